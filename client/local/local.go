@@ -15,12 +15,15 @@ import (
 	"fmt"
 	"io"
 	"iter"
+	"mime/multipart"
 	"net"
 	"net/http"
 	"net/http/httptrace"
 	"net/netip"
+	"net/textproto"
 	"net/url"
 	"os/exec"
+	"path"
 	"runtime"
 	"strconv"
 	"strings"
@@ -775,6 +778,89 @@ func (lc *Client) PushFile(ctx context.Context, target tailcfg.StableNodeID, siz
 	return bestError(fmt.Errorf("%s: %s", res.Status, all), all)
 }
 
+// PushDir sends the directory tree described by manifest to target with
+// Taildrop. The open func is called for each file in manifest.Files, in
+// order, to get its contents; the returned contents must match the size
+// and SHA-256 in the manifest or the receiver rejects the whole directory.
+func (lc *Client) PushDir(ctx context.Context, target tailcfg.StableNodeID, manifest apitype.DirManifest, open func(apitype.DirManifestFile) (io.ReadCloser, error)) error {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		pw.CloseWithError(writeDirMultipart(mw, manifest, open))
+	}()
+	defer pr.Close()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", "http://"+apitype.LocalAPIHost+"/localapi/v0/file-put-dir/"+string(target), pr)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	res, err := lc.doLocalRequestNiceError(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == 200 {
+		io.Copy(io.Discard, res.Body)
+		return nil
+	}
+	all, _ := io.ReadAll(res.Body)
+	return bestError(fmt.Errorf("%s: %s", res.Status, all), all)
+}
+
+// writeDirMultipart writes the body of a PushDir request to mw.
+func writeDirMultipart(mw *multipart.Writer, manifest apitype.DirManifest, open func(apitype.DirManifestFile) (io.ReadCloser, error)) error {
+	mh := make(textproto.MIMEHeader)
+	mh.Set("Content-Type", "application/json")
+	mh.Set("Content-Disposition", `form-data; name="manifest"`)
+	pw, err := mw.CreatePart(mh)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(pw).Encode(manifest); err != nil {
+		return err
+	}
+	for _, f := range manifest.Files {
+		pw, err := mw.CreateFormFile(f.Path, path.Base(f.Path))
+		if err != nil {
+			return err
+		}
+		rc, err := open(f)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(pw, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+// GetWaitingDir returns a tar archive of the received Taildrop directory
+// baseName, which must be a [apitype.WaitingFile] with IsDir set.
+func (lc *Client) GetWaitingDir(ctx context.Context, baseName string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://"+apitype.LocalAPIHost+"/localapi/v0/files/"+url.PathEscape(baseName), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := lc.doLocalRequestNiceError(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != 200 {
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		return nil, fmt.Errorf("HTTP %s: %s", res.Status, body)
+	}
+	if ct := res.Header.Get("Content-Type"); ct != "application/x-tar" {
+		res.Body.Close()
+		return nil, fmt.Errorf("%q is not a directory", baseName)
+	}
+	return res.Body, nil
+}
+
 // CheckIPForwarding asks the local Tailscale daemon whether it looks like the
 // machine is properly configured to forward IP packets as a subnet router
 // or exit node.
@@ -1199,6 +1285,26 @@ func (lc *Client) DebugPacketFilterRules(ctx context.Context) ([]tailcfg.FilterR
 	return decodeJSON[[]tailcfg.FilterRule](body)
 }
 
+// DebugFilterCheck reports what the packet filter does with a new incoming
+// connection from src to dst:port using IP protocol proto (for example,
+// "tcp" or "udp"). If dst is the zero value, the current device's own
+// address is used.
+func (lc *Client) DebugFilterCheck(ctx context.Context, src, dst netip.Addr, port uint16, proto string) (*apitype.FilterCheckResponse, error) {
+	v := url.Values{
+		"src":   {src.String()},
+		"port":  {strconv.FormatUint(uint64(port), 10)},
+		"proto": {proto},
+	}
+	if dst.IsValid() {
+		v.Set("dst", dst.String())
+	}
+	body, err := lc.send(ctx, "POST", "/localapi/v0/debug-filter-check?"+v.Encode(), 200, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error %w: %s", err, body)
+	}
+	return decodeJSON[*apitype.FilterCheckResponse](body)
+}
+
 // DebugSetExpireIn marks the current node key to expire in d.
 //
 // This is meant primarily for debug and testing.
@@ -1238,6 +1344,29 @@ func (lc *Client) StreamDebugCapture(ctx context.Context) (io.ReadCloser, error)
 	return res.Body, nil
 }
 
+// StreamNetLog returns a stream of network flow log records (see
+// netlogtype.Message), one JSON object per line, recorded from the time of
+// the call until ctx is done. Records are dropped if the stream isn't read
+// quickly enough.
+//
+// Network flows are recorded for the duration of the stream, even if network
+// flow logging isn't enabled for the tailnet.
+func (lc *Client) StreamNetLog(ctx context.Context) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://"+apitype.LocalAPIHost+"/localapi/v0/netlog", nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := lc.doLocalRequestNiceError(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != 200 {
+		res.Body.Close()
+		return nil, errors.New(res.Status)
+	}
+	return res.Body, nil
+}
+
 // WatchIPNBus subscribes to the IPN notification bus. It returns a watcher
 // once the bus is connected successfully.
 //
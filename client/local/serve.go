@@ -53,3 +53,13 @@ func (lc *Client) SetServeConfig(ctx context.Context, config *ipn.ServeConfig) e
 	}
 	return nil
 }
+
+// GetServeBackendHealth returns the health of the backends of serve handlers
+// with multiple backends, keyed by the handlers' BackendPoolKey.
+func (lc *Client) GetServeBackendHealth(ctx context.Context) (map[string][]ipn.ServeBackendHealth, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/serve-backend-health")
+	if err != nil {
+		return nil, fmt.Errorf("getting serve backend health: %w", err)
+	}
+	return decodeJSON[map[string][]ipn.ServeBackendHealth](body)
+}
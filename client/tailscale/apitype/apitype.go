@@ -54,6 +54,35 @@ type FileTarget struct {
 type WaitingFile struct {
 	Name string
 	Size int64
+
+	// IsDir is whether this is a directory received with a Taildrop
+	// directory transfer. Size is then the total size of its files.
+	IsDir bool `json:",omitempty"`
+}
+
+// DirManifest describes a directory tree sent with Taildrop.
+// It is sent to the receiver before any file contents,
+// which then verifies every file against it before making the
+// directory visible.
+type DirManifest struct {
+	// Name is the base name of the directory.
+	Name string
+
+	// Files are the regular files in the directory.
+	Files []DirManifestFile
+
+	// Sum is the hex-encoded SHA-256 of the manifest's canonical JSON
+	// encoding with Sum itself empty. It is set by the sending tailscaled.
+	// It's a checksum against corruption, not a signature: anyone able to
+	// modify the manifest can recompute it.
+	Sum string `json:",omitempty"`
+}
+
+// DirManifestFile is a single file in a [DirManifest].
+type DirManifestFile struct {
+	Path   string // slash-separated path relative to the directory root
+	Size   int64  // size in bytes
+	SHA256 string // hex-encoded SHA-256 of the contents
 }
 
 // SetPushDeviceTokenRequest is the body POSTed to the LocalAPI endpoint /set-device-token.
@@ -104,3 +133,33 @@ type OptionalFeatures struct {
 	// are not guaranteed to be present.)
 	Features map[string]bool
 }
+
+// FilterCheckResponse is the response to a LocalAPI debug-filter-check
+// request: what the packet filter does with a new incoming connection.
+type FilterCheckResponse struct {
+	// Response is the filter's verdict: "Accept" or "Drop".
+	Response string
+
+	// Why is the reason for the verdict, as the filter would log it.
+	Why string
+
+	// RuleIndex is the index in the netmap's packet filter rules of the rule
+	// that accepted the connection, or -1 if no rule did.
+	RuleIndex int
+
+	// Rule is the rule at RuleIndex, if any.
+	Rule *tailcfg.FilterRule `json:",omitempty"`
+
+	// MatchedSrc is the source prefix of Rule that contained the
+	// connection's source IP. It's empty if the rule matched by
+	// MatchedSrcCap instead.
+	MatchedSrc string `json:",omitempty"`
+
+	// MatchedSrcCap is the capability of the connection's source node that
+	// Rule matched.
+	MatchedSrcCap tailcfg.NodeCapability `json:",omitempty"`
+
+	// MatchedDst is the destination prefix and port range of Rule that
+	// contained the connection's destination IP and port.
+	MatchedDst string `json:",omitempty"`
+}
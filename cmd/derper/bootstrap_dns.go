@@ -52,24 +52,50 @@ func init() {
 	}))
 }
 
-func refreshBootstrapDNSLoop() {
-	if *bootstrapDNS == "" && *unpublishedDNS == "" {
-		return
+// bootstrapDNSNames are the lists of names served by /bootstrap-dns,
+// in the forms of the --bootstrap-dns-names and
+// --unpublished-bootstrap-dns-names flags.
+type bootstrapDNSNames struct {
+	published   string
+	unpublished string
+}
+
+var (
+	curBootstrapDNSNames    syncs.AtomicValue[bootstrapDNSNames]
+	bootstrapDNSNamesChange = make(chan struct{}, 1)
+)
+
+// setBootstrapDNSNames sets the names served by /bootstrap-dns, and
+// makes refreshBootstrapDNSLoop resolve them promptly.
+func setBootstrapDNSNames(names bootstrapDNSNames) {
+	curBootstrapDNSNames.Store(names)
+	select {
+	case bootstrapDNSNamesChange <- struct{}{}:
+	default:
 	}
+}
+
+func refreshBootstrapDNSLoop() {
 	for {
-		refreshBootstrapDNS()
-		refreshUnpublishedDNS()
-		time.Sleep(10 * time.Minute)
+		names := curBootstrapDNSNames.Load()
+		refreshBootstrapDNS(names.published)
+		refreshUnpublishedDNS(names.unpublished)
+		select {
+		case <-time.After(10 * time.Minute):
+		case <-bootstrapDNSNamesChange:
+		}
 	}
 }
 
-func refreshBootstrapDNS() {
-	if *bootstrapDNS == "" {
+func refreshBootstrapDNS(list string) {
+	if list == "" {
+		dnsCache.Store(nil)
+		dnsCacheBytes.Store(nil)
 		return
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
 	defer cancel()
-	dnsEntries := resolveList(ctx, *bootstrapDNS)
+	dnsEntries := resolveList(ctx, list)
 	// Randomize the order of the IPs for each name to avoid the client biasing
 	// to IPv6
 	for _, vv := range dnsEntries.IPs {
@@ -85,13 +111,14 @@ func refreshBootstrapDNS() {
 	dnsCacheBytes.Store(j)
 }
 
-func refreshUnpublishedDNS() {
-	if *unpublishedDNS == "" {
+func refreshUnpublishedDNS(list string) {
+	if list == "" {
+		unpublishedDNSCache.Store(nil)
 		return
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
 	defer cancel()
-	dnsEntries := resolveList(ctx, *unpublishedDNS)
+	dnsEntries := resolveList(ctx, list)
 	unpublishedDNSCache.Store(dnsEntries)
 }
 
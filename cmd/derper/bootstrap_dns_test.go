@@ -15,13 +15,11 @@ import (
 	"reflect"
 	"testing"
 
-	"tailscale.com/tstest"
 	"tailscale.com/tstest/nettest"
 )
 
 func BenchmarkHandleBootstrapDNS(b *testing.B) {
-	tstest.Replace(b, bootstrapDNS, "log.tailscale.com,login.tailscale.com,controlplane.tailscale.com,login.us.tailscale.com")
-	refreshBootstrapDNS()
+	refreshBootstrapDNS("log.tailscale.com,login.tailscale.com,controlplane.tailscale.com,login.us.tailscale.com")
 	w := new(bitbucketResponseWriter)
 	req, _ := http.NewRequest("GET", "https://localhost/bootstrap-dns?q="+url.QueryEscape("log.tailscale.com"), nil)
 	b.ReportAllocs()
@@ -65,17 +63,13 @@ func TestUnpublishedDNS(t *testing.T) {
 	const published = "login.tailscale.com"
 	const unpublished = "log.tailscale.com"
 
-	prev1, prev2 := *bootstrapDNS, *unpublishedDNS
-	*bootstrapDNS = published
-	*unpublishedDNS = unpublished
+	refreshBootstrapDNS(published)
+	refreshUnpublishedDNS(unpublished)
 	t.Cleanup(func() {
-		*bootstrapDNS = prev1
-		*unpublishedDNS = prev2
+		refreshBootstrapDNS("")
+		refreshUnpublishedDNS("")
 	})
 
-	refreshBootstrapDNS()
-	refreshUnpublishedDNS()
-
 	hasResponse := func(q string) bool {
 		_, found := getBootstrapDNS(t, q)[q]
 		return found
@@ -27,7 +27,6 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
-	"path"
 	"path/filepath"
 	"regexp"
 	"runtime"
@@ -37,13 +36,13 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/tailscale/setec/client/setec"
 	"golang.org/x/time/rate"
 	"tailscale.com/atomicfile"
 	"tailscale.com/derp/derpserver"
 	"tailscale.com/metrics"
 	"tailscale.com/net/ktimeout"
 	"tailscale.com/net/stunserver"
+	"tailscale.com/tstime"
 	"tailscale.com/tsweb"
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
@@ -72,6 +71,10 @@ var (
 
 	meshPSKFile     = flag.String("mesh-psk-file", defaultMeshPSKFile(), "if non-empty, path to file containing the mesh pre-shared key file. It must be 64 lowercase hexadecimal characters; whitespace is trimmed.")
 	meshWith        = flag.String("mesh-with", "", "optional comma-separated list of hostnames to mesh with; the server's own hostname can be in the list. If an entry contains a slash, the second part names a hostname to be used when dialing the target.")
+	meshSRV         = flag.String("mesh-srv", "", "optional DNS SRV record name (e.g. _derp-mesh._tcp.example.com) whose targets to mesh with, in addition to --mesh-with; targets not on port 443 are dialed on their port")
+	meshTXT         = flag.String("mesh-txt", "", "optional DNS TXT record name whose values are comma-separated lists of hostnames to mesh with, in the same form as --mesh-with")
+	meshFile        = flag.String("mesh-file", "", "optional path to a file of hostnames to mesh with, in the same form as --mesh-with, separated by commas or newlines; lines starting with # are ignored")
+	meshDiscovery   = flag.Duration("mesh-discovery-interval", 30*time.Second, "how often to re-resolve --mesh-srv and --mesh-txt and re-read --mesh-file, adding and removing mesh peers as they change")
 	secretsURL      = flag.String("secrets-url", "", "SETEC server URL for secrets retrieval of mesh key")
 	secretPrefix    = flag.String("secrets-path-prefix", "prod/derp", "setec path prefix for \""+setecMeshKeyName+"\" secret for DERP mesh key")
 	secretsCacheDir = flag.String("secrets-cache-dir", defaultSetecCacheDir(), "directory to cache setec secrets in (required if --secrets-url is set)")
@@ -81,6 +84,12 @@ var (
 	verifyClients   = flag.Bool("verify-clients", false, "verify clients to this DERP server through a local tailscaled instance.")
 	verifyClientURL = flag.String("verify-client-url", "", "if non-empty, an admission controller URL for permitting client connections; see tailcfg.DERPAdmitClientRequest")
 	verifyFailOpen  = flag.Bool("verify-client-url-fail-open", true, "whether we fail open if --verify-client-url is unreachable")
+	verifyURLTTL    = flag.Duration("verify-client-url-cache-ttl", 0, "if non-zero, how long to remember --verify-client-url's decision for a client's key and IP, so reconnecting clients don't need another check")
+
+	admitKeysFile = flag.String("admit-keys-file", "", "if non-empty, path to a file of node public keys (\"nodekey:...\"), one per line, that are the only clients allowed to connect; lines starting with # are ignored")
+	admitAllowIPs = flag.String("admit-allow-ips", "", "if non-empty, comma-separated list of IP prefixes that clients must connect from")
+	admitDenyIPs  = flag.String("admit-deny-ips", "", "optional comma-separated list of IP prefixes that clients may not connect from")
+	maxConnsPerIP = flag.Int("max-conns-per-ip", 0, "if non-zero, the maximum number of client connections from each IP address")
 
 	socket = flag.String("socket", "", "optional alternate path to tailscaled socket (only relevant when using --verify-clients)")
 
@@ -94,6 +103,14 @@ var (
 	// tcpWriteTimeout is the timeout for writing to client TCP connections. It does not apply to mesh connections.
 	tcpWriteTimeout = flag.Duration("tcp-write-timeout", derpserver.DefaultTCPWiteTimeout, "TCP write timeout; 0 results in no timeout being set on writes")
 
+	// Per-client rate limits. If unset, the config file's ClientRateLimits are used.
+	clientRateBytes        = flag.Float64("client-rate-limit-bytes", 0, "per-client limit on packet bytes per second sent through this server; 0 means unlimited")
+	clientRateBytesBurst   = flag.Int("client-rate-limit-bytes-burst", 0, "per-client burst of packet bytes above --client-rate-limit-bytes; 0 means one second's worth, but at least the maximum packet size")
+	clientRatePackets      = flag.Float64("client-rate-limit-packets", 0, "per-client limit on packets per second sent through this server; 0 means unlimited")
+	clientRatePacketsBurst = flag.Int("client-rate-limit-packets-burst", 0, "per-client burst of packets above --client-rate-limit-packets; 0 means one second's worth")
+	clientRatePings        = flag.Float64("client-rate-limit-pings", 0, "per-client limit on pings per second answered; 0 means unlimited")
+	clientRatePingsBurst   = flag.Int("client-rate-limit-pings-burst", 0, "per-client burst of pings above --client-rate-limit-pings; 0 means one second's worth")
+
 	// ACE
 	flagACEEnabled = flag.Bool("ace", false, "whether to enable embedded ACE server [experimental + in-development as of 2025-09-12; not yet documented]")
 )
@@ -106,8 +123,58 @@ var (
 const setecMeshKeyName = "meshkey"
 const meshKeyEnvVar = "TAILSCALE_DERPER_MESH_KEY"
 
+// config is the derper config file.
+//
+// Everything but PrivateKey can be changed without a restart, by editing the
+// file and sending derper a SIGHUP or POSTing to /debug/reload.
 type config struct {
 	PrivateKey key.NodePrivate
+
+	// ClientRateLimits, if non-nil, are the per-client rate limits.
+	// The --client-rate-limit-* flags take precedence.
+	ClientRateLimits *derpserver.ClientRateLimits `json:",omitempty"`
+
+	// The following, if non-nil, are used instead of the defaults of the
+	// flags of the same names. Flags set on the command line take
+	// precedence.
+	MeshPSKFile                  *string `json:",omitempty"` // --mesh-psk-file
+	VerifyClients                *bool   `json:",omitempty"` // --verify-clients
+	VerifyClientURL              *string `json:",omitempty"` // --verify-client-url
+	VerifyClientURLFailOpen      *bool   `json:",omitempty"` // --verify-client-url-fail-open
+	AdmitKeysFile                *string `json:",omitempty"` // --admit-keys-file
+	AdmitAllowIPs                *string `json:",omitempty"` // --admit-allow-ips
+	AdmitDenyIPs                 *string `json:",omitempty"` // --admit-deny-ips
+	MaxConnsPerIP                *int    `json:",omitempty"` // --max-conns-per-ip
+	BootstrapDNSNames            *string `json:",omitempty"` // --bootstrap-dns-names
+	UnpublishedBootstrapDNSNames *string `json:",omitempty"` // --unpublished-bootstrap-dns-names
+
+	VerifyClientURLCacheTTL *tstime.GoDuration `json:",omitempty"` // --verify-client-url-cache-ttl
+}
+
+// clientRateLimits returns the per-client rate limits from cfg,
+// overridden by any --client-rate-limit-* flags that were set.
+func clientRateLimits(cfg config) derpserver.ClientRateLimits {
+	var l derpserver.ClientRateLimits
+	if cfg.ClientRateLimits != nil {
+		l = *cfg.ClientRateLimits
+	}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "client-rate-limit-bytes":
+			l.BytesPerSecond = *clientRateBytes
+		case "client-rate-limit-bytes-burst":
+			l.BytesBurst = *clientRateBytesBurst
+		case "client-rate-limit-packets":
+			l.PacketsPerSecond = *clientRatePackets
+		case "client-rate-limit-packets-burst":
+			l.PacketsBurst = *clientRatePacketsBurst
+		case "client-rate-limit-pings":
+			l.PingsPerSecond = *clientRatePings
+		case "client-rate-limit-pings-burst":
+			l.PingsBurst = *clientRatePingsBurst
+		}
+	})
+	return l
 }
 
 func loadConfig() config {
@@ -122,20 +189,30 @@ func loadConfig() config {
 		}
 		log.Printf("no config path specified; using %s", *configPath)
 	}
-	b, err := os.ReadFile(*configPath)
+	cfg, err := readConfig(*configPath)
 	switch {
 	case errors.Is(err, os.ErrNotExist):
 		return writeNewConfig()
 	case err != nil:
-		log.Fatal(err)
-		panic("unreachable")
-	default:
-		var cfg config
-		if err := json.Unmarshal(b, &cfg); err != nil {
-			log.Fatalf("derper: config: %v", err)
-		}
-		return cfg
+		log.Fatalf("derper: %v", err)
+	}
+	return cfg
+}
+
+// readConfig reads the config file at path.
+func readConfig(path string) (config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return config{}, err
+	}
+	var cfg config
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return config{}, fmt.Errorf("config: %w", err)
+	}
+	if cfg.PrivateKey.IsZero() {
+		return config{}, errors.New("config: missing PrivateKey")
 	}
+	return cfg, nil
 }
 
 func writeNewConfig() config {
@@ -165,6 +242,8 @@ func main() {
 
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
 
 	if *dev {
 		*addr = ":3340" // above the keys DERP
@@ -187,63 +266,33 @@ func main() {
 	serveTLS := tsweb.IsProd443(*addr) || *certMode == "manual"
 
 	s := derpserver.New(cfg.PrivateKey, log.Printf)
-	s.SetVerifyClient(*verifyClients)
 	s.SetTailscaledSocketPath(*socket)
-	s.SetVerifyClientURL(*verifyClientURL)
-	s.SetVerifyClientURLFailOpen(*verifyFailOpen)
 	s.SetTCPWriteTimeout(*tcpWriteTimeout)
 
-	var meshKey string
-	if *dev {
-		meshKey = os.Getenv(meshKeyEnvVar)
-		if meshKey == "" {
-			log.Printf("No mesh key specified for dev via %s\n", meshKeyEnvVar)
-		} else {
-			log.Printf("Set mesh key from %s\n", meshKeyEnvVar)
-		}
-	} else if *secretsURL != "" {
-		meshKeySecret := path.Join(*secretPrefix, setecMeshKeyName)
-		fc, err := setec.NewFileCache(*secretsCacheDir)
-		if err != nil {
-			log.Fatalf("NewFileCache: %v", err)
-		}
-		log.Printf("Setting up setec store from %q", *secretsURL)
-		st, err := setec.NewStore(ctx,
-			setec.StoreConfig{
-				Client: setec.Client{Server: *secretsURL},
-				Secrets: []string{
-					meshKeySecret,
-				},
-				Cache: fc,
-			})
-		if err != nil {
-			log.Fatalf("NewStore: %v", err)
-		}
-		meshKey = st.Secret(meshKeySecret).GetString()
-		log.Println("Got mesh key from setec store")
-		st.Close()
-	} else if *meshPSKFile != "" {
-		b, err := setec.StaticFile(*meshPSKFile)
-		if err != nil {
-			log.Fatalf("StaticFile failed to get key: %v", err)
-		}
-		log.Println("Got mesh key from static file")
-		meshKey = b.GetString()
+	st, err := loadSettings(ctx, cfg)
+	if err != nil {
+		log.Fatalf("derper: %v", err)
 	}
-
-	if meshKey == "" && *dev {
-		log.Printf("No mesh key configured for --dev mode")
-	} else if meshKey == "" {
-		log.Printf("No mesh key configured")
-	} else if err := s.SetMeshKey(meshKey); err != nil {
-		log.Fatalf("invalid mesh key: %v", err)
-	} else {
-		log.Println("DERP mesh key configured")
+	logStartupSettings(st)
+	reloader := &reloader{
+		s:          s,
+		logf:       log.Printf,
+		privateKey: cfg.PrivateKey,
+		readConfig: func() (config, error) {
+			if *dev {
+				return config{PrivateKey: cfg.PrivateKey}, nil
+			}
+			return readConfig(*configPath)
+		},
 	}
-
-	if err := startMesh(s); err != nil {
+	reloader.mu.Lock()
+	reloader.applyLocked(ctx, st)
+	mesh, err := startMesh(ctx, s)
+	if err != nil {
 		log.Fatalf("startMesh: %v", err)
 	}
+	reloader.mesh = mesh
+	reloader.mu.Unlock()
 	expvar.Publish("derp", s.ExpVar())
 
 	handleHome, ok := getHomeHandler(*flagHome)
@@ -280,7 +329,7 @@ func main() {
 	mux.Handle("/generate_204", http.HandlerFunc(derpserver.ServeNoContent))
 	debug := tsweb.Debugger(mux)
 	debug.KV("TLS hostname", *hostname)
-	debug.KV("Mesh key", s.HasMeshKey())
+	debug.KVFunc("Mesh key", func() any { return s.HasMeshKey() })
 	debug.Handle("check", "Consistency check", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		err := s.ConsistencyCheck()
 		if err != nil {
@@ -290,6 +339,8 @@ func main() {
 		}
 	}))
 	debug.Handle("traffic", "Traffic check", http.HandlerFunc(s.ServeDebugTraffic))
+	debug.Handle("mesh", "Mesh peers", mesh)
+	debug.Handle("reload", "Config reload", reloader)
 	debug.Handle("set-mutex-profile-fraction", "SetMutexProfileFraction", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		s := r.FormValue("rate")
 		if s == "" || r.Header.Get("Sec-Debug") != "derp" {
@@ -340,11 +391,14 @@ func main() {
 
 	if serveTLS {
 		log.Printf("derper: serving on %s with TLS", *addr)
-		var certManager certProvider
-		certManager, err = certProviderByCertMode(*certMode, *certDir, *hostname, *acmeEABKid, *acmeEABKey, *acmeEmail)
+		var p certProvider
+		p, err = certProviderByCertMode(*certMode, *certDir, *hostname, *acmeEABKid, *acmeEABKey, *acmeEmail)
 		if err != nil {
 			log.Fatalf("derper: can not start cert provider: %v", err)
 		}
+		certManager := newReloadableCertProvider(p)
+		reloader.certs = certManager
+		go reloader.reloadOnSIGHUP(ctx, sighup)
 		httpsrv.TLSConfig = certManager.TLSConfig()
 		getCert := httpsrv.TLSConfig.GetCertificate
 		httpsrv.TLSConfig.GetCertificate = func(hi *tls.ClientHelloInfo) (*tls.Certificate, error) {
@@ -414,6 +468,7 @@ func main() {
 		err = rateLimitedListenAndServeTLS(httpsrv, &lc)
 	} else {
 		log.Printf("derper: serving on %s", *addr)
+		go reloader.reloadOnSIGHUP(ctx, sighup)
 		var ln net.Listener
 		ln, err = lc.Listen(context.Background(), "tcp", httpsrv.Addr)
 		if err != nil {
@@ -4,60 +4,379 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"cmp"
 	"context"
-	"errors"
 	"fmt"
+	"html"
+	"io"
 	"log"
+	"maps"
 	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"tailscale.com/derp"
 	"tailscale.com/derp/derphttp"
 	"tailscale.com/derp/derpserver"
 	"tailscale.com/net/netmon"
+	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
+	"tailscale.com/util/set"
 )
 
-func startMesh(s *derpserver.Server) error {
-	if *meshWith == "" {
-		return nil
+// startMesh starts meshing with the peers in --mesh-with and, if any
+// discovery flags are set, with the peers discovered from them for as long as
+// ctx is alive. It returns a nil meshManager if there's nothing to mesh with.
+//
+// If s has no mesh key yet, the meshManager is returned without being
+// started, and the reloader starts it once a mesh key is configured.
+func startMesh(ctx context.Context, s *derpserver.Server) (*meshManager, error) {
+	if *meshWith == "" && *meshSRV == "" && *meshTXT == "" && *meshFile == "" {
+		return nil, nil
 	}
+	static, err := parseMeshHosts(*meshWith)
+	if err != nil {
+		return nil, err
+	}
+	m := newMeshManager(s, log.Printf)
+	m.ctx = ctx
+	m.static = static
+	m.srvName, m.txtName, m.filePath = *meshSRV, *meshTXT, *meshFile
 	if !s.HasMeshKey() {
-		return errors.New("--mesh-with requires --mesh-psk-file")
+		log.Printf("mesh: no mesh key configured; not meshing until one is")
+		return m, nil
 	}
-	for hostTuple := range strings.SplitSeq(*meshWith, ",") {
-		if err := startMeshWithHost(s, hostTuple); err != nil {
-			return err
+	m.start()
+	return m, nil
+}
+
+// start starts meshing with m's peers and, if m has any discovery sources,
+// keeps updating them until m.ctx is done.
+func (m *meshManager) start() {
+	m.update(m.ctx)
+	if m.discovering() {
+		go m.discoveryLoop(m.ctx, *meshDiscovery)
+	}
+}
+
+// meshHost is a mesh peer as given in --mesh-with: a hostname, and
+// optionally a different hostname to dial.
+type meshHost struct {
+	host     string
+	dialHost string // or empty to dial host
+}
+
+func (h meshHost) String() string {
+	if h.dialHost == "" {
+		return h.host
+	}
+	return h.host + "/" + h.dialHost
+}
+
+// parseMeshHosts parses a comma-separated list of hosts in --mesh-with form.
+// Each entry is a hostname, optionally followed by a slash and a hostname to
+// dial instead.
+func parseMeshHosts(list string) ([]meshHost, error) {
+	var hosts []meshHost
+	for hostTuple := range strings.SplitSeq(list, ",") {
+		hostTuple = strings.TrimSpace(hostTuple)
+		if hostTuple == "" {
+			continue
+		}
+		hostParts := strings.Split(hostTuple, "/")
+		if len(hostParts) > 2 {
+			return nil, fmt.Errorf("too many components in host tuple %q", hostTuple)
 		}
+		h := meshHost{host: hostParts[0]}
+		if len(hostParts) == 2 && hostParts[1] != hostParts[0] {
+			h.dialHost = hostParts[1]
+		}
+		hosts = append(hosts, h)
 	}
-	return nil
+	return hosts, nil
 }
 
-func startMeshWithHost(s *derpserver.Server, hostTuple string) error {
-	var host string
-	var dialHost string
-	hostParts := strings.Split(hostTuple, "/")
-	if len(hostParts) > 2 {
-		return fmt.Errorf("too many components in host tuple %q", hostTuple)
+// parseMeshFile parses the contents of a --mesh-file: hosts in --mesh-with
+// form, separated by commas or newlines. Blank lines and lines starting with
+// '#' are ignored.
+func parseMeshFile(b []byte) ([]meshHost, error) {
+	var hosts []meshHost
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		hs, err := parseMeshHosts(line)
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, hs...)
 	}
-	host = hostParts[0]
-	if len(hostParts) == 2 {
-		dialHost = hostParts[1]
-	} else {
-		dialHost = hostParts[0]
+	return hosts, sc.Err()
+}
+
+// meshHostsFromSRV returns the mesh hosts for the targets of SRV records.
+// Targets on port 443 (or port 0) are used as-is; others include their port.
+func meshHostsFromSRV(srvs []*net.SRV) []meshHost {
+	var hosts []meshHost
+	for _, srv := range srvs {
+		host := strings.TrimSuffix(srv.Target, ".")
+		if host == "" {
+			continue
+		}
+		if srv.Port != 0 && srv.Port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(int(srv.Port)))
+		}
+		hosts = append(hosts, meshHost{host: host})
 	}
+	return hosts
+}
+
+// meshManager maintains the server's set of mesh peers, starting and
+// stopping a mesh client for each as the set changes.
+type meshManager struct {
+	s    *derpserver.Server
+	logf logger.Logf
+
+	// ctx is the context that meshing runs under until it's done.
+	ctx context.Context
+
+	// static are the peers from --mesh-with. The others are the
+	// discovery sources, from --mesh-srv, --mesh-txt and --mesh-file;
+	// empty if unused.
+	static   []meshHost
+	srvName  string
+	txtName  string
+	filePath string
+
+	// lookupSRV, lookupTXT and readFile are the discovery sources,
+	// for tests.
+	lookupSRV func(ctx context.Context, name string) ([]*net.SRV, error)
+	lookupTXT func(ctx context.Context, name string) ([]string, error)
+	readFile  func(name string) ([]byte, error)
+
+	// startPeer starts meshing with h, for tests.
+	startPeer func(h meshHost) (*meshPeer, error)
 
-	logf := logger.WithPrefix(log.Printf, fmt.Sprintf("mesh(%q): ", host))
+	updateMu sync.Mutex // serializes calls to update
+
+	mu          sync.Mutex
+	peers       map[meshHost]*meshPeer
+	lastUpdate  time.Time
+	lastErrs    map[string]error      // by discovery source; only sources with errors
+	lastSources map[meshHost][]string // the sources of each peer
+}
+
+func newMeshManager(s *derpserver.Server, logf logger.Logf) *meshManager {
+	m := &meshManager{
+		s:    s,
+		logf: logf,
+		lookupSRV: func(ctx context.Context, name string) ([]*net.SRV, error) {
+			var r net.Resolver
+			_, srvs, err := r.LookupSRV(ctx, "", "", name)
+			return srvs, err
+		},
+		lookupTXT: func(ctx context.Context, name string) ([]string, error) {
+			var r net.Resolver
+			return r.LookupTXT(ctx, name)
+		},
+		readFile: os.ReadFile,
+		peers:    make(map[meshHost]*meshPeer),
+	}
+	m.startPeer = m.startMeshPeer
+	return m
+}
+
+// discoveryLoop updates the mesh peers every interval until ctx is done,
+// and then stops meshing.
+func (m *meshManager) discoveryLoop(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			m.stopAll()
+			return
+		case <-t.C:
+			m.update(ctx)
+		}
+	}
+}
+
+// discovering reports whether m has any discovery sources.
+func (m *meshManager) discovering() bool {
+	return m.srvName != "" || m.txtName != "" || m.filePath != ""
+}
+
+// discover returns the desired set of mesh peers, the sources that each
+// came from, and the errors from each discovery source that failed. If a
+// source fails, the peers it returned last time (according to lastSources)
+// are kept, so that a transient DNS or file error doesn't tear down the
+// mesh.
+func (m *meshManager) discover(ctx context.Context, lastSources map[meshHost][]string) (want map[meshHost][]string, errs map[string]error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	want = make(map[meshHost][]string)
+	for _, h := range m.static {
+		want[h] = append(want[h], "mesh-with")
+	}
+	errs = make(map[string]error)
+	addFrom := func(source string, hosts []meshHost, err error) {
+		if err != nil {
+			errs[source] = err
+			// Keep the previous peers from this source.
+			for h, sources := range lastSources {
+				if slices.Contains(sources, source) {
+					want[h] = append(want[h], source)
+				}
+			}
+			return
+		}
+		for _, h := range hosts {
+			if !slices.Contains(want[h], source) {
+				want[h] = append(want[h], source)
+			}
+		}
+	}
+	if m.srvName != "" {
+		srvs, err := m.lookupSRV(ctx, m.srvName)
+		addFrom("srv", meshHostsFromSRV(srvs), err)
+	}
+	if m.txtName != "" {
+		var hosts []meshHost
+		vals, err := m.lookupTXT(ctx, m.txtName)
+		for _, v := range vals {
+			hs, perr := parseMeshHosts(v)
+			if perr != nil {
+				err = cmp.Or(err, perr)
+				continue
+			}
+			hosts = append(hosts, hs...)
+		}
+		addFrom("txt", hosts, err)
+	}
+	if m.filePath != "" {
+		b, err := m.readFile(m.filePath)
+		var hosts []meshHost
+		if err == nil {
+			hosts, err = parseMeshFile(b)
+		}
+		addFrom("file", hosts, err)
+	}
+	return want, errs
+}
+
+// update discovers the current set of mesh peers, starting and stopping
+// mesh clients as needed.
+func (m *meshManager) update(ctx context.Context) {
+	m.updateMu.Lock()
+	defer m.updateMu.Unlock()
+
+	m.mu.Lock()
+	lastSources, lastErrs := m.lastSources, m.lastErrs
+	m.mu.Unlock()
+
+	want, errs := m.discover(ctx, lastSources)
+	for source, err := range errs {
+		if lastErrs[source] == nil || lastErrs[source].Error() != err.Error() {
+			m.logf("mesh: %s discovery: %v", source, err)
+		}
+	}
+
+	var removed []*meshPeer
+	defer func() {
+		for _, p := range removed {
+			p.stop()
+		}
+	}()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastSources = want
+	m.lastErrs = errs
+	m.lastUpdate = time.Now()
+
+	for h, p := range m.peers {
+		if _, ok := want[h]; !ok {
+			m.logf("mesh: removing peer %v", h)
+			delete(m.peers, h)
+			removed = append(removed, p)
+		}
+	}
+	for h := range want {
+		if _, ok := m.peers[h]; ok {
+			continue
+		}
+		p, err := m.startPeer(h)
+		if err != nil {
+			m.logf("mesh: adding peer %v: %v", h, err)
+			continue
+		}
+		if m.discovering() {
+			m.logf("mesh: added peer %v", h)
+		}
+		m.peers[h] = p
+	}
+}
+
+// stopAll stops meshing with all peers.
+func (m *meshManager) stopAll() {
+	m.updateMu.Lock()
+	defer m.updateMu.Unlock()
+	m.mu.Lock()
+	peers := m.peers
+	m.peers = make(map[meshHost]*meshPeer)
+	m.mu.Unlock()
+	for _, p := range peers {
+		p.stop()
+	}
+}
+
+// meshPeer is a mesh client connection to a peer in the region.
+type meshPeer struct {
+	host  meshHost
+	added time.Time
+	c     *derphttp.Client // or nil in tests
+
+	cancel context.CancelFunc
+	done   chan struct{} // closed when the watch loop exits
+
+	mu      sync.Mutex
+	present set.Set[key.NodePublic] // clients connected to the peer
+	lastErr error                   // most recent connection error, or nil
+}
+
+// stop stops the mesh client, which removes its packet forwarders from
+// the server.
+func (p *meshPeer) stop() {
+	p.cancel()
+	if p.c != nil {
+		p.c.Close()
+	}
+	<-p.done
+}
+
+func (m *meshManager) startMeshPeer(h meshHost) (*meshPeer, error) {
+	logf := logger.WithPrefix(m.logf, fmt.Sprintf("mesh(%q): ", h.host))
 	netMon := netmon.NewStatic() // good enough for cmd/derper; no need for netns fanciness
-	c, err := derphttp.NewClient(s.PrivateKey(), "https://"+host+"/derp", logf, netMon)
+	c, err := derphttp.NewClient(m.s.PrivateKey(), "https://"+h.host+"/derp", logf, netMon)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	c.MeshKey = s.MeshKey()
+	c.MeshKey = m.s.MeshKey()
 	c.WatchConnectionChanges = true
 
-	logf("will dial %q for %q", dialHost, host)
-	if dialHost != host {
+	if h.dialHost != "" {
+		logf("will dial %q for %q", h.dialHost, h.host)
 		var d net.Dialer
 		c.SetURLDialer(func(ctx context.Context, network, addr string) (net.Conn, error) {
 			_, port, err := net.SplitHostPort(addr)
@@ -65,15 +384,119 @@ func startMeshWithHost(s *derpserver.Server, hostTuple string) error {
 				logf("failed to split %q: %v", addr, err)
 				return nil, err
 			}
-			dialAddr := net.JoinHostPort(dialHost, port)
+			dialAddr := net.JoinHostPort(h.dialHost, port)
 			logf("dialing %q instead of %q", dialAddr, addr)
 			return d.DialContext(ctx, network, dialAddr)
 		})
 	}
 
-	add := func(m derp.PeerPresentMessage) { s.AddPacketForwarder(m.Key, c) }
-	remove := func(m derp.PeerGoneMessage) { s.RemovePacketForwarder(m.Peer, c) }
-	notifyError := func(err error) {}
-	go c.RunWatchConnectionLoop(context.Background(), s.PublicKey(), logf, add, remove, notifyError)
-	return nil
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &meshPeer{
+		host:    h,
+		added:   time.Now(),
+		c:       c,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		present: make(set.Set[key.NodePublic]),
+	}
+	add := func(msg derp.PeerPresentMessage) {
+		m.s.AddPacketForwarder(msg.Key, c)
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.present.Add(msg.Key)
+		p.lastErr = nil
+	}
+	remove := func(msg derp.PeerGoneMessage) {
+		m.s.RemovePacketForwarder(msg.Peer, c)
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.present.Delete(msg.Peer)
+	}
+	notifyError := func(err error) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.lastErr = err
+	}
+	go func() {
+		defer close(p.done)
+		c.RunWatchConnectionLoop(ctx, m.s.PublicKey(), logf, add, remove, notifyError)
+
+		// The loop removes the forwarders it added when its connection
+		// breaks, but not if it exits for a self-connect, or before
+		// noticing a break. Remove any that are left.
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		for k := range p.present {
+			m.s.RemovePacketForwarder(k, c)
+		}
+		clear(p.present)
+	}()
+	return p, nil
+}
+
+// ServeHTTP serves the mesh state for the debug page.
+func (m *meshManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if m == nil {
+		io.WriteString(w, "<p>No mesh peers configured.</p>\n")
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var sources []string
+	if len(m.static) > 0 {
+		var hosts []string
+		for _, h := range m.static {
+			hosts = append(hosts, h.String())
+		}
+		sources = append(sources, "--mesh-with="+strings.Join(hosts, ","))
+	}
+	for _, f := range []struct{ name, val string }{
+		{"mesh-srv", m.srvName},
+		{"mesh-txt", m.txtName},
+		{"mesh-file", m.filePath},
+	} {
+		if f.val != "" {
+			sources = append(sources, fmt.Sprintf("--%s=%s", f.name, f.val))
+		}
+	}
+	fmt.Fprintf(w, "<p>Sources: %s</p>\n", html.EscapeString(strings.Join(sources, ", ")))
+	if m.discovering() {
+		fmt.Fprintf(w, "<p>Last discovery: %v</p>\n", m.lastUpdate.Format(time.RFC3339))
+		for _, source := range slices.Sorted(maps.Keys(m.lastErrs)) {
+			fmt.Fprintf(w, "<p>Discovery error from %s: %s</p>\n", source, html.EscapeString(m.lastErrs[source].Error()))
+		}
+	}
+
+	hosts := make([]meshHost, 0, len(m.peers))
+	for h := range m.peers {
+		hosts = append(hosts, h)
+	}
+	slices.SortFunc(hosts, func(a, b meshHost) int { return strings.Compare(a.String(), b.String()) })
+	io.WriteString(w, "<table border=1 cellpadding=5><tr><th>Peer</th><th>Sources</th><th>Added</th><th>Server key</th><th>Clients</th><th>Last error</th></tr>\n")
+	for _, h := range hosts {
+		p := m.peers[h]
+		var serverKey string
+		if p.c != nil {
+			if k := p.c.ServerPublicKey(); !k.IsZero() {
+				serverKey = k.ShortString()
+			}
+		}
+		p.mu.Lock()
+		n, lastErr := len(p.present), p.lastErr
+		p.mu.Unlock()
+		var errStr string
+		if lastErr != nil {
+			errStr = lastErr.Error()
+		}
+		fmt.Fprintf(w, "<tr><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td>%d</td><td>%s</td></tr>\n",
+			html.EscapeString(h.String()),
+			html.EscapeString(strings.Join(m.lastSources[h], ", ")),
+			p.added.Format(time.RFC3339),
+			html.EscapeString(serverKey),
+			n,
+			html.EscapeString(errStr))
+	}
+	io.WriteString(w, "</table>\n")
 }
@@ -0,0 +1,163 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"tailscale.com/derp/derpserver"
+	"tailscale.com/types/key"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/set"
+)
+
+func TestParseMeshHosts(t *testing.T) {
+	got, err := parseMeshHosts("a.example.com, b.example.com/10.0.0.2,c.example.com/c.example.com,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []meshHost{
+		{host: "a.example.com"},
+		{host: "b.example.com", dialHost: "10.0.0.2"},
+		{host: "c.example.com"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+	if _, err := parseMeshHosts("a/b/c"); err == nil {
+		t.Error("unexpected success parsing a/b/c")
+	}
+
+	got, err = parseMeshFile([]byte("# region peers\na.example.com\n\n  b.example.com/10.0.0.2, c.example.com\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []meshHost{
+		{host: "a.example.com"},
+		{host: "b.example.com", dialHost: "10.0.0.2"},
+		{host: "c.example.com"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("file: got %v; want %v", got, want)
+	}
+
+	got = meshHostsFromSRV([]*net.SRV{
+		{Target: "a.example.com.", Port: 443},
+		{Target: "b.example.com.", Port: 8443},
+		{Target: "."},
+	})
+	want = []meshHost{
+		{host: "a.example.com"},
+		{host: "b.example.com:8443"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SRV: got %v; want %v", got, want)
+	}
+}
+
+func TestMeshManagerUpdate(t *testing.T) {
+	s := derpserver.New(key.NewNode(), logger.Discard)
+	defer s.Close()
+
+	m := newMeshManager(s, t.Logf)
+	m.static = []meshHost{{host: "static.example.com"}}
+	m.srvName = "_derp-mesh._tcp.example.com"
+	m.filePath = "/meshpeers"
+
+	var (
+		srvs    []*net.SRV
+		srvErr  error
+		file    string
+		started []string
+		stopped []string
+	)
+	m.lookupSRV = func(ctx context.Context, name string) ([]*net.SRV, error) {
+		return srvs, srvErr
+	}
+	m.readFile = func(name string) ([]byte, error) {
+		return []byte(file), nil
+	}
+	m.startPeer = func(h meshHost) (*meshPeer, error) {
+		started = append(started, h.String())
+		done := make(chan struct{})
+		close(done)
+		return &meshPeer{
+			host:    h,
+			cancel:  func() { stopped = append(stopped, h.String()) },
+			done:    done,
+			present: make(set.Set[key.NodePublic]),
+		}, nil
+	}
+	peers := func() []string {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		var ret []string
+		for h := range m.peers {
+			ret = append(ret, h.String())
+		}
+		slices.Sort(ret)
+		return ret
+	}
+	check := func(name string, wantPeers, wantStarted, wantStopped []string) {
+		t.Helper()
+		m.update(context.Background())
+		if got := peers(); !slices.Equal(got, wantPeers) {
+			t.Errorf("%s: peers = %q; want %q", name, got, wantPeers)
+		}
+		slices.Sort(started)
+		if !slices.Equal(started, wantStarted) {
+			t.Errorf("%s: started = %q; want %q", name, started, wantStarted)
+		}
+		started = nil
+		slices.Sort(stopped)
+		if !slices.Equal(stopped, wantStopped) {
+			t.Errorf("%s: stopped = %q; want %q", name, stopped, wantStopped)
+		}
+		stopped = nil
+	}
+
+	srvs = []*net.SRV{{Target: "a.example.com.", Port: 443}}
+	file = "b.example.com\n"
+	check("initial",
+		[]string{"a.example.com", "b.example.com", "static.example.com"},
+		[]string{"a.example.com", "b.example.com", "static.example.com"},
+		nil)
+
+	srvs = []*net.SRV{{Target: "a.example.com.", Port: 443}, {Target: "c.example.com.", Port: 443}}
+	file = "# empty\n"
+	check("changed",
+		[]string{"a.example.com", "c.example.com", "static.example.com"},
+		[]string{"c.example.com"},
+		[]string{"b.example.com"})
+
+	// A failed lookup keeps the peers from that source.
+	srvs, srvErr = nil, errors.New("SERVFAIL")
+	check("lookup error",
+		[]string{"a.example.com", "c.example.com", "static.example.com"},
+		nil,
+		nil)
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/mesh", nil))
+	body := rec.Body.String()
+	for _, want := range []string{"c.example.com", "static.example.com", "mesh-with", "srv", "SERVFAIL"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("debug page missing %q:\n%s", want, body)
+		}
+	}
+
+	// A peer found by the same source after an error isn't restarted.
+	srvs, srvErr = []*net.SRV{{Target: "c.example.com.", Port: 443}}, nil
+	check("recovered",
+		[]string{"c.example.com", "static.example.com"},
+		nil,
+		[]string{"a.example.com"})
+}
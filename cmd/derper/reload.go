@@ -0,0 +1,517 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tailscale/setec/client/setec"
+	"tailscale.com/derp"
+	"tailscale.com/derp/derpserver"
+	"tailscale.com/types/key"
+	"tailscale.com/types/logger"
+)
+
+// settings are the derper settings that can be changed without a restart,
+// by editing the config file and reloading it on SIGHUP or via /debug/reload.
+type settings struct {
+	meshKey                 key.DERPMesh // or zero if none
+	verifyClients           bool
+	verifyClientURL         string
+	verifyClientURLFailOpen bool
+	verifyClientURLCacheTTL time.Duration
+	admitKeysFile           string
+	admitKeys               derpserver.KeyAllowlist // or nil if admitKeysFile is empty
+	admitIPs                derpserver.IPFilter
+	maxConnsPerIP           int
+	bootstrapDNS            bootstrapDNSNames
+	clientRateLimits        derpserver.ClientRateLimits
+}
+
+// flagOr returns the value of the named flag if it was set on the command
+// line, else v if non-nil, else the flag's default.
+func flagOr[T any](name string, flagVal *T, v *T) T {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	if !set && v != nil {
+		return *v
+	}
+	return *flagVal
+}
+
+// loadSettings returns the settings from cfg and the flags, fetching the
+// mesh key from its source. It returns an error if any setting is invalid.
+func loadSettings(ctx context.Context, cfg config) (settings, error) {
+	st := settings{
+		verifyClients:           flagOr("verify-clients", verifyClients, cfg.VerifyClients),
+		verifyClientURL:         flagOr("verify-client-url", verifyClientURL, cfg.VerifyClientURL),
+		verifyClientURLFailOpen: flagOr("verify-client-url-fail-open", verifyFailOpen, cfg.VerifyClientURLFailOpen),
+		bootstrapDNS: bootstrapDNSNames{
+			published:   flagOr("bootstrap-dns-names", bootstrapDNS, cfg.BootstrapDNSNames),
+			unpublished: flagOr("unpublished-bootstrap-dns-names", unpublishedDNS, cfg.UnpublishedBootstrapDNSNames),
+		},
+		admitKeysFile:    flagOr("admit-keys-file", admitKeysFile, cfg.AdmitKeysFile),
+		maxConnsPerIP:    flagOr("max-conns-per-ip", maxConnsPerIP, cfg.MaxConnsPerIP),
+		clientRateLimits: clientRateLimits(cfg),
+	}
+	var cacheTTL *time.Duration
+	if cfg.VerifyClientURLCacheTTL != nil {
+		cacheTTL = &cfg.VerifyClientURLCacheTTL.Duration
+	}
+	st.verifyClientURLCacheTTL = flagOr("verify-client-url-cache-ttl", verifyURLTTL, cacheTTL)
+	if u := st.verifyClientURL; u != "" {
+		pu, err := url.Parse(u)
+		if err != nil {
+			return settings{}, fmt.Errorf("verify client URL: %w", err)
+		}
+		if pu.Scheme != "http" && pu.Scheme != "https" || pu.Host == "" {
+			return settings{}, fmt.Errorf("verify client URL %q: not an http or https URL", u)
+		}
+	}
+	for _, list := range []string{st.bootstrapDNS.published, st.bootstrapDNS.unpublished} {
+		if list == "" {
+			continue
+		}
+		for ent := range strings.SplitSeq(list, ",") {
+			if name, _, _ := strings.Cut(ent, "/"); name == "" {
+				return settings{}, fmt.Errorf("bootstrap DNS names %q: empty name", list)
+			}
+		}
+	}
+	var err error
+	if st.admitIPs.Allow, err = parsePrefixes(flagOr("admit-allow-ips", admitAllowIPs, cfg.AdmitAllowIPs)); err != nil {
+		return settings{}, fmt.Errorf("admit allowed IPs: %w", err)
+	}
+	if st.admitIPs.Deny, err = parsePrefixes(flagOr("admit-deny-ips", admitDenyIPs, cfg.AdmitDenyIPs)); err != nil {
+		return settings{}, fmt.Errorf("admit denied IPs: %w", err)
+	}
+	if st.admitKeysFile != "" {
+		if st.admitKeys, err = derpserver.LoadKeyAllowlist(st.admitKeysFile); err != nil {
+			return settings{}, fmt.Errorf("admit keys: %w", err)
+		}
+	}
+	if st.maxConnsPerIP < 0 || st.verifyClientURLCacheTTL < 0 {
+		return settings{}, errors.New("negative max conns per IP or verify client URL cache TTL")
+	}
+	l := st.clientRateLimits
+	if l.BytesPerSecond < 0 || l.PacketsPerSecond < 0 || l.PingsPerSecond < 0 ||
+		l.BytesBurst < 0 || l.PacketsBurst < 0 || l.PingsBurst < 0 {
+		return settings{}, fmt.Errorf("client rate limits %+v: negative limit", l)
+	}
+	if l.BytesBurst > 0 && l.BytesBurst < derp.MaxPacketSize {
+		// Bigger packets could never be sent.
+		return settings{}, fmt.Errorf("client rate limits %+v: bytes burst less than the max packet size %d", l, derp.MaxPacketSize)
+	}
+
+	meshKey, err := loadMeshKey(ctx, flagOr("mesh-psk-file", meshPSKFile, cfg.MeshPSKFile))
+	if err != nil {
+		return settings{}, err
+	}
+	if meshKey != "" {
+		st.meshKey, err = key.ParseDERPMesh(meshKey)
+		if err != nil {
+			return settings{}, fmt.Errorf("invalid mesh key: %w", err)
+		}
+	}
+	return st, nil
+}
+
+// parsePrefixes parses a comma-separated list of IP prefixes or addresses.
+func parsePrefixes(list string) ([]netip.Prefix, error) {
+	var ret []netip.Prefix
+	for s := range strings.SplitSeq(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			ip, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, netip.PrefixFrom(ip, ip.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, p.Masked())
+	}
+	return ret, nil
+}
+
+// loadMeshKey returns the mesh key from the environment in --dev mode, else
+// from setec if --secrets-url is set, else from pskFile if non-empty.
+// It returns the empty string if there's no mesh key.
+func loadMeshKey(ctx context.Context, pskFile string) (string, error) {
+	switch {
+	case *dev:
+		return os.Getenv(meshKeyEnvVar), nil
+	case *secretsURL != "":
+		meshKeySecret := path.Join(*secretPrefix, setecMeshKeyName)
+		fc, err := setec.NewFileCache(*secretsCacheDir)
+		if err != nil {
+			return "", fmt.Errorf("NewFileCache: %w", err)
+		}
+		st, err := setec.NewStore(ctx,
+			setec.StoreConfig{
+				Client: setec.Client{Server: *secretsURL},
+				Secrets: []string{
+					meshKeySecret,
+				},
+				Cache: fc,
+			})
+		if err != nil {
+			return "", fmt.Errorf("NewStore: %w", err)
+		}
+		defer st.Close()
+		return st.Secret(meshKeySecret).GetString(), nil
+	case pskFile != "":
+		b, err := setec.StaticFile(pskFile)
+		if err != nil {
+			return "", fmt.Errorf("StaticFile failed to get key: %w", err)
+		}
+		return b.GetString(), nil
+	}
+	return "", nil
+}
+
+// diffSettings returns a description of each setting that differs between
+// old and new. Mesh keys are not included in the output.
+func diffSettings(old, new settings) []string {
+	var diffs []string
+	if !old.meshKey.Equal(new.meshKey) {
+		switch {
+		case old.meshKey.IsZero():
+			diffs = append(diffs, "mesh key: configured")
+		case new.meshKey.IsZero():
+			diffs = append(diffs, "mesh key: removed")
+		default:
+			diffs = append(diffs, "mesh key: changed")
+		}
+	}
+	add := func(name string, old, new any) {
+		if old != new {
+			diffs = append(diffs, fmt.Sprintf("%s: %+v -> %+v", name, old, new))
+		}
+	}
+	add("verify clients", old.verifyClients, new.verifyClients)
+	add("verify client URL", fmt.Sprintf("%q", old.verifyClientURL), fmt.Sprintf("%q", new.verifyClientURL))
+	add("verify client URL fail open", old.verifyClientURLFailOpen, new.verifyClientURLFailOpen)
+	add("verify client URL cache TTL", old.verifyClientURLCacheTTL, new.verifyClientURLCacheTTL)
+	add("admit keys file", fmt.Sprintf("%q", old.admitKeysFile), fmt.Sprintf("%q", new.admitKeysFile))
+	if !maps.Equal(old.admitKeys, new.admitKeys) {
+		diffs = append(diffs, fmt.Sprintf("admit keys: %d keys -> %d keys", len(old.admitKeys), len(new.admitKeys)))
+	}
+	add("admit allowed IPs", fmt.Sprint(old.admitIPs.Allow), fmt.Sprint(new.admitIPs.Allow))
+	add("admit denied IPs", fmt.Sprint(old.admitIPs.Deny), fmt.Sprint(new.admitIPs.Deny))
+	add("max conns per IP", old.maxConnsPerIP, new.maxConnsPerIP)
+	add("bootstrap DNS names", fmt.Sprintf("%q", old.bootstrapDNS.published), fmt.Sprintf("%q", new.bootstrapDNS.published))
+	add("unpublished bootstrap DNS names", fmt.Sprintf("%q", old.bootstrapDNS.unpublished), fmt.Sprintf("%q", new.bootstrapDNS.unpublished))
+	add("per-client rate limits", old.clientRateLimits, new.clientRateLimits)
+	return diffs
+}
+
+// reloader applies the config file and flags to a running derper, and
+// reapplies them when the config file is reloaded.
+type reloader struct {
+	s          *derpserver.Server
+	logf       logger.Logf
+	privateKey key.NodePrivate
+
+	// readConfig returns the current config file.
+	readConfig func() (config, error)
+
+	// certs, if non-nil, is the TLS certificate provider, which is
+	// recreated on reload in --certmode=manual to pick up new
+	// certificate files.
+	certs *reloadableCertProvider
+
+	// mesh, if non-nil, is started when a mesh key is first configured
+	// and restarted when the mesh key changes, so that mesh peers
+	// reconnect with the new key.
+	mesh *meshManager
+
+	// urlAdmitter is the current --verify-client-url Admitter, if any.
+	// It's kept across reloads that don't change it, to keep its cache.
+	urlAdmitter *derpserver.URLAdmitter
+
+	mu          sync.Mutex
+	cur         settings
+	lastReload  time.Time
+	lastErr     error
+	lastChanges []string
+}
+
+// applyLocked applies st to the server, returning what changed.
+// r.mu must be held.
+func (r *reloader) applyLocked(ctx context.Context, st settings) []string {
+	old := r.cur
+	diffs := diffSettings(old, st)
+	if !st.meshKey.Equal(old.meshKey) {
+		r.s.SetMeshKey(st.meshKey.String()) // already validated
+	}
+	r.s.SetVerifyClient(st.verifyClients)
+	r.s.SetAdmitter(r.admitterLocked(st))
+	if st.clientRateLimits != old.clientRateLimits {
+		r.s.SetClientRateLimits(st.clientRateLimits)
+	}
+	if st.bootstrapDNS != old.bootstrapDNS {
+		setBootstrapDNSNames(st.bootstrapDNS)
+	}
+	r.cur = st
+	if r.mesh != nil && !st.meshKey.Equal(old.meshKey) {
+		if old.meshKey.IsZero() {
+			r.logf("starting mesh connections with new mesh key")
+			r.mesh.start()
+		} else {
+			r.logf("restarting mesh connections with new mesh key")
+			r.mesh.stopAll()
+			r.mesh.update(ctx)
+		}
+	}
+	return diffs
+}
+
+// admitterLocked returns the Admitter for st, or nil if st admits all
+// clients. The checks are ordered from cheapest to most expensive.
+// r.mu must be held.
+func (r *reloader) admitterLocked(st settings) derpserver.Admitter {
+	var as derpserver.Admitters
+	if len(st.admitIPs.Allow) > 0 || len(st.admitIPs.Deny) > 0 {
+		as = append(as, st.admitIPs)
+	}
+	if st.maxConnsPerIP > 0 {
+		as = append(as, derpserver.MaxConnsPerIP(st.maxConnsPerIP))
+	}
+	if st.admitKeys != nil {
+		as = append(as, st.admitKeys)
+	}
+	if st.verifyClientURL == "" {
+		r.urlAdmitter = nil
+	} else {
+		if ua := r.urlAdmitter; ua == nil || ua.URL != st.verifyClientURL ||
+			ua.FailOpen != st.verifyClientURLFailOpen || ua.CacheTTL != st.verifyClientURLCacheTTL {
+			r.urlAdmitter = &derpserver.URLAdmitter{
+				URL:      st.verifyClientURL,
+				FailOpen: st.verifyClientURLFailOpen,
+				CacheTTL: st.verifyClientURLCacheTTL,
+				Logf:     r.logf,
+			}
+		}
+		as = append(as, r.urlAdmitter)
+	}
+	if len(as) == 0 {
+		return nil
+	}
+	return as
+}
+
+// reload reads the config file, validates it and, if it's valid, applies
+// it. If anything is invalid, nothing is changed and an error is returned.
+func (r *reloader) reload(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastReload = time.Now()
+	r.lastChanges = nil
+	r.lastErr = r.reloadLocked(ctx)
+	if r.lastErr != nil {
+		r.logf("config reload failed; keeping current config: %v", r.lastErr)
+		return r.lastErr
+	}
+	if len(r.lastChanges) == 0 {
+		r.logf("config reloaded; no changes")
+	}
+	for _, c := range r.lastChanges {
+		r.logf("config reloaded: %s", c)
+	}
+	return nil
+}
+
+func (r *reloader) reloadLocked(ctx context.Context) error {
+	cfg, err := r.readConfig()
+	if err != nil {
+		return err
+	}
+	if !cfg.PrivateKey.Equal(r.privateKey) {
+		return errors.New("PrivateKey changed; changing the server key requires a restart")
+	}
+	st, err := loadSettings(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	if st.meshKey.IsZero() && !r.cur.meshKey.IsZero() {
+		return errors.New("mesh key removed; removing the mesh key requires a restart")
+	}
+	var certs certProvider
+	if r.certs != nil && *certMode == "manual" {
+		certs, err = certProviderByCertMode(*certMode, *certDir, *hostname, *acmeEABKid, *acmeEABKey, *acmeEmail)
+		if err != nil {
+			return fmt.Errorf("TLS certificate: %w", err)
+		}
+	}
+
+	// Everything's valid. Apply it.
+	r.lastChanges = r.applyLocked(ctx, st)
+	if certs != nil {
+		if old, new := r.certs.Fingerprint(), certFingerprint(certs); old != new {
+			r.lastChanges = append(r.lastChanges, fmt.Sprintf("TLS certificate: %s -> %s", old, new))
+		}
+		r.certs.Set(certs)
+	}
+	return nil
+}
+
+// reloadOnSIGHUP reloads the config each time the process gets a SIGHUP,
+// until ctx is done.
+func (r *reloader) reloadOnSIGHUP(ctx context.Context, sigc <-chan os.Signal) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sigc:
+			r.logf("got SIGHUP; reloading config")
+			r.reload(ctx)
+		}
+	}
+}
+
+// ServeHTTP serves the /debug/reload page. A POST with a "Sec-Debug: derp"
+// header reloads the config.
+func (r *reloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method == "POST" {
+		if req.Header.Get("Sec-Debug") != "derp" {
+			http.Error(w, "To reload, use: curl -XPOST -HSec-Debug:derp http://derp/debug/reload", http.StatusBadRequest)
+			return
+		}
+		if err := r.reload(req.Context()); err != nil {
+			http.Error(w, "reload failed; config unchanged: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.mu.Lock()
+		changes := r.lastChanges
+		r.mu.Unlock()
+		if len(changes) == 0 {
+			io.WriteString(w, "config reloaded; no changes\n")
+		}
+		for _, c := range changes {
+			fmt.Fprintf(w, "%s\n", c)
+		}
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, "<html><body><h1>Config reload</h1>\n")
+	fmt.Fprintf(w, "<p>Reload by sending derper a SIGHUP, or with <code>curl -XPOST -HSec-Debug:derp http://derp/debug/reload</code>.</p>\n")
+	if r.lastReload.IsZero() {
+		fmt.Fprintf(w, "<p>Not reloaded since start.</p>\n")
+	} else {
+		fmt.Fprintf(w, "<p>Last reload: %s</p>\n", r.lastReload.Format(time.RFC3339))
+		if r.lastErr != nil {
+			fmt.Fprintf(w, "<p>Error: %s</p>\n", html.EscapeString(r.lastErr.Error()))
+		} else if len(r.lastChanges) > 0 {
+			fmt.Fprintf(w, "<ul>\n")
+			for _, c := range r.lastChanges {
+				fmt.Fprintf(w, "<li>%s</li>\n", html.EscapeString(c))
+			}
+			fmt.Fprintf(w, "</ul>\n")
+		}
+	}
+	if r.certs != nil {
+		fmt.Fprintf(w, "<p>TLS certificate: %s</p>\n", r.certs.Fingerprint())
+	}
+	fmt.Fprintf(w, "</body></html>\n")
+}
+
+// reloadableCertProvider is a certProvider whose underlying provider can be
+// replaced at runtime. New TLS handshakes use the new provider.
+type reloadableCertProvider struct {
+	cur atomic.Pointer[certProviderState]
+}
+
+type certProviderState struct {
+	p       certProvider
+	getCert func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+}
+
+func newReloadableCertProvider(p certProvider) *reloadableCertProvider {
+	r := new(reloadableCertProvider)
+	r.Set(p)
+	return r
+}
+
+// Set replaces the underlying certProvider.
+func (r *reloadableCertProvider) Set(p certProvider) {
+	r.cur.Store(&certProviderState{p: p, getCert: p.TLSConfig().GetCertificate})
+}
+
+// Fingerprint returns the fingerprint of the current manual certificate,
+// or "automatic" if the certificate isn't manual.
+func (r *reloadableCertProvider) Fingerprint() string {
+	return certFingerprint(r.cur.Load().p)
+}
+
+func (r *reloadableCertProvider) TLSConfig() *tls.Config {
+	c := r.cur.Load().p.TLSConfig()
+	c.GetCertificate = func(hi *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return r.cur.Load().getCert(hi)
+	}
+	return c
+}
+
+func (r *reloadableCertProvider) HTTPHandler(fallback http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.cur.Load().p.HTTPHandler(fallback).ServeHTTP(w, req)
+	})
+}
+
+// certFingerprint returns the SHA-256 fingerprint of p's certificate if p is
+// a manual certificate, or "automatic" otherwise.
+func certFingerprint(p certProvider) string {
+	m, ok := p.(*manualCertManager)
+	if !ok {
+		return "automatic"
+	}
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(m.cert.Certificate[0]))
+}
+
+// logStartupSettings logs the settings that derper starts with.
+func logStartupSettings(st settings) {
+	switch {
+	case !st.meshKey.IsZero():
+		log.Println("DERP mesh key configured")
+	case *dev:
+		log.Printf("No mesh key configured for --dev mode; set %s", meshKeyEnvVar)
+	default:
+		log.Printf("No mesh key configured")
+	}
+	if st.clientRateLimits != (derpserver.ClientRateLimits{}) {
+		log.Printf("per-client rate limits: %+v", st.clientRateLimits)
+	}
+}
@@ -0,0 +1,361 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/derp/derpserver"
+	"tailscale.com/tstest"
+	"tailscale.com/tstime"
+	"tailscale.com/types/key"
+	"tailscale.com/types/logger"
+	"tailscale.com/types/ptr"
+	"tailscale.com/util/set"
+)
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	tstest.Replace(t, configPath, filepath.Join(dir, "derper.json"))
+	tstest.Replace(t, meshPSKFile, "")
+	tstest.Replace(t, certMode, "manual")
+	tstest.Replace(t, certDir, filepath.Join(dir, "certs"))
+	tstest.Replace(t, hostname, "127.0.0.1")
+
+	const meshKey1 = "1111111111111111111111111111111111111111111111111111111111111111"
+	const meshKey2 = "2222222222222222222222222222222222222222222222222222222222222222"
+	keyFile := filepath.Join(dir, "mesh.key")
+	writeFile := func(name, contents string) {
+		t.Helper()
+		if err := os.WriteFile(name, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(keyFile, meshKey1+"\n")
+
+	cfg := config{
+		PrivateKey:  key.NewNode(),
+		MeshPSKFile: ptr.To(keyFile),
+	}
+	writeConfig := func(cfg config) {
+		t.Helper()
+		b, err := json.Marshal(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeFile(*configPath, string(b))
+	}
+	writeConfig(cfg)
+
+	s := derpserver.New(cfg.PrivateKey, logger.Discard)
+	defer s.Close()
+	certs, err := certProviderByCertMode(*certMode, *certDir, *hostname, "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &reloader{
+		s:          s,
+		logf:       t.Logf,
+		privateKey: cfg.PrivateKey,
+		readConfig: func() (config, error) { return readConfig(*configPath) },
+		certs:      newReloadableCertProvider(certs),
+	}
+	ctx := context.Background()
+	st, err := loadSettings(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.applyLocked(ctx, st)
+	if got := s.MeshKey().String(); got != meshKey1 {
+		t.Fatalf("mesh key = %v; want %v", got, meshKey1)
+	}
+
+	// A valid change is applied, and the TLS certificate is reloaded
+	// from disk.
+	writeFile(keyFile, meshKey2)
+	cfg.VerifyClientURL = ptr.To("https://admission.example.com/check")
+	cfg.VerifyClientURLFailOpen = ptr.To(false)
+	cfg.ClientRateLimits = &derpserver.ClientRateLimits{PacketsPerSecond: 100}
+	writeConfig(cfg)
+	oldCert := r.certs.Fingerprint()
+	if err := os.RemoveAll(*certDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.reload(ctx); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if got := s.MeshKey().String(); got != meshKey2 {
+		t.Errorf("mesh key = %v; want %v", got, meshKey2)
+	}
+	if ua := r.urlAdmitter; ua == nil || ua.URL != *cfg.VerifyClientURL || ua.FailOpen {
+		t.Errorf("URL admitter = %+v; want URL %q without FailOpen", ua, *cfg.VerifyClientURL)
+	}
+	as, _ := s.Admitter().(derpserver.Admitters)
+	if len(as) != 1 || as[0] != r.urlAdmitter {
+		t.Errorf("admitter = %#v; want the URL admitter", s.Admitter())
+	}
+	if got := s.ClientRateLimits(); got != *cfg.ClientRateLimits {
+		t.Errorf("client rate limits = %+v; want %+v", got, *cfg.ClientRateLimits)
+	}
+	if newCert := r.certs.Fingerprint(); newCert == oldCert {
+		t.Errorf("TLS certificate not reloaded; still %s", newCert)
+	}
+	wantChanges := []string{
+		"mesh key: changed",
+		`verify client URL: "" -> "https://admission.example.com/check"`,
+		"verify client URL fail open: true -> false",
+		"per-client rate limits:",
+		"TLS certificate:",
+	}
+	if len(r.lastChanges) != len(wantChanges) {
+		t.Fatalf("changes = %q; want %q", r.lastChanges, wantChanges)
+	}
+	for i, want := range wantChanges {
+		if !strings.HasPrefix(r.lastChanges[i], want) {
+			t.Errorf("change %d = %q; want prefix %q", i, r.lastChanges[i], want)
+		}
+	}
+	if slices.ContainsFunc(r.lastChanges, func(c string) bool { return strings.Contains(c, meshKey2) }) {
+		t.Errorf("changes contain mesh key: %q", r.lastChanges)
+	}
+
+	// Invalid configs are refused without changing anything.
+	for name, bad := range map[string]func(*config){
+		"bad-url": func(c *config) {
+			c.ClientRateLimits = nil
+			c.VerifyClientURL = ptr.To("admission.example.com")
+		},
+		"new-private-key": func(c *config) {
+			c.PrivateKey = key.NewNode()
+		},
+		"bad-mesh-key": func(c *config) {
+			writeFile(keyFile, "not a mesh key")
+		},
+		"no-mesh-key": func(c *config) {
+			c.MeshPSKFile = ptr.To("")
+		},
+		"negative-rate": func(c *config) {
+			c.ClientRateLimits = &derpserver.ClientRateLimits{BytesPerSecond: -1}
+		},
+		"small-bytes-burst": func(c *config) {
+			c.ClientRateLimits = &derpserver.ClientRateLimits{BytesPerSecond: 1000, BytesBurst: 1500}
+		},
+		"bad-ip-prefix": func(c *config) {
+			c.AdmitDenyIPs = ptr.To("10.0.0.0/33")
+		},
+		"missing-keys-file": func(c *config) {
+			c.AdmitKeysFile = ptr.To(filepath.Join(dir, "missing"))
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			writeFile(keyFile, meshKey2)
+			badCfg := cfg
+			bad(&badCfg)
+			writeConfig(badCfg)
+			if err := r.reload(ctx); err == nil {
+				t.Fatal("reload succeeded")
+			}
+			if got := s.MeshKey().String(); got != meshKey2 {
+				t.Errorf("mesh key = %v; want %v", got, meshKey2)
+			}
+			if got := r.urlAdmitter.URL; got != *cfg.VerifyClientURL {
+				t.Errorf("verify client URL = %q; want %q", got, *cfg.VerifyClientURL)
+			}
+			if got := s.ClientRateLimits(); got != *cfg.ClientRateLimits {
+				t.Errorf("client rate limits = %+v; want %+v", got, *cfg.ClientRateLimits)
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/reload", nil))
+	if body := rec.Body.String(); !strings.Contains(body, "Error:") {
+		t.Errorf("debug page doesn't show last error:\n%s", body)
+	}
+
+	// Reloading via the debug endpoint requires the Sec-Debug header.
+	writeFile(keyFile, meshKey2)
+	writeConfig(cfg)
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("POST", "/debug/reload", nil))
+	if rec.Code != 400 {
+		t.Errorf("POST without Sec-Debug: code = %d; want 400", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/debug/reload", nil)
+	req.Header.Set("Sec-Debug", "derp")
+	r.ServeHTTP(rec, req)
+	if rec.Code != 200 {
+		t.Errorf("POST: code = %d; want 200; body: %s", rec.Code, rec.Body.String())
+	}
+	if r.lastErr != nil {
+		t.Errorf("last error = %v; want nil", r.lastErr)
+	}
+}
+
+func TestReloadAdmitter(t *testing.T) {
+	dir := t.TempDir()
+	tstest.Replace(t, configPath, filepath.Join(dir, "derper.json"))
+	tstest.Replace(t, meshPSKFile, "")
+
+	allowed := key.NewNode().Public()
+	keysFile := filepath.Join(dir, "keys")
+	if err := os.WriteFile(keysFile, []byte("# allowed\n"+allowed.String()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config{
+		PrivateKey:              key.NewNode(),
+		VerifyClientURL:         ptr.To("https://admission.example.com/check"),
+		VerifyClientURLCacheTTL: &tstime.GoDuration{Duration: time.Minute},
+	}
+	writeConfig := func(cfg config) {
+		t.Helper()
+		b, err := json.Marshal(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(*configPath, b, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig(cfg)
+
+	s := derpserver.New(cfg.PrivateKey, logger.Discard)
+	defer s.Close()
+	r := &reloader{
+		s:          s,
+		logf:       t.Logf,
+		privateKey: cfg.PrivateKey,
+		readConfig: func() (config, error) { return readConfig(*configPath) },
+	}
+	ctx := context.Background()
+	if err := r.reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+	urlAdmitter := r.urlAdmitter
+	if urlAdmitter == nil || urlAdmitter.CacheTTL != time.Minute {
+		t.Fatalf("URL admitter = %+v; want CacheTTL 1m", urlAdmitter)
+	}
+
+	cfg.AdmitKeysFile = ptr.To(keysFile)
+	cfg.AdmitAllowIPs = ptr.To("192.0.2.0/24, 2001:db8::1")
+	cfg.AdmitDenyIPs = ptr.To("192.0.2.128/25")
+	cfg.MaxConnsPerIP = ptr.To(5)
+	writeConfig(cfg)
+	if err := r.reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if r.urlAdmitter != urlAdmitter {
+		t.Error("URL admitter replaced though unchanged")
+	}
+	as, _ := s.Admitter().(derpserver.Admitters)
+	if len(as) != 4 {
+		t.Fatalf("admitter = %#v; want 4 Admitters", s.Admitter())
+	}
+	want := derpserver.IPFilter{
+		Allow: []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24"), netip.MustParsePrefix("2001:db8::1/128")},
+		Deny:  []netip.Prefix{netip.MustParsePrefix("192.0.2.128/25")},
+	}
+	if got, _ := as[0].(derpserver.IPFilter); !reflect.DeepEqual(got, want) {
+		t.Errorf("IP filter = %+v; want %+v", as[0], want)
+	}
+	if got := as[1]; got != derpserver.MaxConnsPerIP(5) {
+		t.Errorf("second admitter = %#v; want MaxConnsPerIP(5)", got)
+	}
+	if err := as[2].Admit(ctx, derpserver.AdmitRequest{NodePublic: allowed}); err != nil {
+		t.Errorf("allowed key rejected: %v", err)
+	}
+	if as[3] != r.urlAdmitter {
+		t.Errorf("last admitter = %#v; want URL admitter", as[3])
+	}
+	for _, want := range []string{"admit keys: 0 keys -> 1 keys", "max conns per IP: 0 -> 5"} {
+		if !slices.Contains(r.lastChanges, want) {
+			t.Errorf("changes %q don't contain %q", r.lastChanges, want)
+		}
+	}
+
+	// Removing everything removes the admitter.
+	writeConfig(config{PrivateKey: cfg.PrivateKey})
+	if err := r.reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if a := s.Admitter(); a != nil {
+		t.Errorf("admitter = %#v; want nil", a)
+	}
+}
+
+func TestReloadStartsMesh(t *testing.T) {
+	dir := t.TempDir()
+	tstest.Replace(t, configPath, filepath.Join(dir, "derper.json"))
+	tstest.Replace(t, meshPSKFile, "")
+
+	cfg := config{PrivateKey: key.NewNode()}
+	writeConfig := func(cfg config) {
+		t.Helper()
+		b, err := json.Marshal(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(*configPath, b, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig(cfg)
+
+	s := derpserver.New(cfg.PrivateKey, logger.Discard)
+	defer s.Close()
+
+	var started []string
+	m := newMeshManager(s, t.Logf)
+	m.ctx = context.Background()
+	m.static = []meshHost{{host: "peer.example.com"}}
+	m.startPeer = func(h meshHost) (*meshPeer, error) {
+		started = append(started, h.String())
+		done := make(chan struct{})
+		close(done)
+		return &meshPeer{
+			host:    h,
+			cancel:  func() {},
+			done:    done,
+			present: make(set.Set[key.NodePublic]),
+		}, nil
+	}
+	r := &reloader{
+		s:          s,
+		logf:       t.Logf,
+		privateKey: cfg.PrivateKey,
+		readConfig: func() (config, error) { return readConfig(*configPath) },
+		mesh:       m,
+	}
+	ctx := context.Background()
+	if err := r.reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(started) != 0 {
+		t.Fatalf("started %q without a mesh key", started)
+	}
+
+	keyFile := filepath.Join(dir, "mesh.key")
+	if err := os.WriteFile(keyFile, []byte("1111111111111111111111111111111111111111111111111111111111111111"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg.MeshPSKFile = ptr.To(keyFile)
+	writeConfig(cfg)
+	if err := r.reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"peer.example.com"}; !slices.Equal(started, want) {
+		t.Errorf("started = %q; want %q", started, want)
+	}
+}
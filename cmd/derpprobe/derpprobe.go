@@ -14,6 +14,7 @@ import (
 	"path"
 	"path/filepath"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/tailscale/setec/client/setec"
@@ -54,6 +55,15 @@ var (
 	secretsURL         = flag.String("secrets-url", "", "SETEC server URL for secrets retrieval of mesh key")
 	secretPrefix       = flag.String("secrets-path-prefix", "prod/derp", fmt.Sprintf("setec path prefix for \"%s\" secret for DERP mesh key", setecMeshKeyName))
 	secretsCacheDir    = flag.String("secrets-cache-dir", defaultSetecCacheDir(), "directory to cache setec secrets in (required if --secrets-url is set)")
+	sloTarget          = flag.Float64("slo-target", 0, "if non-zero, the target ratio of successful probes (e.g. 0.999) to alert on using multi-window burn rates")
+	alertWebhookURL    = flag.String("alert-webhook-url", "", "if non-empty, URL to POST alerts to as JSON")
+	alertSMTPAddr      = flag.String("alert-smtp-addr", "localhost:25", "SMTP server to send alert emails through")
+	alertEmailFrom     = flag.String("alert-email-from", "derpprobe@localhost", "sender address of alert emails")
+	alertEmailTo       = flag.String("alert-email-to", "", "if non-empty, comma-separated addresses to email alerts to")
+	alertStdout        = flag.Bool("alert-stdout", false, "whether to print alerts to stdout")
+	alertRepeat        = flag.Duration("alert-repeat-interval", 0, "if non-zero, how often to notify again about alerts that are still firing")
+	historyFile        = flag.String("history-file", "", "if non-empty, file to persist probe results in, for the status page's uptime history")
+	historyRetention   = flag.Duration("history-retention", 30*24*time.Hour, "how long to keep probe results in --history-file")
 )
 
 func main() {
@@ -64,6 +74,25 @@ func main() {
 	}
 
 	p := prober.New().WithSpread(*spread).WithOnce(*probeOnce).WithMetricNamespace("derpprobe")
+	var alerter *prober.Alerter
+	if *sloTarget != 0 && !*probeOnce {
+		var err error
+		alerter, err = newAlerter()
+		if err != nil {
+			log.Fatal(err)
+		}
+		p = p.WithAlerter(alerter)
+	}
+	if *historyFile != "" && !*probeOnce {
+		store, err := prober.OpenResultStore(prober.ResultStoreOpts{
+			Path:      *historyFile,
+			Retention: *historyRetention,
+		})
+		if err != nil {
+			log.Fatalf("opening --history-file: %v", err)
+		}
+		p = p.WithResultStore(store)
+	}
 	meshKey, err := getMeshKey()
 	if err != nil {
 		log.Fatalf("failed to get mesh key: %v", err)
@@ -108,6 +137,9 @@ func main() {
 	d := tsweb.Debugger(mux)
 	d.Handle("probe-run", "Run a probe", tsweb.StdHandler(tsweb.ReturnHandlerFunc(p.RunHandler), tsweb.HandlerOptions{Logf: log.Printf}))
 	d.Handle("probe-all", "Run all configured probes", tsweb.StdHandler(tsweb.ReturnHandlerFunc(p.RunAllHandler), tsweb.HandlerOptions{Logf: log.Printf}))
+	if alerter != nil {
+		d.Handle("alerts", "Firing alerts", alerter)
+	}
 	mux.Handle("/", tsweb.StdHandler(p.StatusHandler(
 		prober.WithTitle("DERP Prober"),
 		prober.WithPageLink("Prober metrics", "/debug/varz"),
@@ -122,6 +154,32 @@ func main() {
 	log.Fatal(http.ListenAndServe(*listen, mux))
 }
 
+// newAlerter returns an Alerter for the --slo-target and --alert-* flags.
+func newAlerter() (*prober.Alerter, error) {
+	var notifiers []prober.Notifier
+	if *alertWebhookURL != "" {
+		notifiers = append(notifiers, &prober.WebhookNotifier{URL: *alertWebhookURL})
+	}
+	if *alertEmailTo != "" {
+		notifiers = append(notifiers, &prober.EmailNotifier{
+			Addr: *alertSMTPAddr,
+			From: *alertEmailFrom,
+			To:   strings.Split(*alertEmailTo, ","),
+		})
+	}
+	if *alertStdout {
+		notifiers = append(notifiers, &prober.WriterNotifier{})
+	}
+	if len(notifiers) == 0 {
+		log.Printf("--slo-target set without any --alert-* notifiers; alerts are only shown at /debug/alerts")
+	}
+	return prober.NewAlerter(prober.AlertConfig{
+		SLOs:           []prober.SLO{{Target: *sloTarget}},
+		Notifiers:      notifiers,
+		RepeatInterval: *alertRepeat,
+	})
+}
+
 func getMeshKey() (key.DERPMesh, error) {
 	var meshKey string
 
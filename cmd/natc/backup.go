@@ -0,0 +1,214 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/peterbourgon/ff/v3"
+	"tailscale.com/util/httpm"
+)
+
+const adminUsage = `usage:
+  natc snapshot                    take a snapshot of the cluster state on this node
+  natc snapshots                   list the snapshots stored on this node
+  natc backup [-snapshot ID] FILE  take a snapshot (or use snapshot ID) and write it to FILE ("-" for stdout)
+  natc restore FILE                restore the cluster state from a backup in FILE ("-" for stdin)
+
+These talk to the cluster admin HTTP API of a natc running on this machine.
+
+To recover a cluster that has lost quorum: stop every natc, move their
+cluster state directories aside, start a single natc so that it becomes
+leader of a new cluster, run "natc restore" against it, and then start
+the others so that they join it.
+`
+
+// runAdminCommand runs one of the natc subcommands that administer a
+// natc cluster via the cluster admin HTTP API on localhost.
+func runAdminCommand(cmd string, args []string) error {
+	fs := flag.NewFlagSet("natc "+cmd, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), adminUsage)
+		fs.PrintDefaults()
+	}
+	adminPort := fs.Int("cluster-admin-port", 8081, "Port on localhost of the cluster admin HTTP API")
+	snapshotID := fs.String("snapshot", "", "for backup, the ID of an existing snapshot to back up instead of taking a new one")
+	if err := ff.Parse(fs, args, ff.WithEnvVarPrefix("TS_NATC")); err != nil {
+		return err
+	}
+	ac := &adminClient{
+		base: fmt.Sprintf("http://127.0.0.1:%d", *adminPort),
+		hc:   &http.Client{Timeout: 5 * time.Minute},
+	}
+	switch {
+	case cmd == "snapshot" && fs.NArg() == 0:
+		meta, err := ac.takeSnapshot()
+		if err != nil {
+			return err
+		}
+		fmt.Println(meta.ID)
+		return nil
+	case cmd == "snapshots" && fs.NArg() == 0:
+		return ac.printSnapshots(os.Stdout)
+	case cmd == "backup" && fs.NArg() == 1:
+		return ac.backup(*snapshotID, fs.Arg(0))
+	case cmd == "restore" && fs.NArg() == 1:
+		return ac.restore(fs.Arg(0))
+	}
+	fs.Usage()
+	return fmt.Errorf("invalid arguments for natc %s", cmd)
+}
+
+// adminClient is a client of the API served by httpClusterAdmin.
+type adminClient struct {
+	base string
+	hc   *http.Client
+}
+
+// do sends a request to the admin API and returns the response if it was a 200.
+func (ac *adminClient) do(method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(context.Background(), method, ac.base+path, body)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := ac.hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, msg)
+	}
+	return resp, nil
+}
+
+func (ac *adminClient) takeSnapshot() (*raft.SnapshotMeta, error) {
+	resp, err := ac.do(httpm.POST, "/snapshot", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var meta raft.SnapshotMeta
+	if err := json.NewDecoder(resp.Body).Decode(&meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+func (ac *adminClient) printSnapshots(w io.Writer) error {
+	resp, err := ac.do(httpm.GET, "/snapshots", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var metas []*raft.SnapshotMeta
+	if err := json.NewDecoder(resp.Body).Decode(&metas); err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tINDEX\tTERM\tSIZE")
+	for _, m := range metas {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\n", m.ID, m.Index, m.Term, m.Size)
+	}
+	return tw.Flush()
+}
+
+// backup writes a backup of the snapshot with the given id, or of a new
+// snapshot if id is empty, to the file at path, or to stdout if path is "-".
+func (ac *adminClient) backup(id, path string) error {
+	if id == "" {
+		meta, err := ac.takeSnapshot()
+		if err != nil {
+			return err
+		}
+		id = meta.ID
+	}
+	resp, err := ac.do(httpm.GET, "/snapshots/"+id, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if path == "-" {
+		_, err := copyBackup(os.Stdout, resp.Body)
+		return err
+	}
+	// Write to a temporary file first so that a failed backup doesn't
+	// replace a good one.
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = copyBackup(f, resp.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "wrote backup of snapshot %s to %s\n", id, path)
+	return nil
+}
+
+// copyBackup copies the backup read from r to w. The backup's response
+// status is sent before its contents, so copyBackup checks that the whole
+// backup arrived: its JSON header line followed by exactly the number of
+// bytes of state the header declares.
+func copyBackup(w io.Writer, r io.Reader) (*raft.SnapshotMeta, error) {
+	br := bufio.NewReader(r)
+	header, err := br.ReadBytes('\n')
+	if err != nil {
+		return nil, fmt.Errorf("reading backup header: %w", err)
+	}
+	var meta raft.SnapshotMeta
+	if err := json.Unmarshal(header, &meta); err != nil {
+		return nil, fmt.Errorf("reading backup header: %w", err)
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	n, err := io.Copy(w, br)
+	if err != nil {
+		return nil, err
+	}
+	if n != meta.Size {
+		return nil, fmt.Errorf("backup of snapshot %s is %d bytes, want %d", meta.ID, n, meta.Size)
+	}
+	return &meta, nil
+}
+
+// restore sends the backup in the file at path, or stdin if path is "-",
+// to be restored into the cluster.
+func (ac *adminClient) restore(path string) error {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	resp, err := ac.do(httpm.POST, "/restore", r)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,50 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAdminBackup(t *testing.T) {
+	const header = `{"Version":1,"ID":"2-7-123","Index":7,"Term":2,"Size":5}` + "\n"
+	var body string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/snapshots/2-7-123" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	defer ts.Close()
+	ac := &adminClient{base: ts.URL, hc: ts.Client()}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "backup")
+	body = header + "state"
+	if err := ac.backup("2-7-123", path); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != body {
+		t.Fatalf("backup = %q, want %q", got, body)
+	}
+
+	// A truncated or malformed backup doesn't replace the good one.
+	for _, bad := range []string{header + "sta", header + "states", "", `{"Version":1`} {
+		body = bad
+		if err := ac.backup("2-7-123", path); err == nil {
+			t.Errorf("backup of %q succeeded, want error", bad)
+		}
+		if got, _ := os.ReadFile(path); string(got) != header+"state" {
+			t.Errorf("after backup of %q, file = %q, want previous backup", bad, got)
+		}
+	}
+	if des, _ := os.ReadDir(dir); len(des) != 1 {
+		t.Errorf("temporary files left behind: %v", des)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/netip"
 	"time"
@@ -154,6 +155,9 @@ type ClusterOpts struct {
 	Tag        string
 	StateDir   string
 	FollowOnly bool
+	// AdminCapability, if set, is the peer capability required to use the
+	// cluster membership admin endpoints of the consensus monitor.
+	AdminCapability tailcfg.PeerCapability
 }
 
 // StartConsensus is part of the IPPool interface. It starts the raft background routines that handle consensus.
@@ -161,6 +165,7 @@ func (ipp *ConsensusIPPool) StartConsensus(ctx context.Context, ts *tsnet.Server
 	cfg := tsconsensus.DefaultConfig()
 	cfg.ServeDebugMonitor = true
 	cfg.StateDirPath = opts.StateDir
+	cfg.AdminCapability = opts.AdminCapability
 	cns, err := tsconsensus.Start(ctx, ts, ipp, tsconsensus.BootstrapOpts{
 		Tag:        opts.Tag,
 		FollowOnly: opts.FollowOnly,
@@ -227,7 +232,7 @@ type readDomainForIPArgs struct {
 	Addr   netip.Addr
 }
 
-// executeReadDomainForIP parses a readDomainForIP log entry and applies it.
+// executeReadDomainForIP parses a readDomainForIP query or log entry and answers it.
 func (ipp *ConsensusIPPool) executeReadDomainForIP(bs []byte) tsconsensus.CommandResult {
 	var args readDomainForIPArgs
 	err := json.Unmarshal(bs, &args)
@@ -253,7 +258,8 @@ func (ipp *ConsensusIPPool) applyReadDomainForIP(from tailcfg.NodeID, addr netip
 	return tsconsensus.CommandResult{Result: resultBs, Err: err}
 }
 
-// readDomainForIP executes a readDomainForIP command on the leader with raft.
+// readDomainForIP executes a readDomainForIP query on the leader, without
+// appending to the raft log.
 func (ipp *ConsensusIPPool) readDomainForIP(nid tailcfg.NodeID, addr netip.Addr) (string, error) {
 	args := readDomainForIPArgs{
 		NodeID: nid,
@@ -267,9 +273,9 @@ func (ipp *ConsensusIPPool) readDomainForIP(nid tailcfg.NodeID, addr netip.Addr)
 		Name: "readDomainForIP",
 		Args: bs,
 	}
-	result, err := ipp.consensus.ExecuteCommand(c)
+	result, err := ipp.consensus.ExecuteQuery(c, tsconsensus.QueryOpts{})
 	if err != nil {
-		log.Printf("readDomainForIP: raft error executing command: %v", err)
+		log.Printf("readDomainForIP: raft error executing query: %v", err)
 		return "", err
 	}
 	if result.Err != nil {
@@ -433,21 +439,41 @@ func (ipp *ConsensusIPPool) Apply(lg *raft.Log) any {
 	case "markLastUsed":
 		return ipp.executeMarkLastUsed(c.Args)
 	case "readDomainForIP":
+		// Reads are now queries (see Query), but logs from before that
+		// may still contain them.
 		return ipp.executeReadDomainForIP(c.Args)
 	default:
 		panic(fmt.Sprintf("unrecognized command: %s", c.Name))
 	}
 }
 
+// Query is part of the tsconsensus.Querier interface. It answers read-only commands
+// from the current state.
+func (ipp *ConsensusIPPool) Query(c tsconsensus.Command) tsconsensus.CommandResult {
+	switch c.Name {
+	case "readDomainForIP":
+		return ipp.executeReadDomainForIP(c.Args)
+	default:
+		return tsconsensus.CommandResult{Err: fmt.Errorf("unrecognized query: %s", c.Name)}
+	}
+}
+
 // commandExecutor is an interface covering the routing parts of consensus
 // used to allow a fake in the tests
 type commandExecutor interface {
 	ExecuteCommand(tsconsensus.Command) (tsconsensus.CommandResult, error)
+	ExecuteQuery(tsconsensus.Command, tsconsensus.QueryOpts) (tsconsensus.CommandResult, error)
 }
 
 type clusterController interface {
 	GetClusterConfiguration() (raft.Configuration, error)
-	DeleteClusterServer(id raft.ServerID) (uint64, error)
+	RemoveServer(id raft.ServerID) (uint64, error)
+	AddNonvoter(id raft.ServerID, host netip.Addr) (uint64, error)
+	TransferLeadership(id raft.ServerID) error
+	TakeSnapshot() (*raft.SnapshotMeta, error)
+	ListSnapshots() ([]*raft.SnapshotMeta, error)
+	WriteBackup(w io.Writer, id string) error
+	RestoreBackup(r io.Reader) error
 }
 
 // GetClusterConfiguration gets the consensus implementation's cluster configuration
@@ -457,5 +483,38 @@ func (ipp *ConsensusIPPool) GetClusterConfiguration() (raft.Configuration, error
 
 // DeleteClusterServer removes a server from the consensus implementation's cluster configuration
 func (ipp *ConsensusIPPool) DeleteClusterServer(id raft.ServerID) (uint64, error) {
-	return ipp.clusterController.DeleteClusterServer(id)
+	return ipp.clusterController.RemoveServer(id)
+}
+
+// AddClusterNonvoter adds a node to the consensus implementation's cluster configuration
+// as a nonvoter, which follows the cluster state but doesn't count towards quorum.
+func (ipp *ConsensusIPPool) AddClusterNonvoter(id raft.ServerID, host netip.Addr) (uint64, error) {
+	return ipp.clusterController.AddNonvoter(id, host)
+}
+
+// TransferClusterLeadership asks the cluster leader to step down in favor of the server
+// with the given ID, or of any up to date voter if id is empty.
+func (ipp *ConsensusIPPool) TransferClusterLeadership(id raft.ServerID) error {
+	return ipp.clusterController.TransferLeadership(id)
+}
+
+// TakeSnapshot takes a snapshot of the pool's state on this node, compacting the consensus log.
+func (ipp *ConsensusIPPool) TakeSnapshot() (*raft.SnapshotMeta, error) {
+	return ipp.clusterController.TakeSnapshot()
+}
+
+// ListSnapshots lists the snapshots stored on this node, newest first.
+func (ipp *ConsensusIPPool) ListSnapshots() ([]*raft.SnapshotMeta, error) {
+	return ipp.clusterController.ListSnapshots()
+}
+
+// WriteBackup writes a backup of the snapshot with the given id, stored on this node, to w.
+func (ipp *ConsensusIPPool) WriteBackup(w io.Writer, id string) error {
+	return ipp.clusterController.WriteBackup(w, id)
+}
+
+// RestoreBackup replaces the state of the pool on every node in the cluster with a backup
+// written by WriteBackup.
+func (ipp *ConsensusIPPool) RestoreBackup(r io.Reader) error {
+	return ipp.clusterController.RestoreBackup(r)
 }
@@ -38,6 +38,10 @@ func (c *FakeConsensus) ExecuteCommand(cmd tsconsensus.Command) (tsconsensus.Com
 	return result.(tsconsensus.CommandResult), nil
 }
 
+func (c *FakeConsensus) ExecuteQuery(cmd tsconsensus.Command, _ tsconsensus.QueryOpts) (tsconsensus.CommandResult, error) {
+	return c.ipp.Query(cmd), nil
+}
+
 func makePool(pfx netip.Prefix) *ConsensusIPPool {
 	ipp := NewConsensusIPPool(makeSetFromPrefix(pfx))
 	ipp.consensus = &FakeConsensus{ipp: ipp}
@@ -20,6 +20,7 @@ import (
 	"net/netip"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
@@ -36,6 +37,7 @@ import (
 	"tailscale.com/hostinfo"
 	"tailscale.com/ipn"
 	"tailscale.com/net/netutil"
+	"tailscale.com/tailcfg"
 	"tailscale.com/tsnet"
 	"tailscale.com/tsweb"
 	"tailscale.com/util/mak"
@@ -49,6 +51,13 @@ func main() {
 		log.Fatal("cmd/natc is a work in progress and has not been security reviewed;\nits use requires TAILSCALE_USE_WIP_CODE=1 be set in the environment for now.")
 	}
 
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		if err := runAdminCommand(os.Args[1], os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	// Parse flags
 	fs := flag.NewFlagSet("natc", flag.ExitOnError)
 	var (
@@ -66,6 +75,7 @@ func main() {
 		stateDir          = fs.String("state-dir", "", "path to directory in which to store app state")
 		clusterFollowOnly = fs.Bool("follow-only", false, "Try to find a leader with the cluster tag or exit.")
 		clusterAdminPort  = fs.Int("cluster-admin-port", 8081, "Port on localhost for the cluster admin HTTP API")
+		clusterAdminCap   = fs.String("cluster-admin-cap", "", "if set, tailnet identities with this peer capability may change the cluster membership via the consensus monitor")
 	)
 	ff.Parse(fs, os.Args[1:], ff.WithEnvVarPrefix("TS_NATC"))
 
@@ -168,9 +178,10 @@ func main() {
 			log.Fatalf("Creating cluster state dir failed: %v", err)
 		}
 		err = cipp.StartConsensus(ctx, ts, ippool.ClusterOpts{
-			Tag:        *clusterTag,
-			StateDir:   clusterStateDir,
-			FollowOnly: *clusterFollowOnly,
+			Tag:             *clusterTag,
+			StateDir:        clusterStateDir,
+			FollowOnly:      *clusterFollowOnly,
+			AdminCapability: tailcfg.PeerCapability(*clusterAdminCap),
 		})
 		if err != nil {
 			log.Fatalf("StartConsensus: %v", err)
@@ -185,7 +196,7 @@ func main() {
 
 		go func() {
 			// This listens on localhost only, so that only those with access to the host machine
-			// can remove servers from the cluster config.
+			// can remove servers from the cluster config, or take and restore backups.
 			log.Print(http.ListenAndServe(fmt.Sprintf("127.0.0.1:%d", *clusterAdminPort), httpClusterAdmin(cipp)))
 		}()
 	} else {
@@ -668,5 +679,77 @@ func httpClusterAdmin(ipp *ippool.ConsensusIPPool) http.Handler {
 			return
 		}
 	})
+	mux.HandleFunc("POST /nonvoters", func(w http.ResponseWriter, r *http.Request) {
+		var params struct {
+			ID   string
+			Host netip.Addr
+		}
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if params.ID == "" || !params.Host.IsValid() {
+			http.Error(w, "Required: ID and Host", http.StatusBadRequest)
+			return
+		}
+		idx, err := ipp.AddClusterNonvoter(raft.ServerID(params.ID), params.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(idx); err != nil {
+			log.Printf("cluster admin http: error encoding add nonvoter index: %v", err)
+		}
+	})
+	mux.HandleFunc("POST /leadership-transfer", func(w http.ResponseWriter, r *http.Request) {
+		// The ID of the server to transfer to is optional.
+		id := raft.ServerID(r.FormValue("id"))
+		if err := ipp.TransferClusterLeadership(id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+	mux.HandleFunc("POST /snapshot", func(w http.ResponseWriter, r *http.Request) {
+		meta, err := ipp.TakeSnapshot()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(meta); err != nil {
+			log.Printf("cluster admin http: error encoding snapshot meta: %v", err)
+		}
+	})
+	mux.HandleFunc("GET /snapshots", func(w http.ResponseWriter, r *http.Request) {
+		metas, err := ipp.ListSnapshots()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(metas); err != nil {
+			log.Printf("cluster admin http: error encoding snapshot list: %v", err)
+		}
+	})
+	mux.HandleFunc("GET /snapshots/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		metas, err := ipp.ListSnapshots()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !slices.ContainsFunc(metas, func(m *raft.SnapshotMeta) bool { return m.ID == id }) {
+			http.Error(w, "snapshot not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		if err := ipp.WriteBackup(w, id); err != nil {
+			log.Printf("cluster admin http: error writing backup of snapshot %s: %v", id, err)
+		}
+	})
+	mux.HandleFunc("POST /restore", func(w http.ResponseWriter, r *http.Request) {
+		if err := ipp.RestoreBackup(r.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
 	return mux
 }
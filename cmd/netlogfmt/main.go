@@ -22,10 +22,22 @@
 //	                100.85.80.41 -> 192.168.0.101:41641   16.00    2.23Ki   10.40      1.40Ki
 //	               100.107.177.2 -> 192.168.0.100:41641    0.80   83.20      0.80     83.20
 //	=========================================================================================
+//
+// Files and directories of network logs, such as those written by tailscaled
+// when configured to keep network flow logs locally, may be given as arguments
+// instead. Gzip compressed files are decompressed.
+//
+// With --group-by, netlogfmt instead sums the traffic by the given fields,
+// and optionally by time window. For example, to show the top ten peers by
+// traffic, and then the traffic with each peer per hour:
+//
+//	$ go run tailscale.com/cmd/netlogfmt --group-by=peer --top=10 /var/lib/tailscale/netlog
+//	$ go run tailscale.com/cmd/netlogfmt --group-by=peer --window=1h /var/lib/tailscale/netlog
 package main
 
 import (
 	"cmp"
+	"compress/gzip"
 	"encoding/base64"
 	"encoding/json"
 	"flag"
@@ -36,6 +48,7 @@ import (
 	"net/http"
 	"net/netip"
 	"os"
+	"path/filepath"
 	"slices"
 	"strconv"
 	"strings"
@@ -59,6 +72,20 @@ var (
 		"Valid values include \"nodeId\", \"name\", or \"user\".")
 	apiKey      = flag.String("api-key", "", "The API key to query the Tailscale API with.\nSee https://login.tailscale.com/admin/settings/keys")
 	tailnetName = flag.String("tailnet-name", "", "The Tailnet name to lookup nodes within.\nSee https://login.tailscale.com/admin/settings/general")
+
+	groupBy = flag.String("group-by", "", "Instead of printing each message, sum the traffic by these comma-separated fields:\n"+
+		"\"peer\", \"src\", \"dst\", \"port\" (the lower of the two ports), \"proto\", or \"traffic\" (its kind).")
+	window  = flag.Duration("window", 0, "With --group-by, also sum the traffic by time windows of this length, such as 1h.")
+	top     = flag.Int("top", 0, "With --group-by, only print this many rows with the most bytes (per window).")
+	traffic = flag.String("traffic", "virtual,subnet,exit", "With --group-by, the comma-separated kinds of traffic to include:\n"+
+		"\"virtual\", \"subnet\", \"exit\", or \"physical\".")
+	since = flag.String("since", "", "Only include messages starting at or after this RFC 3339 time.")
+	until = flag.String("until", "", "Only include messages starting before this RFC 3339 time.")
+)
+
+var (
+	agg                  *aggregator // non-nil with --group-by
+	sinceTime, untilTime time.Time
 )
 
 var (
@@ -88,22 +115,104 @@ func main() {
 		log.Fatalf("--resolve-addrs must be \"nodeId\", \"name\", or \"user\"")
 	}
 
+	var err error
+	if *since != "" {
+		if sinceTime, err = time.Parse(time.RFC3339, *since); err != nil {
+			log.Fatalf("--since: %v", err)
+		}
+	}
+	if *until != "" {
+		if untilTime, err = time.Parse(time.RFC3339, *until); err != nil {
+			log.Fatalf("--until: %v", err)
+		}
+	}
+	if *groupBy != "" {
+		if agg, err = newAggregator(*groupBy, *traffic, *window); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	mustLoadTailnetNodes()
 
 	// The logic handles a stream of arbitrary JSON.
 	// So long as a JSON object seems like a network log message,
 	// then this will unmarshal and print it.
-	if err := processStream(os.Stdin); err != nil {
-		if err == io.EOF {
-			return
+	if flag.NArg() == 0 {
+		if err := processStream(os.Stdin); err != nil {
+			log.Fatalf("processStream: %v", err)
+		}
+	}
+	for _, path := range flag.Args() {
+		if err := processPath(path); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if agg != nil {
+		must.Do(agg.print(os.Stdout, *top))
+	}
+}
+
+// processPath processes the network log file at path, or if path is a
+// directory, the log files within it whose names contain "netlog", in order.
+func processPath(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return processFile(path)
+	}
+	des, err := os.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, de := range des { // sorted by name, and so in order for rotated files
+		if de.Type().IsRegular() && strings.Contains(de.Name(), "netlog") && isLogFile(de.Name()) {
+			if err := processFile(filepath.Join(path, de.Name())); err != nil {
+				return err
+			}
 		}
-		log.Fatalf("processStream: %v", err)
 	}
+	return nil
 }
 
+// isLogFile reports whether name has the extension of a JSON log file,
+// optionally gzip compressed. It excludes other files, such as the
+// temporary files written while compressing rotated files.
+func isLogFile(name string) bool {
+	name = strings.TrimSuffix(name, ".gz")
+	return strings.HasSuffix(name, ".json") || strings.HasSuffix(name, ".jsonl")
+}
+
+func processFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		r = zr
+	}
+	if err := processStream(r); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// processStream processes a stream of JSON values from r until EOF.
 func processStream(r io.Reader) (err error) {
+	defer func() {
+		if err == io.EOF {
+			err = nil
+		}
+	}()
 	defer try.Handle(&err)
-	dec := jsontext.NewDecoder(os.Stdin)
+	dec := jsontext.NewDecoder(r)
 	for {
 		processValue(dec)
 	}
@@ -158,7 +267,14 @@ func processObject(dec *jsontext.Decoder) {
 	if hasTraffic {
 		var msg message
 		try.E(jsonv2.Unmarshal(rawMsg, &msg))
-		printMessage(msg)
+		switch {
+		case !sinceTime.IsZero() && msg.Start.Before(sinceTime):
+		case !untilTime.IsZero() && !msg.Start.Before(untilTime):
+		case agg != nil:
+			agg.add(msg)
+		default:
+			printMessage(msg)
+		}
 	}
 }
 
@@ -187,15 +303,7 @@ func printMessage(msg message) {
 		insertNode(msg.SrcNode)
 
 		// Derive the Tailnet DNS of the self node.
-		detectTailnetDNS := func(nodeName string) {
-			if prefix, ok := strings.CutSuffix(nodeName, ".ts.net"); ok {
-				if i := strings.LastIndexByte(prefix, '.'); i > 0 {
-					tailnetDNS = nodeName[i:]
-				}
-			}
-		}
-		detectTailnetDNS(msg.SrcNode.Name)
-		detectTailnetDNS(tailnetNodesByID[msg.NodeID].Name)
+		tailnetDNS = cmp.Or(tailnetDNSOf(tailnetNodesByID[msg.NodeID].Name), tailnetDNSOf(msg.SrcNode.Name))
 	}
 
 	// Construct a table of network traffic per connection.
@@ -228,21 +336,7 @@ func printMessage(msg message) {
 			if !a.IsValid() {
 				return ""
 			}
-			name := a.Addr().String()
-			node, ok := tailnetNodesByAddr[a.Addr()]
-			if !ok {
-				node, ok = nodesByAddr[a.Addr()]
-			}
-			if ok {
-				switch *resolveAddrs {
-				case "nodeid":
-					name = cmp.Or(string(node.NodeID), name)
-				case "name":
-					name = cmp.Or(strings.TrimSuffix(string(node.Name), tailnetDNS), name)
-				case "user":
-					name = cmp.Or(bools.IfElse(len(node.Tags) > 0, fmt.Sprint(node.Tags), node.User), name)
-				}
-			}
+			name := resolveAddr(a.Addr(), nodesByAddr, tailnetDNS)
 			if a.Port() != 0 {
 				return name + ":" + strconv.Itoa(int(a.Port()))
 			}
@@ -341,6 +435,38 @@ func printMessage(msg message) {
 	}
 }
 
+// tailnetDNSOf returns the tailnet DNS suffix of a node name,
+// such as ".acme-corp.ts.net", or "" if it has none.
+func tailnetDNSOf(nodeName string) string {
+	if prefix, ok := strings.CutSuffix(nodeName, ".ts.net"); ok {
+		if i := strings.LastIndexByte(prefix, '.'); i > 0 {
+			return nodeName[i:]
+		}
+	}
+	return ""
+}
+
+// resolveAddr returns the name of addr according to --resolve-addrs,
+// using the nodes from the Tailscale API or else those in nodesByAddr.
+func resolveAddr(addr netip.Addr, nodesByAddr map[netip.Addr]netlogtype.Node, tailnetDNS string) string {
+	name := addr.String()
+	node, ok := tailnetNodesByAddr[addr]
+	if !ok {
+		node, ok = nodesByAddr[addr]
+	}
+	if ok {
+		switch *resolveAddrs {
+		case "nodeid":
+			name = cmp.Or(string(node.NodeID), name)
+		case "name":
+			name = cmp.Or(strings.TrimSuffix(string(node.Name), tailnetDNS), name)
+		case "user":
+			name = cmp.Or(bools.IfElse(len(node.Tags) > 0, fmt.Sprint(node.Tags), node.User), name)
+		}
+	}
+	return name
+}
+
 func mustLoadTailnetNodes() {
 	switch {
 	case *apiKey == "" && *tailnetName == "":
@@ -0,0 +1,27 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import "testing"
+
+func TestIsLogFile(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"netlog.json", true},
+		{"netlog.jsonl", true},
+		{"netlog-20250102T030405.000000000Z.jsonl", true},
+		{"netlog-20250102T030405.000000000Z.jsonl.gz", true},
+		{"netlog.json.gz", true},
+		{"netlog-20250102T030405.000000000Z.jsonl.gz.tmp", false},
+		{"netlog.txt", false},
+		{"netlog.gz", false},
+	}
+	for _, tt := range tests {
+		if got := isLogFile(tt.name); got != tt.want {
+			t.Errorf("isLogFile(%q) = %v; want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"tailscale.com/types/netlogtype"
+)
+
+// groupByFields are the valid values of --group-by.
+var groupByFields = []string{"peer", "src", "dst", "port", "proto", "traffic"}
+
+// trafficKinds are the valid values of --traffic.
+var trafficKinds = []string{"virtual", "subnet", "exit", "physical"}
+
+// aggregator sums the traffic counts in network log messages by time window
+// and by the connection fields in groupBy.
+type aggregator struct {
+	groupBy []string        // elements of groupByFields
+	window  time.Duration   // or zero for a single window
+	traffic map[string]bool // kinds of traffic to include, by trafficKinds
+
+	sums map[aggKey]netlogtype.Counts
+
+	// nodesByAddr is the node information embedded in the messages seen so far.
+	// selfAddrs are the addresses of the logging nodes seen so far.
+	nodesByAddr map[netip.Addr]netlogtype.Node
+	selfAddrs   map[netip.Addr]bool
+	tailnetDNS  string
+}
+
+type aggKey struct {
+	start  time.Time // of the window
+	fields string    // values of the groupBy fields, joined by "\t"
+}
+
+func newAggregator(groupBy, traffic string, window time.Duration) (*aggregator, error) {
+	a := &aggregator{
+		window:      window,
+		traffic:     make(map[string]bool),
+		sums:        make(map[aggKey]netlogtype.Counts),
+		nodesByAddr: make(map[netip.Addr]netlogtype.Node),
+		selfAddrs:   make(map[netip.Addr]bool),
+	}
+	for f := range strings.SplitSeq(groupBy, ",") {
+		f = strings.ToLower(strings.TrimSpace(f))
+		if !slices.Contains(groupByFields, f) {
+			return nil, fmt.Errorf("invalid --group-by field %q; want one of %s", f, strings.Join(groupByFields, ", "))
+		}
+		a.groupBy = append(a.groupBy, f)
+	}
+	for k := range strings.SplitSeq(traffic, ",") {
+		k = strings.ToLower(strings.TrimSpace(k))
+		if !slices.Contains(trafficKinds, k) {
+			return nil, fmt.Errorf("invalid --traffic kind %q; want one of %s", k, strings.Join(trafficKinds, ", "))
+		}
+		a.traffic[k] = true
+	}
+	if window < 0 {
+		return nil, fmt.Errorf("invalid --window %v", window)
+	}
+	return a, nil
+}
+
+// add adds the traffic counts in msg.
+func (a *aggregator) add(msg message) {
+	for _, node := range msg.DstNodes {
+		for _, addr := range node.Addresses {
+			a.nodesByAddr[addr] = node
+		}
+	}
+	for _, addr := range msg.SrcNode.Addresses {
+		a.nodesByAddr[addr] = msg.SrcNode
+		a.selfAddrs[addr] = true
+	}
+	if a.tailnetDNS == "" {
+		a.tailnetDNS = tailnetDNSOf(msg.SrcNode.Name)
+	}
+
+	var start time.Time
+	if a.window > 0 {
+		start = msg.Start.Truncate(a.window)
+	}
+	for _, t := range []struct {
+		kind    string
+		traffic []netlogtype.ConnectionCounts
+	}{
+		{"virtual", msg.VirtualTraffic},
+		{"subnet", msg.SubnetTraffic},
+		{"exit", msg.ExitTraffic},
+		{"physical", msg.PhysicalTraffic},
+	} {
+		if !a.traffic[t.kind] {
+			continue
+		}
+		for _, cc := range t.traffic {
+			k := aggKey{start: start, fields: a.fieldsOf(t.kind, cc.Connection)}
+			a.sums[k] = a.sums[k].Add(cc.Counts)
+		}
+	}
+}
+
+// fieldsOf returns the aggKey.fields for a connection of the given
+// kind of traffic.
+func (a *aggregator) fieldsOf(kind string, c netlogtype.Connection) string {
+	vals := make([]string, len(a.groupBy))
+	for i, f := range a.groupBy {
+		switch f {
+		case "peer":
+			// The peer is whichever end isn't the logging node. Physical
+			// traffic is always from the Tailscale IP of the peer.
+			peer := c.Dst.Addr()
+			if kind == "physical" || (a.selfAddrs[c.Dst.Addr()] && !a.selfAddrs[c.Src.Addr()]) {
+				peer = c.Src.Addr()
+			}
+			vals[i] = a.addrName(peer)
+		case "src":
+			vals[i] = a.addrName(c.Src.Addr())
+		case "dst":
+			vals[i] = a.addrName(c.Dst.Addr())
+		case "port":
+			// The lower of the two ports is usually the service port.
+			p := min(c.Src.Port(), c.Dst.Port())
+			if p == 0 {
+				p = max(c.Src.Port(), c.Dst.Port())
+			}
+			vals[i] = strconv.Itoa(int(p))
+		case "proto":
+			vals[i] = c.Proto.String()
+		case "traffic":
+			vals[i] = kind
+		}
+	}
+	return strings.Join(vals, "\t")
+}
+
+func (a *aggregator) addrName(addr netip.Addr) string {
+	if !addr.IsValid() {
+		return "-"
+	}
+	return resolveAddr(addr, a.nodesByAddr, a.tailnetDNS)
+}
+
+// aggRow is a row of aggregated output.
+type aggRow struct {
+	aggKey
+	netlogtype.Counts
+}
+
+// rows returns the sums in order of window, and then by total bytes,
+// descending, keeping only the top rows of each window if top is positive.
+func (a *aggregator) rows(top int) []aggRow {
+	var rows []aggRow
+	for k, c := range a.sums {
+		rows = append(rows, aggRow{k, c})
+	}
+	slices.SortFunc(rows, func(x, y aggRow) int {
+		return cmp.Or(
+			x.start.Compare(y.start),
+			cmp.Compare(y.TxBytes+y.RxBytes, x.TxBytes+x.RxBytes),
+			cmp.Compare(x.fields, y.fields),
+		)
+	})
+	if top <= 0 {
+		return rows
+	}
+	var ret []aggRow
+	var n int
+	for i, r := range rows {
+		if i == 0 || !r.start.Equal(rows[i-1].start) {
+			n = 0
+		}
+		if n < top {
+			ret = append(ret, r)
+		}
+		n++
+	}
+	return ret
+}
+
+// print writes a table of the aggregated rows to w.
+func (a *aggregator) print(w io.Writer, top int) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	var hdr []string
+	if a.window > 0 {
+		hdr = append(hdr, "WINDOW")
+	}
+	for _, f := range a.groupBy {
+		hdr = append(hdr, strings.ToUpper(f))
+	}
+	hdr = append(hdr, "TX PKTS", "TX BYTES", "RX PKTS", "RX BYTES")
+	fmt.Fprintln(tw, strings.Join(hdr, "\t"))
+	for _, r := range a.rows(top) {
+		if a.window > 0 {
+			fmt.Fprintf(tw, "%s\t", r.start.In(time.Local).Format("2006-01-02 15:04:05"))
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%d\t%s\n", r.fields,
+			r.TxPackets, formatIEC(float64(r.TxBytes)),
+			r.RxPackets, formatIEC(float64(r.RxBytes)))
+	}
+	return tw.Flush()
+}
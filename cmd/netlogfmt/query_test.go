@@ -0,0 +1,109 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"net/netip"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/types/ipproto"
+	"tailscale.com/types/netlogtype"
+)
+
+func TestAggregator(t *testing.T) {
+	self := netip.MustParseAddr("100.64.0.1")
+	peer1 := netip.MustParseAddr("100.64.0.2")
+	peer2 := netip.MustParseAddr("100.64.0.3")
+	t0 := time.Date(2025, 1, 2, 3, 0, 0, 0, time.UTC)
+	msg := func(start time.Time, traffic ...netlogtype.ConnectionCounts) message {
+		var m message
+		m.Start, m.End = start, start.Add(5*time.Second)
+		m.SrcNode = netlogtype.Node{NodeID: "nSELF", Addresses: []netip.Addr{self}}
+		m.VirtualTraffic = traffic
+		return m
+	}
+	cc := func(src, dst netip.AddrPort, txBytes, rxBytes uint64) netlogtype.ConnectionCounts {
+		return netlogtype.ConnectionCounts{
+			Connection: netlogtype.Connection{Proto: ipproto.TCP, Src: src, Dst: dst},
+			Counts:     netlogtype.Counts{TxPackets: 1, TxBytes: txBytes, RxPackets: 1, RxBytes: rxBytes},
+		}
+	}
+	msgs := []message{
+		msg(t0,
+			cc(netip.AddrPortFrom(self, 22), netip.AddrPortFrom(peer1, 50000), 100, 10),
+			cc(netip.AddrPortFrom(self, 40000), netip.AddrPortFrom(peer2, 443), 5, 500),
+		),
+		msg(t0.Add(30*time.Minute),
+			cc(netip.AddrPortFrom(self, 22), netip.AddrPortFrom(peer1, 50001), 100, 10),
+		),
+		msg(t0.Add(90*time.Minute),
+			cc(netip.AddrPortFrom(self, 22), netip.AddrPortFrom(peer1, 50002), 1, 1),
+		),
+	}
+
+	tests := []struct {
+		name    string
+		groupBy string
+		window  time.Duration
+		top     int
+		want    []string // fields, window start offset from t0, and total bytes of each row
+	}{
+		{
+			name:    "peer",
+			groupBy: "peer",
+			want:    []string{"100.64.0.3 505", "100.64.0.2 222"},
+		},
+		{
+			name:    "top-peer",
+			groupBy: "peer",
+			top:     1,
+			want:    []string{"100.64.0.3 505"},
+		},
+		{
+			name:    "port-proto",
+			groupBy: "port,proto",
+			want:    []string{"443\tTCP 505", "22\tTCP 222"},
+		},
+		{
+			name:    "peer-per-hour",
+			groupBy: "peer",
+			window:  time.Hour,
+			want:    []string{"0s 100.64.0.3 505", "0s 100.64.0.2 220", "1h0m0s 100.64.0.2 2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := newAggregator(tt.groupBy, "virtual", tt.window)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, m := range msgs {
+				a.add(m)
+			}
+			var got []string
+			for _, r := range a.rows(tt.top) {
+				s := r.fields + " " + strconv.FormatUint(r.TxBytes+r.RxBytes, 10)
+				if tt.window > 0 {
+					s = r.start.Sub(t0).String() + " " + s
+				}
+				got = append(got, s)
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("rows = %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAggregatorInvalid(t *testing.T) {
+	if _, err := newAggregator("peer,bogus", "virtual", 0); err == nil {
+		t.Error("unexpected success with invalid --group-by")
+	}
+	if _, err := newAggregator("peer", "virtual,bogus", 0); err == nil {
+		t.Error("unexpected success with invalid --traffic")
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"os"
 	"runtime"
 	"runtime/debug"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -113,6 +114,18 @@ func debugCmd() *ffcli.Command {
 				Exec:       runDaemonBusEvents,
 				ShortHelp:  "Watch events on the tailscaled bus",
 			},
+			{
+				Name:       "netlog",
+				ShortUsage: "tailscale debug netlog",
+				Exec:       runDebugNetLog,
+				ShortHelp:  "Stream network flow log records as JSON lines",
+				LongHelp: strings.TrimSpace(`
+The 'tailscale debug netlog' command records the node's network flows until
+it's interrupted, printing a record (see tailscale.com/types/netlogtype.Message)
+every few seconds in which there was traffic. The output can be piped to
+"go run tailscale.com/cmd/netlogfmt" to format or summarize it.
+`),
+			},
 			{
 				Name:       "daemon-bus-graph",
 				ShortUsage: "tailscale debug daemon-bus-graph",
@@ -353,6 +366,26 @@ func debugCmd() *ffcli.Command {
 					return fs
 				})(),
 			},
+			{
+				Name:       "filter-check",
+				ShortUsage: "tailscale debug filter-check [--proto=tcp] [--dst=IP] <src-hostname-or-IP> <port>",
+				Exec:       runDebugFilterCheck,
+				ShortHelp:  "Print whether and why the packet filter allows a new incoming connection",
+				LongHelp: strings.TrimSpace(`
+The 'tailscale debug filter-check' command evaluates a synthetic new
+connection from the given peer (or IP) to this node against the current
+packet filter, as if it had arrived over Tailscale. It prints the verdict
+and, if a rule from the tailnet policy allowed it, which rule and which of
+its sources and destinations matched.
+`),
+				FlagSet: (func() *flag.FlagSet {
+					fs := newFlagSet("filter-check")
+					fs.StringVar(&debugFilterCheckArgs.proto, "proto", "tcp", `IP protocol name or number ("tcp", "udp", "icmp", etc.)`)
+					fs.StringVar(&debugFilterCheckArgs.dst, "dst", "", "destination IP, such as a subnet route address (default: this node's Tailscale IP)")
+					fs.BoolVar(&debugFilterCheckArgs.json, "json", false, "output in JSON")
+					return fs
+				})(),
+			},
 			{
 				Name:       "resolve",
 				ShortUsage: "tailscale debug resolve <hostname>",
@@ -828,6 +861,22 @@ func runDaemonBusEvents(ctx context.Context, args []string) error {
 	return nil
 }
 
+func runDebugNetLog(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	rc, err := localClient.StreamNetLog(ctx)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	_, err = io.Copy(Stdout, rc)
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
 var daemonBusGraphArgs struct {
 	format string
 }
@@ -1304,6 +1353,89 @@ func debugControlKnobs(ctx context.Context, args []string) error {
 	return nil
 }
 
+var debugFilterCheckArgs struct {
+	proto string
+	dst   string
+	json  bool
+}
+
+func runDebugFilterCheck(ctx context.Context, args []string) error {
+	if len(args) != 2 || args[0] == "" || args[1] == "" {
+		return errors.New("usage: tailscale debug filter-check [--proto=tcp] [--dst=IP] <src-hostname-or-IP> <port>")
+	}
+	port, err := strconv.ParseUint(args[1], 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port %q: %w", args[1], err)
+	}
+	var dst netip.Addr
+	if debugFilterCheckArgs.dst != "" {
+		dst, err = netip.ParseAddr(debugFilterCheckArgs.dst)
+		if err != nil {
+			return fmt.Errorf("invalid --dst: %w", err)
+		}
+	}
+	hostOrIP := args[0]
+	ip, _, err := tailscaleIPFromArg(ctx, hostOrIP)
+	if err != nil {
+		return err
+	}
+	src, err := netip.ParseAddr(ip)
+	if err != nil {
+		return err
+	}
+	if dst.IsValid() && dst.Is4() != src.Is4() {
+		// Peers are looked up by their IPv4 address; use the other one.
+		st, err := localClient.Status(ctx)
+		if err != nil {
+			return fixTailscaledConnectError(err)
+		}
+		for _, ps := range st.Peer {
+			if slices.Contains(ps.TailscaleIPs, src) {
+				for _, a := range ps.TailscaleIPs {
+					if a.Is4() == dst.Is4() {
+						src = a
+					}
+				}
+			}
+		}
+	}
+	if src.String() != hostOrIP {
+		log.Printf("lookup %q => %q", hostOrIP, src)
+	}
+
+	res, err := localClient.DebugFilterCheck(ctx, src, dst, uint16(port), debugFilterCheckArgs.proto)
+	if err != nil {
+		return err
+	}
+	if debugFilterCheckArgs.json {
+		j, err := json.MarshalIndent(res, "", "\t")
+		if err != nil {
+			return err
+		}
+		outln(string(j))
+		return nil
+	}
+	printf("%s (%s)\n", res.Response, res.Why)
+	if res.RuleIndex < 0 {
+		return nil
+	}
+	printf("rule #%d\n", res.RuleIndex)
+	if res.Rule != nil {
+		j, err := json.MarshalIndent(res.Rule, "  ", "\t")
+		if err != nil {
+			return err
+		}
+		printf("  %s\n", j)
+	}
+	if res.MatchedSrcCap != "" {
+		printf("matched src: node with capability %q\n", res.MatchedSrcCap)
+	} else {
+		printf("matched src: %s\n", res.MatchedSrc)
+	}
+	printf("matched dst: %s\n", res.MatchedDst)
+	return nil
+}
+
 var debugDialTypesArgs struct {
 	network string
 }
@@ -6,12 +6,16 @@
 package cli
 
 import (
+	"archive/tar"
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"mime"
 	"net/http"
@@ -70,8 +74,8 @@ func (c *countingReader) Read(buf []byte) (int, error) {
 
 var fileCpCmd = &ffcli.Command{
 	Name:       "cp",
-	ShortUsage: "tailscale file cp <files...> <target>:",
-	ShortHelp:  "Copy file(s) to a host",
+	ShortUsage: "tailscale file cp <files-or-directories...> <target>:",
+	ShortHelp:  "Copy file(s) or directories to a host",
 	Exec:       runCp,
 	FlagSet: (func() *flag.FlagSet {
 		fs := newFlagSet("cp")
@@ -158,7 +162,14 @@ func runCp(ctx context.Context, args []string) error {
 				return err
 			}
 			if fi.IsDir() {
-				return errors.New("directories not supported")
+				f.Close()
+				if name == "" {
+					name = filepath.Base(filepath.Clean(fileArg))
+				}
+				if err := sendDir(ctx, stableID, fileArg, name); err != nil {
+					return err
+				}
+				continue
 			}
 			contentLength = fi.Size()
 			fileContents = &countingReader{Reader: io.LimitReader(f, contentLength)}
@@ -195,6 +206,101 @@ func runCp(ctx context.Context, args []string) error {
 	return nil
 }
 
+// dirManifest returns the manifest for sending the directory at dir as name,
+// computing the SHA-256 of each regular file within it.
+// Other file types, such as symlinks, are skipped.
+func dirManifest(dir, name string) (apitype.DirManifest, error) {
+	m := apitype.DirManifest{Name: name}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			if !d.IsDir() && cpArgs.verbose {
+				log.Printf("skipping %q: not a regular file", p)
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		h := sha256.New()
+		n, err := io.Copy(h, f)
+		if err != nil {
+			return err
+		}
+		m.Files = append(m.Files, apitype.DirManifestFile{
+			Path:   filepath.ToSlash(rel),
+			Size:   n,
+			SHA256: hex.EncodeToString(h.Sum(nil)),
+		})
+		return nil
+	})
+	return m, err
+}
+
+// sendDir sends the directory tree at dir to stableID as name.
+func sendDir(ctx context.Context, stableID tailcfg.StableNodeID, dir, name string) error {
+	if cpArgs.verbose {
+		log.Printf("hashing %q ...", dir)
+	}
+	manifest, err := dirManifest(dir, name)
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, f := range manifest.Files {
+		total += f.Size
+	}
+	if cpArgs.verbose {
+		log.Printf("sending directory %q (%d files, %d bytes) ...", name, len(manifest.Files), total)
+	}
+
+	var sent atomic.Int64
+	var group sync.WaitGroup
+	ctxProgress, cancelProgress := context.WithCancel(ctx)
+	defer cancelProgress()
+	if isatty.IsTerminal(os.Stderr.Fd()) {
+		group.Go(func() { progressPrinter(ctxProgress, name+"/", sent.Load, total) })
+	}
+
+	err = localClient.PushDir(ctx, stableID, manifest, func(f apitype.DirManifestFile) (io.ReadCloser, error) {
+		fh, err := os.Open(filepath.Join(dir, filepath.FromSlash(f.Path)))
+		if err != nil {
+			return nil, err
+		}
+		cr := &countingReader{Reader: io.LimitReader(fh, f.Size)}
+		return struct {
+			io.Reader
+			io.Closer
+		}{readFunc(func(p []byte) (int, error) {
+			n, err := cr.Read(p)
+			sent.Add(int64(n))
+			return n, err
+		}), fh}, nil
+	})
+	cancelProgress()
+	group.Wait() // wait for progress printer to stop before reporting the error
+	if err != nil {
+		return err
+	}
+	if cpArgs.verbose {
+		log.Printf("sent directory %q", name)
+	}
+	return nil
+}
+
+// readFunc implements io.Reader using a function.
+type readFunc func([]byte) (int, error)
+
+func (f readFunc) Read(p []byte) (int, error) { return f(p) }
+
 func progressPrinter(ctx context.Context, name string, contentCount func() int64, contentLength int64) {
 	var rateValueFast, rateValueSlow tsrate.Value
 	rateValueFast.HalfLife = 1 * time.Second  // fast response for rate measurement
@@ -541,6 +647,92 @@ func receiveFile(ctx context.Context, wf apitype.WaitingFile, dir string) (targe
 	return f.Name(), size, f.Close()
 }
 
+// receiveDir moves the received directory wf out of the inbox into dir.
+// The tree is extracted into a temporary directory first and renamed
+// into place once complete.
+func receiveDir(ctx context.Context, wf apitype.WaitingFile, dir string) (targetDir string, size int64, err error) {
+	rc, err := localClient.GetWaitingDir(ctx, wf.Name)
+	if err != nil {
+		return "", 0, fmt.Errorf("opening inbox directory %q: %w", wf.Name, err)
+	}
+	defer rc.Close()
+
+	tmp, err := os.MkdirTemp(dir, ".taildrop-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.RemoveAll(tmp)
+
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", 0, fmt.Errorf("reading inbox directory %q: %w", wf.Name, err)
+		}
+		if !filepath.IsLocal(hdr.Name) {
+			return "", 0, fmt.Errorf("invalid path %q in inbox directory %q", hdr.Name, wf.Name)
+		}
+		dst := filepath.Join(tmp, filepath.FromSlash(hdr.Name))
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(dst, 0755); err != nil {
+				return "", 0, err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+				return "", 0, err
+			}
+			f, err := os.OpenFile(dst, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+			if err != nil {
+				return "", 0, err
+			}
+			if err := quarantine.SetOnFile(f); err != nil {
+				f.Close()
+				return "", 0, fmt.Errorf("failed to apply quarantine attribute to file %v: %v", f.Name(), err)
+			}
+			n, err := io.Copy(f, tr)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return "", 0, fmt.Errorf("failed to write %v: %v", dst, err)
+			}
+			size += n
+		default:
+			return "", 0, fmt.Errorf("unexpected file type for %q in inbox directory %q", hdr.Name, wf.Name)
+		}
+	}
+
+	targetDir = filepath.Join(dir, wf.Name)
+	switch getArgs.conflict {
+	case skipOnExist:
+		if _, err := os.Lstat(targetDir); err == nil {
+			return "", 0, fmt.Errorf("refusing to overwrite directory %v", targetDir)
+		}
+	case overwriteExisting:
+		if err := os.RemoveAll(targetDir); err != nil {
+			return "", 0, fmt.Errorf("unable to remove target directory: %w", err)
+		}
+	case createNumberedFiles:
+		for i := 1; ; i++ {
+			if _, err := os.Lstat(targetDir); os.IsNotExist(err) {
+				break
+			}
+			if i == 100 {
+				return "", 0, fmt.Errorf("unable to find a name for writing %v", filepath.Join(dir, wf.Name))
+			}
+			targetDir = numberedFileName(dir, wf.Name, i)
+		}
+	}
+	if err := os.Rename(tmp, targetDir); err != nil {
+		return "", 0, err
+	}
+	return targetDir, size, nil
+}
+
 func runFileGetOneBatch(ctx context.Context, dir string) []error {
 	var wfs []apitype.WaitingFile
 	var err error
@@ -570,7 +762,11 @@ func runFileGetOneBatch(ctx context.Context, dir string) []error {
 			errs = append(errs, fmt.Errorf("too many errors in runFileGetOneBatch(). %d files unexamined", len(wfs)-i))
 			break
 		}
-		writtenFile, size, err := receiveFile(ctx, wf, dir)
+		receive := receiveFile
+		if wf.IsDir {
+			receive = receiveDir
+		}
+		writtenFile, size, err := receive(ctx, wf, dir)
 		if err != nil {
 			errs = append(errs, err)
 			continue
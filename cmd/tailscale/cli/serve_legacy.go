@@ -23,6 +23,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"tailscale.com/client/local"
@@ -141,6 +142,7 @@ func (e *serveEnv) newFlags(name string, setup func(fs *flag.FlagSet)) *flag.Fla
 //
 // The purpose of this interface is to allow tests to provide a mock.
 type localServeClient interface {
+	Status(context.Context) (*ipnstate.Status, error)
 	StatusWithoutPeers(context.Context) (*ipnstate.Status, error)
 	GetServeConfig(context.Context) (*ipn.ServeConfig, error)
 	SetServeConfig(context.Context, *ipn.ServeConfig) error
@@ -150,6 +152,7 @@ type localServeClient interface {
 	GetPrefs(ctx context.Context) (*ipn.Prefs, error)
 	EditPrefs(ctx context.Context, mp *ipn.MaskedPrefs) (*ipn.Prefs, error)
 	CheckSOMarkInUse(ctx context.Context) (bool, error)
+	GetServeBackendHealth(ctx context.Context) (map[string][]ipn.ServeBackendHealth, error)
 }
 
 // serveEnv is the environment the serve command runs within. All I/O should be
@@ -163,19 +166,31 @@ type serveEnv struct {
 	json bool // output JSON (status only for now)
 
 	// v2 specific flags
-	bg               bgBoolFlag               // background mode
-	setPath          string                   // serve path
-	https            uint                     // HTTP port
-	http             uint                     // HTTP port
-	tcp              uint                     // TCP port
-	tlsTerminatedTCP uint                     // a TLS terminated TCP port
-	proxyProtocol    uint                     // PROXY protocol version (1 or 2)
-	subcmd           serveMode                // subcommand
-	yes              bool                     // update without prompt
-	service          tailcfg.ServiceName      // service name
-	tun              bool                     // redirect traffic to OS for service
-	allServices      bool                     // apply config file to all services
-	acceptAppCaps    []tailcfg.PeerCapability // app capabilities to forward
+	bg                  bgBoolFlag               // background mode
+	setPath             string                   // serve path
+	https               uint                     // HTTP port
+	http                uint                     // HTTP port
+	tcp                 uint                     // TCP port
+	tlsTerminatedTCP    uint                     // a TLS terminated TCP port
+	proxyProtocol       uint                     // PROXY protocol version (1 or 2)
+	subcmd              serveMode                // subcommand
+	yes                 bool                     // update without prompt
+	service             tailcfg.ServiceName      // service name
+	tun                 bool                     // redirect traffic to OS for service
+	allServices         bool                     // apply config file to all services
+	acceptAppCaps       []tailcfg.PeerCapability // app capabilities to forward
+	lbPolicy            string                   // load balancing policy for multiple targets
+	healthCheckInterval time.Duration            // active health check interval for multiple targets
+	healthCheckPath     string                   // active health check path for multiple HTTP targets
+	stripPrefix         string                   // path prefix to strip from proxied requests
+	hostHeader          string                   // Host header of proxied requests
+	requestHeaders      ipn.HeaderRules          // rewrites of proxied request headers
+	responseHeaders     ipn.HeaderRules          // rewrites of response headers
+	allowUsers          string                   // comma-separated users allowed to use the handler
+	allowGroups         string                   // comma-separated groups allowed to use the handler
+	allowTags           string                   // comma-separated tags allowed to use the handler
+	allowCaps           []tailcfg.PeerCapability // peer capabilities allowed to use the handler
+	funnelAccess        string                   // access policy for Funnel requests
 
 	lc localServeClient // localClient interface, specific to serve
 	// optional stuff for tests:
@@ -636,14 +651,22 @@ func (e *serveEnv) runServeStatus(ctx context.Context, args []string) error {
 	if err != nil {
 		return err
 	}
+	var health map[string][]ipn.ServeBackendHealth
+	if hasBackendPools(sc) {
+		health, err = e.lc.GetServeBackendHealth(ctx)
+		if err != nil {
+			// Still show the config, without backend health.
+			fmt.Fprintf(e.stderr(), "error getting serve backend health: %v\n", err)
+		}
+	}
 	if sc.IsTCPForwardingAny() {
-		if err := printTCPStatusTree(ctx, sc, st); err != nil {
+		if err := printTCPStatusTree(ctx, sc, st, health); err != nil {
 			return err
 		}
 		printf("\n")
 	}
 	for hp := range sc.Web {
-		err := e.printWebStatusTree(sc, hp)
+		err := e.printWebStatusTree(sc, hp, health)
 		if err != nil {
 			return err
 		}
@@ -653,10 +676,48 @@ func (e *serveEnv) runServeStatus(ctx context.Context, args []string) error {
 	return nil
 }
 
-func printTCPStatusTree(ctx context.Context, sc *ipn.ServeConfig, st *ipnstate.Status) error {
+// hasBackendPools reports whether any handler in sc has multiple backends.
+func hasBackendPools(sc *ipn.ServeConfig) bool {
+	for _, h := range sc.TCP {
+		if len(h.TCPForwardBackends) > 0 {
+			return true
+		}
+	}
+	for _, conf := range sc.Web {
+		for _, h := range conf.Handlers {
+			if len(h.Backends) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// backendHealthStatus returns a short description of the health of backend
+// in health, the health of its pool, for "serve status".
+func backendHealthStatus(health []ipn.ServeBackendHealth, backend string) string {
+	for _, h := range health {
+		if h.Backend != backend {
+			continue
+		}
+		if !h.Healthy {
+			if h.LastError != "" {
+				return fmt.Sprintf("unhealthy: %s", h.LastError)
+			}
+			return "unhealthy"
+		}
+		if h.Conns > 0 {
+			return fmt.Sprintf("healthy, %d active", h.Conns)
+		}
+		return "healthy"
+	}
+	return "unknown health"
+}
+
+func printTCPStatusTree(ctx context.Context, sc *ipn.ServeConfig, st *ipnstate.Status, health map[string][]ipn.ServeBackendHealth) error {
 	dnsName := strings.TrimSuffix(st.Self.DNSName, ".")
 	for p, h := range sc.TCP {
-		if h.TCPForward == "" {
+		if h.TCPForward == "" && len(h.TCPForwardBackends) == 0 {
 			continue
 		}
 		hp := ipn.HostPort(net.JoinHostPort(dnsName, strconv.Itoa(int(p))))
@@ -673,12 +734,18 @@ func printTCPStatusTree(ctx context.Context, sc *ipn.ServeConfig, st *ipnstate.S
 			ipp := net.JoinHostPort(a.String(), strconv.Itoa(int(p)))
 			printf("|-- tcp://%s\n", ipp)
 		}
-		printf("|--> tcp://%s\n", h.TCPForward)
+		if h.TCPForward != "" {
+			printf("|--> tcp://%s\n", h.TCPForward)
+		}
+		poolHealth := health[h.View().BackendPoolKey()]
+		for _, b := range h.TCPForwardBackends {
+			printf("|--> tcp://%s (%s)\n", b, backendHealthStatus(poolHealth, b))
+		}
 	}
 	return nil
 }
 
-func (e *serveEnv) printWebStatusTree(sc *ipn.ServeConfig, hp ipn.HostPort) error {
+func (e *serveEnv) printWebStatusTree(sc *ipn.ServeConfig, hp ipn.HostPort, health map[string][]ipn.ServeBackendHealth) error {
 	// No-op if no serve config
 	if sc == nil {
 		return nil
@@ -715,6 +782,8 @@ func (e *serveEnv) printWebStatusTree(sc *ipn.ServeConfig, hp ipn.HostPort) erro
 			return "path", h.Path
 		case h.Proxy != "":
 			return "proxy", h.Proxy
+		case len(h.Backends) > 0:
+			return "proxy", fmt.Sprintf("%d backends", len(h.Backends))
 		case h.Text != "":
 			return "text", "\"" + elipticallyTruncate(h.Text, 20) + "\""
 		}
@@ -731,6 +800,10 @@ func (e *serveEnv) printWebStatusTree(sc *ipn.ServeConfig, hp ipn.HostPort) erro
 		h := sc.Web[hp].Handlers[m]
 		t, d := srvTypeAndDesc(h)
 		printf("%s %s%s %-5s %s\n", "|--", m, strings.Repeat(" ", maxLen-len(m)), t, d)
+		poolHealth := health[h.View().BackendPoolKey()]
+		for _, b := range h.Backends {
+			printf("%s %s (%s)\n", "    |-->", b, backendHealthStatus(poolHealth, b))
+		}
 	}
 
 	return nil
@@ -879,6 +879,14 @@ var fakeStatus = &ipnstate.Status{
 		},
 	},
 	CurrentTailnet: &ipnstate.TailnetStatus{MagicDNSSuffix: "test.ts.net"},
+	User: map[tailcfg.UserID]tailcfg.UserProfile{
+		1: {ID: 1, LoginName: "alice@example.com"},
+		2: {ID: 2, LoginName: "bob@example.com"},
+	},
+}
+
+func (lc *fakeLocalServeClient) Status(ctx context.Context) (*ipnstate.Status, error) {
+	return lc.StatusWithoutPeers(ctx)
 }
 
 func (lc *fakeLocalServeClient) StatusWithoutPeers(ctx context.Context) (*ipnstate.Status, error) {
@@ -942,6 +950,10 @@ func (lc *fakeLocalServeClient) CheckSOMarkInUse(ctx context.Context) (bool, err
 	return lc.SOMarkInUse, nil
 }
 
+func (lc *fakeLocalServeClient) GetServeBackendHealth(ctx context.Context) (map[string][]ipn.ServeBackendHealth, error) {
+	return nil, nil // unused in tests
+}
+
 // exactError returns an error checker that wants exactly the provided want error.
 // If optName is non-empty, it's used in the error message.
 func exactErr(want error, optName ...string) func(error) string {
@@ -13,6 +13,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"maps"
 	"math"
 	"net"
 	"net/url"
@@ -33,6 +34,7 @@ import (
 	"tailscale.com/ipn/conffile"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/tailcfg"
+	"tailscale.com/tstime"
 	"tailscale.com/types/ipproto"
 	"tailscale.com/util/dnsname"
 	"tailscale.com/util/mak"
@@ -134,12 +136,64 @@ func (u *acceptAppCapsFlag) String() string {
 	return strings.Join(s, ",")
 }
 
+// headerRulesFlag is a flag that adds to a set of header rewrite rules. Its
+// values take the form "Name: value" to set a header, "+Name: value" to add
+// a value to a header, or "-Name" to remove a header.
+type headerRulesFlag struct {
+	Value *ipn.HeaderRules
+}
+
+// Set adds the header rule s.
+func (f *headerRulesFlag) Set(s string) error {
+	if name, ok := strings.CutPrefix(s, "-"); ok {
+		if name == "" || strings.Contains(name, ":") {
+			return fmt.Errorf("invalid header removal %q; must be of the form -Name", s)
+		}
+		f.Value.Remove = append(f.Value.Remove, name)
+		return nil
+	}
+	add := strings.HasPrefix(s, "+")
+	name, value, ok := strings.Cut(strings.TrimPrefix(s, "+"), ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return fmt.Errorf("invalid header %q; must be of the form \"Name: value\"", s)
+	}
+	value = strings.TrimSpace(value)
+	if add {
+		mak.Set(&f.Value.Add, name, value)
+	} else {
+		mak.Set(&f.Value.Set, name, value)
+	}
+	return nil
+}
+
+// String returns the header rules in the form accepted by Set.
+func (f *headerRulesFlag) String() string {
+	if f.Value == nil {
+		return ""
+	}
+	var s []string
+	for _, k := range f.Value.Remove {
+		s = append(s, "-"+k)
+	}
+	for _, k := range slices.Sorted(maps.Keys(f.Value.Set)) {
+		s = append(s, k+": "+f.Value.Set[k])
+	}
+	for _, k := range slices.Sorted(maps.Keys(f.Value.Add)) {
+		s = append(s, "+"+k+": "+f.Value.Add[k])
+	}
+	return strings.Join(s, ", ")
+}
+
 var serveHelpCommon = strings.TrimSpace(`
 <target> can be a file, directory, text, or most commonly the location to a service running on the
 local machine. The location to the location service can be expressed as a port number (e.g., 3000),
 a partial URL (e.g., localhost:3000), or a full URL including a path (e.g., http://localhost:3000/foo).
 On Unix-like systems, you can also specify a Unix domain socket (e.g., unix:/tmp/myservice.sock).
 
+Several server targets can be given to load balance across them. Backends that fail are ejected until
+they recover; see the --lb-policy and --health-check-* flags.
+
 EXAMPLES
   - Expose an HTTP server running at 127.0.0.1:3000 in the foreground:
     $ tailscale %[1]s 3000
@@ -153,6 +207,15 @@ EXAMPLES
   - Expose a service listening on a Unix socket (Linux/macOS/BSD only):
     $ tailscale %[1]s unix:/var/run/myservice.sock
 
+  - Load balance across HTTP servers at 127.0.0.1:3000 and 127.0.0.1:3001, checking /healthz every 10s:
+    $ tailscale %[1]s --bg --health-check-interval=10s --health-check-path=/healthz 3000 3001
+
+  - Expose an HTTP server at /api, removing /v1 from request paths and adding an HSTS header to responses:
+    $ tailscale %[1]s --bg --set-path=/api --strip-prefix=/v1 --response-header="Strict-Transport-Security: max-age=31536000" 3000
+
+  - Expose an HTTP server running at 127.0.0.1:3000 only to alice@example.com and devices tagged tag:ci:
+    $ tailscale %[1]s --bg --allow-users=alice@example.com --allow-tags=tag:ci 3000
+
 For more examples and use cases visit our docs site https://tailscale.com/kb/1247/funnel-serve-use-cases
 `)
 
@@ -228,7 +291,7 @@ func newServeV2Command(e *serveEnv, subcmd serveMode) *ffcli.Command {
 		Name:      info.Name,
 		ShortHelp: info.ShortHelp,
 		ShortUsage: strings.Join([]string{
-			fmt.Sprintf("tailscale %s <target>", info.Name),
+			fmt.Sprintf("tailscale %s <target> [<target>...]", info.Name),
 			fmt.Sprintf("tailscale %s status [--json]", info.Name),
 			fmt.Sprintf("tailscale %s reset", info.Name),
 		}, "\n"),
@@ -248,6 +311,20 @@ func newServeV2Command(e *serveEnv, subcmd serveMode) *ffcli.Command {
 			fs.UintVar(&e.tcp, "tcp", 0, "Expose a TCP forwarder to forward raw TCP packets at the specified port")
 			fs.UintVar(&e.tlsTerminatedTCP, "tls-terminated-tcp", 0, "Expose a TCP forwarder to forward TLS-terminated TCP packets at the specified port")
 			fs.UintVar(&e.proxyProtocol, "proxy-protocol", 0, "PROXY protocol version (1 or 2) for TCP forwarding")
+			fs.StringVar(&e.lbPolicy, "lb-policy", "", `Load balancing policy across multiple targets: "round-robin" or "least-conns" (default "round-robin")`)
+			fs.DurationVar(&e.healthCheckInterval, "health-check-interval", 0, "Interval between active health checks of multiple targets (default only passively checks targets by their traffic)")
+			fs.StringVar(&e.healthCheckPath, "health-check-path", "", "Path requested from HTTP targets by active health checks (default only connects)")
+			fs.StringVar(&e.stripPrefix, "strip-prefix", "", "Path prefix to remove from requests before proxying them, after the --set-path mount point")
+			fs.StringVar(&e.hostHeader, "host-header", "", "Host header to send to the proxied server (default the requested host)")
+			fs.Var(&headerRulesFlag{Value: &e.requestHeaders}, "request-header", `Header to set on proxied requests, as "Name: value", "+Name: value" to add a value, or "-Name" to remove it (can be repeated)`)
+			fs.Var(&headerRulesFlag{Value: &e.responseHeaders}, "response-header", `Header to set on responses, as "Name: value", "+Name: value" to add a value, or "-Name" to remove it (can be repeated)`)
+			fs.StringVar(&e.allowUsers, "allow-users", "", "Only allow the specified users to access the server (comma-separated login names or user IDs)")
+			fs.StringVar(&e.allowGroups, "allow-groups", "", "Only allow members of the specified groups to access the server (comma-separated)")
+			fs.StringVar(&e.allowTags, "allow-tags", "", "Only allow devices with the specified tags to access the server (comma-separated)")
+			fs.Var(&acceptAppCapsFlag{Value: &e.allowCaps}, "allow-caps", "Only allow clients granted the specified app capabilities to access the server (comma-separated)")
+			if subcmd == funnel {
+				fs.StringVar(&e.funnelAccess, "funnel-access", "", `Whether to "allow" or "deny" requests from the internet when any --allow-* flag is set (default "deny")`)
+			}
 			fs.BoolVar(&e.yes, "yes", false, "Update without interactive prompts (default false)")
 		}),
 		UsageFunc: usageFuncNoDefaultValues,
@@ -354,18 +431,18 @@ func (e *serveEnv) validateArgs(subcmd serveMode, args []string) error {
 		fmt.Fprintln(e.stderr(), "Error: invalid argument format")
 		return errHelpFunc(subcmd)
 	}
-	if len(args) > 2 {
+	turnOff := args[len(args)-1] == "off"
+	if turnOff && len(args) > 2 {
 		fmt.Fprintf(e.stderr(), "Error: invalid number of arguments (%d)\n", len(args))
 		return errHelpFunc(subcmd)
 	}
-	turnOff := args[len(args)-1] == "off"
-	if len(args) == 2 && !turnOff {
-		fmt.Fprintln(e.stderr(), "Error: invalid argument format")
+	if !turnOff && len(args) == 1 && e.hasLoadBalancingFlags() {
+		fmt.Fprintln(e.stderr(), "Error: load balancing flags require multiple targets")
 		return errHelpFunc(subcmd)
 	}
 
-	// Given the two checks above, we can assume there
-	// are only 1 or 2 arguments which is valid.
+	// Given the checks above, the arguments are either
+	// one or more targets, or an optional target and "off".
 	return nil
 }
 
@@ -521,10 +598,29 @@ func (e *serveEnv) runServeCombined(subcmd serveMode) execFunc {
 			if len(args) > 0 {
 				target = args[0]
 			}
-			if err := e.shouldWarnRemoteDestCompatibility(ctx, target); err != nil {
-				return err
+			for _, t := range args {
+				if err := e.shouldWarnRemoteDestCompatibility(ctx, t); err != nil {
+					return err
+				}
 			}
 			err = e.setServe(sc, dnsName, srvType, srvPort, mount, target, funnel, magicDNSSuffix, e.acceptAppCaps, int(e.proxyProtocol))
+			if err == nil && len(args) > 1 {
+				err = e.applyBackendPool(sc, dnsName, srvType, srvPort, mount, magicDNSSuffix, args)
+			}
+			if err == nil {
+				var rw *ipn.HTTPRewrite
+				rw, err = e.rewrite()
+				if err == nil && rw != nil {
+					err = applyRewrite(sc, dnsName, srvType, srvPort, mount, magicDNSSuffix, rw)
+				}
+			}
+			if err == nil {
+				var access *ipn.HTTPAccess
+				access, err = e.access(ctx)
+				if err == nil && access != nil {
+					err = applyAccess(sc, dnsName, srvType, srvPort, mount, magicDNSSuffix, access)
+				}
+			}
 			msg = e.messageForPort(sc, st, dnsName, srvType, srvPort)
 		}
 		if err != nil {
@@ -715,6 +811,8 @@ func (e *serveEnv) runServeGetConfig(ctx context.Context, args []string) (err er
 					Destination:      destHost,
 					DestinationPorts: tailcfg.PortRange{First: uint16(destPort), Last: uint16(destPort)},
 				})
+			} else if len(config.TCPForwardBackends) > 0 {
+				return nil, fmt.Errorf("service %q: port %d has multiple backends, which service configuration files do not support", svcName, port)
 			} else if config.HTTP || config.HTTPS {
 				webKey := ipn.HostPort(net.JoinHostPort(sniName, strconv.FormatUint(uint64(port), 10)))
 				handlers, ok := serviceConfig.Web[webKey]
@@ -725,12 +823,18 @@ func (e *serveEnv) runServeGetConfig(ctx context.Context, args []string) (err er
 				if !ok {
 					return nil, fmt.Errorf("service %q: root handler not set", svcName)
 				}
+				if defaultHandler.Access != nil {
+					return nil, fmt.Errorf("service %q: port %d has an access policy, which service configuration files do not support", svcName, port)
+				}
 				if defaultHandler.Path != "" {
 					mak.Set(&sdf.Endpoints, &ppr, &conffile.Target{
 						Protocol:         conffile.ProtoFile,
 						Destination:      defaultHandler.Path,
 						DestinationPorts: tailcfg.PortRange{},
+						Rewrite:          conffile.RewriteFromServe(defaultHandler.Rewrite),
 					})
+				} else if len(defaultHandler.Backends) > 0 {
+					return nil, fmt.Errorf("service %q: port %d has multiple backends, which service configuration files do not support", svcName, port)
 				} else if defaultHandler.Proxy != "" {
 					proto, rest, ok := strings.Cut(defaultHandler.Proxy, "://")
 					if !ok {
@@ -750,6 +854,7 @@ func (e *serveEnv) runServeGetConfig(ctx context.Context, args []string) (err er
 						Protocol:         conffile.ServiceProtocol(proto),
 						Destination:      host,
 						DestinationPorts: tailcfg.PortRange{First: uint16(port), Last: uint16(port)},
+						Rewrite:          conffile.RewriteFromServe(defaultHandler.Rewrite),
 					})
 				}
 			}
@@ -868,6 +973,9 @@ func (e *serveEnv) runServeSetConfig(ctx context.Context, args []string) (err er
 					target = fmt.Sprintf("%s://%s", ep.Protocol, net.JoinHostPort(ep.Destination, portStr))
 				}
 				err := e.setServe(sc, name.String(), serveType, port, "/", target, false, magicDNSSuffix, nil, 0 /* proxy protocol */)
+				if err == nil && ep.Rewrite != nil {
+					err = applyRewrite(sc, name.String(), serveType, port, "/", magicDNSSuffix, ep.Rewrite.ServeRewrite())
+				}
 				if err != nil {
 					return fmt.Errorf("service %q: %w", name, err)
 				}
@@ -993,6 +1101,8 @@ func (e *serveEnv) messageForPort(sc *ipn.ServeConfig, st *ipnstate.Status, dnsN
 			return "path", h.Path
 		case h.Proxy != "":
 			return "proxy", h.Proxy
+		case len(h.Backends) > 0:
+			return "proxy", strings.Join(h.Backends, ", ")
 		case h.Text != "":
 			return "text", "\"" + elipticallyTruncate(h.Text, 20) + "\""
 		}
@@ -1059,7 +1169,13 @@ func (e *serveEnv) messageForPort(sc *ipn.ServeConfig, st *ipnstate.Status, dnsN
 			ipp := net.JoinHostPort(a.String(), strconv.Itoa(int(srvPort)))
 			output.WriteString(fmt.Sprintf("|-- tcp://%s\n", ipp))
 		}
-		output.WriteString(fmt.Sprintf("|--> tcp://%s\n\n", tcpHandler.TCPForward))
+		if tcpHandler.TCPForward != "" {
+			output.WriteString(fmt.Sprintf("|--> tcp://%s\n", tcpHandler.TCPForward))
+		}
+		for _, b := range tcpHandler.TCPForwardBackends {
+			output.WriteString(fmt.Sprintf("|--> tcp://%s\n", b))
+		}
+		output.WriteString("\n")
 	}
 
 	if !forService && !e.bg.Value {
@@ -1246,6 +1362,232 @@ func (e *serveEnv) applyTCPServe(sc *ipn.ServeConfig, dnsName string, srcType se
 	return nil
 }
 
+// applyBackendPool replaces the single proxy or TCP forwarding target that
+// setServe configured from targets[0] with a pool of all of targets, load
+// balanced according to the --lb-policy and --health-check-* flags.
+func (e *serveEnv) applyBackendPool(sc *ipn.ServeConfig, dnsName string, srvType serveType, srvPort uint16, mount, mds string, targets []string) error {
+	lb, err := e.loadBalancing()
+	if err != nil {
+		return err
+	}
+	svcName := tailcfg.AsServiceName(dnsName)
+	switch srvType {
+	case serveTypeHTTPS, serveTypeHTTP:
+		h := webHandler(sc, dnsName, srvPort, mount, mds)
+		if h == nil || h.Proxy == "" {
+			return errors.New("multiple targets are only supported for proxies")
+		}
+		backends := []string{h.Proxy}
+		for _, t := range targets[1:] {
+			b, err := ipn.ExpandProxyTargetValue(t, []string{"http", "https", "https+insecure", "unix"}, "http")
+			if err != nil {
+				return err
+			}
+			backends = append(backends, b)
+		}
+		h.Proxy = ""
+		h.Backends = backends
+		h.LoadBalancing = lb
+	case serveTypeTCP, serveTypeTLSTerminatedTCP:
+		h := sc.GetTCPPortHandler(srvPort, svcName)
+		if h == nil || h.TCPForward == "" {
+			return errors.New("multiple targets are only supported for TCP forwarding")
+		}
+		backends := []string{h.TCPForward}
+		for _, t := range targets[1:] {
+			targetURL, err := ipn.ExpandProxyTargetValue(t, []string{"tcp"}, "tcp")
+			if err != nil {
+				return fmt.Errorf("unable to expand target: %v", err)
+			}
+			u, err := url.Parse(targetURL)
+			if err != nil {
+				return fmt.Errorf("invalid TCP target %q: %v", t, err)
+			}
+			backends = append(backends, u.Host)
+		}
+		h.TCPForward = ""
+		h.TCPForwardBackends = backends
+		h.LoadBalancing = lb
+	default:
+		return fmt.Errorf("multiple targets are not supported for %s", srvType)
+	}
+	return nil
+}
+
+// webHandler returns the HTTP handler of sc for dnsName, which may be a
+// service name, at srvPort and mount. It returns nil if there is none.
+func webHandler(sc *ipn.ServeConfig, dnsName string, srvPort uint16, mount, mds string) *ipn.HTTPHandler {
+	svcName := tailcfg.AsServiceName(dnsName)
+	host := dnsName
+	if svcName != noService {
+		host = svcName.WithoutPrefix() + "." + mds
+	}
+	hp := ipn.HostPort(net.JoinHostPort(host, strconv.Itoa(int(srvPort))))
+	return sc.GetWebHandler(svcName, hp, mount)
+}
+
+// applyRewrite sets rw as the rewrite rules of the HTTP handler that was
+// just added to sc.
+func applyRewrite(sc *ipn.ServeConfig, dnsName string, srvType serveType, srvPort uint16, mount, mds string, rw *ipn.HTTPRewrite) error {
+	if srvType != serveTypeHTTPS && srvType != serveTypeHTTP {
+		return errors.New("rewrites are only supported for HTTP and HTTPS")
+	}
+	h := webHandler(sc, dnsName, srvPort, mount, mds)
+	if h == nil {
+		return fmt.Errorf("no handler for %s", mount)
+	}
+	isProxy := h.Proxy != "" || len(h.Backends) > 0
+	if !isProxy && (rw.StripPrefix != "" || rw.Host != "" || rw.RequestHeaders != nil) {
+		return errors.New("request rewrites are only supported for proxies")
+	}
+	h.Rewrite = rw
+	return nil
+}
+
+// applyAccess sets a as the access control policy of the HTTP handler that
+// was just added to sc.
+func applyAccess(sc *ipn.ServeConfig, dnsName string, srvType serveType, srvPort uint16, mount, mds string, a *ipn.HTTPAccess) error {
+	if srvType != serveTypeHTTPS && srvType != serveTypeHTTP {
+		return errors.New("access control is only supported for HTTP and HTTPS")
+	}
+	h := webHandler(sc, dnsName, srvPort, mount, mds)
+	if h == nil {
+		return fmt.Errorf("no handler for %s", mount)
+	}
+	h.Access = a
+	return nil
+}
+
+// access returns the access control policy for HTTP handlers from the
+// command's flags, or nil if none is set.
+func (e *serveEnv) access(ctx context.Context) (*ipn.HTTPAccess, error) {
+	split := func(s string) []string {
+		var out []string
+		for v := range strings.SplitSeq(s, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				out = append(out, v)
+			}
+		}
+		return out
+	}
+	users, err := e.userIDs(ctx, split(e.allowUsers))
+	if err != nil {
+		return nil, err
+	}
+	a := &ipn.HTTPAccess{
+		Users:  users,
+		Groups: split(e.allowGroups),
+		Tags:   split(e.allowTags),
+		Caps:   e.allowCaps,
+		Funnel: ipn.FunnelAccess(e.funnelAccess),
+	}
+	if !a.Funnel.Valid() {
+		return nil, fmt.Errorf("invalid --funnel-access %q; must be %q or %q", e.funnelAccess, ipn.FunnelAccessAllow, ipn.FunnelAccessDeny)
+	}
+	for _, tag := range a.Tags {
+		if err := tailcfg.CheckTag(tag); err != nil {
+			return nil, fmt.Errorf("invalid --allow-tags: %w", err)
+		}
+	}
+	if len(a.Users) == 0 && len(a.Groups) == 0 && len(a.Tags) == 0 && len(a.Caps) == 0 {
+		if a.Funnel != "" {
+			return nil, errors.New("--funnel-access requires an --allow-users, --allow-groups, --allow-tags or --allow-caps flag")
+		}
+		return nil, nil
+	}
+	return a, nil
+}
+
+// userIDs returns the IDs of users, each given as a login name or a
+// numeric user ID. Login names are looked up among the users of nodes in
+// the netmap.
+func (e *serveEnv) userIDs(ctx context.Context, users []string) ([]tailcfg.UserID, error) {
+	var ids []tailcfg.UserID
+	var st *ipnstate.Status
+	for _, u := range users {
+		if id, err := strconv.ParseInt(u, 10, 64); err == nil {
+			ids = append(ids, tailcfg.UserID(id))
+			continue
+		}
+		if st == nil {
+			var err error
+			st, err = e.lc.Status(ctx)
+			if err != nil {
+				return nil, err
+			}
+		}
+		var found bool
+		for id, up := range st.User {
+			if strings.EqualFold(up.LoginName, u) {
+				ids = append(ids, id)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("invalid --allow-users: unknown user %q; use their user ID instead", u)
+		}
+	}
+	return ids, nil
+}
+
+// rewrite returns the rewrite rules for HTTP handlers from the command's
+// flags, or nil if none are set.
+func (e *serveEnv) rewrite() (*ipn.HTTPRewrite, error) {
+	rw := &ipn.HTTPRewrite{
+		StripPrefix: e.stripPrefix,
+		Host:        e.hostHeader,
+	}
+	if rw.StripPrefix != "" && !strings.HasPrefix(rw.StripPrefix, "/") {
+		return nil, errors.New("--strip-prefix must start with /")
+	}
+	if rw.Host != "" && strings.ContainsAny(rw.Host, "/ ") {
+		return nil, fmt.Errorf("invalid --host-header %q", rw.Host)
+	}
+	hasRules := func(r ipn.HeaderRules) bool {
+		return len(r.Remove) > 0 || len(r.Set) > 0 || len(r.Add) > 0
+	}
+	if hasRules(e.requestHeaders) {
+		rw.RequestHeaders = &e.requestHeaders
+	}
+	if hasRules(e.responseHeaders) {
+		rw.ResponseHeaders = &e.responseHeaders
+	}
+	if *rw == (ipn.HTTPRewrite{}) {
+		return nil, nil
+	}
+	return rw, nil
+}
+
+// hasLoadBalancingFlags reports whether any flag configuring the load
+// balancing of multiple targets is set.
+func (e *serveEnv) hasLoadBalancingFlags() bool {
+	return e.lbPolicy != "" || e.healthCheckInterval != 0 || e.healthCheckPath != ""
+}
+
+// loadBalancing returns the load balancing configuration for multiple
+// targets from the command's flags, or nil if none are set.
+func (e *serveEnv) loadBalancing() (*ipn.LoadBalancing, error) {
+	if !e.hasLoadBalancingFlags() {
+		return nil, nil
+	}
+	policy := ipn.LBPolicy(e.lbPolicy)
+	if !policy.Valid() {
+		return nil, fmt.Errorf("invalid --lb-policy %q; must be %q or %q", e.lbPolicy, ipn.LBRoundRobin, ipn.LBLeastConns)
+	}
+	if e.healthCheckInterval < 0 {
+		return nil, errors.New("--health-check-interval cannot be negative")
+	}
+	if e.healthCheckPath != "" && !strings.HasPrefix(e.healthCheckPath, "/") {
+		return nil, errors.New("--health-check-path must start with /")
+	}
+	return &ipn.LoadBalancing{
+		Policy:              policy,
+		HealthCheckInterval: tstime.GoDuration{Duration: e.healthCheckInterval},
+		HealthCheckPath:     e.healthCheckPath,
+	}, nil
+}
+
 func (e *serveEnv) applyFunnel(sc *ipn.ServeConfig, dnsName string, srvPort uint16, allowFunnel bool) {
 	hp := ipn.HostPort(net.JoinHostPort(dnsName, strconv.Itoa(int(srvPort))))
 
@@ -1359,6 +1701,11 @@ func isLegacyInvocation(subcmd serveMode, args []string) (string, bool) {
 			return "", false
 		}
 	}
+	if _, err := strconv.ParseUint(srcPortStr, 10, 16); err != nil {
+		// Not a legacy source like "tcp:2222", but likely a target
+		// URL like "tcp://localhost:22" among several.
+		return "", false
+	}
 
 	var wantLength int
 	switch srcType {
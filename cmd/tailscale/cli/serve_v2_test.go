@@ -17,12 +17,14 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/tailcfg"
+	"tailscale.com/tstime"
 	"tailscale.com/types/views"
 )
 
@@ -989,6 +991,245 @@ func TestServeDevConfigMutations(t *testing.T) {
 				wantErr: anyErr(),
 			}},
 		},
+		{
+			name: "multiple_proxy_targets",
+			steps: []step{
+				{
+					command: cmd("serve --bg 3000 https+insecure://localhost:3001"),
+					want: &ipn.ServeConfig{
+						TCP: map[uint16]*ipn.TCPPortHandler{443: {HTTPS: true}},
+						Web: map[ipn.HostPort]*ipn.WebServerConfig{
+							"foo.test.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+								"/": {Backends: []string{"http://127.0.0.1:3000", "https+insecure://localhost:3001"}},
+							}},
+						},
+					},
+				},
+				{
+					command: cmd("serve --bg --lb-policy=least-conns --health-check-interval=10s --health-check-path=/healthz 3000 3001"),
+					want: &ipn.ServeConfig{
+						TCP: map[uint16]*ipn.TCPPortHandler{443: {HTTPS: true}},
+						Web: map[ipn.HostPort]*ipn.WebServerConfig{
+							"foo.test.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+								"/": {
+									Backends: []string{"http://127.0.0.1:3000", "http://127.0.0.1:3001"},
+									LoadBalancing: &ipn.LoadBalancing{
+										Policy:              ipn.LBLeastConns,
+										HealthCheckInterval: tstime.GoDuration{Duration: 10 * time.Second},
+										HealthCheckPath:     "/healthz",
+									},
+								},
+							}},
+						},
+					},
+				},
+				{
+					command: cmd("serve --bg 3000"),
+					want: &ipn.ServeConfig{
+						TCP: map[uint16]*ipn.TCPPortHandler{443: {HTTPS: true}},
+						Web: map[ipn.HostPort]*ipn.WebServerConfig{
+							"foo.test.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+								"/": {Proxy: "http://127.0.0.1:3000"},
+							}},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "multiple_tcp_targets",
+			steps: []step{{
+				command: cmd("serve --tls-terminated-tcp=443 --bg tcp://localhost:5432 tcp://localhost:5433"),
+				want: &ipn.ServeConfig{
+					TCP: map[uint16]*ipn.TCPPortHandler{
+						443: {
+							TCPForwardBackends: []string{"localhost:5432", "localhost:5433"},
+							TerminateTLS:       "foo.test.ts.net",
+						},
+					},
+				},
+			}},
+		},
+		{
+			name: "multiple_text_targets",
+			steps: []step{{
+				command: cmd("serve --bg text:hello text:world"),
+				wantErr: anyErr(),
+			}},
+		},
+		{
+			name: "load_balancing_single_target",
+			steps: []step{{
+				command: cmd("serve --bg --lb-policy=least-conns 3000"),
+				wantErr: anyErr(),
+			}},
+		},
+		{
+			name: "invalid_load_balancing_policy",
+			steps: []step{{
+				command: cmd("serve --bg --lb-policy=random 3000 3001"),
+				wantErr: anyErr(),
+			}},
+		},
+		{
+			name: "off_with_multiple_targets",
+			steps: []step{{
+				command: cmd("serve --bg 3000 3001 off"),
+				wantErr: anyErr(),
+			}},
+		},
+		{
+			name: "rewrite_proxy",
+			steps: []step{
+				{
+					command: cmd("serve --bg --set-path=/api --strip-prefix=/v1 --host-header=backend.internal --request-header=X-Env:prod --request-header=-Cookie --response-header=+Vary:Origin 3000"),
+					want: &ipn.ServeConfig{
+						TCP: map[uint16]*ipn.TCPPortHandler{443: {HTTPS: true}},
+						Web: map[ipn.HostPort]*ipn.WebServerConfig{
+							"foo.test.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+								"/api": {
+									Proxy: "http://127.0.0.1:3000",
+									Rewrite: &ipn.HTTPRewrite{
+										StripPrefix: "/v1",
+										Host:        "backend.internal",
+										RequestHeaders: &ipn.HeaderRules{
+											Remove: []string{"Cookie"},
+											Set:    map[string]string{"X-Env": "prod"},
+										},
+										ResponseHeaders: &ipn.HeaderRules{
+											Add: map[string]string{"Vary": "Origin"},
+										},
+									},
+								},
+							}},
+						},
+					},
+				},
+				{
+					command: cmd("serve --bg --set-path=/api 3000"),
+					want: &ipn.ServeConfig{
+						TCP: map[uint16]*ipn.TCPPortHandler{443: {HTTPS: true}},
+						Web: map[ipn.HostPort]*ipn.WebServerConfig{
+							"foo.test.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+								"/api": {Proxy: "http://127.0.0.1:3000"},
+							}},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "rewrite_text_response_headers",
+			steps: []step{{
+				command: cmd("serve --bg --response-header=Access-Control-Allow-Origin:* text:hello"),
+				want: &ipn.ServeConfig{
+					TCP: map[uint16]*ipn.TCPPortHandler{443: {HTTPS: true}},
+					Web: map[ipn.HostPort]*ipn.WebServerConfig{
+						"foo.test.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+							"/": {
+								Text: "hello",
+								Rewrite: &ipn.HTTPRewrite{
+									ResponseHeaders: &ipn.HeaderRules{
+										Set: map[string]string{"Access-Control-Allow-Origin": "*"},
+									},
+								},
+							},
+						}},
+					},
+				},
+			}},
+		},
+		{
+			name: "rewrite_text_request",
+			steps: []step{{
+				command: cmd("serve --bg --host-header=example.com text:hello"),
+				wantErr: anyErr(),
+			}},
+		},
+		{
+			name: "rewrite_tcp",
+			steps: []step{{
+				command: cmd("serve --tcp=5432 --bg --response-header=X-Foo:bar tcp://localhost:5432"),
+				wantErr: anyErr(),
+			}},
+		},
+		{
+			name: "rewrite_invalid_strip_prefix",
+			steps: []step{{
+				command: cmd("serve --bg --strip-prefix=v1 3000"),
+				wantErr: anyErr(),
+			}},
+		},
+		{
+			name: "access_serve",
+			steps: []step{{
+				command: cmd("serve --bg --allow-users=Alice@example.com,3 --allow-tags=tag:ci --allow-caps=example.com/cap/admin 3000"),
+				want: &ipn.ServeConfig{
+					TCP: map[uint16]*ipn.TCPPortHandler{443: {HTTPS: true}},
+					Web: map[ipn.HostPort]*ipn.WebServerConfig{
+						"foo.test.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+							"/": {
+								Proxy: "http://127.0.0.1:3000",
+								Access: &ipn.HTTPAccess{
+									Users: []tailcfg.UserID{1, 3},
+									Tags:  []string{"tag:ci"},
+									Caps:  []tailcfg.PeerCapability{"example.com/cap/admin"},
+								},
+							},
+						}},
+					},
+				},
+			}},
+		},
+		{
+			name: "access_funnel",
+			steps: []step{{
+				command: cmd("funnel --bg --allow-groups=group:eng --funnel-access=allow 3000"),
+				want: &ipn.ServeConfig{
+					TCP: map[uint16]*ipn.TCPPortHandler{443: {HTTPS: true}},
+					Web: map[ipn.HostPort]*ipn.WebServerConfig{
+						"foo.test.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+							"/": {
+								Proxy: "http://127.0.0.1:3000",
+								Access: &ipn.HTTPAccess{
+									Groups: []string{"group:eng"},
+									Funnel: ipn.FunnelAccessAllow,
+								},
+							},
+						}},
+					},
+					AllowFunnel: map[ipn.HostPort]bool{"foo.test.ts.net:443": true},
+				},
+			}},
+		},
+		{
+			name: "access_funnel_without_allow_list",
+			steps: []step{{
+				command: cmd("funnel --bg --funnel-access=deny 3000"),
+				wantErr: anyErr(),
+			}},
+		},
+		{
+			name: "access_invalid_tag",
+			steps: []step{{
+				command: cmd("serve --bg --allow-tags=ci 3000"),
+				wantErr: anyErr(),
+			}},
+		},
+		{
+			name: "access_unknown_user",
+			steps: []step{{
+				command: cmd("serve --bg --allow-users=carol@example.com 3000"),
+				wantErr: anyErr(),
+			}},
+		},
+		{
+			name: "access_tcp",
+			steps: []step{{
+				command: cmd("serve --tcp=5432 --bg --allow-users=alice@example.com tcp://localhost:5432"),
+				wantErr: anyErr(),
+			}},
+		},
 	}
 
 	for _, group := range groups {
@@ -1233,6 +1474,74 @@ func TestAcceptSetAppCapsFlag(t *testing.T) {
 	}
 }
 
+func TestHeaderRulesFlag(t *testing.T) {
+	tests := []struct {
+		name    string
+		inputs  []string
+		want    ipn.HeaderRules
+		wantStr string
+		wantErr bool
+	}{
+		{
+			name:    "set",
+			inputs:  []string{"X-Env: prod", "Strict-Transport-Security:max-age=31536000"},
+			want:    ipn.HeaderRules{Set: map[string]string{"X-Env": "prod", "Strict-Transport-Security": "max-age=31536000"}},
+			wantStr: "Strict-Transport-Security: max-age=31536000, X-Env: prod",
+		},
+		{
+			name:    "add_and_remove",
+			inputs:  []string{"+Vary: Origin", "-Server", "-X-Powered-By"},
+			want:    ipn.HeaderRules{Remove: []string{"Server", "X-Powered-By"}, Add: map[string]string{"Vary": "Origin"}},
+			wantStr: "-Server, -X-Powered-By, +Vary: Origin",
+		},
+		{
+			name:    "empty_value",
+			inputs:  []string{"X-Empty:"},
+			want:    ipn.HeaderRules{Set: map[string]string{"X-Empty": ""}},
+			wantStr: "X-Empty: ",
+		},
+		{
+			name:    "missing_value",
+			inputs:  []string{"X-Env"},
+			wantErr: true,
+		},
+		{
+			name:    "remove_with_value",
+			inputs:  []string{"-Server: foo"},
+			wantErr: true,
+		},
+		{
+			name:    "missing_name",
+			inputs:  []string{"+: foo"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rules ipn.HeaderRules
+			f := &headerRulesFlag{Value: &rules}
+			var err error
+			for _, s := range tt.inputs {
+				if err = f.Set(s); err != nil {
+					break
+				}
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Set error = %v; want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(rules, tt.want) {
+				t.Errorf("rules = %+v; want %+v", rules, tt.want)
+			}
+			if got := f.String(); got != tt.wantStr {
+				t.Errorf("String() = %q; want %q", got, tt.wantStr)
+			}
+		})
+	}
+}
+
 func TestCleanURLPath(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -1754,6 +2063,11 @@ func TestIsLegacyInvocation(t *testing.T) {
 			args:     []string{"localhost:3000"},
 			expected: false,
 		},
+		{
+			subcmd:   serve,
+			args:     []string{"tcp://localhost:5432", "tcp://localhost:5433"},
+			expected: false,
+		},
 	}
 
 	for idx, tt := range tests {
@@ -0,0 +1,10 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !android && !ts_omit_auditlog
+
+package main
+
+// Register the audit log extension. On Linux, it's only enabled
+// if audit log exports are configured in the config file.
+import _ "tailscale.com/ipn/auditlog"
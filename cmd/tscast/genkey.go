@@ -0,0 +1,55 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/sessionrecording/localsink"
+)
+
+var genkeyCmd = &ffcli.Command{
+	Name:       "genkey",
+	ShortUsage: "tscast genkey <private-key-file>",
+	ShortHelp:  "Generate a key pair for encrypting local recordings",
+	LongHelp: `The genkey command generates a key pair for encrypting session
+recordings written by a local recording sink. It writes the "recpriv:"
+private key to the named file, which must not already exist, and prints
+the "recpub:" public key.
+
+Set the public key as the Recipient of the node's SSHRecording
+configuration, and keep the private key file somewhere the node can't
+read it. Pass the file to tscast with --key to read the recordings.`,
+	Exec: runGenkey,
+}
+
+func runGenkey(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return flag.ErrHelp
+	}
+	return genKey(os.Stdout, args[0])
+}
+
+// genKey generates a new key pair, writes the private key to a new file
+// at path and prints the public key to w.
+func genKey(w io.Writer, path string) error {
+	priv := localsink.NewPrivateKey()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(f, priv.String())
+	if err := errors.Join(err, f.Close()); err != nil {
+		os.Remove(path)
+		return err
+	}
+	_, err = fmt.Fprintln(w, priv.Public().String())
+	return err
+}
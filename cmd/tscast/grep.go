@@ -0,0 +1,119 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/sessionrecording"
+)
+
+var grepArgs struct {
+	ignoreCase bool
+	input      bool
+}
+
+var grepCmd = &ffcli.Command{
+	Name:       "grep",
+	ShortUsage: "tscast grep [flags] <regexp> <cast-file>...",
+	ShortHelp:  "Search the output of recordings",
+	LongHelp: `The grep command prints the lines of recorded terminal output that match
+a regular expression, with the time into the session that each line
+started. Terminal escape sequences are removed before matching.
+
+It exits with status 1 if nothing matched.`,
+	FlagSet: (func() *flag.FlagSet {
+		fs := flag.NewFlagSet("grep", flag.ExitOnError)
+		fs.BoolVar(&grepArgs.ignoreCase, "i", false, "match case-insensitively")
+		fs.BoolVar(&grepArgs.input, "input", false, "also search recorded input, if any")
+		return fs
+	})(),
+	Exec: runGrep,
+}
+
+// errNoMatch is returned by the grep command if nothing matched.
+var errNoMatch = errors.New("no match")
+
+func runGrep(ctx context.Context, args []string) error {
+	if len(args) < 2 {
+		return flag.ErrHelp
+	}
+	expr := args[0]
+	if grepArgs.ignoreCase {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return err
+	}
+	matched := false
+	for _, path := range args[1:] {
+		f, err := openCast(path)
+		if err != nil {
+			return err
+		}
+		n, err := grepCast(os.Stdout, f, re, grepArgs.input)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		matched = matched || n > 0
+	}
+	if !matched {
+		return errNoMatch
+	}
+	return nil
+}
+
+// grepCast writes the lines of f that match re to w and returns the
+// number of matching lines.
+func grepCast(w io.Writer, f *castFile, re *regexp.Regexp, input bool) (n int, err error) {
+	emit := func(code string) func(float64, string) {
+		return func(at float64, line string) {
+			if err != nil || !re.MatchString(line) {
+				return
+			}
+			n++
+			_, err = fmt.Fprintf(w, "%s [%s] %s%s\n", f.name, formatOffset(at), code, line)
+		}
+	}
+	out := &lineBuilder{emit: emit("")}
+	in := &lineBuilder{emit: emit("> "), crEndsLine: true}
+	if ferr := forEachEvent(f, func(ev sessionrecording.CastEvent) error {
+		switch ev.Code {
+		case sessionrecording.CastOutput:
+			out.write(ev.Time, ev.Data)
+		case sessionrecording.CastInput:
+			if input {
+				in.write(ev.Time, ev.Data)
+			}
+		}
+		return err
+	}); ferr != nil {
+		return n, ferr
+	}
+	out.flush()
+	in.flush()
+	return n, err
+}
+
+// formatOffset formats secs, an offset into a recording, as HH:MM:SS.mmm.
+func formatOffset(secs float64) string {
+	d := time.Duration(secs * float64(time.Second)).Round(time.Millisecond)
+	h := d / time.Hour
+	d -= h * time.Hour
+	m := d / time.Minute
+	d -= m * time.Minute
+	s := d / time.Second
+	d -= s * time.Second
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", h, m, s, d/time.Millisecond)
+}
@@ -0,0 +1,109 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/sessionrecording"
+)
+
+var playArgs struct {
+	speed     float64
+	idleLimit time.Duration
+	start     time.Duration
+	quiet     bool
+}
+
+var playCmd = &ffcli.Command{
+	Name:       "play",
+	ShortUsage: "tscast play [flags] <cast-file>",
+	ShortHelp:  "Replay a recording in the terminal",
+	LongHelp: `The play command writes a recording's output to the terminal with the
+original timing, scaled by --speed. Long pauses can be shortened with
+--idle-limit. The terminal should be at least as large as the recorded one.`,
+	FlagSet: (func() *flag.FlagSet {
+		fs := flag.NewFlagSet("play", flag.ExitOnError)
+		fs.Float64Var(&playArgs.speed, "speed", 1, "playback speed multiplier")
+		fs.DurationVar(&playArgs.idleLimit, "idle-limit", 0, "if non-zero, the longest pause between events")
+		fs.DurationVar(&playArgs.start, "start", 0, "offset into the recording to start playing at; earlier output is shown immediately")
+		fs.BoolVar(&playArgs.quiet, "quiet", false, "don't print the recording's details before playing it")
+		return fs
+	})(),
+	Exec: runPlay,
+}
+
+func runPlay(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return flag.ErrHelp
+	}
+	if playArgs.speed <= 0 {
+		return errors.New("--speed must be positive")
+	}
+	f, err := openCast(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if !playArgs.quiet {
+		s := newSummary(f.name, f.Header())
+		fmt.Fprintf(os.Stderr, "# %s: %s as %s from %s at %s\n", f.name, s.SSHUser, s.LocalUser, s.SrcNode, s.Start.Format(time.RFC3339))
+	}
+	p := &player{
+		w:         os.Stdout,
+		speed:     playArgs.speed,
+		idleLimit: playArgs.idleLimit,
+		start:     playArgs.start,
+		sleep: func(d time.Duration) error {
+			t := time.NewTimer(d)
+			defer t.Stop()
+			select {
+			case <-t.C:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	}
+	return forEachEvent(f, p.event)
+}
+
+// player writes the output of a recording with its original timing.
+type player struct {
+	w         io.Writer
+	speed     float64
+	idleLimit time.Duration
+	start     time.Duration
+	sleep     func(time.Duration) error
+
+	last time.Duration // offset of the previous output event
+}
+
+func (p *player) event(ev sessionrecording.CastEvent) error {
+	if ev.Code != sessionrecording.CastOutput {
+		return nil
+	}
+	at := ev.Offset()
+	if at > p.start {
+		delay := at - max(p.last, p.start)
+		if p.idleLimit > 0 {
+			delay = min(delay, p.idleLimit)
+		}
+		if delay > 0 {
+			if err := p.sleep(time.Duration(float64(delay) / p.speed)); err != nil {
+				return err
+			}
+		}
+	}
+	p.last = at
+	_, err := io.WriteString(p.w, ev.Data)
+	return err
+}
@@ -0,0 +1,148 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/sessionrecording"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tstime"
+)
+
+var summaryCmd = &ffcli.Command{
+	Name:       "summary",
+	ShortUsage: "tscast summary <cast-file>...",
+	ShortHelp:  "Print a JSON summary of recordings",
+	LongHelp: `The summary command prints a JSON array with one object per recording,
+describing who connected from where, when and for how long, and the
+commands that were run.
+
+Tailscale SSH doesn't record terminal input, so for interactive sessions
+the commands are recovered from output lines that look like a shell prompt
+followed by a command, and have "source": "prompt". Commands from recorded
+input have "source": "input", and the command of a non-interactive session
+has "source": "exec".`,
+	Exec: runSummary,
+}
+
+// summary is the JSON summary of a recording.
+type summary struct {
+	File         string                       `json:"file"`
+	Start        time.Time                    `json:"start"`
+	Duration     tstime.GoDuration            `json:"duration"`
+	SrcNode      string                       `json:"srcNode"`
+	SrcNodeID    tailcfg.StableNodeID         `json:"srcNodeID"`
+	SrcNodeUser  string                       `json:"srcNodeUser,omitempty"`
+	SrcNodeTags  []string                     `json:"srcNodeTags,omitempty"`
+	SSHUser      string                       `json:"sshUser,omitempty"`
+	LocalUser    string                       `json:"localUser,omitempty"`
+	ConnectionID string                       `json:"connectionID,omitempty"`
+	Kubernetes   *sessionrecording.Kubernetes `json:"kubernetes,omitempty"`
+	Width        int                          `json:"width,omitempty"`
+	Height       int                          `json:"height,omitempty"`
+	OutputBytes  int64                        `json:"outputBytes"`
+	Commands     []command                    `json:"commands"`
+
+	// Truncated is whether the recording ends mid-event, such as when
+	// the session or the recorder died.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// command is a command run during a recorded session.
+type command struct {
+	At      string `json:"at"` // offset into the recording, as HH:MM:SS.mmm
+	Command string `json:"command"`
+	Source  string `json:"source"` // "exec", "input" or "prompt"
+}
+
+func newSummary(name string, h sessionrecording.CastHeader) *summary {
+	return &summary{
+		File:         name,
+		Start:        time.Unix(h.Timestamp, 0).UTC(),
+		SrcNode:      h.SrcNode,
+		SrcNodeID:    h.SrcNodeID,
+		SrcNodeUser:  h.SrcNodeUser,
+		SrcNodeTags:  h.SrcNodeTags,
+		SSHUser:      h.SSHUser,
+		LocalUser:    h.LocalUser,
+		ConnectionID: h.ConnectionID,
+		Kubernetes:   h.Kubernetes,
+		Width:        h.Width,
+		Height:       h.Height,
+		Commands:     []command{},
+	}
+}
+
+func runSummary(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return flag.ErrHelp
+	}
+	sums := make([]*summary, 0, len(args))
+	for _, path := range args {
+		f, err := openCast(path)
+		if err != nil {
+			return err
+		}
+		s, err := summarize(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		sums = append(sums, s)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sums)
+}
+
+// summarize reads all of f and returns its summary.
+func summarize(f *castFile) (*summary, error) {
+	s := newSummary(f.name, f.Header())
+	if c := f.Header().Command; c != "" {
+		s.Commands = append(s.Commands, command{At: formatOffset(0), Command: c, Source: "exec"})
+	}
+
+	var typed, prompted []command
+	in := &lineBuilder{crEndsLine: true, emit: func(at float64, line string) {
+		typed = append(typed, command{At: formatOffset(at), Command: line, Source: "input"})
+	}}
+	out := &lineBuilder{emit: func(at float64, line string) {
+		if c, ok := commandFromPrompt(line); ok {
+			prompted = append(prompted, command{At: formatOffset(at), Command: c, Source: "prompt"})
+		}
+	}}
+	var last float64
+	err := forEachEvent(f, func(ev sessionrecording.CastEvent) error {
+		last = max(last, ev.Time)
+		switch ev.Code {
+		case sessionrecording.CastOutput:
+			s.OutputBytes += int64(len(ev.Data))
+			out.write(ev.Time, ev.Data)
+		case sessionrecording.CastInput:
+			in.write(ev.Time, ev.Data)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	in.flush()
+	out.flush()
+
+	s.Duration = tstime.GoDuration{Duration: time.Duration(last * float64(time.Second)).Round(time.Millisecond)}
+	s.Truncated = f.truncated
+	// Prefer what was actually typed, when it was recorded.
+	if len(typed) > 0 {
+		s.Commands = append(s.Commands, typed...)
+	} else {
+		s.Commands = append(s.Commands, prompted...)
+	}
+	return s, nil
+}
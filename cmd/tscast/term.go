@@ -0,0 +1,142 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// lineBuilder turns a stream of terminal data into plain text lines,
+// dropping escape sequences and applying backspaces and carriage returns
+// the way a terminal would for a single line.
+//
+// It only approximates a terminal; it doesn't track the cursor within a
+// line or across lines. That's good enough to search output and to
+// recover what was typed at a shell prompt.
+type lineBuilder struct {
+	// crEndsLine is whether a lone '\r' ends a line, as it does for
+	// terminal input. For output, a lone '\r' returns to the start of
+	// the current line.
+	crEndsLine bool
+
+	// emit is called with each complete line and the time of the first
+	// data that contributed to it.
+	emit func(at float64, line string)
+
+	line    []byte
+	lineAt  float64
+	esc     escState
+	afterCR bool
+}
+
+type escState int
+
+const (
+	escNone   escState = iota
+	escStart           // saw ESC
+	escCSI             // in ESC [ ... final
+	escOSC             // in ESC ] ... BEL or ST
+	escOSCEsc          // saw ESC in an OSC, expecting '\'
+)
+
+// write processes data that was written at time at.
+func (b *lineBuilder) write(at float64, data string) {
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch b.esc {
+		case escStart:
+			switch c {
+			case '[':
+				b.esc = escCSI
+			case ']':
+				b.esc = escOSC
+			default:
+				b.esc = escNone
+			}
+			continue
+		case escCSI:
+			if c >= 0x40 && c <= 0x7e {
+				b.esc = escNone
+			}
+			continue
+		case escOSC:
+			switch c {
+			case '\a':
+				b.esc = escNone
+			case 0x1b:
+				b.esc = escOSCEsc
+			}
+			continue
+		case escOSCEsc:
+			b.esc = escNone
+			continue
+		}
+
+		if b.afterCR {
+			b.afterCR = false
+			if c != '\n' {
+				b.line = b.line[:0]
+			}
+		}
+		switch c {
+		case 0x1b:
+			b.esc = escStart
+		case '\n':
+			b.flush()
+		case '\r':
+			if b.crEndsLine {
+				b.flush()
+			} else {
+				b.afterCR = true
+			}
+		case '\b', 0x7f:
+			if len(b.line) > 0 {
+				_, size := utf8.DecodeLastRune(b.line)
+				b.line = b.line[:len(b.line)-size]
+			}
+		case '\a', 0:
+			// Ignore.
+		case 0x15: // ^U, kill line
+			if b.crEndsLine {
+				b.line = b.line[:0]
+			}
+		default:
+			if c < 0x20 && c != '\t' {
+				continue
+			}
+			if len(b.line) == 0 {
+				b.lineAt = at
+			}
+			b.line = append(b.line, c)
+		}
+	}
+}
+
+// flush emits the current line, if it's not empty, even if it's not
+// terminated.
+func (b *lineBuilder) flush() {
+	b.afterCR = false
+	if len(b.line) > 0 {
+		b.emit(b.lineAt, strings.ToValidUTF8(string(b.line), "�"))
+	}
+	b.line = b.line[:0]
+}
+
+// promptRE matches a line of output that looks like a shell prompt
+// followed by a command, such as "alice@host:~$ ls -l", "[root@host /]# id"
+// or "/ # ps". The command is the last submatch.
+var promptRE = regexp.MustCompile(`^(?:\S*@\S+[^$#]*|\[[^\]]*\]|/[^$#\s]*\s?|)[$#] (\S.*)$`)
+
+// commandFromPrompt returns the command typed at a shell prompt in line,
+// if line looks like a prompt followed by a command.
+func commandFromPrompt(line string) (cmd string, ok bool) {
+	m := promptRE.FindStringSubmatch(line)
+	if m == nil {
+		return "", false
+	}
+	cmd = strings.TrimSpace(m[1])
+	return cmd, cmd != ""
+}
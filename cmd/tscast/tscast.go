@@ -0,0 +1,166 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+// The tscast command reads asciinema session recordings ("casts") made by
+// Tailscale SSH and the Kubernetes API server proxy, so that they can be
+// reviewed without running a recorder UI.
+//
+// It reads casts as stored by tsrecorder as well as recordings written by
+// a local recording sink, which may be compressed and encrypted; pass the
+// recipient's private key with --key for the latter. The genkey subcommand
+// generates the key pair used for encryption.
+//
+// Example usage:
+//
+//	$ tscast genkey recording.key
+//	$ tscast play --speed=4 --idle-limit=2s session.cast
+//	$ tscast grep 'sudo|passwd' recordings/*.cast.zst
+//	$ tscast summary --key=recording.key recordings/*.cast.zst.enc
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/sessionrecording"
+	"tailscale.com/sessionrecording/localsink"
+)
+
+func main() {
+	if err := rootCmd.Parse(os.Args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	err := rootCmd.Run(context.Background())
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(2)
+	}
+	if errors.Is(err, errNoMatch) {
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "tscast:", err)
+		os.Exit(1)
+	}
+}
+
+var rootArgs struct {
+	key string
+}
+
+var rootCmd = &ffcli.Command{
+	Name:       "tscast",
+	ShortUsage: "tscast [--key=<file>] <subcommand> [flags] <cast-file>...",
+	ShortHelp:  "Review Tailscale session recordings",
+	FlagSet: (func() *flag.FlagSet {
+		fs := flag.NewFlagSet("tscast", flag.ExitOnError)
+		fs.StringVar(&rootArgs.key, "key", "", `file containing the "recpriv:" private key for encrypted recordings, or the key itself`)
+		return fs
+	})(),
+	Subcommands: []*ffcli.Command{
+		playCmd,
+		grepCmd,
+		summaryCmd,
+		genkeyCmd,
+	},
+	Exec: func(context.Context, []string) error {
+		return flag.ErrHelp
+	},
+}
+
+// castFile is an open session recording.
+type castFile struct {
+	*sessionrecording.CastReader
+	name string
+	c    io.Closer
+
+	// truncated is set by forEachEvent if the recording ends mid-event.
+	truncated bool
+}
+
+func (f *castFile) Close() error { return f.c.Close() }
+
+// openCast opens the recording at path, which may be "-" for stdin.
+func openCast(path string) (*castFile, error) {
+	var f *os.File
+	if path == "-" {
+		f = os.Stdin
+	} else {
+		var err error
+		f, err = os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var r io.Reader = f
+	name := filepath.Base(path)
+	if strings.HasSuffix(name, ".zst") || strings.HasSuffix(name, ".enc") {
+		priv, err := privateKey()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		r, err = localsink.Open(f, name, priv)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	cr, err := sessionrecording.NewCastReader(r)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &castFile{CastReader: cr, name: path, c: f}, nil
+}
+
+// privateKey returns the key given by --key, or nil if there is none.
+func privateKey() (*localsink.PrivateKey, error) {
+	if rootArgs.key == "" {
+		return nil, nil
+	}
+	s := rootArgs.key
+	if !strings.HasPrefix(s, "recpriv:") {
+		b, err := os.ReadFile(s)
+		if err != nil {
+			return nil, err
+		}
+		s = string(b)
+	}
+	k, err := localsink.ParsePrivateKey(s)
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// forEachEvent calls fn for each event in f. A recording that ends
+// mid-event, as happens when a session is cut off, isn't an error.
+func forEachEvent(f *castFile, fn func(sessionrecording.CastEvent) error) error {
+	for {
+		ev, err := f.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			f.truncated = true
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.name, err)
+		}
+		if err := fn(ev); err != nil {
+			return err
+		}
+	}
+}
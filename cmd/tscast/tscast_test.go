@@ -0,0 +1,311 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/sessionrecording"
+	"tailscale.com/sessionrecording/localsink"
+)
+
+const testCast = `{"version":2,"width":80,"height":24,"timestamp":1700000000,"srcNode":"laptop.tail-scale.ts.net","srcNodeID":"nABC","srcNodeUser":"alice@example.com","env":{"TERM":"xterm"},"sshUser":"alice","localUser":"root","connectionID":"ssh-conn-1"}
+[0.1,"o","\u001b]0;root@host: ~\u0007root@host:~# "]
+[1.5,"o","l"]
+[1.6,"o","s"]
+[1.7,"o","x\b \b"]
+[1.8,"o","\r\n"]
+[1.9,"o","secret.txt  notes.md\r\n"]
+[2.0,"o","\u001b[01;32mroot@host\u001b[00m:~# cat secret.txt\r\n"]
+[3.25,"o","password=hunter2\r\n"]
+[4.5,"o","root@host:~# "]
+[65.5,"o","exit\r\n"]
+`
+
+func TestLineBuilder(t *testing.T) {
+	tests := []struct {
+		name  string
+		input bool
+		data  []string
+		want  []string
+	}{
+		{
+			name: "output",
+			data: []string{"hello\r\n", "wor", "ld\n"},
+			want: []string{"hello", "world"},
+		},
+		{
+			name: "escapes-split-across-writes",
+			data: []string{"\x1b[01", ";32mgreen\x1b[0m \x1b]0;title\x1b", "\\plain\r\n"},
+			want: []string{"green plain"},
+		},
+		{
+			name: "carriage-return-overwrites",
+			data: []string{"50%\r100%\r\n"},
+			want: []string{"100%"},
+		},
+		{
+			name: "backspace",
+			data: []string{"héllx\b\x7fo\n"},
+			want: []string{"hélo"},
+		},
+		{
+			name:  "input",
+			input: true,
+			data:  []string{"ls -l\r", "whoa\x15who", "ami\r"},
+			want:  []string{"ls -l", "whoami"},
+		},
+		{
+			name: "unterminated",
+			data: []string{"no newline"},
+			want: []string{"no newline"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			b := &lineBuilder{crEndsLine: tt.input, emit: func(_ float64, line string) {
+				got = append(got, line)
+			}}
+			for i, d := range tt.data {
+				b.write(float64(i), d)
+			}
+			b.flush()
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommandFromPrompt(t *testing.T) {
+	tests := []struct {
+		line string
+		want string // empty for no command
+	}{
+		{"alice@host:~$ ls -l", "ls -l"},
+		{"root@host:/var/log# tail -f syslog", "tail -f syslog"},
+		{"[root@host ~]# id", "id"},
+		{"/ # ps aux", "ps aux"},
+		{"$ make", "make"},
+		{"root@host:~# ", ""},
+		{"total 0", ""},
+		{"-rw-r--r-- 1 root root 0 notes.md", ""},
+	}
+	for _, tt := range tests {
+		got, ok := commandFromPrompt(tt.line)
+		if got != tt.want || ok != (tt.want != "") {
+			t.Errorf("commandFromPrompt(%q) = %q, %v; want %q", tt.line, got, ok, tt.want)
+		}
+	}
+}
+
+func writeTestCast(t *testing.T, name, cast string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(cast), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSummarize(t *testing.T) {
+	f, err := openCast(writeTestCast(t, "s.cast", testCast))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	s, err := summarize(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.SrcNode != "laptop.tail-scale.ts.net" || s.SSHUser != "alice" || s.LocalUser != "root" || s.SrcNodeUser != "alice@example.com" {
+		t.Errorf("wrong identity in summary: %+v", s)
+	}
+	if want := time.Unix(1700000000, 0).UTC(); !s.Start.Equal(want) {
+		t.Errorf("Start = %v; want %v", s.Start, want)
+	}
+	if s.Duration.Duration != 65500*time.Millisecond {
+		t.Errorf("Duration = %v; want 1m5.5s", s.Duration)
+	}
+	if s.Truncated {
+		t.Error("Truncated = true")
+	}
+	want := []command{
+		{At: "00:00:00.100", Command: "ls", Source: "prompt"},
+		{At: "00:00:02.000", Command: "cat secret.txt", Source: "prompt"},
+		{At: "00:00:04.500", Command: "exit", Source: "prompt"},
+	}
+	if !slices.Equal(s.Commands, want) {
+		t.Errorf("Commands = %+v; want %+v", s.Commands, want)
+	}
+}
+
+func TestSummarizeInputAndExec(t *testing.T) {
+	const cast = `{"version":2,"timestamp":1700000000,"command":"uptime"}
+[0.5,"i","ls\r"]
+[0.6,"o","$ ls\r\n"]
+[1,"o","up 3 da`
+	f, err := openCast(writeTestCast(t, "s.cast", cast))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	s, err := summarize(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []command{
+		{At: "00:00:00.000", Command: "uptime", Source: "exec"},
+		{At: "00:00:00.500", Command: "ls", Source: "input"},
+	}
+	if !slices.Equal(s.Commands, want) {
+		t.Errorf("Commands = %+v; want %+v", s.Commands, want)
+	}
+	if !s.Truncated {
+		t.Error("Truncated = false for recording ending mid-event")
+	}
+}
+
+func TestGrep(t *testing.T) {
+	f, err := openCast(writeTestCast(t, "s.cast", testCast))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var buf bytes.Buffer
+	n, err := grepCast(&buf, f, regexp.MustCompile(`(?i)PASSWORD|secret`), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		f.name + " [00:00:01.900] secret.txt  notes.md",
+		f.name + " [00:00:02.000] root@host:~# cat secret.txt",
+		f.name + " [00:00:03.250] password=hunter2",
+		"",
+	}, "\n")
+	if n != 3 || buf.String() != want {
+		t.Errorf("grep found %d lines:\n%s\nwant:\n%s", n, buf.String(), want)
+	}
+}
+
+func TestOpenLocalSinkRecording(t *testing.T) {
+	priv := localsink.NewPrivateKey()
+	pub := priv.Public()
+	dir := t.TempDir()
+	sink, err := localsink.New(localsink.Options{Dir: dir, Compress: true, Recipient: &pub})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := sink.NewRecording(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, testCast)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.cast.zst.enc"))
+	if len(matches) != 1 {
+		t.Fatalf("got recordings %q; want 1", matches)
+	}
+
+	rootArgs.key = ""
+	if _, err := openCast(matches[0]); err == nil {
+		t.Fatal("opened encrypted recording without a key")
+	}
+
+	keyFile := filepath.Join(dir, "key")
+	if err := os.WriteFile(keyFile, []byte(priv.String()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	rootArgs.key = keyFile
+	defer func() { rootArgs.key = "" }()
+	f, err := openCast(matches[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	s, err := summarize(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.SSHUser != "alice" || len(s.Commands) != 3 {
+		t.Errorf("unexpected summary %+v", s)
+	}
+}
+
+func TestPlay(t *testing.T) {
+	var out bytes.Buffer
+	var slept []time.Duration
+	p := &player{
+		w:         &out,
+		speed:     2,
+		idleLimit: 10 * time.Second,
+		start:     time.Second,
+		sleep: func(d time.Duration) error {
+			slept = append(slept, d)
+			return nil
+		},
+	}
+	for _, ev := range []sessionrecording.CastEvent{
+		{Time: 0.5, Code: "o", Data: "a"},
+		{Time: 2, Code: "o", Data: "b"},
+		{Time: 2.5, Code: "r", Data: "100x40"},
+		{Time: 3, Code: "o", Data: "c"},
+		{Time: 63, Code: "o", Data: "d"},
+	} {
+		if err := p.event(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if out.String() != "abcd" {
+		t.Errorf("output = %q; want %q", out.String(), "abcd")
+	}
+	want := []time.Duration{500 * time.Millisecond, 500 * time.Millisecond, 5 * time.Second}
+	if !slices.Equal(slept, want) {
+		t.Errorf("slept %v; want %v", slept, want)
+	}
+}
+
+func TestGenKey(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "key")
+	var out bytes.Buffer
+	if err := genKey(&out, keyFile); err != nil {
+		t.Fatal(err)
+	}
+	pub, err := localsink.ParsePublicKey(strings.TrimSpace(out.String()))
+	if err != nil {
+		t.Fatalf("parsing printed public key: %v", err)
+	}
+	fi, err := os.Stat(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && fi.Mode().Perm() != 0600 {
+		t.Errorf("key file mode = %v; want 0600", fi.Mode().Perm())
+	}
+	rootArgs.key = keyFile
+	defer func() { rootArgs.key = "" }()
+	priv, err := privateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if priv.Public() != pub {
+		t.Errorf("public key %v doesn't match private key in file", pub)
+	}
+
+	if err := genKey(io.Discard, keyFile); err == nil {
+		t.Error("genKey overwrote an existing key file")
+	}
+}
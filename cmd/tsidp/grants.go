@@ -0,0 +1,449 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	"tailscale.com/util/mak"
+	"tailscale.com/util/rands"
+)
+
+// parseCodeChallenge returns the PKCE code challenge (RFC 7636) of an
+// authorization request with query q, or the empty string if it has none.
+// Only the S256 method is supported.
+func parseCodeChallenge(q url.Values) (string, error) {
+	challenge := q.Get("code_challenge")
+	if challenge == "" {
+		return "", nil
+	}
+	if q.Get("code_challenge_method") != "S256" {
+		return "", errors.New("tsidp: code_challenge_method must be S256")
+	}
+	// A base64url encoded SHA-256 hash, without padding.
+	if b, err := base64.RawURLEncoding.DecodeString(challenge); err != nil || len(b) != sha256.Size {
+		return "", errors.New("tsidp: invalid code_challenge")
+	}
+	return challenge, nil
+}
+
+// verifyCodeVerifier reports whether the PKCE code verifier presented at the
+// token endpoint matches the code challenge of the authorization request.
+// If there was no challenge, there must be no verifier.
+func verifyCodeVerifier(challenge, verifier string) bool {
+	if challenge == "" {
+		return verifier == ""
+	}
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(sum[:])), []byte(challenge)) == 1
+}
+
+// serveClientCredentialsGrant implements the client_credentials grant (RFC
+// 6749, section 4.4) for tagged nodes, such as services, that authenticate
+// with their tailnet identity. It issues an access token only.
+func (s *idpServer) serveClientCredentialsGrant(w http.ResponseWriter, r *http.Request) {
+	if isFunnelRequest(r) {
+		http.Error(w, "tsidp: client_credentials grant not available over Funnel", http.StatusUnauthorized)
+		return
+	}
+	who, err := s.lc.WhoIs(r.Context(), s.visitorAddr(r))
+	if err != nil {
+		log.Printf("Error getting WhoIs: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !who.Node.IsTagged() {
+		http.Error(w, "tsidp: client_credentials grant is only for tagged nodes", http.StatusBadRequest)
+		return
+	}
+
+	clientID, clientSecret := clientCredentials(r)
+	ar := &authRequest{
+		clientID:   clientID,
+		remoteUser: who,
+		grantID:    rands.HexString(16),
+	}
+	if !s.allowInsecureRegistration {
+		s.mu.Lock()
+		c, ok := s.funnelClients[clientID]
+		s.mu.Unlock()
+		// Public clients can't use this grant, as they have no
+		// credentials (RFC 6749, section 4.4).
+		if !ok || c.isPublic() || subtle.ConstantTimeCompare([]byte(clientSecret), []byte(c.Secret)) != 1 {
+			http.Error(w, "tsidp: invalid client credentials", http.StatusUnauthorized)
+			return
+		}
+		ar.funnelRP = c
+	} else {
+		ar.rpNodeID = who.Node.ID
+	}
+
+	at := rands.HexString(32)
+	now := time.Now()
+	s.mu.Lock()
+	ar.issuedAt = now
+	ar.validTill = now.Add(5 * time.Minute)
+	mak.Set(&s.accessToken, at, ar)
+	s.storeTokensLocked()
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(oidcTokenResponse{
+		AccessToken: at,
+		TokenType:   "Bearer",
+		ExpiresIn:   5 * 60,
+	}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// deviceCodeGrantType is the grant_type of the device authorization grant.
+const deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+const (
+	// deviceCodeLifetime is how long the user has to approve a device
+	// authorization request.
+	deviceCodeLifetime = 10 * time.Minute
+
+	// devicePollInterval is the minimum interval at which clients may
+	// poll the token endpoint, until they're told to slow down.
+	devicePollInterval = 5 * time.Second
+)
+
+// deviceAuth is a pending device authorization request (RFC 8628).
+type deviceAuth struct {
+	// ar is the request of the client. Its remoteUser is set once a user
+	// approves the request.
+	ar *authRequest
+
+	deviceCode string
+	userCode   string // as displayed, like "BCDF-GHJK"
+	clientName string // for the verification page
+	expires    time.Time
+
+	interval time.Duration // current minimum poll interval
+	lastPoll time.Time
+
+	approved bool
+	denied   bool
+}
+
+// userCodeAlphabet is the alphabet of user codes, without vowels to avoid
+// forming words, as recommended by RFC 8628, section 6.1.
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+// newUserCode returns a random user code, like "BCDF-GHJK".
+func newUserCode() string {
+	var code []byte
+	var b [1]byte
+	for len(code) < 9 {
+		if len(code) == 4 {
+			code = append(code, '-')
+			continue
+		}
+		crand.Read(b[:])
+		// Avoid modulo bias.
+		if int(b[0]) >= 256/len(userCodeAlphabet)*len(userCodeAlphabet) {
+			continue
+		}
+		code = append(code, userCodeAlphabet[int(b[0])%len(userCodeAlphabet)])
+	}
+	return string(code)
+}
+
+// normalizeUserCode returns the user code as entered by a user, in upper
+// case and without separators.
+func normalizeUserCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			r -= 'a' - 'A'
+		}
+		if r < 'A' || r > 'Z' {
+			return -1
+		}
+		return r
+	}, code)
+}
+
+// deleteDeviceAuthLocked removes da from the pending device authorization
+// requests. s.mu must be held.
+func (s *idpServer) deleteDeviceAuthLocked(da *deviceAuth) {
+	delete(s.deviceCode, da.deviceCode)
+	delete(s.userCode, normalizeUserCode(da.userCode))
+}
+
+// deviceAuthorizationResponse is the response of the device authorization
+// endpoint, as described in RFC 8628, section 3.2.
+type deviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// serveDeviceAuthorization implements the device authorization endpoint of
+// RFC 8628, which starts the device flow for clients that can't open a
+// browser on the user's device, or that have no redirect URI, such as CLIs.
+func (s *idpServer) serveDeviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "tsidp: method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	clientID, clientSecret := clientCredentials(r)
+	if clientID == "" {
+		http.Error(w, "tsidp: must specify client_id", http.StatusBadRequest)
+		return
+	}
+
+	da := &deviceAuth{
+		ar:         &authRequest{clientID: clientID},
+		deviceCode: rands.HexString(32),
+		userCode:   newUserCode(),
+		expires:    time.Now().Add(deviceCodeLifetime),
+		interval:   devicePollInterval,
+	}
+
+	// Identify the relying party in the same way as the authorize
+	// endpoint, so that the token endpoint can authenticate it.
+	s.mu.Lock()
+	c, registered := s.funnelClients[clientID]
+	s.mu.Unlock()
+	switch {
+	case !s.allowInsecureRegistration || isFunnelRequest(r):
+		if !registered {
+			http.Error(w, "tsidp: invalid client ID", http.StatusBadRequest)
+			return
+		}
+		if !c.isPublic() && subtle.ConstantTimeCompare([]byte(clientSecret), []byte(c.Secret)) != 1 {
+			http.Error(w, "tsidp: invalid client credentials", http.StatusUnauthorized)
+			return
+		}
+		da.ar.funnelRP = c
+		da.clientName = c.Name
+	default:
+		ra, err := netip.ParseAddrPort(r.RemoteAddr)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if ra.Addr().IsLoopback() {
+			da.ar.localRP = true
+			da.clientName = "a client on the tsidp host"
+			break
+		}
+		who, err := s.lc.WhoIs(r.Context(), r.RemoteAddr)
+		if err != nil {
+			log.Printf("Error getting WhoIs: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		da.ar.rpNodeID = who.Node.ID
+		da.clientName = strings.TrimSuffix(who.Node.Name, ".")
+	}
+	if da.clientName == "" {
+		da.clientName = clientID
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	for _, old := range s.deviceCode {
+		if old.expires.Before(now) {
+			s.deleteDeviceAuthLocked(old)
+		}
+	}
+	mak.Set(&s.deviceCode, da.deviceCode, da)
+	mak.Set(&s.userCode, normalizeUserCode(da.userCode), da)
+	s.mu.Unlock()
+
+	verificationURI := s.serverURL + "/device"
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(deviceAuthorizationResponse{
+		DeviceCode:              da.deviceCode,
+		UserCode:                da.userCode,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "?" + url.Values{"user_code": {da.userCode}}.Encode(),
+		ExpiresIn:               int(deviceCodeLifetime.Seconds()),
+		Interval:                int(devicePollInterval.Seconds()),
+	}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// writeOAuthError writes an OAuth error response (RFC 6749, section 5.2).
+// Device flow clients rely on the error code to know whether to keep
+// polling.
+func writeOAuthError(w http.ResponseWriter, code int, errCode, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(struct {
+		Error       string `json:"error"`
+		Description string `json:"error_description,omitempty"`
+	}{errCode, description})
+}
+
+// serveDeviceCodeGrant implements the device code grant of RFC 8628,
+// section 3.4, which clients poll until the user approves or denies their
+// device authorization request.
+func (s *idpServer) serveDeviceCodeGrant(w http.ResponseWriter, r *http.Request) {
+	dc := r.FormValue("device_code")
+	if dc == "" {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "device_code is required")
+		return
+	}
+	s.mu.Lock()
+	da, ok := s.deviceCode[dc]
+	s.mu.Unlock()
+	if !ok {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "unknown device_code")
+		return
+	}
+	if !s.authenticateClient(w, r, da.ar) {
+		return
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	if da.expires.Before(now) {
+		s.deleteDeviceAuthLocked(da)
+		s.mu.Unlock()
+		writeOAuthError(w, http.StatusBadRequest, "expired_token", "")
+		return
+	}
+	if now.Sub(da.lastPoll) < da.interval {
+		da.interval += devicePollInterval
+		da.lastPoll = now
+		s.mu.Unlock()
+		writeOAuthError(w, http.StatusBadRequest, "slow_down", "")
+		return
+	}
+	da.lastPoll = now
+	switch {
+	case da.denied:
+		s.deleteDeviceAuthLocked(da)
+		s.mu.Unlock()
+		writeOAuthError(w, http.StatusBadRequest, "access_denied", "")
+		return
+	case !da.approved:
+		s.mu.Unlock()
+		writeOAuthError(w, http.StatusBadRequest, "authorization_pending", "")
+		return
+	}
+	s.deleteDeviceAuthLocked(da)
+	ar := *da.ar
+	s.mu.Unlock()
+
+	ar.grantID = rands.HexString(16)
+	s.issueTokens(w, &ar, "")
+}
+
+//go:embed ui-device.html
+var deviceHTML string
+
+var deviceTmpl = template.Must(headerTmpl.New("device").Parse(deviceHTML))
+
+type deviceDisplayData struct {
+	UserCode   string
+	ClientName string
+	User       string
+	Pending    bool // whether to ask the user to approve the request
+	Success    string
+	Error      string
+}
+
+// serveDeviceVerification serves the page on which users approve or deny
+// device authorization requests. It's only available over the tailnet, as
+// it identifies the user by their node.
+func (s *idpServer) serveDeviceVerification(w http.ResponseWriter, r *http.Request) {
+	if isFunnelRequest(r) {
+		http.Error(w, "tsidp: not found", http.StatusNotFound)
+		return
+	}
+	if r.Method != "GET" && r.Method != "POST" {
+		http.Error(w, "tsidp: method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := http.NewCrossOriginProtection().Check(r); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	who, err := s.lc.WhoIs(r.Context(), s.visitorAddr(r))
+	if err != nil {
+		log.Printf("Error getting WhoIs: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := deviceDisplayData{UserCode: r.FormValue("user_code")}
+	if who.UserProfile != nil {
+		data.User = who.UserProfile.LoginName
+	}
+	render := func() {
+		var buf bytes.Buffer
+		if err := deviceTmpl.Execute(&buf, data); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		buf.WriteTo(w)
+	}
+	if data.UserCode == "" {
+		render()
+		return
+	}
+	if who.Node.IsTagged() {
+		data.Error = "Tagged nodes can't approve device requests. Use a device that's logged in as a user."
+		render()
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	da, ok := s.userCode[normalizeUserCode(data.UserCode)]
+	if !ok || da.approved || da.denied || da.expires.Before(time.Now()) {
+		data.Error = "Unknown or expired code. Check the code and try again."
+		render()
+		return
+	}
+	data.UserCode = da.userCode
+	data.ClientName = da.clientName
+
+	if r.Method == "GET" {
+		data.Pending = true
+		render()
+		return
+	}
+	switch r.FormValue("action") {
+	case "approve":
+		da.ar.remoteUser = who
+		da.approved = true
+		data.Success = "Device approved. You can return to your device."
+	case "deny":
+		da.denied = true
+		data.Success = "Request denied."
+	default:
+		data.Pending = true
+	}
+	render()
+}
@@ -0,0 +1,301 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/client/local"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/net/memnet"
+	"tailscale.com/tailcfg"
+)
+
+const (
+	testUserAddr   = "100.64.0.1:1234"
+	testTaggedAddr = "100.64.0.2:1234"
+)
+
+// setupTestServerWithWhoIs returns a test server whose LocalClient knows
+// about a user node at testUserAddr and a tagged node at testTaggedAddr.
+func setupTestServerWithWhoIs(t *testing.T, strictMode bool) *idpServer {
+	t.Helper()
+	whoIs := map[string]*apitype.WhoIsResponse{
+		testUserAddr: {
+			Node:        &tailcfg.Node{ID: 1, Name: "laptop.test.ts.net.", User: 456},
+			UserProfile: &tailcfg.UserProfile{LoginName: "alice@example.com"},
+		},
+		testTaggedAddr: {
+			Node:        &tailcfg.Node{ID: 2, StableID: "nsvc", Name: "svc.test.ts.net.", Tags: []string{"tag:svc"}},
+			UserProfile: &tailcfg.UserProfile{LoginName: "tagged-devices"},
+		},
+	}
+	return setupTestServerWithClient(t, strictMode, fakeWhoIsClient(t, func(addr string) *apitype.WhoIsResponse {
+		return whoIs[addr]
+	}))
+}
+
+// fakeWhoIsClient returns a LocalClient whose WhoIs calls are answered by
+// whoIs, which returns nil for unknown addresses.
+func fakeWhoIsClient(t *testing.T, whoIs func(addr string) *apitype.WhoIsResponse) *local.Client {
+	t.Helper()
+	ln := memnet.Listen("local-tailscaled.sock:80")
+	localapi := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if who := whoIs(r.URL.Query().Get("addr")); r.URL.Path == "/localapi/v0/whois" && who != nil {
+			json.NewEncoder(w).Encode(who)
+			return
+		}
+		http.Error(w, "not found", http.StatusNotFound)
+	})}
+	go localapi.Serve(ln)
+	t.Cleanup(func() { localapi.Close() })
+	return &local.Client{Dial: ln.Dial}
+}
+
+func TestCodeChallenge(t *testing.T) {
+	verifier := strings.Repeat("v", 43)
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	tests := []struct {
+		query   url.Values
+		want    string
+		wantErr bool
+	}{
+		{query: url.Values{}},
+		{query: url.Values{"code_challenge": {challenge}, "code_challenge_method": {"S256"}}, want: challenge},
+		{query: url.Values{"code_challenge": {challenge}, "code_challenge_method": {"plain"}}, wantErr: true},
+		{query: url.Values{"code_challenge": {challenge}}, wantErr: true},
+		{query: url.Values{"code_challenge": {"short"}, "code_challenge_method": {"S256"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseCodeChallenge(tt.query)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("parseCodeChallenge(%v) = %q, %v; want %q, error %v", tt.query, got, err, tt.want, tt.wantErr)
+		}
+	}
+
+	if !verifyCodeVerifier(challenge, verifier) {
+		t.Error("valid verifier rejected")
+	}
+	if verifyCodeVerifier(challenge, strings.Repeat("w", 43)) {
+		t.Error("wrong verifier accepted")
+	}
+	if verifyCodeVerifier(challenge, "") {
+		t.Error("missing verifier accepted")
+	}
+	if !verifyCodeVerifier("", "") || verifyCodeVerifier("", verifier) {
+		t.Error("verifier without challenge not rejected")
+	}
+}
+
+func TestPKCEPublicClient(t *testing.T) {
+	verifier := strings.Repeat("v", 43)
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	srv := setupTestServerWithWhoIs(t, true)
+	srv.funnelClients["cli"] = &funnelClient{ID: "cli", RedirectURI: "http://localhost:8080/callback"}
+	authorize := func(q url.Values) *httptest.ResponseRecorder {
+		q.Set("client_id", "cli")
+		q.Set("redirect_uri", "http://localhost:8080/callback")
+		req := httptest.NewRequest("GET", "/authorize?"+q.Encode(), nil)
+		req.RemoteAddr = testUserAddr
+		rr := httptest.NewRecorder()
+		srv.authorize(rr, req)
+		return rr
+	}
+	if rr := authorize(url.Values{}); rr.Code != http.StatusBadRequest {
+		t.Fatalf("public client without PKCE: %d; want 400", rr.Code)
+	}
+	rr := authorize(url.Values{"code_challenge": {challenge}, "code_challenge_method": {"S256"}})
+	if rr.Code != http.StatusFound {
+		t.Fatalf("authorize: %d %s", rr.Code, rr.Body.String())
+	}
+	loc, err := url.Parse(rr.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := loc.Query().Get("code")
+
+	exchange := func(verifier string) *httptest.ResponseRecorder {
+		form := url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {code},
+			"redirect_uri":  {"http://localhost:8080/callback"},
+			"client_id":     {"cli"},
+			"code_verifier": {verifier},
+		}
+		req := httptest.NewRequest("POST", "/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		srv.serveToken(rr, req)
+		return rr
+	}
+	if rr := exchange(strings.Repeat("w", 43)); rr.Code != http.StatusBadRequest {
+		t.Errorf("exchange with wrong verifier: %d; want 400", rr.Code)
+	}
+	// The code is consumed by a failed exchange, so get another one.
+	rr = authorize(url.Values{"code_challenge": {challenge}, "code_challenge_method": {"S256"}})
+	loc, _ = url.Parse(rr.Header().Get("Location"))
+	code = loc.Query().Get("code")
+	if rr := exchange(verifier); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "id_token") {
+		t.Errorf("exchange with verifier: %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestClientCredentialsGrant(t *testing.T) {
+	srv := setupTestServerWithWhoIs(t, true)
+	grant := func(addr, secret string) *httptest.ResponseRecorder {
+		form := url.Values{"grant_type": {"client_credentials"}}
+		req := httptest.NewRequest("POST", "/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("test-client", secret)
+		req.RemoteAddr = addr
+		rr := httptest.NewRecorder()
+		srv.serveToken(rr, req)
+		return rr
+	}
+
+	rr := grant(testTaggedAddr, "test-secret")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("tagged node: %d %s", rr.Code, rr.Body.String())
+	}
+	var resp oidcTokenResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.AccessToken == "" || resp.IDToken != "" || resp.RefreshToken != "" {
+		t.Errorf("response = %+v; want only an access token", resp)
+	}
+	if got := introspect(t, srv, "test-secret", resp.AccessToken); !got.Active || got.Sub != "nsvc" || got.Username != "svc.test.ts.net" {
+		t.Errorf("introspecting token = %+v", got)
+	}
+
+	if rr := grant(testUserAddr, "test-secret"); rr.Code != http.StatusBadRequest {
+		t.Errorf("user node: %d; want 400", rr.Code)
+	}
+	if rr := grant(testTaggedAddr, "wrong-secret"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("wrong secret: %d; want 401", rr.Code)
+	}
+}
+
+func TestDeviceFlow(t *testing.T) {
+	srv := setupTestServerWithWhoIs(t, true)
+	srv.funnelClients["cli"] = &funnelClient{ID: "cli", Name: "My CLI"}
+
+	post := func(handler http.HandlerFunc, addr string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = addr
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+	start := func() deviceAuthorizationResponse {
+		t.Helper()
+		rr := post(srv.serveDeviceAuthorization, "203.0.113.1:1234", url.Values{"client_id": {"cli"}})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("device authorization: %d %s", rr.Code, rr.Body.String())
+		}
+		var resp deviceAuthorizationResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	poll := func(deviceCode string) (string, *httptest.ResponseRecorder) {
+		t.Helper()
+		// Pretend that the client waited for the poll interval.
+		srv.mu.Lock()
+		if da, ok := srv.deviceCode[deviceCode]; ok {
+			da.lastPoll = time.Time{}
+		}
+		srv.mu.Unlock()
+		rr := post(srv.serveToken, "203.0.113.1:1234", url.Values{
+			"grant_type":  {deviceCodeGrantType},
+			"device_code": {deviceCode},
+			"client_id":   {"cli"},
+		})
+		var resp struct{ Error string }
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		return resp.Error, rr
+	}
+
+	dev := start()
+	if dev.VerificationURI != "https://test.ts.net/device" || !strings.Contains(dev.VerificationURIComplete, "user_code=") || len(dev.UserCode) != 9 {
+		t.Errorf("device authorization response = %+v", dev)
+	}
+	if e, _ := poll(dev.DeviceCode); e != "authorization_pending" {
+		t.Errorf("poll before approval = %q; want authorization_pending", e)
+	}
+	rr := post(srv.serveToken, "203.0.113.1:1234", url.Values{"grant_type": {deviceCodeGrantType}, "device_code": {dev.DeviceCode}, "client_id": {"cli"}})
+	if !strings.Contains(rr.Body.String(), "slow_down") {
+		t.Errorf("fast poll = %s; want slow_down", rr.Body.String())
+	}
+
+	// The verification page asks the user to approve the request, and
+	// accepts the code in any case and without the separator.
+	req := httptest.NewRequest("GET", "/device?user_code="+strings.ToLower(strings.ReplaceAll(dev.UserCode, "-", "")), nil)
+	req.RemoteAddr = testUserAddr
+	rr = httptest.NewRecorder()
+	srv.serveDeviceVerification(rr, req)
+	if body := rr.Body.String(); !strings.Contains(body, "My CLI") || !strings.Contains(body, "alice@example.com") || !strings.Contains(body, `value="approve"`) {
+		t.Errorf("verification page doesn't ask for approval:\n%s", body)
+	}
+	if rr := post(srv.serveDeviceVerification, testTaggedAddr, url.Values{"user_code": {dev.UserCode}, "action": {"approve"}}); !strings.Contains(rr.Body.String(), "Tagged nodes") {
+		t.Errorf("tagged node approved request")
+	}
+	if rr := post(srv.serveDeviceVerification, testUserAddr, url.Values{"user_code": {dev.UserCode}, "action": {"approve"}}); !strings.Contains(rr.Body.String(), "Device approved") {
+		t.Errorf("approving request: %s", rr.Body.String())
+	}
+
+	e, rr := poll(dev.DeviceCode)
+	if e != "" || rr.Code != http.StatusOK {
+		t.Fatalf("poll after approval: %d %s", rr.Code, rr.Body.String())
+	}
+	var tokens oidcTokenResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &tokens); err != nil {
+		t.Fatal(err)
+	}
+	if tokens.IDToken == "" || tokens.RefreshToken == "" {
+		t.Errorf("tokens = %+v", tokens)
+	}
+	srv.mu.Lock()
+	ar := srv.accessToken[tokens.AccessToken]
+	srv.mu.Unlock()
+	if ar == nil || ar.remoteUser.UserProfile.LoginName != "alice@example.com" {
+		t.Errorf("access token not issued for approving user")
+	}
+	if e, _ := poll(dev.DeviceCode); e != "invalid_grant" {
+		t.Errorf("poll after tokens were issued = %q; want invalid_grant", e)
+	}
+
+	// Denied requests.
+	dev = start()
+	post(srv.serveDeviceVerification, testUserAddr, url.Values{"user_code": {dev.UserCode}, "action": {"deny"}})
+	if e, _ := poll(dev.DeviceCode); e != "access_denied" {
+		t.Errorf("poll after denial = %q; want access_denied", e)
+	}
+
+	// Cross-site approvals are rejected.
+	dev = start()
+	req = httptest.NewRequest("POST", "/device", strings.NewReader(url.Values{"user_code": {dev.UserCode}, "action": {"approve"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Sec-Fetch-Site", "cross-site")
+	req.RemoteAddr = testUserAddr
+	rr = httptest.NewRecorder()
+	srv.serveDeviceVerification(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("cross-site approval: %d; want 403", rr.Code)
+	}
+}
@@ -0,0 +1,618 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto"
+	crand "crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"maps"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+	"tailscale.com/util/rands"
+)
+
+// samlServiceProvidersFile is where the registered SAML service providers
+// are stored.
+const samlServiceProvidersFile = "saml-service-providers.json"
+
+const (
+	samlMetadataPath = "/saml/metadata"
+	samlSSOPath      = "/saml/sso"
+)
+
+const (
+	nsSAMLAssertion = "urn:oasis:names:tc:SAML:2.0:assertion"
+	nsSAMLProtocol  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	nsSAMLMetadata  = "urn:oasis:names:tc:SAML:2.0:metadata"
+	nsXMLDSig       = "http://www.w3.org/2000/09/xmldsig#"
+
+	samlBindingRedirect = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+	samlBindingPOST     = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+
+	samlNameIDEmail       = "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"
+	samlNameIDPersistent  = "urn:oasis:names:tc:SAML:2.0:nameid-format:persistent"
+	samlNameIDUnspecified = "urn:oasis:names:tc:SAML:1.1:nameid-format:unspecified"
+
+	xmlExcC14N = "http://www.w3.org/2001/10/xml-exc-c14n#"
+)
+
+// samlAssertionLifetime is how long a SAML assertion is valid for.
+const samlAssertionLifetime = 5 * time.Minute
+
+// samlServiceProvider is a SAML service provider (SP) that users can sign
+// in to with tsidp, the SAML equivalent of a funnelClient.
+type samlServiceProvider struct {
+	// ID identifies the SP in the UI. Entity IDs are usually URLs, which
+	// don't make good path components.
+	ID       string `json:"id"`
+	Name     string `json:"name,omitempty"`
+	EntityID string `json:"entity_id"`
+
+	// ACSURL is the URL of the SP's assertion consumer service, which
+	// assertions are posted to.
+	ACSURL string `json:"acs_url"`
+
+	// AttributeMap maps the names of the SAML attributes sent to the SP to
+	// the claims that they're set from, like "mail" to "email". Claims
+	// include the claims of ID tokens and the extra claims of capRules. If
+	// empty, all claims are sent as attributes of the same name.
+	AttributeMap map[string]string `json:"attribute_map,omitempty"`
+}
+
+// xmlNode is an XML element with unprefixed attributes and either text or
+// child elements.
+//
+// Nodes are serialized in the canonical form of Exclusive XML
+// Canonicalization (https://www.w3.org/TR/xml-exc-c14n/), provided that
+// namespace prefixes are declared on the outermost element that uses them.
+// That lets the digest of a signed element be computed over its serialized
+// form, without implementing canonicalization of arbitrary XML.
+type xmlNode struct {
+	name     string
+	attrs    []xmlAttr
+	text     string
+	children []*xmlNode
+}
+
+type xmlAttr struct {
+	name, value string
+}
+
+// newXMLNode returns an element with the given name and attributes, given
+// as name and value pairs.
+func newXMLNode(name string, attrs ...string) *xmlNode {
+	n := &xmlNode{name: name}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		n.attrs = append(n.attrs, xmlAttr{attrs[i], attrs[i+1]})
+	}
+	return n
+}
+
+// add appends children to n and returns n.
+func (n *xmlNode) add(children ...*xmlNode) *xmlNode {
+	n.children = append(n.children, children...)
+	return n
+}
+
+// withText sets the text of n and returns n.
+func (n *xmlNode) withText(text string) *xmlNode {
+	n.text = text
+	return n
+}
+
+func (n *xmlNode) attr(name string) string {
+	for _, a := range n.attrs {
+		if a.name == name {
+			return a.value
+		}
+	}
+	return ""
+}
+
+// isNamespaceDecl reports whether the attribute named name declares a
+// namespace.
+func isNamespaceDecl(name string) bool {
+	return name == "xmlns" || strings.HasPrefix(name, "xmlns:")
+}
+
+// canonical returns the canonical serialization of n.
+func (n *xmlNode) canonical() []byte {
+	var buf bytes.Buffer
+	n.writeTo(&buf)
+	return buf.Bytes()
+}
+
+func (n *xmlNode) writeTo(buf *bytes.Buffer) {
+	// Namespace declarations come first, sorted by prefix (with the
+	// default namespace first), followed by the other attributes, sorted
+	// by name as they're unprefixed.
+	attrs := slices.Clone(n.attrs)
+	slices.SortStableFunc(attrs, func(a, b xmlAttr) int {
+		if an, bn := isNamespaceDecl(a.name), isNamespaceDecl(b.name); an != bn {
+			if an {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.name, b.name)
+	})
+	buf.WriteByte('<')
+	buf.WriteString(n.name)
+	for _, a := range attrs {
+		buf.WriteByte(' ')
+		buf.WriteString(a.name)
+		buf.WriteString(`="`)
+		buf.WriteString(xmlAttrEscaper.Replace(xmlValidChars(a.value)))
+		buf.WriteByte('"')
+	}
+	buf.WriteByte('>')
+	buf.WriteString(xmlTextEscaper.Replace(xmlValidChars(n.text)))
+	for _, c := range n.children {
+		c.writeTo(buf)
+	}
+	buf.WriteString("</")
+	buf.WriteString(n.name)
+	buf.WriteByte('>')
+}
+
+// The escaping of canonical XML, which differs from that of encoding/xml.
+var (
+	xmlTextEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+	xmlAttrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+)
+
+// xmlValidChars returns s without the characters that can't appear in XML.
+func xmlValidChars(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' || r == 0xFFFE || r == 0xFFFF {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// signXML adds an enveloped XML signature of n to n, as its child at index
+// at, signed with sk. n must have an ID attribute, and cert is the DER
+// encoded certificate of sk to include in the signature.
+func signXML(n *xmlNode, sk *signingKey, cert []byte, at int) error {
+	digest := sha256.Sum256(n.canonical())
+	signedInfo := newXMLNode("ds:SignedInfo", "xmlns:ds", nsXMLDSig).add(
+		newXMLNode("ds:CanonicalizationMethod", "Algorithm", xmlExcC14N),
+		newXMLNode("ds:SignatureMethod", "Algorithm", "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"),
+		newXMLNode("ds:Reference", "URI", "#"+n.attr("ID")).add(
+			newXMLNode("ds:Transforms").add(
+				newXMLNode("ds:Transform", "Algorithm", "http://www.w3.org/2000/09/xmldsig#enveloped-signature"),
+				newXMLNode("ds:Transform", "Algorithm", xmlExcC14N),
+			),
+			newXMLNode("ds:DigestMethod", "Algorithm", "http://www.w3.org/2001/04/xmlenc#sha256"),
+			newXMLNode("ds:DigestValue").withText(base64.StdEncoding.EncodeToString(digest[:])),
+		),
+	)
+	h := sha256.Sum256(signedInfo.canonical())
+	sig, err := rsa.SignPKCS1v15(nil, sk.k, crypto.SHA256, h[:])
+	if err != nil {
+		return err
+	}
+	signature := newXMLNode("ds:Signature", "xmlns:ds", nsXMLDSig).add(
+		signedInfo,
+		newXMLNode("ds:SignatureValue").withText(base64.StdEncoding.EncodeToString(sig)),
+		samlKeyInfo(cert),
+	)
+	n.children = slices.Insert(n.children, at, signature)
+	return nil
+}
+
+func samlKeyInfo(cert []byte) *xmlNode {
+	return newXMLNode("ds:KeyInfo", "xmlns:ds", nsXMLDSig).add(
+		newXMLNode("ds:X509Data").add(
+			newXMLNode("ds:X509Certificate").withText(base64.StdEncoding.EncodeToString(cert)),
+		),
+	)
+}
+
+// samlCertificate returns a DER encoded self-signed certificate for the OIDC
+// signing key, which SAML uses to convey the key. The certificate is
+// deterministic, so that it only changes with the key.
+func (s *idpServer) samlCertificate() ([]byte, error) {
+	return s.lazySAMLCert.GetErr(func() ([]byte, error) {
+		sk, err := s.oidcPrivateKey()
+		if err != nil {
+			return nil, err
+		}
+		tmpl := &x509.Certificate{
+			SerialNumber: new(big.Int).SetUint64(sk.kid),
+			Subject:      pkix.Name{CommonName: "tsidp"},
+			NotBefore:    time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+			NotAfter:     time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+		}
+		// The signature of an RSA key doesn't depend on the randomness.
+		return x509.CreateCertificate(crand.Reader, tmpl, tmpl, sk.k.Public(), sk.k)
+	})
+}
+
+// samlEntityID returns the entity ID of tsidp as a SAML identity provider.
+func (s *idpServer) samlEntityID() string {
+	return s.serverURL + samlMetadataPath
+}
+
+// serveSAMLMetadata serves the SAML metadata of tsidp, which service
+// providers are configured with.
+func (s *idpServer) serveSAMLMetadata(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "tsidp: method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cert, err := s.samlCertificate()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	idp := newXMLNode("md:IDPSSODescriptor",
+		"protocolSupportEnumeration", nsSAMLProtocol,
+		"WantAuthnRequestsSigned", "false",
+	).add(newXMLNode("md:KeyDescriptor", "use", "signing").add(samlKeyInfo(cert)))
+	for _, f := range []string{samlNameIDEmail, samlNameIDPersistent, samlNameIDUnspecified} {
+		idp.add(newXMLNode("md:NameIDFormat").withText(f))
+	}
+	for _, b := range []string{samlBindingRedirect, samlBindingPOST} {
+		idp.add(newXMLNode("md:SingleSignOnService", "Binding", b, "Location", s.serverURL+samlSSOPath))
+	}
+	md := newXMLNode("md:EntityDescriptor", "xmlns:md", nsSAMLMetadata, "entityID", s.samlEntityID()).add(idp)
+
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	io.WriteString(w, xml.Header)
+	w.Write(md.canonical())
+}
+
+// samlAuthnRequest is the part of a SAML AuthnRequest that tsidp uses.
+type samlAuthnRequest struct {
+	XMLName                     xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:protocol AuthnRequest"`
+	ID                          string   `xml:",attr"`
+	AssertionConsumerServiceURL string   `xml:",attr"`
+	Issuer                      string   `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+	NameIDPolicy                struct {
+		Format string `xml:",attr"`
+	} `xml:"urn:oasis:names:tc:SAML:2.0:protocol NameIDPolicy"`
+}
+
+// parseSAMLRequest parses the AuthnRequest of r, sent with either the
+// HTTP-Redirect or HTTP-POST binding.
+func parseSAMLRequest(r *http.Request) (*samlAuthnRequest, error) {
+	b, err := base64.StdEncoding.DecodeString(r.FormValue("SAMLRequest"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SAMLRequest: %w", err)
+	}
+	if r.Method == "GET" {
+		// The HTTP-Redirect binding deflates the request.
+		b, err = io.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(b)), 1<<20))
+		if err != nil {
+			return nil, fmt.Errorf("invalid SAMLRequest: %w", err)
+		}
+	}
+	var req samlAuthnRequest
+	if err := xml.Unmarshal(b, &req); err != nil {
+		return nil, fmt.Errorf("invalid SAMLRequest: %w", err)
+	}
+	if req.ID == "" || req.Issuer == "" {
+		return nil, errors.New("invalid SAMLRequest: missing ID or Issuer")
+	}
+	return &req, nil
+}
+
+var samlPostTmpl = template.Must(template.New("saml-post").Parse(`<!DOCTYPE html>
+<html>
+  <head><title>Signing in...</title></head>
+  <body onload="document.forms[0].submit()">
+    <form method="POST" action="{{.ACSURL}}">
+      <input type="hidden" name="SAMLResponse" value="{{.SAMLResponse}}">
+      {{if .RelayState}}<input type="hidden" name="RelayState" value="{{.RelayState}}">{{end}}
+      <noscript><button type="submit">Continue</button></noscript>
+    </form>
+  </body>
+</html>
+`))
+
+// serveSAMLSSO implements the single sign-on service of the SAML web
+// browser SSO profile. Like the authorize endpoint, it's visited by the user
+// being authenticated, so it's only available over the tailnet. It responds
+// with a page that posts the signed assertion to the service provider.
+func (s *idpServer) serveSAMLSSO(w http.ResponseWriter, r *http.Request) {
+	if isFunnelRequest(r) {
+		http.Error(w, "tsidp: unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != "GET" && r.Method != "POST" {
+		http.Error(w, "tsidp: method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	req, err := parseSAMLRequest(r)
+	if err != nil {
+		http.Error(w, "tsidp: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var sp *samlServiceProvider
+	s.mu.Lock()
+	for _, v := range s.samlServiceProviders {
+		if v.EntityID == req.Issuer {
+			sp = v
+			break
+		}
+	}
+	s.mu.Unlock()
+	if sp == nil {
+		http.Error(w, "tsidp: unknown SAML service provider", http.StatusBadRequest)
+		return
+	}
+	if req.AssertionConsumerServiceURL != "" && req.AssertionConsumerServiceURL != sp.ACSURL {
+		http.Error(w, "tsidp: AssertionConsumerServiceURL mismatch", http.StatusBadRequest)
+		return
+	}
+
+	who, err := s.lc.WhoIs(r.Context(), s.visitorAddr(r))
+	if err != nil {
+		log.Printf("Error getting WhoIs: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if who.Node.IsTagged() {
+		http.Error(w, "tsidp: tagged nodes not supported", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := s.samlResponse(sp, req, who, time.Now())
+	if err != nil {
+		log.Printf("tsidp: creating SAML response: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var buf bytes.Buffer
+	if err := samlPostTmpl.Execute(&buf, map[string]string{
+		"ACSURL":       sp.ACSURL,
+		"SAMLResponse": base64.StdEncoding.EncodeToString(resp),
+		"RelayState":   r.FormValue("RelayState"),
+	}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	buf.WriteTo(w)
+}
+
+// samlResponse returns a SAML Response to req from sp, with an assertion
+// about who signed with the OIDC signing key.
+func (s *idpServer) samlResponse(sp *samlServiceProvider, req *samlAuthnRequest, who *apitype.WhoIsResponse, now time.Time) ([]byte, error) {
+	attrs, err := samlAttributes(sp, who)
+	if err != nil {
+		return nil, err
+	}
+	sk, err := s.oidcPrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	cert, err := s.samlCertificate()
+	if err != nil {
+		return nil, err
+	}
+
+	nameIDFormat, nameID := samlNameIDEmail, who.UserProfile.LoginName
+	switch req.NameIDPolicy.Format {
+	case samlNameIDPersistent:
+		nameIDFormat, nameID = samlNameIDPersistent, who.Node.User.String()
+	case samlNameIDUnspecified:
+		nameIDFormat = samlNameIDUnspecified
+	}
+
+	instant := now.UTC().Format(time.RFC3339)
+	expiry := now.Add(samlAssertionLifetime).UTC().Format(time.RFC3339)
+	attrStmt := newXMLNode("saml:AttributeStatement")
+	for _, name := range slices.Sorted(maps.Keys(attrs)) {
+		a := newXMLNode("saml:Attribute", "Name", name, "NameFormat", "urn:oasis:names:tc:SAML:2.0:attrname-format:basic")
+		for _, v := range attrs[name] {
+			a.add(newXMLNode("saml:AttributeValue").withText(v))
+		}
+		attrStmt.add(a)
+	}
+	assertion := newXMLNode("saml:Assertion",
+		"xmlns:saml", nsSAMLAssertion,
+		"ID", "_"+rands.HexString(32),
+		"IssueInstant", instant,
+		"Version", "2.0",
+	).add(
+		newXMLNode("saml:Issuer").withText(s.samlEntityID()),
+		newXMLNode("saml:Subject").add(
+			newXMLNode("saml:NameID", "Format", nameIDFormat).withText(nameID),
+			newXMLNode("saml:SubjectConfirmation", "Method", "urn:oasis:names:tc:SAML:2.0:cm:bearer").add(
+				newXMLNode("saml:SubjectConfirmationData",
+					"InResponseTo", req.ID,
+					"NotOnOrAfter", expiry,
+					"Recipient", sp.ACSURL,
+				),
+			),
+		),
+		newXMLNode("saml:Conditions", "NotBefore", instant, "NotOnOrAfter", expiry).add(
+			newXMLNode("saml:AudienceRestriction").add(
+				newXMLNode("saml:Audience").withText(sp.EntityID),
+			),
+		),
+		newXMLNode("saml:AuthnStatement", "AuthnInstant", instant, "SessionIndex", "_"+rands.HexString(16)).add(
+			newXMLNode("saml:AuthnContext").add(
+				newXMLNode("saml:AuthnContextClassRef").withText("urn:oasis:names:tc:SAML:2.0:ac:classes:unspecified"),
+			),
+		),
+		attrStmt,
+	)
+	// The signature goes after the Issuer.
+	if err := signXML(assertion, sk, cert, 1); err != nil {
+		return nil, err
+	}
+
+	resp := newXMLNode("samlp:Response",
+		"xmlns:samlp", nsSAMLProtocol,
+		"xmlns:saml", nsSAMLAssertion,
+		"ID", "_"+rands.HexString(32),
+		"InResponseTo", req.ID,
+		"Destination", sp.ACSURL,
+		"IssueInstant", instant,
+		"Version", "2.0",
+	).add(
+		newXMLNode("saml:Issuer").withText(s.samlEntityID()),
+		newXMLNode("samlp:Status").add(
+			newXMLNode("samlp:StatusCode", "Value", "urn:oasis:names:tc:SAML:2.0:status:Success"),
+		),
+		assertion,
+	)
+	return append([]byte(xml.Header), resp.canonical()...), nil
+}
+
+// samlAttributes returns the SAML attributes about who to send to sp, from
+// the claims that an ID token would have and the extra claims of the
+// tsidp capability grants that apply to who.
+func samlAttributes(sp *samlServiceProvider, who *apitype.WhoIsResponse) (map[string][]string, error) {
+	rules, err := tailcfg.UnmarshalCapJSON[capRule](who.CapMap, tailcfg.PeerCapabilityTsIDP)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal capability: %w", err)
+	}
+	claims, err := withExtraClaims(userClaims(who), rules)
+	if err != nil {
+		return nil, err
+	}
+	attrMap := sp.AttributeMap
+	if len(attrMap) == 0 {
+		attrMap = make(map[string]string, len(claims))
+		for k := range claims {
+			attrMap[k] = k
+		}
+	}
+	attrs := make(map[string][]string)
+	for attr, claim := range attrMap {
+		if vals := samlAttributeValues(claims[claim]); len(vals) > 0 {
+			// The order of merged extra claims isn't meaningful, so
+			// sort values to keep assertions deterministic.
+			slices.Sort(vals)
+			attrs[attr] = vals
+		}
+	}
+	return attrs, nil
+}
+
+// samlAttributeValues returns the values of the SAML attribute for a claim
+// value, as decoded from JSON. Arrays are multi-valued attributes, and
+// objects are sent as JSON.
+func samlAttributeValues(v any) []string {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case string:
+		return []string{v}
+	case float64:
+		return []string{strconv.FormatFloat(v, 'f', -1, 64)}
+	case bool:
+		return []string{strconv.FormatBool(v)}
+	case []any:
+		var vals []string
+		for _, e := range v {
+			if _, isSlice := e.([]any); isSlice {
+				b, _ := json.Marshal(e)
+				vals = append(vals, string(b))
+				continue
+			}
+			vals = append(vals, samlAttributeValues(e)...)
+		}
+		return vals
+	default:
+		b, _ := json.Marshal(v)
+		return []string{string(b)}
+	}
+}
+
+// parseAttributeMap parses an attribute map as entered in the UI, with one
+// "attribute=claim" mapping per line.
+func parseAttributeMap(s string) (map[string]string, error) {
+	var m map[string]string
+	for line := range strings.Lines(s) {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		attr, claim, ok := strings.Cut(line, "=")
+		attr, claim = strings.TrimSpace(attr), strings.TrimSpace(claim)
+		if !ok || attr == "" || claim == "" {
+			return nil, fmt.Errorf("invalid attribute mapping %q; want attribute=claim", line)
+		}
+		if m == nil {
+			m = make(map[string]string)
+		}
+		m[attr] = claim
+	}
+	return m, nil
+}
+
+// formatAttributeMap formats an attribute map for editing in the UI.
+func formatAttributeMap(m map[string]string) string {
+	var sb strings.Builder
+	for _, attr := range slices.Sorted(maps.Keys(m)) {
+		fmt.Fprintf(&sb, "%s=%s\n", attr, m[attr])
+	}
+	return sb.String()
+}
+
+// storeSAMLServiceProvidersLocked writes the registered SAML service
+// providers to disk. s.mu must be held.
+func (s *idpServer) storeSAMLServiceProvidersLocked() error {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(s.samlServiceProviders); err != nil {
+		return err
+	}
+	path, err := getConfigFilePath(s.rootPath, samlServiceProvidersFile)
+	if err != nil {
+		return fmt.Errorf("storeSAMLServiceProvidersLocked: %v", err)
+	}
+	return os.WriteFile(path, buf.Bytes(), 0600)
+}
+
+// loadSAMLServiceProviders loads the SAML service providers stored by
+// storeSAMLServiceProvidersLocked.
+func (s *idpServer) loadSAMLServiceProviders() error {
+	path, err := getConfigFilePath(s.rootPath, samlServiceProvidersFile)
+	if err != nil {
+		return err
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := json.Unmarshal(b, &s.samlServiceProviders); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,337 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+)
+
+func TestXMLNodeCanonical(t *testing.T) {
+	n := newXMLNode("a:Elem", "z", "1", "xmlns:b", "urn:b", "b", "x\"&<>\t\n", "xmlns:a", "urn:a").add(
+		newXMLNode("a:Child").withText("x&<>\"\r\x01"),
+		newXMLNode("a:Empty"),
+	)
+	want := `<a:Elem xmlns:a="urn:a" xmlns:b="urn:b" b="x&quot;&amp;&lt;>&#x9;&#xA;" z="1"><a:Child>x&amp;&lt;&gt;"&#xD;</a:Child><a:Empty></a:Empty></a:Elem>`
+	if got := string(n.canonical()); got != want {
+		t.Errorf("canonical =\n%s\nwant\n%s", got, want)
+	}
+}
+
+// setupSAMLTestServer returns a test server with a signing key and a SAML
+// service provider.
+func setupSAMLTestServer(t *testing.T) (*idpServer, *samlServiceProvider) {
+	t.Helper()
+	srv := setupTestServerWithWhoIs(t, true)
+	srv.lazySigningKey.Set(&signingKey{kid: 42, k: mustGeneratePrivateKey(t)})
+	sp := &samlServiceProvider{
+		ID:       "sp1",
+		Name:     "Wiki",
+		EntityID: "https://wiki.example.com/saml",
+		ACSURL:   "https://wiki.example.com/saml/acs",
+	}
+	srv.samlServiceProviders = map[string]*samlServiceProvider{sp.ID: sp}
+	return srv, sp
+}
+
+// verifySAMLAssertion checks the signature of the assertion in resp, which
+// tsidp writes in canonical form, and returns the certificate it was signed
+// with.
+func verifySAMLAssertion(t *testing.T, resp string) *x509.Certificate {
+	t.Helper()
+	assertion := regexp.MustCompile(`<saml:Assertion .*</saml:Assertion>`).FindString(resp)
+	signature := regexp.MustCompile(`<ds:Signature .*</ds:Signature>`).FindString(assertion)
+	signedInfo := regexp.MustCompile(`<ds:SignedInfo .*</ds:SignedInfo>`).FindString(signature)
+	if assertion == "" || signature == "" || signedInfo == "" {
+		t.Fatalf("no signed assertion in response:\n%s", resp)
+	}
+	field := func(name string) []byte {
+		t.Helper()
+		m := regexp.MustCompile(fmt.Sprintf(`<ds:%s>([^<]*)</ds:%s>`, name, name)).FindStringSubmatch(signature)
+		if m == nil {
+			t.Fatalf("no %s in signature", name)
+		}
+		b, err := base64.StdEncoding.DecodeString(m[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	// With the enveloped signature removed, the assertion is already in
+	// canonical form.
+	digest := sha256.Sum256([]byte(strings.Replace(assertion, signature, "", 1)))
+	if !bytes.Equal(digest[:], field("DigestValue")) {
+		t.Error("digest doesn't match assertion")
+	}
+	cert, err := x509.ParseCertificate(field("X509Certificate"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := sha256.Sum256([]byte(signedInfo))
+	if err := rsa.VerifyPKCS1v15(cert.PublicKey.(*rsa.PublicKey), crypto.SHA256, h[:], field("SignatureValue")); err != nil {
+		t.Errorf("invalid signature: %v", err)
+	}
+	return cert
+}
+
+func TestSAMLResponse(t *testing.T) {
+	srv, sp := setupSAMLTestServer(t)
+	sp.AttributeMap = map[string]string{"mail": "email", "uid": "username", "groups": "groups"}
+	who := &apitype.WhoIsResponse{
+		Node:        &tailcfg.Node{ID: 1, Name: "laptop.test.ts.net.", User: 456},
+		UserProfile: &tailcfg.UserProfile{LoginName: "alice&bob@example.com"},
+		CapMap: tailcfg.PeerCapMap{
+			tailcfg.PeerCapabilityTsIDP: {
+				mustMarshalJSON(t, capRule{ExtraClaims: map[string]any{"groups": []string{"admins", "devs"}}}),
+			},
+		},
+	}
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	b, err := srv.samlResponse(sp, &samlAuthnRequest{ID: "_req1", Issuer: sp.EntityID}, who, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := verifySAMLAssertion(t, string(b))
+	if !cert.PublicKey.(*rsa.PublicKey).Equal(&mustGeneratePrivateKey(t).PublicKey) {
+		t.Error("assertion not signed with the signing key")
+	}
+
+	var resp struct {
+		InResponseTo string `xml:",attr"`
+		Destination  string `xml:",attr"`
+		Assertion    struct {
+			Subject struct {
+				NameID struct {
+					Format string `xml:",attr"`
+					Value  string `xml:",chardata"`
+				}
+				SubjectConfirmation struct {
+					SubjectConfirmationData struct {
+						InResponseTo string `xml:",attr"`
+						NotOnOrAfter string `xml:",attr"`
+						Recipient    string `xml:",attr"`
+					}
+				}
+			}
+			Conditions struct {
+				Audience string `xml:"AudienceRestriction>Audience"`
+			}
+			Attributes []struct {
+				Name   string   `xml:",attr"`
+				Values []string `xml:"AttributeValue"`
+			} `xml:"AttributeStatement>Attribute"`
+		}
+	}
+	if err := xml.Unmarshal(b, &resp); err != nil {
+		t.Fatal(err)
+	}
+	a := resp.Assertion
+	if resp.InResponseTo != "_req1" || resp.Destination != sp.ACSURL {
+		t.Errorf("response InResponseTo, Destination = %q, %q", resp.InResponseTo, resp.Destination)
+	}
+	if a.Subject.NameID.Value != "alice&bob@example.com" || a.Subject.NameID.Format != samlNameIDEmail {
+		t.Errorf("NameID = %+v", a.Subject.NameID)
+	}
+	if got := a.Subject.SubjectConfirmation.SubjectConfirmationData; got.InResponseTo != "_req1" || got.Recipient != sp.ACSURL || got.NotOnOrAfter != "2026-01-02T03:09:05Z" {
+		t.Errorf("SubjectConfirmationData = %+v", got)
+	}
+	if a.Conditions.Audience != sp.EntityID {
+		t.Errorf("Audience = %q", a.Conditions.Audience)
+	}
+	attrs := make(map[string][]string)
+	for _, attr := range a.Attributes {
+		attrs[attr.Name] = attr.Values
+	}
+	want := map[string][]string{
+		"mail":   {"alice&bob@example.com"},
+		"uid":    {"alice&bob"},
+		"groups": {"admins", "devs"},
+	}
+	if !reflect.DeepEqual(attrs, want) {
+		t.Errorf("attributes = %v; want %v", attrs, want)
+	}
+
+	// Without an attribute map, all claims are sent.
+	sp.AttributeMap = nil
+	got, err := samlAttributes(sp, who)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, claim := range []string{"email", "username", "nid", "node", "tailnet", "groups"} {
+		if len(got[claim]) == 0 {
+			t.Errorf("claim %q not sent as an attribute; got %v", claim, got)
+		}
+	}
+	if v := got["nid"]; len(v) != 1 || v[0] != "1" {
+		t.Errorf("nid attribute = %q; want 1", v)
+	}
+}
+
+func TestSAMLSSO(t *testing.T) {
+	srv, sp := setupSAMLTestServer(t)
+	authnRequest := func(issuer, acsURL string) string {
+		var buf bytes.Buffer
+		fw, _ := flate.NewWriter(&buf, flate.DefaultCompression)
+		fmt.Fprintf(fw, `<samlp:AuthnRequest xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="_abc" AssertionConsumerServiceURL=%q><saml:Issuer>%s</saml:Issuer></samlp:AuthnRequest>`, acsURL, issuer)
+		fw.Close()
+		return base64.StdEncoding.EncodeToString(buf.Bytes())
+	}
+	sso := func(samlRequest string) *httptest.ResponseRecorder {
+		q := url.Values{"SAMLRequest": {samlRequest}, "RelayState": {"/wiki/page"}}
+		req := httptest.NewRequest("GET", samlSSOPath+"?"+q.Encode(), nil)
+		req.RemoteAddr = testUserAddr
+		rr := httptest.NewRecorder()
+		srv.serveSAMLSSO(rr, req)
+		return rr
+	}
+
+	rr := sso(authnRequest(sp.EntityID, sp.ACSURL))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("SSO: %d %s", rr.Code, rr.Body.String())
+	}
+	body := rr.Body.String()
+	if !strings.Contains(body, `action="https://wiki.example.com/saml/acs"`) || !strings.Contains(body, `value="/wiki/page"`) {
+		t.Errorf("SSO page doesn't post to the ACS:\n%s", body)
+	}
+	m := regexp.MustCompile(`name="SAMLResponse" value="([^"]*)"`).FindStringSubmatch(body)
+	if m == nil {
+		t.Fatalf("no SAMLResponse in SSO page:\n%s", body)
+	}
+	resp, err := base64.StdEncoding.DecodeString(html.UnescapeString(m[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifySAMLAssertion(t, string(resp))
+	if !strings.Contains(string(resp), "alice@example.com") {
+		t.Errorf("response isn't about the visiting user:\n%s", resp)
+	}
+
+	if rr := sso(authnRequest("https://unknown.example.com", sp.ACSURL)); rr.Code != http.StatusBadRequest {
+		t.Errorf("unknown SP: %d; want 400", rr.Code)
+	}
+	if rr := sso(authnRequest(sp.EntityID, "https://evil.example.com/acs")); rr.Code != http.StatusBadRequest {
+		t.Errorf("ACS URL mismatch: %d; want 400", rr.Code)
+	}
+	if rr := sso("not base64!"); rr.Code != http.StatusBadRequest {
+		t.Errorf("invalid request: %d; want 400", rr.Code)
+	}
+}
+
+func TestSAMLMetadata(t *testing.T) {
+	srv, _ := setupSAMLTestServer(t)
+	rr := httptest.NewRecorder()
+	srv.serveSAMLMetadata(rr, httptest.NewRequest("GET", samlMetadataPath, nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("metadata: %d %s", rr.Code, rr.Body.String())
+	}
+	var md struct {
+		EntityID string `xml:"entityID,attr"`
+		IDP      struct {
+			Cert string `xml:"KeyDescriptor>KeyInfo>X509Data>X509Certificate"`
+			SSO  []struct {
+				Binding  string `xml:",attr"`
+				Location string `xml:",attr"`
+			} `xml:"SingleSignOnService"`
+		} `xml:"IDPSSODescriptor"`
+	}
+	if err := xml.Unmarshal(rr.Body.Bytes(), &md); err != nil {
+		t.Fatal(err)
+	}
+	if md.EntityID != "https://test.ts.net/saml/metadata" {
+		t.Errorf("entityID = %q", md.EntityID)
+	}
+	if len(md.IDP.SSO) != 2 || md.IDP.SSO[0].Location != "https://test.ts.net/saml/sso" {
+		t.Errorf("SSO services = %+v", md.IDP.SSO)
+	}
+
+	// The certificate is the same for the same key.
+	srv2, _ := setupSAMLTestServer(t)
+	cert2, err := srv2.samlCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.IDP.Cert != base64.StdEncoding.EncodeToString(cert2) {
+		t.Error("certificate changed for the same key")
+	}
+}
+
+func TestSAMLServiceProvidersUI(t *testing.T) {
+	srv, _ := setupSAMLTestServer(t)
+	post := func(path string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		srv.handleUI(rr, req)
+		return rr
+	}
+
+	rr := post("/saml/new", url.Values{
+		"name":          {"Tracker"},
+		"entity_id":     {"https://tracker.example.com"},
+		"acs_url":       {"https://tracker.example.com/acs"},
+		"attribute_map": {"mail = email\nuid=username\n"},
+	})
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("adding SP: %d %s", rr.Code, rr.Body.String())
+	}
+	var added *samlServiceProvider
+	for _, sp := range srv.samlServiceProviders {
+		if sp.Name == "Tracker" {
+			added = sp
+		}
+	}
+	if added == nil || !reflect.DeepEqual(added.AttributeMap, map[string]string{"mail": "email", "uid": "username"}) {
+		t.Fatalf("added SP = %+v", added)
+	}
+
+	if rr := post("/saml/new", url.Values{"entity_id": {"https://wiki.example.com/saml"}, "acs_url": {"https://x.example.com"}}); !strings.Contains(rr.Body.String(), "already exists") {
+		t.Error("added SP with duplicate entity ID")
+	}
+	if rr := post("/saml/new", url.Values{"entity_id": {"x"}, "acs_url": {"https://x.example.com"}, "attribute_map": {"bogus"}}); !strings.Contains(rr.Body.String(), "want attribute=claim") {
+		t.Error("added SP with invalid attribute map")
+	}
+
+	// The SPs are persisted.
+	srv2 := setupTestServer(t, true)
+	srv2.rootPath = srv.rootPath
+	if err := srv2.loadSAMLServiceProviders(); err != nil {
+		t.Fatal(err)
+	}
+	if got := srv2.samlServiceProviders[added.ID]; !reflect.DeepEqual(got, added) {
+		t.Errorf("loaded SP = %+v; want %+v", got, added)
+	}
+
+	rr = httptest.NewRecorder()
+	srv.handleUI(rr, httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(rr.Body.String(), "Tracker") || !strings.Contains(rr.Body.String(), "/saml/edit/"+added.ID) {
+		t.Error("SP not listed")
+	}
+
+	if rr := post("/saml/edit/"+added.ID, url.Values{"action": {"delete"}}); rr.Code != http.StatusSeeOther {
+		t.Errorf("deleting SP: %d", rr.Code)
+	}
+	if _, ok := srv.samlServiceProviders[added.ID]; ok {
+		t.Error("SP not deleted")
+	}
+}
@@ -0,0 +1,506 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"tailscale.com/atomicfile"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+	"tailscale.com/util/mak"
+)
+
+// tokensFile is where issued access and refresh tokens are persisted, so
+// that restarting tsidp doesn't log everyone out. Only the tokens' hashes
+// are stored (see hashToken).
+const tokensFile = "oidc-tokens.json"
+
+// hashedTokenPrefix is the prefix of token hashes, as returned by hashToken.
+const hashedTokenPrefix = "sha256:"
+
+// hashToken returns the hash that tok is stored under in tokensFile, and
+// in s.accessToken and s.refreshToken once loaded from it.
+func hashToken(tok string) string {
+	sum := sha256.Sum256([]byte(tok))
+	return hashedTokenPrefix + hex.EncodeToString(sum[:])
+}
+
+// tokenKey returns the key of the token tok in m: tok itself if it was
+// issued since tsidp started, or its hash if it was loaded from tokensFile.
+// It reports whether tok is in m.
+func tokenKey[V any](m map[string]V, tok string) (key string, ok bool) {
+	if strings.HasPrefix(tok, hashedTokenPrefix) {
+		// Don't let a token's hash be used as the token.
+		return "", false
+	}
+	if _, ok := m[tok]; ok {
+		return tok, true
+	}
+	key = hashToken(tok)
+	_, ok = m[key]
+	return key, ok
+}
+
+// refreshTokenLifetime is how long a grant's tokens can be refreshed for,
+// from when its first tokens are issued.
+const refreshTokenLifetime = 30 * 24 * time.Hour
+
+// refreshToken is an issued refresh token.
+//
+// Refresh tokens are rotated: each one can be used once, and using it
+// issues a new one. A used refresh token is kept until it expires, so that
+// its reuse (indicating that it leaked) can be detected, which revokes all
+// of the tokens of its grant.
+type refreshToken struct {
+	// ar is the auth request that the token was issued for. Its
+	// validTill is when the refresh token expires, which is the
+	// grant's expiry.
+	ar *authRequest
+
+	// used is whether the token has been exchanged for new tokens.
+	used bool
+}
+
+func (s *idpServer) serveRefreshTokenGrant(w http.ResponseWriter, r *http.Request) {
+	rt := r.FormValue("refresh_token")
+	if rt == "" {
+		http.Error(w, "tsidp: refresh_token is required", http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	key, ok := tokenKey(s.refreshToken, rt)
+	tok := s.refreshToken[key]
+	s.mu.Unlock()
+	if !ok || tok.ar.validTill.Before(time.Now()) {
+		http.Error(w, "tsidp: invalid refresh token", http.StatusBadRequest)
+		return
+	}
+	if !s.authenticateClient(w, r, tok.ar) {
+		return
+	}
+
+	s.mu.Lock()
+	if s.refreshToken[key] != tok {
+		// Revoked while the client was being authenticated.
+		s.mu.Unlock()
+		http.Error(w, "tsidp: invalid refresh token", http.StatusBadRequest)
+		return
+	}
+	if !s.allowInsecureRegistration {
+		if _, ok := s.funnelClients[tok.ar.clientID]; !ok {
+			s.mu.Unlock()
+			http.Error(w, "tsidp: client no longer exists", http.StatusUnauthorized)
+			return
+		}
+	}
+	if tok.used {
+		s.revokeGrantLocked(tok.ar.grantID)
+		s.storeTokensLocked()
+		s.mu.Unlock()
+		log.Printf("tsidp: refresh token reused by client %q; revoked its grant", tok.ar.clientID)
+		http.Error(w, "tsidp: invalid refresh token", http.StatusBadRequest)
+		return
+	}
+	s.mu.Unlock()
+
+	who, err := s.currentWhoIs(r.Context(), tok.ar.remoteUser)
+	if err != nil {
+		log.Printf("tsidp: not refreshing tokens of client %q: %v", tok.ar.clientID, err)
+		http.Error(w, "tsidp: invalid refresh token", http.StatusBadRequest)
+		return
+	}
+	ar := *tok.ar
+	ar.remoteUser = who
+	if ar.grantExpiry.IsZero() {
+		// Stored by an older tsidp, which didn't record it.
+		ar.grantExpiry = tok.ar.validTill
+	}
+	s.issueTokens(w, &ar, key)
+}
+
+// currentWhoIs returns the current identity of the node of who, which
+// tokens were issued for. It returns an error if the node or its user is
+// gone, or if the node's tsidp capability rules have changed since, as
+// tokens issued now may not reflect what the user was granted.
+func (s *idpServer) currentWhoIs(ctx context.Context, who *apitype.WhoIsResponse) (*apitype.WhoIsResponse, error) {
+	n := who.Node
+	if len(n.Addresses) == 0 {
+		return nil, errors.New("node has no addresses")
+	}
+	cur, err := s.lc.WhoIs(ctx, n.Addresses[0].Addr().String())
+	if err != nil {
+		return nil, fmt.Errorf("node %v: %w", n.ID, err)
+	}
+	if cur.Node == nil || cur.Node.ID != n.ID || cur.UserProfile == nil {
+		return nil, fmt.Errorf("node %v is gone", n.ID)
+	}
+	if cur.Node.User != n.User || cur.Node.IsTagged() {
+		return nil, fmt.Errorf("node %v no longer belongs to user %v", n.ID, n.User)
+	}
+	if !slices.Equal(cur.CapMap[tailcfg.PeerCapabilityTsIDP], who.CapMap[tailcfg.PeerCapabilityTsIDP]) {
+		return nil, fmt.Errorf("tsidp capability of node %v changed", n.ID)
+	}
+	return cur, nil
+}
+
+// revokeGrantLocked revokes all access and refresh tokens issued for the
+// grant with the given ID. s.mu must be held.
+func (s *idpServer) revokeGrantLocked(grantID string) {
+	if grantID == "" {
+		return
+	}
+	for k, ar := range s.accessToken {
+		if ar.grantID == grantID {
+			delete(s.accessToken, k)
+		}
+	}
+	for k, rt := range s.refreshToken {
+		if rt.ar.grantID == grantID {
+			delete(s.refreshToken, k)
+		}
+	}
+}
+
+// lookupTokenLocked returns the auth request of the unexpired access or
+// refresh token tok, and whether it's a refresh token. s.mu must be held.
+func (s *idpServer) lookupTokenLocked(tok, typeHint string) (ar *authRequest, isRefresh bool) {
+	now := time.Now()
+	access := func() *authRequest {
+		if k, ok := tokenKey(s.accessToken, tok); ok && s.accessToken[k].validTill.After(now) {
+			return s.accessToken[k]
+		}
+		return nil
+	}
+	refresh := func() *authRequest {
+		if k, ok := tokenKey(s.refreshToken, tok); ok {
+			if rt := s.refreshToken[k]; !rt.used && rt.ar.validTill.After(now) {
+				return rt.ar
+			}
+		}
+		return nil
+	}
+	if typeHint == "refresh_token" {
+		if ar := refresh(); ar != nil {
+			return ar, true
+		}
+		return access(), false
+	}
+	if ar := access(); ar != nil {
+		return ar, false
+	}
+	if ar := refresh(); ar != nil {
+		return ar, true
+	}
+	return nil, false
+}
+
+// authenticateTokenClient authenticates the client making request r to
+// revoke or introspect a token issued for ar, which is nil if the token is
+// unknown. If the client can't be authenticated, it writes an error to w
+// and returns ok false. Otherwise, tokenOK reports whether the token may be
+// acted on; a client that isn't ar's relying party is treated as if the
+// token were unknown.
+func (s *idpServer) authenticateTokenClient(w http.ResponseWriter, r *http.Request, ar *authRequest) (tokenOK, ok bool) {
+	if !s.allowInsecureRegistration {
+		clientID, clientSecret := clientCredentials(r)
+		s.mu.Lock()
+		c, found := s.funnelClients[clientID]
+		s.mu.Unlock()
+		if !found || subtle.ConstantTimeCompare([]byte(clientSecret), []byte(c.Secret)) != 1 {
+			http.Error(w, "tsidp: invalid client credentials", http.StatusUnauthorized)
+			return false, false
+		}
+		return ar != nil && ar.clientID == clientID, true
+	}
+	if ar == nil {
+		return false, true
+	}
+	if err := ar.allowRelyingParty(r, s.lc); err != nil {
+		log.Printf("Error allowing relying party: %v", err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return false, false
+	}
+	return true, true
+}
+
+// serveRevoke implements the token revocation endpoint of RFC 7009.
+func (s *idpServer) serveRevoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "tsidp: method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tok := r.FormValue("token")
+	if tok == "" {
+		http.Error(w, "tsidp: token is required", http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	ar, isRefresh := s.lookupTokenLocked(tok, r.FormValue("token_type_hint"))
+	s.mu.Unlock()
+	tokenOK, ok := s.authenticateTokenClient(w, r, ar)
+	if !ok {
+		return
+	}
+	if !tokenOK {
+		// Per RFC 7009, section 2.2, invalid tokens don't cause an
+		// error response.
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if isRefresh {
+		// Revoking a refresh token also revokes the access tokens
+		// issued for the same grant (RFC 7009, section 2.1).
+		s.revokeGrantLocked(ar.grantID)
+		if k, ok := tokenKey(s.refreshToken, tok); ok {
+			delete(s.refreshToken, k)
+		}
+	} else if k, ok := tokenKey(s.accessToken, tok); ok {
+		delete(s.accessToken, k)
+	}
+	s.storeTokensLocked()
+	w.WriteHeader(http.StatusOK)
+}
+
+// introspectionResponse is the response of the token introspection
+// endpoint, as described in RFC 7662, section 2.2.
+type introspectionResponse struct {
+	Active    bool   `json:"active"`
+	ClientID  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+	Aud       string `json:"aud,omitempty"`
+	Iss       string `json:"iss,omitempty"`
+}
+
+// serveIntrospect implements the token introspection endpoint of RFC 7662.
+func (s *idpServer) serveIntrospect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "tsidp: method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tok := r.FormValue("token")
+	if tok == "" {
+		http.Error(w, "tsidp: token is required", http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	ar, isRefresh := s.lookupTokenLocked(tok, r.FormValue("token_type_hint"))
+	s.mu.Unlock()
+
+	tokenOK, ok := s.authenticateTokenClient(w, r, ar)
+	if !ok {
+		return
+	}
+	var resp introspectionResponse
+	if tokenOK {
+		resp = introspectionResponse{
+			Active:    true,
+			ClientID:  ar.clientID,
+			TokenType: "Bearer",
+			Exp:       ar.validTill.Unix(),
+			Sub:       ar.remoteUser.Node.User.String(),
+			Aud:       ar.clientID,
+			Iss:       s.serverURL,
+		}
+		if isRefresh {
+			resp.TokenType = "refresh_token"
+		}
+		if !ar.issuedAt.IsZero() {
+			resp.Iat = ar.issuedAt.Unix()
+		}
+		if n := ar.remoteUser.Node; n.IsTagged() {
+			// Tokens of tagged nodes come from the client_credentials
+			// grant, and identify the node rather than a user.
+			resp.Sub = string(n.StableID)
+			resp.Username = strings.TrimSuffix(n.Name, ".")
+		} else if ar.remoteUser.UserProfile != nil {
+			resp.Username = ar.remoteUser.UserProfile.LoginName
+		}
+		if ar.localRP {
+			resp.Iss = s.loopbackURL
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// storedAuthRequest is the persisted form of an authRequest.
+type storedAuthRequest struct {
+	LocalRP        bool                   `json:",omitempty"`
+	RPNodeID       tailcfg.NodeID         `json:",omitempty"`
+	FunnelClientID string                 `json:",omitempty"`
+	ClientID       string                 `json:",omitempty"`
+	Nonce          string                 `json:",omitempty"`
+	RedirectURI    string                 `json:",omitempty"`
+	GrantID        string                 `json:",omitempty"`
+	GrantExpiry    time.Time              `json:",omitzero"`
+	RemoteUser     *apitype.WhoIsResponse `json:",omitempty"`
+	IssuedAt       time.Time              `json:",omitzero"`
+	ValidTill      time.Time
+}
+
+type storedRefreshToken struct {
+	storedAuthRequest
+	Used bool `json:",omitempty"`
+}
+
+// tokenState is the format of tokensFile.
+type tokenState struct {
+	AccessTokens  map[string]storedAuthRequest  `json:",omitempty"`
+	RefreshTokens map[string]storedRefreshToken `json:",omitempty"`
+}
+
+func (ar *authRequest) stored() storedAuthRequest {
+	st := storedAuthRequest{
+		LocalRP:     ar.localRP,
+		RPNodeID:    ar.rpNodeID,
+		ClientID:    ar.clientID,
+		Nonce:       ar.nonce,
+		RedirectURI: ar.redirectURI,
+		GrantID:     ar.grantID,
+		GrantExpiry: ar.grantExpiry,
+		RemoteUser:  ar.remoteUser,
+		IssuedAt:    ar.issuedAt,
+		ValidTill:   ar.validTill,
+	}
+	if ar.funnelRP != nil {
+		st.FunnelClientID = ar.funnelRP.ID
+	}
+	return st
+}
+
+// authRequestLocked returns the authRequest for st, or nil if its client no
+// longer exists. s.mu must be held.
+func (s *idpServer) authRequestLocked(st storedAuthRequest) *authRequest {
+	ar := &authRequest{
+		localRP:     st.LocalRP,
+		rpNodeID:    st.RPNodeID,
+		clientID:    st.ClientID,
+		nonce:       st.Nonce,
+		redirectURI: st.RedirectURI,
+		grantID:     st.GrantID,
+		grantExpiry: st.GrantExpiry,
+		remoteUser:  st.RemoteUser,
+		issuedAt:    st.IssuedAt,
+		validTill:   st.ValidTill,
+	}
+	if st.FunnelClientID != "" {
+		c, ok := s.funnelClients[st.FunnelClientID]
+		if !ok {
+			return nil
+		}
+		ar.funnelRP = c
+	}
+	if ar.remoteUser == nil || ar.remoteUser.Node == nil {
+		return nil
+	}
+	return ar
+}
+
+// storeTokensLocked drops expired tokens and persists the hashes of the
+// rest to tokensFile, unless s has no root path. Errors are logged. s.mu
+// must be held.
+func (s *idpServer) storeTokensLocked() {
+	now := time.Now()
+	var st tokenState
+	for k, ar := range s.accessToken {
+		if ar.validTill.Before(now) {
+			delete(s.accessToken, k)
+			continue
+		}
+		mak.Set(&st.AccessTokens, storedTokenKey(k), ar.stored())
+	}
+	for k, rt := range s.refreshToken {
+		if rt.ar.validTill.Before(now) {
+			delete(s.refreshToken, k)
+			continue
+		}
+		mak.Set(&st.RefreshTokens, storedTokenKey(k), storedRefreshToken{rt.ar.stored(), rt.used})
+	}
+	if s.rootPath == "" {
+		return
+	}
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(st); err != nil {
+		log.Printf("tsidp: encoding tokens: %v", err)
+		return
+	}
+	path, err := getConfigFilePath(s.rootPath, tokensFile)
+	if err != nil {
+		log.Printf("tsidp: storing tokens: %v", err)
+		return
+	}
+	if err := atomicfile.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		log.Printf("tsidp: storing tokens: %v", err)
+	}
+}
+
+// storedTokenKey returns the key in tokensFile for the token with key k
+// in s.accessToken or s.refreshToken.
+func storedTokenKey(k string) string {
+	if strings.HasPrefix(k, hashedTokenPrefix) {
+		return k
+	}
+	return hashToken(k)
+}
+
+// loadTokens loads the unexpired tokens persisted by storeTokensLocked.
+// The OAuth clients must already be loaded. If tokensFile can't be parsed,
+// the error is logged and no tokens are loaded, so clients have to log in
+// again.
+func (s *idpServer) loadTokens() error {
+	path, err := getConfigFilePath(s.rootPath, tokensFile)
+	if err != nil {
+		return err
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var st tokenState
+	if err := json.Unmarshal(b, &st); err != nil {
+		log.Printf("tsidp: ignoring tokens in %s: %v", path, err)
+		return nil
+	}
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, sar := range st.AccessTokens {
+		if ar := s.authRequestLocked(sar); ar != nil && ar.validTill.After(now) {
+			mak.Set(&s.accessToken, storedTokenKey(k), ar)
+		}
+	}
+	for k, srt := range st.RefreshTokens {
+		if ar := s.authRequestLocked(srt.storedAuthRequest); ar != nil && ar.validTill.After(now) {
+			mak.Set(&s.refreshToken, storedTokenKey(k), &refreshToken{ar: ar, used: srt.Used})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,339 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+)
+
+// tokenTestServer returns a strict mode test server with an authorization
+// code "test-code" issued to "test-client". Its LocalClient's WhoIs returns
+// the node's current identity, which starts out as it was when the code was
+// issued and can be changed, or set to nil for a node that's gone, through
+// the returned pointer.
+func tokenTestServer(t *testing.T) (*idpServer, *atomic.Pointer[apitype.WhoIsResponse]) {
+	t.Helper()
+	who := &apitype.WhoIsResponse{
+		Node: &tailcfg.Node{
+			ID:        123,
+			Name:      "test-node.test.ts.net.",
+			User:      456,
+			Addresses: []netip.Prefix{netip.MustParsePrefix("100.64.0.1/32")},
+		},
+		UserProfile: &tailcfg.UserProfile{LoginName: "alice@example.com"},
+	}
+	var cur atomic.Pointer[apitype.WhoIsResponse]
+	cur.Store(who)
+	srv := setupTestServerWithClient(t, true, fakeWhoIsClient(t, func(addr string) *apitype.WhoIsResponse {
+		if addr != "100.64.0.1" {
+			return nil
+		}
+		return cur.Load()
+	}))
+	srv.code["test-code"] = &authRequest{
+		clientID:    "test-client",
+		redirectURI: "https://rp.example.com/callback",
+		validTill:   time.Now().Add(5 * time.Minute),
+		funnelRP:    srv.funnelClients["test-client"],
+		remoteUser:  who,
+	}
+	return srv, &cur
+}
+
+// postForm calls handler with a POST of form, authenticated as test-client
+// with the given secret.
+func postForm(handler http.HandlerFunc, secret string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("test-client", secret)
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+	return rr
+}
+
+func exchangeToken(t *testing.T, srv *idpServer, form url.Values) (oidcTokenResponse, int) {
+	t.Helper()
+	rr := postForm(srv.serveToken, "test-secret", form)
+	var resp oidcTokenResponse
+	if rr.Code == http.StatusOK {
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp, rr.Code
+}
+
+func introspect(t *testing.T, srv *idpServer, secret, tok string) introspectionResponse {
+	t.Helper()
+	rr := postForm(srv.serveIntrospect, secret, url.Values{"token": {tok}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("introspect: %d %s", rr.Code, rr.Body.String())
+	}
+	var resp introspectionResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestRefreshToken(t *testing.T) {
+	srv, _ := tokenTestServer(t)
+	first, code := exchangeToken(t, srv, url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {"test-code"},
+		"redirect_uri": {"https://rp.example.com/callback"},
+	})
+	if code != http.StatusOK || first.RefreshToken == "" {
+		t.Fatalf("code exchange: %d, refresh token %q", code, first.RefreshToken)
+	}
+
+	refresh := func(rt string) (oidcTokenResponse, int) {
+		return exchangeToken(t, srv, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {rt}})
+	}
+	second, code := refresh(first.RefreshToken)
+	if code != http.StatusOK {
+		t.Fatalf("refresh: %d", code)
+	}
+	if second.IDToken == "" || second.AccessToken == first.AccessToken || second.RefreshToken == first.RefreshToken {
+		t.Errorf("refresh didn't issue new tokens: %+v", second)
+	}
+	if rr := postForm(srv.serveToken, "wrong-secret", url.Values{"grant_type": {"refresh_token"}, "refresh_token": {second.RefreshToken}}); rr.Code != http.StatusUnauthorized {
+		t.Errorf("refresh with wrong secret: %d; want 401", rr.Code)
+	}
+
+	// Reusing the first refresh token revokes all of the grant's tokens.
+	if _, code := refresh(first.RefreshToken); code != http.StatusBadRequest {
+		t.Errorf("reused refresh token: %d; want 400", code)
+	}
+	for _, tok := range []string{first.AccessToken, second.AccessToken, second.RefreshToken} {
+		if introspect(t, srv, "test-secret", tok).Active {
+			t.Errorf("token %q still active after refresh token reuse", tok)
+		}
+	}
+	if _, code := refresh(second.RefreshToken); code != http.StatusBadRequest {
+		t.Errorf("refresh with revoked token: %d; want 400", code)
+	}
+}
+
+func TestRefreshTokenChecks(t *testing.T) {
+	codeExchange := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {"test-code"},
+		"redirect_uri": {"https://rp.example.com/callback"},
+	}
+	refresh := func(srv *idpServer, rt string) (oidcTokenResponse, int) {
+		return exchangeToken(t, srv, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {rt}})
+	}
+
+	t.Run("grant_expiry", func(t *testing.T) {
+		srv, _ := tokenTestServer(t)
+		first, _ := exchangeToken(t, srv, codeExchange)
+		second, code := refresh(srv, first.RefreshToken)
+		if code != http.StatusOK {
+			t.Fatalf("refresh: %d", code)
+		}
+		srv.mu.Lock()
+		k, _ := tokenKey(srv.refreshToken, first.RefreshToken)
+		firstExpiry := srv.refreshToken[k].ar.validTill
+		k, _ = tokenKey(srv.refreshToken, second.RefreshToken)
+		secondExpiry := srv.refreshToken[k].ar.validTill
+		srv.mu.Unlock()
+		if !secondExpiry.Equal(firstExpiry) {
+			t.Errorf("refreshed token expires at %v; want the grant's expiry %v", secondExpiry, firstExpiry)
+		}
+	})
+
+	t.Run("node_gone", func(t *testing.T) {
+		srv, who := tokenTestServer(t)
+		first, _ := exchangeToken(t, srv, codeExchange)
+		who.Store(nil)
+		if _, code := refresh(srv, first.RefreshToken); code != http.StatusBadRequest {
+			t.Errorf("refresh for deleted node: %d; want 400", code)
+		}
+	})
+
+	t.Run("user_changed", func(t *testing.T) {
+		srv, who := tokenTestServer(t)
+		first, _ := exchangeToken(t, srv, codeExchange)
+		cur := *who.Load()
+		n := *cur.Node
+		n.User = 789
+		cur.Node = &n
+		who.Store(&cur)
+		if _, code := refresh(srv, first.RefreshToken); code != http.StatusBadRequest {
+			t.Errorf("refresh for node of another user: %d; want 400", code)
+		}
+	})
+
+	t.Run("capability_changed", func(t *testing.T) {
+		srv, who := tokenTestServer(t)
+		first, _ := exchangeToken(t, srv, codeExchange)
+		cur := *who.Load()
+		cur.CapMap = tailcfg.PeerCapMap{tailcfg.PeerCapabilityTsIDP: {`{"extraClaims":{"role":"admin"}}`}}
+		who.Store(&cur)
+		if _, code := refresh(srv, first.RefreshToken); code != http.StatusBadRequest {
+			t.Errorf("refresh after capability change: %d; want 400", code)
+		}
+	})
+
+	t.Run("issue_failure", func(t *testing.T) {
+		srv, _ := tokenTestServer(t)
+		first, _ := exchangeToken(t, srv, codeExchange)
+		t.Run("no_signer", func(t *testing.T) {
+			srv.lazySigner.SetForTest(t, nil, errors.New("no signer"))
+			if _, code := refresh(srv, first.RefreshToken); code != http.StatusInternalServerError {
+				t.Fatalf("refresh without signer: %d; want 500", code)
+			}
+		})
+		// The refresh token wasn't used up by the failed refresh.
+		if _, code := refresh(srv, first.RefreshToken); code != http.StatusOK {
+			t.Errorf("refresh after failed refresh: %d; want 200", code)
+		}
+	})
+}
+
+func TestRevokeAndIntrospect(t *testing.T) {
+	srv, _ := tokenTestServer(t)
+	tokens, code := exchangeToken(t, srv, url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {"test-code"},
+		"redirect_uri": {"https://rp.example.com/callback"},
+	})
+	if code != http.StatusOK {
+		t.Fatalf("code exchange: %d", code)
+	}
+
+	got := introspect(t, srv, "test-secret", tokens.AccessToken)
+	if !got.Active || got.ClientID != "test-client" || got.Username != "alice@example.com" || got.TokenType != "Bearer" || got.Iss != srv.serverURL {
+		t.Errorf("introspecting access token = %+v", got)
+	}
+	if got := introspect(t, srv, "test-secret", tokens.RefreshToken); !got.Active || got.TokenType != "refresh_token" {
+		t.Errorf("introspecting refresh token = %+v", got)
+	}
+	if got := introspect(t, srv, "test-secret", "unknown"); got.Active {
+		t.Errorf("introspecting unknown token = %+v", got)
+	}
+	if rr := postForm(srv.serveIntrospect, "wrong-secret", url.Values{"token": {tokens.AccessToken}}); rr.Code != http.StatusUnauthorized {
+		t.Errorf("introspecting with wrong secret: %d; want 401", rr.Code)
+	}
+
+	// Another client can't see or revoke the tokens.
+	srv.funnelClients["other-client"] = &funnelClient{ID: "other-client", Secret: "other-secret"}
+	other := func(handler http.HandlerFunc) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(url.Values{"token": {tokens.AccessToken}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("other-client", "other-secret")
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+	if rr := other(srv.serveIntrospect); strings.Contains(rr.Body.String(), `"active":true`) {
+		t.Errorf("other client introspected token: %s", rr.Body.String())
+	}
+	if rr := other(srv.serveRevoke); rr.Code != http.StatusOK {
+		t.Errorf("other client revoking token: %d; want 200", rr.Code)
+	}
+	if !introspect(t, srv, "test-secret", tokens.AccessToken).Active {
+		t.Error("other client revoked token")
+	}
+
+	// Revoking the refresh token revokes the access token too.
+	if rr := postForm(srv.serveRevoke, "test-secret", url.Values{"token": {tokens.RefreshToken}}); rr.Code != http.StatusOK {
+		t.Fatalf("revoke: %d %s", rr.Code, rr.Body.String())
+	}
+	for _, tok := range []string{tokens.AccessToken, tokens.RefreshToken} {
+		if introspect(t, srv, "test-secret", tok).Active {
+			t.Errorf("token %q active after revocation", tok)
+		}
+	}
+	if rr := postForm(srv.serveRevoke, "test-secret", url.Values{"token": {"unknown"}}); rr.Code != http.StatusOK {
+		t.Errorf("revoking unknown token: %d; want 200", rr.Code)
+	}
+}
+
+func TestTokensPersistence(t *testing.T) {
+	srv, _ := tokenTestServer(t)
+	tokens, code := exchangeToken(t, srv, url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {"test-code"},
+		"redirect_uri": {"https://rp.example.com/callback"},
+	})
+	if code != http.StatusOK {
+		t.Fatalf("code exchange: %d", code)
+	}
+
+	// Only the tokens' hashes are stored.
+	path, err := getConfigFilePath(srv.rootPath, tokensFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tok := range []string{tokens.AccessToken, tokens.RefreshToken} {
+		if strings.Contains(string(b), tok) {
+			t.Errorf("token %q stored in %s", tok, tokensFile)
+		}
+		if !strings.Contains(string(b), hashToken(tok)) {
+			t.Errorf("hash of token %q not stored in %s", tok, tokensFile)
+		}
+	}
+
+	srv2 := setupTestServerWithClient(t, true, srv.lc)
+	srv2.rootPath = srv.rootPath
+	if err := srv2.loadTokens(); err != nil {
+		t.Fatal(err)
+	}
+	if got := introspect(t, srv2, "test-secret", tokens.AccessToken); !got.Active || got.Username != "alice@example.com" {
+		t.Errorf("access token after reload = %+v", got)
+	}
+	if introspect(t, srv2, "test-secret", hashToken(tokens.AccessToken)).Active {
+		t.Error("hash of access token accepted as a token")
+	}
+	if _, code := exchangeToken(t, srv2, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {tokens.RefreshToken}}); code != http.StatusOK {
+		t.Errorf("refresh after reload: %d", code)
+	}
+
+	// Tokens of clients that no longer exist are dropped.
+	srv3 := setupTestServer(t, true)
+	srv3.rootPath = srv.rootPath
+	delete(srv3.funnelClients, "test-client")
+	if err := srv3.loadTokens(); err != nil {
+		t.Fatal(err)
+	}
+	if len(srv3.accessToken) != 0 || len(srv3.refreshToken) != 0 {
+		t.Errorf("loaded tokens of deleted client: %d access, %d refresh", len(srv3.accessToken), len(srv3.refreshToken))
+	}
+}
+
+func TestLoadCorruptTokens(t *testing.T) {
+	srv := setupTestServer(t, true)
+	path, err := getConfigFilePath(srv.rootPath, tokensFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(`{"AccessTokens":{"sha256:00":`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.loadTokens(); err != nil {
+		t.Fatalf("loadTokens with a truncated file: %v", err)
+	}
+	if len(srv.accessToken) != 0 || len(srv.refreshToken) != 0 {
+		t.Errorf("loaded %d access and %d refresh tokens from a truncated file", len(srv.accessToken), len(srv.refreshToken))
+	}
+}
@@ -230,6 +230,12 @@ func main() {
 	} else if !errors.Is(err, os.ErrNotExist) {
 		log.Fatalf("could not open %s: %v", clientsFilePath, err)
 	}
+	if err := srv.loadTokens(); err != nil {
+		log.Fatalf("could not load tokens: %v", err)
+	}
+	if err := srv.loadSAMLServiceProviders(); err != nil {
+		log.Fatalf("could not load SAML service providers: %v", err)
+	}
 
 	log.Printf("Running tsidp at %s ...", srv.serverURL)
 
@@ -346,11 +352,17 @@ type idpServer struct {
 	lazyMux        lazy.SyncValue[*http.ServeMux]
 	lazySigningKey lazy.SyncValue[*signingKey]
 	lazySigner     lazy.SyncValue[jose.Signer]
+	lazySAMLCert   lazy.SyncValue[[]byte] // DER encoded certificate for the signing key
 
 	mu            sync.Mutex               // guards the fields below
 	code          map[string]*authRequest  // keyed by random hex
-	accessToken   map[string]*authRequest  // keyed by random hex
+	accessToken   map[string]*authRequest  // keyed by random hex, or its hashToken if loaded from disk
+	refreshToken  map[string]*refreshToken // keyed by random hex, or its hashToken if loaded from disk
+	deviceCode    map[string]*deviceAuth   // keyed by random hex
+	userCode      map[string]*deviceAuth   // keyed by normalized user code
 	funnelClients map[string]*funnelClient // keyed by client ID
+
+	samlServiceProviders map[string]*samlServiceProvider // keyed by ID
 }
 
 type authRequest struct {
@@ -378,6 +390,22 @@ type authRequest struct {
 	// redirectURI is the redirect_uri presented in the request.
 	redirectURI string
 
+	// codeChallenge is the PKCE S256 "code_challenge" presented in the
+	// request, if any.
+	codeChallenge string
+
+	// grantID identifies the authorization grant that tokens were issued
+	// for, so that all of the tokens derived from a grant (by refreshing)
+	// can be revoked together.
+	grantID string
+
+	// issuedAt is when the token was issued.
+	issuedAt time.Time
+
+	// grantExpiry is when the grant's tokens can no longer be refreshed,
+	// set when its first tokens are issued. Refreshing doesn't extend it.
+	grantExpiry time.Time
+
 	// remoteUser is the user who is being authenticated.
 	remoteUser *apitype.WhoIsResponse
 
@@ -473,18 +501,19 @@ func (s *idpServer) authorize(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// Get user information
-		var remoteAddr string
-		if s.localTSMode {
-			remoteAddr = r.Header.Get("X-Forwarded-For")
-		} else {
-			remoteAddr = r.RemoteAddr
+		codeChallenge, err := parseCodeChallenge(uq)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if codeChallenge == "" && c.isPublic() {
+			http.Error(w, "tsidp: public clients must use PKCE", http.StatusBadRequest)
+			return
 		}
 
 		// Check who is visiting the authorize endpoint.
 		var who *apitype.WhoIsResponse
-		var err error
-		who, err = s.lc.WhoIs(r.Context(), remoteAddr)
+		who, err = s.lc.WhoIs(r.Context(), s.visitorAddr(r))
 		if err != nil {
 			log.Printf("Error getting WhoIs: %v", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -493,11 +522,12 @@ func (s *idpServer) authorize(w http.ResponseWriter, r *http.Request) {
 
 		code := rands.HexString(32)
 		ar := &authRequest{
-			nonce:       uq.Get("nonce"),
-			remoteUser:  who,
-			redirectURI: redirectURI,
-			clientID:    clientID,
-			funnelRP:    c, // Store the validated client
+			nonce:         uq.Get("nonce"),
+			remoteUser:    who,
+			redirectURI:   redirectURI,
+			codeChallenge: codeChallenge,
+			clientID:      clientID,
+			funnelRP:      c, // Store the validated client
 		}
 
 		s.mu.Lock()
@@ -522,16 +552,12 @@ func (s *idpServer) authorize(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var remoteAddr string
-	if s.localTSMode {
-		// in local tailscaled mode, the local tailscaled is forwarding us
-		// HTTP requests, so reading r.RemoteAddr will just get us our own
-		// address.
-		remoteAddr = r.Header.Get("X-Forwarded-For")
-	} else {
-		remoteAddr = r.RemoteAddr
+	codeChallenge, err := parseCodeChallenge(uq)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	who, err := s.lc.WhoIs(r.Context(), remoteAddr)
+	who, err := s.lc.WhoIs(r.Context(), s.visitorAddr(r))
 	if err != nil {
 		log.Printf("Error getting WhoIs: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -540,10 +566,11 @@ func (s *idpServer) authorize(w http.ResponseWriter, r *http.Request) {
 
 	code := rands.HexString(32)
 	ar := &authRequest{
-		nonce:       uq.Get("nonce"),
-		remoteUser:  who,
-		redirectURI: redirectURI,
-		clientID:    clientID,
+		nonce:         uq.Get("nonce"),
+		remoteUser:    who,
+		redirectURI:   redirectURI,
+		codeChallenge: codeChallenge,
+		clientID:      clientID,
 	}
 
 	if r.URL.Path == "/authorize/funnel" {
@@ -558,6 +585,10 @@ func (s *idpServer) authorize(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "tsidp: redirect_uri mismatch", http.StatusBadRequest)
 			return
 		}
+		if c.isPublic() && codeChallenge == "" {
+			http.Error(w, "tsidp: public clients must use PKCE", http.StatusBadRequest)
+			return
+		}
 		ar.funnelRP = c
 	} else if r.URL.Path == "/authorize/localhost" {
 		ar.localRP = true
@@ -591,6 +622,17 @@ func (s *idpServer) authorize(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, u, http.StatusFound)
 }
 
+// visitorAddr returns the address of the client that made r.
+func (s *idpServer) visitorAddr(r *http.Request) string {
+	if s.localTSMode {
+		// in local tailscaled mode, the local tailscaled is forwarding us
+		// HTTP requests, so reading r.RemoteAddr will just get us our own
+		// address.
+		return r.Header.Get("X-Forwarded-For")
+	}
+	return r.RemoteAddr
+}
+
 func (s *idpServer) newMux() *http.ServeMux {
 	mux := http.NewServeMux()
 	mux.HandleFunc(oidcJWKSPath, s.serveJWKS)
@@ -604,6 +646,12 @@ func (s *idpServer) newMux() *http.ServeMux {
 	}
 	mux.HandleFunc("/userinfo", s.serveUserInfo)
 	mux.HandleFunc("/token", s.serveToken)
+	mux.HandleFunc("/revoke", s.serveRevoke)
+	mux.HandleFunc("/introspect", s.serveIntrospect)
+	mux.HandleFunc("/device_authorization", s.serveDeviceAuthorization)
+	mux.HandleFunc("/device", s.serveDeviceVerification)
+	mux.HandleFunc(samlMetadataPath, s.serveSAMLMetadata)
+	mux.HandleFunc(samlSSOPath, s.serveSAMLSSO)
 	mux.HandleFunc("/clients/", s.serveClients)
 	mux.HandleFunc("/", s.handleUI)
 	return mux
@@ -626,7 +674,8 @@ func (s *idpServer) serveUserInfo(w http.ResponseWriter, r *http.Request) {
 	}
 
 	s.mu.Lock()
-	ar, ok := s.accessToken[tk]
+	key, ok := tokenKey(s.accessToken, tk)
+	ar := s.accessToken[key]
 	s.mu.Unlock()
 	if !ok {
 		http.Error(w, "tsidp: invalid token", http.StatusBadRequest)
@@ -636,7 +685,7 @@ func (s *idpServer) serveUserInfo(w http.ResponseWriter, r *http.Request) {
 	if ar.validTill.Before(time.Now()) {
 		http.Error(w, "tsidp: token expired", http.StatusBadRequest)
 		s.mu.Lock()
-		delete(s.accessToken, tk)
+		delete(s.accessToken, key)
 		s.mu.Unlock()
 		return
 	}
@@ -846,10 +895,21 @@ func (s *idpServer) serveToken(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "tsidp: method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	if r.FormValue("grant_type") != "authorization_code" {
+	switch r.FormValue("grant_type") {
+	case "authorization_code":
+		s.serveAuthorizationCodeGrant(w, r)
+	case "refresh_token":
+		s.serveRefreshTokenGrant(w, r)
+	case "client_credentials":
+		s.serveClientCredentialsGrant(w, r)
+	case deviceCodeGrantType:
+		s.serveDeviceCodeGrant(w, r)
+	default:
 		http.Error(w, "tsidp: grant_type not supported", http.StatusBadRequest)
-		return
 	}
+}
+
+func (s *idpServer) serveAuthorizationCodeGrant(w http.ResponseWriter, r *http.Request) {
 	code := r.FormValue("code")
 	if code == "" {
 		http.Error(w, "tsidp: code is required", http.StatusBadRequest)
@@ -866,61 +926,97 @@ func (s *idpServer) serveToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !s.authenticateClient(w, r, ar) {
+		return
+	}
+
+	if ar.redirectURI != r.FormValue("redirect_uri") {
+		http.Error(w, "tsidp: redirect_uri mismatch", http.StatusBadRequest)
+		return
+	}
+	if !verifyCodeVerifier(ar.codeChallenge, r.FormValue("code_verifier")) {
+		http.Error(w, "tsidp: invalid code_verifier", http.StatusBadRequest)
+		return
+	}
+	ar.grantID = rands.HexString(16)
+	s.issueTokens(w, ar, "")
+}
+
+// authenticateClient reports whether the client making the request r is the
+// relying party that ar was issued to. If not, it writes an error to w.
+func (s *idpServer) authenticateClient(w http.ResponseWriter, r *http.Request, ar *authRequest) bool {
 	if !s.allowInsecureRegistration {
 		// When insecure registration is NOT allowed, always validate client credentials regardless of request source
-		clientID := r.FormValue("client_id")
-		clientSecret := r.FormValue("client_secret")
-
-		// Try basic auth if form values are empty
-		if clientID == "" || clientSecret == "" {
-			if basicClientID, basicClientSecret, ok := r.BasicAuth(); ok {
-				if clientID == "" {
-					clientID = basicClientID
-				}
-				if clientSecret == "" {
-					clientSecret = basicClientSecret
-				}
-			}
+		clientID, clientSecret := clientCredentials(r)
+		if ar.funnelRP.isPublic() && clientID != "" && clientID == ar.clientID {
+			// Public clients have no secret; their grants are bound
+			// to them by PKCE or the device flow instead.
+			return true
 		}
-
 		if clientID == "" || clientSecret == "" {
 			http.Error(w, "tsidp: client credentials required in when insecure registration is not allowed", http.StatusUnauthorized)
-			return
+			return false
 		}
 
 		// Validate against the stored auth request
 		if ar.clientID != clientID {
 			http.Error(w, "tsidp: client_id mismatch", http.StatusBadRequest)
-			return
+			return false
 		}
 
 		// Validate client credentials against stored clients
 		if ar.funnelRP == nil {
 			http.Error(w, "tsidp: no client information found", http.StatusBadRequest)
-			return
+			return false
 		}
 
 		clientIDcmp := subtle.ConstantTimeCompare([]byte(clientID), []byte(ar.funnelRP.ID))
 		clientSecretcmp := subtle.ConstantTimeCompare([]byte(clientSecret), []byte(ar.funnelRP.Secret))
 		if clientIDcmp != 1 || clientSecretcmp != 1 {
 			http.Error(w, "tsidp: invalid client credentials", http.StatusUnauthorized)
-			return
-		}
-	} else {
-		// Original behavior when insecure registration is allowed
-		// Only checks ClientID and Client Secret when over funnel.
-		// Local connections are allowed and tailnet connections only check matching nodeIDs.
-		if err := ar.allowRelyingParty(r, s.lc); err != nil {
-			log.Printf("Error allowing relying party: %v", err)
-			http.Error(w, err.Error(), http.StatusForbidden)
-			return
+			return false
 		}
+		return true
+	}
+	// Original behavior when insecure registration is allowed
+	// Only checks ClientID and Client Secret when over funnel.
+	// Local connections are allowed and tailnet connections only check matching nodeIDs.
+	if err := ar.allowRelyingParty(r, s.lc); err != nil {
+		log.Printf("Error allowing relying party: %v", err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return false
 	}
+	return true
+}
 
-	if ar.redirectURI != r.FormValue("redirect_uri") {
-		http.Error(w, "tsidp: redirect_uri mismatch", http.StatusBadRequest)
-		return
+// clientCredentials returns the client ID and secret from the form values
+// of r, or else from its basic auth credentials.
+func clientCredentials(r *http.Request) (clientID, clientSecret string) {
+	clientID = r.FormValue("client_id")
+	clientSecret = r.FormValue("client_secret")
+
+	// Try basic auth if form values are empty
+	if clientID == "" || clientSecret == "" {
+		if basicClientID, basicClientSecret, ok := r.BasicAuth(); ok {
+			if clientID == "" {
+				clientID = basicClientID
+			}
+			if clientSecret == "" {
+				clientSecret = basicClientSecret
+			}
+		}
 	}
+	return clientID, clientSecret
+}
+
+// issueTokens issues an ID token, an access token and a refresh token for
+// the user and relying party of ar, and writes them to w.
+//
+// If refreshKey is non-empty, the tokens are issued in exchange for the
+// refresh token stored under that key in s.refreshToken, which is marked
+// used only once the new tokens are stored. If it has been used or revoked
+// in the meantime, no tokens are issued.
+func (s *idpServer) issueTokens(w http.ResponseWriter, ar *authRequest, refreshKey string) {
 	signer, err := s.oidcSigner()
 	if err != nil {
 		log.Printf("Error getting signer: %v", err)
@@ -930,8 +1026,6 @@ func (s *idpServer) serveToken(w http.ResponseWriter, r *http.Request) {
 	jti := rands.HexString(32)
 	who := ar.remoteUser
 
-	// TODO(maisem): not sure if this is the right thing to do
-	userName, _, _ := strings.Cut(ar.remoteUser.UserProfile.LoginName, "@")
 	n := who.Node.View()
 	if n.IsTagged() {
 		http.Error(w, "tsidp: tagged nodes not supported", http.StatusBadRequest)
@@ -939,27 +1033,17 @@ func (s *idpServer) serveToken(w http.ResponseWriter, r *http.Request) {
 	}
 
 	now := time.Now()
-	_, tcd, _ := strings.Cut(n.Name(), ".")
-	tsClaims := tailscaleClaims{
-		Claims: jwt.Claims{
-			Audience:  jwt.Audience{ar.clientID},
-			Expiry:    jwt.NewNumericDate(now.Add(5 * time.Minute)),
-			ID:        jti,
-			IssuedAt:  jwt.NewNumericDate(now),
-			Issuer:    s.serverURL,
-			NotBefore: jwt.NewNumericDate(now),
-			Subject:   n.User().String(),
-		},
-		Nonce:     ar.nonce,
-		Key:       n.Key(),
-		Addresses: n.Addresses(),
-		NodeID:    n.ID(),
-		NodeName:  n.Name(),
-		Tailnet:   tcd,
-		UserID:    n.User(),
-		Email:     who.UserProfile.LoginName,
-		UserName:  userName,
-	}
+	tsClaims := userClaims(who)
+	tsClaims.Claims = jwt.Claims{
+		Audience:  jwt.Audience{ar.clientID},
+		Expiry:    jwt.NewNumericDate(now.Add(5 * time.Minute)),
+		ID:        jti,
+		IssuedAt:  jwt.NewNumericDate(now),
+		Issuer:    s.serverURL,
+		NotBefore: jwt.NewNumericDate(now),
+		Subject:   n.User().String(),
+	}
+	tsClaims.Nonce = ar.nonce
 	if ar.localRP {
 		tsClaims.Issuer = s.loopbackURL
 	}
@@ -987,24 +1071,67 @@ func (s *idpServer) serveToken(w http.ResponseWriter, r *http.Request) {
 	}
 
 	at := rands.HexString(32)
+	rt := rands.HexString(32)
 	s.mu.Lock()
+	if refreshKey != "" {
+		prev := s.refreshToken[refreshKey]
+		if prev == nil || prev.used {
+			if prev != nil {
+				// Exchanged concurrently: treat it like any other reuse.
+				s.revokeGrantLocked(prev.ar.grantID)
+				s.storeTokensLocked()
+			}
+			s.mu.Unlock()
+			http.Error(w, "tsidp: invalid refresh token", http.StatusBadRequest)
+			return
+		}
+		prev.used = true
+	}
+	if ar.grantExpiry.IsZero() {
+		ar.grantExpiry = now.Add(refreshTokenLifetime)
+	}
+	ar.issuedAt = now
 	ar.validTill = now.Add(5 * time.Minute)
 	mak.Set(&s.accessToken, at, ar)
+	rar := *ar
+	rar.validTill = ar.grantExpiry
+	mak.Set(&s.refreshToken, rt, &refreshToken{ar: &rar})
+	s.storeTokensLocked()
 	s.mu.Unlock()
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(oidcTokenResponse{
-		AccessToken: at,
-		TokenType:   "Bearer",
-		ExpiresIn:   5 * 60,
-		IDToken:     token,
+		AccessToken:  at,
+		TokenType:    "Bearer",
+		ExpiresIn:    5 * 60,
+		IDToken:      token,
+		RefreshToken: rt,
 	}); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
 
+// userClaims returns the claims about the user and node of who, without
+// any of the registered JWT claims.
+func userClaims(who *apitype.WhoIsResponse) tailscaleClaims {
+	n := who.Node.View()
+	_, tcd, _ := strings.Cut(n.Name(), ".")
+	// TODO(maisem): not sure if this is the right thing to do
+	userName, _, _ := strings.Cut(who.UserProfile.LoginName, "@")
+	return tailscaleClaims{
+		Key:       n.Key(),
+		Addresses: n.Addresses(),
+		NodeID:    n.ID(),
+		NodeName:  n.Name(),
+		Tailnet:   tcd,
+		UserID:    n.User(),
+		Email:     who.UserProfile.LoginName,
+		UserName:  userName,
+	}
+}
+
 type oidcTokenResponse struct {
-	IDToken      string `json:"id_token"`
+	IDToken      string `json:"id_token,omitempty"`
 	TokenType    string `json:"token_type"`
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token,omitempty"`
@@ -1093,16 +1220,22 @@ func (s *idpServer) serveJWKS(w http.ResponseWriter, r *http.Request) {
 // openIDProviderMetadata is a partial representation of
 // https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata.
 type openIDProviderMetadata struct {
-	Issuer                           string              `json:"issuer"`
-	AuthorizationEndpoint            string              `json:"authorization_endpoint,omitempty"`
-	TokenEndpoint                    string              `json:"token_endpoint,omitempty"`
-	UserInfoEndpoint                 string              `json:"userinfo_endpoint,omitempty"`
-	JWKS_URI                         string              `json:"jwks_uri"`
-	ScopesSupported                  views.Slice[string] `json:"scopes_supported"`
-	ResponseTypesSupported           views.Slice[string] `json:"response_types_supported"`
-	SubjectTypesSupported            views.Slice[string] `json:"subject_types_supported"`
-	ClaimsSupported                  views.Slice[string] `json:"claims_supported"`
-	IDTokenSigningAlgValuesSupported views.Slice[string] `json:"id_token_signing_alg_values_supported"`
+	Issuer                            string              `json:"issuer"`
+	AuthorizationEndpoint             string              `json:"authorization_endpoint,omitempty"`
+	TokenEndpoint                     string              `json:"token_endpoint,omitempty"`
+	UserInfoEndpoint                  string              `json:"userinfo_endpoint,omitempty"`
+	RevocationEndpoint                string              `json:"revocation_endpoint,omitempty"`
+	IntrospectionEndpoint             string              `json:"introspection_endpoint,omitempty"`
+	DeviceAuthorizationEndpoint       string              `json:"device_authorization_endpoint,omitempty"`
+	JWKS_URI                          string              `json:"jwks_uri"`
+	ScopesSupported                   views.Slice[string] `json:"scopes_supported"`
+	ResponseTypesSupported            views.Slice[string] `json:"response_types_supported"`
+	GrantTypesSupported               views.Slice[string] `json:"grant_types_supported,omitempty"`
+	CodeChallengeMethodsSupported     views.Slice[string] `json:"code_challenge_methods_supported,omitempty"`
+	TokenEndpointAuthMethodsSupported views.Slice[string] `json:"token_endpoint_auth_methods_supported,omitempty"`
+	SubjectTypesSupported             views.Slice[string] `json:"subject_types_supported"`
+	ClaimsSupported                   views.Slice[string] `json:"claims_supported"`
+	IDTokenSigningAlgValuesSupported  views.Slice[string] `json:"id_token_signing_alg_values_supported"`
 	// TODO(maisem): maybe add other fields?
 	// Currently we fill out the REQUIRED fields, scopes_supported and claims_supported.
 }
@@ -1140,6 +1273,7 @@ var (
 
 	// We only support getting the id_token.
 	openIDSupportedReponseTypes = views.SliceOf([]string{"id_token", "code"})
+	openIDSupportedGrantTypes   = views.SliceOf([]string{"authorization_code", "refresh_token", "client_credentials", deviceCodeGrantType})
 
 	// The type of the "sub" field in the JWT, which means it is globally unique identifier.
 	// The other option is "pairwise", which means the identifier is different per receiving 3p.
@@ -1148,6 +1282,13 @@ var (
 	// The algo used for signing. The OpenID spec says "The algorithm RS256 MUST be included."
 	// https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata
 	openIDSupportedSigningAlgos = views.SliceOf([]string{string(jose.RS256)})
+
+	// Only S256 is supported for PKCE; "plain" offers no protection against
+	// an intercepted authorization request.
+	openIDSupportedCodeChallengeMethods = views.SliceOf([]string{"S256"})
+
+	// "none" is used by public clients, which have no secret.
+	openIDSupportedTokenEndpointAuthMethods = views.SliceOf([]string{"client_secret_basic", "client_secret_post", "none"})
 )
 
 func (s *idpServer) serveOpenIDConfig(w http.ResponseWriter, r *http.Request) {
@@ -1205,16 +1346,22 @@ func (s *idpServer) serveOpenIDConfig(w http.ResponseWriter, r *http.Request) {
 	je := json.NewEncoder(w)
 	je.SetIndent("", "  ")
 	if err := je.Encode(openIDProviderMetadata{
-		AuthorizationEndpoint:            authorizeEndpoint,
-		Issuer:                           rpEndpoint,
-		JWKS_URI:                         rpEndpoint + oidcJWKSPath,
-		UserInfoEndpoint:                 rpEndpoint + "/userinfo",
-		TokenEndpoint:                    rpEndpoint + "/token",
-		ScopesSupported:                  openIDSupportedScopes,
-		ResponseTypesSupported:           openIDSupportedReponseTypes,
-		SubjectTypesSupported:            openIDSupportedSubjectTypes,
-		ClaimsSupported:                  openIDSupportedClaims,
-		IDTokenSigningAlgValuesSupported: openIDSupportedSigningAlgos,
+		AuthorizationEndpoint:             authorizeEndpoint,
+		Issuer:                            rpEndpoint,
+		JWKS_URI:                          rpEndpoint + oidcJWKSPath,
+		UserInfoEndpoint:                  rpEndpoint + "/userinfo",
+		TokenEndpoint:                     rpEndpoint + "/token",
+		RevocationEndpoint:                rpEndpoint + "/revoke",
+		IntrospectionEndpoint:             rpEndpoint + "/introspect",
+		DeviceAuthorizationEndpoint:       rpEndpoint + "/device_authorization",
+		ScopesSupported:                   openIDSupportedScopes,
+		ResponseTypesSupported:            openIDSupportedReponseTypes,
+		GrantTypesSupported:               openIDSupportedGrantTypes,
+		CodeChallengeMethodsSupported:     openIDSupportedCodeChallengeMethods,
+		TokenEndpointAuthMethodsSupported: openIDSupportedTokenEndpointAuthMethods,
+		SubjectTypesSupported:             openIDSupportedSubjectTypes,
+		ClaimsSupported:                   openIDSupportedClaims,
+		IDTokenSigningAlgValuesSupported:  openIDSupportedSigningAlgos,
 	}); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
@@ -1229,6 +1376,12 @@ type funnelClient struct {
 	RedirectURI string `json:"redirect_uri"`
 }
 
+// isPublic reports whether c is a public client, such as a CLI or a single
+// page app, that can't keep a secret. Public clients must use PKCE.
+func (c *funnelClient) isPublic() bool {
+	return c != nil && c.Secret == ""
+}
+
 // /clients is a privileged endpoint that allows the visitor to create new
 // Funnel-capable OIDC clients, so it is only accessible over the tailnet.
 func (s *idpServer) serveClients(w http.ResponseWriter, r *http.Request) {
@@ -1283,7 +1436,10 @@ func (s *idpServer) serveNewClient(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	clientID := rands.HexString(32)
-	clientSecret := rands.HexString(64)
+	var clientSecret string
+	if r.FormValue("public") != "true" {
+		clientSecret = rands.HexString(64)
+	}
 	newClient := funnelClient{
 		ID:          clientID,
 		Secret:      clientSecret,
@@ -14,6 +14,7 @@ import (
 	"strings"
 	"time"
 
+	"tailscale.com/util/mak"
 	"tailscale.com/util/rands"
 )
 
@@ -26,12 +27,16 @@ var listHTML string
 //go:embed ui-edit.html
 var editHTML string
 
+//go:embed ui-saml-edit.html
+var samlEditHTML string
+
 //go:embed ui-style.css
 var styleCSS string
 
 var headerTmpl = template.Must(template.New("header").Parse(headerHTML))
 var listTmpl = template.Must(headerTmpl.New("list").Parse(listHTML))
 var editTmpl = template.Must(headerTmpl.New("edit").Parse(editHTML))
+var samlEditTmpl = template.Must(headerTmpl.New("saml-edit").Parse(samlEditHTML))
 
 var processStart = time.Now()
 
@@ -48,6 +53,9 @@ func (s *idpServer) handleUI(w http.ResponseWriter, r *http.Request) {
 	case "/new":
 		s.handleNewClient(w, r)
 		return
+	case "/saml/new":
+		s.handleNewSAMLServiceProvider(w, r)
+		return
 	case "/style.css":
 		http.ServeContent(w, r, "ui-style.css", processStart, strings.NewReader(styleCSS))
 		return
@@ -57,6 +65,10 @@ func (s *idpServer) handleUI(w http.ResponseWriter, r *http.Request) {
 		s.handleEditClient(w, r)
 		return
 	}
+	if strings.HasPrefix(r.URL.Path, "/saml/edit/") {
+		s.handleEditSAMLServiceProvider(w, r)
+		return
+	}
 
 	http.Error(w, "tsidp: not found", http.StatusNotFound)
 }
@@ -72,6 +84,15 @@ func (s *idpServer) handleClientsList(w http.ResponseWriter, r *http.Request) {
 			HasSecret:   c.Secret != "",
 		})
 	}
+	sps := make([]samlDisplayData, 0, len(s.samlServiceProviders))
+	for _, sp := range s.samlServiceProviders {
+		sps = append(sps, samlDisplayData{
+			ID:       sp.ID,
+			Name:     sp.Name,
+			EntityID: sp.EntityID,
+			ACSURL:   sp.ACSURL,
+		})
+	}
 	s.mu.Unlock()
 
 	sort.Slice(clients, func(i, j int) bool {
@@ -80,9 +101,19 @@ func (s *idpServer) handleClientsList(w http.ResponseWriter, r *http.Request) {
 		}
 		return clients[i].ID < clients[j].ID
 	})
+	sort.Slice(sps, func(i, j int) bool {
+		if sps[i].Name != sps[j].Name {
+			return sps[i].Name < sps[j].Name
+		}
+		return sps[i].EntityID < sps[j].EntityID
+	})
 
 	var buf bytes.Buffer
-	if err := listTmpl.Execute(&buf, clients); err != nil {
+	if err := listTmpl.Execute(&buf, listDisplayData{
+		Clients:              clients,
+		SAMLServiceProviders: sps,
+		SAMLMetadataURL:      s.samlEntityID(),
+	}); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -105,11 +136,13 @@ func (s *idpServer) handleNewClient(w http.ResponseWriter, r *http.Request) {
 
 		name := strings.TrimSpace(r.FormValue("name"))
 		redirectURI := strings.TrimSpace(r.FormValue("redirect_uri"))
+		public := r.FormValue("public") == "on"
 
 		baseData := clientDisplayData{
 			IsNew:       true,
 			Name:        name,
 			RedirectURI: redirectURI,
+			Public:      public,
 		}
 
 		if errMsg := validateRedirectURI(redirectURI); errMsg != "" {
@@ -118,7 +151,10 @@ func (s *idpServer) handleNewClient(w http.ResponseWriter, r *http.Request) {
 		}
 
 		clientID := rands.HexString(32)
-		clientSecret := rands.HexString(64)
+		var clientSecret string
+		if !public {
+			clientSecret = rands.HexString(64)
+		}
 		newClient := funnelClient{
 			ID:          clientID,
 			Secret:      clientSecret,
@@ -145,8 +181,13 @@ func (s *idpServer) handleNewClient(w http.ResponseWriter, r *http.Request) {
 			Name:        name,
 			RedirectURI: redirectURI,
 			Secret:      clientSecret,
+			Public:      public,
 			IsNew:       true,
 		}
+		if public {
+			s.renderFormSuccess(w, successData, "Public client created successfully! It must use PKCE or the device flow.")
+			return
+		}
 		s.renderFormSuccess(w, successData, "Client created successfully! Save the client secret - it won't be shown again.")
 		return
 	}
@@ -256,12 +297,19 @@ func (s *idpServer) handleEditClient(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 }
 
+type listDisplayData struct {
+	Clients              []clientDisplayData
+	SAMLServiceProviders []samlDisplayData
+	SAMLMetadataURL      string
+}
+
 type clientDisplayData struct {
 	ID          string
 	Name        string
 	RedirectURI string
 	Secret      string
 	HasSecret   bool
+	Public      bool // a public client, without a secret
 	IsNew       bool
 	IsEdit      bool
 	Success     string
@@ -323,3 +371,166 @@ func validateRedirectURI(redirectURI string) string {
 
 	return ""
 }
+
+type samlDisplayData struct {
+	ID           string
+	Name         string
+	EntityID     string
+	ACSURL       string
+	AttributeMap string
+	IsNew        bool
+	Success      string
+	Error        string
+}
+
+func renderSAMLForm(w http.ResponseWriter, data samlDisplayData) {
+	var buf bytes.Buffer
+	if err := samlEditTmpl.Execute(&buf, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	buf.WriteTo(w)
+}
+
+// samlServiceProviderFromForm returns the service provider described by the
+// form of r, or an error message for the user.
+func (s *idpServer) samlServiceProviderFromForm(r *http.Request, id string) (_ *samlServiceProvider, errMsg string) {
+	sp := &samlServiceProvider{
+		ID:       id,
+		Name:     strings.TrimSpace(r.FormValue("name")),
+		EntityID: strings.TrimSpace(r.FormValue("entity_id")),
+		ACSURL:   strings.TrimSpace(r.FormValue("acs_url")),
+	}
+	if sp.EntityID == "" {
+		return nil, "Entity ID is required"
+	}
+	if u, err := url.Parse(sp.ACSURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, "Assertion Consumer Service URL must be a valid HTTP or HTTPS URL"
+	}
+	m, err := parseAttributeMap(r.FormValue("attribute_map"))
+	if err != nil {
+		return nil, err.Error()
+	}
+	sp.AttributeMap = m
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, other := range s.samlServiceProviders {
+		if other.ID != id && other.EntityID == sp.EntityID {
+			return nil, "A service provider with this entity ID already exists"
+		}
+	}
+	return sp, ""
+}
+
+func (s *idpServer) handleNewSAMLServiceProvider(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		renderSAMLForm(w, samlDisplayData{IsNew: true})
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data := samlDisplayData{
+		IsNew:        true,
+		Name:         r.FormValue("name"),
+		EntityID:     r.FormValue("entity_id"),
+		ACSURL:       r.FormValue("acs_url"),
+		AttributeMap: r.FormValue("attribute_map"),
+	}
+	sp, errMsg := s.samlServiceProviderFromForm(r, rands.HexString(16))
+	if errMsg != "" {
+		data.Error = errMsg
+		renderSAMLForm(w, data)
+		return
+	}
+
+	s.mu.Lock()
+	mak.Set(&s.samlServiceProviders, sp.ID, sp)
+	err := s.storeSAMLServiceProvidersLocked()
+	if err != nil {
+		delete(s.samlServiceProviders, sp.ID)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		log.Printf("could not write SAML service providers: %v", err)
+		data.Error = "Failed to save service provider"
+		renderSAMLForm(w, data)
+		return
+	}
+	http.Redirect(w, r, "/saml/edit/"+sp.ID+"?created=1", http.StatusSeeOther)
+}
+
+func (s *idpServer) handleEditSAMLServiceProvider(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/saml/edit/")
+	s.mu.Lock()
+	sp, ok := s.samlServiceProviders[id]
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, "Service provider not found", http.StatusNotFound)
+		return
+	}
+	data := samlDisplayData{
+		ID:           sp.ID,
+		Name:         sp.Name,
+		EntityID:     sp.EntityID,
+		ACSURL:       sp.ACSURL,
+		AttributeMap: formatAttributeMap(sp.AttributeMap),
+	}
+
+	switch r.Method {
+	case "GET":
+		if r.FormValue("created") != "" {
+			data.Success = "Service provider added! Configure it with the IdP metadata at " + s.samlEntityID()
+		}
+		renderSAMLForm(w, data)
+	case "POST":
+		if r.FormValue("action") == "delete" {
+			s.mu.Lock()
+			delete(s.samlServiceProviders, id)
+			err := s.storeSAMLServiceProvidersLocked()
+			if err != nil {
+				s.samlServiceProviders[id] = sp
+			}
+			s.mu.Unlock()
+			if err != nil {
+				log.Printf("could not write SAML service providers: %v", err)
+				data.Error = "Failed to delete service provider. Please try again."
+				renderSAMLForm(w, data)
+				return
+			}
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+
+		data.Name = r.FormValue("name")
+		data.EntityID = r.FormValue("entity_id")
+		data.ACSURL = r.FormValue("acs_url")
+		data.AttributeMap = r.FormValue("attribute_map")
+		updated, errMsg := s.samlServiceProviderFromForm(r, id)
+		if errMsg != "" {
+			data.Error = errMsg
+			renderSAMLForm(w, data)
+			return
+		}
+		s.mu.Lock()
+		s.samlServiceProviders[id] = updated
+		err := s.storeSAMLServiceProvidersLocked()
+		if err != nil {
+			s.samlServiceProviders[id] = sp
+		}
+		s.mu.Unlock()
+		if err != nil {
+			log.Printf("could not write SAML service providers: %v", err)
+			data.Error = "Failed to update service provider"
+			renderSAMLForm(w, data)
+			return
+		}
+		data.Success = "Service provider updated successfully!"
+		renderSAMLForm(w, data)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
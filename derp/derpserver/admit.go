@@ -0,0 +1,314 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package derpserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/lru"
+	"tailscale.com/util/set"
+)
+
+// AdmitRequest describes a client connecting to a [Server], for an
+// [Admitter] to decide whether to admit it.
+type AdmitRequest struct {
+	// NodePublic is the client's public key.
+	NodePublic key.NodePublic
+
+	// IP is the client's IP address, or the zero value if the client
+	// didn't connect over IP.
+	IP netip.Addr
+
+	// ConnsFromIP is the number of other connections that the server
+	// currently has from IP, including ones that are still being admitted.
+	ConnsFromIP int
+}
+
+// An Admitter decides whether a client may connect to a [Server].
+// See [Server.SetAdmitter].
+//
+// Mesh peers are always admitted, without consulting the Admitter.
+type Admitter interface {
+	// Admit returns nil if the client may connect, or else an error
+	// saying why not. The error should be a *RejectError so that the
+	// rejection is counted by its reason.
+	Admit(ctx context.Context, req AdmitRequest) error
+}
+
+// RejectError is the error returned by an [Admitter] that rejects a client.
+type RejectError struct {
+	// Reason is a short, fixed string naming the reason for the
+	// rejection, such as "ip_denied". It's used as a metric label.
+	Reason string
+
+	// Err describes the rejection in more detail.
+	Err error
+}
+
+func (e *RejectError) Error() string { return e.Err.Error() }
+func (e *RejectError) Unwrap() error { return e.Err }
+
+// reject returns a *RejectError with the given reason and formatted error.
+func reject(reason, format string, args ...any) error {
+	return &RejectError{Reason: reason, Err: fmt.Errorf(format, args...)}
+}
+
+// rejectReason returns the reason of err for the admission rejection metric.
+func rejectReason(err error) string {
+	if re, ok := errors.AsType[*RejectError](err); ok {
+		return re.Reason
+	}
+	return "error"
+}
+
+// SetAdmitter sets an Admitter to consult for each new client connection,
+// after the checks configured by [Server.SetVerifyClient] and
+// [Server.SetVerifyClientURL]. A nil Admitter admits all clients.
+//
+// It may be called at any time, and applies to new connections.
+func (s *Server) SetAdmitter(a Admitter) {
+	s.admitter.Store(admitterBox{a})
+}
+
+// Admitter returns the Admitter set by [Server.SetAdmitter], if any.
+func (s *Server) Admitter() Admitter {
+	return s.admitter.Load().Admitter
+}
+
+// admitterBox lets a nil Admitter be stored in a syncs.AtomicValue.
+type admitterBox struct{ Admitter }
+
+// Admitters is an [Admitter] that admits a client only if all of its
+// Admitters do. They're consulted in order, so cheaper checks should come
+// first.
+type Admitters []Admitter
+
+func (as Admitters) Admit(ctx context.Context, req AdmitRequest) error {
+	for _, a := range as {
+		if err := a.Admit(ctx, req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// KeyAllowlist is an [Admitter] that admits only the clients whose keys
+// it contains.
+type KeyAllowlist set.Set[key.NodePublic]
+
+func (l KeyAllowlist) Admit(ctx context.Context, req AdmitRequest) error {
+	if !set.Set[key.NodePublic](l).Contains(req.NodePublic) {
+		return reject("key_not_allowed", "node key %v not in allowlist", req.NodePublic.ShortString())
+	}
+	return nil
+}
+
+// ParseKeyAllowlist parses a list of node public keys, one per line in
+// "nodekey:..." form. Blank lines and lines starting with # are ignored.
+func ParseKeyAllowlist(b []byte) (KeyAllowlist, error) {
+	l := make(KeyAllowlist)
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var k key.NodePublic
+		if err := k.UnmarshalText([]byte(line)); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		l[k] = struct{}{}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// LoadKeyAllowlist reads a file in the [ParseKeyAllowlist] format.
+func LoadKeyAllowlist(path string) (KeyAllowlist, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	l, err := ParseKeyAllowlist(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return l, nil
+}
+
+// IPFilter is an [Admitter] that admits clients by IP address.
+//
+// Clients in a Deny prefix are rejected. If Allow is non-empty, clients
+// must also be in an Allow prefix, and clients not connecting over IP are
+// rejected.
+type IPFilter struct {
+	Allow []netip.Prefix
+	Deny  []netip.Prefix
+}
+
+func (f IPFilter) Admit(ctx context.Context, req AdmitRequest) error {
+	ip := req.IP.Unmap()
+	for _, p := range f.Deny {
+		if p.Contains(ip) {
+			return reject("ip_denied", "IP %v denied by %v", ip, p)
+		}
+	}
+	if len(f.Allow) == 0 {
+		return nil
+	}
+	for _, p := range f.Allow {
+		if p.Contains(ip) {
+			return nil
+		}
+	}
+	return reject("ip_not_allowed", "IP %v not allowed", ip)
+}
+
+// MaxConnsPerIP is an [Admitter] that admits a client only if the server
+// has fewer than that many connections from the client's IP address.
+// Clients not connecting over IP are admitted.
+type MaxConnsPerIP int
+
+func (n MaxConnsPerIP) Admit(ctx context.Context, req AdmitRequest) error {
+	if req.IP.IsValid() && req.ConnsFromIP >= int(n) {
+		return reject("too_many_conns_per_ip", "IP %v has %d connections; max %d", req.IP, req.ConnsFromIP, n)
+	}
+	return nil
+}
+
+// URLAdmitter is an [Admitter] that asks an admission controller over HTTP
+// whether to admit clients. See [tailcfg.DERPAdmitClientRequest].
+//
+// A URLAdmitter must not be copied after first use.
+type URLAdmitter struct {
+	// URL is the admission controller URL.
+	URL string
+
+	// FailOpen is whether to admit clients when the admission controller
+	// can't be reached.
+	FailOpen bool
+
+	// CacheTTL, if non-zero, is how long to remember the admission
+	// controller's decision for a client key and IP, so that a client
+	// reconnecting doesn't need another request. Clients admitted because
+	// of FailOpen aren't remembered.
+	CacheTTL time.Duration
+
+	// Logf, if non-nil, logs clients admitted because of FailOpen.
+	Logf logger.Logf
+
+	// Client, if non-nil, is the HTTP client to use instead of
+	// http.DefaultClient.
+	Client *http.Client
+
+	mu    sync.Mutex
+	cache lru.Cache[urlAdmitCacheKey, urlAdmitDecision] // lazily initialized
+}
+
+// maxURLAdmitCacheEntries is the maximum number of decisions that a
+// URLAdmitter remembers.
+const maxURLAdmitCacheEntries = 100_000
+
+type urlAdmitCacheKey struct {
+	k  key.NodePublic
+	ip netip.Addr
+}
+
+type urlAdmitDecision struct {
+	err     error // nil if admitted
+	expires time.Time
+}
+
+func (a *URLAdmitter) Admit(ctx context.Context, req AdmitRequest) error {
+	ck := urlAdmitCacheKey{req.NodePublic, req.IP}
+	if a.CacheTTL > 0 {
+		a.mu.Lock()
+		d, ok := a.cache.GetOk(ck)
+		a.mu.Unlock()
+		if ok && time.Now().Before(d.expires) {
+			return d.err
+		}
+	}
+	allow, err := a.check(ctx, req)
+	if err != nil {
+		if _, ok := errors.AsType[unreachableError](err); ok && a.FailOpen {
+			if a.Logf != nil {
+				a.Logf("admission controller unreachable; allowing client %v", req.NodePublic)
+			}
+			return nil
+		}
+		return &RejectError{Reason: "admission_controller_error", Err: err}
+	}
+	var decision error
+	if !allow {
+		decision = reject("admission_controller", "admission controller: %v/%v not allowed", req.NodePublic, req.IP)
+	}
+	if a.CacheTTL > 0 {
+		a.mu.Lock()
+		a.cache.MaxEntries = maxURLAdmitCacheEntries
+		a.cache.Set(ck, urlAdmitDecision{err: decision, expires: time.Now().Add(a.CacheTTL)})
+		a.mu.Unlock()
+	}
+	return decision
+}
+
+// unreachableError is the error returned by URLAdmitter.check when the
+// admission controller can't be reached.
+type unreachableError struct{ err error }
+
+func (e unreachableError) Error() string { return e.err.Error() }
+func (e unreachableError) Unwrap() error { return e.err }
+
+// check asks the admission controller whether to admit req.
+func (a *URLAdmitter) check(ctx context.Context, req AdmitRequest) (allow bool, _ error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	jreq, err := json.Marshal(&tailcfg.DERPAdmitClientRequest{
+		NodePublic: req.NodePublic,
+		Source:     req.IP,
+	})
+	if err != nil {
+		return false, err
+	}
+	hreq, err := http.NewRequestWithContext(ctx, "POST", a.URL, bytes.NewReader(jreq))
+	if err != nil {
+		return false, err
+	}
+	hc := a.Client
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	res, err := hc.Do(hreq)
+	if err != nil {
+		return false, unreachableError{err}
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return false, fmt.Errorf("admission controller: %v", res.Status)
+	}
+	var jres tailcfg.DERPAdmitClientResponse
+	if err := json.NewDecoder(io.LimitReader(res.Body, 4<<10)).Decode(&jres); err != nil {
+		return false, err
+	}
+	// TODO(bradfitz): add policy for configurable bandwidth rate per client?
+	return jres.Allow, nil
+}
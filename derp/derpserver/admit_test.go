@@ -0,0 +1,217 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package derpserver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tailscale.com/derp"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+)
+
+func TestParseKeyAllowlist(t *testing.T) {
+	k1, k2 := key.NewNode().Public(), key.NewNode().Public()
+	l, err := ParseKeyAllowlist([]byte("# allowed nodes\n" + k1.String() + "\n\n  " + k2.String() + "  \n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l) != 2 {
+		t.Errorf("got %d keys; want 2", len(l))
+	}
+	ctx := context.Background()
+	for _, k := range []key.NodePublic{k1, k2} {
+		if err := l.Admit(ctx, AdmitRequest{NodePublic: k}); err != nil {
+			t.Errorf("Admit(%v) = %v", k, err)
+		}
+	}
+	if err := l.Admit(ctx, AdmitRequest{NodePublic: key.NewNode().Public()}); rejectReason(err) != "key_not_allowed" {
+		t.Errorf("Admit(unknown) = %v; want key_not_allowed", err)
+	}
+	if _, err := ParseKeyAllowlist([]byte(k1.String() + "\nnodekey:bogus\n")); err == nil {
+		t.Error("unexpected success parsing bogus key")
+	}
+}
+
+func TestAdmitters(t *testing.T) {
+	ip := func(s string) netip.Addr { return netip.MustParseAddr(s) }
+	a := Admitters{
+		IPFilter{
+			Allow: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("2001:db8::/32")},
+			Deny:  []netip.Prefix{netip.MustParsePrefix("10.1.0.0/16")},
+		},
+		MaxConnsPerIP(2),
+	}
+	tests := []struct {
+		req        AdmitRequest
+		wantReason string // or empty if admitted
+	}{
+		{AdmitRequest{IP: ip("10.0.0.1")}, ""},
+		{AdmitRequest{IP: ip("::ffff:10.0.0.1")}, ""},
+		{AdmitRequest{IP: ip("2001:db8::1"), ConnsFromIP: 1}, ""},
+		{AdmitRequest{IP: ip("10.1.2.3")}, "ip_denied"},
+		{AdmitRequest{IP: ip("192.168.0.1")}, "ip_not_allowed"},
+		{AdmitRequest{}, "ip_not_allowed"},
+		{AdmitRequest{IP: ip("10.0.0.1"), ConnsFromIP: 2}, "too_many_conns_per_ip"},
+	}
+	for _, tt := range tests {
+		err := a.Admit(context.Background(), tt.req)
+		var got string
+		if err != nil {
+			got = rejectReason(err)
+		}
+		if got != tt.wantReason {
+			t.Errorf("Admit(%+v) = %v; want reason %q", tt.req, err, tt.wantReason)
+		}
+	}
+}
+
+func TestURLAdmitter(t *testing.T) {
+	allowed := key.NewNode().Public()
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		var req tailcfg.DERPAdmitClientRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		json.NewEncoder(w).Encode(tailcfg.DERPAdmitClientResponse{Allow: req.NodePublic == allowed})
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	ip := netip.MustParseAddr("192.0.2.1")
+	a := &URLAdmitter{URL: ts.URL, CacheTTL: time.Hour}
+	for range 3 {
+		if err := a.Admit(ctx, AdmitRequest{NodePublic: allowed, IP: ip}); err != nil {
+			t.Fatalf("Admit(allowed) = %v", err)
+		}
+		if err := a.Admit(ctx, AdmitRequest{NodePublic: key.NodePublic{}, IP: ip}); rejectReason(err) != "admission_controller" {
+			t.Fatalf("Admit(denied) = %v; want admission_controller rejection", err)
+		}
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("admission controller got %d requests; want 2", got)
+	}
+
+	// An unreachable admission controller rejects the client, unless
+	// FailOpen is set, and the decision isn't cached.
+	ts.Close()
+	for _, failOpen := range []bool{false, true} {
+		a := &URLAdmitter{URL: ts.URL, FailOpen: failOpen, CacheTTL: time.Hour, Logf: t.Logf}
+		err := a.Admit(ctx, AdmitRequest{NodePublic: allowed, IP: ip})
+		if failOpen && err != nil {
+			t.Errorf("FailOpen: Admit = %v; want nil", err)
+		}
+		if !failOpen && rejectReason(err) != "admission_controller_error" {
+			t.Errorf("Admit = %v; want admission_controller_error rejection", err)
+		}
+		if a.cache.Len() != 0 {
+			t.Errorf("FailOpen=%v: unreachable decision cached", failOpen)
+		}
+	}
+}
+
+func TestServerAdmitter(t *testing.T) {
+	s := New(key.NewNode(), t.Logf)
+	defer s.Close()
+	s.SetAdmitter(MaxConnsPerIP(1))
+
+	ip := netip.MustParseAddr("192.0.2.1")
+	ctx := context.Background()
+	newClient := func() *sclient {
+		k := key.NewNode().Public()
+		if _, err := s.verifyClient(ctx, k, nil, ip); err != nil {
+			t.Fatalf("connection rejected: %v", err)
+		}
+		c := &sclient{s: s, key: k, logf: t.Logf, remoteIPPort: netip.AddrPortFrom(ip, 1234)}
+		s.registerClient(c)
+		return c
+	}
+	c := newClient()
+	if _, err := s.verifyClient(ctx, key.NewNode().Public(), nil, ip); rejectReason(err) != "too_many_conns_per_ip" {
+		t.Fatalf("second connection: %v; want too_many_conns_per_ip", err)
+	}
+	if got := s.admitRejected.Get("too_many_conns_per_ip").Value(); got != 1 {
+		t.Errorf("rejections = %d; want 1", got)
+	}
+	s.unregisterClient(c)
+	s.unregisterClient(newClient())
+	if len(s.connsByIP) != 0 {
+		t.Errorf("connsByIP = %v; want empty", s.connsByIP)
+	}
+
+	// Concurrent connections from one IP are counted while they're
+	// being admitted, so only one gets in.
+	var admitted atomic.Int32
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Go(func() {
+			if _, err := s.verifyClient(ctx, key.NewNode().Public(), nil, ip); err == nil {
+				admitted.Add(1)
+			}
+		})
+	}
+	wg.Wait()
+	if got := admitted.Load(); got != 1 {
+		t.Errorf("%d concurrent connections admitted; want 1", got)
+	}
+	if got := s.connsByIP[ip]; got != 1 {
+		t.Errorf("connsByIP[%v] = %d; want 1", ip, got)
+	}
+
+	s.SetAdmitter(nil)
+	if _, err := s.verifyClient(ctx, key.NewNode().Public(), nil, ip); err != nil {
+		t.Fatalf("connection with nil Admitter rejected: %v", err)
+	}
+}
+
+func TestServerAdmitterConnsByIP(t *testing.T) {
+	s := New(key.NewNode(), t.Logf)
+	defer s.Close()
+	s.SetAdmitter(MaxConnsPerIP(1))
+	const meshKey = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	if err := s.SetMeshKey(meshKey); err != nil {
+		t.Fatal(err)
+	}
+	mk, err := key.ParseDERPMesh(meshKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ip := netip.MustParseAddr("192.0.2.1")
+	ctx := context.Background()
+
+	// Mesh peers sharing an IP aren't limited, and don't count towards
+	// the limit of other clients.
+	for range 3 {
+		isMesh, err := s.verifyClient(ctx, key.NewNode().Public(), &derp.ClientInfo{MeshKey: mk}, ip)
+		if err != nil || !isMesh {
+			t.Fatalf("mesh peer: isMesh %v, err %v; want mesh peer admitted", isMesh, err)
+		}
+	}
+	if len(s.connsByIP) != 0 {
+		t.Errorf("connsByIP with mesh peers = %v; want empty", s.connsByIP)
+	}
+
+	// An admitted client releases its slot even if it's no longer
+	// registered when it's unregistered.
+	k := key.NewNode().Public()
+	if _, err := s.verifyClient(ctx, k, nil, ip); err != nil {
+		t.Fatalf("client rejected: %v", err)
+	}
+	s.unregisterClient(&sclient{s: s, key: k, logf: t.Logf, remoteIPPort: netip.AddrPortFrom(ip, 1234)})
+	if len(s.connsByIP) != 0 {
+		t.Errorf("connsByIP after unregistering unknown client = %v; want empty", s.connsByIP)
+	}
+}
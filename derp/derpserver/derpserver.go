@@ -8,7 +8,6 @@ package derpserver
 
 import (
 	"bufio"
-	"bytes"
 	"context"
 	"crypto/ed25519"
 	crand "crypto/rand"
@@ -47,7 +46,6 @@ import (
 	"tailscale.com/envknob"
 	"tailscale.com/metrics"
 	"tailscale.com/syncs"
-	"tailscale.com/tailcfg"
 	"tailscale.com/tstime"
 	"tailscale.com/tstime/rate"
 	"tailscale.com/types/key"
@@ -132,7 +130,7 @@ type Server struct {
 	publicKey   key.NodePublic
 	logf        logger.Logf
 	memSys0     uint64 // runtime.MemStats.Sys at start (or early-ish)
-	meshKey     key.DERPMesh
+	meshKey     syncs.AtomicValue[key.DERPMesh]
 	limitedLogf logger.Logf
 	metaCert    []byte // the encoded x509 cert to send after LetsEncrypt cert+intermediate
 	dupPolicy   dupPolicy
@@ -171,14 +169,19 @@ type Server struct {
 	meshUpdateBatchSize        *metrics.Histogram
 	meshUpdateLoopCount        *metrics.Histogram
 	bufferedWriteFrames        *metrics.Histogram // how many sendLoop frames (or groups of related frames) get written per flush
+	rateLimitedPackets         metrics.LabelMap   // packets and pings dropped by ClientRateLimits, by limit
+	rateLimitedBytes           metrics.LabelMap   // bytes of packets dropped by ClientRateLimits, by limit
 
 	// verifyClientsLocalTailscaled only accepts client connections to the DERP
 	// server if the clientKey is a known peer in the network, as specified by a
 	// running tailscaled's client's LocalAPI.
-	verifyClientsLocalTailscaled bool
+	verifyClientsLocalTailscaled atomic.Bool
 
-	verifyClientsURL         string
-	verifyClientsURLFailOpen bool
+	verifyClientsURL         syncs.AtomicValue[string]
+	verifyClientsURLFailOpen atomic.Bool
+
+	admitter      syncs.AtomicValue[admitterBox]
+	admitRejected metrics.LabelMap // client connections rejected, by reason
 
 	mu       syncs.Mutex
 	closed   bool
@@ -199,6 +202,9 @@ type Server struct {
 
 	// maps from netip.AddrPort to a client's public key
 	keyOfAddr map[netip.AddrPort]key.NodePublic
+	connsByIP map[netip.Addr]int // number of admitted or registered non-mesh clients by IP
+
+	clientLimits ClientRateLimits
 
 	// Sets the client send queue depth for the server.
 	perClientSendQueueDepth int
@@ -379,7 +385,11 @@ func New(privateKey key.NodePrivate, logf logger.Logf) *Server {
 		meshUpdateBatchSize: metrics.NewHistogram([]float64{0, 1, 2, 5, 10, 20, 50, 100, 200, 500, 1000}),
 		meshUpdateLoopCount: metrics.NewHistogram([]float64{0, 1, 2, 5, 10, 20, 50, 100}),
 		bufferedWriteFrames: metrics.NewHistogram([]float64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 15, 20, 25, 50, 100}),
+		rateLimitedPackets:  metrics.LabelMap{Label: "limit"},
+		rateLimitedBytes:    metrics.LabelMap{Label: "limit"},
+		admitRejected:       metrics.LabelMap{Label: "reason"},
 		keyOfAddr:           map[netip.AddrPort]key.NodePublic{},
+		connsByIP:           map[netip.Addr]int{},
 		clock:               tstime.StdClock{},
 		tcpWriteTimeout:     DefaultTCPWiteTimeout,
 	}
@@ -441,6 +451,7 @@ func genDroppedCounters() {
 		dropReasonQueueTail,
 		dropReasonWriteError,
 		dropReasonDupClient,
+		dropReasonRateLimited,
 	}
 
 	for _, dr := range dropReasons {
@@ -461,36 +472,52 @@ func genDroppedCounters() {
 // SetMesh sets the pre-shared key that regional DERP servers used to mesh
 // amongst themselves.
 //
-// It must be called before serving begins.
+// It may be called at any time. Changing the key doesn't disconnect mesh
+// peers that already connected with the old key.
 func (s *Server) SetMeshKey(v string) error {
 	k, err := key.ParseDERPMesh(v)
 	if err != nil {
 		return err
 	}
-	s.meshKey = k
+	s.meshKey.Store(k)
 	return nil
 }
 
 // SetVerifyClients sets whether this DERP server verifies clients through tailscaled.
 //
-// It must be called before serving begins.
+// It may be called at any time, and applies to new connections.
 func (s *Server) SetVerifyClient(v bool) {
-	s.verifyClientsLocalTailscaled = v
+	s.verifyClientsLocalTailscaled.Store(v)
 }
 
 // SetVerifyClientURL sets the admission controller URL to use for verifying clients.
 // If empty, all clients are accepted (unless restricted by SetVerifyClient checking
 // against tailscaled).
+//
+// It may be called at any time, and applies to new connections.
 func (s *Server) SetVerifyClientURL(v string) {
-	s.verifyClientsURL = v
+	s.verifyClientsURL.Store(v)
 }
 
 // SetVerifyClientURLFailOpen sets whether to allow clients to connect if the
 // admission controller URL is unreachable.
 func (s *Server) SetVerifyClientURLFailOpen(v bool) {
-	s.verifyClientsURLFailOpen = v
+	s.verifyClientsURLFailOpen.Store(v)
 }
 
+// VerifyClient reports whether clients are verified through tailscaled.
+// See [Server.SetVerifyClient].
+func (s *Server) VerifyClient() bool { return s.verifyClientsLocalTailscaled.Load() }
+
+// VerifyClientURL returns the admission controller URL, if any.
+// See [Server.SetVerifyClientURL].
+func (s *Server) VerifyClientURL() string { return s.verifyClientsURL.Load() }
+
+// VerifyClientURLFailOpen reports whether clients are allowed when the
+// admission controller is unreachable.
+// See [Server.SetVerifyClientURLFailOpen].
+func (s *Server) VerifyClientURLFailOpen() bool { return s.verifyClientsURLFailOpen.Load() }
+
 // SetTailscaledSocketPath sets the unix socket path to use to talk to
 // tailscaled if client verification is enabled.
 //
@@ -509,10 +536,10 @@ func (s *Server) SetTCPWriteTimeout(d time.Duration) {
 }
 
 // HasMeshKey reports whether the server is configured with a mesh key.
-func (s *Server) HasMeshKey() bool { return !s.meshKey.IsZero() }
+func (s *Server) HasMeshKey() bool { return !s.meshKey.Load().IsZero() }
 
 // MeshKey returns the configured mesh key, if any.
-func (s *Server) MeshKey() key.DERPMesh { return s.meshKey }
+func (s *Server) MeshKey() key.DERPMesh { return s.meshKey.Load() }
 
 // PrivateKey returns the server's private key.
 func (s *Server) PrivateKey() key.NodePrivate { return s.privateKey }
@@ -676,6 +703,7 @@ func (s *Server) registerClient(c *sclient) {
 		cs = &clientSet{}
 		s.clients[c.key] = cs
 	}
+	s.applyRateLimitsLocked(c)
 	was := cs.activeClient.Load()
 	if was == nil {
 		// Common case.
@@ -739,6 +767,10 @@ func (s *Server) broadcastPeerStateChangeLocked(peer key.NodePublic, ipPort neti
 func (s *Server) unregisterClient(c *sclient) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if !c.canMesh {
+		// Counted in verifyClient, even if it's not found below.
+		defer s.releaseConnFromIPLocked(c.remoteIPPort.Addr())
+	}
 
 	set, ok := s.clients[c.key]
 	if !ok {
@@ -925,7 +957,8 @@ func (s *Server) accept(ctx context.Context, nc derp.Conn, brw *bufio.ReadWriter
 	}
 
 	remoteIPPort, _ := netip.ParseAddrPort(remoteAddr)
-	if err := s.verifyClient(ctx, clientKey, clientInfo, remoteIPPort.Addr()); err != nil {
+	canMesh, err := s.verifyClient(ctx, clientKey, clientInfo, remoteIPPort.Addr())
+	if err != nil {
 		return fmt.Errorf("client %v rejected: %v", clientKey, err)
 	}
 
@@ -950,7 +983,7 @@ func (s *Server) accept(ctx context.Context, nc derp.Conn, brw *bufio.ReadWriter
 		discoSendQueue: make(chan pkt, s.perClientSendQueueDepth),
 		sendPongCh:     make(chan [8]byte, 1),
 		peerGone:       make(chan peerGoneMsg),
-		canMesh:        s.isMeshPeer(clientInfo),
+		canMesh:        canMesh,
 		isNotIdealConn: IdealNodeContextKey.Value(ctx) != "",
 		peerGoneLim:    rate.NewLimiter(rate.Every(time.Second), 3),
 	}
@@ -1096,11 +1129,14 @@ func (c *sclient) handleFramePing(ft derp.FrameType, fl uint32) error {
 	if extra := int64(fl) - int64(len(m)); extra > 0 {
 		_, err = io.CopyN(io.Discard, c.br, extra)
 	}
+	if !c.allowPing() {
+		c.s.rateLimitedPackets.Add(rateLimitPings, 1)
+		return err
+	}
 	select {
 	case c.sendPongCh <- [8]byte(m):
 	default:
 		// They're pinging too fast. Ignore.
-		// TODO(bradfitz): add a rate limiter too.
 	}
 	return err
 }
@@ -1190,6 +1226,14 @@ func (c *sclient) handleFrameSendPacket(ft derp.FrameType, fl uint32) error {
 		return fmt.Errorf("client %v: recvPacket: %v", c.key, err)
 	}
 
+	if ok, limit := c.allowPacket(len(contents)); !ok {
+		s.rateLimitedPackets.Add(limit, 1)
+		s.rateLimitedBytes.Add(limit, int64(len(contents)))
+		s.recordDrop(contents, c.key, dstKey, dropReasonRateLimited)
+		c.debugLogf("SendPacket for %s, dropping with reason=%s (%s)", dstKey.ShortString(), dropReasonRateLimited, limit)
+		return nil
+	}
+
 	var fwd PacketForwarder
 	var dstLen int
 	var dst *sclient
@@ -1257,6 +1301,7 @@ const (
 	dropReasonQueueTail        dropReason = "queue_tail"          // destination queue is full, dropped packet at queue tail
 	dropReasonWriteError       dropReason = "write_error"         // OS write() failed
 	dropReasonDupClient        dropReason = "dup_client"          // the public key is connected 2+ times (active/active, fighting)
+	dropReasonRateLimited      dropReason = "rate_limited"        // the source client exceeded its ClientRateLimits
 )
 
 func (s *Server) recordDrop(packetBytes []byte, srcKey, dstKey key.NodePublic, reason dropReason) {
@@ -1371,70 +1416,115 @@ func (s *Server) isMeshPeer(info *derp.ClientInfo) bool {
 		return false
 	}
 
-	return s.meshKey.Equal(info.MeshKey)
+	return s.meshKey.Load().Equal(info.MeshKey)
 }
 
 // verifyClient checks whether the client is allowed to connect to the derper,
 // depending on how & whether the server's been configured to verify.
-func (s *Server) verifyClient(ctx context.Context, clientKey key.NodePublic, info *derp.ClientInfo, clientIP netip.Addr) error {
+// Rejections are counted by reason.
+//
+// It reports whether the client is a trusted mesh peer. If the client is
+// allowed and isn't a mesh peer, it's counted in connsByIP until it's passed
+// to unregisterClient. Mesh peers aren't counted, so that a region's servers
+// sharing an IP don't count towards the limits of other clients.
+func (s *Server) verifyClient(ctx context.Context, clientKey key.NodePublic, info *derp.ClientInfo, clientIP netip.Addr) (isMesh bool, err error) {
 	if s.isMeshPeer(info) {
 		// Trusted mesh peer. No need to verify further. In fact, verifying
 		// further wouldn't work: it's not part of the tailnet so tailscaled and
 		// likely the admission control URL wouldn't know about it.
-		return nil
+		return true, nil
+	}
+	err = s.admitClient(ctx, clientKey, clientIP)
+	if err != nil {
+		s.admitRejected.Add(rejectReason(err), 1)
 	}
+	return false, err
+}
 
+// admitClient is the non-mesh part of verifyClient.
+func (s *Server) admitClient(ctx context.Context, clientKey key.NodePublic, clientIP netip.Addr) error {
 	// tailscaled-based verification:
-	if s.verifyClientsLocalTailscaled {
-		_, err := s.localClient.WhoIsNodeKey(ctx, clientKey)
-		if err == local.ErrPeerNotFound {
-			return fmt.Errorf("peer %v not authorized (not found in local tailscaled)", clientKey)
-		}
-		if err != nil {
-			if strings.Contains(err.Error(), "invalid 'addr' parameter") {
-				// Issue 12617
-				return errors.New("tailscaled version is too old (out of sync with derper binary)")
-			}
-			return fmt.Errorf("failed to query local tailscaled status for %v: %w", clientKey, err)
+	if s.verifyClientsLocalTailscaled.Load() {
+		if err := s.verifyClientLocalTailscaled(ctx, clientKey); err != nil {
+			return err
 		}
 	}
 
-	// admission controller-based verification:
-	if s.verifyClientsURL != "" {
-		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-		defer cancel()
+	// Count the client before asking the admitters, so that concurrent
+	// connections from the same IP see each other.
+	s.mu.Lock()
+	req := AdmitRequest{
+		NodePublic:  clientKey,
+		IP:          clientIP,
+		ConnsFromIP: s.connsByIP[clientIP],
+	}
+	s.addConnFromIPLocked(clientIP)
+	s.mu.Unlock()
 
-		jreq, err := json.Marshal(&tailcfg.DERPAdmitClientRequest{
-			NodePublic: clientKey,
-			Source:     clientIP,
-		})
-		if err != nil {
-			return err
-		}
-		req, err := http.NewRequestWithContext(ctx, "POST", s.verifyClientsURL, bytes.NewReader(jreq))
-		if err != nil {
-			return err
-		}
-		res, err := http.DefaultClient.Do(req)
-		if err != nil {
-			if s.verifyClientsURLFailOpen {
-				s.logf("admission controller unreachable; allowing client %v", clientKey)
-				return nil
-			}
-			return err
-		}
-		defer res.Body.Close()
-		if res.StatusCode != 200 {
-			return fmt.Errorf("admission controller: %v", res.Status)
+	err := s.runAdmitters(ctx, req)
+	if err != nil {
+		s.mu.Lock()
+		s.releaseConnFromIPLocked(clientIP)
+		s.mu.Unlock()
+	}
+	return err
+}
+
+// runAdmitters asks the configured admission controllers whether to admit
+// the client in req.
+func (s *Server) runAdmitters(ctx context.Context, req AdmitRequest) error {
+	// admission controller-based verification:
+	if verifyURL := s.verifyClientsURL.Load(); verifyURL != "" {
+		a := &URLAdmitter{
+			URL:      verifyURL,
+			FailOpen: s.verifyClientsURLFailOpen.Load(),
+			Logf:     s.logf,
 		}
-		var jres tailcfg.DERPAdmitClientResponse
-		if err := json.NewDecoder(io.LimitReader(res.Body, 4<<10)).Decode(&jres); err != nil {
+		if err := a.Admit(ctx, req); err != nil {
 			return err
 		}
-		if !jres.Allow {
-			return fmt.Errorf("admission controller: %v/%v not allowed", clientKey, clientIP)
+	}
+
+	if a := s.admitter.Load().Admitter; a != nil {
+		return a.Admit(ctx, req)
+	}
+	return nil
+}
+
+// addConnFromIPLocked counts a connection from ip in connsByIP.
+//
+// s.mu must be held.
+func (s *Server) addConnFromIPLocked(ip netip.Addr) {
+	if ip.IsValid() {
+		s.connsByIP[ip]++
+	}
+}
+
+// releaseConnFromIPLocked undoes addConnFromIPLocked.
+//
+// s.mu must be held.
+func (s *Server) releaseConnFromIPLocked(ip netip.Addr) {
+	if !ip.IsValid() {
+		return
+	}
+	if s.connsByIP[ip]--; s.connsByIP[ip] <= 0 {
+		delete(s.connsByIP, ip)
+	}
+}
+
+// verifyClientLocalTailscaled checks that clientKey is a peer of the local
+// tailscaled.
+func (s *Server) verifyClientLocalTailscaled(ctx context.Context, clientKey key.NodePublic) error {
+	_, err := s.localClient.WhoIsNodeKey(ctx, clientKey)
+	if err == local.ErrPeerNotFound {
+		return reject("tailscaled", "peer %v not authorized (not found in local tailscaled)", clientKey)
+	}
+	if err != nil {
+		if strings.Contains(err.Error(), "invalid 'addr' parameter") {
+			// Issue 12617
+			return &RejectError{Reason: "tailscaled_error", Err: errors.New("tailscaled version is too old (out of sync with derper binary)")}
 		}
-		// TODO(bradfitz): add policy for configurable bandwidth rate per client?
+		return reject("tailscaled_error", "failed to query local tailscaled status for %v: %w", clientKey, err)
 	}
 	return nil
 }
@@ -1666,6 +1756,10 @@ type sclient struct {
 	// client that it's trying to establish a direct connection
 	// through us with a peer we have no record of.
 	peerGoneLim *rate.Limiter
+
+	// limiters enforce the server's ClientRateLimits, or are nil if
+	// there are none. It's set while holding s.mu.
+	limiters atomic.Pointer[clientLimiters]
 }
 
 func (c *sclient) presentFlags() derp.PeerPresentFlags {
@@ -2246,6 +2340,9 @@ func (s *Server) ExpVar() expvar.Var {
 	m.Set("counter_mesh_update_batch_size", s.meshUpdateBatchSize)
 	m.Set("counter_mesh_update_loop_count", s.meshUpdateLoopCount)
 	m.Set("counter_buffered_write_frames", s.bufferedWriteFrames)
+	m.Set("counter_rate_limited_packets", &s.rateLimitedPackets)
+	m.Set("counter_rate_limited_bytes", &s.rateLimitedBytes)
+	m.Set("counter_admit_rejected", &s.admitRejected)
 	var expvarVersion expvar.String
 	expvarVersion.Set(version.Long())
 	m.Set("version", &expvarVersion)
@@ -2286,7 +2383,7 @@ func (s *Server) ConsistencyCheck() error {
 			len(s.clients)))
 	}
 
-	if s.verifyClientsLocalTailscaled {
+	if s.verifyClientsLocalTailscaled.Load() {
 		if err := s.checkVerifyClientsLocalTailscaled(); err != nil {
 			errs = append(errs, err.Error())
 		}
@@ -2306,11 +2403,7 @@ func (s *Server) checkVerifyClientsLocalTailscaled() error {
 	if err != nil {
 		return fmt.Errorf("localClient.Status: %w", err)
 	}
-	info := &derp.ClientInfo{
-		IsProber: true,
-	}
-	clientIP := netip.IPv6Loopback()
-	if err := s.verifyClient(ctx, status.Self.PublicKey, info, clientIP); err != nil {
+	if err := s.verifyClientLocalTailscaled(ctx, status.Self.PublicKey); err != nil {
 		return fmt.Errorf("verifyClient for self nodekey: %w", err)
 	}
 	return nil
@@ -66,8 +66,8 @@ func TestSetMeshKey(t *testing.T) {
 			if err != nil {
 				t.Fatal(err)
 			}
-			if !s.meshKey.Equal(want) {
-				t.Fatalf("got %v, want %v", s.meshKey, want)
+			if got := s.MeshKey(); !got.Equal(want) {
+				t.Fatalf("got %v, want %v", got, want)
 			}
 		})
 	}
@@ -0,0 +1,137 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package derpserver
+
+import (
+	xrate "golang.org/x/time/rate"
+	"tailscale.com/derp"
+)
+
+// ClientRateLimits are limits on how fast each client connection may send
+// packets and pings through a [Server]. Packets and pings over the limits
+// are dropped. Mesh peers are not limited.
+//
+// A zero rate means unlimited.
+type ClientRateLimits struct {
+	// BytesPerSecond limits the rate of packet payload bytes sent.
+	BytesPerSecond float64 `json:",omitempty"`
+
+	// BytesBurst is the number of bytes that may be sent at once, above
+	// BytesPerSecond. If zero, it's BytesPerSecond, but no less than
+	// derp.MaxPacketSize, so that every packet can eventually be sent.
+	// If set, it must be at least derp.MaxPacketSize.
+	BytesBurst int `json:",omitempty"`
+
+	// PacketsPerSecond limits the rate of packets sent.
+	PacketsPerSecond float64 `json:",omitempty"`
+
+	// PacketsBurst is the number of packets that may be sent at once,
+	// above PacketsPerSecond. If zero, it's PacketsPerSecond, but no
+	// less than 1.
+	PacketsBurst int `json:",omitempty"`
+
+	// PingsPerSecond limits the rate of ping frames answered.
+	PingsPerSecond float64 `json:",omitempty"`
+
+	// PingsBurst is like PacketsBurst, for pings.
+	PingsBurst int `json:",omitempty"`
+}
+
+// Labels of the Server.rateLimited* metrics.
+const (
+	rateLimitBytes   = "bytes"
+	rateLimitPackets = "packets"
+	rateLimitPings   = "pings"
+)
+
+// SetClientRateLimits sets the per-client rate limits.
+// It may be called at any time, and applies to existing clients.
+func (s *Server) SetClientRateLimits(l ClientRateLimits) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clientLimits = l
+	for _, cs := range s.clients {
+		cs.ForeachClient(s.applyRateLimitsLocked)
+	}
+}
+
+// ClientRateLimits returns the current per-client rate limits.
+func (s *Server) ClientRateLimits() ClientRateLimits {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.clientLimits
+}
+
+// applyRateLimitsLocked replaces c's rate limiters with new ones for
+// s.clientLimits, which start with full buckets.
+//
+// s.mu must be held.
+func (s *Server) applyRateLimitsLocked(c *sclient) {
+	if c.canMesh {
+		return
+	}
+	l := s.clientLimits
+	if l == (ClientRateLimits{}) {
+		c.limiters.Store(nil)
+		return
+	}
+	c.limiters.Store(&clientLimiters{
+		bytes:   newLimiter(l.BytesPerSecond, l.BytesBurst, derp.MaxPacketSize),
+		packets: newLimiter(l.PacketsPerSecond, l.PacketsBurst, 1),
+		pings:   newLimiter(l.PingsPerSecond, l.PingsBurst, 1),
+	})
+}
+
+// clientLimiters are the rate limiters of an sclient.
+// A nil limiter means unlimited.
+type clientLimiters struct {
+	bytes, packets, pings *xrate.Limiter
+}
+
+// newLimiter returns a new rate limiter for perSecond, or nil if perSecond is
+// zero. If burst is zero, one second's worth is used instead, but no less
+// than minBurst.
+func newLimiter(perSecond float64, burst, minBurst int) *xrate.Limiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = max(int(perSecond), minBurst)
+	}
+	return xrate.NewLimiter(xrate.Limit(perSecond), burst)
+}
+
+// allowPacket reports whether c's rate limits allow it to send a packet
+// of n bytes now, counting the packet against the limits if so.
+// If not, it returns the name of the limit that was exceeded, and the
+// packet isn't counted against any limit.
+func (c *sclient) allowPacket(n int) (ok bool, limit string) {
+	lims := c.limiters.Load()
+	if lims == nil {
+		return true, ""
+	}
+	now := c.s.clock.Now()
+	var pr *xrate.Reservation
+	if lims.packets != nil {
+		pr = lims.packets.ReserveN(now, 1)
+		if !pr.OK() || pr.DelayFrom(now) > 0 {
+			pr.CancelAt(now)
+			return false, rateLimitPackets
+		}
+	}
+	if lims.bytes != nil && !lims.bytes.AllowN(now, n) {
+		if pr != nil {
+			pr.CancelAt(now)
+		}
+		return false, rateLimitBytes
+	}
+	return true, ""
+}
+
+// allowPing reports whether c's rate limits allow it a ping now,
+// counting the ping against the limits if so.
+func (c *sclient) allowPing() bool {
+	lims := c.limiters.Load()
+	return lims == nil || lims.pings == nil || lims.pings.AllowN(c.s.clock.Now(), 1)
+}
@@ -0,0 +1,128 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package derpserver
+
+import (
+	"bufio"
+	"bytes"
+	"expvar"
+	"testing"
+	"time"
+
+	"tailscale.com/derp"
+	"tailscale.com/tstest"
+	"tailscale.com/types/key"
+)
+
+func TestClientRateLimits(t *testing.T) {
+	clock := tstest.NewClock(tstest.ClockOpts{})
+	s := New(key.NewNode(), t.Logf)
+	defer s.Close()
+	s.clock = clock
+
+	c := &sclient{s: s, key: key.NewNode().Public(), logf: t.Logf}
+	mesh := &sclient{s: s, key: key.NewNode().Public(), logf: t.Logf, canMesh: true}
+	s.registerClient(c)
+	s.registerClient(mesh)
+
+	// Without limits, everything is allowed.
+	for range 100 {
+		if ok, _ := c.allowPacket(derp.MaxPacketSize); !ok {
+			t.Fatal("packet not allowed without limits")
+		}
+		if !c.allowPing() {
+			t.Fatal("ping not allowed without limits")
+		}
+	}
+
+	s.SetClientRateLimits(ClientRateLimits{
+		BytesPerSecond:   2000,
+		PacketsPerSecond: 10,
+		PingsPerSecond:   1,
+		PingsBurst:       2,
+	})
+
+	// The byte bucket holds a max-size packet, despite the lower rate.
+	if ok, _ := c.allowPacket(derp.MaxPacketSize); !ok {
+		t.Fatal("max-size packet not allowed with full bucket")
+	}
+	if ok, limit := c.allowPacket(1500); ok || limit != rateLimitBytes {
+		t.Fatalf("allowPacket after emptying byte bucket = %v, %q; want false, %q", ok, limit, rateLimitBytes)
+	}
+	clock.Advance(time.Second)
+	if ok, _ := c.allowPacket(1500); !ok {
+		t.Fatal("packet not allowed after byte bucket refilled")
+	}
+
+	// Replacing the limits refills the buckets. Then the packet bucket
+	// (10 packets) runs out before the byte bucket.
+	s.SetClientRateLimits(s.ClientRateLimits())
+	for i := range 10 {
+		if ok, limit := c.allowPacket(1); !ok {
+			t.Fatalf("packet %d not allowed: %s", i, limit)
+		}
+	}
+	if ok, limit := c.allowPacket(1); ok || limit != rateLimitPackets {
+		t.Fatalf("allowPacket after emptying packet bucket = %v, %q; want false, %q", ok, limit, rateLimitPackets)
+	}
+
+	// A packet dropped for one limit doesn't count against the other.
+	s.SetClientRateLimits(s.ClientRateLimits())
+	if ok, _ := c.allowPacket(derp.MaxPacketSize); !ok {
+		t.Fatal("max-size packet not allowed with full buckets")
+	}
+	clock.Advance(time.Second)
+	for i := range 20 {
+		if ok, limit := c.allowPacket(derp.MaxPacketSize); ok || limit != rateLimitBytes {
+			t.Fatalf("packet %d over byte limit = %v, %q; want false, %q", i, ok, limit, rateLimitBytes)
+		}
+	}
+	for i := range 10 {
+		if ok, limit := c.allowPacket(100); !ok {
+			t.Fatalf("small packet %d after byte drops not allowed: %s", i, limit)
+		}
+	}
+
+	for i := range 2 {
+		if !c.allowPing() {
+			t.Fatalf("ping %d not allowed", i)
+		}
+	}
+	if c.allowPing() {
+		t.Fatal("ping allowed after emptying ping bucket")
+	}
+
+	// Mesh peers aren't limited.
+	for range 100 {
+		if ok, _ := mesh.allowPacket(derp.MaxPacketSize); !ok {
+			t.Fatal("mesh peer packet not allowed")
+		}
+	}
+
+	// Rate-limited packets are dropped and counted.
+	dst := key.NewNode().Public()
+	payload := []byte("hello")
+	raw := dst.Raw32()
+	c.br = bufio.NewReader(bytes.NewReader(append(raw[:], payload...)))
+	labels := dropReasonKindLabels{Reason: string(dropReasonRateLimited), Kind: string(packetKindOther)}
+	droppedBefore := packetsDropped.Get(labels).(*expvar.Int).Value()
+	if err := c.handleFrameSendPacket(derp.FrameSendPacket, uint32(len(raw)+len(payload))); err != nil {
+		t.Fatal(err)
+	}
+	if got := packetsDropped.Get(labels).(*expvar.Int).Value() - droppedBefore; got != 1 {
+		t.Errorf("rate_limited drops = %d; want 1", got)
+	}
+	if got := s.rateLimitedPackets.Get(rateLimitPackets).Value(); got != 1 {
+		t.Errorf("rate limited packets = %d; want 1", got)
+	}
+	if got := s.rateLimitedBytes.Get(rateLimitPackets).Value(); got != int64(len(payload)) {
+		t.Errorf("rate limited bytes = %d; want %d", got, len(payload))
+	}
+
+	// Removing the limits removes the limiters.
+	s.SetClientRateLimits(ClientRateLimits{})
+	if c.limiters.Load() != nil {
+		t.Error("limiters not removed")
+	}
+}
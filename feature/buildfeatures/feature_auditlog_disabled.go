@@ -0,0 +1,13 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Code generated by gen.go; DO NOT EDIT.
+
+//go:build ts_omit_auditlog
+
+package buildfeatures
+
+// HasAuditLog is whether the binary was built with support for modular feature "Client audit log exports (Linux only; always included on Windows)".
+// Specifically, it's whether the binary was NOT built with the "ts_omit_auditlog" build tag.
+// It's a const so it can be used for dead code elimination.
+const HasAuditLog = false
@@ -0,0 +1,13 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Code generated by gen.go; DO NOT EDIT.
+
+//go:build ts_omit_localnetlog
+
+package buildfeatures
+
+// HasLocalNetLog is whether the binary was built with support for modular feature "Local network flow log files, IPFIX export and LocalAPI stream".
+// Specifically, it's whether the binary was NOT built with the "ts_omit_localnetlog" build tag.
+// It's a const so it can be used for dead code elimination.
+const HasLocalNetLog = false
@@ -0,0 +1,8 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !ios && !ts_omit_localnetlog
+
+package condregister
+
+import _ "tailscale.com/feature/localnetlog"
@@ -96,6 +96,7 @@ var Features = map[FeatureTag]FeatureMeta{
 	"ace":           {Sym: "ACE", Desc: "Alternate Connectivity Endpoints"},
 	"acme":          {Sym: "ACME", Desc: "ACME TLS certificate management"},
 	"appconnectors": {Sym: "AppConnectors", Desc: "App Connectors support"},
+	"auditlog":      {Sym: "AuditLog", Desc: "Client audit log exports (Linux only; always included on Windows)"},
 	"aws":           {Sym: "AWS", Desc: "AWS integration"},
 	"advertiseexitnode": {
 		Sym:  "AdvertiseExitNode",
@@ -222,6 +223,11 @@ var Features = map[FeatureTag]FeatureMeta{
 		Desc: "Network flow logging support",
 		Deps: []FeatureTag{"logtail"},
 	},
+	"localnetlog": {
+		Sym:  "LocalNetLog",
+		Desc: "Local network flow log files, IPFIX export and LocalAPI stream",
+		Deps: []FeatureTag{"netlog"},
+	},
 	"netstack": {Sym: "Netstack", Desc: "gVisor netstack (userspace networking) support"},
 	"networkmanager": {
 		Sym:  "NetworkManager",
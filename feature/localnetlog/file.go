@@ -0,0 +1,232 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package localnetlog
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/syncs"
+)
+
+const (
+	defaultMaxFileSize = 10 << 20
+	defaultMaxFiles    = 10
+
+	// currentFile is the name of the file being written to.
+	currentFile = "netlog.jsonl"
+
+	// rotatedPrefix and rotatedTimeFormat make up the names of rotated
+	// files, which sort in the order they were rotated, before currentFile.
+	rotatedPrefix     = "netlog-"
+	rotatedTimeFormat = "20060102T150405.000000000Z"
+)
+
+// fileWriter writes JSON lines to currentFile in a directory,
+// rotating it when it gets too large.
+type fileWriter struct {
+	dir      string
+	maxSize  int64
+	maxFiles int
+	compress bool
+	now      func() time.Time // for tests
+
+	mu   syncs.Mutex
+	f    *os.File // or nil if closed
+	size int64    // of f
+
+	// finishMu serializes compressing and pruning rotated files,
+	// which is done without mu held.
+	finishMu syncs.Mutex
+}
+
+func newFileWriter(conf *ipn.NetLogConfig) (*fileWriter, error) {
+	if conf.Dir == "" {
+		return nil, errors.New("no directory configured")
+	}
+	if conf.MaxFileSize < 0 || conf.MaxFiles < 0 {
+		return nil, errors.New("MaxFileSize and MaxFiles must not be negative")
+	}
+	w := &fileWriter{
+		dir:      conf.Dir,
+		maxSize:  conf.MaxFileSize,
+		maxFiles: conf.MaxFiles,
+		compress: conf.Compress,
+		now:      time.Now,
+	}
+	if w.maxSize == 0 {
+		w.maxSize = defaultMaxFileSize
+	}
+	if w.maxFiles == 0 {
+		w.maxFiles = defaultMaxFiles
+	}
+	if err := os.MkdirAll(w.dir, 0700); err != nil {
+		return nil, err
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// open opens currentFile for appending.
+// w.mu must be held, or w must not yet be shared.
+func (w *fileWriter) open() error {
+	f, err := os.OpenFile(filepath.Join(w.dir, currentFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.f, w.size = f, fi.Size()
+	return nil
+}
+
+// writeLine writes line, which must end in a newline, to the current file,
+// rotating it first if line would take it past the maximum size.
+func (w *fileWriter) writeLine(line []byte) error {
+	w.mu.Lock()
+	rotated, err := w.writeLineLocked(line)
+	w.mu.Unlock()
+	if rotated != "" {
+		// Compress and prune without w.mu held, so that other writes
+		// don't wait on compressing a whole file.
+		if ferr := w.finishRotation(rotated); ferr != nil {
+			err = errors.Join(err, fmt.Errorf("rotating: %w", ferr))
+		}
+	}
+	return err
+}
+
+// writeLineLocked is the part of writeLine that needs w.mu held.
+// If it rotated the current file, it returns the rotated file's path,
+// which the caller must pass to finishRotation.
+func (w *fileWriter) writeLineLocked(line []byte) (rotated string, err error) {
+	if w.f == nil {
+		return "", errors.New("closed")
+	}
+	if w.size > 0 && w.size+int64(len(line)) > w.maxSize {
+		rotated, err = w.rotateLocked()
+		if err != nil {
+			return rotated, fmt.Errorf("rotating: %w", err)
+		}
+	}
+	n, err := w.f.Write(line)
+	w.size += int64(n)
+	return rotated, err
+}
+
+// rotateLocked renames the current file to a rotated file and opens a new
+// current file. It returns the rotated file's path, or the empty string if
+// the current file wasn't renamed.
+func (w *fileWriter) rotateLocked() (rotated string, err error) {
+	if err := w.f.Close(); err != nil {
+		return "", err
+	}
+	w.f = nil
+	cur := filepath.Join(w.dir, currentFile)
+	rotated = filepath.Join(w.dir, rotatedPrefix+w.now().UTC().Format(rotatedTimeFormat)+".jsonl")
+	if err := os.Rename(cur, rotated); err != nil {
+		return "", err
+	}
+	return rotated, w.open()
+}
+
+// finishRotation compresses the rotated file at path if configured to,
+// and then deletes the oldest rotated files beyond the maximum number.
+// w.mu must not be held.
+func (w *fileWriter) finishRotation(path string) error {
+	w.finishMu.Lock()
+	defer w.finishMu.Unlock()
+	if w.compress {
+		if err := gzipFile(path); err != nil {
+			return err
+		}
+	}
+	return w.prune()
+}
+
+// prune deletes the oldest rotated files beyond w.maxFiles.
+// w.finishMu must be held.
+func (w *fileWriter) prune() error {
+	des, err := os.ReadDir(w.dir)
+	if err != nil {
+		return err
+	}
+	var rotated []string
+	for _, de := range des {
+		name := de.Name()
+		if strings.HasPrefix(name, rotatedPrefix) && (strings.HasSuffix(name, ".jsonl") || strings.HasSuffix(name, ".jsonl.gz")) {
+			rotated = append(rotated, name)
+		}
+	}
+	slices.Sort(rotated)
+	var errs []error
+	for len(rotated) > w.maxFiles {
+		if err := os.Remove(filepath.Join(w.dir, rotated[0])); err != nil {
+			errs = append(errs, err)
+		}
+		rotated = rotated[1:]
+	}
+	return errors.Join(errs...)
+}
+
+// gzipFile replaces the file at path with a gzip compressed copy
+// named path+".gz".
+func gzipFile(path string) (err error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tmp := path + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(tmp)
+		}
+	}()
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Close closes the current file.
+func (w *fileWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f = nil
+	return err
+}
@@ -0,0 +1,172 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package localnetlog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"tailscale.com/ipn"
+)
+
+func TestFileWriterRotation(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		t.Run(fmt.Sprintf("compress=%v", compress), func(t *testing.T) {
+			dir := t.TempDir()
+			w, err := newFileWriter(&ipn.NetLogConfig{
+				Dir:         dir,
+				MaxFileSize: 100,
+				MaxFiles:    2,
+				Compress:    compress,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Close()
+			now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+			w.now = func() time.Time {
+				now = now.Add(time.Second)
+				return now
+			}
+
+			// Each line is 40 bytes, so each file holds two lines.
+			var lines [][]byte
+			for i := range 9 {
+				line := fmt.Appendf(nil, "%039d\n", i)
+				lines = append(lines, line)
+				if err := w.writeLine(line); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			des, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, de := range des {
+				names = append(names, de.Name())
+			}
+			ext := ".jsonl"
+			if compress {
+				ext += ".gz"
+			}
+			// Lines 0-3 were in rotated files that have since been deleted.
+			want := []string{
+				"netlog-20250102T030408.000000000Z" + ext, // lines 4 and 5
+				"netlog-20250102T030409.000000000Z" + ext, // lines 6 and 7
+				"netlog.jsonl", // line 8
+			}
+			if !slices.Equal(names, want) {
+				t.Fatalf("files = %q; want %q", names, want)
+			}
+
+			var got []byte
+			for _, name := range names {
+				got = append(got, readFile(t, filepath.Join(dir, name))...)
+			}
+			if want := bytes.Join(lines[4:], nil); !bytes.Equal(got, want) {
+				t.Errorf("contents = %q; want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestFileWriterRotationUnlocked(t *testing.T) {
+	dir := t.TempDir()
+	w, err := newFileWriter(&ipn.NetLogConfig{
+		Dir:         dir,
+		MaxFileSize: 20,
+		Compress:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	line := []byte("0123456789\n")
+	if err := w.writeLine(line); err != nil {
+		t.Fatal(err)
+	}
+
+	// Block compressing the file that the next write rotates, and check
+	// that writes continue in the meantime.
+	w.finishMu.Lock()
+	rotated := make(chan error, 1)
+	go func() {
+		rotated <- w.writeLine(line)
+	}()
+	for {
+		matches, err := filepath.Glob(filepath.Join(dir, rotatedPrefix+"*"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(matches) > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := w.writeLine([]byte("x\n")); err != nil {
+		t.Fatal(err)
+	}
+	w.finishMu.Unlock()
+	if err := <-rotated; err != nil {
+		t.Fatal(err)
+	}
+	matches, err := filepath.Glob(filepath.Join(dir, rotatedPrefix+"*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 || filepath.Ext(matches[0]) != ".gz" {
+		t.Errorf("rotated files = %q; want one .gz file", matches)
+	}
+}
+
+func TestFileWriterAppends(t *testing.T) {
+	dir := t.TempDir()
+	conf := &ipn.NetLogConfig{Dir: dir}
+	for _, line := range []string{"a\n", "b\n"} {
+		w, err := newFileWriter(conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.writeLine([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := string(readFile(t, filepath.Join(dir, currentFile))); got != "a\nb\n" {
+		t.Errorf("contents = %q; want %q", got, "a\nb\n")
+	}
+}
+
+func readFile(t *testing.T, path string) []byte {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if filepath.Ext(path) == ".gz" {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = zr
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
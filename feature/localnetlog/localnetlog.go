@@ -0,0 +1,251 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package localnetlog makes network flow logs (see [netlogtype.Message])
+// available on the local node: written to rotated files or exported to an
+// IPFIX collector if configured in the tailscaled config file, and streamed
+// over the LocalAPI. This works whether or not network flow logging is
+// enabled for the tailnet.
+//
+// The records are JSON lines that cmd/netlogfmt can format and query.
+package localnetlog
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"github.com/go-json-experiment/json/jsontext"
+	"tailscale.com/feature"
+	"tailscale.com/ipn/ipnext"
+	"tailscale.com/ipn/ipnlocal"
+	"tailscale.com/ipn/localapi"
+	"tailscale.com/syncs"
+	"tailscale.com/types/logger"
+	"tailscale.com/types/netlogtype"
+	"tailscale.com/util/set"
+	"tailscale.com/wgengine"
+	"tailscale.com/wgengine/netlog/ipfix"
+)
+
+// featureName is the name of the feature implemented by this package.
+// It is also the [extension] name and the log prefix.
+const featureName = "localnetlog"
+
+func init() {
+	feature.Register(featureName)
+	ipnext.RegisterExtension(featureName, newExtension)
+	localapi.Register("netlog", serveNetLog)
+}
+
+// streamBuffer is the number of records buffered for each LocalAPI stream.
+// Records for a stream that falls further behind are dropped.
+const streamBuffer = 16
+
+// extension is an [ipnext.Extension] that records network flow logs
+// while there's somewhere to write them.
+type extension struct {
+	logf   logger.Logf
+	engine wgengine.NetLogSinkSetter
+	file   *fileWriter     // or nil if not configured
+	ipfix  *ipfix.Exporter // or nil if not configured
+
+	// installMu serializes installing and removing the engine's sink.
+	// Removing the sink doesn't wait for in-progress calls to write,
+	// which is what closeMu is for.
+	installMu sync.Mutex
+	installed bool // whether write is installed as the engine's sink
+
+	// closeMu is held for reading by write while it uses file and ipfix,
+	// and for writing by Shutdown while it closes them.
+	closeMu sync.RWMutex
+	closed  bool // whether file and ipfix have been closed
+
+	mu       syncs.Mutex
+	streams  set.HandleSet[chan []byte]
+	shutdown bool
+}
+
+// newExtension is an [ipnext.NewExtensionFn] that creates a new local
+// network flow log extension.
+func newExtension(logf logger.Logf, sb ipnext.SafeBackend) (ipnext.Extension, error) {
+	eng, ok := sb.Sys().Engine.GetOK()
+	if !ok {
+		return nil, ipnext.SkipExtension
+	}
+	sinkSetter, ok := eng.(wgengine.NetLogSinkSetter)
+	if !ok {
+		return nil, ipnext.SkipExtension
+	}
+	e := &extension{
+		logf:   logger.WithPrefix(logf, featureName+": "),
+		engine: sinkSetter,
+	}
+	if c := sb.Sys().InitialConfig; c != nil && c.Parsed.NetLog != nil {
+		conf := c.Parsed.NetLog
+		if conf.Dir != "" {
+			fw, err := newFileWriter(conf)
+			if err != nil {
+				return nil, fmt.Errorf("network flow log files: %w", err)
+			}
+			e.file = fw
+		}
+		if conf.IPFIXCollector != "" {
+			x, err := ipfix.NewExporter(ipfix.Config{
+				Collector:           conf.IPFIXCollector,
+				EnterpriseNumber:    conf.IPFIXEnterpriseNumber,
+				ObservationDomainID: conf.IPFIXObservationDomainID,
+			})
+			if err != nil {
+				if e.file != nil {
+					e.file.Close()
+				}
+				return nil, fmt.Errorf("IPFIX exporter: %w", err)
+			}
+			e.ipfix = x
+		}
+	}
+	return e, nil
+}
+
+// Name implements [ipnext.Extension].
+func (e *extension) Name() string {
+	return featureName
+}
+
+// Init implements [ipnext.Extension].
+func (e *extension) Init(h ipnext.Host) error {
+	e.updateSink()
+	return nil
+}
+
+// Shutdown implements [ipnext.Extension].
+func (e *extension) Shutdown() error {
+	e.mu.Lock()
+	e.shutdown = true
+	e.mu.Unlock()
+	e.updateSink()
+	e.closeMu.Lock()
+	defer e.closeMu.Unlock()
+	e.closed = true
+	var errs []error
+	if e.file != nil {
+		errs = append(errs, e.file.Close())
+	}
+	if e.ipfix != nil {
+		errs = append(errs, e.ipfix.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// updateSink installs or removes the engine's network flow log sink,
+// depending on whether there's anywhere for records to go.
+func (e *extension) updateSink() {
+	e.installMu.Lock()
+	defer e.installMu.Unlock()
+	e.mu.Lock()
+	want := !e.shutdown && (e.file != nil || e.ipfix != nil || len(e.streams) > 0)
+	e.mu.Unlock()
+	if want == e.installed {
+		return
+	}
+	e.installed = want
+	if want {
+		e.engine.SetNetLogSink(e.write)
+	} else {
+		e.engine.SetNetLogSink(nil)
+	}
+}
+
+// write is the engine's network flow log sink.
+func (e *extension) write(msg *netlogtype.Message) {
+	e.closeMu.RLock()
+	defer e.closeMu.RUnlock()
+	if e.closed {
+		return
+	}
+	if e.ipfix != nil {
+		if err := e.ipfix.Export(msg); err != nil {
+			e.logf("IPFIX export error: %v", err)
+		}
+	}
+	line, err := jsonv2.Marshal(msg, jsontext.AllowInvalidUTF8(true))
+	if err != nil {
+		e.logf("json.Marshal error: %v", err)
+		return
+	}
+	line = append(line, '\n')
+	if e.file != nil {
+		if err := e.file.writeLine(line); err != nil {
+			e.logf("error writing file: %v", err)
+		}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, ch := range e.streams {
+		select {
+		case ch <- line:
+		default:
+			// The reader is too slow; drop the record rather than
+			// holding up the others.
+		}
+	}
+}
+
+// addStream registers ch to receive each record and returns a function
+// that unregisters it.
+func (e *extension) addStream(ch chan []byte) (remove func()) {
+	e.mu.Lock()
+	h := e.streams.Add(ch)
+	e.mu.Unlock()
+	e.updateSink()
+	return func() {
+		e.mu.Lock()
+		delete(e.streams, h)
+		e.mu.Unlock()
+		e.updateSink()
+	}
+}
+
+// serveNetLog streams network flow log records as JSON lines
+// until the request is canceled.
+func serveNetLog(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "network flow log access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+	e, ok := ipnlocal.GetExt[*extension](h.LocalBackend())
+	if !ok {
+		http.Error(w, "network flow logging not available", http.StatusNotImplemented)
+		return
+	}
+	f, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	ch := make(chan []byte, streamBuffer)
+	defer e.addStream(ch)()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	f.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case line := <-ch:
+			if _, err := w.Write(line); err != nil {
+				return
+			}
+			f.Flush()
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package localnetlog
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"tailscale.com/ipn"
+	"tailscale.com/types/netlogtype"
+)
+
+// fakeEngine is a [wgengine.NetLogSinkSetter] that, like the real engine,
+// doesn't wait for in-progress calls to the old sink when it's replaced.
+type fakeEngine struct {
+	mu   sync.Mutex
+	sink func(*netlogtype.Message)
+}
+
+func (e *fakeEngine) SetNetLogSink(fn func(*netlogtype.Message)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.sink = fn
+}
+
+func TestWriteDuringShutdown(t *testing.T) {
+	fw, err := newFileWriter(&ipn.NetLogConfig{Dir: t.TempDir(), Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logMu sync.Mutex
+	var logs []string
+	eng := new(fakeEngine)
+	e := &extension{
+		logf: func(format string, args ...any) {
+			logMu.Lock()
+			defer logMu.Unlock()
+			logs = append(logs, fmt.Sprintf(format, args...))
+		},
+		engine: eng,
+		file:   fw,
+	}
+	e.updateSink()
+	sink := eng.sink
+	if sink == nil {
+		t.Fatal("sink not installed")
+	}
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Go(func() {
+			for range 100 {
+				sink(&netlogtype.Message{})
+			}
+		})
+	}
+	if err := e.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if eng.sink != nil {
+		t.Error("sink still installed after Shutdown")
+	}
+	// A call that was already on its way into the old sink is dropped
+	// rather than written to the closed file.
+	sink(&netlogtype.Message{})
+	logMu.Lock()
+	defer logMu.Unlock()
+	if len(logs) > 0 {
+		t.Errorf("unexpected errors: %q", logs)
+	}
+}
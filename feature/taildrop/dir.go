@@ -0,0 +1,445 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package taildrop
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/envknob"
+	"tailscale.com/util/set"
+)
+
+var (
+	ErrDirsNotSupported = errors.New("directory transfers not supported by this node's Taildrop storage")
+	ErrNoSuchTransfer   = errors.New("no such directory transfer")
+	ErrChecksumMismatch = errors.New("file contents do not match manifest")
+	ErrIncompleteDir    = errors.New("directory transfer incomplete")
+	ErrInvalidManifest  = errors.New("invalid directory manifest")
+	ErrTooManyTransfers = errors.New("too many directory transfers in progress")
+)
+
+const (
+	// maxManifestFiles is the maximum number of files in a directory transfer.
+	maxManifestFiles = 100_000
+
+	// maxManifestPath is the maximum length of a path in a directory transfer.
+	maxManifestPath = 4096
+
+	// maxIncomingDirsPerSender is the maximum number of directory transfers
+	// a sender may have in progress at once.
+	maxIncomingDirsPerSender = 16
+)
+
+// dirFileOps is implemented by FileOps that can receive directory trees.
+type dirFileOps interface {
+	FileOps
+
+	// RenameTree atomically moves the files at the given paths (as returned
+	// by OpenWriter) into a new directory named dirName, keyed by their
+	// slash-separated paths relative to the new directory.
+	// If dirName already exists, a new name is picked.
+	// It returns the full path of the new directory.
+	RenameTree(dirName string, files map[string]string) (newPath string, err error)
+
+	// ListDirs returns the base names of all complete directories in the root.
+	ListDirs() ([]string, error)
+
+	// OpenTree returns the contents of the directory named name.
+	OpenTree(name string) (fs.FS, error)
+
+	// RemoveTree deletes the directory named name and all its contents.
+	RemoveTree(name string) error
+}
+
+// manifestSum returns the hex-encoded SHA-256 of the canonical JSON
+// encoding of m, ignoring m.Sum.
+func manifestSum(m *apitype.DirManifest) string {
+	m2 := *m
+	m2.Sum = ""
+	j, err := json.Marshal(m2)
+	if err != nil {
+		panic(err) // can't happen; manifests only contain strings and ints
+	}
+	sum := sha256.Sum256(j)
+	return hex.EncodeToString(sum[:])
+}
+
+// SetManifestChecksum sets m.Sum for sending m to a peer.
+//
+// The checksum is unkeyed, so it only detects manifests that were corrupted
+// or mangled in transit, not ones deliberately modified. The sender is
+// authenticated by the PeerAPI connection the manifest arrives on.
+func SetManifestChecksum(m *apitype.DirManifest) {
+	m.Sum = manifestSum(m)
+}
+
+// validateManifest reports whether m describes a directory tree that's
+// safe to create. If checkSum, it also verifies m.Sum.
+func validateManifest(m *apitype.DirManifest, checkSum bool) error {
+	if err := validateBaseName(m.Name); err != nil {
+		return fmt.Errorf("%w: directory name: %w", ErrInvalidManifest, err)
+	}
+	if isSenderDir(m.Name) {
+		return fmt.Errorf("%w: directory name %q uses reserved prefix %q", ErrInvalidManifest, m.Name, senderDirPrefix)
+	}
+	if len(m.Files) > maxManifestFiles {
+		return fmt.Errorf("%w: too many files (%d > %d)", ErrInvalidManifest, len(m.Files), maxManifestFiles)
+	}
+	var files, dirs set.Set[string]
+	files.Make()
+	dirs.Make()
+	for _, f := range m.Files {
+		if len(f.Path) > maxManifestPath || f.Path == "" || path.Clean(f.Path) != f.Path {
+			return fmt.Errorf("%w: invalid path %q", ErrInvalidManifest, f.Path)
+		}
+		for seg := range strings.SplitSeq(f.Path, "/") {
+			if validateBaseName(seg) != nil {
+				return fmt.Errorf("%w: invalid path %q", ErrInvalidManifest, f.Path)
+			}
+		}
+		if f.Size < 0 {
+			return fmt.Errorf("%w: invalid size for %q", ErrInvalidManifest, f.Path)
+		}
+		if b, err := hex.DecodeString(f.SHA256); err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("%w: invalid SHA-256 for %q", ErrInvalidManifest, f.Path)
+		}
+		if files.Contains(f.Path) || dirs.Contains(f.Path) {
+			return fmt.Errorf("%w: duplicate path %q", ErrInvalidManifest, f.Path)
+		}
+		files.Add(f.Path)
+		for d := path.Dir(f.Path); d != "."; d = path.Dir(d) {
+			if files.Contains(d) {
+				return fmt.Errorf("%w: %q is both a file and a directory", ErrInvalidManifest, d)
+			}
+			dirs.Add(d)
+		}
+	}
+	if checkSum && m.Sum != manifestSum(m) {
+		return fmt.Errorf("%w: checksum mismatch", ErrInvalidManifest)
+	}
+	return nil
+}
+
+// validateTransferID reports whether tid is a valid directory transfer ID.
+// It's used as part of file names so it must be short and plain.
+func validateTransferID(tid string) error {
+	if len(tid) < 16 || len(tid) > 64 {
+		return ErrNoSuchTransfer
+	}
+	if _, err := hex.DecodeString(tid); err != nil {
+		return ErrNoSuchTransfer
+	}
+	return nil
+}
+
+type incomingDirKey struct {
+	id         clientID
+	transferID string
+}
+
+// incomingDir is a directory transfer in progress.
+type incomingDir struct {
+	manifest apitype.DirManifest
+	index    map[string]int // manifest.Files index by path
+	started  time.Time
+
+	mu     sync.Mutex
+	staged map[int]string // manifest.Files index to partial path, once verified
+}
+
+// stagedName returns the name of the partial file for manifest.Files[i].
+func (k incomingDirKey) stagedName(i int) string {
+	return fmt.Sprintf("%s-%d%s", k.transferID, i, k.id.partialSuffix())
+}
+
+// dirOps returns m's FileOps if it supports directory transfers.
+func (m *manager) dirOps() (dirFileOps, error) {
+	switch {
+	case m == nil || m.opts.fileOps == nil:
+		return nil, ErrNoTaildrop
+	case !envknob.CanTaildrop():
+		return nil, ErrNoTaildrop
+	}
+	dops, ok := m.opts.fileOps.(dirFileOps)
+	if !ok {
+		return nil, ErrDirsNotSupported
+	}
+	return dops, nil
+}
+
+// PutDirManifest starts a directory transfer with the given transfer ID
+// from the given client id. The manifest's checksum must be set with
+// [SetManifestChecksum].
+// Calling it again with an identical manifest is a no-op.
+func (m *manager) PutDirManifest(id clientID, transferID string, manifest *apitype.DirManifest) error {
+	return m.PutDirManifestFrom(sender{id: id}, transferID, manifest)
+}
+
+// PutDirManifestFrom is like [manager.PutDirManifest] but takes a full
+// description of the sender, which is checked along with every file in
+// the manifest against the configured [ReceivePolicy].
+func (m *manager) PutDirManifestFrom(s sender, transferID string, manifest *apitype.DirManifest) error {
+	id := s.id
+	if _, err := m.dirOps(); err != nil {
+		return err
+	}
+	if err := validateTransferID(transferID); err != nil {
+		return err
+	}
+	if err := validateManifest(manifest, true); err != nil {
+		return err
+	}
+	if policy := m.opts.Policy; policy != nil {
+		if err := policy.checkSender(s); err != nil {
+			return err
+		}
+		var total int64
+		for _, f := range manifest.Files {
+			if err := policy.checkFile(path.Base(f.Path), f.Size); err != nil {
+				return fmt.Errorf("%q: %w", f.Path, err)
+			}
+			total += f.Size
+		}
+		if err := m.quota.check(policy, id, m.opts.Clock.Now(), total); err != nil {
+			return err
+		}
+	}
+	m.expireIncomingDirs()
+
+	dir := &incomingDir{
+		manifest: *manifest,
+		index:    make(map[string]int, len(manifest.Files)),
+		started:  m.opts.Clock.Now(),
+		staged:   make(map[int]string),
+	}
+	for i, f := range manifest.Files {
+		dir.index[f.Path] = i
+	}
+	key := incomingDirKey{id, transferID}
+	m.incomingDirsMu.Lock()
+	defer m.incomingDirsMu.Unlock()
+	if got, ok := m.incomingDirs.Load(key); ok {
+		if got.manifest.Sum != manifest.Sum {
+			return ErrFileExists
+		}
+		return nil
+	}
+	var n int
+	for k := range m.incomingDirs.Keys() {
+		if k.id == id {
+			n++
+		}
+	}
+	if n >= maxIncomingDirsPerSender {
+		return ErrTooManyTransfers
+	}
+	m.incomingDirs.Store(key, dir)
+	return nil
+}
+
+// expireIncomingDirs forgets about directory transfers that were started more
+// than deleteDelay ago, and schedules their partial files for deletion.
+func (m *manager) expireIncomingDirs() {
+	now := m.opts.Clock.Now()
+	for k, dir := range m.incomingDirs.All() {
+		if now.Sub(dir.started) < deleteDelay {
+			continue
+		}
+		m.incomingDirs.Delete(k)
+		dir.mu.Lock()
+		for i := range dir.staged {
+			m.deleter.Insert(k.stagedName(i))
+		}
+		dir.mu.Unlock()
+	}
+}
+
+// PutDirFile receives the file at the slash-separated relPath of the
+// directory transfer transferID, verifying it against the manifest.
+// The length is the expected length of content to read from r,
+// it may be negative to indicate that it is unknown.
+func (m *manager) PutDirFile(id clientID, transferID, relPath string, r io.Reader, length int64) (err error) {
+	if _, err := m.dirOps(); err != nil {
+		return err
+	}
+	key := incomingDirKey{id, transferID}
+	dir, ok := m.incomingDirs.Load(key)
+	if !ok {
+		return ErrNoSuchTransfer
+	}
+	i, ok := dir.index[relPath]
+	if !ok {
+		return ErrInvalidFileName
+	}
+	want := dir.manifest.Files[i]
+	if length >= 0 && length != want.Size {
+		return fmt.Errorf("%w: got %d bytes for %q; want %d", ErrChecksumMismatch, length, relPath, want.Size)
+	}
+
+	// Claim the file before touching its staged copy, which another
+	// upload of the same file may still be writing.
+	inFileKey := incomingFileKey{id, dir.manifest.Name + "/" + relPath}
+	inFile, loaded := m.incomingFiles.LoadOrInit(inFileKey, func() *incomingFile {
+		return &incomingFile{
+			clock:          m.opts.Clock,
+			started:        m.opts.Clock.Now(),
+			size:           want.Size,
+			sendFileNotify: m.opts.SendFileNotify,
+		}
+	})
+	if loaded {
+		return ErrFileExists
+	}
+	defer m.incomingFiles.Delete(inFileKey)
+
+	dir.mu.Lock()
+	delete(dir.staged, i) // until this attempt is verified
+	dir.mu.Unlock()
+	stagedName := key.stagedName(i)
+	m.deleter.Remove(stagedName)
+	// OpenWriter doesn't truncate at offset 0, so drop any earlier attempt.
+	if err := m.opts.fileOps.Remove(stagedName); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return m.redactAndLogError("Remove", err)
+	}
+	wc, stagedPath, err := m.opts.fileOps.OpenWriter(stagedName, 0, 0o666)
+	if err != nil {
+		return m.redactAndLogError("Create", err)
+	}
+	defer func() {
+		wc.Close()
+		if err != nil {
+			m.deleter.Insert(stagedName) // mark partial file for eventual deletion
+		}
+	}()
+	inFile.w = wc
+
+	var dst io.Writer = inFile
+	if m.opts.Policy != nil {
+		dst = m.newPolicyWriter(inFile, id, 0)
+	}
+	h := sha256.New()
+	n, err := io.Copy(dst, io.TeeReader(io.LimitReader(r, want.Size+1), h))
+	if err != nil {
+		return m.redactAndLogError("Copy", err)
+	}
+	if n != want.Size || hex.EncodeToString(h.Sum(nil)) != want.SHA256 {
+		return fmt.Errorf("%w: %q", ErrChecksumMismatch, relPath)
+	}
+	if err := wc.Close(); err != nil {
+		return m.redactAndLogError("Close", err)
+	}
+
+	inFile.mu.Lock()
+	inFile.done = true
+	inFile.mu.Unlock()
+
+	dir.mu.Lock()
+	dir.staged[i] = stagedPath
+	dir.mu.Unlock()
+	m.opts.SendFileNotify()
+	return nil
+}
+
+// CommitDir completes the directory transfer transferID once all its files
+// have been received and verified, moving them into place at once.
+// It returns the full path of the new directory.
+func (m *manager) CommitDir(id clientID, transferID string) (finalPath string, err error) {
+	dops, err := m.dirOps()
+	if err != nil {
+		return "", err
+	}
+	key := incomingDirKey{id, transferID}
+	dir, ok := m.incomingDirs.Load(key)
+	if !ok {
+		return "", ErrNoSuchTransfer
+	}
+
+	dir.mu.Lock()
+	defer dir.mu.Unlock()
+	if got, want := len(dir.staged), len(dir.manifest.Files); got != want {
+		return "", fmt.Errorf("%w: received %d of %d files", ErrIncompleteDir, got, want)
+	}
+	files := make(map[string]string, len(dir.staged))
+	for i, stagedPath := range dir.staged {
+		files[dir.manifest.Files[i].Path] = stagedPath
+	}
+	m.incomingDirs.Delete(key)
+
+	finalPath, err = dops.RenameTree(dir.manifest.Name, files)
+	if err != nil {
+		for i := range dir.staged {
+			m.deleter.Insert(key.stagedName(i))
+		}
+		return "", m.redactAndLogError("RenameTree", err)
+	}
+	m.totalReceived.Add(1)
+	m.opts.SendFileNotify()
+	return finalPath, nil
+}
+
+// OpenDir returns the contents of the received directory of the given
+// baseName from [Handler.Dir].
+// This method is only allowed when [Handler.DirectFileMode] is false.
+func (m *manager) OpenDir(baseName string) (fs.FS, error) {
+	if m == nil || m.opts.fileOps == nil {
+		return nil, ErrNoTaildrop
+	}
+	if m.opts.DirectFileMode {
+		return nil, errors.New("opens not allowed in direct mode")
+	}
+	dops, ok := m.opts.fileOps.(dirFileOps)
+	if !ok {
+		return nil, ErrDirsNotSupported
+	}
+	fsys, err := dops.OpenTree(baseName)
+	if err != nil {
+		return nil, redactError(err)
+	}
+	return fsys, nil
+}
+
+// waitingDirs returns the received directories waiting in [Handler.Dir].
+// Per-sender subdirectories holding individual files are not included.
+func (m *manager) waitingDirs() ([]apitype.WaitingFile, error) {
+	dops, ok := m.opts.fileOps.(dirFileOps)
+	if !ok {
+		return nil, nil
+	}
+	names, err := dops.ListDirs()
+	if err != nil {
+		return nil, redactError(err)
+	}
+	var ret []apitype.WaitingFile
+	for _, name := range names {
+		if isPartialOrDeleted(name) || isSenderDir(name) {
+			continue
+		}
+		fsys, err := dops.OpenTree(name)
+		if err != nil {
+			continue
+		}
+		var size int64
+		fs.WalkDir(fsys, ".", func(_ string, d fs.DirEntry, err error) error {
+			if err == nil && d.Type().IsRegular() {
+				if fi, err := d.Info(); err == nil {
+					size += fi.Size()
+				}
+			}
+			return nil
+		})
+		ret = append(ret, apitype.WaitingFile{Name: name, Size: size, IsDir: true})
+	}
+	return ret, nil
+}
@@ -0,0 +1,314 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package taildrop
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tstest"
+	"tailscale.com/util/must"
+)
+
+const testTransferID = "0123456789abcdef0123456789abcdef"
+
+func testManifest(name string, files map[string]string) *apitype.DirManifest {
+	m := &apitype.DirManifest{Name: name}
+	for p, content := range files {
+		sum := sha256.Sum256([]byte(content))
+		m.Files = append(m.Files, apitype.DirManifestFile{
+			Path:   p,
+			Size:   int64(len(content)),
+			SHA256: hex.EncodeToString(sum[:]),
+		})
+	}
+	SetManifestChecksum(m)
+	return m
+}
+
+func TestValidateManifest(t *testing.T) {
+	sum := strings.Repeat("00", sha256.Size)
+	tests := []struct {
+		name   string
+		files  []apitype.DirManifestFile
+		wantOk bool
+	}{
+		{"ok", []apitype.DirManifestFile{{Path: "a", Size: 1, SHA256: sum}, {Path: "b/c", Size: 2, SHA256: sum}}, true},
+		{"empty_dir", nil, true},
+		{"absolute", []apitype.DirManifestFile{{Path: "/a", Size: 1, SHA256: sum}}, false},
+		{"dotdot", []apitype.DirManifestFile{{Path: "a/../../b", Size: 1, SHA256: sum}}, false},
+		{"unclean", []apitype.DirManifestFile{{Path: "a//b", Size: 1, SHA256: sum}}, false},
+		{"partial_suffix", []apitype.DirManifestFile{{Path: "a/b.partial", Size: 1, SHA256: sum}}, false},
+		{"backslash", []apitype.DirManifestFile{{Path: `a\b`, Size: 1, SHA256: sum}}, false},
+		{"duplicate", []apitype.DirManifestFile{{Path: "a", Size: 1, SHA256: sum}, {Path: "a", Size: 1, SHA256: sum}}, false},
+		{"file_and_dir", []apitype.DirManifestFile{{Path: "a", Size: 1, SHA256: sum}, {Path: "a/b", Size: 1, SHA256: sum}}, false},
+		{"dir_and_file", []apitype.DirManifestFile{{Path: "a/b", Size: 1, SHA256: sum}, {Path: "a", Size: 1, SHA256: sum}}, false},
+		{"negative_size", []apitype.DirManifestFile{{Path: "a", Size: -1, SHA256: sum}}, false},
+		{"bad_sum", []apitype.DirManifestFile{{Path: "a", Size: 1, SHA256: "abc"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &apitype.DirManifest{Name: "dir", Files: tt.files}
+			SetManifestChecksum(m)
+			err := validateManifest(m, true)
+			if gotOk := err == nil; gotOk != tt.wantOk {
+				t.Errorf("validateManifest = %v; wantOk %v", err, tt.wantOk)
+			}
+		})
+	}
+
+	m := testManifest("dir", map[string]string{"a": "x"})
+	m.Files[0].Size = 2
+	if err := validateManifest(m, true); !errors.Is(err, ErrInvalidManifest) {
+		t.Errorf("tampered manifest: got %v; want ErrInvalidManifest", err)
+	}
+}
+
+func TestDirTransfer(t *testing.T) {
+	dir := t.TempDir()
+	m := managerOptions{Logf: t.Logf, fileOps: must.Get(newFileOps(dir))}.New()
+	defer m.Shutdown()
+
+	files := map[string]string{
+		"README":          "hello",
+		"bin/tool":        "binary contents",
+		"bin/sub/empty":   "",
+		"lib/a (1).txt":   "a",
+		"lib/deep/b/c/d":  "d",
+		"lib/unicode-😋.x": "emoji",
+	}
+	manifest := testManifest("build", files)
+	must.Do(m.PutDirManifest("id", testTransferID, manifest))
+	must.Do(m.PutDirManifest("id", testTransferID, manifest)) // idempotent
+
+	var first bool
+	for p, content := range files {
+		if !first {
+			first = true
+			if err := m.PutDirFile("id", testTransferID, p, strings.NewReader(content+"junk"), -1); !errors.Is(err, ErrChecksumMismatch) {
+				t.Errorf("PutDirFile with bad contents = %v; want ErrChecksumMismatch", err)
+			}
+			if _, err := m.CommitDir("id", testTransferID); !errors.Is(err, ErrIncompleteDir) {
+				t.Errorf("CommitDir before all files = %v; want ErrIncompleteDir", err)
+			}
+		}
+		must.Do(m.PutDirFile("id", testTransferID, p, strings.NewReader(content), int64(len(content))))
+	}
+	if err := m.PutDirFile("id", testTransferID, "not/in/manifest", strings.NewReader(""), 0); !errors.Is(err, ErrInvalidFileName) {
+		t.Errorf("PutDirFile of unknown path = %v; want ErrInvalidFileName", err)
+	}
+
+	// Nothing is visible until the transfer is committed.
+	if _, err := os.Stat(filepath.Join(dir, "build")); !os.IsNotExist(err) {
+		t.Fatalf("directory visible before commit: %v", err)
+	}
+	finalPath := must.Get(m.CommitDir("id", testTransferID))
+	if finalPath != filepath.Join(dir, "build") {
+		t.Errorf("CommitDir = %q; want %q", finalPath, filepath.Join(dir, "build"))
+	}
+	for p, want := range files {
+		got, err := os.ReadFile(filepath.Join(finalPath, filepath.FromSlash(p)))
+		if err != nil || string(got) != want {
+			t.Errorf("%s = %q, %v; want %q", p, got, err, want)
+		}
+	}
+	if _, err := m.CommitDir("id", testTransferID); !errors.Is(err, ErrNoSuchTransfer) {
+		t.Errorf("second CommitDir = %v; want ErrNoSuchTransfer", err)
+	}
+
+	// A second transfer with the same name gets a new name.
+	manifest2 := testManifest("build", map[string]string{"x": "y"})
+	tid2 := strings.Repeat("ab", 16)
+	must.Do(m.PutDirManifest("id", tid2, manifest2))
+	must.Do(m.PutDirFile("id", tid2, "x", strings.NewReader("y"), 1))
+	if got, want := must.Get(m.CommitDir("id", tid2)), filepath.Join(dir, "build (1)"); got != want {
+		t.Errorf("CommitDir = %q; want %q", got, want)
+	}
+
+	wfs := must.Get(m.WaitingFiles())
+	if len(wfs) != 2 || !wfs[0].IsDir || wfs[0].Name != "build" || wfs[0].Size != 27 {
+		t.Errorf("WaitingFiles = %+v", wfs)
+	}
+	fsys := must.Get(m.OpenDir("build"))
+	if got := string(must.Get(fs.ReadFile(fsys, "lib/deep/b/c/d"))); got != "d" {
+		t.Errorf("OpenDir contents = %q; want %q", got, "d")
+	}
+	must.Do(m.DeleteFile("build"))
+	must.Do(m.DeleteFile("build (1)"))
+	if wfs := must.Get(m.WaitingFiles()); len(wfs) != 0 {
+		t.Errorf("WaitingFiles after delete = %+v", wfs)
+	}
+}
+
+func TestDirTransferConcurrentPut(t *testing.T) {
+	dir := t.TempDir()
+	m := managerOptions{Logf: t.Logf, fileOps: must.Get(newFileOps(dir))}.New()
+	defer m.Shutdown()
+
+	must.Do(m.PutDirManifest("id", testTransferID, testManifest("build", map[string]string{"a": "hello"})))
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- m.PutDirFile("id", testTransferID, "a", pr, 5)
+	}()
+	must.Get(pw.Write([]byte("hel")))
+
+	// A second upload of the same file doesn't disturb the first.
+	if err := m.PutDirFile("id", testTransferID, "a", strings.NewReader("hello"), 5); !errors.Is(err, ErrFileExists) {
+		t.Errorf("concurrent PutDirFile = %v; want ErrFileExists", err)
+	}
+	must.Get(pw.Write([]byte("lo")))
+	pw.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("first PutDirFile: %v", err)
+	}
+	finalPath := must.Get(m.CommitDir("id", testTransferID))
+	if got := string(must.Get(os.ReadFile(filepath.Join(finalPath, "a")))); got != "hello" {
+		t.Errorf("contents = %q; want %q", got, "hello")
+	}
+}
+
+func TestDirTransferLimit(t *testing.T) {
+	m := managerOptions{Logf: t.Logf, fileOps: must.Get(newFileOps(t.TempDir()))}.New()
+	defer m.Shutdown()
+
+	manifest := testManifest("build", map[string]string{"a": "x"})
+	tid := func(i int) string { return fmt.Sprintf("%032x", i) }
+	for i := range maxIncomingDirsPerSender {
+		must.Do(m.PutDirManifest("id", tid(i), manifest))
+	}
+	if err := m.PutDirManifest("id", tid(maxIncomingDirsPerSender), manifest); !errors.Is(err, ErrTooManyTransfers) {
+		t.Errorf("PutDirManifest over limit = %v; want ErrTooManyTransfers", err)
+	}
+	// Repeating a manifest that's already in progress is still fine, as
+	// are transfers from other senders.
+	must.Do(m.PutDirManifest("id", tid(0), manifest))
+	must.Do(m.PutDirManifest("other", tid(0), manifest))
+
+	// Finishing a transfer makes room for another.
+	must.Do(m.PutDirFile("id", tid(0), "a", strings.NewReader("x"), 1))
+	must.Get(m.CommitDir("id", tid(0)))
+	must.Do(m.PutDirManifest("id", tid(maxIncomingDirsPerSender), manifest))
+}
+
+func TestHandlePeerPutDir(t *testing.T) {
+	dir := t.TempDir()
+	m := managerOptions{Logf: t.Logf, fileOps: must.Get(newFileOps(dir))}.New()
+	defer m.Shutdown()
+	ext := &fakeExtension{
+		logf:           t.Logf,
+		capFileSharing: true,
+		clock:          &tstest.Clock{},
+		taildrop:       m,
+	}
+	ph := &peerAPIHandler{
+		isSelf:   true,
+		selfNode: (&tailcfg.Node{Addresses: []netip.Prefix{netip.MustParsePrefix("100.100.100.101/32")}}).View(),
+		peerNode: (&tailcfg.Node{ComputedName: "some-peer-name"}).View(),
+	}
+	do := func(method, path, body string) int {
+		rr := httptest.NewRecorder()
+		handlePeerPutDirWithBackend(ph, ext, rr, httptest.NewRequest(method, "/v0/put-dir/"+path, strings.NewReader(body)))
+		return rr.Code
+	}
+
+	manifest := testManifest("out", map[string]string{"a/b c.txt": "hello"})
+	mj := string(must.Get(json.Marshal(manifest)))
+	if code := do("PUT", "bogus", mj); code != 404 {
+		t.Errorf("PUT manifest with bad transfer ID = %d; want 404", code)
+	}
+	if code := do("PUT", testTransferID, mj); code != 200 {
+		t.Fatalf("PUT manifest = %d; want 200", code)
+	}
+	if code := do("POST", testTransferID, ""); code != 409 {
+		t.Errorf("early commit = %d; want 409", code)
+	}
+	if code := do("PUT", testTransferID+"/"+url.PathEscape("a/b c.txt"), "HELLO"); code != 400 {
+		t.Errorf("PUT corrupt file = %d; want 400", code)
+	}
+	if code := do("PUT", testTransferID+"/"+url.PathEscape("a/b c.txt"), "hello"); code != 200 {
+		t.Errorf("PUT file = %d; want 200", code)
+	}
+	if code := do("POST", testTransferID, ""); code != 200 {
+		t.Errorf("commit = %d; want 200", code)
+	}
+	if got := must.Get(os.ReadFile(filepath.Join(dir, "out", "a", "b c.txt"))); string(got) != "hello" {
+		t.Errorf("received contents = %q", got)
+	}
+}
+
+func TestPerSenderDirsWithDirTransfer(t *testing.T) {
+	dir := t.TempDir()
+	m := managerOptions{Logf: t.Logf, fileOps: must.Get(newFileOps(dir)), Policy: &ReceivePolicy{PerSenderDirs: true}, DirectFileMode: true}.New()
+	defer m.Shutdown()
+	ext := &fakeExtension{
+		logf:           t.Logf,
+		capFileSharing: true,
+		clock:          &tstest.Clock{},
+		taildrop:       m,
+	}
+	ph := &peerAPIHandler{
+		isSelf:   true,
+		selfNode: (&tailcfg.Node{Addresses: []netip.Prefix{netip.MustParsePrefix("100.100.100.101/32")}}).View(),
+		peerNode: (&tailcfg.Node{StableID: "nLAPTOP", Name: "laptop.example.ts.net."}).View(),
+	}
+	putFile := func(name, body string) int {
+		rr := httptest.NewRecorder()
+		handlePeerPutWithBackend(ph, ext, rr, httptest.NewRequest("PUT", "/v0/put/"+name, strings.NewReader(body)))
+		return rr.Code
+	}
+	putDir := func(method, path, body string) int {
+		rr := httptest.NewRecorder()
+		handlePeerPutDirWithBackend(ph, ext, rr, httptest.NewRequest(method, "/v0/put-dir/"+path, strings.NewReader(body)))
+		return rr.Code
+	}
+
+	if code := putFile("a.txt", "hello"); code != 200 {
+		t.Fatalf("PUT file = %d; want 200", code)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "taildrop-from-laptop", "a.txt")); err != nil {
+		t.Fatalf("per-sender file: %v", err)
+	}
+
+	// A directory transfer may not use the per-sender prefix.
+	bad := testManifest("taildrop-from-laptop", map[string]string{"x": "y"})
+	if code := putDir("PUT", testTransferID, string(must.Get(json.Marshal(bad)))); code != 400 {
+		t.Errorf("PUT manifest with reserved name = %d; want 400", code)
+	}
+
+	// A directory transfer named after the sender doesn't collide
+	// with its per-sender directory.
+	good := testManifest("laptop", map[string]string{"x": "y"})
+	if code := putDir("PUT", testTransferID, string(must.Get(json.Marshal(good)))); code != 200 {
+		t.Fatalf("PUT manifest = %d; want 200", code)
+	}
+	if code := putDir("PUT", testTransferID+"/x", "y"); code != 200 {
+		t.Fatalf("PUT dir file = %d; want 200", code)
+	}
+	if code := putDir("POST", testTransferID, ""); code != 200 {
+		t.Fatalf("commit = %d; want 200", code)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "laptop", "x")); err != nil {
+		t.Errorf("received directory: %v", err)
+	}
+
+	wfs := must.Get(m.waitingDirs())
+	if len(wfs) != 1 || wfs[0].Name != "laptop" || !wfs[0].IsDir {
+		t.Errorf("waitingDirs = %+v; want only the received directory", wfs)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"maps"
 	"path/filepath"
 	"runtime"
@@ -48,6 +49,14 @@ func newExtension(logf logger.Logf, b ipnext.SafeBackend) (ipnext.Extension, err
 		logf:       logger.WithPrefix(logf, "taildrop: "),
 	}
 	e.setPlatformDefaultDirectFileRoot()
+	if conf := b.Sys().InitialConfig; conf != nil {
+		if v := conf.Parsed.TaildropStorage; v != nil && *v != "" {
+			e.storage = *v
+		}
+		if v := conf.Parsed.TaildropPolicy; v != nil && *v != "" {
+			e.policyFile = *v
+		}
+	}
 	return e, nil
 }
 
@@ -72,6 +81,15 @@ type Extension struct {
 	// *.partial file to its final name on completion.
 	directFileRoot string
 
+	// storage, if non-empty, is the location from the tailscaled config file
+	// where received files are written directly. It is passed to [NewFileOps]
+	// and takes precedence over directFileRoot.
+	storage string
+
+	// policyFile, if non-empty, is the path from the tailscaled config file
+	// of the [ReceivePolicy] to enforce. It's re-read on profile changes.
+	policyFile string
+
 	// FileOps abstracts platform-specific file operations needed for file transfers.
 	// This is currently being used for Android to use the Storage Access Framework.
 	fileOps FileOps
@@ -158,6 +176,15 @@ func (e *Extension) onChangeProfile(profile ipn.LoginProfileView, _ ipn.PrefsVie
 	// A non-nil [FileOps] also implies that we are in DirectFileMode.
 	fops := e.fileOps
 	isDirectFileMode := fops != nil
+	if fops == nil && e.storage != "" {
+		var err error
+		if fops, err = NewFileOps(e.logf, e.storage, e.stagingRoot(uid, activeLogin)); err != nil {
+			e.logf("taildrop: cannot create FileOps for configured storage: %v", err)
+			e.setMgrLocked(nil)
+			return
+		}
+		isDirectFileMode = true
+	}
 	if fops == nil {
 		var fileRoot string
 		if fileRoot, isDirectFileMode = e.fileRoot(uid, activeLogin); fileRoot == "" {
@@ -174,12 +201,24 @@ func (e *Extension) onChangeProfile(profile ipn.LoginProfileView, _ ipn.PrefsVie
 		}
 	}
 
+	var policy *ReceivePolicy
+	if e.policyFile != "" {
+		var err error
+		if policy, err = LoadReceivePolicy(e.policyFile); err != nil {
+			// Fail closed rather than accept files the policy would reject.
+			e.logf("taildrop: cannot load receive policy: %v", err)
+			e.setMgrLocked(nil)
+			return
+		}
+	}
+
 	e.setMgrLocked(managerOptions{
 		Logf:           e.logf,
 		Clock:          tstime.DefaultClock{Clock: e.sb.Clock()},
 		State:          e.stateStore,
 		DirectFileMode: isDirectFileMode,
 		fileOps:        fops,
+		Policy:         policy,
 		SendFileNotify: e.sendFileNotify,
 	}.New())
 }
@@ -194,21 +233,27 @@ func (e *Extension) fileRoot(uid tailcfg.UserID, activeLogin string) (root strin
 	if v := e.directFileRoot; v != "" {
 		return v, true
 	}
+	return e.stagingRoot(uid, activeLogin), false
+}
+
+// stagingRoot returns the daemon-owned directory where Taildrop files for the
+// given user are staged, or the empty string if there is no state directory.
+func (e *Extension) stagingRoot(uid tailcfg.UserID, activeLogin string) string {
 	varRoot := e.sb.TailscaleVarRoot()
 	if varRoot == "" {
 		e.logf("Taildrop disabled; no state directory")
-		return "", false
+		return ""
 	}
 
 	if activeLogin == "" {
 		e.logf("taildrop: no active login; can't select a target directory")
-		return "", false
+		return ""
 	}
 
 	baseDir := fmt.Sprintf("%s-uid-%d",
 		strings.ReplaceAll(activeLogin, "@", "-"),
 		uid)
-	return filepath.Join(varRoot, "files", baseDir), false
+	return filepath.Join(varRoot, "files", baseDir)
 }
 
 // hasCapFileSharing reports whether the current node has the file sharing
@@ -324,6 +369,12 @@ func (e *Extension) OpenFile(name string) (rc io.ReadCloser, size int64, err err
 	return e.manager().OpenFile(name)
 }
 
+// OpenDir returns the contents of a received directory waiting to be
+// picked up.
+func (e *Extension) OpenDir(name string) (fs.FS, error) {
+	return e.manager().OpenDir(name)
+}
+
 func (e *Extension) nodeBackend() ipnext.NodeBackend {
 	if e.nodeBackendForTest != nil {
 		return e.nodeBackendForTest
@@ -331,6 +382,18 @@ func (e *Extension) nodeBackend() ipnext.NodeBackend {
 	return e.host.NodeBackend()
 }
 
+// peerLoginName returns the login name of peer's owner,
+// or the empty string if unknown or peer is tagged.
+func (e *Extension) peerLoginName(peer tailcfg.NodeView) string {
+	if !peer.Valid() || peer.IsTagged() {
+		return ""
+	}
+	if u, ok := e.nodeBackend().UserByID(peer.User()); ok {
+		return u.LoginName()
+	}
+	return ""
+}
+
 // FileTargets lists nodes that the current node can send files to.
 func (e *Extension) FileTargets() ([]*apitype.FileTarget, error) {
 	var ret []*apitype.FileTarget
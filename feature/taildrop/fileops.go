@@ -4,9 +4,15 @@
 package taildrop
 
 import (
+	"fmt"
 	"io"
 	"io/fs"
 	"os"
+	"runtime"
+	"strings"
+
+	"tailscale.com/types/logger"
+	"tailscale.com/util/mak"
 )
 
 // FileOps abstracts over both local‐FS paths and Android SAF URIs.
@@ -39,3 +45,56 @@ type FileOps interface {
 }
 
 var newFileOps func(dir string) (FileOps, error)
+
+// FileOpsProvider returns a FileOps for the provided storage location.
+// The location is of the form "scheme://rest", where scheme was previously
+// registered with RegisterFileOps; the scheme is not stripped.
+//
+// stagingDir, if non-empty, is a private local directory that the provider
+// may use to hold partial files while they are being received, so that
+// interrupted transfers can be resumed before the file is handed off to
+// the final storage.
+type FileOpsProvider func(logf logger.Logf, location, stagingDir string) (FileOps, error)
+
+var knownFileOps map[string]FileOpsProvider
+
+// RegisterFileOps registers a provider for Taildrop storage locations
+// of the form "scheme://...". It panics if the scheme is empty, or if the
+// scheme is already registered.
+func RegisterFileOps(scheme string, fn FileOpsProvider) {
+	if len(scheme) == 0 {
+		panic("scheme is empty")
+	}
+	if _, ok := knownFileOps[scheme]; ok {
+		panic(fmt.Sprintf("%q already registered", scheme))
+	}
+	mak.Set(&knownFileOps, scheme, fn)
+}
+
+// NewFileOps returns a FileOps for the provided storage location.
+//
+// If location is of the form "scheme://rest" and scheme was registered with
+// RegisterFileOps, the registered provider is used. By default the following
+// schemes are registered on platforms with a local filesystem:
+//
+//   - "file": the path of the URL is a local directory.
+//   - "s3": an S3-compatible object store; see the s3 provider for the
+//     supported URL parameters.
+//
+// In all other cases, location is treated as a local directory path.
+func NewFileOps(logf logger.Logf, location, stagingDir string) (FileOps, error) {
+	if logf == nil {
+		logf = logger.Discard
+	}
+	if scheme, _, ok := strings.Cut(location, "://"); ok {
+		fn, ok := knownFileOps[scheme]
+		if !ok {
+			return nil, fmt.Errorf("unknown Taildrop storage scheme %q", scheme)
+		}
+		return fn(logf, location, stagingDir)
+	}
+	if newFileOps == nil {
+		return nil, fmt.Errorf("local Taildrop storage not supported on %s", runtime.GOOS)
+	}
+	return newFileOps(location)
+}
@@ -11,12 +11,15 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
 	"sync"
 	"unicode/utf8"
+
+	"tailscale.com/types/logger"
 )
 
 var renameMu sync.Mutex
@@ -35,6 +38,16 @@ func init() {
 		}
 		return fsFileOps{rootDir: dir}, nil
 	}
+	RegisterFileOps("file", func(_ logger.Logf, location, _ string) (FileOps, error) {
+		u, err := url.Parse(location)
+		if err != nil {
+			return nil, err
+		}
+		if u.Host != "" && u.Host != "localhost" {
+			return nil, fmt.Errorf("file URL %q must not have a remote host", location)
+		}
+		return newFileOps(filepath.FromSlash(u.Path))
+	})
 }
 
 func (f fsFileOps) OpenWriter(name string, offset int64, perm os.FileMode) (io.WriteCloser, string, error) {
@@ -151,6 +164,15 @@ func (f fsFileOps) Rename(oldPath, newName string) (newPath string, err error) {
 	return "", fmt.Errorf("too many retries trying to rename %q to %q", oldPath, newName)
 }
 
+// RenameInto is like Rename but moves the file into the subdirectory dir,
+// which must be a base name.
+func (f fsFileOps) RenameInto(oldPath, dir, newName string) (newPath string, err error) {
+	if err := validateBaseName(dir); err != nil {
+		return "", err
+	}
+	return fsFileOps{rootDir: filepath.Join(f.rootDir, dir)}.Rename(oldPath, newName)
+}
+
 // sha256File computes the SHA‑256 of a file.
 func sha256File(path string) (sum [sha256.Size]byte, _ error) {
 	f, err := os.Open(path)
@@ -180,6 +202,97 @@ func (f fsFileOps) ListFiles() ([]string, error) {
 	return names, nil
 }
 
+func (f fsFileOps) ListDirs() ([]string, error) {
+	entries, err := os.ReadDir(f.rootDir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+// RenameTree moves the partial files into a new directory named dirName.
+// The tree is first assembled in a hidden partial directory so that it
+// only appears under its final name once complete.
+func (f fsFileOps) RenameTree(dirName string, files map[string]string) (newPath string, err error) {
+	if err := validateBaseName(dirName); err != nil {
+		return "", err
+	}
+	tmp, err := os.MkdirTemp(f.rootDir, dirName+".*"+partialSuffix)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(tmp)
+		}
+	}()
+	for rel, oldPath := range files {
+		if !filepath.IsLocal(filepath.FromSlash(rel)) {
+			return "", ErrInvalidFileName
+		}
+		dst := filepath.Join(tmp, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(dst), 0o700); err != nil {
+			return "", err
+		}
+		if err := os.Rename(oldPath, dst); err != nil {
+			return "", err
+		}
+	}
+
+	dst := filepath.Join(f.rootDir, dirName)
+	const maxRetries = 10
+	for range maxRetries {
+		renameMu.Lock()
+		_, statErr := os.Lstat(dst)
+		if os.IsNotExist(statErr) {
+			err = os.Rename(tmp, dst)
+			renameMu.Unlock()
+			if err != nil {
+				return "", err
+			}
+			return dst, nil
+		}
+		renameMu.Unlock()
+		if statErr != nil {
+			return "", statErr
+		}
+		dst = filepath.Join(f.rootDir, nextFilename(filepath.Base(dst)))
+	}
+	return "", fmt.Errorf("too many retries trying to rename directory to %q", dirName)
+}
+
+func (f fsFileOps) OpenTree(name string) (fs.FS, error) {
+	path, err := joinDir(f.rootDir, name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, &fs.PathError{Op: "opentree", Path: path, Err: errors.New("not a directory")}
+	}
+	return os.DirFS(path), nil
+}
+
+func (f fsFileOps) RemoveTree(name string) error {
+	path, err := joinDir(f.rootDir, name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Lstat(path); err != nil {
+		return err
+	}
+	return os.RemoveAll(path)
+}
+
 func (f fsFileOps) Stat(name string) (fs.FileInfo, error) {
 	path, err := joinDir(f.rootDir, name)
 	if err != nil {
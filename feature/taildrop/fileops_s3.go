@@ -0,0 +1,436 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+//go:build !android
+
+package taildrop
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"tailscale.com/types/logger"
+)
+
+func init() {
+	RegisterFileOps("s3", newS3FileOps)
+}
+
+// newS3FileOps returns a FileOps that stores completed files in an
+// S3-compatible object store. The location is of the form:
+//
+//	s3://bucket[/prefix][?region=us-east-1][&endpoint=http://localhost:9000]
+//
+// If endpoint is set, requests use path-style addressing against it
+// (as needed by MinIO and most other S3-compatible stores); otherwise
+// they go to AWS using virtual-hosted-style addressing.
+//
+// Credentials are read from the standard AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables.
+// The region defaults to $AWS_REGION, or else "us-east-1".
+func newS3FileOps(logf logger.Logf, location, stagingDir string) (FileOps, error) {
+	if stagingDir == "" {
+		return nil, errors.New("s3 storage requires a local staging directory")
+	}
+	sink, err := newS3Sink(location)
+	if err != nil {
+		return nil, err
+	}
+	logf("storing received files in %s", sink.Location(""))
+	return NewSinkFileOps(stagingDir, sink)
+}
+
+const (
+	// s3RequestTimeout bounds requests to S3 that don't stream a file.
+	s3RequestTimeout = time.Minute
+
+	// s3IdleTimeout is how long a connection to S3 may make no progress
+	// before the request using it fails. It bounds requests that stream a
+	// file, which have no overall deadline, so that a stalled endpoint
+	// can't hang Taildrop.
+	s3IdleTimeout = time.Minute
+)
+
+// s3Sink is a FileSink backed by an S3-compatible object store.
+type s3Sink struct {
+	hc             *http.Client
+	requestTimeout time.Duration // for requests that don't stream a file
+	bucket         string
+	prefix         string   // key prefix; empty or ends in "/"
+	base           *url.URL // base URL for objects, without the key
+	pathStyle      bool     // whether the bucket name is part of the path
+
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+
+	now func() time.Time // for tests
+}
+
+func newS3Sink(location string) (*s3Sink, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "s3" || u.Host == "" {
+		return nil, fmt.Errorf("invalid s3 location %q", location)
+	}
+	q := u.Query()
+	s := &s3Sink{
+		hc:             newS3HTTPClient(s3IdleTimeout),
+		requestTimeout: s3RequestTimeout,
+		bucket:         u.Host,
+		region:         q.Get("region"),
+		accessKey:      os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:      os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken:   os.Getenv("AWS_SESSION_TOKEN"),
+		now:            time.Now,
+	}
+	if p := strings.Trim(u.Path, "/"); p != "" {
+		s.prefix = p + "/"
+	}
+	if s.region == "" {
+		s.region = os.Getenv("AWS_REGION")
+	}
+	if s.region == "" {
+		s.region = "us-east-1"
+	}
+	if ep := q.Get("endpoint"); ep != "" {
+		s.base, err = url.Parse(ep)
+		if err != nil {
+			return nil, fmt.Errorf("invalid s3 endpoint: %w", err)
+		}
+		if s.base.Scheme != "http" && s.base.Scheme != "https" {
+			return nil, fmt.Errorf("invalid s3 endpoint %q", ep)
+		}
+		s.pathStyle = true
+	} else {
+		s.base = &url.URL{Scheme: "https", Host: fmt.Sprintf("%s.s3.%s.amazonaws.com", s.bucket, s.region)}
+	}
+	if s.accessKey == "" || s.secretKey == "" {
+		return nil, errors.New("s3 storage requires AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	return s, nil
+}
+
+// newS3HTTPClient returns an HTTP client whose connections fail any request
+// that makes no progress reading or writing for idleTimeout.
+func newS3HTTPClient(idleTimeout time.Duration) *http.Client {
+	d := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		c, err := d.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &idleTimeoutConn{Conn: c, timeout: idleTimeout}, nil
+	}
+	tr.ResponseHeaderTimeout = idleTimeout
+	return &http.Client{Transport: tr}
+}
+
+// idleTimeoutConn is a net.Conn whose reads and writes fail if they make no
+// progress for timeout.
+type idleTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *idleTimeoutConn) Read(b []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *idleTimeoutConn) Write(b []byte) (int, error) {
+	if err := c.Conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(b)
+}
+
+// objectPath returns the URL path of the object named key,
+// or of the bucket if key is empty.
+func (s *s3Sink) objectPath(key string) string {
+	p := "/" + key
+	if s.pathStyle {
+		p = "/" + s.bucket + p
+	}
+	return path.Join(strings.TrimSuffix(s.base.Path, "/"), p)
+}
+
+func (s *s3Sink) Location(name string) string {
+	return "s3://" + s.bucket + "/" + s.prefix + name
+}
+
+func (s *s3Sink) Put(name string, r io.Reader, size int64) error {
+	res, err := s.do(context.Background(), http.MethodPut, s.prefix+name, nil, r, size)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+func (s *s3Sink) Open(name string) (io.ReadCloser, error) {
+	res, err := s.do(context.Background(), http.MethodGet, s.prefix+name, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+func (s *s3Sink) Stat(name string) (fs.FileInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.requestTimeout)
+	defer cancel()
+	res, err := s.do(ctx, http.MethodHead, s.prefix+name, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+	fi := s3FileInfo{name: name, size: res.ContentLength}
+	if t, err := http.ParseTime(res.Header.Get("Last-Modified")); err == nil {
+		fi.modTime = t
+	}
+	return fi, nil
+}
+
+func (s *s3Sink) Remove(name string) error {
+	// S3 deletes are idempotent and never report a missing object,
+	// so check for existence first to match the FileSink contract.
+	if _, err := s.Stat(name); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.requestTimeout)
+	defer cancel()
+	res, err := s.do(ctx, http.MethodDelete, s.prefix+name, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// listBucketResult is the subset of the ListObjectsV2 response we use.
+type listBucketResult struct {
+	Contents []struct {
+		Key string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+func (s *s3Sink) List() ([]string, error) {
+	var names []string
+	q := url.Values{
+		"list-type": {"2"},
+		"delimiter": {"/"},
+	}
+	if s.prefix != "" {
+		q.Set("prefix", s.prefix)
+	}
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), s.requestTimeout)
+		res, err := s.do(ctx, http.MethodGet, "", q, nil, 0)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		var lr listBucketResult
+		err = xml.NewDecoder(io.LimitReader(res.Body, 16<<20)).Decode(&lr)
+		res.Body.Close()
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("decoding s3 object list: %w", err)
+		}
+		for _, c := range lr.Contents {
+			if name, ok := strings.CutPrefix(c.Key, s.prefix); ok && name != "" {
+				names = append(names, name)
+			}
+		}
+		if !lr.IsTruncated || lr.NextContinuationToken == "" {
+			return names, nil
+		}
+		q.Set("continuation-token", lr.NextContinuationToken)
+	}
+}
+
+// do sends a signed request for the object named key (or the bucket if
+// key is empty). It returns an error wrapping [fs.ErrNotExist] if the
+// object does not exist, or an error for any other non-2xx response.
+// The response body must be read before ctx is canceled.
+func (s *s3Sink) do(ctx context.Context, method, key string, query url.Values, body io.Reader, size int64) (*http.Response, error) {
+	u := *s.base
+	u.Path = s.objectPath(key)
+	u.RawPath = escapePath(u.Path)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Body = io.NopCloser(body)
+		req.ContentLength = size
+		if size == 0 {
+			req.Body = http.NoBody
+		}
+	}
+	s.sign(req, u.RawPath, u.RawQuery)
+
+	res, err := s.hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode/100 == 2 {
+		return res, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(res.Body, 1<<10))
+	res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, &fs.PathError{Op: strings.ToLower(method), Path: s.Location(strings.TrimPrefix(key, s.prefix)), Err: fs.ErrNotExist}
+	}
+	return nil, fmt.Errorf("s3 %s %q: %s: %s", method, key, res.Status, strings.TrimSpace(string(msg)))
+}
+
+// sign adds AWS Signature Version 4 headers to req.
+// The payload is left unsigned so that bodies can be streamed.
+func (s *s3Sink) sign(req *http.Request, escapedPath, rawQuery string) {
+	const (
+		algorithm   = "AWS4-HMAC-SHA256"
+		payloadHash = "UNSIGNED-PAYLOAD"
+		service     = "s3"
+	)
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, vv := range req.Header {
+		if k := strings.ToLower(k); strings.HasPrefix(k, "x-amz-") {
+			headers[k] = strings.Join(vv, ",")
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		canonHeaders.WriteString(k + ":" + strings.TrimSpace(headers[k]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	if escapedPath == "" {
+		escapedPath = "/"
+	}
+	canonRequest := strings.Join([]string{
+		req.Method,
+		escapedPath,
+		rawQuery,
+		canonHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + s.region + "/" + service + "/aws4_request"
+	reqHash := sha256.Sum256([]byte(canonRequest))
+	stringToSign := algorithm + "\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(reqHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), day)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		algorithm, s.accessKey, scope, signedHeaders, sig))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// canonicalQuery encodes q as required by AWS Signature Version 4:
+// sorted by key with RFC 3986 escaping, which is also a valid URL query.
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		vs := append([]string(nil), q[k]...)
+		sort.Strings(vs)
+		for _, v := range vs {
+			parts = append(parts, awsEscape(k)+"="+awsEscape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// awsEscape escapes s per RFC 3986, leaving only unreserved characters as-is.
+func awsEscape(s string) string {
+	var b strings.Builder
+	for i := range len(s) {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		default:
+			b.WriteString("%" + strings.ToUpper(strconv.FormatUint(uint64(c)|0x100, 16)[1:]))
+		}
+	}
+	return b.String()
+}
+
+// escapePath escapes each segment of the slash-separated path p with awsEscape.
+func escapePath(p string) string {
+	segs := strings.Split(p, "/")
+	for i, seg := range segs {
+		segs[i] = awsEscape(seg)
+	}
+	return strings.Join(segs, "/")
+}
+
+// s3FileInfo is the FileInfo of an object in an s3Sink.
+type s3FileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (fi s3FileInfo) Name() string       { return fi.name }
+func (fi s3FileInfo) Size() int64        { return fi.size }
+func (fi s3FileInfo) Mode() fs.FileMode  { return 0o600 }
+func (fi s3FileInfo) ModTime() time.Time { return fi.modTime }
+func (fi s3FileInfo) IsDir() bool        { return false }
+func (fi s3FileInfo) Sys() any           { return nil }
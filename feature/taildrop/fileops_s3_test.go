@@ -0,0 +1,175 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+//go:build !android
+
+package taildrop
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tailscale.com/util/must"
+)
+
+// fakeS3 is a minimal stand-in for an S3-compatible object store
+// using path-style addressing.
+type fakeS3 struct {
+	t      *testing.T
+	bucket string
+
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/") ||
+		!strings.Contains(auth, "SignedHeaders=host;x-amz-content-sha256;x-amz-date,") ||
+		r.Header.Get("X-Amz-Date") == "" {
+		f.t.Errorf("unexpected auth headers: %q", auth)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	key, ok := strings.CutPrefix(r.URL.Path, "/"+f.bucket)
+	if !ok {
+		http.Error(w, "no such bucket", http.StatusNotFound)
+		return
+	}
+	key = strings.TrimPrefix(key, "/")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case key == "" && r.Method == "GET":
+		if r.URL.Query().Get("list-type") != "2" {
+			http.Error(w, "bad list", http.StatusBadRequest)
+			return
+		}
+		type content struct{ Key string }
+		var res struct {
+			XMLName  xml.Name `xml:"ListBucketResult"`
+			Contents []content
+		}
+		prefix := r.URL.Query().Get("prefix")
+		for _, k := range slices.Sorted(maps.Keys(f.objects)) {
+			if strings.HasPrefix(k, prefix) && !strings.Contains(k[len(prefix):], "/") {
+				res.Contents = append(res.Contents, content{k})
+			}
+		}
+		xml.NewEncoder(w).Encode(res)
+	case r.Method == "PUT":
+		b, err := io.ReadAll(r.Body)
+		if err != nil || int64(len(b)) != r.ContentLength {
+			http.Error(w, "bad body", http.StatusBadRequest)
+			return
+		}
+		f.objects[key] = b
+	case r.Method == "GET" || r.Method == "HEAD":
+		b, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(b)))
+		w.Write(b)
+	case r.Method == "DELETE":
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "bad method", http.StatusMethodNotAllowed)
+	}
+}
+
+func TestS3FileOps(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+
+	srv := &fakeS3{t: t, bucket: "drops", objects: make(map[string][]byte)}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	location := "s3://drops/incoming?endpoint=" + url.QueryEscape(ts.URL)
+	fops := must.Get(NewFileOps(t.Logf, location, t.TempDir()))
+	m := managerOptions{Logf: t.Logf, fileOps: fops, DirectFileMode: true}.New()
+	defer m.Shutdown()
+
+	for _, name := range []string{"report.pdf", "a+b=c (1).txt", "report.pdf"} {
+		content := "contents of " + name
+		if _, err := m.PutFile("id", name, strings.NewReader(content), 0, int64(len(content))); err != nil {
+			t.Fatalf("PutFile(%q): %v", name, err)
+		}
+	}
+
+	got := slices.Sorted(maps.Keys(srv.objects))
+	want := []string{"incoming/a+b=c (1).txt", "incoming/report.pdf"}
+	if !slices.Equal(got, want) {
+		t.Errorf("objects = %q; want %q", got, want)
+	}
+	if files := must.Get(fops.ListFiles()); !slices.Equal(slices.Sorted(slices.Values(files)), []string{"a+b=c (1).txt", "report.pdf"}) {
+		t.Errorf("ListFiles = %q", files)
+	}
+	fi := must.Get(fops.Stat("report.pdf"))
+	if fi.Size() != int64(len("contents of report.pdf")) {
+		t.Errorf("Stat size = %d", fi.Size())
+	}
+	must.Do(fops.Remove("report.pdf"))
+	if _, err := fops.Stat("report.pdf"); err == nil {
+		t.Errorf("Stat after Remove succeeded")
+	}
+
+	if _, err := NewFileOps(t.Logf, location, ""); err == nil {
+		t.Errorf("NewFileOps without staging directory succeeded")
+	}
+}
+
+func TestS3StalledEndpoint(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	stalled := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-stalled
+	}))
+	defer ts.Close()
+	defer close(stalled)
+
+	s := must.Get(newS3Sink("s3://drops?endpoint=" + url.QueryEscape(ts.URL)))
+	s.hc = newS3HTTPClient(50 * time.Millisecond)
+	s.requestTimeout = 50 * time.Millisecond
+
+	if _, err := s.Stat("report.pdf"); err == nil {
+		t.Errorf("Stat on stalled endpoint succeeded")
+	}
+	if err := s.Put("report.pdf", strings.NewReader("contents"), int64(len("contents"))); err == nil {
+		t.Errorf("Put on stalled endpoint succeeded")
+	}
+	if _, err := s.Open("report.pdf"); err == nil {
+		t.Errorf("Open on stalled endpoint succeeded")
+	}
+}
+
+func TestAWSEscape(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"foo.txt", "foo.txt"},
+		{"a b", "a%20b"},
+		{"a+b=c", "a%2Bb%3Dc"},
+		{"~_-.", "~_-."},
+		{"é", "%C3%A9"},
+	}
+	for _, tt := range tests {
+		if got := awsEscape(tt.in); got != tt.want {
+			t.Errorf("awsEscape(%q) = %q; want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+//go:build !android
+
+package taildrop
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/util/set"
+)
+
+// FileSink is a destination for completed Taildrop files that isn't a local
+// directory, such as an object store.
+//
+// Names passed to a FileSink are base names that have already been validated
+// by the caller. Implementations must be safe for concurrent use.
+type FileSink interface {
+	// Put stores size bytes read from r as name,
+	// replacing any existing content.
+	Put(name string, r io.Reader, size int64) error
+
+	// Open opens name for reading.
+	Open(name string) (io.ReadCloser, error)
+
+	// Stat returns the FileInfo for name.
+	// It returns an error wrapping [fs.ErrNotExist] if name does not exist.
+	Stat(name string) (fs.FileInfo, error)
+
+	// Remove deletes name.
+	// It returns an error wrapping [fs.ErrNotExist] if name does not exist.
+	Remove(name string) error
+
+	// List returns the names of all files in the sink.
+	List() ([]string, error)
+
+	// Location returns a human-readable location of name,
+	// such as a URL. It is reported to clients as the final path of a file.
+	Location(name string) string
+}
+
+// NewSinkFileOps returns a FileOps that receives partial files into the local
+// stagingDir and moves them into sink once they are complete.
+//
+// Because partial files stay local until they are complete, resuming
+// interrupted transfers works the same as with a local directory.
+func NewSinkFileOps(stagingDir string, sink FileSink) (FileOps, error) {
+	if sink == nil {
+		return nil, errors.New("nil FileSink")
+	}
+	if newFileOps == nil {
+		return nil, errors.New("local staging directory not supported")
+	}
+	staging, err := newFileOps(stagingDir)
+	if err != nil {
+		return nil, err
+	}
+	return &sinkFileOps{staging: staging, sink: sink}, nil
+}
+
+// sinkFileOps implements FileOps by staging partial files locally
+// and moving completed files into a FileSink.
+type sinkFileOps struct {
+	staging FileOps
+	sink    FileSink
+
+	mu      sync.Mutex
+	pending set.Set[string] // names being uploaded to sink
+}
+
+func (f *sinkFileOps) OpenWriter(name string, offset int64, perm os.FileMode) (io.WriteCloser, string, error) {
+	return f.staging.OpenWriter(name, offset, perm)
+}
+
+func (f *sinkFileOps) Remove(name string) error {
+	err := f.staging.Remove(name)
+	if err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return f.sink.Remove(name)
+}
+
+// Rename uploads the staged file at oldPath into the sink as newName and
+// removes the staged copy. Like the local implementation, it de-duplicates
+// files with identical contents and otherwise picks a new name on conflict.
+func (f *sinkFileOps) Rename(oldPath, newName string) (newPath string, err error) {
+	if filepath.IsAbs(newName) || strings.ContainsAny(newName, `/\`) {
+		return "", fmt.Errorf("invalid newName %q: must not be an absolute path or contain path separators", newName)
+	}
+	st, err := os.Stat(oldPath)
+	if err != nil {
+		return "", err
+	}
+	wantSize := st.Size()
+
+	dst := newName
+	const maxRetries = 10
+	for range maxRetries {
+		fi, statErr := f.reserve(dst)
+		if statErr == nil {
+			// Avoid the upload if the destination has the same contents.
+			if fi.Size() == wantSize {
+				same, err := f.sameContents(oldPath, dst)
+				if err != nil {
+					return "", err
+				}
+				if same {
+					if err := os.Remove(oldPath); err != nil {
+						return "", err
+					}
+					return f.sink.Location(dst), nil
+				}
+			}
+			dst = nextFilename(dst)
+			continue
+		}
+		if !errors.Is(statErr, fs.ErrNotExist) {
+			return "", statErr
+		}
+
+		err := f.upload(oldPath, dst, wantSize)
+		f.release(dst)
+		if err != nil {
+			return "", err
+		}
+		return f.sink.Location(dst), nil
+	}
+	return "", fmt.Errorf("too many retries trying to rename %q to %q", oldPath, newName)
+}
+
+// reserve claims name for an upload if it's not already in use.
+// If name is taken, it returns the existing file's info and a nil error.
+// If name was free, it returns an error wrapping [fs.ErrNotExist] and the
+// caller must call release once the upload is done.
+func (f *sinkFileOps) reserve(name string) (fs.FileInfo, error) {
+	f.mu.Lock()
+	if f.pending.Contains(name) {
+		f.mu.Unlock()
+		return pendingFileInfo{name}, nil
+	}
+	f.pending.Make()
+	f.pending.Add(name)
+	f.mu.Unlock()
+
+	// Stat without f.mu held, as it may be a slow network round trip,
+	// and undo the reservation if name turns out to be taken.
+	fi, err := f.sink.Stat(name)
+	if err == nil || !errors.Is(err, fs.ErrNotExist) {
+		f.release(name)
+	}
+	return fi, err
+}
+
+func (f *sinkFileOps) release(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pending.Delete(name)
+}
+
+func (f *sinkFileOps) upload(srcPath, name string, size int64) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if err := f.sink.Put(name, src, size); err != nil {
+		return err
+	}
+	src.Close() // Windows wants the file handle to be closed before removal.
+	return os.Remove(srcPath)
+}
+
+func (f *sinkFileOps) sameContents(localPath, name string) (bool, error) {
+	sumL, err := sha256File(localPath)
+	if err != nil {
+		return false, err
+	}
+	rc, err := f.sink.Open(name)
+	if err != nil {
+		return false, err
+	}
+	defer rc.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, rc); err != nil {
+		return false, err
+	}
+	return bytes.Equal(sumL[:], h.Sum(nil)), nil
+}
+
+func (f *sinkFileOps) ListFiles() ([]string, error) {
+	staged, err := f.staging.ListFiles()
+	if err != nil {
+		return nil, err
+	}
+	stored, err := f.sink.List()
+	if err != nil {
+		return nil, err
+	}
+	seen := set.Of(staged...)
+	names := staged
+	for _, name := range stored {
+		if !seen.Contains(name) {
+			seen.Add(name)
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func (f *sinkFileOps) Stat(name string) (fs.FileInfo, error) {
+	fi, err := f.staging.Stat(name)
+	if err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return fi, err
+	}
+	return f.sink.Stat(name)
+}
+
+func (f *sinkFileOps) OpenReader(name string) (io.ReadCloser, error) {
+	rc, err := f.staging.OpenReader(name)
+	if err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return rc, err
+	}
+	return f.sink.Open(name)
+}
+
+// pendingFileInfo is the FileInfo of a file that is still being
+// uploaded to a FileSink. Its size is unknown.
+type pendingFileInfo struct{ name string }
+
+func (fi pendingFileInfo) Name() string       { return fi.name }
+func (fi pendingFileInfo) Size() int64        { return -1 }
+func (fi pendingFileInfo) Mode() fs.FileMode  { return 0 }
+func (fi pendingFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi pendingFileInfo) IsDir() bool        { return false }
+func (fi pendingFileInfo) Sys() any           { return nil }
@@ -0,0 +1,245 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+//go:build !android
+
+package taildrop
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tailscale.com/types/logger"
+	"tailscale.com/util/must"
+)
+
+func TestNewFileOps(t *testing.T) {
+	dir := t.TempDir()
+
+	fops := must.Get(NewFileOps(t.Logf, dir, ""))
+	if _, ok := fops.(fsFileOps); !ok {
+		t.Errorf("NewFileOps(dir) = %T; want fsFileOps", fops)
+	}
+
+	fops = must.Get(NewFileOps(t.Logf, "file://"+filepath.ToSlash(dir), ""))
+	if got, ok := fops.(fsFileOps); !ok || got.rootDir != dir {
+		t.Errorf("NewFileOps(file URL) = %#v; want fsFileOps rooted at %q", fops, dir)
+	}
+
+	if _, err := NewFileOps(t.Logf, "bogus://foo", ""); err == nil {
+		t.Errorf("NewFileOps with unknown scheme succeeded")
+	}
+
+	old := maps.Clone(knownFileOps)
+	t.Cleanup(func() { knownFileOps = old })
+	var gotLocation, gotStaging string
+	RegisterFileOps("test", func(_ logger.Logf, location, stagingDir string) (FileOps, error) {
+		gotLocation, gotStaging = location, stagingDir
+		return NewSinkFileOps(stagingDir, newMemSink())
+	})
+	must.Get(NewFileOps(t.Logf, "test://foo/bar", dir))
+	if gotLocation != "test://foo/bar" || gotStaging != dir {
+		t.Errorf("provider called with (%q, %q); want (%q, %q)", gotLocation, gotStaging, "test://foo/bar", dir)
+	}
+}
+
+func TestSinkFileOps(t *testing.T) {
+	staging := t.TempDir()
+	sink := newMemSink()
+	fops := must.Get(NewSinkFileOps(staging, sink))
+
+	m := managerOptions{Logf: t.Logf, fileOps: fops, DirectFileMode: true}.New()
+	defer m.Shutdown()
+
+	put := func(name, content string) {
+		t.Helper()
+		if _, err := m.PutFile("id", name, strings.NewReader(content), 0, int64(len(content))); err != nil {
+			t.Fatalf("PutFile(%q): %v", name, err)
+		}
+	}
+	put("foo.txt", "hello")
+	put("foo.txt", "hello") // identical contents are de-duplicated
+	put("foo.txt", "world") // different contents get a new name
+
+	if got, want := sink.names(), []string{"foo (1).txt", "foo.txt"}; !slices.Equal(got, want) {
+		t.Errorf("sink contents = %q; want %q", got, want)
+	}
+	if got := string(sink.files["foo (1).txt"]); got != "world" {
+		t.Errorf("foo (1).txt = %q; want %q", got, "world")
+	}
+	if ents := must.Get(os.ReadDir(staging)); len(ents) != 0 {
+		t.Errorf("staging directory not empty after transfers: %v", ents)
+	}
+
+	fi := must.Get(fops.Stat("foo.txt"))
+	if fi.Size() != 5 {
+		t.Errorf("Stat size = %d; want 5", fi.Size())
+	}
+	rc := must.Get(fops.OpenReader("foo.txt"))
+	if got := string(must.Get(io.ReadAll(rc))); got != "hello" {
+		t.Errorf("OpenReader = %q; want %q", got, "hello")
+	}
+	rc.Close()
+
+	must.Do(fops.Remove("foo.txt"))
+	if err := fops.Remove("foo.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("second Remove = %v; want ErrNotExist", err)
+	}
+}
+
+func TestSinkFileOpsResume(t *testing.T) {
+	oldBlockSize := blockSize
+	defer func() { blockSize = oldBlockSize }()
+	blockSize = 4
+
+	sink := newMemSink()
+	fops := must.Get(NewSinkFileOps(t.TempDir(), sink))
+	m := managerOptions{Logf: t.Logf, fileOps: fops, DirectFileMode: true}.New()
+	defer m.Shutdown()
+
+	want := []byte("0123456789abcdef")
+	if _, err := m.PutFile("id", "foo", io.LimitReader(bytes.NewReader(want), 9), 0, int64(len(want))); err == nil {
+		t.Fatalf("short PutFile succeeded")
+	}
+	if len(sink.names()) != 0 {
+		t.Fatalf("partial file was moved into sink")
+	}
+
+	next, close, err := m.HashPartialFile("id", "foo")
+	must.Do(err)
+	offset, r, err := resumeReader(bytes.NewReader(want), next)
+	must.Do(err)
+	must.Do(close())
+	if offset != 9 {
+		t.Errorf("resume offset = %d; want 9", offset)
+	}
+	must.Get(m.PutFile("id", "foo", r, offset, int64(len(want))-offset))
+	if got := sink.files["foo"]; !bytes.Equal(got, want) {
+		t.Errorf("resumed content = %q; want %q", got, want)
+	}
+}
+
+func TestSinkFileOpsReserve(t *testing.T) {
+	sink := newMemSink()
+	sink.files["taken"] = []byte("x")
+	unblock := make(chan struct{})
+	blocked := make(chan struct{})
+	sink.statHook = func(name string) {
+		if name == "slow" {
+			close(blocked)
+			<-unblock
+		}
+	}
+	fops := must.Get(NewSinkFileOps(t.TempDir(), sink)).(*sinkFileOps)
+
+	slowDone := make(chan error, 1)
+	go func() {
+		_, err := fops.reserve("slow")
+		slowDone <- err
+	}()
+	<-blocked
+
+	// A slow Stat must not block reservations of other names, and the
+	// name being checked is already reserved.
+	if _, err := fops.reserve("fast"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("reserve(fast) = %v; want ErrNotExist", err)
+	}
+	if fi, err := fops.reserve("slow"); err != nil || fi.Name() != "slow" {
+		t.Errorf("reserve(slow) while pending = %v, %v; want pending file", fi, err)
+	}
+	close(unblock)
+	if err := <-slowDone; !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("reserve(slow) = %v; want ErrNotExist", err)
+	}
+
+	// Names that exist in the sink are reported and not left reserved.
+	if _, err := fops.reserve("taken"); err != nil {
+		t.Errorf("reserve(taken) = %v; want nil", err)
+	}
+	if fops.pending.Contains("taken") {
+		t.Error("existing file left reserved")
+	}
+}
+
+// memSink is an in-memory FileSink for tests.
+type memSink struct {
+	mu    sync.Mutex
+	files map[string][]byte
+
+	// statHook, if non-nil, is called at the start of each Stat.
+	statHook func(name string)
+}
+
+func newMemSink() *memSink {
+	return &memSink{files: make(map[string][]byte)}
+}
+
+func (s *memSink) names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Sorted(maps.Keys(s.files))
+}
+
+func (s *memSink) Put(name string, r io.Reader, size int64) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if int64(len(b)) != size {
+		return errors.New("size mismatch")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[name] = b
+	return nil
+}
+
+func (s *memSink) Open(name string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (s *memSink) Stat(name string) (fs.FileInfo, error) {
+	if s.statHook != nil {
+		s.statHook(name)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return s3FileInfo{name: name, size: int64(len(b)), modTime: time.Now()}, nil
+}
+
+func (s *memSink) Remove(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.files[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(s.files, name)
+	return nil
+}
+
+func (s *memSink) List() ([]string, error) {
+	return s.names(), nil
+}
+
+func (s *memSink) Location(name string) string {
+	return "mem:" + name
+}
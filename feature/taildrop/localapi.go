@@ -4,6 +4,7 @@
 package taildrop
 
 import (
+	"archive/tar"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -33,12 +34,14 @@ import (
 
 func init() {
 	localapi.Register("file-put/", serveFilePut)
+	localapi.Register("file-put-dir/", serveFilePutDir)
 	localapi.Register("files/", serveFiles)
 	localapi.Register("file-targets", serveFileTargets)
 }
 
 var (
-	metricFilePutCalls = clientmetric.NewCounter("localapi_file_put")
+	metricFilePutCalls    = clientmetric.NewCounter("localapi_file_put")
+	metricFilePutDirCalls = clientmetric.NewCounter("localapi_file_put_dir")
 )
 
 // serveFilePut sends a file to another node.
@@ -86,12 +89,6 @@ func serveFilePut(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	fts, err := ext.FileTargets()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
 	upath, ok := strings.CutPrefix(r.URL.EscapedPath(), "/localapi/v0/file-put/")
 	if !ok {
 		http.Error(w, "misconfigured", http.StatusInternalServerError)
@@ -110,6 +107,39 @@ func serveFilePut(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
 	}
 	peerID := tailcfg.StableNodeID(peerIDStr)
 
+	dstURL, ok := peerAPIURLForPut(ext, w, peerID)
+	if !ok {
+		return
+	}
+
+	progressUpdates := ext.startProgressUpdates()
+	defer close(progressUpdates)
+
+	switch r.Method {
+	case "PUT":
+		file := ipn.OutgoingFile{
+			ID:           rands.HexString(30),
+			PeerID:       peerID,
+			Name:         filenameEscaped,
+			DeclaredSize: r.ContentLength,
+		}
+		singleFilePut(h, r.Context(), progressUpdates, w, r.Body, dstURL, file)
+	case "POST":
+		multiFilePost(h, progressUpdates, w, r, peerID, dstURL)
+	default:
+		http.Error(w, "want PUT to put file", http.StatusBadRequest)
+		return
+	}
+}
+
+// peerAPIURLForPut returns the PeerAPI URL of the file target peerID.
+// If it returns false, it has already written an error to w.
+func peerAPIURLForPut(ext *Extension, w http.ResponseWriter, peerID tailcfg.StableNodeID) (_ *url.URL, ok bool) {
+	fts, err := ext.FileTargets()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
 	var ft *apitype.FileTarget
 	for _, x := range fts {
 		if x.Node.StableID == peerID {
@@ -119,19 +149,23 @@ func serveFilePut(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
 	}
 	if ft == nil {
 		http.Error(w, "node not found", http.StatusNotFound)
-		return
+		return nil, false
 	}
 	dstURL, err := url.Parse(ft.PeerAPIURL)
 	if err != nil {
 		http.Error(w, "bogus peer URL", http.StatusInternalServerError)
-		return
+		return nil, false
 	}
+	return dstURL, true
+}
 
-	// Periodically report progress of outgoing files.
+// startProgressUpdates starts a goroutine that periodically reports the
+// progress of outgoing files sent on the returned channel.
+// The caller must close the channel when done, which sends a final report.
+func (ext *Extension) startProgressUpdates() chan ipn.OutgoingFile {
 	outgoingFiles := make(map[string]*ipn.OutgoingFile)
 	t := time.NewTicker(1 * time.Second)
 	progressUpdates := make(chan ipn.OutgoingFile)
-	defer close(progressUpdates)
 
 	go func() {
 		defer t.Stop()
@@ -148,22 +182,7 @@ func serveFilePut(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
 			}
 		}
 	}()
-
-	switch r.Method {
-	case "PUT":
-		file := ipn.OutgoingFile{
-			ID:           rands.HexString(30),
-			PeerID:       peerID,
-			Name:         filenameEscaped,
-			DeclaredSize: r.ContentLength,
-		}
-		singleFilePut(h, r.Context(), progressUpdates, w, r.Body, dstURL, file)
-	case "POST":
-		multiFilePost(h, progressUpdates, w, r, peerID, dstURL)
-	default:
-		http.Error(w, "want PUT to put file", http.StatusBadRequest)
-		return
-	}
+	return progressUpdates
 }
 
 func multiFilePost(h *localapi.Handler, progressUpdates chan (ipn.OutgoingFile), w http.ResponseWriter, r *http.Request, peerID tailcfg.StableNodeID, dstURL *url.URL) {
@@ -356,6 +375,165 @@ func singleFilePut(
 	return true
 }
 
+// serveFilePutDir sends a directory tree to another node.
+//
+// The request body is multipart/form-data. The first part must be an
+// application/json [apitype.DirManifest] listing every file with its size
+// and SHA-256. Each following part is the content of one file, with the
+// file's manifest path as its form name. The manifest is checksummed and
+// sent to the peer first, then each file, and finally the peer is asked to
+// verify and materialize the directory.
+//
+// URL format:
+//
+//   - POST /localapi/v0/file-put-dir/:stableID
+func serveFilePutDir(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
+	metricFilePutDirCalls.Add(1)
+
+	if !h.PermitWrite {
+		http.Error(w, "file access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "want POST to put directory", http.StatusBadRequest)
+		return
+	}
+	ext, ok := ipnlocal.GetExt[*Extension](h.LocalBackend())
+	if !ok {
+		http.Error(w, "misconfigured taildrop extension", http.StatusInternalServerError)
+		return
+	}
+	peerIDStr, ok := strings.CutPrefix(r.URL.EscapedPath(), "/localapi/v0/file-put-dir/")
+	if !ok {
+		http.Error(w, "misconfigured", http.StatusInternalServerError)
+		return
+	}
+	peerID := tailcfg.StableNodeID(peerIDStr)
+	dstURL, ok := peerAPIURLForPut(ext, w, peerID)
+	if !ok {
+		return
+	}
+
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid Content-Type for multipart POST: %s", err), http.StatusBadRequest)
+		return
+	}
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	part, err := mr.NextPart()
+	if err != nil || part.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "first MIME part must be a JSON directory manifest", http.StatusBadRequest)
+		return
+	}
+	var manifest apitype.DirManifest
+	if err := json.NewDecoder(part).Decode(&manifest); err != nil {
+		http.Error(w, fmt.Sprintf("invalid manifest: %s", err), http.StatusBadRequest)
+		return
+	}
+	if err := validateManifest(&manifest, false); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	SetManifestChecksum(&manifest)
+
+	sizes := make(map[string]int64, len(manifest.Files))
+	var total int64
+	for _, f := range manifest.Files {
+		sizes[f.Path] = f.Size
+		total += f.Size
+	}
+
+	progressUpdates := ext.startProgressUpdates()
+	defer close(progressUpdates)
+	transferID := rands.HexString(32)
+	outgoing := ipn.OutgoingFile{
+		ID:           transferID,
+		PeerID:       peerID,
+		Name:         manifest.Name,
+		Started:      time.Now(),
+		DeclaredSize: total,
+		NumFiles:     len(manifest.Files),
+	}
+	progressUpdates <- outgoing
+
+	fail := func(code int, err error) {
+		outgoing.Finished = true
+		outgoing.Succeeded = false
+		progressUpdates <- outgoing
+		http.Error(w, err.Error(), code)
+	}
+
+	ctx := r.Context()
+	client := &http.Client{Transport: h.LocalBackend().Dialer().PeerAPITransport()}
+	baseURL := dstURL.String() + "/v0/put-dir/" + transferID
+	mj, _ := json.Marshal(manifest)
+	if err := putDirRequest(ctx, client, "PUT", baseURL, bytes.NewReader(mj), int64(len(mj))); err != nil {
+		fail(http.StatusBadGateway, err)
+		return
+	}
+
+	var sentBefore int64
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			fail(http.StatusBadRequest, fmt.Errorf("failed to decode multipart/form-data: %w", err))
+			return
+		}
+		relPath := part.FormName()
+		size, ok := sizes[relPath]
+		if !ok {
+			fail(http.StatusBadRequest, fmt.Errorf("file %q not in manifest", relPath))
+			return
+		}
+		body := progresstracking.NewReader(part, 1*time.Second, func(n int, err error) {
+			outgoing.Sent = sentBefore + int64(n)
+			progressUpdates <- outgoing
+		})
+		if err := putDirRequest(ctx, client, "PUT", baseURL+"/"+url.PathEscape(relPath), body, size); err != nil {
+			fail(http.StatusBadGateway, err)
+			return
+		}
+		sentBefore += size
+		outgoing.Sent = sentBefore
+		outgoing.FilesSent++
+		progressUpdates <- outgoing
+	}
+
+	if err := putDirRequest(ctx, client, "POST", baseURL, nil, 0); err != nil {
+		fail(http.StatusBadGateway, err)
+		return
+	}
+	outgoing.Finished = true
+	outgoing.Succeeded = true
+	progressUpdates <- outgoing
+	io.WriteString(w, "{}\n")
+}
+
+// putDirRequest sends a directory transfer request to a peer's PeerAPI,
+// returning an error containing the peer's response if it failed.
+func putDirRequest(ctx context.Context, client *http.Client, method, urlStr string, body io.Reader, size int64) error {
+	req, err := http.NewRequestWithContext(ctx, method, urlStr, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 4<<10))
+		return fmt.Errorf("peer returned %s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
 func serveFiles(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
 	if !h.PermitWrite {
 		http.Error(w, "file access denied", http.StatusForbidden)
@@ -420,6 +598,16 @@ func serveFiles(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
+	if fsys, err := ext.OpenDir(name); err == nil {
+		w.Header().Set("Content-Type", "application/x-tar")
+		tw := tar.NewWriter(w)
+		if err := tw.AddFS(fsys); err != nil {
+			h.Logf("taildrop: writing directory: %v", err)
+			return
+		}
+		tw.Close()
+		return
+	}
 	rc, size, err := ext.OpenFile(name)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -5,6 +5,7 @@ package taildrop
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -12,6 +13,7 @@ import (
 	"strings"
 	"time"
 
+	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/ipn/ipnlocal"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tstime"
@@ -21,10 +23,12 @@ import (
 
 func init() {
 	ipnlocal.RegisterPeerAPIHandler("/v0/put/", handlePeerPut)
+	ipnlocal.RegisterPeerAPIHandler("/v0/put-dir/", handlePeerPutDir)
 }
 
 var (
-	metricPutCalls = clientmetric.NewCounter("peerapi_put")
+	metricPutCalls    = clientmetric.NewCounter("peerapi_put")
+	metricPutDirCalls = clientmetric.NewCounter("peerapi_put_dir")
 )
 
 // canPutFile reports whether h can put a file ("Taildrop") to this node.
@@ -51,6 +55,46 @@ type extensionForPut interface {
 	manager() *manager
 	hasCapFileSharing() bool
 	Clock() tstime.Clock
+
+	// peerLoginName returns the login name of peer's owner,
+	// or the empty string if unknown or peer is tagged.
+	peerLoginName(peer tailcfg.NodeView) string
+}
+
+// peerSender returns the sender of a put request, for the receive policy.
+func peerSender(h ipnlocal.PeerAPIHandler, ext extensionForPut) sender {
+	return senderFromPeer(h.Peer(), ext.peerLoginName(h.Peer()), h.IsSelfUntagged())
+}
+
+// writePutError writes err from a put or put-dir request to w
+// with an appropriate HTTP status.
+func writePutError(w http.ResponseWriter, err error) {
+	var qe *QuotaError
+	switch {
+	case errors.Is(err, ErrNoTaildrop), errors.Is(err, ErrSenderNotAllowed):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.As(err, &qe):
+		w.Header().Set("Retry-After", fmt.Sprint(int64(qe.RetryAfter.Seconds()+1)))
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	case errors.Is(err, ErrFileTooLarge):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, ErrExtensionNotAllowed):
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+	case errors.Is(err, ErrDirsNotSupported):
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+	case errors.Is(err, ErrNoSuchTransfer):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrInvalidFileName),
+		errors.Is(err, ErrInvalidManifest),
+		errors.Is(err, ErrChecksumMismatch):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrTooManyTransfers):
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	case errors.Is(err, ErrFileExists), errors.Is(err, ErrIncompleteDir):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
 }
 
 func handlePeerPutWithBackend(h ipnlocal.PeerAPIHandler, ext extensionForPut, w http.ResponseWriter, r *http.Request) {
@@ -127,7 +171,6 @@ func handlePeerPutWithBackend(h ipnlocal.PeerAPIHandler, ext extensionForPut, w
 		}
 	case "PUT":
 		t0 := ext.Clock().Now()
-		id := clientID(h.Peer().StableID())
 
 		var offset int64
 		if rangeHdr := r.Header.Get("Range"); rangeHdr != "" {
@@ -138,26 +181,86 @@ func handlePeerPutWithBackend(h ipnlocal.PeerAPIHandler, ext extensionForPut, w
 			}
 			offset = ranges[0].Start
 		}
-		n, err := taildropMgr.PutFile(clientID(fmt.Sprint(id)), baseName, r.Body, offset, r.ContentLength)
-		switch err {
-		case nil:
-			d := ext.Clock().Since(t0).Round(time.Second / 10)
-			h.Logf("got put of %s in %v from %v/%v", approxSize(n), d, h.RemoteAddr().Addr(), h.Peer().ComputedName)
-			io.WriteString(w, "{}\n")
-		case ErrNoTaildrop:
-			http.Error(w, err.Error(), http.StatusForbidden)
-		case ErrInvalidFileName:
-			http.Error(w, err.Error(), http.StatusBadRequest)
-		case ErrFileExists:
-			http.Error(w, err.Error(), http.StatusConflict)
-		default:
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		n, err := taildropMgr.PutFileFrom(peerSender(h, ext), baseName, r.Body, offset, r.ContentLength)
+		if err != nil {
+			writePutError(w, err)
+			return
 		}
+		d := ext.Clock().Since(t0).Round(time.Second / 10)
+		h.Logf("got put of %s in %v from %v/%v", approxSize(n), d, h.RemoteAddr().Addr(), h.Peer().ComputedName)
+		io.WriteString(w, "{}\n")
 	default:
 		http.Error(w, "expected method GET or PUT", http.StatusMethodNotAllowed)
 	}
 }
 
+// handlePeerPutDir handles directory transfers. A transfer consists of:
+//
+//   - PUT /v0/put-dir/:transferID with the JSON [apitype.DirManifest]
+//   - PUT /v0/put-dir/:transferID/:escaped-path for each file in the manifest
+//   - POST /v0/put-dir/:transferID to verify and complete the transfer
+//
+// The directory only becomes visible once the final POST succeeds.
+func handlePeerPutDir(h ipnlocal.PeerAPIHandler, w http.ResponseWriter, r *http.Request) {
+	ext, ok := ipnlocal.GetExt[*Extension](h.LocalBackend())
+	if !ok {
+		http.Error(w, "miswired", http.StatusInternalServerError)
+		return
+	}
+	handlePeerPutDirWithBackend(h, ext, w, r)
+}
+
+func handlePeerPutDirWithBackend(h ipnlocal.PeerAPIHandler, ext extensionForPut, w http.ResponseWriter, r *http.Request) {
+	metricPutDirCalls.Add(1)
+
+	taildropMgr := ext.manager()
+	if taildropMgr == nil {
+		h.Logf("taildrop: no taildrop manager")
+		http.Error(w, "failed to get taildrop manager", http.StatusInternalServerError)
+		return
+	}
+	if !canPutFile(h) || !ext.hasCapFileSharing() {
+		http.Error(w, ErrNoTaildrop.Error(), http.StatusForbidden)
+		return
+	}
+	rest, ok := strings.CutPrefix(r.URL.EscapedPath(), "/v0/put-dir/")
+	if !ok {
+		http.Error(w, "misconfigured internals", http.StatusForbidden)
+		return
+	}
+	transferID, escapedPath, hasPath := strings.Cut(rest, "/")
+	relPath, err := url.PathUnescape(escapedPath)
+	if err != nil {
+		http.Error(w, ErrInvalidFileName.Error(), http.StatusBadRequest)
+		return
+	}
+	id := clientID(h.Peer().StableID())
+
+	switch {
+	case r.Method == "PUT" && !hasPath:
+		var manifest apitype.DirManifest
+		if err := json.NewDecoder(io.LimitReader(r.Body, 64<<20)).Decode(&manifest); err != nil {
+			http.Error(w, ErrInvalidManifest.Error(), http.StatusBadRequest)
+			return
+		}
+		err = taildropMgr.PutDirManifestFrom(peerSender(h, ext), transferID, &manifest)
+	case r.Method == "PUT" && hasPath:
+		err = taildropMgr.PutDirFile(id, transferID, relPath, r.Body, r.ContentLength)
+	case r.Method == "POST" && !hasPath:
+		if _, err = taildropMgr.CommitDir(id, transferID); err == nil {
+			h.Logf("got dir put from %v/%v", h.RemoteAddr().Addr(), h.Peer().ComputedName)
+		}
+	default:
+		http.Error(w, "expected method PUT or POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		writePutError(w, err)
+		return
+	}
+	io.WriteString(w, "{}\n")
+}
+
 func approxSize(n int64) string {
 	if n <= 1<<10 {
 		return "<=1KB"
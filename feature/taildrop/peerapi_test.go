@@ -57,12 +57,16 @@ type fakeExtension struct {
 	capFileSharing bool
 	clock          tstime.Clock
 	taildrop       *manager
+	peerLogin      string
 }
 
 func (lb *fakeExtension) manager() *manager {
 	return lb.taildrop
 }
 func (lb *fakeExtension) Clock() tstime.Clock { return lb.clock }
+func (lb *fakeExtension) peerLoginName(tailcfg.NodeView) string {
+	return lb.peerLogin
+}
 func (lb *fakeExtension) hasCapFileSharing() bool {
 	return lb.capFileSharing
 }
@@ -0,0 +1,307 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package taildrop
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tailscale/hujson"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tstime"
+	"tailscale.com/util/mak"
+)
+
+var (
+	ErrSenderNotAllowed    = errors.New("sender not allowed to send files to this node")
+	ErrFileTooLarge        = errors.New("file exceeds maximum allowed size")
+	ErrQuotaExceeded       = errors.New("sender quota exceeded")
+	ErrExtensionNotAllowed = errors.New("file type not allowed")
+)
+
+// defaultQuotaPeriod is the ReceivePolicy.QuotaPeriod used if none is set.
+const defaultQuotaPeriod = 24 * time.Hour
+
+// ReceivePolicy restricts which files this node accepts via Taildrop.
+// The zero value accepts everything, subject to the usual
+// file sharing capability checks.
+type ReceivePolicy struct {
+	// AllowSenders, if non-empty, is the list of senders that may send
+	// files. Each entry is "*", a tag ("tag:foo"), a user login name
+	// ("alice@example.com"), a node name ("laptop" or
+	// "laptop.tailnet.ts.net"), or "autogroup:self" for the
+	// untagged nodes of this node's owner.
+	AllowSenders []string `json:",omitempty"`
+
+	// DenySenders is the list of senders that may not send files,
+	// in the same format as AllowSenders. It takes precedence
+	// over AllowSenders.
+	DenySenders []string `json:",omitempty"`
+
+	// MaxFileSize, if positive, is the maximum size in bytes of a
+	// single received file.
+	MaxFileSize int64 `json:",omitempty"`
+
+	// SenderQuota, if positive, is the number of bytes each sending
+	// node may send during each QuotaPeriod.
+	SenderQuota int64 `json:",omitempty"`
+
+	// QuotaPeriod is the period over which SenderQuota applies.
+	// If zero, it defaults to 24 hours.
+	QuotaPeriod tstime.GoDuration `json:",omitzero"`
+
+	// AllowedExtensions, if non-empty, is the list of file extensions
+	// (such as ".pdf") that may be received. Matching is case-insensitive.
+	AllowedExtensions []string `json:",omitempty"`
+
+	// PerSenderDirs, if true, moves received files into a subdirectory
+	// named after the sending node, such as "taildrop-from-laptop".
+	// Directory transfers are not moved. These subdirectories are not
+	// reported as waiting directory transfers.
+	//
+	// It only applies in [Handler.DirectFileMode] and is ignored
+	// otherwise, as files waiting to be retrieved through the LocalAPI
+	// must stay at the top of the Taildrop directory.
+	PerSenderDirs bool `json:",omitempty"`
+}
+
+// LoadReceivePolicy reads a ReceivePolicy from the HuJSON file at path.
+func LoadReceivePolicy(path string) (*ReceivePolicy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	b, err = hujson.Standardize(b)
+	if err != nil {
+		return nil, fmt.Errorf("parsing Taildrop policy %q: %w", path, err)
+	}
+	p := new(ReceivePolicy)
+	if err := json.Unmarshal(b, p); err != nil {
+		return nil, fmt.Errorf("parsing Taildrop policy %q: %w", path, err)
+	}
+	for _, ext := range p.AllowedExtensions {
+		if !strings.HasPrefix(ext, ".") || strings.ContainsAny(ext, `/\`) {
+			return nil, fmt.Errorf("Taildrop policy %q: invalid extension %q; must start with a dot", path, ext)
+		}
+	}
+	return p, nil
+}
+
+// sender describes the node sending files, for matching against a ReceivePolicy.
+type sender struct {
+	id     clientID
+	name   string // node name, such as "laptop.tailnet.ts.net."; may be empty
+	login  string // login name of the node's owner; empty for tagged nodes
+	tags   []string
+	isSelf bool // whether the node is an untagged node of this node's owner
+}
+
+// senderFromPeer returns the sender for peer, whose owner has the given
+// login name.
+func senderFromPeer(peer tailcfg.NodeView, login string, isSelf bool) sender {
+	return sender{
+		id:     clientID(peer.StableID()),
+		name:   peer.Name(),
+		login:  login,
+		tags:   peer.Tags().AsSlice(),
+		isSelf: isSelf,
+	}
+}
+
+// matches reports whether s is matched by the policy sender entry pat.
+func (s sender) matches(pat string) bool {
+	switch {
+	case pat == "*":
+		return true
+	case pat == "autogroup:self":
+		return s.isSelf
+	case strings.HasPrefix(pat, "tag:"):
+		return slices.Contains(s.tags, pat)
+	case strings.Contains(pat, "@"):
+		return s.login != "" && strings.EqualFold(s.login, pat)
+	}
+	name := strings.TrimSuffix(s.name, ".")
+	pat = strings.TrimSuffix(pat, ".")
+	if strings.EqualFold(name, pat) {
+		return true
+	}
+	host, _, _ := strings.Cut(name, ".")
+	return host != "" && strings.EqualFold(host, pat)
+}
+
+// senderDirPrefix is the prefix of the per-sender subdirectories used for
+// ReceivePolicy.PerSenderDirs. Received directory transfers may not use it,
+// so the two never collide.
+const senderDirPrefix = "taildrop-from-"
+
+// isSenderDir reports whether name is a per-sender subdirectory.
+func isSenderDir(name string) bool {
+	return strings.HasPrefix(name, senderDirPrefix)
+}
+
+// subdir returns the name of the per-sender subdirectory that files from s
+// are placed in when ReceivePolicy.PerSenderDirs is set.
+func (s sender) subdir() string {
+	host, _, _ := strings.Cut(s.name, ".")
+	if host != "" && validateBaseName(host) == nil {
+		return senderDirPrefix + host
+	}
+	return senderDirPrefix + string(s.id)
+}
+
+// checkSender reports whether p permits files from s.
+func (p *ReceivePolicy) checkSender(s sender) error {
+	if p == nil {
+		return nil
+	}
+	if slices.ContainsFunc(p.DenySenders, s.matches) {
+		return ErrSenderNotAllowed
+	}
+	if len(p.AllowSenders) > 0 && !slices.ContainsFunc(p.AllowSenders, s.matches) {
+		return ErrSenderNotAllowed
+	}
+	return nil
+}
+
+// checkFile reports whether p permits a file named baseName of the given
+// length, which may be negative if unknown.
+func (p *ReceivePolicy) checkFile(baseName string, length int64) error {
+	if p == nil {
+		return nil
+	}
+	if p.MaxFileSize > 0 && length > p.MaxFileSize {
+		return fmt.Errorf("%w: %d bytes > %d bytes", ErrFileTooLarge, length, p.MaxFileSize)
+	}
+	if len(p.AllowedExtensions) > 0 {
+		ext := path.Ext(baseName)
+		if !slices.ContainsFunc(p.AllowedExtensions, func(e string) bool { return strings.EqualFold(e, ext) }) {
+			return fmt.Errorf("%w: %q", ErrExtensionNotAllowed, ext)
+		}
+	}
+	return nil
+}
+
+func (p *ReceivePolicy) quotaPeriod() time.Duration {
+	if p.QuotaPeriod.Duration > 0 {
+		return p.QuotaPeriod.Duration
+	}
+	return defaultQuotaPeriod
+}
+
+// QuotaError is returned when a sender has exceeded its ReceivePolicy.SenderQuota.
+// It wraps [ErrQuotaExceeded].
+type QuotaError struct {
+	// RetryAfter is how long until the sender's quota resets.
+	RetryAfter time.Duration
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("%v; try again in %v", ErrQuotaExceeded, e.RetryAfter.Round(time.Second))
+}
+
+func (e *QuotaError) Unwrap() error { return ErrQuotaExceeded }
+
+// quotaTracker tracks the bytes received from each sender
+// during the current ReceivePolicy.QuotaPeriod.
+type quotaTracker struct {
+	mu    sync.Mutex
+	usage map[clientID]*senderUsage
+}
+
+type senderUsage struct {
+	periodStart time.Time
+	bytes       int64
+}
+
+// check reports whether n more bytes from id at time now would fit in the
+// sender's quota, returning a *QuotaError if not.
+func (q *quotaTracker) check(p *ReceivePolicy, id clientID, now time.Time, n int64) error {
+	return q.use(p, id, now, n, false)
+}
+
+// add is like check but also records the n bytes as used if they fit.
+func (q *quotaTracker) add(p *ReceivePolicy, id clientID, now time.Time, n int64) error {
+	return q.use(p, id, now, n, true)
+}
+
+func (q *quotaTracker) use(p *ReceivePolicy, id clientID, now time.Time, n int64, record bool) error {
+	if p == nil || p.SenderQuota <= 0 {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	period := p.quotaPeriod()
+	u := q.usage[id]
+	if u == nil || now.Sub(u.periodStart) >= period {
+		u = &senderUsage{periodStart: now}
+		mak.Set(&q.usage, id, u)
+	}
+	if u.bytes+n > p.SenderQuota {
+		return &QuotaError{RetryAfter: u.periodStart.Add(period).Sub(now)}
+	}
+	if record {
+		u.bytes += n
+	}
+	return nil
+}
+
+// policyWriter enforces ReceivePolicy limits on the bytes of a file
+// as they're written.
+type policyWriter struct {
+	w         io.Writer
+	m         *manager
+	id        clientID
+	remaining int64 // bytes left before MaxFileSize is exceeded, or -1 for no limit
+}
+
+// newPolicyWriter returns a writer that writes to w on behalf of id,
+// for a file that already has offset bytes.
+func (m *manager) newPolicyWriter(w io.Writer, id clientID, offset int64) *policyWriter {
+	pw := &policyWriter{w: w, m: m, id: id, remaining: -1}
+	if max := m.opts.Policy.MaxFileSize; max > 0 {
+		pw.remaining = max - offset
+	}
+	return pw
+}
+
+func (pw *policyWriter) Write(p []byte) (int, error) {
+	if pw.remaining >= 0 && int64(len(p)) > pw.remaining {
+		return 0, fmt.Errorf("%w: more than %d bytes", ErrFileTooLarge, pw.m.opts.Policy.MaxFileSize)
+	}
+	if err := pw.m.quota.add(pw.m.opts.Policy, pw.id, pw.m.opts.Clock.Now(), int64(len(p))); err != nil {
+		return 0, err
+	}
+	if pw.remaining >= 0 {
+		pw.remaining -= int64(len(p))
+	}
+	return pw.w.Write(p)
+}
+
+// subdirFileOps is implemented by FileOps that can place completed files
+// in a subdirectory, for [ReceivePolicy.PerSenderDirs].
+type subdirFileOps interface {
+	// RenameInto is like [FileOps.Rename] but places the file in
+	// the subdirectory dir, creating it if needed.
+	RenameInto(oldPath, dir, newName string) (newPath string, err error)
+}
+
+// renameFrom moves the completed partial file at partialPath sent by s
+// to its final name, honoring [ReceivePolicy.PerSenderDirs].
+func (m *manager) renameFrom(s sender, partialPath, baseName string) (string, error) {
+	if p := m.opts.Policy; p != nil && p.PerSenderDirs && m.opts.DirectFileMode {
+		if sops, ok := m.opts.fileOps.(subdirFileOps); ok {
+			return sops.RenameInto(partialPath, s.subdir(), baseName)
+		}
+		m.opts.Logf("per-sender directories not supported by Taildrop storage; ignoring")
+	}
+	return m.opts.fileOps.Rename(partialPath, baseName)
+}
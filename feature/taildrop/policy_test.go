@@ -0,0 +1,234 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package taildrop
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/tstest"
+	"tailscale.com/tstime"
+	"tailscale.com/util/must"
+)
+
+func TestSenderMatches(t *testing.T) {
+	s := sender{
+		id:    "nSTABLE",
+		name:  "laptop.example.ts.net.",
+		login: "alice@example.com",
+		tags:  []string{"tag:ci"},
+	}
+	tests := []struct {
+		pat  string
+		want bool
+	}{
+		{"*", true},
+		{"tag:ci", true},
+		{"tag:prod", false},
+		{"alice@example.com", true},
+		{"ALICE@example.com", true},
+		{"bob@example.com", false},
+		{"laptop", true},
+		{"laptop.example.ts.net", true},
+		{"laptop.example.ts.net.", true},
+		{"desktop", false},
+		{"autogroup:self", false},
+	}
+	for _, tt := range tests {
+		if got := s.matches(tt.pat); got != tt.want {
+			t.Errorf("matches(%q) = %v; want %v", tt.pat, got, tt.want)
+		}
+	}
+	if got, want := s.subdir(), "taildrop-from-laptop"; got != want {
+		t.Errorf("subdir = %q; want %q", got, want)
+	}
+	if got, want := (sender{id: "nSTABLE"}).subdir(), "taildrop-from-nSTABLE"; got != want {
+		t.Errorf("subdir without name = %q; want %q", got, want)
+	}
+}
+
+func TestReceivePolicyCheckSender(t *testing.T) {
+	alice := sender{login: "alice@example.com", isSelf: true}
+	ci := sender{tags: []string{"tag:ci"}}
+
+	p := &ReceivePolicy{AllowSenders: []string{"autogroup:self"}}
+	if err := p.checkSender(alice); err != nil {
+		t.Errorf("alice: %v", err)
+	}
+	if err := p.checkSender(ci); !errors.Is(err, ErrSenderNotAllowed) {
+		t.Errorf("ci: got %v; want ErrSenderNotAllowed", err)
+	}
+
+	p = &ReceivePolicy{AllowSenders: []string{"*"}, DenySenders: []string{"tag:ci"}}
+	if err := p.checkSender(alice); err != nil {
+		t.Errorf("alice: %v", err)
+	}
+	if err := p.checkSender(ci); !errors.Is(err, ErrSenderNotAllowed) {
+		t.Errorf("ci: got %v; want ErrSenderNotAllowed", err)
+	}
+
+	var nilPolicy *ReceivePolicy
+	if err := nilPolicy.checkSender(ci); err != nil {
+		t.Errorf("nil policy: %v", err)
+	}
+}
+
+func TestQuotaTracker(t *testing.T) {
+	p := &ReceivePolicy{SenderQuota: 10, QuotaPeriod: tstime.GoDuration{Duration: time.Hour}}
+	var q quotaTracker
+	t0 := time.Unix(1700000000, 0)
+
+	if err := q.add(p, "a", t0, 6); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.check(p, "a", t0, 4); err != nil {
+		t.Errorf("check within quota: %v", err)
+	}
+	if err := q.add(p, "b", t0, 10); err != nil {
+		t.Errorf("other sender: %v", err)
+	}
+	err := q.add(p, "a", t0.Add(15*time.Minute), 5)
+	var qe *QuotaError
+	if !errors.As(err, &qe) || !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("over quota: got %v; want QuotaError", err)
+	}
+	if qe.RetryAfter != 45*time.Minute {
+		t.Errorf("RetryAfter = %v; want 45m", qe.RetryAfter)
+	}
+	if err := q.add(p, "a", t0.Add(time.Hour), 10); err != nil {
+		t.Errorf("after period reset: %v", err)
+	}
+}
+
+func TestLoadReceivePolicy(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "policy.hujson")
+	must.Do(os.WriteFile(path, []byte(`{
+		// Only accept documents from CI, into per-sender directories.
+		"AllowSenders": ["tag:ci"],
+		"MaxFileSize": 1048576,
+		"SenderQuota": 10485760,
+		"QuotaPeriod": "1h",
+		"AllowedExtensions": [".pdf", ".txt"],
+		"PerSenderDirs": true,
+	}`), 0o600))
+	p, err := LoadReceivePolicy(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.QuotaPeriod.Duration != time.Hour || !p.PerSenderDirs || p.MaxFileSize != 1<<20 || len(p.AllowedExtensions) != 2 {
+		t.Errorf("unexpected policy: %+v", p)
+	}
+
+	must.Do(os.WriteFile(path, []byte(`{"AllowedExtensions": ["pdf"]}`), 0o600))
+	if _, err := LoadReceivePolicy(path); err == nil {
+		t.Error("extension without dot: got nil error")
+	}
+}
+
+func TestHandlePeerPutPolicy(t *testing.T) {
+	policy := &ReceivePolicy{
+		DenySenders:       []string{"mallory@example.com"},
+		MaxFileSize:       10,
+		SenderQuota:       12,
+		AllowedExtensions: []string{".txt"},
+		PerSenderDirs:     true,
+	}
+	dir := t.TempDir()
+	m := managerOptions{Logf: t.Logf, fileOps: must.Get(newFileOps(dir)), Policy: policy, DirectFileMode: true}.New()
+	defer m.Shutdown()
+	ext := &fakeExtension{
+		logf:           t.Logf,
+		capFileSharing: true,
+		clock:          &tstest.Clock{},
+		taildrop:       m,
+		peerLogin:      "alice@example.com",
+	}
+	peer := &tailcfg.Node{
+		StableID:     "nALICE",
+		Name:         "laptop.example.ts.net.",
+		ComputedName: "laptop",
+	}
+	ph := &peerAPIHandler{
+		isSelf:   true,
+		selfNode: (&tailcfg.Node{Addresses: []netip.Prefix{netip.MustParsePrefix("100.100.100.101/32")}}).View(),
+		peerNode: peer.View(),
+	}
+	put := func(name, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handlePeerPutWithBackend(ph, ext, rr, httptest.NewRequest("PUT", "/v0/put/"+name, strings.NewReader(body)))
+		return rr
+	}
+
+	if rr := put("a.txt", "hello"); rr.Code != http.StatusOK {
+		t.Fatalf("allowed put = %d: %s", rr.Code, rr.Body)
+	}
+	if got := string(must.Get(os.ReadFile(filepath.Join(dir, "taildrop-from-laptop", "a.txt")))); got != "hello" {
+		t.Errorf("per-sender file contents = %q", got)
+	}
+	if rr := put("b.exe", "hi"); rr.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("disallowed extension = %d; want 415", rr.Code)
+	}
+	if rr := put("c.txt", "way too long for the limit"); rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("large file = %d; want 413", rr.Code)
+	}
+	if rr := put("d.txt", "0123456789"); rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") == "" {
+		t.Errorf("over quota = %d, Retry-After %q; want 429 with Retry-After", rr.Code, rr.Header().Get("Retry-After"))
+	}
+	if _, err := os.Stat(filepath.Join(dir, "taildrop-from-laptop", "d.txt")); !os.IsNotExist(err) {
+		t.Errorf("rejected file exists: %v", err)
+	}
+
+	ext.peerLogin = "mallory@example.com"
+	if rr := put("e.txt", "x"); rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), ErrSenderNotAllowed.Error()) {
+		t.Errorf("blocked sender = %d %q; want 403", rr.Code, rr.Body)
+	}
+}
+
+func TestPerSenderDirsIgnoredForWaitingFiles(t *testing.T) {
+	dir := t.TempDir()
+	m := managerOptions{Logf: t.Logf, fileOps: must.Get(newFileOps(dir)), Policy: &ReceivePolicy{PerSenderDirs: true}}.New()
+	defer m.Shutdown()
+	ext := &fakeExtension{
+		logf:           t.Logf,
+		capFileSharing: true,
+		clock:          &tstest.Clock{},
+		taildrop:       m,
+		peerLogin:      "alice@example.com",
+	}
+	ph := &peerAPIHandler{
+		isSelf:   true,
+		selfNode: (&tailcfg.Node{Addresses: []netip.Prefix{netip.MustParsePrefix("100.100.100.101/32")}}).View(),
+		peerNode: (&tailcfg.Node{StableID: "nALICE", Name: "laptop.example.ts.net."}).View(),
+	}
+	rr := httptest.NewRecorder()
+	handlePeerPutWithBackend(ph, ext, rr, httptest.NewRequest("PUT", "/v0/put/a.txt", strings.NewReader("hello")))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("put = %d: %s", rr.Code, rr.Body)
+	}
+
+	// Without DirectFileMode, the file must stay where the LocalAPI can
+	// list and retrieve it.
+	wfs := must.Get(m.WaitingFiles())
+	if len(wfs) != 1 || wfs[0].Name != "a.txt" {
+		t.Fatalf("WaitingFiles = %+v; want a.txt", wfs)
+	}
+	rc, _, err := m.OpenFile("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if got := string(must.Get(io.ReadAll(rc))); got != "hello" {
+		t.Errorf("contents = %q; want hello", got)
+	}
+}
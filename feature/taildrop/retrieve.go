@@ -53,6 +53,14 @@ func (m *manager) HasFilesWaiting() bool {
 		// Found at least one downloadable file
 		return true
 	}
+	if dops, ok := m.opts.fileOps.(dirFileOps); ok {
+		dirs, _ := dops.ListDirs()
+		for _, name := range dirs {
+			if !isPartialOrDeleted(name) && !isSenderDir(name) {
+				return true
+			}
+		}
+	}
 
 	// No waiting files → update negative‑result cache
 	m.emptySince.Store(total)
@@ -91,6 +99,11 @@ func (m *manager) WaitingFiles() ([]apitype.WaitingFile, error) {
 			Size: fi.Size(),
 		})
 	}
+	dirs, err := m.waitingDirs()
+	if err != nil {
+		return nil, err
+	}
+	ret = append(ret, dirs...)
 	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
 	return ret, nil
 }
@@ -105,11 +118,18 @@ func (m *manager) DeleteFile(baseName string) error {
 		return errors.New("deletes not allowed in direct mode")
 	}
 
+	remove := m.opts.fileOps.Remove
+	if dops, ok := m.opts.fileOps.(dirFileOps); ok {
+		if fi, err := dops.Stat(baseName); err == nil && fi.IsDir() {
+			remove = dops.RemoveTree
+		}
+	}
+
 	var bo *backoff.Backoff
 	logf := m.opts.Logf
 	t0 := m.opts.Clock.Now()
 	for {
-		err := m.opts.fileOps.Remove(baseName)
+		err := remove(baseName)
 		if err != nil && !os.IsNotExist(err) {
 			err = redactError(err)
 			// Put a retry loop around deletes on Windows.
@@ -170,5 +190,9 @@ func (m *manager) OpenFile(baseName string) (rc io.ReadCloser, size int64, err e
 		f.Close()
 		return nil, 0, redactError(err)
 	}
+	if fi.IsDir() {
+		f.Close()
+		return nil, 0, errors.New("is a directory; use OpenDir")
+	}
 	return f, fi.Size(), nil
 }
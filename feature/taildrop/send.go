@@ -71,6 +71,13 @@ func (f *incomingFile) Write(p []byte) (n int, err error) {
 // a partial file. While resuming, PutFile may be called again with a non-zero
 // offset to specify where to resume receiving data at.
 func (m *manager) PutFile(id clientID, baseName string, r io.Reader, offset, length int64) (fileLength int64, err error) {
+	return m.PutFileFrom(sender{id: id}, baseName, r, offset, length)
+}
+
+// PutFileFrom is like [manager.PutFile] but takes a full description of the
+// sender, which is checked against the configured [ReceivePolicy].
+func (m *manager) PutFileFrom(s sender, baseName string, r io.Reader, offset, length int64) (fileLength int64, err error) {
+	id := s.id
 
 	switch {
 	case m == nil || m.opts.fileOps == nil:
@@ -84,6 +91,18 @@ func (m *manager) PutFile(id clientID, baseName string, r io.Reader, offset, len
 	if err := validateBaseName(baseName); err != nil {
 		return 0, err
 	}
+	policy := m.opts.Policy
+	if err := policy.checkSender(s); err != nil {
+		return 0, err
+	}
+	if err := policy.checkFile(baseName, max(length, 0)+offset); err != nil {
+		return 0, err
+	}
+	if length > 0 {
+		if err := m.quota.check(policy, id, m.opts.Clock.Now(), length); err != nil {
+			return 0, err
+		}
+	}
 
 	// and make sure we don't delete it while uploading:
 	m.deleter.Remove(baseName)
@@ -135,7 +154,11 @@ func (m *manager) PutFile(id clientID, baseName string, r io.Reader, offset, len
 	}
 
 	// Copy the contents of the file to the writer.
-	copyLength, err := io.Copy(wc, r)
+	var dst io.Writer = wc
+	if policy != nil {
+		dst = m.newPolicyWriter(wc, id, offset)
+	}
+	copyLength, err := io.Copy(dst, r)
 	if err != nil {
 		return 0, m.redactAndLogError("Copy", err)
 	}
@@ -153,7 +176,7 @@ func (m *manager) PutFile(id clientID, baseName string, r io.Reader, offset, len
 	inFile.mu.Unlock()
 
 	// 6) Finalize (rename/move) the partial into place via FileOps.Rename
-	finalPath, err := m.opts.fileOps.Rename(partialPath, baseName)
+	finalPath, err := m.renameFrom(s, partialPath, baseName)
 	if err != nil {
 		return 0, m.redactAndLogError("Rename", err)
 	}
@@ -19,6 +19,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"unicode"
 	"unicode/utf8"
@@ -88,6 +89,10 @@ type managerOptions struct {
 	// use fsFileOps.
 	fileOps FileOps
 
+	// Policy restricts which files are accepted.
+	// It may be nil to accept all files.
+	Policy *ReceivePolicy
+
 	// SendFileNotify is called periodically while a file is actively
 	// receiving the contents for the file. There is a final call
 	// to the function when reception completes.
@@ -101,6 +106,13 @@ type manager struct {
 
 	// incomingFiles is a map of files actively being received.
 	incomingFiles syncs.Map[incomingFileKey, *incomingFile]
+	// incomingDirs is a map of directory transfers actively being received.
+	incomingDirs syncs.Map[incomingDirKey, *incomingDir]
+	// incomingDirsMu serializes adding to incomingDirs, so that the
+	// number of transfers per sender can be bounded.
+	incomingDirsMu sync.Mutex
+	// quota tracks per-sender usage for opts.Policy.
+	quota quotaTracker
 	// deleter managers asynchronous deletion of files.
 	deleter fileDeleter
 
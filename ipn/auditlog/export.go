@@ -0,0 +1,210 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package auditlog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+)
+
+// ExportedLog is the JSON form of an audit log written by the export
+// transports returned by [NewFileTransport], [NewSyslogTransport] and
+// [NewWebhookTransport].
+type ExportedLog struct {
+	// Action is the action that was audited.
+	Action tailcfg.ClientAuditAction `json:"action"`
+	// Details is the action-specific detail string, if any.
+	Details string `json:"details,omitempty"`
+	// Timestamp is when the action happened on the node.
+	Timestamp time.Time `json:"timestamp"`
+	// Hostname is the OS hostname of the node.
+	Hostname string `json:"hostname,omitempty"`
+}
+
+func exportedLog(req tailcfg.AuditLogRequest) ExportedLog {
+	hostname, _ := os.Hostname()
+	return ExportedLog{
+		Action:    req.Action,
+		Details:   req.Details,
+		Timestamp: req.Timestamp,
+		Hostname:  hostname,
+	}
+}
+
+// retryableError is an error from an export transport that's
+// likely to be transient. See [IsRetryableError].
+type retryableError struct {
+	err error
+}
+
+func (e retryableError) Error() string   { return e.err.Error() }
+func (e retryableError) Unwrap() error   { return e.err }
+func (e retryableError) Retryable() bool { return true }
+
+// fileTransport is a [Transport] that appends audit logs
+// to a file as JSON lines.
+type fileTransport struct {
+	path string
+	mu   sync.Mutex // serializes appends
+}
+
+// NewFileTransport returns a [Transport] that appends each audit log to
+// the file at path as a line of JSON (see [ExportedLog]). The file is
+// created with mode 0600 if it doesn't exist, and is opened for each log
+// so that it can be rotated externally.
+func NewFileTransport(path string) Transport {
+	return &fileTransport{path: path}
+}
+
+func (t *fileTransport) SendAuditLog(_ context.Context, req tailcfg.AuditLogRequest) error {
+	line, err := json.Marshal(exportedLog(req))
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f, err := os.OpenFile(t.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return retryableError{err}
+	}
+	_, err = f.Write(line)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return retryableError{err}
+	}
+	return nil
+}
+
+// webhookTransport is a [Transport] that POSTs audit logs to a URL.
+type webhookTransport struct {
+	url string
+	hc  *http.Client
+}
+
+// NewWebhookTransport returns a [Transport] that POSTs each audit log to
+// the http or https URL rawURL as a JSON object (see [ExportedLog]).
+// Any 2xx response is success. Network errors and 429 and 5xx responses
+// are retryable; other responses fail permanently.
+//
+// If hc is nil, a client with a 30 second timeout is used.
+func NewWebhookTransport(rawURL string, hc *http.Client) (Transport, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook URL: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid webhook URL %q: must be an absolute http or https URL", rawURL)
+	}
+	if hc == nil {
+		hc = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &webhookTransport{url: rawURL, hc: hc}, nil
+}
+
+func (t *webhookTransport) SendAuditLog(ctx context.Context, req tailcfg.AuditLogRequest) error {
+	body, err := json.Marshal(exportedLog(req))
+	if err != nil {
+		return err
+	}
+	hreq, err := http.NewRequestWithContext(ctx, "POST", t.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	hreq.Header.Set("Content-Type", "application/json")
+	res, err := t.hc.Do(hreq)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return retryableError{err}
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 4<<10))
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		return nil
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500:
+		return retryableError{fmt.Errorf("webhook: %s", res.Status)}
+	default:
+		return fmt.Errorf("webhook: %s", res.Status)
+	}
+}
+
+// exportStore is a [LogStore] that keeps the queue of an export
+// separately from the control plane's queue in the same underlying store.
+type exportStore struct {
+	LogStore
+	name string
+}
+
+func (s exportStore) key(key ipn.ProfileID) (ipn.ProfileID, error) {
+	if key == "" {
+		return "", errors.New("empty key")
+	}
+	return ipn.ProfileID("export-" + s.name + "-" + string(key)), nil
+}
+
+func (s exportStore) save(key ipn.ProfileID, txns []*transaction) error {
+	k, err := s.key(key)
+	if err != nil {
+		return err
+	}
+	return s.LogStore.save(k, txns)
+}
+
+func (s exportStore) load(key ipn.ProfileID) ([]*transaction, error) {
+	k, err := s.key(key)
+	if err != nil {
+		return nil, err
+	}
+	return s.LogStore.load(k)
+}
+
+// export is a destination that audit logs are exported to.
+type export struct {
+	name      string // "file", "syslog" or "webhook"; used in the store key and logs
+	transport Transport
+}
+
+// newExports returns the exports configured by conf, which may be nil.
+func newExports(conf *ipn.AuditLogConfig) ([]export, error) {
+	if conf == nil {
+		return nil, nil
+	}
+	var exports []export
+	if conf.File != "" {
+		exports = append(exports, export{"file", NewFileTransport(conf.File)})
+	}
+	if conf.Syslog {
+		t, err := NewSyslogTransport()
+		if err != nil {
+			return nil, err
+		}
+		exports = append(exports, export{"syslog", t})
+	}
+	if conf.Webhook != "" {
+		t, err := NewWebhookTransport(conf.Webhook, nil)
+		if err != nil {
+			return nil, err
+		}
+		exports = append(exports, export{"webhook", t})
+	}
+	return exports, nil
+}
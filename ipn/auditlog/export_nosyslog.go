@@ -0,0 +1,17 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build windows || plan9
+
+package auditlog
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// NewSyslogTransport returns an error, as syslog isn't supported
+// on this platform.
+func NewSyslogTransport() (Transport, error) {
+	return nil, fmt.Errorf("syslog audit log export is not supported on %s", runtime.GOOS)
+}
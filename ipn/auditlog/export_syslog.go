@@ -0,0 +1,51 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !windows && !plan9
+
+package auditlog
+
+import (
+	"context"
+	"encoding/json"
+	"log/syslog"
+	"sync"
+
+	"tailscale.com/tailcfg"
+)
+
+// syslogTransport is a [Transport] that sends audit logs to the local
+// syslog daemon.
+type syslogTransport struct {
+	mu sync.Mutex
+	w  *syslog.Writer // nil until the first successful connection
+}
+
+// NewSyslogTransport returns a [Transport] that sends each audit log to
+// the local syslog daemon as a JSON object (see [ExportedLog]), with the
+// tag "tailscaled-audit" and the LOG_AUTH facility at LOG_NOTICE
+// priority. Failing to reach the daemon is a retryable error.
+func NewSyslogTransport() (Transport, error) {
+	return &syslogTransport{}, nil
+}
+
+func (t *syslogTransport) SendAuditLog(_ context.Context, req tailcfg.AuditLogRequest) error {
+	msg, err := json.Marshal(exportedLog(req))
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.w == nil {
+		w, err := syslog.New(syslog.LOG_AUTH|syslog.LOG_NOTICE, "tailscaled-audit")
+		if err != nil {
+			return retryableError{err}
+		}
+		t.w = w
+	}
+	// The syslog.Writer reconnects on its own if a write fails.
+	if err := t.w.Notice(string(msg)); err != nil {
+		return retryableError{err}
+	}
+	return nil
+}
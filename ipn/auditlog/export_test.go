@@ -0,0 +1,232 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package auditlog
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/store/mem"
+	"tailscale.com/tailcfg"
+	"tailscale.com/util/must"
+)
+
+func TestFileTransport(t *testing.T) {
+	c := qt.New(t)
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	tr := NewFileTransport(path)
+
+	ts := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, details := range []string{"first", "second"} {
+		err := tr.SendAuditLog(context.Background(), tailcfg.AuditLogRequest{
+			Action:    tailcfg.AuditNodeDisconnect,
+			Details:   details,
+			Timestamp: ts,
+		})
+		c.Assert(err, qt.IsNil)
+	}
+
+	f, err := os.Open(path)
+	c.Assert(err, qt.IsNil)
+	defer f.Close()
+	var got []ExportedLog
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var l ExportedLog
+		c.Assert(json.Unmarshal(sc.Bytes(), &l), qt.IsNil)
+		got = append(got, l)
+	}
+	c.Assert(got, qt.HasLen, 2)
+	c.Assert(got[0].Action, qt.Equals, tailcfg.AuditNodeDisconnect)
+	c.Assert(got[0].Details, qt.Equals, "first")
+	c.Assert(got[1].Details, qt.Equals, "second")
+	c.Assert(got[1].Timestamp.Equal(ts), qt.IsTrue)
+
+	fi, err := os.Stat(path)
+	c.Assert(err, qt.IsNil)
+	c.Assert(fi.Mode().Perm(), qt.Equals, os.FileMode(0600))
+
+	// Failing to write is retryable.
+	tr = NewFileTransport(filepath.Join(t.TempDir(), "missing", "audit.jsonl"))
+	err = tr.SendAuditLog(context.Background(), tailcfg.AuditLogRequest{Action: tailcfg.AuditNodeDisconnect})
+	c.Assert(IsRetryableError(err), qt.IsTrue)
+}
+
+func TestWebhookTransport(t *testing.T) {
+	c := qt.New(t)
+
+	var mu sync.Mutex
+	status := http.StatusServiceUnavailable
+	var got []ExportedLog
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		var l ExportedLog
+		if err := json.NewDecoder(r.Body).Decode(&l); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		got = append(got, l)
+	}))
+	defer srv.Close()
+	setStatus := func(s int) {
+		mu.Lock()
+		defer mu.Unlock()
+		status = s
+	}
+
+	tr, err := NewWebhookTransport(srv.URL, srv.Client())
+	c.Assert(err, qt.IsNil)
+	req := tailcfg.AuditLogRequest{Action: tailcfg.AuditNodeDisconnect, Details: "d", Timestamp: time.Now()}
+
+	for _, tt := range []struct {
+		status    int
+		wantErr   bool
+		retryable bool
+	}{
+		{http.StatusServiceUnavailable, true, true},
+		{http.StatusTooManyRequests, true, true},
+		{http.StatusForbidden, true, false},
+		{http.StatusOK, false, false},
+	} {
+		setStatus(tt.status)
+		err := tr.SendAuditLog(context.Background(), req)
+		c.Assert(err != nil, qt.Equals, tt.wantErr, qt.Commentf("status %d: %v", tt.status, err))
+		c.Assert(IsRetryableError(err), qt.Equals, tt.retryable, qt.Commentf("status %d", tt.status))
+	}
+	mu.Lock()
+	c.Assert(got, qt.HasLen, 1)
+	c.Assert(got[0].Details, qt.Equals, "d")
+	mu.Unlock()
+
+	// Queued logs are retried with the logger's backoff until the
+	// webhook recovers.
+	setStatus(http.StatusBadGateway)
+	al := loggerForTest(t, Opts{
+		RetryLimit: 100,
+		Store:      exportStore{NewLogStore(&mem.Store{}), "webhook"},
+	})
+	al.backoffOpts = backoffOpts{min: time.Millisecond, max: 4 * time.Millisecond, multiplier: 2}
+	c.Assert(al.SetProfileID("test"), qt.IsNil)
+	c.Assert(al.Start(tr), qt.IsNil)
+	c.Assert(al.Enqueue(tailcfg.AuditNodeDisconnect, "retried"), qt.IsNil)
+	time.Sleep(20 * time.Millisecond)
+	setStatus(http.StatusOK)
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		mu.Lock()
+		n := len(got)
+		mu.Unlock()
+		if n == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for webhook retry")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	mu.Lock()
+	c.Assert(got[1].Details, qt.Equals, "retried")
+	mu.Unlock()
+
+	for _, bad := range []string{"", "ftp://example.com/", "/relative", "http://"} {
+		_, err := NewWebhookTransport(bad, nil)
+		c.Assert(err, qt.IsNotNil, qt.Commentf("%q", bad))
+	}
+}
+
+func TestExportStore(t *testing.T) {
+	c := qt.New(t)
+	base := NewLogStore(&mem.Store{})
+	file := exportStore{base, "file"}
+	webhook := exportStore{base, "webhook"}
+
+	txn := &transaction{EventID: "1", Action: tailcfg.AuditNodeDisconnect}
+	c.Assert(file.save("p", []*transaction{txn}), qt.IsNil)
+
+	for _, tt := range []struct {
+		s    LogStore
+		want int
+	}{
+		{file, 1},
+		{webhook, 0},
+		{base, 0},
+	} {
+		got, err := tt.s.load("p")
+		c.Assert(err, qt.IsNil)
+		c.Assert(got, qt.HasLen, tt.want)
+	}
+
+	_, err := file.load("")
+	c.Assert(err, qt.IsNotNil)
+}
+
+func TestNewExports(t *testing.T) {
+	c := qt.New(t)
+
+	exports, err := newExports(nil)
+	c.Assert(err, qt.IsNil)
+	c.Assert(exports, qt.HasLen, 0)
+
+	exports, err = newExports(&ipn.AuditLogConfig{
+		File:    filepath.Join(t.TempDir(), "audit.jsonl"),
+		Webhook: "https://audit.example.com/hook",
+	})
+	c.Assert(err, qt.IsNil)
+	var names []string
+	for _, ex := range exports {
+		names = append(names, ex.name)
+	}
+	c.Assert(names, qt.DeepEquals, []string{"file", "webhook"})
+
+	_, err = newExports(&ipn.AuditLogConfig{Webhook: "not a url"})
+	c.Assert(err, qt.IsNotNil)
+}
+
+func TestExtensionExports(t *testing.T) {
+	c := qt.New(t)
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	exports, err := newExports(&ipn.AuditLogConfig{File: path})
+	c.Assert(err, qt.IsNil)
+
+	e := &extension{logf: t.Logf, exports: exports}
+	e.store.Set(NewLogStore(&mem.Store{}))
+	control := newMockTransport(nil)
+	primary, err := e.startLogger(t.Logf, must.Get(e.getStore()), control, "test")
+	c.Assert(err, qt.IsNil)
+	e.logger = primary
+	e.exportLoggers = e.startExportLoggers("test")
+	c.Assert(e.exportLoggers, qt.HasLen, 1)
+
+	c.Assert(e.getCurrentLogger()(tailcfg.AuditNodeDisconnect, "both"), qt.IsNil)
+
+	primary.FlushAndStop(context.Background())
+	for _, l := range e.exportLoggers {
+		l.FlushAndStop(context.Background())
+	}
+	c.Assert(control.sentCount(), qt.Equals, 1)
+	b, err := os.ReadFile(path)
+	c.Assert(err, qt.IsNil)
+	var l ExportedLog
+	c.Assert(json.Unmarshal(b, &l), qt.IsNil)
+	c.Assert(l.Details, qt.Equals, "both")
+}
@@ -7,6 +7,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"runtime"
 	"time"
 
 	"tailscale.com/control/controlclient"
@@ -32,8 +33,12 @@ func init() {
 // extension is an [ipnext.Extension] managing audit logging
 // on platforms that import this package.
 // As of 2025-03-27, that's only Windows and macOS.
+// On Linux, it's only enabled if audit log exports are configured
+// in the tailscaled config file.
 type extension struct {
-	logf logger.Logf
+	logf    logger.Logf
+	varRoot string   // or empty if none
+	exports []export // additional destinations for audit logs
 
 	// store is the log store shared by all loggers.
 	// It is created when the first logger is started.
@@ -47,12 +52,32 @@ type extension struct {
 	//
 	// It queues, persists, and sends audit logs to the control client.
 	logger *Logger
+	// exportLoggers are the audit loggers for e.exports, in the same order.
+	// They're created and replaced along with logger.
+	exportLoggers []*Logger
 }
 
 // newExtension is an [ipnext.NewExtensionFn] that creates a new audit log extension.
 // It is registered with [ipnext.RegisterExtension] if the package is imported.
-func newExtension(logf logger.Logf, _ ipnext.SafeBackend) (ipnext.Extension, error) {
-	return &extension{logf: logger.WithPrefix(logf, featureName+": ")}, nil
+func newExtension(logf logger.Logf, sb ipnext.SafeBackend) (ipnext.Extension, error) {
+	e := &extension{logf: logger.WithPrefix(logf, featureName+": ")}
+	var conf *ipn.AuditLogConfig
+	if sb != nil {
+		e.varRoot = sb.TailscaleVarRoot()
+		if c := sb.Sys().InitialConfig; c != nil {
+			conf = c.Parsed.AuditLog
+		}
+	}
+	exports, err := newExports(conf)
+	if err != nil {
+		return nil, fmt.Errorf("audit log exports: %w", err)
+	}
+	e.exports = exports
+	if len(exports) == 0 && runtime.GOOS == "linux" {
+		// Audit logging is opt-in on Linux.
+		return nil, ipnext.SkipExtension
+	}
+	return e, nil
 }
 
 // Name implements [ipnext.Extension].
@@ -80,18 +105,54 @@ func (e *extension) startNewLogger(cc controlclient.Client, profileID ipn.Profil
 	if !ok {
 		return nil, fmt.Errorf("%T cannot be used as transport", cc)
 	}
+	store, err := e.getStore()
+	if err != nil {
+		return nil, err
+	}
+	return e.startLogger(e.logf, store, transport, profileID)
+}
+
+// startExportLoggers creates and starts a logger for each of e.exports.
+// Each has its own queue in the log store, so that an export that's down
+// doesn't delay the others or the control plane. Exports that fail to
+// start are logged and skipped.
+func (e *extension) startExportLoggers(profileID ipn.ProfileID) []*Logger {
+	if len(e.exports) == 0 {
+		return nil
+	}
+	store, err := e.getStore()
+	if err != nil {
+		e.logf("[unexpected] %v", err)
+		return nil
+	}
+	var loggers []*Logger
+	for _, ex := range e.exports {
+		logf := logger.WithPrefix(e.logf, ex.name+": ")
+		l, err := e.startLogger(logf, exportStore{store, ex.name}, ex.transport, profileID)
+		if err != nil {
+			e.logf("[unexpected] %s export: %v", ex.name, err)
+			continue
+		}
+		loggers = append(loggers, l)
+	}
+	return loggers
+}
 
-	// Create a new log store if this is the first logger.
-	// Otherwise, get the existing log store.
+// getStore returns the log store shared by all loggers,
+// creating it if this is the first logger.
+func (e *extension) getStore() (LogStore, error) {
 	store, err := e.store.GetErr(func() (LogStore, error) {
-		return newDefaultLogStore(e.logf)
+		return newDefaultLogStore(e.logf, e.varRoot)
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create audit log store: %w", err)
 	}
+	return store, nil
+}
 
+func (e *extension) startLogger(logf logger.Logf, store LogStore, transport Transport, profileID ipn.ProfileID) (*Logger, error) {
 	logger := NewLogger(Opts{
-		Logf:       e.logf,
+		Logf:       logf,
 		RetryLimit: 32,
 		Store:      store,
 	})
@@ -106,8 +167,13 @@ func (e *extension) startNewLogger(cc controlclient.Client, profileID ipn.Profil
 
 func (e *extension) controlClientChanged(cc controlclient.Client, profile ipn.LoginProfileView) (cleanup func()) {
 	logger, err := e.startNewLogger(cc, profile.ID())
+	var exportLoggers []*Logger
+	if err == nil {
+		exportLoggers = e.startExportLoggers(profile.ID())
+	}
 	e.mu.Lock()
 	e.logger = logger // nil on error
+	e.exportLoggers = exportLoggers
 	e.mu.Unlock()
 	if err != nil {
 		// If we fail to create or start the logger, log the error
@@ -127,6 +193,9 @@ func (e *extension) controlClientChanged(cc controlclient.Client, profile ipn.Lo
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 		logger.FlushAndStop(ctx)
+		for _, l := range exportLoggers {
+			l.FlushAndStop(ctx)
+		}
 	}
 }
 
@@ -140,8 +209,10 @@ func (e *extension) profileChanged(profile ipn.LoginProfileView, _ ipn.PrefsView
 		// The profile info has changed, but it represents the same node.
 		// This includes the case where the login has just been completed
 		// and the profile's [ipn.ProfileID] has been set for the first time.
-		if err := e.logger.SetProfileID(profile.ID()); err != nil {
-			e.logf("[unexpected] failed to set profile ID: %v", err)
+		for _, l := range append([]*Logger{e.logger}, e.exportLoggers...) {
+			if err := l.SetProfileID(profile.ID()); err != nil {
+				e.logf("[unexpected] failed to set profile ID: %v", err)
+			}
 		}
 	default:
 		// The profile info has changed, and it represents a different node.
@@ -151,6 +222,7 @@ func (e *extension) profileChanged(profile ipn.LoginProfileView, _ ipn.PrefsView
 		// We don't expect any auditable actions to be attempted in this state.
 		// But if they are, they will fail with [errNoLogger].
 		e.logger = nil
+		e.exportLoggers = nil
 	}
 }
 
@@ -180,7 +252,23 @@ func (e *extension) getCurrentLogger() ipnauth.AuditLogFunc {
 	if e.logger == nil {
 		return noCurrentLogger
 	}
-	return e.logger.Enqueue
+	if len(e.exportLoggers) == 0 {
+		return e.logger.Enqueue
+	}
+	primary, exports := e.logger, e.exportLoggers
+	return func(action tailcfg.ClientAuditAction, details string) error {
+		if err := primary.Enqueue(action, details); err != nil {
+			return err
+		}
+		// The action is allowed once the control plane's copy of the log
+		// is persisted; a failure to queue an export doesn't block it.
+		for _, l := range exports {
+			if err := l.Enqueue(action, details); err != nil {
+				e.logf("failed to queue exported audit log: %v", err)
+			}
+		}
+		return nil
+	}
 }
 
 // Shutdown implements [ipnlocal.Extension].
@@ -4,12 +4,14 @@
 package auditlog
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"runtime"
 
 	"tailscale.com/ipn/store"
+	"tailscale.com/paths"
 	"tailscale.com/types/lazy"
 	"tailscale.com/types/logger"
 	"tailscale.com/util/must"
@@ -27,12 +29,21 @@ func SetStoreFilePath(path string) {
 	}
 }
 
+// storeFileName is the base name of the audit log store file
+// on platforms other than Windows.
+const storeFileName = "audit-log.json"
+
 // DefaultStoreFilePath returns the default audit log store file path
 // for the current platform, or an error if the platform does not have one.
 func DefaultStoreFilePath() (string, error) {
 	switch runtime.GOOS {
 	case "windows":
 		return filepath.Join(os.Getenv("ProgramData"), "Tailscale", "audit-log.json"), nil
+	case "linux":
+		if dir := paths.DefaultTailscaledStateDir(); dir != "" && dir != "." {
+			return filepath.Join(dir, storeFileName), nil
+		}
+		return "", errors.New("[unexpected] no default state directory for the audit log store")
 	default:
 		// The auditlog package must either be omitted from the build,
 		// have the platform-specific store path set with [SetStoreFilePath] (e.g., on macOS),
@@ -42,8 +53,14 @@ func DefaultStoreFilePath() (string, error) {
 }
 
 // newDefaultLogStore returns a new [LogStore] for the current platform.
-func newDefaultLogStore(logf logger.Logf) (LogStore, error) {
-	path, err := storeFilePath.GetErr(DefaultStoreFilePath)
+// On Linux, it's kept in varRoot, tailscaled's state directory, if non-empty.
+func newDefaultLogStore(logf logger.Logf, varRoot string) (LogStore, error) {
+	path, err := storeFilePath.GetErr(func() (string, error) {
+		if runtime.GOOS == "linux" && varRoot != "" {
+			return filepath.Join(varRoot, storeFileName), nil
+		}
+		return DefaultStoreFilePath()
+	})
 	if err != nil {
 		// This indicates that the auditlog package was not omitted from the build
 		// on a platform without a default store path and that [SetStoreFilePath]
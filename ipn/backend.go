@@ -243,6 +243,12 @@ type OutgoingFile struct {
 	Sent         int64                // bytes copied thus far
 	Finished     bool                 // indicates whether or not the transfer finished
 	Succeeded    bool                 // for a finished transfer, indicates whether or not it was successful
+
+	// NumFiles is, for a directory transfer, the number of files in the
+	// directory. DeclaredSize and Sent then cover all files combined.
+	// It is zero for single file transfers.
+	NumFiles  int `json:",omitempty"`
+	FilesSent int `json:",omitempty"` // number of files completely sent in a directory transfer
 }
 
 // StateKey is an opaque identifier for a set of LocalBackend state
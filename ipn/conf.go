@@ -9,6 +9,7 @@ import (
 	"net/netip"
 
 	"tailscale.com/tailcfg"
+	"tailscale.com/tstime"
 	"tailscale.com/types/opt"
 	"tailscale.com/types/preftype"
 )
@@ -52,6 +53,35 @@ type ConfigVAlpha struct {
 	// should advertise amongst its wireguard endpoints.
 	StaticEndpoints []netip.AddrPort `json:",omitempty"`
 
+	// TaildropStorage, if non-empty, is where received Taildrop files are
+	// written directly, without staging them for pick-up. It is either a
+	// local directory or a URL whose scheme names a registered storage
+	// backend, such as "s3://bucket/prefix".
+	TaildropStorage *string `json:",omitempty"`
+
+	// TaildropPolicy, if non-empty, is the path to a HuJSON file with the
+	// policy restricting which files are accepted via Taildrop: which
+	// senders may send files, per-sender quotas, maximum file size,
+	// allowed file extensions, and whether to sort received files into
+	// per-sender directories. See taildrop.ReceivePolicy.
+	TaildropPolicy *string `json:",omitempty"`
+
+	// SSHRecording, if non-nil, configures recording of Tailscale SSH
+	// sessions to local storage. Sessions whose SSH policy names no
+	// recorders are recorded locally, as are sessions whose recorders
+	// can't be reached.
+	SSHRecording *SSHRecordingConfig `json:",omitempty"`
+
+	// AuditLog, if non-nil, configures destinations that client audit
+	// logs are exported to in addition to the control plane. On Linux,
+	// configuring it is what enables audit logging.
+	AuditLog *AuditLogConfig `json:",omitempty"`
+
+	// NetLog, if non-nil, configures writing network flow logs to local
+	// files or exporting them to an IPFIX collector, whether or not network
+	// flow logging is enabled for the tailnet.
+	NetLog *NetLogConfig `json:",omitempty"`
+
 	// TODO(bradfitz,maisem): future something like:
 	// Profile map[string]*Config // keyed by alice@gmail.com, corp.com (TailnetSID)
 }
@@ -168,3 +198,79 @@ func (c *ConfigVAlpha) ToPrefs() (MaskedPrefs, error) {
 	}
 	return mp, nil
 }
+
+// SSHRecordingConfig is the configuration for recording Tailscale SSH
+// sessions to a local directory.
+type SSHRecordingConfig struct {
+	// Dir is the directory to write recordings to.
+	Dir string
+
+	// MaxAge, if non-zero, is how long to keep recordings.
+	MaxAge tstime.GoDuration `json:",omitzero"`
+
+	// MaxTotalSize, if positive, is the maximum total size in bytes of
+	// the recordings to keep. The oldest are deleted first.
+	MaxTotalSize int64 `json:",omitempty"`
+
+	// Compress specifies whether to compress recordings with zstd.
+	Compress bool `json:",omitempty"`
+
+	// Recipient, if non-empty, is the "recpub:"-prefixed public key to
+	// encrypt recordings to, as generated by "tscast genkey".
+	Recipient string `json:",omitempty"`
+}
+
+// AuditLogConfig is the configuration for exporting client audit logs
+// (see tailcfg.ClientAuditAction) to destinations other than the control
+// plane. Each destination has its own persistent queue, so one that's
+// unavailable doesn't hold up the others.
+type AuditLogConfig struct {
+	// File, if non-empty, is the path of a file that audit logs are
+	// appended to as JSON lines.
+	File string `json:",omitempty"`
+
+	// Syslog specifies whether to send audit logs to the local
+	// syslog daemon. It's not supported on Windows.
+	Syslog bool `json:",omitempty"`
+
+	// Webhook, if non-empty, is an http or https URL that each audit log
+	// is POSTed to as a JSON object. Requests that fail with a network
+	// error, a 429 or a 5xx status are retried with backoff.
+	Webhook string `json:",omitempty"`
+}
+
+// NetLogConfig is the configuration for writing network flow logs (see
+// netlogtype.Message) to files on the local node, and for exporting them
+// to an IPFIX collector.
+type NetLogConfig struct {
+	// Dir, if non-empty, is the directory that flow logs are written to,
+	// one JSON object per line. The current file is named netlog.jsonl. When it reaches
+	// MaxFileSize, it's renamed to netlog-<UTC timestamp>.jsonl (with a .gz
+	// suffix if Compress is set) and a new current file is started.
+	Dir string `json:",omitempty"`
+
+	// MaxFileSize is the size in bytes at which the current file is
+	// rotated. If zero, 10 MiB is used.
+	MaxFileSize int64 `json:",omitempty"`
+
+	// MaxFiles is the number of rotated files to keep, after which the
+	// oldest are deleted. If zero, 10 are kept.
+	MaxFiles int `json:",omitempty"`
+
+	// Compress specifies whether rotated files are gzip compressed.
+	Compress bool `json:",omitempty"`
+
+	// IPFIXCollector, if non-empty, is the "host:port" of a UDP IPFIX
+	// collector to export flow records to.
+	IPFIXCollector string `json:",omitempty"`
+
+	// IPFIXEnterpriseNumber is the IANA Private Enterprise Number that
+	// scopes the Tailscale-specific IPFIX information elements (node IDs,
+	// users and traffic type). If zero, those elements are not exported
+	// and only the standard ones are.
+	IPFIXEnterpriseNumber uint32 `json:",omitempty"`
+
+	// IPFIXObservationDomainID is the observation domain ID that this node
+	// sends in IPFIX messages.
+	IPFIXObservationDomainID uint32 `json:",omitempty"`
+}
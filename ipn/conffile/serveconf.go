@@ -15,6 +15,7 @@ import (
 
 	jsonv2 "github.com/go-json-experiment/json"
 	"github.com/go-json-experiment/json/jsontext"
+	"tailscale.com/ipn"
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/opt"
 	"tailscale.com/util/mak"
@@ -81,6 +82,67 @@ type Target struct {
 	// If Protocol is not ProtoFile or ProtoTUN, then DestinationPorts is the
 	// set of ports on which to connect to the host referred to by Destination.
 	DestinationPorts tailcfg.PortRange
+
+	// Rewrite optionally rewrites the requests to and responses from HTTP
+	// and file targets. A Target with a Rewrite is written as an object
+	// with "target" and "rewrite" fields, rather than as a string.
+	Rewrite *Rewrite
+}
+
+// targetObject is the object form of a [Target] with a [Rewrite].
+type targetObject struct {
+	Target  string   `json:"target"`
+	Rewrite *Rewrite `json:"rewrite,omitzero"`
+}
+
+// Rewrite is the config syntax for rewriting the requests to and responses
+// from an HTTP target. See [ipn.HTTPRewrite] for the meaning of its fields.
+type Rewrite struct {
+	StripPrefix     string       `json:"stripPrefix,omitzero"`
+	Host            string       `json:"host,omitzero"`
+	RequestHeaders  *HeaderRules `json:"requestHeaders,omitzero"`
+	ResponseHeaders *HeaderRules `json:"responseHeaders,omitzero"`
+}
+
+// HeaderRules is the config syntax for a set of changes to HTTP headers.
+// See [ipn.HeaderRules].
+type HeaderRules struct {
+	Remove []string          `json:"remove,omitzero"`
+	Set    map[string]string `json:"set,omitzero"`
+	Add    map[string]string `json:"add,omitzero"`
+}
+
+// HasRequestRules reports whether r rewrites requests, as opposed to only
+// responses.
+func (r *Rewrite) HasRequestRules() bool {
+	return r != nil && (r.StripPrefix != "" || r.Host != "" || r.RequestHeaders != nil)
+}
+
+// ServeRewrite returns r as an [ipn.HTTPRewrite]. It returns nil if r is nil.
+func (r *Rewrite) ServeRewrite() *ipn.HTTPRewrite {
+	if r == nil {
+		return nil
+	}
+	return &ipn.HTTPRewrite{
+		StripPrefix:     r.StripPrefix,
+		Host:            r.Host,
+		RequestHeaders:  (*ipn.HeaderRules)(r.RequestHeaders),
+		ResponseHeaders: (*ipn.HeaderRules)(r.ResponseHeaders),
+	}
+}
+
+// RewriteFromServe returns rw in the config syntax. It returns nil if rw is
+// nil.
+func RewriteFromServe(rw *ipn.HTTPRewrite) *Rewrite {
+	if rw == nil {
+		return nil
+	}
+	return &Rewrite{
+		StripPrefix:     rw.StripPrefix,
+		Host:            rw.Host,
+		RequestHeaders:  (*HeaderRules)(rw.RequestHeaders),
+		ResponseHeaders: (*HeaderRules)(rw.ResponseHeaders),
+	}
 }
 
 // UnmarshalJSON implements [jsonv1.Unmarshaler].
@@ -91,9 +153,25 @@ func (t *Target) UnmarshalJSON(buf []byte) error {
 // UnmarshalJSONFrom implements [jsonv2.UnmarshalerFrom].
 func (t *Target) UnmarshalJSONFrom(dec *jsontext.Decoder) error {
 	var str string
-	if err := jsonv2.UnmarshalDecode(dec, &str); err != nil {
+	var rewrite *Rewrite
+	if dec.PeekKind() == '{' {
+		var obj targetObject
+		if err := jsonv2.UnmarshalDecode(dec, &obj, jsonv2.RejectUnknownMembers(true)); err != nil {
+			return err
+		}
+		str, rewrite = obj.Target, obj.Rewrite
+	} else if err := jsonv2.UnmarshalDecode(dec, &str); err != nil {
+		return err
+	}
+	if err := t.parse(str); err != nil {
 		return err
 	}
+	t.Rewrite = rewrite
+	return nil
+}
+
+// parse parses the string form of a Target.
+func (t *Target) parse(str string) error {
 
 	// The TUN case does not look like a standard <url>://<proto> arrangement,
 	// so handled separately.
@@ -130,6 +208,19 @@ func (t *Target) UnmarshalJSONFrom(dec *jsontext.Decoder) error {
 	return nil
 }
 
+// MarshalJSON implements [jsonv1.Marshaler].
+func (t *Target) MarshalJSON() ([]byte, error) {
+	text, err := t.MarshalText()
+	if err != nil {
+		return nil, err
+	}
+	if t.Rewrite == nil {
+		return jsonv2.Marshal(string(text))
+	}
+	return jsonv2.Marshal(targetObject{Target: string(text), Rewrite: t.Rewrite})
+}
+
+// MarshalText returns the string form of t, without its Rewrite.
 func (t *Target) MarshalText() ([]byte, error) {
 	var out string
 	switch t.Protocol {
@@ -204,6 +295,17 @@ func loadConfigV0(json []byte, forService string) (*ServicesConfigFile, error) {
 		foundTUN := false
 		foundNonTUN := false
 		for ppr, target := range svc.Endpoints {
+			switch target.Protocol {
+			case ProtoHTTP, ProtoHTTPS, ProtoHTTPSInsecure:
+			case ProtoFile:
+				if target.Rewrite.HasRequestRules() {
+					return nil, fmt.Errorf("service %q: file targets can only rewrite response headers", svcName)
+				}
+			default:
+				if target.Rewrite != nil {
+					return nil, fmt.Errorf("service %q: rewrites are only supported for HTTP and file targets", svcName)
+				}
+			}
 			if target.Protocol == "TUN" {
 				if ppr.Proto != 0 || ppr.Ports != tailcfg.PortRangeAny {
 					return nil, fmt.Errorf("service %q: destination \"TUN\" can only be used with source \"*\"", svcName)
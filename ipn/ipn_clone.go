@@ -11,6 +11,7 @@ import (
 
 	"tailscale.com/drive"
 	"tailscale.com/tailcfg"
+	"tailscale.com/tstime"
 	"tailscale.com/types/opt"
 	"tailscale.com/types/persist"
 	"tailscale.com/types/preftype"
@@ -122,7 +123,7 @@ func (src *ServeConfig) Clone() *ServeConfig {
 			if v == nil {
 				dst.TCP[k] = nil
 			} else {
-				dst.TCP[k] = new(*v)
+				dst.TCP[k] = v.Clone()
 			}
 		}
 	}
@@ -184,7 +185,7 @@ func (src *ServiceConfig) Clone() *ServiceConfig {
 			if v == nil {
 				dst.TCP[k] = nil
 			} else {
-				dst.TCP[k] = new(*v)
+				dst.TCP[k] = v.Clone()
 			}
 		}
 	}
@@ -216,16 +217,22 @@ func (src *TCPPortHandler) Clone() *TCPPortHandler {
 	}
 	dst := new(TCPPortHandler)
 	*dst = *src
+	dst.TCPForwardBackends = append(src.TCPForwardBackends[:0:0], src.TCPForwardBackends...)
+	if dst.LoadBalancing != nil {
+		dst.LoadBalancing = new(*src.LoadBalancing)
+	}
 	return dst
 }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _TCPPortHandlerCloneNeedsRegeneration = TCPPortHandler(struct {
-	HTTPS         bool
-	HTTP          bool
-	TCPForward    string
-	TerminateTLS  string
-	ProxyProtocol int
+	HTTPS              bool
+	HTTP               bool
+	TCPForward         string
+	TCPForwardBackends []string
+	TerminateTLS       string
+	ProxyProtocol      int
+	LoadBalancing      *LoadBalancing
 }{})
 
 // Clone makes a deep copy of HTTPHandler.
@@ -236,7 +243,13 @@ func (src *HTTPHandler) Clone() *HTTPHandler {
 	}
 	dst := new(HTTPHandler)
 	*dst = *src
+	dst.Backends = append(src.Backends[:0:0], src.Backends...)
 	dst.AcceptAppCaps = append(src.AcceptAppCaps[:0:0], src.AcceptAppCaps...)
+	if dst.LoadBalancing != nil {
+		dst.LoadBalancing = new(*src.LoadBalancing)
+	}
+	dst.Rewrite = src.Rewrite.Clone()
+	dst.Access = src.Access.Clone()
 	return dst
 }
 
@@ -244,9 +257,99 @@ func (src *HTTPHandler) Clone() *HTTPHandler {
 var _HTTPHandlerCloneNeedsRegeneration = HTTPHandler(struct {
 	Path          string
 	Proxy         string
+	Backends      []string
 	Text          string
 	AcceptAppCaps []tailcfg.PeerCapability
 	Redirect      string
+	LoadBalancing *LoadBalancing
+	Rewrite       *HTTPRewrite
+	Access        *HTTPAccess
+}{})
+
+// Clone makes a deep copy of HTTPAccess.
+// The result aliases no memory with the original.
+func (src *HTTPAccess) Clone() *HTTPAccess {
+	if src == nil {
+		return nil
+	}
+	dst := new(HTTPAccess)
+	*dst = *src
+	dst.Users = append(src.Users[:0:0], src.Users...)
+	dst.Groups = append(src.Groups[:0:0], src.Groups...)
+	dst.Tags = append(src.Tags[:0:0], src.Tags...)
+	dst.Caps = append(src.Caps[:0:0], src.Caps...)
+	return dst
+}
+
+// A compilation failure here means this code must be regenerated, with the command at the top of this file.
+var _HTTPAccessCloneNeedsRegeneration = HTTPAccess(struct {
+	Users  []tailcfg.UserID
+	Groups []string
+	Tags   []string
+	Caps   []tailcfg.PeerCapability
+	Funnel FunnelAccess
+}{})
+
+// Clone makes a deep copy of HTTPRewrite.
+// The result aliases no memory with the original.
+func (src *HTTPRewrite) Clone() *HTTPRewrite {
+	if src == nil {
+		return nil
+	}
+	dst := new(HTTPRewrite)
+	*dst = *src
+	dst.RequestHeaders = src.RequestHeaders.Clone()
+	dst.ResponseHeaders = src.ResponseHeaders.Clone()
+	return dst
+}
+
+// A compilation failure here means this code must be regenerated, with the command at the top of this file.
+var _HTTPRewriteCloneNeedsRegeneration = HTTPRewrite(struct {
+	StripPrefix     string
+	Host            string
+	RequestHeaders  *HeaderRules
+	ResponseHeaders *HeaderRules
+}{})
+
+// Clone makes a deep copy of HeaderRules.
+// The result aliases no memory with the original.
+func (src *HeaderRules) Clone() *HeaderRules {
+	if src == nil {
+		return nil
+	}
+	dst := new(HeaderRules)
+	*dst = *src
+	dst.Remove = append(src.Remove[:0:0], src.Remove...)
+	dst.Set = maps.Clone(src.Set)
+	dst.Add = maps.Clone(src.Add)
+	return dst
+}
+
+// A compilation failure here means this code must be regenerated, with the command at the top of this file.
+var _HeaderRulesCloneNeedsRegeneration = HeaderRules(struct {
+	Remove []string
+	Set    map[string]string
+	Add    map[string]string
+}{})
+
+// Clone makes a deep copy of LoadBalancing.
+// The result aliases no memory with the original.
+func (src *LoadBalancing) Clone() *LoadBalancing {
+	if src == nil {
+		return nil
+	}
+	dst := new(LoadBalancing)
+	*dst = *src
+	return dst
+}
+
+// A compilation failure here means this code must be regenerated, with the command at the top of this file.
+var _LoadBalancingCloneNeedsRegeneration = LoadBalancing(struct {
+	Policy              LBPolicy
+	HealthCheckInterval tstime.GoDuration
+	HealthCheckPath     string
+	MaxFails            int
+	FailTimeout         tstime.GoDuration
 }{})
 
 // Clone makes a deep copy of WebServerConfig.
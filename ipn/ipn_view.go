@@ -14,13 +14,14 @@ import (
 	"github.com/go-json-experiment/json/jsontext"
 	"tailscale.com/drive"
 	"tailscale.com/tailcfg"
+	"tailscale.com/tstime"
 	"tailscale.com/types/opt"
 	"tailscale.com/types/persist"
 	"tailscale.com/types/preftype"
 	"tailscale.com/types/views"
 )
 
-//go:generate go run tailscale.com/cmd/cloner  -clonefunc=false -type=LoginProfile,Prefs,ServeConfig,ServiceConfig,TCPPortHandler,HTTPHandler,WebServerConfig
+//go:generate go run tailscale.com/cmd/cloner  -clonefunc=false -type=LoginProfile,Prefs,ServeConfig,ServiceConfig,TCPPortHandler,HTTPHandler,HTTPAccess,HTTPRewrite,HeaderRules,LoadBalancing,WebServerConfig
 
 // View returns a read-only view of LoginProfile.
 func (p *LoginProfile) View() LoginProfileView {
@@ -811,9 +812,18 @@ func (v TCPPortHandlerView) HTTP() bool { return v.ж.HTTP }
 // Whether or not TLS is terminated by tailscaled depends on
 // TerminateTLS.
 //
-// It is mutually exclusive with HTTPS.
+// It is mutually exclusive with HTTPS and TCPForwardBackends.
 func (v TCPPortHandlerView) TCPForward() string { return v.ж.TCPForward }
 
+// TCPForwardBackends, if non-empty, is a pool of IP:port backends to
+// forward TCP connections to, selected according to LoadBalancing.
+// TerminateTLS and ProxyProtocol apply to all of them.
+//
+// It is mutually exclusive with HTTPS and TCPForward.
+func (v TCPPortHandlerView) TCPForwardBackends() views.Slice[string] {
+	return views.SliceOf(v.ж.TCPForwardBackends)
+}
+
 // TerminateTLS, if non-empty, means that tailscaled should terminate the
 // TLS connections before forwarding them to TCPForward, permitting only the
 // SNI name with this value. It is only used if TCPForward is non-empty.
@@ -826,13 +836,20 @@ func (v TCPPortHandlerView) TerminateTLS() string { return v.ж.TerminateTLS }
 // This is only valid if TCPForward is non-empty.
 func (v TCPPortHandlerView) ProxyProtocol() int { return v.ж.ProxyProtocol }
 
+// LoadBalancing configures how connections are distributed across
+// TCPForwardBackends and how their health is checked. If nil, the
+// defaults described on LoadBalancing are used.
+func (v TCPPortHandlerView) LoadBalancing() LoadBalancingView { return v.ж.LoadBalancing.View() }
+
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _TCPPortHandlerViewNeedsRegeneration = TCPPortHandler(struct {
-	HTTPS         bool
-	HTTP          bool
-	TCPForward    string
-	TerminateTLS  string
-	ProxyProtocol int
+	HTTPS              bool
+	HTTP               bool
+	TCPForward         string
+	TCPForwardBackends []string
+	TerminateTLS       string
+	ProxyProtocol      int
+	LoadBalancing      *LoadBalancing
 }{})
 
 // View returns a read-only view of HTTPHandler.
@@ -908,6 +925,11 @@ func (v HTTPHandlerView) Path() string { return v.ж.Path }
 // http://localhost:3000/, localhost:3030, 3030
 func (v HTTPHandlerView) Proxy() string { return v.ж.Proxy }
 
+// Backends, if non-empty, is a pool of backends to proxy requests to,
+// each in the same form as Proxy. Requests are distributed across the
+// healthy backends according to LoadBalancing.
+func (v HTTPHandlerView) Backends() views.Slice[string] { return views.SliceOf(v.ж.Backends) }
+
 // plaintext to serve (primarily for testing)
 func (v HTTPHandlerView) Text() string { return v.ж.Text }
 
@@ -925,13 +947,403 @@ func (v HTTPHandlerView) AcceptAppCaps() views.Slice[tailcfg.PeerCapability] {
 //   - ${REQUEST_URI}: replaced with the request's full URI (path and query string)
 func (v HTTPHandlerView) Redirect() string { return v.ж.Redirect }
 
+// LoadBalancing configures how requests are distributed across Backends
+// and how their health is checked. If nil, the defaults described on
+// LoadBalancing are used.
+func (v HTTPHandlerView) LoadBalancing() LoadBalancingView { return v.ж.LoadBalancing.View() }
+
+// Rewrite, if non-nil, rewrites the requests proxied by this handler
+// and the responses it serves.
+func (v HTTPHandlerView) Rewrite() HTTPRewriteView { return v.ж.Rewrite.View() }
+
+// Access, if non-nil, restricts which clients can use this handler.
+// Other clients get a 403 Forbidden response.
+func (v HTTPHandlerView) Access() HTTPAccessView { return v.ж.Access.View() }
+
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _HTTPHandlerViewNeedsRegeneration = HTTPHandler(struct {
 	Path          string
 	Proxy         string
+	Backends      []string
 	Text          string
 	AcceptAppCaps []tailcfg.PeerCapability
 	Redirect      string
+	LoadBalancing *LoadBalancing
+	Rewrite       *HTTPRewrite
+	Access        *HTTPAccess
+}{})
+
+// View returns a read-only view of HTTPAccess.
+func (p *HTTPAccess) View() HTTPAccessView {
+	return HTTPAccessView{ж: p}
+}
+
+// HTTPAccessView provides a read-only view over HTTPAccess.
+//
+// Its methods should only be called if `Valid()` returns true.
+type HTTPAccessView struct {
+	// ж is the underlying mutable value, named with a hard-to-type
+	// character that looks pointy like a pointer.
+	// It is named distinctively to make you think of how dangerous it is to escape
+	// to callers. You must not let callers be able to mutate it.
+	ж *HTTPAccess
+}
+
+// Valid reports whether v's underlying value is non-nil.
+func (v HTTPAccessView) Valid() bool { return v.ж != nil }
+
+// AsStruct returns a clone of the underlying value which aliases no memory with
+// the original.
+func (v HTTPAccessView) AsStruct() *HTTPAccess {
+	if v.ж == nil {
+		return nil
+	}
+	return v.ж.Clone()
+}
+
+// MarshalJSON implements [jsonv1.Marshaler].
+func (v HTTPAccessView) MarshalJSON() ([]byte, error) {
+	return jsonv1.Marshal(v.ж)
+}
+
+// MarshalJSONTo implements [jsonv2.MarshalerTo].
+func (v HTTPAccessView) MarshalJSONTo(enc *jsontext.Encoder) error {
+	return jsonv2.MarshalEncode(enc, v.ж)
+}
+
+// UnmarshalJSON implements [jsonv1.Unmarshaler].
+func (v *HTTPAccessView) UnmarshalJSON(b []byte) error {
+	if v.ж != nil {
+		return errors.New("already initialized")
+	}
+	if len(b) == 0 {
+		return nil
+	}
+	var x HTTPAccess
+	if err := jsonv1.Unmarshal(b, &x); err != nil {
+		return err
+	}
+	v.ж = &x
+	return nil
+}
+
+// UnmarshalJSONFrom implements [jsonv2.UnmarshalerFrom].
+func (v *HTTPAccessView) UnmarshalJSONFrom(dec *jsontext.Decoder) error {
+	if v.ж != nil {
+		return errors.New("already initialized")
+	}
+	var x HTTPAccess
+	if err := jsonv2.UnmarshalDecode(dec, &x); err != nil {
+		return err
+	}
+	v.ж = &x
+	return nil
+}
+
+// Users lists the IDs of allowed users. Untagged nodes owned by one of
+// them match; tagged nodes don't, whoever owns them.
+func (v HTTPAccessView) Users() views.Slice[tailcfg.UserID] { return views.SliceOf(v.ж.Users) }
+
+// Groups lists groups whose members are allowed, such as "group:eng".
+// Group membership comes from [tailcfg.UserProfile.Groups], which is
+// only populated if the control server is configured to report groups;
+// otherwise, no group matches. Tagged nodes don't match.
+func (v HTTPAccessView) Groups() views.Slice[string] { return views.SliceOf(v.ж.Groups) }
+
+// Tags lists tags, such as "tag:prod", of allowed nodes.
+func (v HTTPAccessView) Tags() views.Slice[string] { return views.SliceOf(v.ж.Tags) }
+
+// Caps lists peer capabilities, such as "example.com/cap/admin", that
+// allow clients granted any of them.
+func (v HTTPAccessView) Caps() views.Slice[tailcfg.PeerCapability] { return views.SliceOf(v.ж.Caps) }
+
+// Funnel is the policy for requests via Funnel. The zero value denies
+// them.
+func (v HTTPAccessView) Funnel() FunnelAccess { return v.ж.Funnel }
+
+// A compilation failure here means this code must be regenerated, with the command at the top of this file.
+var _HTTPAccessViewNeedsRegeneration = HTTPAccess(struct {
+	Users  []tailcfg.UserID
+	Groups []string
+	Tags   []string
+	Caps   []tailcfg.PeerCapability
+	Funnel FunnelAccess
+}{})
+
+// View returns a read-only view of HTTPRewrite.
+func (p *HTTPRewrite) View() HTTPRewriteView {
+	return HTTPRewriteView{ж: p}
+}
+
+// HTTPRewriteView provides a read-only view over HTTPRewrite.
+//
+// Its methods should only be called if `Valid()` returns true.
+type HTTPRewriteView struct {
+	// ж is the underlying mutable value, named with a hard-to-type
+	// character that looks pointy like a pointer.
+	// It is named distinctively to make you think of how dangerous it is to escape
+	// to callers. You must not let callers be able to mutate it.
+	ж *HTTPRewrite
+}
+
+// Valid reports whether v's underlying value is non-nil.
+func (v HTTPRewriteView) Valid() bool { return v.ж != nil }
+
+// AsStruct returns a clone of the underlying value which aliases no memory with
+// the original.
+func (v HTTPRewriteView) AsStruct() *HTTPRewrite {
+	if v.ж == nil {
+		return nil
+	}
+	return v.ж.Clone()
+}
+
+// MarshalJSON implements [jsonv1.Marshaler].
+func (v HTTPRewriteView) MarshalJSON() ([]byte, error) {
+	return jsonv1.Marshal(v.ж)
+}
+
+// MarshalJSONTo implements [jsonv2.MarshalerTo].
+func (v HTTPRewriteView) MarshalJSONTo(enc *jsontext.Encoder) error {
+	return jsonv2.MarshalEncode(enc, v.ж)
+}
+
+// UnmarshalJSON implements [jsonv1.Unmarshaler].
+func (v *HTTPRewriteView) UnmarshalJSON(b []byte) error {
+	if v.ж != nil {
+		return errors.New("already initialized")
+	}
+	if len(b) == 0 {
+		return nil
+	}
+	var x HTTPRewrite
+	if err := jsonv1.Unmarshal(b, &x); err != nil {
+		return err
+	}
+	v.ж = &x
+	return nil
+}
+
+// UnmarshalJSONFrom implements [jsonv2.UnmarshalerFrom].
+func (v *HTTPRewriteView) UnmarshalJSONFrom(dec *jsontext.Decoder) error {
+	if v.ж != nil {
+		return errors.New("already initialized")
+	}
+	var x HTTPRewrite
+	if err := jsonv2.UnmarshalDecode(dec, &x); err != nil {
+		return err
+	}
+	v.ж = &x
+	return nil
+}
+
+// StripPrefix, if non-empty, is removed from the start of the request
+// path before proxying, after the handler's mount point has already
+// been removed. Paths that don't start with StripPrefix are proxied
+// unchanged.
+func (v HTTPRewriteView) StripPrefix() string { return v.ж.StripPrefix }
+
+// Host, if non-empty, overrides the Host header of proxied requests. By
+// default, the Host header of the incoming request is forwarded.
+func (v HTTPRewriteView) Host() string { return v.ж.Host }
+
+// RequestHeaders rewrites the headers of proxied requests. The
+// Tailscale identity and app capability headers set by serve can't be
+// rewritten.
+func (v HTTPRewriteView) RequestHeaders() HeaderRulesView { return v.ж.RequestHeaders.View() }
+
+// ResponseHeaders rewrites the headers of responses, such as to add
+// CORS or HSTS headers.
+func (v HTTPRewriteView) ResponseHeaders() HeaderRulesView { return v.ж.ResponseHeaders.View() }
+
+// A compilation failure here means this code must be regenerated, with the command at the top of this file.
+var _HTTPRewriteViewNeedsRegeneration = HTTPRewrite(struct {
+	StripPrefix     string
+	Host            string
+	RequestHeaders  *HeaderRules
+	ResponseHeaders *HeaderRules
+}{})
+
+// View returns a read-only view of HeaderRules.
+func (p *HeaderRules) View() HeaderRulesView {
+	return HeaderRulesView{ж: p}
+}
+
+// HeaderRulesView provides a read-only view over HeaderRules.
+//
+// Its methods should only be called if `Valid()` returns true.
+type HeaderRulesView struct {
+	// ж is the underlying mutable value, named with a hard-to-type
+	// character that looks pointy like a pointer.
+	// It is named distinctively to make you think of how dangerous it is to escape
+	// to callers. You must not let callers be able to mutate it.
+	ж *HeaderRules
+}
+
+// Valid reports whether v's underlying value is non-nil.
+func (v HeaderRulesView) Valid() bool { return v.ж != nil }
+
+// AsStruct returns a clone of the underlying value which aliases no memory with
+// the original.
+func (v HeaderRulesView) AsStruct() *HeaderRules {
+	if v.ж == nil {
+		return nil
+	}
+	return v.ж.Clone()
+}
+
+// MarshalJSON implements [jsonv1.Marshaler].
+func (v HeaderRulesView) MarshalJSON() ([]byte, error) {
+	return jsonv1.Marshal(v.ж)
+}
+
+// MarshalJSONTo implements [jsonv2.MarshalerTo].
+func (v HeaderRulesView) MarshalJSONTo(enc *jsontext.Encoder) error {
+	return jsonv2.MarshalEncode(enc, v.ж)
+}
+
+// UnmarshalJSON implements [jsonv1.Unmarshaler].
+func (v *HeaderRulesView) UnmarshalJSON(b []byte) error {
+	if v.ж != nil {
+		return errors.New("already initialized")
+	}
+	if len(b) == 0 {
+		return nil
+	}
+	var x HeaderRules
+	if err := jsonv1.Unmarshal(b, &x); err != nil {
+		return err
+	}
+	v.ж = &x
+	return nil
+}
+
+// UnmarshalJSONFrom implements [jsonv2.UnmarshalerFrom].
+func (v *HeaderRulesView) UnmarshalJSONFrom(dec *jsontext.Decoder) error {
+	if v.ж != nil {
+		return errors.New("already initialized")
+	}
+	var x HeaderRules
+	if err := jsonv2.UnmarshalDecode(dec, &x); err != nil {
+		return err
+	}
+	v.ж = &x
+	return nil
+}
+
+// names of headers to remove
+func (v HeaderRulesView) Remove() views.Slice[string] { return views.SliceOf(v.ж.Remove) }
+
+// header name => value replacing any others
+func (v HeaderRulesView) Set() views.Map[string, string] { return views.MapOf(v.ж.Set) }
+
+// header name => value to add to any others
+func (v HeaderRulesView) Add() views.Map[string, string] { return views.MapOf(v.ж.Add) }
+
+// A compilation failure here means this code must be regenerated, with the command at the top of this file.
+var _HeaderRulesViewNeedsRegeneration = HeaderRules(struct {
+	Remove []string
+	Set    map[string]string
+	Add    map[string]string
+}{})
+
+// View returns a read-only view of LoadBalancing.
+func (p *LoadBalancing) View() LoadBalancingView {
+	return LoadBalancingView{ж: p}
+}
+
+// LoadBalancingView provides a read-only view over LoadBalancing.
+//
+// Its methods should only be called if `Valid()` returns true.
+type LoadBalancingView struct {
+	// ж is the underlying mutable value, named with a hard-to-type
+	// character that looks pointy like a pointer.
+	// It is named distinctively to make you think of how dangerous it is to escape
+	// to callers. You must not let callers be able to mutate it.
+	ж *LoadBalancing
+}
+
+// Valid reports whether v's underlying value is non-nil.
+func (v LoadBalancingView) Valid() bool { return v.ж != nil }
+
+// AsStruct returns a clone of the underlying value which aliases no memory with
+// the original.
+func (v LoadBalancingView) AsStruct() *LoadBalancing {
+	if v.ж == nil {
+		return nil
+	}
+	return v.ж.Clone()
+}
+
+// MarshalJSON implements [jsonv1.Marshaler].
+func (v LoadBalancingView) MarshalJSON() ([]byte, error) {
+	return jsonv1.Marshal(v.ж)
+}
+
+// MarshalJSONTo implements [jsonv2.MarshalerTo].
+func (v LoadBalancingView) MarshalJSONTo(enc *jsontext.Encoder) error {
+	return jsonv2.MarshalEncode(enc, v.ж)
+}
+
+// UnmarshalJSON implements [jsonv1.Unmarshaler].
+func (v *LoadBalancingView) UnmarshalJSON(b []byte) error {
+	if v.ж != nil {
+		return errors.New("already initialized")
+	}
+	if len(b) == 0 {
+		return nil
+	}
+	var x LoadBalancing
+	if err := jsonv1.Unmarshal(b, &x); err != nil {
+		return err
+	}
+	v.ж = &x
+	return nil
+}
+
+// UnmarshalJSONFrom implements [jsonv2.UnmarshalerFrom].
+func (v *LoadBalancingView) UnmarshalJSONFrom(dec *jsontext.Decoder) error {
+	if v.ж != nil {
+		return errors.New("already initialized")
+	}
+	var x LoadBalancing
+	if err := jsonv2.UnmarshalDecode(dec, &x); err != nil {
+		return err
+	}
+	v.ж = &x
+	return nil
+}
+
+// Policy is the policy for selecting among healthy backends.
+// If empty, LBRoundRobin is used.
+func (v LoadBalancingView) Policy() LBPolicy { return v.ж.Policy }
+
+// HealthCheckInterval is the interval between active health checks of
+// each backend. If zero, backends are only checked passively, by the
+// outcome of the requests and connections they serve.
+func (v LoadBalancingView) HealthCheckInterval() tstime.GoDuration { return v.ж.HealthCheckInterval }
+
+// HealthCheckPath, if non-empty, is the path requested from HTTP
+// backends by active health checks, which pass on a 2xx or 3xx response.
+// If empty, and for TCP backends, active health checks only establish a
+// connection.
+func (v LoadBalancingView) HealthCheckPath() string { return v.ж.HealthCheckPath }
+
+// MaxFails is the number of consecutive failures after which a backend
+// is ejected. If zero, 3 is used.
+func (v LoadBalancingView) MaxFails() int { return v.ж.MaxFails }
+
+// FailTimeout is how long a backend stays ejected when there are no
+// active health checks. If zero, 30 seconds is used.
+func (v LoadBalancingView) FailTimeout() tstime.GoDuration { return v.ж.FailTimeout }
+
+// A compilation failure here means this code must be regenerated, with the command at the top of this file.
+var _LoadBalancingViewNeedsRegeneration = LoadBalancing(struct {
+	Policy              LBPolicy
+	HealthCheckInterval tstime.GoDuration
+	HealthCheckPath     string
+	MaxFails            int
+	FailTimeout         tstime.GoDuration
 }{})
 
 // View returns a read-only view of WebServerConfig.
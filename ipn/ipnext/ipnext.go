@@ -475,6 +475,10 @@ type NodeBackend interface {
 	// PeerHasCap reports whether the peer has the specified peer capability.
 	PeerHasCap(peer tailcfg.NodeView, cap tailcfg.PeerCapability) bool
 
+	// UserByID returns the profile of the user with the given ID,
+	// if known from the current network map.
+	UserByID(tailcfg.UserID) (_ tailcfg.UserProfileView, ok bool)
+
 	// PeerAPIBase returns the "http://ip:port" URL base to reach peer's
 	// PeerAPI, or the empty string if the peer is invalid or doesn't support
 	// PeerAPI.
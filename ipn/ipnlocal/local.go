@@ -74,6 +74,7 @@ import (
 	"tailscale.com/types/appctype"
 	"tailscale.com/types/dnstype"
 	"tailscale.com/types/empty"
+	"tailscale.com/types/ipproto"
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/logid"
@@ -326,6 +327,7 @@ type LocalBackend struct {
 
 	serveListeners     map[netip.AddrPort]*localListener // listeners for local serve traffic
 	serveProxyHandlers sync.Map                          // string (HTTPHandler.Proxy) => *reverseProxy
+	serveBackendPools  sync.Map                          // string (BackendPoolKey) => *serveBackendPool
 
 	// dialPlan is any dial plan that we've received from the control
 	// server during a previous connection; it is cleared on logout.
@@ -1570,6 +1572,38 @@ func (b *LocalBackend) PeerCaps(src netip.Addr) tailcfg.PeerCapMap {
 	return b.currentNode().PeerCaps(src)
 }
 
+// CheckFilter reports what the current packet filter would do with a new
+// incoming connection (a TCP SYN, or the first packet of any other proto)
+// from src to dst:port, and why. If dst is the zero value, this node's
+// address of the same family as src is used.
+//
+// If the packet was accepted by a rule from the netmap's packet filter, rule
+// is that rule.
+func (b *LocalBackend) CheckFilter(src, dst netip.Addr, port uint16, proto ipproto.Proto) (res filter.CheckResult, rule *tailcfg.FilterRule, err error) {
+	nb := b.currentNode()
+	nm := nb.NetMap()
+	if nm == nil {
+		return res, nil, errors.New("no netmap")
+	}
+	if !dst.IsValid() {
+		for _, p := range nm.GetAddresses().All() {
+			if p.IsSingleIP() && p.Addr().BitLen() == src.BitLen() {
+				dst = p.Addr()
+				break
+			}
+		}
+		if !dst.IsValid() {
+			return res, nil, fmt.Errorf("no self address in the same address family as %v", src)
+		}
+	}
+	res = nb.filter().Explain(src, dst, port, proto)
+	if i := res.MatchIndex; i >= 0 && i < nm.PacketFilterRules.Len() {
+		r := nm.PacketFilterRules.At(i)
+		rule = &r
+	}
+	return res, rule, nil
+}
+
 func (b *LocalBackend) GetFilterForTest() *filter.Filter {
 	testenv.AssertInTest()
 	nb := b.currentNode()
@@ -35,6 +35,7 @@ import (
 
 	"github.com/pires/go-proxyproto"
 	"go4.org/mem"
+	"golang.org/x/net/http/httpguts"
 	"tailscale.com/ipn"
 	"tailscale.com/net/netmon"
 	"tailscale.com/net/netutil"
@@ -92,6 +93,9 @@ type serveHTTPContext struct {
 	Funnel *funnelFlow
 	// AppCapabilities lists all PeerCapabilities that should be forwarded by serve
 	AppCapabilities views.Slice[tailcfg.PeerCapability]
+	// Rewrite is the rewrite rules of the handler proxying the request,
+	// if any.
+	Rewrite ipn.HTTPRewriteView
 }
 
 // funnelFlow represents a funneled connection initiated via IngressPeer
@@ -600,27 +604,17 @@ func (b *LocalBackend) tcpHandlerForVIPService(dstAddr, srcAddr netip.AddrPort)
 			}
 			defer backConn.Close()
 			if sni := tcph.TerminateTLS(); sni != "" {
-				conn = tls.Server(conn, &tls.Config{
-					GetCertificate: func(hi *tls.ClientHelloInfo) (*tls.Certificate, error) {
-						ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
-						defer cancel()
-						pair, err := b.GetCertPEM(ctx, sni)
-						if err != nil {
-							return nil, err
-						}
-						cert, err := tls.X509KeyPair(pair.CertPEM, pair.KeyPEM)
-						if err != nil {
-							return nil, err
-						}
-						return &cert, nil
-					},
-				})
+				conn = b.tlsServerForServe(conn, sni)
 			}
 
 			return b.forwardTCPWithProxyProtocol(conn, backConn, tcph.ProxyProtocol(), srcAddr, dport, backDst)
 		}
 	}
 
+	if tcph.TCPForwardBackends().Len() > 0 {
+		return b.tcpHandlerForBackendPool(tcph, srcAddr, dport)
+	}
+
 	return nil
 }
 
@@ -678,21 +672,7 @@ func (b *LocalBackend) tcpHandlerForServe(dport uint16, srcAddr netip.AddrPort,
 			}
 			defer backConn.Close()
 			if sni := tcph.TerminateTLS(); sni != "" {
-				conn = tls.Server(conn, &tls.Config{
-					GetCertificate: func(hi *tls.ClientHelloInfo) (*tls.Certificate, error) {
-						ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
-						defer cancel()
-						pair, err := b.GetCertPEM(ctx, sni)
-						if err != nil {
-							return nil, err
-						}
-						cert, err := tls.X509KeyPair(pair.CertPEM, pair.KeyPEM)
-						if err != nil {
-							return nil, err
-						}
-						return &cert, nil
-					},
-				})
+				conn = b.tlsServerForServe(conn, sni)
 			}
 
 			// TODO(bradfitz): do the RegisterIPPortIdentity and
@@ -701,9 +681,33 @@ func (b *LocalBackend) tcpHandlerForServe(dport uint16, srcAddr netip.AddrPort,
 		}
 	}
 
+	if tcph.TCPForwardBackends().Len() > 0 {
+		return b.tcpHandlerForBackendPool(tcph, srcAddr, dport)
+	}
+
 	return nil
 }
 
+// tlsServerForServe returns a TLS server connection for conn, terminating
+// TLS for the SNI name sni with its Tailscale certificate.
+func (b *LocalBackend) tlsServerForServe(conn net.Conn, sni string) net.Conn {
+	return tls.Server(conn, &tls.Config{
+		GetCertificate: func(hi *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+			pair, err := b.GetCertPEM(ctx, sni)
+			if err != nil {
+				return nil, err
+			}
+			cert, err := tls.X509KeyPair(pair.CertPEM, pair.KeyPEM)
+			if err != nil {
+				return nil, err
+			}
+			return &cert, nil
+		},
+	})
+}
+
 // forwardTCPWithProxyProtocol forwards TCP traffic between conn and backConn,
 // optionally prepending a PROXY protocol header if proxyProtoVer > 0.
 // The srcAddr is the original client address used to build the PROXY header.
@@ -899,6 +903,7 @@ type reverseProxy struct {
 	backend       string
 	lb            *LocalBackend
 	socketPath    string                          // path to unix socket, empty for TCP
+	health        *serveBackend                   // or nil if not in a backend pool
 	httpTransport lazy.SyncValue[*http.Transport] // transport for non-h2c backends
 	h2cTransport  lazy.SyncValue[*http.Transport] // transport for h2c backends
 	// closed tracks whether proxy is closed/currently closing.
@@ -925,6 +930,14 @@ func (rp *reverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	p := &httputil.ReverseProxy{Rewrite: func(r *httputil.ProxyRequest) {
+		var rewrite ipn.HTTPRewriteView
+		if c, ok := serveHTTPContextKey.ValueOk(r.Out.Context()); ok {
+			rewrite = c.Rewrite
+		}
+		if rewrite.Valid() && rewrite.StripPrefix() != "" {
+			stripURLPathPrefix(r.Out.URL, rewrite.StripPrefix())
+		}
+
 		oldOutPath := r.Out.URL.Path
 		r.SetURL(rp.url)
 
@@ -945,13 +958,43 @@ func (rp *reverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		} else {
 			r.Out.Host = r.In.Host
 		}
+		if rewrite.Valid() && rewrite.Host() != "" {
+			r.Out.Host = rewrite.Host()
+		}
 		addProxyForwardedHeaders(r)
+		if rewrite.Valid() {
+			// Rewrite headers before adding the identity headers, so
+			// that they can't be spoofed by the rules.
+			applyHeaderRules(r.Out.Header, rewrite.RequestHeaders())
+		}
 		rp.lb.addTailscaleIdentityHeaders(r)
 		if err := rp.lb.addAppCapabilitiesHeader(r); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-	}} // There is no way to autodetect h2c as per RFC 9113
+	}}
+	if be := rp.health; be != nil {
+		// Passively check the health of pooled backends by the outcome of
+		// the requests they serve. Gateway errors mean the backend is
+		// listening but can't serve requests.
+		p.ModifyResponse = func(res *http.Response) error {
+			switch res.StatusCode {
+			case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+				be.markFailed(fmt.Errorf("backend responded %s", res.Status))
+			default:
+				be.markSucceeded()
+			}
+			return nil
+		}
+		p.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+			if r.Context().Err() == nil {
+				be.markFailed(err)
+			}
+			rp.logf("serve: proxy error for %s: %v", rp.backend, err)
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}
+	// There is no way to autodetect h2c as per RFC 9113
 	// https://datatracker.ietf.org/doc/html/rfc9113#name-starting-http-2.
 	// However, we assume that http:// proxy prefix in combination with the
 	// protoccol being HTTP/2 is sufficient to detect h2c for our needs. Only use this for
@@ -965,6 +1008,24 @@ func (rp *reverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	p.ServeHTTP(w, r)
 }
 
+// dialBackend dials the backend, for health checks that don't make a
+// request.
+func (rp *reverseProxy) dialBackend(ctx context.Context) (net.Conn, error) {
+	if rp.socketPath != "" {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", rp.socketPath)
+	}
+	host := rp.url.Host
+	if rp.url.Port() == "" {
+		port := "80"
+		if rp.url.Scheme == "https" {
+			port = "443"
+		}
+		host = net.JoinHostPort(rp.url.Hostname(), port)
+	}
+	return rp.lb.dialer.SystemDial(ctx, "tcp", host)
+}
+
 // getTransport returns the Transport used for regular (non-GRPC) requests
 // to the backend. The Transport gets created lazily, at most once.
 func (rp *reverseProxy) getTransport() *http.Transport {
@@ -1123,6 +1184,99 @@ func (b *LocalBackend) addAppCapabilitiesHeader(r *httputil.ProxyRequest) error
 	return nil
 }
 
+// serveAccessAllowed reports whether the client making r is allowed by a,
+// as documented on [ipn.HTTPAccess].
+func (b *LocalBackend) serveAccessAllowed(r *http.Request, a ipn.HTTPAccessView) bool {
+	c, ok := serveHTTPContextKey.ValueOk(r.Context())
+	if !ok {
+		return false
+	}
+	if c.Funnel != nil {
+		return a.Funnel() == ipn.FunnelAccessAllow
+	}
+	node, user, ok := b.WhoIs("tcp", c.SrcAddr)
+	if !ok {
+		return false
+	}
+	if node.IsTagged() {
+		if node.Tags().ContainsFunc(func(tag string) bool { return views.SliceContains(a.Tags(), tag) }) {
+			return true
+		}
+	} else {
+		if views.SliceContains(a.Users(), node.User()) {
+			return true
+		}
+		if slices.ContainsFunc(user.Groups, func(g string) bool { return views.SliceContains(a.Groups(), g) }) {
+			return true
+		}
+	}
+	if a.Caps().Len() > 0 {
+		peerCaps := b.PeerCaps(c.SrcAddr.Addr())
+		if a.Caps().ContainsFunc(peerCaps.HasCapability) {
+			return true
+		}
+	}
+	return false
+}
+
+// stripURLPathPrefix removes prefix from the start of u's path, if present,
+// in the same way as [http.StripPrefix].
+func stripURLPathPrefix(u *url.URL, prefix string) {
+	p := strings.TrimPrefix(u.Path, prefix)
+	rp := strings.TrimPrefix(u.RawPath, prefix)
+	if len(p) < len(u.Path) && (u.RawPath == "" || len(rp) < len(u.RawPath)) {
+		u.Path = p
+		u.RawPath = rp
+	}
+}
+
+// applyHeaderRules applies rules to h, in the order documented on
+// [ipn.HeaderRules]. It does nothing if rules is invalid.
+func applyHeaderRules(h http.Header, rules ipn.HeaderRulesView) {
+	if !rules.Valid() {
+		return
+	}
+	for _, k := range rules.Remove().All() {
+		h.Del(k)
+	}
+	for k, v := range rules.Set().All() {
+		h.Set(k, v)
+	}
+	for k, v := range rules.Add().All() {
+		h.Add(k, v)
+	}
+}
+
+// headerRewriteWriter is an [http.ResponseWriter] that applies rules to the
+// response headers before they're written.
+type headerRewriteWriter struct {
+	http.ResponseWriter
+	rules       ipn.HeaderRulesView
+	wroteHeader bool
+}
+
+func (w *headerRewriteWriter) WriteHeader(code int) {
+	// Informational responses other than protocol switches are followed by
+	// the final response, which gets the rewritten headers.
+	if !w.wroteHeader && (code >= 200 || code == http.StatusSwitchingProtocols) {
+		w.wroteHeader = true
+		applyHeaderRules(w.Header(), w.rules)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *headerRewriteWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the underlying ResponseWriter, for [http.ResponseController].
+func (w *headerRewriteWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
 // parseRedirectWithCode parses a redirect string that may optionally start with
 // a HTTP redirect status code ("3xx:").
 // Returns the status code and the final redirect URL.
@@ -1144,6 +1298,13 @@ func (b *LocalBackend) serveWebHandler(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
+	if rw := h.Rewrite(); rw.Valid() && rw.ResponseHeaders().Valid() {
+		w = &headerRewriteWriter{ResponseWriter: w, rules: rw.ResponseHeaders()}
+	}
+	if a := h.Access(); a.Valid() && !b.serveAccessAllowed(r, a) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
 	if s := h.Text(); s != "" {
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 		io.WriteString(w, s)
@@ -1160,8 +1321,14 @@ func (b *LocalBackend) serveWebHandler(w http.ResponseWriter, r *http.Request) {
 		b.serveFileOrDirectory(w, r, v, mountPoint)
 		return
 	}
-	if v := h.Proxy(); v != "" {
-		p, ok := b.serveProxyHandlers.Load(v)
+	if v := h.Proxy(); v != "" || h.Backends().Len() > 0 {
+		var p any
+		var ok bool
+		if v != "" {
+			p, ok = b.serveProxyHandlers.Load(v)
+		} else {
+			p, ok = b.serveBackendPools.Load(h.BackendPoolKey())
+		}
 		if !ok {
 			http.Error(w, "unknown proxy destination", http.StatusInternalServerError)
 			return
@@ -1172,6 +1339,7 @@ func (b *LocalBackend) serveWebHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		c.AppCapabilities = h.AcceptAppCaps()
+		c.Rewrite = h.Rewrite()
 		h := p.(http.Handler)
 		// Trim the mount point from the URL path before proxying. (#6571)
 		if r.URL.Path != "/" {
@@ -1525,6 +1693,7 @@ func serveSetTCPPortsInterceptedFromNetmapAndPrefsLocked(b *LocalBackend, prefs
 			b.updateServeTCPPortNetMapAddrListenersLocked(servePorts)
 		}
 	}
+	b.setServeBackendPoolsLocked()
 
 	b.setVIPServicesTCPPortsInterceptedLocked(vipServicesPorts)
 
@@ -1673,6 +1842,16 @@ func validateServeConfigUpdate(existing, incoming ipn.ServeConfigView) error {
 		}
 	}
 
+	if err := validateServeBackends(incoming); err != nil {
+		return err
+	}
+	if err := validateServeRewrites(incoming); err != nil {
+		return err
+	}
+	if err := validateServeAccess(incoming); err != nil {
+		return err
+	}
+
 	if !existing.Valid() {
 		return nil
 	}
@@ -1751,6 +1930,147 @@ func validateServeConfigUpdate(existing, incoming ipn.ServeConfigView) error {
 	return nil
 }
 
+// validateServeBackends checks the handlers with multiple backends in sc.
+func validateServeBackends(sc ipn.ServeConfigView) error {
+	checkLB := func(lb ipn.LoadBalancingView) error {
+		switch {
+		case !lb.Valid():
+			return nil
+		case !lb.Policy().Valid():
+			return fmt.Errorf("unknown load balancing policy %q", lb.Policy())
+		case lb.HealthCheckInterval().Duration < 0, lb.FailTimeout().Duration < 0, lb.MaxFails() < 0:
+			return errors.New("load balancing settings cannot be negative")
+		}
+		return nil
+	}
+	checkTCP := func(port uint16, h ipn.TCPPortHandlerView) error {
+		if h.TCPForwardBackends().Len() == 0 {
+			return nil
+		}
+		if h.TCPForward() != "" {
+			return fmt.Errorf("port %d cannot forward to both a single backend and a pool of backends", port)
+		}
+		if err := checkLB(h.LoadBalancing()); err != nil {
+			return fmt.Errorf("port %d: %w", port, err)
+		}
+		return nil
+	}
+
+	for hp, conf := range sc.Webs() {
+		for mount, h := range conf.Handlers().All() {
+			if h.Backends().Len() == 0 {
+				continue
+			}
+			if h.Proxy() != "" {
+				return fmt.Errorf("handler for %s%s cannot proxy to both a single backend and a pool of backends", hp, mount)
+			}
+			if err := checkLB(h.LoadBalancing()); err != nil {
+				return fmt.Errorf("handler for %s%s: %w", hp, mount, err)
+			}
+		}
+	}
+	for port, h := range sc.TCPs() {
+		if err := checkTCP(port, h); err != nil {
+			return err
+		}
+	}
+	for svcName, svc := range sc.Services().All() {
+		for port, h := range svc.TCP().All() {
+			if err := checkTCP(port, h); err != nil {
+				return fmt.Errorf("%s: %w", svcName, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validateServeAccess validates the access control policies of sc's HTTP
+// handlers.
+func validateServeAccess(sc ipn.ServeConfigView) error {
+	check := func(a ipn.HTTPAccessView) error {
+		if !a.Valid() {
+			return nil
+		}
+		if !a.Funnel().Valid() {
+			return fmt.Errorf("unknown Funnel access policy %q", a.Funnel())
+		}
+		for _, tag := range a.Tags().All() {
+			if err := tailcfg.CheckTag(tag); err != nil {
+				return err
+			}
+		}
+		if a.Users().Len() == 0 && a.Groups().Len() == 0 && a.Tags().Len() == 0 && a.Caps().Len() == 0 && a.Funnel() != ipn.FunnelAccessAllow {
+			return errors.New("access policy allows no one")
+		}
+		return nil
+	}
+	for hp, conf := range sc.Webs() {
+		for mount, h := range conf.Handlers().All() {
+			if err := check(h.Access()); err != nil {
+				return fmt.Errorf("handler for %s%s: %w", hp, mount, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validateServeRewrites validates the rewrite rules of sc's HTTP handlers.
+func validateServeRewrites(sc ipn.ServeConfigView) error {
+	checkHeaders := func(rules ipn.HeaderRulesView) error {
+		if !rules.Valid() {
+			return nil
+		}
+		for _, k := range rules.Remove().All() {
+			if !httpguts.ValidHeaderFieldName(k) {
+				return fmt.Errorf("invalid header name %q", k)
+			}
+		}
+		for _, m := range []views.Map[string, string]{rules.Set(), rules.Add()} {
+			for k, v := range m.All() {
+				if !httpguts.ValidHeaderFieldName(k) {
+					return fmt.Errorf("invalid header name %q", k)
+				}
+				if !httpguts.ValidHeaderFieldValue(v) {
+					return fmt.Errorf("invalid value for header %q", k)
+				}
+			}
+		}
+		return nil
+	}
+	check := func(h ipn.HTTPHandlerView) error {
+		rw := h.Rewrite()
+		if !rw.Valid() {
+			return nil
+		}
+		isProxy := h.Proxy() != "" || h.Backends().Len() > 0
+		if !isProxy && (rw.StripPrefix() != "" || rw.Host() != "" || rw.RequestHeaders().Valid()) {
+			return errors.New("request rewrites are only supported for proxies")
+		}
+		if p := rw.StripPrefix(); p != "" && !strings.HasPrefix(p, "/") {
+			return fmt.Errorf("prefix to strip %q must start with /", p)
+		}
+		if host := rw.Host(); host != "" && (!httpguts.ValidHostHeader(host) || strings.Contains(host, "/")) {
+			return fmt.Errorf("invalid host %q", host)
+		}
+		if err := checkHeaders(rw.RequestHeaders()); err != nil {
+			return fmt.Errorf("request headers: %w", err)
+		}
+		if err := checkHeaders(rw.ResponseHeaders()); err != nil {
+			return fmt.Errorf("response headers: %w", err)
+		}
+		return nil
+	}
+
+	for hp, conf := range sc.Webs() {
+		for mount, h := range conf.Handlers().All() {
+			if err := check(h); err != nil {
+				return fmt.Errorf("handler for %s%s: %w", hp, mount, err)
+			}
+		}
+	}
+	return nil
+}
+
 // serveType is a high-level descriptor of the kind of serve performed by a TCP
 // port handler.
 type serveType int
@@ -1787,7 +2107,7 @@ func serveTypeFromPortHandler(ph ipn.TCPPortHandlerView) serveType {
 		return serveTypeHTTPS
 	case ph.TerminateTLS() != "":
 		return serveTypeTLSTerminatedTCP
-	case ph.TCPForward() != "":
+	case ph.TCPForward() != "" || ph.TCPForwardBackends().Len() > 0:
 		return serveTypeTCP
 	default:
 		return -1
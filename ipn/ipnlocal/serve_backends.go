@@ -0,0 +1,420 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !ts_omit_serve
+
+package ipnlocal
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/types/logger"
+	"tailscale.com/types/views"
+)
+
+const (
+	defaultServeBackendMaxFails    = 3
+	defaultServeBackendFailTimeout = 30 * time.Second
+
+	// serveHealthCheckTimeout is the longest an active health check of a
+	// serve backend may take, if shorter than the health check interval.
+	serveHealthCheckTimeout = 5 * time.Second
+)
+
+var errNoHealthyBackends = errors.New("no healthy backends")
+
+// serveBackendPool is a pool of backends for an ipn.HTTPHandler with
+// Backends or an ipn.TCPPortHandler with TCPForwardBackends. Pools are keyed
+// by their BackendPoolKey in LocalBackend.serveBackendPools.
+type serveBackendPool struct {
+	lb       *LocalBackend
+	logf     logger.Logf
+	backends []*serveBackend
+
+	policy              ipn.LBPolicy
+	healthCheckInterval time.Duration // or zero for passive checks only
+	healthCheckPath     string
+	maxFails            int
+	failTimeout         time.Duration
+
+	next   atomic.Uint32 // index of the backend to try first
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// serveBackend is a backend in a serveBackendPool, along with its health.
+type serveBackend struct {
+	pool   *serveBackendPool
+	target string        // as listed in the handler's config
+	proxy  *reverseProxy // for HTTP backends; nil for TCP backends
+
+	conns atomic.Int64 // in-flight requests or open connections
+
+	mu           sync.Mutex
+	healthy      bool
+	fails        int       // consecutive failures
+	ejectedUntil time.Time // when to retry an ejected backend without active checks
+	lastErr      error
+	lastCheck    time.Time // of the last active health check
+}
+
+// newServeBackendPool returns a new pool of backends. If isHTTP is true, the
+// backends are HTTPHandler.Proxy-style targets; otherwise they are IP:port
+// TCP targets. The pool's active health checks, if any, don't start until
+// start is called.
+func (b *LocalBackend) newServeBackendPool(isHTTP bool, targets views.Slice[string], lbv ipn.LoadBalancingView) (*serveBackendPool, error) {
+	p := &serveBackendPool{
+		lb:          b,
+		logf:        b.logf,
+		policy:      ipn.LBRoundRobin,
+		maxFails:    defaultServeBackendMaxFails,
+		failTimeout: defaultServeBackendFailTimeout,
+	}
+	if lbv.Valid() {
+		p.policy = cmp.Or(lbv.Policy(), p.policy)
+		p.healthCheckInterval = lbv.HealthCheckInterval().Duration
+		p.healthCheckPath = lbv.HealthCheckPath()
+		p.maxFails = cmp.Or(lbv.MaxFails(), p.maxFails)
+		p.failTimeout = cmp.Or(lbv.FailTimeout().Duration, p.failTimeout)
+	}
+	for _, target := range targets.All() {
+		be := &serveBackend{pool: p, target: target, healthy: true}
+		if isHTTP {
+			h, err := b.proxyHandlerForBackend(target)
+			if err != nil {
+				for _, be := range p.backends {
+					be.proxy.close()
+				}
+				return nil, err
+			}
+			be.proxy = h.(*reverseProxy)
+			be.proxy.health = be
+		}
+		p.backends = append(p.backends, be)
+	}
+	return p, nil
+}
+
+// start starts the active health checks of the pool's backends, if
+// configured, until close is called or b shuts down.
+func (p *serveBackendPool) start() {
+	p.ctx, p.cancel = context.WithCancel(p.lb.ctx)
+	if p.healthCheckInterval <= 0 {
+		return
+	}
+	for _, be := range p.backends {
+		p.lb.goTracker.Go(func() { be.runHealthChecks(p.ctx) })
+	}
+}
+
+// close stops the pool's health checks and closes idle connections to its
+// backends.
+func (p *serveBackendPool) close() {
+	if p.cancel != nil {
+		p.cancel()
+	}
+	for _, be := range p.backends {
+		if be.proxy != nil {
+			be.proxy.close()
+		}
+	}
+}
+
+// pick returns the backend to use for the next request or connection,
+// according to the pool's policy, skipping backends in skip. It returns nil
+// if no healthy backend remains.
+func (p *serveBackendPool) pick(skip []*serveBackend) *serveBackend {
+	now := p.lb.clock.Now()
+	n := len(p.backends)
+	start := int((p.next.Add(1) - 1) % uint32(n))
+	var best *serveBackend
+	for i := range n {
+		be := p.backends[(start+i)%n]
+		if slices.Contains(skip, be) || !be.available(now) {
+			continue
+		}
+		if p.policy != ipn.LBLeastConns {
+			return be
+		}
+		if best == nil || be.conns.Load() < best.conns.Load() {
+			best = be
+		}
+	}
+	return best
+}
+
+// ServeHTTP proxies r to a healthy backend of the pool.
+func (p *serveBackendPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	be := p.pick(nil)
+	if be == nil {
+		http.Error(w, errNoHealthyBackends.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	be.conns.Add(1)
+	defer be.done()
+	be.proxy.ServeHTTP(w, r)
+}
+
+// dial dials a healthy TCP backend of the pool, trying each healthy backend
+// in turn until one succeeds. On success, the caller must call done on the
+// returned backend when the connection is closed.
+func (p *serveBackendPool) dial() (net.Conn, *serveBackend, error) {
+	var tried []*serveBackend
+	for {
+		be := p.pick(tried)
+		if be == nil {
+			return nil, nil, errNoHealthyBackends
+		}
+		tried = append(tried, be)
+		be.conns.Add(1)
+		ctx, cancel := context.WithTimeout(p.ctx, 10*time.Second)
+		c, err := p.lb.dialer.SystemDial(ctx, "tcp", be.target)
+		cancel()
+		if err != nil {
+			be.done()
+			be.markFailed(err)
+			continue
+		}
+		be.markSucceeded()
+		return c, be, nil
+	}
+}
+
+// health returns the health of the pool's backends.
+func (p *serveBackendPool) health() []ipn.ServeBackendHealth {
+	now := p.lb.clock.Now()
+	ret := make([]ipn.ServeBackendHealth, 0, len(p.backends))
+	for _, be := range p.backends {
+		be.mu.Lock()
+		h := ipn.ServeBackendHealth{
+			Backend:   be.target,
+			Healthy:   be.availableLocked(now),
+			Conns:     int(be.conns.Load()),
+			Fails:     be.fails,
+			LastCheck: be.lastCheck,
+		}
+		if be.lastErr != nil {
+			h.LastError = be.lastErr.Error()
+		}
+		be.mu.Unlock()
+		ret = append(ret, h)
+	}
+	return ret
+}
+
+// done records the end of a request or connection to be.
+func (be *serveBackend) done() {
+	be.conns.Add(-1)
+}
+
+// available reports whether be may be picked at time now.
+func (be *serveBackend) available(now time.Time) bool {
+	be.mu.Lock()
+	defer be.mu.Unlock()
+	return be.availableLocked(now)
+}
+
+func (be *serveBackend) availableLocked(now time.Time) bool {
+	if be.healthy {
+		return true
+	}
+	// Without active health checks, retry ejected backends once their
+	// timeout passes; the outcome of that traffic decides their health.
+	return be.pool.healthCheckInterval <= 0 && !now.Before(be.ejectedUntil)
+}
+
+// markSucceeded records a successful request, connection or health check,
+// returning be to its pool if it was ejected.
+func (be *serveBackend) markSucceeded() {
+	be.mu.Lock()
+	defer be.mu.Unlock()
+	if !be.healthy {
+		be.pool.logf("serve: backend %s is healthy again", be.target)
+	}
+	be.healthy = true
+	be.fails = 0
+}
+
+// markFailed records a failed request, connection or health check, ejecting
+// be from its pool once it has failed maxFails times in a row.
+func (be *serveBackend) markFailed(err error) {
+	be.mu.Lock()
+	defer be.mu.Unlock()
+	be.fails++
+	be.lastErr = err
+	if be.fails < be.pool.maxFails {
+		return
+	}
+	if be.healthy {
+		be.pool.logf("serve: ejecting backend %s after %d failures: %v", be.target, be.fails, err)
+	}
+	be.healthy = false
+	be.ejectedUntil = be.pool.lb.clock.Now().Add(be.pool.failTimeout)
+}
+
+// runHealthChecks actively checks the health of be until ctx is done.
+func (be *serveBackend) runHealthChecks(ctx context.Context) {
+	ticker, tickc := be.pool.lb.clock.NewTicker(be.pool.healthCheckInterval)
+	defer ticker.Stop()
+	for {
+		be.checkHealth(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-tickc:
+		}
+	}
+}
+
+// checkHealth runs one active health check of be.
+func (be *serveBackend) checkHealth(ctx context.Context) {
+	p := be.pool
+	checkCtx, cancel := context.WithTimeout(ctx, min(p.healthCheckInterval, serveHealthCheckTimeout))
+	defer cancel()
+	err := be.probe(checkCtx)
+	if ctx.Err() != nil {
+		// The pool was closed mid-check.
+		return
+	}
+	be.mu.Lock()
+	be.lastCheck = p.lb.clock.Now()
+	be.mu.Unlock()
+	if err != nil {
+		be.markFailed(err)
+	} else {
+		be.markSucceeded()
+	}
+}
+
+// probe checks whether be is reachable and, for HTTP backends with a
+// health check path, responds successfully.
+func (be *serveBackend) probe(ctx context.Context) error {
+	if be.proxy == nil {
+		c, err := be.pool.lb.dialer.SystemDial(ctx, "tcp", be.target)
+		if err != nil {
+			return err
+		}
+		return c.Close()
+	}
+	if be.pool.healthCheckPath == "" {
+		c, err := be.proxy.dialBackend(ctx)
+		if err != nil {
+			return err
+		}
+		return c.Close()
+	}
+	u := *be.proxy.url
+	u.Path, u.RawPath, u.RawQuery = be.pool.healthCheckPath, "", ""
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	res, err := be.proxy.getTransport().RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= 400 {
+		return fmt.Errorf("health check: %s", res.Status)
+	}
+	return nil
+}
+
+// setServeBackendPoolsLocked ensures there is a backend pool for each
+// handler with multiple backends in serveConfig, and closes pools that are
+// no longer used. Like setServeProxyHandlersLocked, it expects serveConfig
+// to be up-to-date.
+func (b *LocalBackend) setServeBackendPoolsLocked() {
+	var keys map[string]bool
+	addPool := func(key string, isHTTP bool, targets views.Slice[string], lbv ipn.LoadBalancingView) {
+		if key == "" || keys[key] {
+			return
+		}
+		if keys == nil {
+			keys = make(map[string]bool)
+		}
+		keys[key] = true
+		if _, ok := b.serveBackendPools.Load(key); ok {
+			return
+		}
+		b.logf("serve: creating a new backend pool for %s", key)
+		p, err := b.newServeBackendPool(isHTTP, targets, lbv)
+		if err != nil {
+			b.logf("[unexpected] could not create backend pool for %s: %v", key, err)
+			return
+		}
+		p.start()
+		b.serveBackendPools.Store(key, p)
+	}
+	if b.serveConfig.Valid() {
+		for _, conf := range b.serveConfig.Webs() {
+			for _, h := range conf.Handlers().All() {
+				addPool(h.BackendPoolKey(), true, h.Backends(), h.LoadBalancing())
+			}
+		}
+		for _, h := range b.serveConfig.TCPs() {
+			addPool(h.BackendPoolKey(), false, h.TCPForwardBackends(), h.LoadBalancing())
+		}
+		for _, svc := range b.serveConfig.Services().All() {
+			for _, h := range svc.TCP().All() {
+				addPool(h.BackendPoolKey(), false, h.TCPForwardBackends(), h.LoadBalancing())
+			}
+		}
+	}
+
+	b.serveBackendPools.Range(func(key, value any) bool {
+		if !keys[key.(string)] {
+			b.logf("serve: closing backend pool for %s", key)
+			b.serveBackendPools.Delete(key)
+			value.(*serveBackendPool).close()
+		}
+		return true
+	})
+}
+
+// tcpHandlerForBackendPool returns a handler that forwards TCP connections
+// from srcAddr to the backends of the pool for tcph.
+func (b *LocalBackend) tcpHandlerForBackendPool(tcph ipn.TCPPortHandlerView, srcAddr netip.AddrPort, dport uint16) func(net.Conn) error {
+	key := tcph.BackendPoolKey()
+	return func(conn net.Conn) error {
+		defer conn.Close()
+		v, ok := b.serveBackendPools.Load(key)
+		if !ok {
+			b.logf("[unexpected] localbackend: no backend pool for port %v", dport)
+			return nil
+		}
+		backConn, be, err := v.(*serveBackendPool).dial()
+		if err != nil {
+			b.logf("localbackend: failed to TCP proxy port %v (from %v) to backend pool: %v", dport, srcAddr, err)
+			return nil
+		}
+		defer be.done()
+		defer backConn.Close()
+		if sni := tcph.TerminateTLS(); sni != "" {
+			conn = b.tlsServerForServe(conn, sni)
+		}
+		return b.forwardTCPWithProxyProtocol(conn, backConn, tcph.ProxyProtocol(), srcAddr, dport, be.target)
+	}
+}
+
+// ServeBackendHealth returns the health of the backends of each serve
+// backend pool, keyed by the pools' BackendPoolKey.
+func (b *LocalBackend) ServeBackendHealth() map[string][]ipn.ServeBackendHealth {
+	ret := make(map[string][]ipn.ServeBackendHealth)
+	b.serveBackendPools.Range(func(key, value any) bool {
+		ret[key.(string)] = value.(*serveBackendPool).health()
+		return true
+	})
+	return ret
+}
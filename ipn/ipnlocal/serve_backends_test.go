@@ -0,0 +1,305 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !ts_omit_serve
+
+package ipnlocal
+
+import (
+	"crypto/tls"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/tstest"
+	"tailscale.com/tstime"
+	"tailscale.com/types/views"
+)
+
+// newNamedTestServer returns a test HTTP server that reports name in its
+// responses' Backend header, and whose /healthz endpoint fails while
+// unhealthy is true.
+func newNamedTestServer(t *testing.T, name string, unhealthy *atomic.Bool) *httptest.Server {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" && unhealthy != nil && unhealthy.Load() {
+			http.Error(w, "unhealthy", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Backend", name)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// serveTestRequest serves a request for path on example.ts.net:443 and
+// returns its response.
+func serveTestRequest(b *LocalBackend, path string) *http.Response {
+	req := &http.Request{
+		URL: &url.URL{Path: path},
+		TLS: &tls.ConnectionState{ServerName: "example.ts.net"},
+	}
+	req = req.WithContext(serveHTTPContextKey.WithValue(req.Context(), &serveHTTPContext{
+		DestPort: 443,
+		SrcAddr:  netip.MustParseAddrPort("100.150.151.152:1234"),
+	}))
+	w := httptest.NewRecorder()
+	b.serveWebHandler(w, req)
+	return w.Result()
+}
+
+func setBackendPoolConfig(t *testing.T, b *LocalBackend, h *ipn.HTTPHandler) {
+	t.Helper()
+	conf := &ipn.ServeConfig{
+		TCP: map[uint16]*ipn.TCPPortHandler{443: {HTTPS: true}},
+		Web: map[ipn.HostPort]*ipn.WebServerConfig{
+			"example.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{"/": h}},
+		},
+	}
+	if err := b.SetServeConfig(conf, ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func backendHealth(b *LocalBackend, key, backend string) (h ipn.ServeBackendHealth, ok bool) {
+	for _, h := range b.ServeBackendHealth()[key] {
+		if h.Backend == backend {
+			return h, true
+		}
+	}
+	return h, false
+}
+
+func TestServeBackendPoolPassiveEjection(t *testing.T) {
+	b := newTestBackend(t)
+	s1 := newNamedTestServer(t, "s1", nil)
+	s2 := newNamedTestServer(t, "s2", nil)
+	h := &ipn.HTTPHandler{Backends: []string{s1.URL, s2.URL}}
+	setBackendPoolConfig(t, b, h)
+	key := h.View().BackendPoolKey()
+
+	got := map[string]int{}
+	for range 4 {
+		res := serveTestRequest(b, "/")
+		got[res.Header.Get("Backend")]++
+	}
+	if got["s1"] != 2 || got["s2"] != 2 {
+		t.Fatalf("round-robin requests = %v; want 2 each", got)
+	}
+
+	s2.Close()
+	var badGateways int
+	for range 10 {
+		res := serveTestRequest(b, "/")
+		switch {
+		case res.StatusCode == http.StatusBadGateway:
+			badGateways++
+		case res.Header.Get("Backend") != "s1":
+			t.Fatalf("unexpected response %v from %q", res.Status, res.Header.Get("Backend"))
+		}
+	}
+	if badGateways != defaultServeBackendMaxFails {
+		t.Errorf("got %d failed requests before ejection; want %d", badGateways, defaultServeBackendMaxFails)
+	}
+	if h, ok := backendHealth(b, key, s2.URL); !ok || h.Healthy || h.LastError == "" {
+		t.Errorf("health of closed backend = %+v, %v; want unhealthy with an error", h, ok)
+	}
+	if h, ok := backendHealth(b, key, s1.URL); !ok || !h.Healthy {
+		t.Errorf("health of open backend = %+v, %v; want healthy", h, ok)
+	}
+
+	// Removing the handler closes its pool.
+	if err := b.SetServeConfig(&ipn.ServeConfig{}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if health := b.ServeBackendHealth(); len(health) != 0 {
+		t.Errorf("backend health after reset = %v; want none", health)
+	}
+}
+
+func TestServeBackendPoolPassiveGatewayErrors(t *testing.T) {
+	b := newTestBackend(t)
+	s1 := newNamedTestServer(t, "s1", nil)
+	s2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "overloaded", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(s2.Close)
+	h := &ipn.HTTPHandler{Backends: []string{s1.URL, s2.URL}}
+	setBackendPoolConfig(t, b, h)
+
+	var unavailable int
+	for range 10 {
+		res := serveTestRequest(b, "/")
+		switch {
+		case res.StatusCode == http.StatusServiceUnavailable:
+			unavailable++
+		case res.Header.Get("Backend") != "s1":
+			t.Fatalf("unexpected response %v from %q", res.Status, res.Header.Get("Backend"))
+		}
+	}
+	if unavailable != defaultServeBackendMaxFails {
+		t.Errorf("got %d 503 responses before ejection; want %d", unavailable, defaultServeBackendMaxFails)
+	}
+	if h, ok := backendHealth(b, h.View().BackendPoolKey(), s2.URL); !ok || h.Healthy {
+		t.Errorf("health of failing backend = %+v, %v; want unhealthy", h, ok)
+	}
+}
+
+func TestServeBackendPoolActiveHealthCheck(t *testing.T) {
+	b := newTestBackend(t)
+	var unhealthy atomic.Bool
+	unhealthy.Store(true)
+	s1 := newNamedTestServer(t, "s1", nil)
+	s2 := newNamedTestServer(t, "s2", &unhealthy)
+	h := &ipn.HTTPHandler{
+		Backends: []string{s1.URL, s2.URL},
+		LoadBalancing: &ipn.LoadBalancing{
+			HealthCheckInterval: tstime.GoDuration{Duration: 10 * time.Millisecond},
+			HealthCheckPath:     "/healthz",
+			MaxFails:            1,
+		},
+	}
+	setBackendPoolConfig(t, b, h)
+	key := h.View().BackendPoolKey()
+
+	waitForHealth := func(want bool) {
+		t.Helper()
+		for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if h, ok := backendHealth(b, key, s2.URL); ok && h.Healthy == want && !h.LastCheck.IsZero() {
+				return
+			}
+		}
+		t.Fatalf("backend %s did not become healthy=%v", s2.URL, want)
+	}
+
+	waitForHealth(false)
+	for range 4 {
+		if got := serveTestRequest(b, "/").Header.Get("Backend"); got != "s1" {
+			t.Fatalf("request served by %q; want s1", got)
+		}
+	}
+
+	unhealthy.Store(false)
+	waitForHealth(true)
+	got := map[string]int{}
+	for range 4 {
+		got[serveTestRequest(b, "/").Header.Get("Backend")]++
+	}
+	if got["s1"] != 2 || got["s2"] != 2 {
+		t.Fatalf("round-robin requests = %v; want 2 each", got)
+	}
+}
+
+func TestServeBackendPoolPick(t *testing.T) {
+	b := newTestBackend(t)
+	clock := tstest.NewClock(tstest.ClockOpts{})
+	b.clock = clock
+
+	targets := views.SliceOf([]string{"127.0.0.1:1", "127.0.0.1:2", "127.0.0.1:3"})
+	p, err := b.newServeBackendPool(false, targets, (&ipn.LoadBalancing{Policy: ipn.LBLeastConns}).View())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, c := p.backends[0], p.backends[2]
+	a.conns.Store(2)
+	p.backends[1].conns.Store(1)
+	if got := p.pick(nil); got != c {
+		t.Errorf("least-conns picked %s; want %s", got.target, c.target)
+	}
+	if got := p.pick([]*serveBackend{c}); got != p.backends[1] {
+		t.Errorf("least-conns skipping %s picked %s; want %s", c.target, got.target, p.backends[1].target)
+	}
+
+	for range defaultServeBackendMaxFails {
+		c.markFailed(io.EOF)
+	}
+	if c.available(clock.Now()) {
+		t.Fatalf("backend available after %d failures", defaultServeBackendMaxFails)
+	}
+	if got := p.pick(nil); got == c {
+		t.Errorf("least-conns picked ejected backend %s", c.target)
+	}
+	clock.Advance(defaultServeBackendFailTimeout)
+	if !c.available(clock.Now()) {
+		t.Fatalf("backend not retried after fail timeout")
+	}
+	c.markSucceeded()
+	if h := p.health()[2]; !h.Healthy || h.Fails != 0 {
+		t.Errorf("health after success = %+v; want healthy", h)
+	}
+
+	p.policy = ipn.LBRoundRobin
+	var order []string
+	for range 4 {
+		order = append(order, p.pick(nil).target)
+	}
+	if order[0] == order[1] || order[1] == order[2] || order[0] != order[3] {
+		t.Errorf("round-robin order = %v", order)
+	}
+
+	// The counter wraps around without picking an invalid index.
+	p.next.Store(math.MaxUint32)
+	for range 2 {
+		if got := p.pick(nil); got == nil {
+			t.Fatal("round-robin picked nothing after counter wrap")
+		}
+	}
+}
+
+func TestServeBackendPoolTCP(t *testing.T) {
+	b := newTestBackend(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			io.WriteString(c, "hello")
+			c.Close()
+		}
+	}()
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := closed.Addr().String()
+	closed.Close()
+
+	tcph := &ipn.TCPPortHandler{TCPForwardBackends: []string{closedAddr, ln.Addr().String()}}
+	conf := &ipn.ServeConfig{TCP: map[uint16]*ipn.TCPPortHandler{8443: tcph}}
+	if err := b.SetServeConfig(conf, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range 3 {
+		handler := b.tcpHandlerForServe(8443, netip.MustParseAddrPort("100.150.151.152:1234"), nil)
+		if handler == nil {
+			t.Fatal("no handler for backend pool")
+		}
+		client, server := net.Pipe()
+		go handler(server)
+		got, err := io.ReadAll(client)
+		client.Close()
+		if err != nil || string(got) != "hello" {
+			t.Fatalf("connection %d: got %q, %v; want hello", i, got, err)
+		}
+	}
+
+	h, ok := backendHealth(b, tcph.View().BackendPoolKey(), closedAddr)
+	if !ok || h.Fails == 0 || h.LastError == "" {
+		t.Errorf("health of closed backend = %+v, %v; want failures", h, ok)
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -801,6 +802,208 @@ func TestServeHTTPProxyHeaders(t *testing.T) {
 	}
 }
 
+func TestServeHTTPRewrite(t *testing.T) {
+	b := newTestBackend(t)
+
+	testServ := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			// Echo the request to the response headers, so that the
+			// rewritten request can be checked in tests.
+			for key, val := range r.Header {
+				w.Header().Add(key, strings.Join(val, ","))
+			}
+			w.Header().Set("Request-Path", r.URL.Path)
+			w.Header().Set("Request-Host", r.Host)
+			w.Header().Set("Server-Version", "1.0")
+		},
+	))
+	defer testServ.Close()
+
+	conf := &ipn.ServeConfig{
+		Web: map[ipn.HostPort]*ipn.WebServerConfig{
+			"example.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+				"/api/": {
+					Proxy: testServ.URL,
+					Rewrite: &ipn.HTTPRewrite{
+						StripPrefix: "/v1",
+						Host:        "backend.internal",
+						RequestHeaders: &ipn.HeaderRules{
+							Remove: []string{"Cookie"},
+							Set: map[string]string{
+								"X-Env":                "prod",
+								"Tailscale-User-Login": "spoofed@example.com",
+							},
+							Add: map[string]string{"X-Forwarded-For": "192.0.2.1"},
+						},
+						ResponseHeaders: &ipn.HeaderRules{
+							Remove: []string{"Server-Version"},
+							Set:    map[string]string{"Strict-Transport-Security": "max-age=31536000"},
+						},
+					},
+				},
+				"/": {
+					Text: "hello",
+					Rewrite: &ipn.HTTPRewrite{
+						ResponseHeaders: &ipn.HeaderRules{
+							Set: map[string]string{
+								"Access-Control-Allow-Origin": "*",
+								"Content-Type":                "text/html",
+							},
+						},
+					},
+				},
+			}},
+		},
+	}
+	if err := b.SetServeConfig(conf, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		path        string
+		wantHeaders map[string]string
+	}{
+		{
+			name: "proxy",
+			path: "/api/v1/users",
+			wantHeaders: map[string]string{
+				"Request-Path":              "/users",
+				"Request-Host":              "backend.internal",
+				"X-Env":                     "prod",
+				"Cookie":                    "",
+				"X-Forwarded-For":           "100.150.151.152,192.0.2.1",
+				"Tailscale-User-Login":      "someone@example.com",
+				"Server-Version":            "",
+				"Strict-Transport-Security": "max-age=31536000",
+			},
+		},
+		{
+			name: "proxy-without-prefix",
+			path: "/api/users",
+			wantHeaders: map[string]string{
+				"Request-Path": "/users",
+			},
+		},
+		{
+			name: "text",
+			path: "/",
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin": "*",
+				"Content-Type":                "text/html",
+				"Strict-Transport-Security":   "",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &http.Request{
+				Host:   "example.ts.net",
+				URL:    &url.URL{Path: tt.path},
+				Header: http.Header{"Cookie": {"session=secret"}},
+				TLS:    &tls.ConnectionState{ServerName: "example.ts.net"},
+			}
+			req = req.WithContext(serveHTTPContextKey.WithValue(req.Context(), &serveHTTPContext{
+				DestPort: 443,
+				SrcAddr:  netip.MustParseAddrPort("100.150.151.152:1234"),
+			}))
+
+			w := httptest.NewRecorder()
+			b.serveWebHandler(w, req)
+
+			h := w.Result().Header
+			for k, want := range tt.wantHeaders {
+				if got := strings.Join(h.Values(k), ","); got != want {
+					t.Errorf("%s header = %q; want %q", k, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestServeHTTPAccess(t *testing.T) {
+	b := newTestBackend(t)
+
+	nm := b.NetMap()
+	nm.UserProfiles[1] = (&tailcfg.UserProfile{
+		LoginName: "someone@example.com",
+		Groups:    []string{"group:eng"},
+	}).View()
+	matches, err := filter.MatchesFromFilterRules([]tailcfg.FilterRule{{
+		SrcIPs: []string{"100.150.151.153"},
+		CapGrant: []tailcfg.CapGrant{{
+			Dsts:   []netip.Prefix{netip.MustParsePrefix("100.150.151.151/32")},
+			CapMap: tailcfg.PeerCapMap{"example.com/cap/admin": nil},
+		}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nm.PacketFilter = matches
+	b.SetControlClientStatus(nil, controlclient.Status{NetMap: nm})
+
+	conf := &ipn.ServeConfig{
+		Web: map[ipn.HostPort]*ipn.WebServerConfig{
+			"example.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+				"/open":   {Text: "ok"},
+				"/users":  {Text: "ok", Access: &ipn.HTTPAccess{Users: []tailcfg.UserID{1}}},
+				"/groups": {Text: "ok", Access: &ipn.HTTPAccess{Groups: []string{"group:eng"}}},
+				"/tags":   {Text: "ok", Access: &ipn.HTTPAccess{Tags: []string{"tag:test"}}},
+				"/caps":   {Text: "ok", Access: &ipn.HTTPAccess{Caps: []tailcfg.PeerCapability{"example.com/cap/admin"}}},
+				"/funnel": {Text: "ok", Access: &ipn.HTTPAccess{Tags: []string{"tag:other"}, Funnel: ipn.FunnelAccessAllow}},
+			}},
+		},
+	}
+	if err := b.SetServeConfig(conf, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	const (
+		user    = "100.150.151.152" // user 1, in group:eng
+		tagged  = "100.150.151.153" // tag:server and tag:test, owned by user 1, granted example.com/cap/admin
+		outside = "100.160.161.162"
+		funnel  = "funnel"
+	)
+	tests := []struct {
+		path    string
+		allowed []string
+	}{
+		{"/open", []string{user, tagged, outside, funnel}},
+		{"/users", []string{user}},
+		{"/groups", []string{user}},
+		{"/tags", []string{tagged}},
+		{"/caps", []string{tagged}},
+		{"/funnel", []string{funnel}},
+	}
+	for _, tt := range tests {
+		for _, src := range []string{user, tagged, outside, funnel} {
+			c := &serveHTTPContext{DestPort: 443}
+			if src == funnel {
+				c.SrcAddr = netip.MustParseAddrPort("203.0.113.1:1234")
+				c.Funnel = &funnelFlow{Host: "example.ts.net"}
+			} else {
+				c.SrcAddr = netip.MustParseAddrPort(src + ":1234")
+			}
+			req := &http.Request{
+				URL: &url.URL{Path: tt.path},
+				TLS: &tls.ConnectionState{ServerName: "example.ts.net"},
+			}
+			req = req.WithContext(serveHTTPContextKey.WithValue(req.Context(), c))
+
+			w := httptest.NewRecorder()
+			b.serveWebHandler(w, req)
+
+			want := http.StatusForbidden
+			if slices.Contains(tt.allowed, src) {
+				want = http.StatusOK
+			}
+			if w.Code != want {
+				t.Errorf("%s from %s: got status %d, want %d", tt.path, src, w.Code, want)
+			}
+		}
+	}
+}
+
 func TestServeHTTPProxyGrantHeader(t *testing.T) {
 	b := newTestBackend(t)
 
@@ -1747,6 +1950,151 @@ func TestValidateServeConfigUpdate(t *testing.T) {
 			},
 			wantError: true,
 		},
+		{
+			name:        "backend pool",
+			description: "handlers can load balance across multiple backends",
+			incoming: &ipn.ServeConfig{
+				TCP: map[uint16]*ipn.TCPPortHandler{
+					443: {HTTPS: true},
+					8443: {
+						TCPForwardBackends: []string{"localhost:8080", "localhost:8081"},
+						LoadBalancing:      &ipn.LoadBalancing{Policy: ipn.LBLeastConns},
+					},
+				},
+				Web: map[ipn.HostPort]*ipn.WebServerConfig{
+					"example.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+						"/": {Backends: []string{"http://localhost:3000", "http://localhost:3001"}},
+					}},
+				},
+			},
+			wantError: false,
+		},
+		{
+			name:        "proxy and backend pool",
+			description: "handlers cannot have both a single proxy and multiple backends",
+			incoming: &ipn.ServeConfig{
+				Web: map[ipn.HostPort]*ipn.WebServerConfig{
+					"example.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+						"/": {
+							Proxy:    "http://localhost:3000",
+							Backends: []string{"http://localhost:3000", "http://localhost:3001"},
+						},
+					}},
+				},
+			},
+			wantError: true,
+		},
+		{
+			name:        "unknown load balancing policy",
+			description: "backend pools must use a known load balancing policy",
+			incoming: &ipn.ServeConfig{
+				Services: map[tailcfg.ServiceName]*ipn.ServiceConfig{
+					"svc:foo": {
+						TCP: map[uint16]*ipn.TCPPortHandler{
+							80: {
+								TCPForwardBackends: []string{"localhost:8080", "localhost:8081"},
+								LoadBalancing:      &ipn.LoadBalancing{Policy: "random"},
+							},
+						},
+					},
+				},
+			},
+			wantError: true,
+		},
+		{
+			name:        "rewrite",
+			description: "handlers can rewrite requests and responses",
+			incoming: &ipn.ServeConfig{
+				Web: map[ipn.HostPort]*ipn.WebServerConfig{
+					"example.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+						"/api": {
+							Proxy: "http://localhost:3000",
+							Rewrite: &ipn.HTTPRewrite{
+								StripPrefix:     "/v1",
+								Host:            "backend.internal:3000",
+								RequestHeaders:  &ipn.HeaderRules{Set: map[string]string{"X-Env": "prod"}},
+								ResponseHeaders: &ipn.HeaderRules{Remove: []string{"Server"}},
+							},
+						},
+						"/": {
+							Text:    "hello",
+							Rewrite: &ipn.HTTPRewrite{ResponseHeaders: &ipn.HeaderRules{Add: map[string]string{"Vary": "Origin"}}},
+						},
+					}},
+				},
+			},
+			wantError: false,
+		},
+		{
+			name:        "access policy",
+			description: "handlers can restrict which clients can use them",
+			incoming: &ipn.ServeConfig{
+				Web: map[ipn.HostPort]*ipn.WebServerConfig{
+					"example.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+						"/": {
+							Proxy: "http://localhost:3000",
+							Access: &ipn.HTTPAccess{
+								Users:  []tailcfg.UserID{1},
+								Tags:   []string{"tag:prod"},
+								Funnel: ipn.FunnelAccessDeny,
+							},
+						},
+					}},
+				},
+			},
+			wantError: false,
+		},
+		{
+			name:        "access policy allowing no one",
+			description: "access policies must allow some clients",
+			incoming: &ipn.ServeConfig{
+				Web: map[ipn.HostPort]*ipn.WebServerConfig{
+					"example.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+						"/": {Proxy: "http://localhost:3000", Access: &ipn.HTTPAccess{}},
+					}},
+				},
+			},
+			wantError: true,
+		},
+		{
+			name:        "access policy with invalid tag",
+			description: "access policy tags must be valid",
+			incoming: &ipn.ServeConfig{
+				Web: map[ipn.HostPort]*ipn.WebServerConfig{
+					"example.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+						"/": {Proxy: "http://localhost:3000", Access: &ipn.HTTPAccess{Tags: []string{"prod"}}},
+					}},
+				},
+			},
+			wantError: true,
+		},
+		{
+			name:        "request rewrite without proxy",
+			description: "only proxies can rewrite requests",
+			incoming: &ipn.ServeConfig{
+				Web: map[ipn.HostPort]*ipn.WebServerConfig{
+					"example.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+						"/": {Text: "hello", Rewrite: &ipn.HTTPRewrite{Host: "example.com"}},
+					}},
+				},
+			},
+			wantError: true,
+		},
+		{
+			name:        "invalid rewrite header",
+			description: "header rewrites must use valid header names",
+			incoming: &ipn.ServeConfig{
+				Web: map[ipn.HostPort]*ipn.WebServerConfig{
+					"example.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+						"/": {
+							Proxy:   "http://localhost:3000",
+							Rewrite: &ipn.HTTPRewrite{ResponseHeaders: &ipn.HeaderRules{Set: map[string]string{"Bad Header": "x"}}},
+						},
+					}},
+				},
+			},
+			wantError: true,
+		},
 	}
 
 	for _, tt := range tests {
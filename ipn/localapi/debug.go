@@ -24,6 +24,7 @@ import (
 	"tailscale.com/feature"
 	"tailscale.com/feature/buildfeatures"
 	"tailscale.com/ipn"
+	"tailscale.com/types/ipproto"
 	"tailscale.com/types/logger"
 	"tailscale.com/util/eventbus"
 	"tailscale.com/util/httpm"
@@ -40,6 +41,7 @@ func init() {
 	Register("debug-derp-region", (*Handler).serveDebugDERPRegion)
 	Register("debug-dial-types", (*Handler).serveDebugDialTypes)
 	Register("debug-log", (*Handler).serveDebugLog)
+	Register("debug-filter-check", (*Handler).serveDebugFilterCheck)
 	Register("debug-packet-filter-matches", (*Handler).serveDebugPacketFilterMatches)
 	Register("debug-packet-filter-rules", (*Handler).serveDebugPacketFilterRules)
 	Register("debug-peer-endpoint-changes", (*Handler).serveDebugPeerEndpointChanges)
@@ -305,6 +307,66 @@ func (h *Handler) serveDebugPacketFilterMatches(w http.ResponseWriter, r *http.R
 	enc.Encode(nm.PacketFilter)
 }
 
+// serveDebugFilterCheck reports what the packet filter does with a new
+// incoming connection from the "src" IP to the "dst" IP (default: this node)
+// and "port", using IP protocol "proto" (default: tcp).
+func (h *Handler) serveDebugFilterCheck(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "debug access denied", http.StatusForbidden)
+		return
+	}
+	src, err := netip.ParseAddr(r.FormValue("src"))
+	if err != nil {
+		http.Error(w, "invalid or missing 'src' parameter", http.StatusBadRequest)
+		return
+	}
+	var dst netip.Addr
+	if v := r.FormValue("dst"); v != "" {
+		dst, err = netip.ParseAddr(v)
+		if err != nil {
+			http.Error(w, "invalid 'dst' parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	var port uint64
+	if v := r.FormValue("port"); v != "" {
+		port, err = strconv.ParseUint(v, 10, 16)
+		if err != nil {
+			http.Error(w, "invalid 'port' parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	proto := ipproto.TCP
+	if v := r.FormValue("proto"); v != "" {
+		if err := proto.UnmarshalText([]byte(v)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	res, rule, err := h.b.CheckFilter(src, dst, uint16(port), proto)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	resp := apitype.FilterCheckResponse{
+		Response:      res.Response.String(),
+		Why:           res.Why,
+		RuleIndex:     res.MatchIndex,
+		Rule:          rule,
+		MatchedSrcCap: res.SrcCap,
+	}
+	if res.Src.IsValid() {
+		resp.MatchedSrc = res.Src.String()
+	}
+	if res.MatchIndex >= 0 {
+		resp.MatchedDst = res.Dst.String()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	enc.Encode(resp)
+}
+
 // debugEventError provides the JSON encoding of internal errors from event processing.
 type debugEventError struct {
 	Error string
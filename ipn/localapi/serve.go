@@ -20,6 +20,7 @@ import (
 
 func init() {
 	Register("serve-config", (*Handler).serveServeConfig)
+	Register("serve-backend-health", (*Handler).serveServeBackendHealth)
 }
 
 func (h *Handler) serveServeConfig(w http.ResponseWriter, r *http.Request) {
@@ -76,6 +77,21 @@ func (h *Handler) serveServeConfig(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// serveServeBackendHealth returns the health of the backends of serve
+// handlers with multiple backends, keyed by the handlers' BackendPoolKey.
+func (h *Handler) serveServeBackendHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != httpm.GET {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.PermitRead {
+		http.Error(w, "serve backend health access denied", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.b.ServeBackendHealth())
+}
+
 func authorizeServeConfigForGOOSAndUserContext(goos string, configIn *ipn.ServeConfig, h *Handler) error {
 	switch goos {
 	case "windows", "linux", "darwin", "illumos", "solaris":
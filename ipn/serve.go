@@ -14,10 +14,13 @@ import (
 	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/tailcfg"
+	"tailscale.com/tstime"
 	"tailscale.com/types/ipproto"
+	"tailscale.com/types/views"
 	"tailscale.com/util/dnsname"
 	"tailscale.com/util/mak"
 	"tailscale.com/util/set"
@@ -143,9 +146,16 @@ type TCPPortHandler struct {
 	// Whether or not TLS is terminated by tailscaled depends on
 	// TerminateTLS.
 	//
-	// It is mutually exclusive with HTTPS.
+	// It is mutually exclusive with HTTPS and TCPForwardBackends.
 	TCPForward string `json:",omitempty"`
 
+	// TCPForwardBackends, if non-empty, is a pool of IP:port backends to
+	// forward TCP connections to, selected according to LoadBalancing.
+	// TerminateTLS and ProxyProtocol apply to all of them.
+	//
+	// It is mutually exclusive with HTTPS and TCPForward.
+	TCPForwardBackends []string `json:",omitempty"`
+
 	// TerminateTLS, if non-empty, means that tailscaled should terminate the
 	// TLS connections before forwarding them to TCPForward, permitting only the
 	// SNI name with this value. It is only used if TCPForward is non-empty.
@@ -157,6 +167,11 @@ type TCPPortHandler struct {
 	//
 	// This is only valid if TCPForward is non-empty.
 	ProxyProtocol int `json:",omitzero"`
+
+	// LoadBalancing configures how connections are distributed across
+	// TCPForwardBackends and how their health is checked. If nil, the
+	// defaults described on LoadBalancing are used.
+	LoadBalancing *LoadBalancing `json:",omitempty"`
 }
 
 // HTTPHandler is either a path or a proxy to serve.
@@ -166,6 +181,11 @@ type HTTPHandler struct {
 	Path  string `json:",omitempty"` // absolute path to directory or file to serve
 	Proxy string `json:",omitempty"` // http://localhost:3000/, localhost:3030, 3030
 
+	// Backends, if non-empty, is a pool of backends to proxy requests to,
+	// each in the same form as Proxy. Requests are distributed across the
+	// healthy backends according to LoadBalancing.
+	Backends []string `json:",omitempty"`
+
 	Text string `json:",omitempty"` // plaintext to serve (primarily for testing)
 
 	AcceptAppCaps []tailcfg.PeerCapability `json:",omitempty"` // peer capabilities to forward in grant header, e.g. example.com/cap/mon
@@ -179,10 +199,181 @@ type HTTPHandler struct {
 	//   - ${REQUEST_URI}: replaced with the request's full URI (path and query string)
 	Redirect string `json:",omitempty"`
 
+	// LoadBalancing configures how requests are distributed across Backends
+	// and how their health is checked. If nil, the defaults described on
+	// LoadBalancing are used.
+	LoadBalancing *LoadBalancing `json:",omitempty"`
+
+	// Rewrite, if non-nil, rewrites the requests proxied by this handler
+	// and the responses it serves.
+	Rewrite *HTTPRewrite `json:",omitempty"`
+
+	// Access, if non-nil, restricts which clients can use this handler.
+	// Other clients get a 403 Forbidden response.
+	Access *HTTPAccess `json:",omitempty"`
+
 	// TODO(bradfitz): bool to not enumerate directories? TTL on mapping for
 	// temporary ones? Error codes?
 }
 
+// HTTPAccess is an access control policy for an HTTP handler.
+//
+// Requests from the tailnet are allowed if the client matches any of Users,
+// Groups, Tags or Caps. Requests from the internet via Funnel have no tailnet
+// identity to match, so Funnel alone decides whether they're allowed.
+type HTTPAccess struct {
+	// Users lists the IDs of allowed users. Untagged nodes owned by one of
+	// them match; tagged nodes don't, whoever owns them.
+	Users []tailcfg.UserID `json:",omitempty"`
+
+	// Groups lists groups whose members are allowed, such as "group:eng".
+	// Group membership comes from [tailcfg.UserProfile.Groups], which is
+	// only populated if the control server is configured to report groups;
+	// otherwise, no group matches. Tagged nodes don't match.
+	Groups []string `json:",omitempty"`
+
+	// Tags lists tags, such as "tag:prod", of allowed nodes.
+	Tags []string `json:",omitempty"`
+
+	// Caps lists peer capabilities, such as "example.com/cap/admin", that
+	// allow clients granted any of them.
+	Caps []tailcfg.PeerCapability `json:",omitempty"`
+
+	// Funnel is the policy for requests via Funnel. The zero value denies
+	// them.
+	Funnel FunnelAccess `json:",omitempty"`
+}
+
+// FunnelAccess is the policy of an [HTTPAccess] for Funnel requests.
+type FunnelAccess string
+
+const (
+	// FunnelAccessDeny denies requests via Funnel. It's the default.
+	FunnelAccessDeny FunnelAccess = "deny"
+	// FunnelAccessAllow allows requests via Funnel.
+	FunnelAccessAllow FunnelAccess = "allow"
+)
+
+// Valid reports whether a is a known policy or empty.
+func (a FunnelAccess) Valid() bool {
+	switch a {
+	case "", FunnelAccessDeny, FunnelAccessAllow:
+		return true
+	}
+	return false
+}
+
+// HTTPRewrite is a set of rules for rewriting the requests proxied by an
+// HTTPHandler and the responses it serves.
+//
+// The request rules (StripPrefix, Host and RequestHeaders) only apply to
+// handlers that proxy to a backend. ResponseHeaders applies to all handlers.
+type HTTPRewrite struct {
+	// StripPrefix, if non-empty, is removed from the start of the request
+	// path before proxying, after the handler's mount point has already
+	// been removed. Paths that don't start with StripPrefix are proxied
+	// unchanged.
+	StripPrefix string `json:",omitempty"`
+
+	// Host, if non-empty, overrides the Host header of proxied requests. By
+	// default, the Host header of the incoming request is forwarded.
+	Host string `json:",omitempty"`
+
+	// RequestHeaders rewrites the headers of proxied requests. The
+	// Tailscale identity and app capability headers set by serve can't be
+	// rewritten.
+	RequestHeaders *HeaderRules `json:",omitempty"`
+
+	// ResponseHeaders rewrites the headers of responses, such as to add
+	// CORS or HSTS headers.
+	ResponseHeaders *HeaderRules `json:",omitempty"`
+}
+
+// HeaderRules is a set of changes to HTTP headers. They're applied in the
+// order Remove, Set, Add.
+type HeaderRules struct {
+	Remove []string          `json:",omitempty"` // names of headers to remove
+	Set    map[string]string `json:",omitempty"` // header name => value replacing any others
+	Add    map[string]string `json:",omitempty"` // header name => value to add to any others
+}
+
+// LBPolicy is a policy for selecting a backend from a pool of serve
+// backends.
+type LBPolicy string
+
+const (
+	// LBRoundRobin selects healthy backends in turn.
+	LBRoundRobin LBPolicy = "round-robin"
+	// LBLeastConns selects the healthy backend with the fewest in-flight
+	// requests or open connections, breaking ties in turn.
+	LBLeastConns LBPolicy = "least-conns"
+)
+
+// Valid reports whether p is a known policy or empty.
+func (p LBPolicy) Valid() bool {
+	switch p {
+	case "", LBRoundRobin, LBLeastConns:
+		return true
+	}
+	return false
+}
+
+// LoadBalancing configures a pool of serve backends, as listed in
+// HTTPHandler.Backends or TCPPortHandler.TCPForwardBackends.
+//
+// Backends are ejected from the pool after MaxFails consecutive failures of
+// proxied requests, connections or active health checks. Ejected backends
+// return to the pool after a successful active health check, or, without
+// active health checks, once FailTimeout has passed.
+type LoadBalancing struct {
+	// Policy is the policy for selecting among healthy backends.
+	// If empty, LBRoundRobin is used.
+	Policy LBPolicy `json:",omitempty"`
+
+	// HealthCheckInterval is the interval between active health checks of
+	// each backend. If zero, backends are only checked passively, by the
+	// outcome of the requests and connections they serve.
+	HealthCheckInterval tstime.GoDuration `json:",omitzero"`
+
+	// HealthCheckPath, if non-empty, is the path requested from HTTP
+	// backends by active health checks, which pass on a 2xx or 3xx response.
+	// If empty, and for TCP backends, active health checks only establish a
+	// connection.
+	HealthCheckPath string `json:",omitempty"`
+
+	// MaxFails is the number of consecutive failures after which a backend
+	// is ejected. If zero, 3 is used.
+	MaxFails int `json:",omitzero"`
+
+	// FailTimeout is how long a backend stays ejected when there are no
+	// active health checks. If zero, 30 seconds is used.
+	FailTimeout tstime.GoDuration `json:",omitzero"`
+}
+
+// ServeBackendHealth is the health of one backend of a serve backend pool,
+// as returned by the LocalAPI for "tailscale serve status".
+type ServeBackendHealth struct {
+	// Backend is the backend as listed in HTTPHandler.Backends or
+	// TCPPortHandler.TCPForwardBackends.
+	Backend string
+
+	// Healthy is whether the backend is in the pool.
+	Healthy bool
+
+	// Conns is the number of in-flight requests or open connections to the
+	// backend.
+	Conns int `json:",omitzero"`
+
+	// Fails is the number of consecutive failures of the backend.
+	Fails int `json:",omitzero"`
+
+	// LastError, if non-empty, is the most recent failure of the backend.
+	LastError string `json:",omitempty"`
+
+	// LastCheck is the time of the most recent active health check, if any.
+	LastCheck time.Time `json:",omitzero"`
+}
+
 // WebHandlerExists reports whether if the ServeConfig Web handler exists for
 // the given host:port and mount point.
 func (sc *ServeConfig) WebHandlerExists(svcName tailcfg.ServiceName, hp HostPort, mount string) bool {
@@ -271,7 +462,7 @@ func (sc *ServeConfig) IsTCPForwardingAny() bool {
 		return false
 	}
 	for _, h := range sc.TCP {
-		if h.TCPForward != "" {
+		if h.TCPForward != "" || len(h.TCPForwardBackends) > 0 {
 			return true
 		}
 	}
@@ -790,6 +981,39 @@ func ExpandProxyTargetValue(target string, supportedSchemes []string, defaultSch
 	return u.String(), nil
 }
 
+// BackendPoolKey returns the key identifying the pool of the handler's
+// Backends, as used by the LocalAPI to report their health. Handlers with
+// the same Backends and LoadBalancing share a pool. It returns the empty
+// string if the handler has no Backends.
+func (v HTTPHandlerView) BackendPoolKey() string {
+	return backendPoolKey("http", v.Backends(), v.LoadBalancing())
+}
+
+// BackendPoolKey returns the key identifying the pool of the handler's
+// TCPForwardBackends, as used by the LocalAPI to report their health.
+// Handlers with the same TCPForwardBackends and LoadBalancing share a pool.
+// It returns the empty string if the handler has no TCPForwardBackends.
+func (v TCPPortHandlerView) BackendPoolKey() string {
+	return backendPoolKey("tcp", v.TCPForwardBackends(), v.LoadBalancing())
+}
+
+func backendPoolKey(kind string, backends views.Slice[string], lb LoadBalancingView) string {
+	if backends.Len() == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString(kind)
+	for _, b := range backends.All() {
+		sb.WriteString(" ")
+		sb.WriteString(b)
+	}
+	if lb.Valid() {
+		fmt.Fprintf(&sb, " policy=%s check=%v,%q max-fails=%d fail-timeout=%v",
+			lb.Policy(), lb.HealthCheckInterval().Duration, lb.HealthCheckPath(), lb.MaxFails(), lb.FailTimeout().Duration)
+	}
+	return sb.String()
+}
+
 // TCPs returns an iterator over both background and foreground TCP
 // listeners.
 //
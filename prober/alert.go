@@ -0,0 +1,413 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package prober
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"tailscale.com/types/logger"
+)
+
+// Severity is the severity of an alert.
+type Severity string
+
+const (
+	SeverityPage   Severity = "page"
+	SeverityTicket Severity = "ticket"
+)
+
+// BurnRateWindow is a pair of windows over which a probe's error budget
+// burn rate is evaluated. An alert fires when the burn rate over both the
+// Long and Short windows is at least BurnRate. The Short window makes the
+// alert resolve soon after the probe recovers.
+//
+// The burn rate is the ratio of failed probes in a window to the ratio
+// allowed by the SLO: a burn rate of 1 uses up the error budget exactly
+// over the SLO period.
+type BurnRateWindow struct {
+	Long     time.Duration
+	Short    time.Duration
+	BurnRate float64
+	Severity Severity
+}
+
+// DefaultBurnRateWindows are the windows used by an [SLO] without Windows of
+// its own. They're the multiwindow, multi-burn-rate alerts recommended by the
+// Google SRE workbook for a 30 day SLO period.
+var DefaultBurnRateWindows = []BurnRateWindow{
+	{Long: time.Hour, Short: 5 * time.Minute, BurnRate: 14.4, Severity: SeverityPage},
+	{Long: 6 * time.Hour, Short: 30 * time.Minute, BurnRate: 6, Severity: SeverityPage},
+	{Long: 24 * time.Hour, Short: 2 * time.Hour, BurnRate: 3, Severity: SeverityTicket},
+	{Long: 3 * 24 * time.Hour, Short: 6 * time.Hour, BurnRate: 1, Severity: SeverityTicket},
+}
+
+// SLO is a service level objective for the probes of a class.
+type SLO struct {
+	// Class is the [ProbeClass.Class] of the probes that this SLO applies
+	// to. An SLO with an empty Class applies to the probes of all classes
+	// that don't have an SLO of their own.
+	Class string
+
+	// Target is the target ratio of successful probes, such as 0.999.
+	Target float64
+
+	// Windows are the burn rate windows to alert on, in order of
+	// precedence: if several windows are burning, the first one's
+	// severity is used. If empty, DefaultBurnRateWindows is used.
+	Windows []BurnRateWindow
+
+	// MinResults is the number of probe results needed in a short window,
+	// and so in its long window, for it to alert, so that a probe that
+	// has only just started doesn't alert on its first failure.
+	// Defaults to defaultMinResults.
+	MinResults int
+}
+
+// defaultMinResults is the default [SLO.MinResults].
+const defaultMinResults = 3
+
+func (s SLO) minResults() int {
+	if s.MinResults <= 0 {
+		return defaultMinResults
+	}
+	return s.MinResults
+}
+
+func (s SLO) windows() []BurnRateWindow {
+	if len(s.Windows) == 0 {
+		return DefaultBurnRateWindows
+	}
+	return s.Windows
+}
+
+// AlertStatus is whether an alert is firing or resolved.
+type AlertStatus string
+
+const (
+	AlertFiring   AlertStatus = "firing"
+	AlertResolved AlertStatus = "resolved"
+)
+
+// Alert is a notification that a probe is burning through its error
+// budget too quickly, or that it no longer is.
+type Alert struct {
+	Status   AlertStatus
+	Probe    string
+	Class    string
+	Labels   map[string]string
+	Severity Severity
+
+	// Target is the SLO's target success ratio.
+	Target float64
+
+	// Window is the burn rate window that fired. For resolved alerts,
+	// it's the last window that fired.
+	Window BurnRateWindow
+
+	// BurnRate is the burn rate over Window.Long when the alert was sent.
+	BurnRate float64
+
+	// StartsAt is when the alert started firing. EndsAt is when it
+	// resolved, or zero if it's firing.
+	StartsAt time.Time
+	EndsAt   time.Time `json:",omitzero"`
+
+	// LastError is the most recent probe error, if any.
+	LastError string `json:",omitempty"`
+}
+
+func (a Alert) String() string {
+	s := fmt.Sprintf("[%s] %s probe %q: error budget burn rate %.1f over %v (SLO %g%%, alert at %g)",
+		a.Status, a.Severity, a.Probe, a.BurnRate, a.Window.Long, a.Target*100, a.Window.BurnRate)
+	if a.LastError != "" && a.Status == AlertFiring {
+		s += "; last error: " + a.LastError
+	}
+	return s
+}
+
+// AlertConfig configures an [Alerter].
+type AlertConfig struct {
+	// SLOs are the SLOs to evaluate. Probes of a class with no SLO (and
+	// no SLO with an empty Class) aren't alerted on.
+	SLOs []SLO
+
+	// Notifiers are notified of each alert that fires or resolves.
+	Notifiers []Notifier
+
+	// RepeatInterval, if non-zero, is how often to notify again about an
+	// alert that's still firing. Otherwise, notifications are only sent
+	// when an alert fires, changes severity or resolves.
+	RepeatInterval time.Duration
+
+	// Logf logs notification failures. Defaults to log.Printf.
+	Logf logger.Logf
+}
+
+// Alerter evaluates probe results against SLOs and sends notifications
+// when probes burn through their error budgets too quickly.
+// Use [Prober.WithAlerter] to feed it the results of a Prober's probes.
+type Alerter struct {
+	slos           map[string]SLO // by class
+	notifiers      []Notifier
+	repeatInterval time.Duration
+	logf           logger.Logf
+	bucketSize     time.Duration // granularity of probe history
+	maxWindow      time.Duration // longest window of all SLOs
+
+	queue   chan Alert    // notifications to send
+	stopped chan struct{} // closed when the notification loop exits
+
+	mu     sync.Mutex
+	closed bool
+	probes map[string]*probeAlertState // by probe name
+}
+
+// probeAlertState is the result history and alert state of a probe.
+type probeAlertState struct {
+	buckets []resultBucket // oldest first
+	firing  *Alert         // or nil if not firing
+	sentAt  time.Time      // when firing was last sent
+}
+
+// resultBucket counts the probe results that ended in
+// [start, start+Alerter.bucketSize).
+type resultBucket struct {
+	start    time.Time
+	ok, fail int
+}
+
+// NewAlerter returns a new Alerter. Its Close method must be called when
+// it's no longer needed.
+func NewAlerter(c AlertConfig) (*Alerter, error) {
+	a := &Alerter{
+		slos:           make(map[string]SLO),
+		notifiers:      c.Notifiers,
+		repeatInterval: c.RepeatInterval,
+		logf:           c.Logf,
+		queue:          make(chan Alert, 100),
+		stopped:        make(chan struct{}),
+		probes:         make(map[string]*probeAlertState),
+	}
+	for _, s := range c.SLOs {
+		if _, dup := a.slos[s.Class]; dup {
+			return nil, fmt.Errorf("duplicate SLO for class %q", s.Class)
+		}
+		if s.Target <= 0 || s.Target >= 1 {
+			return nil, fmt.Errorf("SLO for class %q: target %v not between 0 and 1", s.Class, s.Target)
+		}
+		for _, w := range s.windows() {
+			if w.Short <= 0 || w.Long < w.Short || w.BurnRate <= 0 {
+				return nil, fmt.Errorf("SLO for class %q: invalid window %+v", s.Class, w)
+			}
+			a.maxWindow = max(a.maxWindow, w.Long)
+			// Buckets are small enough for the short window to
+			// span several of them.
+			if b := w.Short / 5; a.bucketSize == 0 || b < a.bucketSize {
+				a.bucketSize = b
+			}
+		}
+		a.slos[s.Class] = s
+	}
+	a.bucketSize = max(a.bucketSize, time.Second)
+	if a.logf == nil {
+		a.logf = log.Printf
+	}
+	go a.notifyLoop()
+	return a, nil
+}
+
+// Close stops the Alerter, after sending any pending notifications.
+func (a *Alerter) Close() error {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return errors.New("already closed")
+	}
+	a.closed = true
+	close(a.queue)
+	a.mu.Unlock()
+	<-a.stopped
+	return nil
+}
+
+// WithAlerter makes p send the results of its probes to a, which alerts on
+// them.
+func (p *Prober) WithAlerter(a *Alerter) *Prober {
+	p.alerter = a
+	return p
+}
+
+// sloFor returns the SLO for probes of class.
+func (a *Alerter) sloFor(class string) (_ SLO, ok bool) {
+	if s, ok := a.slos[class]; ok {
+		return s, true
+	}
+	s, ok := a.slos[""]
+	return s, ok
+}
+
+// record records a probe result that ended at end, and sends any
+// resulting notifications.
+func (a *Alerter) record(info ProbeInfo, end time.Time, err error) {
+	slo, ok := a.sloFor(info.Class)
+	if !ok {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return
+	}
+	st, ok := a.probes[info.Name]
+	if !ok {
+		st = new(probeAlertState)
+		a.probes[info.Name] = st
+	}
+	st.add(end.Truncate(a.bucketSize), err == nil, end.Add(-a.maxWindow-a.bucketSize))
+
+	var fired *BurnRateWindow
+	var burn float64
+	for _, w := range slo.windows() {
+		shortRatio, n := st.errorRatio(end, w.Short)
+		if n < slo.minResults() {
+			continue
+		}
+		longRatio, _ := st.errorRatio(end, w.Long)
+		budget := 1 - slo.Target
+		if shortRatio/budget >= w.BurnRate && longRatio/budget >= w.BurnRate {
+			fired = &w
+			burn = longRatio / budget
+			break
+		}
+	}
+
+	var lastErr string
+	if err != nil {
+		lastErr = err.Error()
+	}
+	switch {
+	case fired == nil && st.firing != nil:
+		resolved := *st.firing
+		resolved.Status = AlertResolved
+		resolved.EndsAt = end
+		st.firing = nil
+		a.sendLocked(resolved)
+	case fired != nil && st.firing == nil:
+		st.firing = &Alert{
+			Status:   AlertFiring,
+			Probe:    info.Name,
+			Class:    info.Class,
+			Labels:   info.Labels,
+			Severity: fired.Severity,
+			Target:   slo.Target,
+			Window:   *fired,
+			BurnRate: burn,
+			StartsAt: end,
+		}
+		st.firing.LastError = lastErr
+		st.sentAt = end
+		a.sendLocked(*st.firing)
+	case fired != nil:
+		st.firing.BurnRate = burn
+		st.firing.LastError = cmp.Or(lastErr, st.firing.LastError)
+		changed := st.firing.Severity != fired.Severity
+		st.firing.Severity = fired.Severity
+		st.firing.Window = *fired
+		if changed || (a.repeatInterval > 0 && end.Sub(st.sentAt) >= a.repeatInterval) {
+			st.sentAt = end
+			a.sendLocked(*st.firing)
+		}
+	}
+}
+
+// add counts a result in the bucket starting at bucket, and drops the
+// buckets that started before cutoff.
+func (st *probeAlertState) add(bucket time.Time, ok bool, cutoff time.Time) {
+	if n := len(st.buckets); n == 0 || st.buckets[n-1].start.Before(bucket) {
+		st.buckets = append(st.buckets, resultBucket{start: bucket})
+	}
+	b := &st.buckets[len(st.buckets)-1]
+	if ok {
+		b.ok++
+	} else {
+		b.fail++
+	}
+	i := 0
+	for i < len(st.buckets) && st.buckets[i].start.Before(cutoff) {
+		i++
+	}
+	st.buckets = slices.Delete(st.buckets, 0, i)
+}
+
+// errorRatio returns the ratio of failed results in the window ending at
+// now, and the number of results in it.
+func (st *probeAlertState) errorRatio(now time.Time, window time.Duration) (ratio float64, n int) {
+	var fail int
+	for i := len(st.buckets) - 1; i >= 0; i-- {
+		b := st.buckets[i]
+		if !b.start.After(now.Add(-window)) {
+			break
+		}
+		n += b.ok + b.fail
+		fail += b.fail
+	}
+	if n == 0 {
+		return 0, 0
+	}
+	return float64(fail) / float64(n), n
+}
+
+// sendLocked queues al to be sent to the notifiers.
+// a.mu must be held.
+func (a *Alerter) sendLocked(al Alert) {
+	al.Labels = maps.Clone(al.Labels)
+	select {
+	case a.queue <- al:
+	default:
+		a.logf("prober: alert queue full; dropping notification: %v", al)
+	}
+}
+
+func (a *Alerter) notifyLoop() {
+	defer close(a.stopped)
+	for al := range a.queue {
+		for _, n := range a.notifiers {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			if err := n.Notify(ctx, al); err != nil {
+				a.logf("prober: sending alert for probe %q: %v", al.Probe, err)
+			}
+			cancel()
+		}
+	}
+}
+
+// Alerts returns the alerts that are currently firing, sorted by probe name.
+func (a *Alerter) Alerts() []Alert {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var ret []Alert
+	for _, st := range a.probes {
+		if st.firing != nil {
+			ret = append(ret, *st.firing)
+		}
+	}
+	slices.SortFunc(ret, func(a, b Alert) int { return cmp.Compare(a.Probe, b.Probe) })
+	return ret
+}
+
+// ServeHTTP serves the firing alerts as JSON.
+func (a *Alerter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.Alerts())
+}
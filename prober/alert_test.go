@@ -0,0 +1,265 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package prober
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// alertRecorder is a Notifier that remembers the alerts it's sent.
+type alertRecorder struct {
+	mu     sync.Mutex
+	alerts []Alert
+}
+
+func (r *alertRecorder) Notify(ctx context.Context, a Alert) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.alerts = append(r.alerts, a)
+	return nil
+}
+
+func TestAlerter(t *testing.T) {
+	rec := new(alertRecorder)
+	a, err := NewAlerter(AlertConfig{
+		SLOs: []SLO{{
+			Class:  "derp",
+			Target: 0.99,
+			Windows: []BurnRateWindow{
+				{Long: time.Hour, Short: 5 * time.Minute, BurnRate: 14.4, Severity: SeverityPage},
+				{Long: 6 * time.Hour, Short: 30 * time.Minute, BurnRate: 6, Severity: SeverityTicket},
+			},
+		}},
+		Notifiers: []Notifier{rec},
+		Logf:      t.Logf,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info := ProbeInfo{Name: "derp1", Class: "derp", Labels: map[string]string{"region": "nyc"}}
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	probeErr := errors.New("timeout")
+	run := func(n int, err error) {
+		for range n {
+			now = now.Add(time.Minute)
+			a.record(info, now, err)
+			// Probes of classes without an SLO are ignored.
+			a.record(ProbeInfo{Name: "dns", Class: "dns"}, now, probeErr)
+		}
+	}
+
+	run(60, nil)
+	if got := a.Alerts(); len(got) != 0 {
+		t.Fatalf("alerts after successes = %v", got)
+	}
+	// Four failures burn the 30m/6h budget 6x as fast as allowed; nine
+	// also burn the 5m/1h budget 14.4x as fast.
+	run(10, probeErr)
+	got := a.Alerts()
+	if len(got) != 1 || got[0].Probe != "derp1" || got[0].Severity != SeverityPage || got[0].LastError != "timeout" {
+		t.Fatalf("firing alerts = %+v; want page for derp1", got)
+	}
+	run(120, nil)
+	if got := a.Alerts(); len(got) != 0 {
+		t.Fatalf("alerts after recovery = %v", got)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	type notification struct {
+		status   AlertStatus
+		severity Severity
+	}
+	want := []notification{
+		{AlertFiring, SeverityTicket},
+		{AlertFiring, SeverityPage},
+		{AlertFiring, SeverityTicket},
+		{AlertResolved, SeverityTicket},
+	}
+	if len(rec.alerts) != len(want) {
+		t.Fatalf("got %d notifications; want %d: %v", len(rec.alerts), len(want), rec.alerts)
+	}
+	for i, w := range want {
+		al := rec.alerts[i]
+		if al.Status != w.status || al.Severity != w.severity {
+			t.Errorf("notification %d = %v; want %v %v", i, al, w.status, w.severity)
+		}
+		if al.Labels["region"] != "nyc" {
+			t.Errorf("notification %d labels = %v", i, al.Labels)
+		}
+	}
+	if first, last := rec.alerts[0], rec.alerts[3]; !last.StartsAt.Equal(first.StartsAt) || !last.EndsAt.After(last.StartsAt) {
+		t.Errorf("resolved alert StartsAt = %v, EndsAt = %v; want StartsAt %v", last.StartsAt, last.EndsAt, first.StartsAt)
+	}
+}
+
+func TestAlerterRepeat(t *testing.T) {
+	rec := new(alertRecorder)
+	a, err := NewAlerter(AlertConfig{
+		SLOs: []SLO{{
+			Target:     0.9,
+			Windows:    []BurnRateWindow{{Long: time.Hour, Short: 10 * time.Minute, BurnRate: 1, Severity: SeverityPage}},
+			MinResults: 3,
+		}},
+		Notifiers:      []Notifier{rec},
+		RepeatInterval: 30 * time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range 60 {
+		now = now.Add(time.Minute)
+		a.record(ProbeInfo{Name: "p"}, now, errors.New("down"))
+		if i == 1 && len(a.Alerts()) != 0 {
+			t.Fatal("alert fired before MinResults")
+		}
+	}
+	a.Close()
+	// Fired on the third failure, and repeated 30 minutes later.
+	if len(rec.alerts) != 2 {
+		t.Errorf("got %d notifications; want 2: %v", len(rec.alerts), rec.alerts)
+	}
+}
+
+func TestAlerterMinResults(t *testing.T) {
+	rec := new(alertRecorder)
+	a, err := NewAlerter(AlertConfig{
+		SLOs:      []SLO{{Target: 0.999}},
+		Notifiers: []Notifier{rec},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range defaultMinResults {
+		if len(a.Alerts()) != 0 {
+			t.Fatalf("alert fired after %d results", i)
+		}
+		now = now.Add(time.Minute)
+		a.record(ProbeInfo{Name: "p"}, now, errors.New("down"))
+	}
+	if len(a.Alerts()) != 1 {
+		t.Errorf("no alert after %d failures", defaultMinResults)
+	}
+	a.Close()
+}
+
+func TestAlerterWithProber(t *testing.T) {
+	clk := newFakeTime()
+	rec := new(alertRecorder)
+	a, err := NewAlerter(AlertConfig{
+		SLOs: []SLO{{
+			Class:      "flaky",
+			Target:     0.9,
+			Windows:    []BurnRateWindow{{Long: time.Minute, Short: time.Minute, BurnRate: 1, Severity: SeverityPage}},
+			MinResults: 1, // the probe only runs once
+		}},
+		Notifiers: []Notifier{rec},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := newForTest(clk.Now, clk.NewTicker).WithOnce(true).WithAlerter(a)
+	p.Run("flaky-probe", probeInterval, nil, ProbeClass{
+		Class: "flaky",
+		Probe: func(context.Context) error { return errors.New("flaked") },
+	})
+	p.Wait()
+	a.Close()
+	if len(rec.alerts) != 1 || rec.alerts[0].Probe != "flaky-probe" || rec.alerts[0].LastError != "flaked" {
+		t.Errorf("notifications = %v; want one for flaky-probe", rec.alerts)
+	}
+}
+
+func TestNewAlerterErrors(t *testing.T) {
+	for name, slos := range map[string][]SLO{
+		"target":    {{Target: 1}},
+		"duplicate": {{Class: "a", Target: 0.9}, {Class: "a", Target: 0.99}},
+		"window":    {{Target: 0.9, Windows: []BurnRateWindow{{Long: time.Minute, Short: time.Hour, BurnRate: 1}}}},
+	} {
+		if _, err := NewAlerter(AlertConfig{SLOs: slos}); err == nil {
+			t.Errorf("%s: NewAlerter succeeded", name)
+		}
+	}
+}
+
+func TestNotifiers(t *testing.T) {
+	al := Alert{
+		Status:   AlertFiring,
+		Probe:    "derp/nyc",
+		Severity: SeverityPage,
+		Target:   0.999,
+		Window:   DefaultBurnRateWindows[0],
+		BurnRate: 20,
+		StartsAt: time.Now(),
+	}
+	ctx := context.Background()
+
+	var got Alert
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			http.Error(w, err.Error(), 400)
+		}
+	}))
+	defer ts.Close()
+	if err := (&WebhookNotifier{URL: ts.URL}).Notify(ctx, al); err != nil {
+		t.Fatal(err)
+	}
+	if got.Probe != al.Probe || got.Severity != al.Severity {
+		t.Errorf("webhook got %+v", got)
+	}
+
+	var msg string
+	en := &EmailNotifier{
+		Addr: "localhost:25",
+		From: "prober@example.com",
+		To:   []string{"oncall@example.com"},
+		sendMail: func(addr string, _ smtp.Auth, from string, to []string, m []byte) error {
+			msg = string(m)
+			return nil
+		},
+	}
+	if err := en.Notify(ctx, al); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(msg, "Subject: [FIRING:page] probe derp/nyc\r\n") {
+		t.Errorf("email message:\n%s", msg)
+	}
+
+	// Line breaks in probe names and labels can't inject headers.
+	evil := al
+	evil.Probe = "derp\r\nBcc: attacker@example.com"
+	evil.Labels = map[string]string{"region\nX-Evil: 1": "nyc\r\nX-Evil: 2"}
+	if err := en.Notify(ctx, evil); err != nil {
+		t.Fatal(err)
+	}
+	header, body, _ := strings.Cut(msg, "\r\n\r\n")
+	if strings.Contains(header, "\r\nBcc:") || strings.Contains(msg, "\nX-Evil") {
+		t.Errorf("email message with line breaks in probe name and labels:\n%s", msg)
+	}
+	if !strings.Contains(body, "Probe: derp Bcc: attacker@example.com\r\n") {
+		t.Errorf("email body:\n%s", body)
+	}
+
+	var buf bytes.Buffer
+	if err := (&WriterNotifier{W: &buf}).Notify(ctx, al); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `[firing] page probe "derp/nyc"`) {
+		t.Errorf("writer got %q", buf.String())
+	}
+}
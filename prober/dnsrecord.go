@@ -0,0 +1,270 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package prober
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DNSRecordOpts configures a [DNSRecord] probe.
+type DNSRecordOpts struct {
+	// Resolver is the DNS server to query, as "host:port" or just "host"
+	// for port 53.
+	Resolver string
+
+	// Name is the name to look up.
+	Name string
+
+	// Type is the type of records to look up. Defaults to A.
+	// The supported types are A, AAAA, CNAME, NS, PTR, MX, SRV and TXT.
+	Type dnsmessage.Type
+
+	// Want are records that the answer must contain, in the same format
+	// as dig(1) prints them: an IP address for A and AAAA; a name for
+	// CNAME, NS and PTR; "pref name" for MX; "priority weight port
+	// target" for SRV; and the concatenated strings for TXT. Names are
+	// compared case-insensitively, with or without a trailing dot.
+	//
+	// If Want is empty, the answer must contain at least one record of
+	// Type, unless WantRCode is set.
+	Want []string
+
+	// Exact is whether the answer must contain only the records in Want.
+	Exact bool
+
+	// MinTTL and MaxTTL, if non-zero, are the bounds for the TTLs of the
+	// records in the answer.
+	MinTTL time.Duration
+	MaxTTL time.Duration
+
+	// WantRCode is the response code that the resolver must return.
+	// Defaults to success (NOERROR).
+	WantRCode dnsmessage.RCode
+}
+
+// DNSRecord returns a ProbeClass that looks up a name against a specific
+// resolver and checks the records in the answer.
+//
+// The query is sent over UDP, and retried over TCP if the answer is
+// truncated.
+func DNSRecord(opts DNSRecordOpts) (ProbeClass, error) {
+	if opts.Resolver == "" || opts.Name == "" {
+		return ProbeClass{}, errors.New("DNS record probe requires a resolver and name")
+	}
+	if _, _, err := net.SplitHostPort(opts.Resolver); err != nil {
+		opts.Resolver = net.JoinHostPort(opts.Resolver, "53")
+	}
+	if opts.Type == 0 {
+		opts.Type = dnsmessage.TypeA
+	}
+	if !slices.Contains(dnsRecordTypes, opts.Type) {
+		return ProbeClass{}, fmt.Errorf("unsupported DNS record type %v", opts.Type)
+	}
+	name, err := dnsmessage.NewName(dnsFQDN(opts.Name))
+	if err != nil {
+		return ProbeClass{}, fmt.Errorf("invalid DNS name %q: %w", opts.Name, err)
+	}
+	var want []string
+	for _, w := range opts.Want {
+		want = append(want, normalizeDNSRecord(opts.Type, w))
+	}
+	return ProbeClass{
+		Probe: func(ctx context.Context) error {
+			return probeDNSRecord(ctx, opts, name, want)
+		},
+		Class: "dns_record",
+		Labels: Labels{
+			"resolver": opts.Resolver,
+			"qtype":    strings.TrimPrefix(opts.Type.String(), "Type"),
+		},
+	}, nil
+}
+
+var dnsRecordTypes = []dnsmessage.Type{
+	dnsmessage.TypeA,
+	dnsmessage.TypeAAAA,
+	dnsmessage.TypeCNAME,
+	dnsmessage.TypeNS,
+	dnsmessage.TypePTR,
+	dnsmessage.TypeMX,
+	dnsmessage.TypeSRV,
+	dnsmessage.TypeTXT,
+}
+
+func dnsFQDN(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
+
+func probeDNSRecord(ctx context.Context, opts DNSRecordOpts, name dnsmessage.Name, want []string) error {
+	resp, err := dnsQuery(ctx, opts.Resolver, name, opts.Type)
+	if err != nil {
+		return fmt.Errorf("querying %v for %s %v: %w", opts.Resolver, opts.Name, opts.Type, err)
+	}
+	if resp.RCode != opts.WantRCode {
+		return fmt.Errorf("%s %v: rcode %v, want %v", opts.Name, opts.Type, resp.RCode, opts.WantRCode)
+	}
+
+	var got []string
+	for _, rr := range resp.Answers {
+		if rr.Header.Type != opts.Type {
+			// Such as the CNAMEs leading to the records we asked for.
+			continue
+		}
+		ttl := time.Duration(rr.Header.TTL) * time.Second
+		if opts.MinTTL != 0 && ttl < opts.MinTTL {
+			return fmt.Errorf("%s %v: TTL %v less than %v", opts.Name, opts.Type, ttl, opts.MinTTL)
+		}
+		if opts.MaxTTL != 0 && ttl > opts.MaxTTL {
+			return fmt.Errorf("%s %v: TTL %v greater than %v", opts.Name, opts.Type, ttl, opts.MaxTTL)
+		}
+		got = append(got, formatDNSRecord(rr.Body))
+	}
+	if len(want) == 0 && opts.WantRCode == dnsmessage.RCodeSuccess && len(got) == 0 {
+		return fmt.Errorf("%s %v: no records", opts.Name, opts.Type)
+	}
+	for _, w := range want {
+		if !slices.Contains(got, w) {
+			return fmt.Errorf("%s %v: answer %q doesn't contain %q", opts.Name, opts.Type, got, w)
+		}
+	}
+	if opts.Exact {
+		for _, g := range got {
+			if !slices.Contains(want, g) {
+				return fmt.Errorf("%s %v: unexpected record %q in answer", opts.Name, opts.Type, g)
+			}
+		}
+	}
+	return nil
+}
+
+// normalizeDNSRecord returns the record s of type typ in the form returned
+// by formatDNSRecord.
+func normalizeDNSRecord(typ dnsmessage.Type, s string) string {
+	switch typ {
+	case dnsmessage.TypeA, dnsmessage.TypeAAAA:
+		if ip, err := netip.ParseAddr(s); err == nil {
+			return ip.String()
+		}
+		return s
+	case dnsmessage.TypeTXT:
+		return s
+	}
+	return strings.ToLower(strings.TrimSuffix(s, "."))
+}
+
+// formatDNSRecord formats a DNS record body as described in
+// [DNSRecordOpts.Want].
+func formatDNSRecord(body dnsmessage.ResourceBody) string {
+	name := func(n dnsmessage.Name) string {
+		return strings.ToLower(strings.TrimSuffix(n.String(), "."))
+	}
+	switch r := body.(type) {
+	case *dnsmessage.AResource:
+		return netip.AddrFrom4(r.A).String()
+	case *dnsmessage.AAAAResource:
+		return netip.AddrFrom16(r.AAAA).String()
+	case *dnsmessage.CNAMEResource:
+		return name(r.CNAME)
+	case *dnsmessage.NSResource:
+		return name(r.NS)
+	case *dnsmessage.PTRResource:
+		return name(r.PTR)
+	case *dnsmessage.MXResource:
+		return fmt.Sprintf("%d %s", r.Pref, name(r.MX))
+	case *dnsmessage.SRVResource:
+		return fmt.Sprintf("%d %d %d %s", r.Priority, r.Weight, r.Port, name(r.Target))
+	case *dnsmessage.TXTResource:
+		return strings.Join(r.TXT, "")
+	}
+	return body.GoString()
+}
+
+// dnsQuery sends a recursive query for name and typ to resolver, over UDP
+// and then over TCP if the UDP response is truncated.
+func dnsQuery(ctx context.Context, resolver string, name dnsmessage.Name, typ dnsmessage.Type) (*dnsmessage.Message, error) {
+	id := uint16(rand.N(1 << 16))
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: true})
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(dnsmessage.Question{Name: name, Type: typ, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, err
+	}
+	query, err := b.Finish()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := dnsExchange(ctx, "udp", resolver, query)
+	if err == nil && resp.Truncated {
+		resp, err = dnsExchange(ctx, "tcp", resolver, query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.ID != id {
+		return nil, fmt.Errorf("response ID %d doesn't match query ID %d", resp.ID, id)
+	}
+	return resp, nil
+}
+
+func dnsExchange(ctx context.Context, network, resolver string, query []byte) (*dnsmessage.Message, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, resolver)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if dl, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(dl)
+	}
+
+	var buf []byte
+	if network == "tcp" {
+		msg := binary.BigEndian.AppendUint16(nil, uint16(len(query)))
+		if _, err := conn.Write(append(msg, query...)); err != nil {
+			return nil, err
+		}
+		var n [2]byte
+		if _, err := io.ReadFull(conn, n[:]); err != nil {
+			return nil, err
+		}
+		buf = make([]byte, binary.BigEndian.Uint16(n[:]))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+		buf = make([]byte, 64<<10)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		buf = buf[:n]
+	}
+
+	var resp dnsmessage.Message
+	if err := resp.Unpack(buf); err != nil {
+		return nil, fmt.Errorf("parsing response: %w", err)
+	}
+	return &resp, nil
+}
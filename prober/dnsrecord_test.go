@@ -0,0 +1,196 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package prober
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// fakeDNSAnswer returns the response to query, over UDP if udp is set.
+func fakeDNSAnswer(t *testing.T, query []byte, udp bool) []byte {
+	var q dnsmessage.Message
+	if err := q.Unpack(query); err != nil {
+		t.Errorf("bad query: %v", err)
+		return nil
+	}
+	resp := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: q.ID, Response: true, RecursionDesired: q.RecursionDesired},
+		Questions: q.Questions,
+	}
+	qu := q.Questions[0]
+	hdr := func(typ dnsmessage.Type, ttl uint32) dnsmessage.ResourceHeader {
+		return dnsmessage.ResourceHeader{Name: qu.Name, Type: typ, Class: dnsmessage.ClassINET, TTL: ttl}
+	}
+	switch qu.Name.String() {
+	case "www.example.com.":
+		resp.Answers = []dnsmessage.Resource{
+			{Header: hdr(dnsmessage.TypeCNAME, 3600), Body: &dnsmessage.CNAMEResource{CNAME: dnsmessage.MustNewName("Web.Example.com.")}},
+			{Header: hdr(dnsmessage.TypeA, 300), Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}}},
+			{Header: hdr(dnsmessage.TypeA, 300), Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 2}}},
+		}
+	case "big.example.com.":
+		if udp {
+			resp.Truncated = true
+			break
+		}
+		resp.Answers = []dnsmessage.Resource{
+			{Header: hdr(dnsmessage.TypeTXT, 60), Body: &dnsmessage.TXTResource{TXT: []string{"v=spf1 ", strings.Repeat("a", 200)}}},
+		}
+	default:
+		resp.RCode = dnsmessage.RCodeNameError
+	}
+	b, err := resp.Pack()
+	if err != nil {
+		t.Errorf("packing response: %v", err)
+	}
+	return b
+}
+
+// startFakeDNS starts a DNS server on localhost over UDP and TCP, and
+// returns its address.
+func startFakeDNS(t *testing.T) string {
+	uc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { uc.Close() })
+	ln, err := net.Listen("tcp", uc.LocalAddr().String())
+	if err != nil {
+		t.Skipf("listening on TCP port of UDP listener: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := uc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			uc.WriteTo(fakeDNSAnswer(t, buf[:n], true), addr)
+		}
+	}()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				var n [2]byte
+				if _, err := io.ReadFull(c, n[:]); err != nil {
+					return
+				}
+				query := make([]byte, binary.BigEndian.Uint16(n[:]))
+				if _, err := io.ReadFull(c, query); err != nil {
+					return
+				}
+				resp := fakeDNSAnswer(t, query, false)
+				c.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(resp))), resp...))
+			}()
+		}
+	}()
+	return uc.LocalAddr().String()
+}
+
+func TestDNSRecord(t *testing.T) {
+	resolver := startFakeDNS(t)
+	tests := []struct {
+		name    string
+		opts    DNSRecordOpts
+		wantErr string // or empty for success
+	}{
+		{
+			name: "a",
+			opts: DNSRecordOpts{Name: "www.example.com", Want: []string{"192.0.2.2"}},
+		},
+		{
+			name: "a-exact",
+			opts: DNSRecordOpts{Name: "www.example.com", Want: []string{"192.0.2.1", "192.0.2.2"}, Exact: true, MinTTL: time.Minute, MaxTTL: time.Hour},
+		},
+		{
+			name:    "a-not-exact",
+			opts:    DNSRecordOpts{Name: "www.example.com", Want: []string{"192.0.2.1"}, Exact: true},
+			wantErr: `unexpected record "192.0.2.2"`,
+		},
+		{
+			name:    "a-missing",
+			opts:    DNSRecordOpts{Name: "www.example.com", Want: []string{"192.0.2.3"}},
+			wantErr: `doesn't contain "192.0.2.3"`,
+		},
+		{
+			name:    "ttl-too-high",
+			opts:    DNSRecordOpts{Name: "www.example.com", MaxTTL: time.Minute},
+			wantErr: "TTL 5m0s greater than 1m0s",
+		},
+		{
+			name: "cname",
+			opts: DNSRecordOpts{Name: "www.example.com.", Type: dnsmessage.TypeCNAME, Want: []string{"web.example.COM."}},
+		},
+		{
+			name:    "no-aaaa",
+			opts:    DNSRecordOpts{Name: "www.example.com", Type: dnsmessage.TypeAAAA},
+			wantErr: "no records",
+		},
+		{
+			name: "txt-over-tcp",
+			opts: DNSRecordOpts{Name: "big.example.com", Type: dnsmessage.TypeTXT, Want: []string{"v=spf1 " + strings.Repeat("a", 200)}},
+		},
+		{
+			name: "nxdomain",
+			opts: DNSRecordOpts{Name: "missing.example.com", WantRCode: dnsmessage.RCodeNameError},
+		},
+		{
+			name:    "unexpected-nxdomain",
+			opts:    DNSRecordOpts{Name: "missing.example.com"},
+			wantErr: "rcode RCodeNameError, want RCodeSuccess",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.Resolver = resolver
+			pc, err := DNSRecord(tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			err = pc.Probe(ctx)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("probe failed: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("probe error = %v; want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDNSRecordOpts(t *testing.T) {
+	pc, err := DNSRecord(DNSRecordOpts{Resolver: "192.0.2.53", Name: "example.com", Type: dnsmessage.TypeAAAA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := pc.Labels["resolver"]; got != "192.0.2.53:53" {
+		t.Errorf("resolver label = %q", got)
+	}
+	if got := pc.Labels["qtype"]; got != "AAAA" {
+		t.Errorf("qtype label = %q", got)
+	}
+	if _, err := DNSRecord(DNSRecordOpts{Resolver: "192.0.2.53", Name: "example.com", Type: dnsmessage.TypeSOA}); err == nil {
+		t.Error("unexpected success with unsupported type")
+	}
+	if _, err := DNSRecord(DNSRecordOpts{Name: "example.com"}); err == nil {
+		t.Error("unexpected success without resolver")
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package prober
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Notifier sends alert notifications somewhere. See [AlertConfig].
+type Notifier interface {
+	Notify(context.Context, Alert) error
+}
+
+// NotifierFunc is a function that implements [Notifier].
+type NotifierFunc func(context.Context, Alert) error
+
+func (f NotifierFunc) Notify(ctx context.Context, a Alert) error { return f(ctx, a) }
+
+// WebhookNotifier is a [Notifier] that POSTs each alert as JSON to a URL.
+type WebhookNotifier struct {
+	URL string
+
+	// Client, if non-nil, is the HTTP client to use instead of
+	// http.DefaultClient.
+	Client *http.Client
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, a Alert) error {
+	b, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", n.URL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	hc := n.Client
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	res, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 1<<10))
+		return fmt.Errorf("webhook: %v: %s", res.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// EmailNotifier is a [Notifier] that emails alerts through an SMTP server,
+// typically a local mail relay.
+type EmailNotifier struct {
+	// Addr is the SMTP server's "host:port".
+	Addr string
+
+	// From is the sender address, and To are the recipient addresses.
+	From string
+	To   []string
+
+	// Auth, if non-nil, authenticates to the SMTP server.
+	Auth smtp.Auth
+
+	// sendMail is smtp.SendMail, or a fake in tests.
+	sendMail func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+func (n *EmailNotifier) Notify(ctx context.Context, a Alert) error {
+	if len(n.To) == 0 {
+		return errors.New("email: no recipients")
+	}
+	subject := fmt.Sprintf("[%s:%s] probe %s", strings.ToUpper(string(a.Status)), a.Severity, a.Probe)
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", n.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(n.To, ", "))
+	// Probe names and labels are arbitrary strings; encoding the subject
+	// keeps any line breaks in them from starting new headers.
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&msg, "%s\r\n\r\n", oneLine(a.String()))
+	fmt.Fprintf(&msg, "Probe: %s\r\nClass: %s\r\n", oneLine(a.Probe), oneLine(a.Class))
+	for k, v := range a.Labels {
+		fmt.Fprintf(&msg, "Label %s: %s\r\n", oneLine(k), oneLine(v))
+	}
+	fmt.Fprintf(&msg, "Started: %s\r\n", a.StartsAt.Format(time.RFC3339))
+	if !a.EndsAt.IsZero() {
+		fmt.Fprintf(&msg, "Resolved: %s\r\n", a.EndsAt.Format(time.RFC3339))
+	}
+
+	// smtp.SendMail doesn't take a context, so give up waiting on it
+	// (but let it finish in the background) if ctx is done first.
+	send := n.sendMail
+	if send == nil {
+		send = smtp.SendMail
+	}
+	errc := make(chan error, 1)
+	go func() { errc <- send(n.Addr, n.Auth, n.From, n.To, msg.Bytes()) }()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// crlfReplacer replaces line breaks with spaces, for oneLine.
+var crlfReplacer = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ")
+
+// oneLine returns s with any line breaks replaced by spaces, so that it
+// can't start a new line of an email.
+func oneLine(s string) string {
+	return crlfReplacer.Replace(s)
+}
+
+// WriterNotifier is a [Notifier] that writes alerts to W, one per line.
+// If W is nil, alerts are written to os.Stdout.
+type WriterNotifier struct {
+	W io.Writer
+
+	mu sync.Mutex
+}
+
+func (n *WriterNotifier) Notify(ctx context.Context, a Alert) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	w := n.W
+	if w == nil {
+		w = os.Stdout
+	}
+	_, err := fmt.Fprintf(w, "%s %s\n", time.Now().Format(time.RFC3339), a)
+	return err
+}
@@ -77,6 +77,11 @@ type Prober struct {
 	// Whether to run all probes once instead of running them in a loop.
 	once bool
 
+	// alerter and store, if non-nil, are sent the result of each probe
+	// run.
+	alerter *Alerter
+	store   *ResultStore
+
 	// Time-related functions that get faked out during tests.
 	now       func() time.Time
 	newTicker func(time.Duration) ticker
@@ -367,6 +372,7 @@ func (p *Probe) run() (pi ProbeInfo, err error) {
 			log.Printf("probe %s panicked: %v", p.name, r)
 			err = fmt.Errorf("panic: %v", r)
 			p.recordEndLocked(err)
+			p.reportResult(err)
 		}
 	}()
 	ctx := p.ctx
@@ -379,15 +385,34 @@ func (p *Probe) run() (pi ProbeInfo, err error) {
 	err = p.probeClass.Probe(ctx)
 
 	p.mu.Lock()
-	defer p.mu.Unlock()
 	p.recordEndLocked(err)
 	if err != nil {
 		log.Printf("probe %s: %v", p.name, err)
 	}
 	pi = p.probeInfoLocked()
+	p.mu.Unlock()
+	p.reportResult(err)
 	return
 }
 
+// reportResult sends the result of the probe run that just ended to the
+// Prober's Alerter and ResultStore, if any.
+func (p *Probe) reportResult(err error) {
+	a, s := p.prober.alerter, p.prober.store
+	if a == nil && s == nil {
+		return
+	}
+	p.mu.Lock()
+	pi := p.probeInfoLocked()
+	p.mu.Unlock()
+	if a != nil {
+		a.record(pi, pi.End, err)
+	}
+	if s != nil {
+		s.record(pi.Name, pi.End, err)
+	}
+}
+
 func (p *Probe) recordStart() {
 	p.mu.Lock()
 	p.start = p.prober.now()
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"html/template"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -65,6 +66,11 @@ func (p *Prober) StatusHandler(opts ...statusHandlerOpt) tsweb.ReturnHandlerFunc
 			TimeSinceLastStart time.Duration
 			TimeSinceLastEnd   time.Duration
 			Links              map[string]template.URL
+
+			// Set if the Prober has a ResultStore.
+			Uptime    string
+			Bars      []uptimeBar
+			Incidents []incidentView
 		}
 		vars := struct {
 			Title           string
@@ -72,9 +78,18 @@ func (p *Prober) StatusHandler(opts ...statusHandlerOpt) tsweb.ReturnHandlerFunc
 			TotalProbes     int64
 			UnhealthyProbes int64
 			Probes          map[string]probeStatus
+			HistoryDays     int // or zero if there's no ResultStore
 		}{
 			Title: params.title,
 		}
+		now := p.now()
+		if p.store != nil {
+			maxDays := max(int(p.store.Retention()/(24*time.Hour)), 1)
+			vars.HistoryDays = min(defaultHistoryDays, maxDays)
+			if d, err := strconv.Atoi(r.FormValue("days")); err == nil && d > 0 {
+				vars.HistoryDays = min(d, maxDays)
+			}
+		}
 
 		for text, url := range params.pageLinks {
 			mak.Set(&vars.Links, text, template.URL(url))
@@ -103,6 +118,16 @@ func (p *Prober) StatusHandler(opts ...statusHandlerOpt) tsweb.ReturnHandlerFunc
 				}
 				mak.Set(&s.Links, text, template.URL(url))
 			}
+			if vars.HistoryDays > 0 {
+				start := historyStart(now, vars.HistoryDays)
+				h := p.store.History(name, start)
+				s.Uptime = "no data"
+				if up := h.Uptime(); up >= 0 {
+					s.Uptime = fmt.Sprintf("%.3f%%", up*100)
+				}
+				s.Bars = uptimeBars(h, start, vars.HistoryDays)
+				s.Incidents = incidentViews(h, now, maxIncidentsShown)
+			}
 			mak.Set(&vars.Probes, name, s)
 		}
 
@@ -113,6 +138,77 @@ func (p *Prober) StatusHandler(opts ...statusHandlerOpt) tsweb.ReturnHandlerFunc
 	}
 }
 
+// defaultHistoryDays is the number of days of probe history shown on the
+// status page by default, if the Prober has a ResultStore.
+const defaultHistoryDays = 14
+
+// maxIncidentsShown is the number of recent incidents shown per probe on
+// the status page.
+const maxIncidentsShown = 5
+
+// historyStart returns the start of the first of the last days UTC days.
+func historyStart(now time.Time, days int) time.Time {
+	y, m, d := now.UTC().Date()
+	return time.Date(y, m, d-days+1, 0, 0, 0, 0, time.UTC)
+}
+
+// uptimeBar is a day of a probe's history on the status page.
+type uptimeBar struct {
+	Title string
+	Class string // CSS class: "up", "degraded", "down" or "nodata"
+}
+
+// uptimeBars returns the bars for days days of h, starting at start.
+func uptimeBars(h ProbeHistory, start time.Time, days int) []uptimeBar {
+	ok := make([]int, days)
+	fail := make([]int, days)
+	for _, b := range h.Buckets {
+		if i := int(b.Start.Sub(start) / (24 * time.Hour)); i >= 0 && i < days {
+			ok[i] += b.OK
+			fail[i] += b.Fail
+		}
+	}
+	bars := make([]uptimeBar, days)
+	for i := range bars {
+		day := start.AddDate(0, 0, i).Format("2006-01-02")
+		total := ok[i] + fail[i]
+		if total == 0 {
+			bars[i] = uptimeBar{Title: day + ": no data", Class: "nodata"}
+			continue
+		}
+		up := float64(ok[i]) / float64(total)
+		class := "down"
+		switch {
+		case up == 1:
+			class = "up"
+		case up >= 0.99:
+			class = "degraded"
+		}
+		bars[i] = uptimeBar{
+			Title: fmt.Sprintf("%s: %.3f%% of %d runs succeeded", day, up*100, total),
+			Class: class,
+		}
+	}
+	return bars
+}
+
+// incidentView is an incident shown on the status page.
+type incidentView struct {
+	Incident
+	Duration time.Duration
+}
+
+// incidentViews returns up to n of the most recent incidents in h, newest
+// first.
+func incidentViews(h ProbeHistory, now time.Time, n int) []incidentView {
+	var ret []incidentView
+	for i := len(h.Incidents) - 1; i >= 0 && len(ret) < n; i-- {
+		in := h.Incidents[i]
+		ret = append(ret, incidentView{in, in.Duration(now).Truncate(time.Second)})
+	}
+	return ret
+}
+
 // renderTemplate renders the given Go template with the provided data
 // and returns the result as a string.
 func renderTemplate(tpl string, data any) (string, error) {
@@ -0,0 +1,402 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package prober
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"tailscale.com/atomicfile"
+	"tailscale.com/types/logger"
+)
+
+// ResultStoreOpts configures a [ResultStore].
+type ResultStoreOpts struct {
+	// Path is the file to store probe results in. It's created if it
+	// doesn't exist.
+	Path string
+
+	// Retention is how long to keep probe history. Defaults to 30 days.
+	Retention time.Duration
+
+	// Resolution is the granularity with which the success of probes is
+	// kept once the store is compacted. Defaults to an hour. Incidents
+	// are kept to the precision of a probe run.
+	Resolution time.Duration
+
+	// Logf logs errors writing to the file. If nil, log.Printf is used.
+	Logf logger.Logf
+}
+
+// ResultStore persists the results of a Prober's probes, so that their
+// history survives restarts. See [Prober.WithResultStore].
+//
+// Results are appended to a file, one JSON object per line. The file is
+// periodically compacted by rewriting it with the results aggregated into
+// buckets of [ResultStoreOpts.Resolution] and with the history older than
+// [ResultStoreOpts.Retention] removed.
+type ResultStore struct {
+	path       string
+	retention  time.Duration
+	resolution time.Duration
+	logf       logger.Logf
+
+	mu        sync.Mutex
+	f         *os.File // or nil if closed
+	appended  int      // records appended since the last compaction
+	compacted int      // records written by the last compaction
+	probes    map[string]*probeHistory
+}
+
+// probeHistory is the history of a probe in a ResultStore.
+type probeHistory struct {
+	buckets   []UptimeBucket // by Start
+	incidents []Incident     // by Start; the last may be ongoing
+}
+
+// UptimeBucket counts the probe runs that ended within a period.
+type UptimeBucket struct {
+	Start time.Time
+	OK    int `json:",omitzero"`
+	Fail  int `json:",omitzero"`
+}
+
+// Incident is a period during which a probe failed.
+type Incident struct {
+	// Start is when the first failed probe run ended.
+	Start time.Time
+
+	// End is when the first successful probe run after Start ended, or
+	// zero if the incident is ongoing.
+	End time.Time `json:",omitzero"`
+
+	// Failures is the number of failed probe runs.
+	Failures int
+
+	// Error is the error of the first failed probe run.
+	Error string `json:",omitempty"`
+}
+
+// Ongoing reports whether the incident hasn't ended.
+func (in Incident) Ongoing() bool { return in.End.IsZero() }
+
+// Duration returns the duration of the incident, up to now if it's ongoing.
+func (in Incident) Duration(now time.Time) time.Duration {
+	if in.Ongoing() {
+		return now.Sub(in.Start)
+	}
+	return in.End.Sub(in.Start)
+}
+
+// storeRecord is a line of a ResultStore file. Exactly one of Result,
+// Bucket and Incident is set.
+type storeRecord struct {
+	Probe    string        `json:"probe"`
+	Result   *storedResult `json:"result,omitempty"`
+	Bucket   *UptimeBucket `json:"bucket,omitempty"`
+	Incident *Incident     `json:"incident,omitempty"`
+}
+
+type storedResult struct {
+	End   time.Time
+	OK    bool
+	Error string `json:",omitempty"`
+}
+
+// OpenResultStore opens the store at opts.Path, creating it if needed, and
+// compacts it.
+func OpenResultStore(opts ResultStoreOpts) (*ResultStore, error) {
+	if opts.Path == "" {
+		return nil, errors.New("result store requires a path")
+	}
+	s := &ResultStore{
+		path:       opts.Path,
+		retention:  cmp.Or(opts.Retention, 30*24*time.Hour),
+		resolution: cmp.Or(opts.Resolution, time.Hour),
+		logf:       opts.Logf,
+		probes:     make(map[string]*probeHistory),
+	}
+	if s.logf == nil {
+		s.logf = log.Printf
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.compactLocked(time.Now()); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load reads the store's file into memory.
+func (s *ResultStore) load() error {
+	f, err := os.Open(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for n := 1; sc.Scan(); n++ {
+		var rec storeRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			// A partially written last line is expected after a
+			// crash; skip it, and anything else that's corrupt.
+			s.logf("prober: %s:%d: skipping bad record: %v", s.path, n, err)
+			continue
+		}
+		h := s.historyLocked(rec.Probe)
+		switch {
+		case rec.Result != nil:
+			h.add(rec.Result.End.Truncate(s.resolution), rec.Result.End, rec.Result.OK, rec.Result.Error)
+		case rec.Bucket != nil:
+			h.mergeBucket(*rec.Bucket)
+		case rec.Incident != nil:
+			h.mergeIncident(*rec.Incident)
+		}
+	}
+	return sc.Err()
+}
+
+func (s *ResultStore) historyLocked(probe string) *probeHistory {
+	h, ok := s.probes[probe]
+	if !ok {
+		h = new(probeHistory)
+		s.probes[probe] = h
+	}
+	return h
+}
+
+// add records a probe run that ended at end, in the bucket starting at
+// bucket. It returns the incident that the run ended, if any.
+func (h *probeHistory) add(bucket, end time.Time, ok bool, errMsg string) (ended *Incident) {
+	b := h.bucket(bucket)
+	if ok {
+		b.OK++
+	} else {
+		b.Fail++
+	}
+
+	var cur *Incident
+	if n := len(h.incidents); n > 0 && h.incidents[n-1].Ongoing() {
+		cur = &h.incidents[n-1]
+	}
+	switch {
+	case ok && cur != nil:
+		cur.End = end
+		return cur
+	case !ok && cur != nil:
+		cur.Failures++
+	case !ok:
+		h.incidents = append(h.incidents, Incident{Start: end, Failures: 1, Error: errMsg})
+	}
+	return nil
+}
+
+// bucket returns the bucket of h starting at start, adding it if needed.
+func (h *probeHistory) bucket(start time.Time) *UptimeBucket {
+	i, found := slices.BinarySearchFunc(h.buckets, start, func(b UptimeBucket, t time.Time) int { return b.Start.Compare(t) })
+	if !found {
+		h.buckets = slices.Insert(h.buckets, i, UptimeBucket{Start: start})
+	}
+	return &h.buckets[i]
+}
+
+// mergeBucket adds the counts of b to h.
+func (h *probeHistory) mergeBucket(b UptimeBucket) {
+	hb := h.bucket(b.Start)
+	hb.OK += b.OK
+	hb.Fail += b.Fail
+}
+
+// mergeIncident adds in to h, replacing the ongoing incident with the same
+// start time, if any.
+func (h *probeHistory) mergeIncident(in Incident) {
+	i, found := slices.BinarySearchFunc(h.incidents, in.Start, func(in Incident, t time.Time) int { return in.Start.Compare(t) })
+	if found {
+		h.incidents[i] = in
+		return
+	}
+	h.incidents = slices.Insert(h.incidents, i, in)
+}
+
+// WithResultStore makes p record the results of its probes in s.
+func (p *Prober) WithResultStore(s *ResultStore) *Prober {
+	p.store = s
+	return p
+}
+
+// record records a probe result that ended at end.
+func (s *ResultStore) record(probe string, end time.Time, err error) {
+	var errMsg string
+	if err != nil {
+		errMsg = err.Error()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return
+	}
+	h := s.historyLocked(probe)
+	ended := h.add(end.Truncate(s.resolution), end, err == nil, errMsg)
+
+	recs := []storeRecord{{Probe: probe, Result: &storedResult{End: end, OK: err == nil, Error: errMsg}}}
+	if ended != nil {
+		// Also write the whole incident, so that it survives the
+		// compaction of its results.
+		in := *ended
+		recs = append(recs, storeRecord{Probe: probe, Incident: &in})
+	}
+	var buf bytes.Buffer
+	for _, rec := range recs {
+		b, _ := json.Marshal(rec)
+		buf.Write(b)
+		buf.WriteByte('\n')
+	}
+	if _, err := s.f.Write(buf.Bytes()); err != nil {
+		s.logf("prober: writing result store: %v", err)
+	}
+	s.appended += len(recs)
+	if s.appended > max(10_000, 2*s.compacted) {
+		if err := s.compactLocked(end); err != nil {
+			s.logf("prober: compacting result store: %v", err)
+		}
+	}
+}
+
+// Compact rewrites the store's file with the results aggregated into
+// buckets, and removes the history older than the retention period.
+// It's done automatically as results are recorded.
+func (s *ResultStore) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return errors.New("result store closed")
+	}
+	return s.compactLocked(time.Now())
+}
+
+func (s *ResultStore) compactLocked(now time.Time) error {
+	cutoff := now.Add(-s.retention)
+	var buf bytes.Buffer
+	n := 0
+	write := func(rec storeRecord) {
+		b, _ := json.Marshal(rec)
+		buf.Write(b)
+		buf.WriteByte('\n')
+		n++
+	}
+	for _, name := range slices.Sorted(maps.Keys(s.probes)) {
+		h := s.probes[name]
+		h.buckets = slices.DeleteFunc(h.buckets, func(b UptimeBucket) bool {
+			return b.Start.Add(s.resolution).Before(cutoff)
+		})
+		h.incidents = slices.DeleteFunc(h.incidents, func(in Incident) bool {
+			return !in.Ongoing() && in.End.Before(cutoff)
+		})
+		if len(h.buckets) == 0 && len(h.incidents) == 0 {
+			delete(s.probes, name)
+			continue
+		}
+		for _, b := range h.buckets {
+			write(storeRecord{Probe: name, Bucket: &b})
+		}
+		for _, in := range h.incidents {
+			write(storeRecord{Probe: name, Incident: &in})
+		}
+	}
+
+	// Only swap to the compacted file once it's written and open, so that
+	// if writing it fails, results keep being appended to the old one.
+	if err := atomicfile.WriteFile(s.path, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	if s.f != nil {
+		s.f.Close()
+	}
+	s.f = f
+	s.appended = 0
+	s.compacted = n
+	return nil
+}
+
+// Close closes the store. Results recorded after Close are dropped.
+func (s *ResultStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return errors.New("already closed")
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
+
+// ProbeHistory is the history of a probe's results.
+type ProbeHistory struct {
+	// Buckets are the counts of the probe's results, oldest first.
+	Buckets []UptimeBucket
+
+	// Incidents are the periods during which the probe failed, oldest
+	// first. The last one may be ongoing.
+	Incidents []Incident
+}
+
+// Uptime returns the ratio of successful probe runs in h, or -1 if there
+// are none.
+func (h ProbeHistory) Uptime() float64 {
+	var ok, total int
+	for _, b := range h.Buckets {
+		ok += b.OK
+		total += b.OK + b.Fail
+	}
+	if total == 0 {
+		return -1
+	}
+	return float64(ok) / float64(total)
+}
+
+// History returns the history of the named probe since the given time.
+func (s *ResultStore) History(probe string, since time.Time) ProbeHistory {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, ok := s.probes[probe]
+	if !ok {
+		return ProbeHistory{}
+	}
+	var ret ProbeHistory
+	for _, b := range h.buckets {
+		if !b.Start.Add(s.resolution).Before(since) {
+			ret.Buckets = append(ret.Buckets, b)
+		}
+	}
+	for _, in := range h.incidents {
+		if in.Ongoing() || !in.End.Before(since) {
+			ret.Incidents = append(ret.Incidents, in)
+		}
+	}
+	return ret
+}
+
+// Retention returns the store's retention period.
+func (s *ResultStore) Retention() time.Duration { return s.retention }
@@ -0,0 +1,160 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package prober
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResultStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.jsonl")
+	opts := ResultStoreOpts{Path: path, Retention: 48 * time.Hour, Logf: t.Logf}
+	s, err := OpenResultStore(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now().Add(-time.Hour).Truncate(time.Hour)
+	at := func(m int) time.Time { return start.Add(time.Duration(m) * time.Minute) }
+	down := errors.New("down")
+	for m := range 30 {
+		var err error
+		if m >= 10 && m < 15 || m >= 25 {
+			err = down
+		}
+		s.record("p1", at(m), err)
+	}
+	s.record("p2", at(0), nil)
+
+	want := ProbeHistory{
+		Buckets: []UptimeBucket{{Start: start, OK: 20, Fail: 10}},
+		Incidents: []Incident{
+			{Start: at(10), End: at(15), Failures: 5, Error: "down"},
+			{Start: at(25), Failures: 5, Error: "down"},
+		},
+	}
+	check := func(s *ResultStore, want ProbeHistory) {
+		t.Helper()
+		got := s.History("p1", start)
+		for i := range got.Buckets {
+			got.Buckets[i].Start = got.Buckets[i].Start.Local()
+		}
+		for i := range got.Incidents {
+			got.Incidents[i].Start = got.Incidents[i].Start.Local()
+			if !got.Incidents[i].End.IsZero() {
+				got.Incidents[i].End = got.Incidents[i].End.Local()
+			}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("history = %+v; want %+v", got, want)
+		}
+	}
+	check(s, want)
+	if got, want := s.History("p1", start).Uptime(), 20.0/30; got != want {
+		t.Errorf("uptime = %v; want %v", got, want)
+	}
+	if got := s.History("missing", start).Uptime(); got != -1 {
+		t.Errorf("uptime of missing probe = %v; want -1", got)
+	}
+
+	// Reopening the store (which compacts it) keeps the history, and the
+	// ongoing incident can be ended.
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s, err = OpenResultStore(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(s, want)
+	s.record("p1", at(40), nil)
+	want.Buckets[0].OK++
+	want.Incidents[1].End = at(40)
+	check(s, want)
+
+	// A partially written record (such as after a crash) is skipped.
+	s.Close()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"probe":"p1","result":{"End":`)
+	f.Close()
+	s, err = OpenResultStore(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(s, want)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(b), "\n"); lines != 4 {
+		t.Errorf("compacted store has %d lines; want 4 (2 buckets, 2 incidents):\n%s", lines, b)
+	}
+
+	// History older than the retention period is dropped on compaction.
+	s.record("p1", at(0).Add(72*time.Hour), nil)
+	if err := s.compactLocked(at(0).Add(72 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	check(s, ProbeHistory{Buckets: []UptimeBucket{{Start: start.Add(72 * time.Hour), OK: 1}}})
+	if h := s.History("p2", start); len(h.Buckets) != 0 {
+		t.Errorf("p2 history not dropped: %+v", h)
+	}
+
+	// If compaction fails, results are still appended to the old file.
+	s.path = filepath.Join(t.TempDir(), "missing", "results.jsonl")
+	if err := s.Compact(); err == nil {
+		t.Error("compaction into a missing directory succeeded")
+	}
+	s.path = path
+	s.record("p1", at(1).Add(72*time.Hour), nil)
+	s.Close()
+	s, err = OpenResultStore(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(s, ProbeHistory{Buckets: []UptimeBucket{{Start: start.Add(72 * time.Hour), OK: 2}}})
+	s.Close()
+}
+
+func TestStatusHandlerHistory(t *testing.T) {
+	s, err := OpenResultStore(ResultStoreOpts{Path: filepath.Join(t.TempDir(), "results.jsonl")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	clk := newFakeTime()
+	p := newForTest(clk.Now, clk.NewTicker).WithOnce(true).WithResultStore(s)
+	p.Run("failing", probeInterval, nil, FuncProbe(func(context.Context) error {
+		return errors.New("broken")
+	}))
+	p.Wait()
+	if h := s.History("failing", time.Time{}); len(h.Incidents) != 1 {
+		t.Fatalf("history = %+v; want one incident", h)
+	}
+
+	rec := httptest.NewRecorder()
+	if err := p.StatusHandler()(rec, httptest.NewRequest("GET", "/?days=3", nil)); err != nil {
+		t.Fatal(err)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{"History (3 days)", "Uptime: 0.000%", `class="down"`, "Ongoing", "broken"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("status page doesn't contain %q", want)
+		}
+	}
+	if n := strings.Count(body, `<span class="nodata"`); n != 2 {
+		t.Errorf("status page has %d days without data; want 2", n)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package prober
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+)
+
+// TailnetPinger pings tailnet peers. It's implemented by
+// [tailscale.com/client/local.Client], such as the one returned by
+// tsnet.Server.LocalClient, which lets a prober monitor peers (such as
+// subnet routers and exit nodes) from inside a tailnet.
+type TailnetPinger interface {
+	Ping(ctx context.Context, ip netip.Addr, pingType tailcfg.PingType) (*ipnstate.PingResult, error)
+}
+
+// TailnetPingOpts contains options for [TailnetPing]. The zero value for
+// all fields is valid.
+type TailnetPingOpts struct {
+	// PingType is the type of ping to send. Defaults to TSMP, which tests
+	// the peer's WireGuard and netstack or TUN device. Use disco to test
+	// only its magicsock path.
+	PingType tailcfg.PingType
+
+	// RequireDirect is whether the probe fails if the peer is reached
+	// through DERP rather than over a direct connection. It has no
+	// effect for TSMP pings, which don't report the path used.
+	RequireDirect bool
+}
+
+// TailnetPing returns a ProbeClass that pings the tailnet peer at ip through
+// pinger.
+//
+// ip may also be an IP address behind a subnet router, in which case
+// the subnet router is pinged.
+func TailnetPing(pinger TailnetPinger, ip netip.Addr, opts TailnetPingOpts) ProbeClass {
+	if opts.PingType == "" {
+		opts.PingType = tailcfg.PingTSMP
+	}
+	return ProbeClass{
+		Probe: func(ctx context.Context) error {
+			return probeTailnetPing(ctx, pinger, ip, opts)
+		},
+		Class: "tailnet_ping",
+		Labels: Labels{
+			"peer":      ip.String(),
+			"ping_type": string(opts.PingType),
+		},
+	}
+}
+
+func probeTailnetPing(ctx context.Context, pinger TailnetPinger, ip netip.Addr, opts TailnetPingOpts) error {
+	res, err := pinger.Ping(ctx, ip, opts.PingType)
+	if err != nil {
+		return fmt.Errorf("pinging %v: %w", ip, err)
+	}
+	if res.Err != "" {
+		return fmt.Errorf("pinging %v: %s", ip, res.Err)
+	}
+	if opts.RequireDirect && res.DERPRegionID != 0 {
+		return fmt.Errorf("pinging %v: reached %s via DERP region %s, want direct", ip, res.NodeName, res.DERPRegionCode)
+	}
+	return nil
+}
+
+// TailnetDial returns a ProbeClass that healthchecks a TCP service on the
+// tailnet by connecting to addr ("host:port") using dial, such as
+// tsnet.Server.Dial.
+func TailnetDial(dial func(ctx context.Context, network, addr string) (net.Conn, error), addr string) ProbeClass {
+	return ProbeClass{
+		Probe: func(ctx context.Context) error {
+			conn, err := dial(ctx, "tcp", addr)
+			if err != nil {
+				return fmt.Errorf("dialing %q over tailnet: %w", addr, err)
+			}
+			conn.Close()
+			return nil
+		},
+		Class: "tailnet_dial",
+		Labels: Labels{
+			"peer": addr,
+		},
+	}
+}
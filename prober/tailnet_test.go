@@ -0,0 +1,80 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package prober
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+)
+
+type fakePinger func(ip netip.Addr, pingType tailcfg.PingType) (*ipnstate.PingResult, error)
+
+func (f fakePinger) Ping(ctx context.Context, ip netip.Addr, pingType tailcfg.PingType) (*ipnstate.PingResult, error) {
+	return f(ip, pingType)
+}
+
+func TestTailnetPing(t *testing.T) {
+	peer := netip.MustParseAddr("100.64.0.1")
+	var gotType tailcfg.PingType
+	res := &ipnstate.PingResult{NodeName: "router"}
+	pinger := fakePinger(func(ip netip.Addr, pingType tailcfg.PingType) (*ipnstate.PingResult, error) {
+		if ip != peer {
+			return nil, errors.New("unknown peer")
+		}
+		gotType = pingType
+		return res, nil
+	})
+	ctx := context.Background()
+
+	pc := TailnetPing(pinger, peer, TailnetPingOpts{})
+	if err := pc.Probe(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if gotType != tailcfg.PingTSMP {
+		t.Errorf("ping type = %q; want TSMP", gotType)
+	}
+	if pc.Labels["peer"] != "100.64.0.1" || pc.Labels["ping_type"] != "TSMP" {
+		t.Errorf("labels = %v", pc.Labels)
+	}
+
+	res.DERPRegionID, res.DERPRegionCode = 1, "nyc"
+	if err := TailnetPing(pinger, peer, TailnetPingOpts{PingType: tailcfg.PingDisco}).Probe(ctx); err != nil {
+		t.Errorf("disco ping via DERP: %v", err)
+	}
+	err := TailnetPing(pinger, peer, TailnetPingOpts{PingType: tailcfg.PingDisco, RequireDirect: true}).Probe(ctx)
+	if err == nil || !strings.Contains(err.Error(), "via DERP region nyc") {
+		t.Errorf("disco ping via DERP with RequireDirect = %v", err)
+	}
+
+	res.Err = "timeout"
+	if err := pc.Probe(ctx); err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Errorf("failed ping = %v", err)
+	}
+	if err := TailnetPing(pinger, netip.MustParseAddr("100.64.0.2"), TailnetPingOpts{}).Probe(ctx); err == nil {
+		t.Error("ping of unknown peer succeeded")
+	}
+}
+
+func TestTailnetDial(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var d net.Dialer
+	if err := TailnetDial(d.DialContext, ln.Addr().String()).Probe(context.Background()); err != nil {
+		t.Errorf("dialing listener: %v", err)
+	}
+	ln.Close()
+	if err := TailnetDial(d.DialContext, ln.Addr().String()).Probe(context.Background()); err == nil {
+		t.Error("dialing closed listener succeeded")
+	}
+}
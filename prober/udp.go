@@ -0,0 +1,76 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package prober
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// udpRetransmitInterval is how long a UDP probe waits for a response before
+// sending its request again.
+const udpRetransmitInterval = time.Second
+
+// UDP returns a ProbeClass that healthchecks a UDP request/response
+// service, such as an echo server.
+//
+// The probe function sends request to addr in a datagram and waits for a
+// response, resending the request every second until one arrives. If want
+// is non-nil, the response must be equal to it.
+func UDP(addr string, request, want []byte) ProbeClass {
+	return ProbeClass{
+		Probe: func(ctx context.Context) error {
+			return probeUDP(ctx, addr, request, want)
+		},
+		Class: "udp",
+	}
+}
+
+// UDPEcho is like UDP, but expects the response to be equal to the
+// request.
+func UDPEcho(addr string, request []byte) ProbeClass {
+	pc := UDP(addr, request, request)
+	pc.Class = "udp_echo"
+	return pc
+}
+
+func probeUDP(ctx context.Context, addr string, request, want []byte) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return fmt.Errorf("dialing %q: %v", addr, err)
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 64<<10)
+	for {
+		if _, err := conn.Write(request); err != nil {
+			return fmt.Errorf("sending to %q: %w", addr, err)
+		}
+		dl := time.Now().Add(udpRetransmitInterval)
+		ctxDL, last := ctx.Deadline()
+		if last = last && !ctxDL.After(dl); last {
+			dl = ctxDL
+		}
+		conn.SetReadDeadline(dl)
+		n, err := conn.Read(buf)
+		if err != nil {
+			if ne, ok := errors.AsType[net.Error](err); ok && ne.Timeout() && !last && ctx.Err() == nil {
+				continue
+			}
+			return fmt.Errorf("reading from %q: %w", addr, err)
+		}
+		if want != nil && !bytes.Equal(buf[:n], want) {
+			if maxlen := 300; n > maxlen {
+				n = maxlen
+			}
+			return fmt.Errorf("response from %q is %q, want %q", addr, buf[:n], want)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package prober
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestUDP(t *testing.T) {
+	uc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer uc.Close()
+	var dropped atomic.Bool
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := uc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			// Drop the first request, to exercise retransmission.
+			if !dropped.Swap(true) {
+				continue
+			}
+			uc.WriteTo(buf[:n], addr)
+		}
+	}()
+	addr := uc.LocalAddr().String()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := UDPEcho(addr, []byte("ping")).Probe(ctx); err != nil {
+		t.Errorf("echo probe: %v", err)
+	}
+	if !dropped.Load() {
+		t.Error("first request not dropped")
+	}
+	if err := UDP(addr, []byte("ping"), nil).Probe(ctx); err != nil {
+		t.Errorf("probe without want: %v", err)
+	}
+	err = UDP(addr, []byte("ping"), []byte("pong")).Probe(ctx)
+	if err == nil || !strings.Contains(err.Error(), `is "ping", want "pong"`) {
+		t.Errorf("probe with wrong response = %v", err)
+	}
+
+	uc.Close()
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := UDPEcho(addr, []byte("ping")).Probe(ctx); err == nil {
+		t.Error("probe of closed port succeeded")
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package sessionrecording
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Asciinema v2 event codes.
+// See https://docs.asciinema.org/manual/asciicast/v2/#supported-event-types.
+const (
+	CastOutput = "o" // data written to the terminal
+	CastInput  = "i" // data read from the terminal
+	CastResize = "r" // terminal resize, as "<a>x<b>"
+	CastMarker = "m" // marker
+)
+
+// CastEvent is a single event line of an asciinema v2 cast, following
+// the CastHeader. It's encoded as a JSON array of [Time, Code, Data].
+type CastEvent struct {
+	// Time is the number of seconds since the start of the recording.
+	Time float64
+
+	// Code is the type of event, such as CastOutput.
+	Code string
+
+	// Data is the event's data, such as the output written.
+	Data string
+}
+
+// Offset returns e.Time as a time.Duration.
+func (e CastEvent) Offset() time.Duration {
+	return time.Duration(e.Time * float64(time.Second))
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e CastEvent) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]any{e.Time, e.Code, e.Data})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (e *CastEvent) UnmarshalJSON(b []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	if len(raw) != 3 {
+		return fmt.Errorf("cast event has %d elements; want 3", len(raw))
+	}
+	var ev CastEvent
+	if err := json.Unmarshal(raw[0], &ev.Time); err != nil {
+		return fmt.Errorf("cast event time: %w", err)
+	}
+	if err := json.Unmarshal(raw[1], &ev.Code); err != nil {
+		return fmt.Errorf("cast event code: %w", err)
+	}
+	if err := json.Unmarshal(raw[2], &ev.Data); err != nil {
+		return fmt.Errorf("cast event data: %w", err)
+	}
+	*e = ev
+	return nil
+}
+
+// CastReader reads an asciinema v2 cast as written by session recorders:
+// a CastHeader followed by a stream of CastEvents.
+type CastReader struct {
+	dec *json.Decoder
+	hdr CastHeader
+}
+
+// NewCastReader returns a CastReader that reads from r.
+// It reads and validates the cast's header before returning.
+func NewCastReader(r io.Reader) (*CastReader, error) {
+	cr := &CastReader{dec: json.NewDecoder(r)}
+	if err := cr.dec.Decode(&cr.hdr); err != nil {
+		if err == io.EOF {
+			return nil, errors.New("empty cast")
+		}
+		return nil, fmt.Errorf("reading cast header: %w", err)
+	}
+	if cr.hdr.Version != 2 {
+		return nil, fmt.Errorf("unsupported cast version %d", cr.hdr.Version)
+	}
+	return cr, nil
+}
+
+// Header returns the cast's header.
+func (cr *CastReader) Header() CastHeader {
+	return cr.hdr
+}
+
+// Next returns the next event in the cast.
+// It returns io.EOF when there are no more events.
+//
+// Recordings of sessions that were cut short may end with a partial
+// event, in which case Next returns io.ErrUnexpectedEOF.
+func (cr *CastReader) Next() (CastEvent, error) {
+	var ev CastEvent
+	err := cr.dec.Decode(&ev)
+	return ev, err
+}
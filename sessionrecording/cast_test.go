@@ -0,0 +1,97 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package sessionrecording
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCastReader(t *testing.T) {
+	const cast = `{"version":2,"width":80,"height":24,"timestamp":1700000000,"srcNode":"laptop.tail-scale.ts.net","sshUser":"alice","localUser":"root"}
+[0.5,"o","hello\r\n"]
+[1.25,"r","100x40"]
+[2,"o","bye\u001b[0m"]
+`
+	cr, err := NewCastReader(strings.NewReader(cast))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := cr.Header(); h.SrcNode != "laptop.tail-scale.ts.net" || h.SSHUser != "alice" || h.LocalUser != "root" {
+		t.Errorf("unexpected header %+v", h)
+	}
+	var got []CastEvent
+	for {
+		ev, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, ev)
+	}
+	want := []CastEvent{
+		{0.5, CastOutput, "hello\r\n"},
+		{1.25, CastResize, "100x40"},
+		{2, CastOutput, "bye\x1b[0m"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d events; want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("event %d = %+v; want %+v", i, got[i], want[i])
+		}
+	}
+	if d := got[1].Offset(); d != 1250*time.Millisecond {
+		t.Errorf("Offset = %v; want 1.25s", d)
+	}
+
+	// Events round trip through JSON in the format written by recorders.
+	j, err := json.Marshal(want[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(j) != `[0.5,"o","hello\r\n"]` {
+		t.Errorf("Marshal = %s", j)
+	}
+}
+
+func TestCastReaderErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		cast    string
+		wantErr bool  // from NewCastReader
+		nextErr error // from the first Next; nil means any error
+	}{
+		{name: "empty", cast: "", wantErr: true},
+		{name: "version", cast: `{"version":1}`, wantErr: true},
+		{name: "truncated", cast: `{"version":2}` + "\n" + `[0.1,"o","hel`, nextErr: io.ErrUnexpectedEOF},
+		{name: "no-events", cast: `{"version":2}` + "\n", nextErr: io.EOF},
+		{name: "short-event", cast: `{"version":2}` + "\n" + `[1,"o"]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr, err := NewCastReader(strings.NewReader(tt.cast))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewCastReader error = %v; wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			_, err = cr.Next()
+			if tt.nextErr == nil && err == nil {
+				t.Error("Next error = nil; want error")
+			}
+			if tt.nextErr != nil && !errors.Is(err, tt.nextErr) {
+				t.Errorf("Next error = %v; want %v", err, tt.nextErr)
+			}
+		})
+	}
+}
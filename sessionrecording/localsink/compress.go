@@ -0,0 +1,128 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package localsink
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"time"
+
+	"tailscale.com/util/zstdframe"
+)
+
+const (
+	// maxFrameSize is how much uncompressed data is buffered
+	// before it's written out as a zstd frame.
+	maxFrameSize = 64 << 10
+
+	// maxFrameDelay is how long data may sit in the buffer before it's
+	// written out on the next Write, so that little is lost if the
+	// process dies during a long, quiet session.
+	maxFrameDelay = 10 * time.Second
+)
+
+// compressWriter writes independently compressed zstd frames to w.
+// The output is a valid zstd stream even if it's truncated after
+// any frame.
+type compressWriter struct {
+	w         io.Writer
+	now       func() time.Time
+	buf       []byte
+	out       []byte
+	lastFlush time.Time
+}
+
+func newCompressWriter(w io.Writer, now func() time.Time) *compressWriter {
+	return &compressWriter{w: w, now: now, lastFlush: now()}
+}
+
+func (c *compressWriter) Write(p []byte) (int, error) {
+	c.buf = append(c.buf, p...)
+	if len(c.buf) >= maxFrameSize || c.now().Sub(c.lastFlush) >= maxFrameDelay {
+		if err := c.flush(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (c *compressWriter) flush() error {
+	c.lastFlush = c.now()
+	if len(c.buf) == 0 {
+		return nil
+	}
+	c.out = zstdframe.AppendEncode(c.out[:0], c.buf, zstdframe.WithChecksum(true))
+	c.buf = c.buf[:0]
+	_, err := c.w.Write(c.out)
+	return err
+}
+
+// Close flushes any buffered data. It does not close the underlying writer.
+func (c *compressWriter) Close() error {
+	return c.flush()
+}
+
+// maxDecodedFrameSize bounds the memory used to decode a single frame.
+// It's well above maxFrameSize to allow for recordings written by
+// other encoders.
+const maxDecodedFrameSize = 16 << 20
+
+// decompressReader decodes a stream of zstd frames one frame at a time.
+type decompressReader struct {
+	r   io.Reader
+	buf bytes.Buffer // decoded data not yet read
+	raw []byte
+	err error
+}
+
+func newDecompressReader(r io.Reader) *decompressReader {
+	return &decompressReader{r: r}
+}
+
+func (d *decompressReader) Read(p []byte) (int, error) {
+	for d.buf.Len() == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		d.err = d.readFrame()
+	}
+	return d.buf.Read(p)
+}
+
+// readFrame reads and decodes the next frame into d.buf.
+func (d *decompressReader) readFrame() error {
+	for {
+		n, err := zstdframe.NextSize(d.raw)
+		if err == nil && n <= len(d.raw) {
+			frame := d.raw[:n]
+			out, err := zstdframe.AppendDecode(d.buf.AvailableBuffer(), frame, zstdframe.MaxDecodedSize(maxDecodedFrameSize))
+			if err != nil {
+				return err
+			}
+			d.buf.Write(out)
+			d.raw = d.raw[n:]
+			return nil
+		}
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return err
+		}
+		// Need more input.
+		chunk := make([]byte, 32<<10)
+		m, rerr := d.r.Read(chunk)
+		d.raw = append(d.raw, chunk[:m]...)
+		if rerr == io.EOF {
+			if m > 0 {
+				continue
+			}
+			if len(d.raw) > 0 {
+				return io.ErrUnexpectedEOF
+			}
+			return io.EOF
+		}
+		if rerr != nil {
+			return rerr
+		}
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package localsink
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// Encrypted recordings have the following format:
+//
+//	magic         [8]byte  "tsrecenc"
+//	version       byte     1
+//	ephemeralPub  [32]byte
+//	frames        ...
+//
+// Each frame is a big-endian uint32 length followed by that many bytes of
+// NaCl box sealed data, sealed from the ephemeral key to the recipient key
+// with a nonce of the frame's big-endian uint64 sequence number, starting
+// at zero. The first byte of each opened frame is 1 for the final frame
+// and 0 otherwise; the rest is recording data. A recording without a final
+// frame was truncated.
+const (
+	encMagic        = "tsrecenc"
+	encVersion      = 1
+	maxEncFrameData = 64 << 10
+)
+
+const (
+	publicKeyPrefix  = "recpub:"
+	privateKeyPrefix = "recpriv:"
+)
+
+// PublicKey is the X25519 public key of a recipient of encrypted recordings.
+type PublicKey struct {
+	k [32]byte
+}
+
+// PrivateKey is the X25519 private key that decrypts recordings
+// encrypted to its PublicKey.
+type PrivateKey struct {
+	k [32]byte
+}
+
+// NewPrivateKey generates a new random PrivateKey.
+func NewPrivateKey() PrivateKey {
+	var k PrivateKey
+	rand.Read(k.k[:])
+	return k
+}
+
+// Public returns the public key for k.
+func (k PrivateKey) Public() PublicKey {
+	var pub PublicKey
+	curve25519.ScalarBaseMult(&pub.k, &k.k)
+	return pub
+}
+
+// String returns k as "recpriv:" followed by 64 hex digits.
+func (k PrivateKey) String() string { return privateKeyPrefix + hex.EncodeToString(k.k[:]) }
+
+// String returns k as "recpub:" followed by 64 hex digits.
+func (k PublicKey) String() string { return publicKeyPrefix + hex.EncodeToString(k.k[:]) }
+
+// ParsePublicKey parses a PublicKey in the format returned by [PublicKey.String].
+func ParsePublicKey(s string) (PublicKey, error) {
+	var k PublicKey
+	err := parseHexKey(&k.k, s, publicKeyPrefix)
+	return k, err
+}
+
+// ParsePrivateKey parses a PrivateKey in the format returned by [PrivateKey.String].
+func ParsePrivateKey(s string) (PrivateKey, error) {
+	var k PrivateKey
+	err := parseHexKey(&k.k, s, privateKeyPrefix)
+	return k, err
+}
+
+func parseHexKey(dst *[32]byte, s, prefix string) error {
+	hexKey, ok := strings.CutPrefix(strings.TrimSpace(s), prefix)
+	if !ok {
+		return fmt.Errorf("key %q does not start with %q", s, prefix)
+	}
+	b, err := hex.DecodeString(hexKey)
+	if err != nil || len(b) != len(dst) {
+		return fmt.Errorf("key %q is not %d hex-encoded bytes", s, len(dst))
+	}
+	copy(dst[:], b)
+	return nil
+}
+
+func frameNonce(seq uint64) *[24]byte {
+	var nonce [24]byte
+	binary.BigEndian.PutUint64(nonce[16:], seq)
+	return &nonce
+}
+
+// encryptWriter encrypts data written to it to a recipient's PublicKey.
+type encryptWriter struct {
+	w      io.Writer
+	shared [32]byte
+	seq    uint64
+	buf    []byte
+	closed bool
+}
+
+func newEncryptWriter(w io.Writer, recipient PublicKey) (*encryptWriter, error) {
+	ephPub, ephPriv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	e := &encryptWriter{w: w}
+	box.Precompute(&e.shared, &recipient.k, ephPriv)
+
+	hdr := make([]byte, 0, len(encMagic)+1+len(ephPub))
+	hdr = append(hdr, encMagic...)
+	hdr = append(hdr, encVersion)
+	hdr = append(hdr, ephPub[:]...)
+	if _, err := w.Write(hdr); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (n int, err error) {
+	if e.closed {
+		return 0, errors.New("write to closed encryptWriter")
+	}
+	for len(p) > 0 {
+		chunk := p[:min(len(p), maxEncFrameData)]
+		if err := e.writeFrame(false, chunk); err != nil {
+			return n, err
+		}
+		p = p[len(chunk):]
+		n += len(chunk)
+	}
+	return n, nil
+}
+
+func (e *encryptWriter) writeFrame(final bool, data []byte) error {
+	var flag byte
+	if final {
+		flag = 1
+	}
+	plain := append([]byte{flag}, data...)
+	e.buf = append(e.buf[:0], 0, 0, 0, 0)
+	e.buf = box.SealAfterPrecomputation(e.buf, plain, frameNonce(e.seq), &e.shared)
+	binary.BigEndian.PutUint32(e.buf, uint32(len(e.buf)-4))
+	e.seq++
+	_, err := e.w.Write(e.buf)
+	return err
+}
+
+// Close writes the final frame. It does not close the underlying writer.
+func (e *encryptWriter) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	return e.writeFrame(true, nil)
+}
+
+// errTruncated is returned when an encrypted recording ends without
+// its final frame.
+var errTruncated = fmt.Errorf("localsink: encrypted recording truncated: %w", io.ErrUnexpectedEOF)
+
+// decryptReader decrypts a recording written by encryptWriter.
+type decryptReader struct {
+	r      io.Reader
+	shared [32]byte
+	seq    uint64
+	plain  []byte // decrypted data not yet read
+	done   bool   // final frame seen
+}
+
+func newDecryptReader(r io.Reader, priv PrivateKey) (*decryptReader, error) {
+	hdr := make([]byte, len(encMagic)+1+32)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, fmt.Errorf("localsink: reading encryption header: %w", err)
+	}
+	if string(hdr[:len(encMagic)]) != encMagic {
+		return nil, errors.New("localsink: not an encrypted recording")
+	}
+	if v := hdr[len(encMagic)]; v != encVersion {
+		return nil, fmt.Errorf("localsink: unsupported encrypted recording version %d", v)
+	}
+	var ephPub [32]byte
+	copy(ephPub[:], hdr[len(encMagic)+1:])
+	d := &decryptReader{r: r}
+	box.Precompute(&d.shared, &ephPub, &priv.k)
+	return d, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.readFrame(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+func (d *decryptReader) readFrame() error {
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(d.r, lenBuf[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return errTruncated
+		}
+		return err
+	}
+	n := binary.BigEndian.Uint32(lenBuf[:])
+	if n < box.Overhead+1 || n > box.Overhead+1+maxEncFrameData {
+		return fmt.Errorf("localsink: invalid encrypted frame size %d", n)
+	}
+	sealed := make([]byte, n)
+	if _, err := io.ReadFull(d.r, sealed); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return errTruncated
+		}
+		return err
+	}
+	plain, ok := box.OpenAfterPrecomputation(nil, sealed, frameNonce(d.seq), &d.shared)
+	if !ok {
+		return errors.New("localsink: cannot decrypt recording; wrong key or corrupt data")
+	}
+	d.seq++
+	d.done = plain[0] == 1
+	d.plain = plain[1:]
+	return nil
+}
@@ -0,0 +1,274 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package localsink implements a [sessionrecording.Sink] that stores
+// session recordings in a local directory, with retention limits and
+// optional compression and encryption.
+//
+// Each recording is written to its own file named
+// "<prefix>-<start-unix-nanos>-<random>.cast", followed by ".zst" if it is
+// compressed and ".enc" if it is encrypted. Compression is applied before
+// encryption. Use [Open] to read a recording back.
+package localsink
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/sessionrecording"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/set"
+)
+
+const (
+	castSuffix       = ".cast"
+	compressedSuffix = ".zst"
+	encryptedSuffix  = ".enc"
+)
+
+// Options configures a [Sink].
+type Options struct {
+	// Dir is the directory to store recordings in.
+	// It is created if it doesn't exist. It is required.
+	Dir string
+
+	// Prefix is the file name prefix of recordings.
+	// If empty, "ssh-session" is used.
+	Prefix string
+
+	// MaxAge, if non-zero, is how long recordings are kept.
+	// Older recordings are deleted when a new recording starts.
+	MaxAge time.Duration
+
+	// MaxTotalSize, if positive, is the maximum total size in bytes of
+	// completed recordings in Dir. The oldest recordings are deleted when
+	// a new recording starts until the total is below this limit.
+	MaxTotalSize int64
+
+	// Compress specifies whether to compress recordings with zstd.
+	Compress bool
+
+	// Recipient, if non-nil, is the public key that recordings are
+	// encrypted to. Only the holder of the corresponding private key
+	// can read them.
+	Recipient *PublicKey
+
+	// Logf is the logger to use. If nil, logs are discarded.
+	Logf logger.Logf
+
+	// Now, if non-nil, returns the current time.
+	// It's used by tests.
+	Now func() time.Time
+}
+
+// Sink writes session recordings to a local directory.
+// It implements [sessionrecording.Sink].
+type Sink struct {
+	opts Options
+
+	mu     sync.Mutex
+	active set.Set[string] // base names of recordings still being written
+}
+
+var _ sessionrecording.Sink = (*Sink)(nil)
+
+// New returns a new Sink with the given options.
+func New(opts Options) (*Sink, error) {
+	if opts.Dir == "" {
+		return nil, errors.New("localsink: no directory specified")
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = "ssh-session"
+	}
+	if strings.ContainsAny(opts.Prefix, `/\`) {
+		return nil, fmt.Errorf("localsink: invalid prefix %q", opts.Prefix)
+	}
+	if opts.Logf == nil {
+		opts.Logf = logger.Discard
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	if err := os.MkdirAll(opts.Dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Sink{opts: opts}, nil
+}
+
+// suffix returns the file name suffix of new recordings.
+func (s *Sink) suffix() string {
+	suffix := castSuffix
+	if s.opts.Compress {
+		suffix += compressedSuffix
+	}
+	if s.opts.Recipient != nil {
+		suffix += encryptedSuffix
+	}
+	return suffix
+}
+
+// NewRecording implements [sessionrecording.Sink].
+func (s *Sink) NewRecording(start time.Time) (io.WriteCloser, error) {
+	s.prune()
+
+	f, err := os.CreateTemp(s.opts.Dir, fmt.Sprintf("%s-%d-*%s", s.opts.Prefix, start.UnixNano(), s.suffix()))
+	if err != nil {
+		return nil, err
+	}
+	name := filepath.Base(f.Name())
+	s.mu.Lock()
+	s.active.Make()
+	s.active.Add(name)
+	s.mu.Unlock()
+
+	rw := &recordingWriter{w: f, closers: []io.Closer{f}, done: func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.active.Delete(name)
+	}}
+	if s.opts.Recipient != nil {
+		ew, err := newEncryptWriter(rw.w, *s.opts.Recipient)
+		if err != nil {
+			rw.Close()
+			return nil, err
+		}
+		rw.w = ew
+		rw.closers = append(rw.closers, ew)
+	}
+	if s.opts.Compress {
+		zw := newCompressWriter(rw.w, s.opts.Now)
+		rw.w = zw
+		rw.closers = append(rw.closers, zw)
+	}
+	return rw, nil
+}
+
+// recordingWriter is the writer for a single recording
+// made of a stack of writers.
+type recordingWriter struct {
+	w       io.Writer
+	closers []io.Closer // closed in reverse order
+	done    func()
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	return w.w.Write(p)
+}
+
+func (w *recordingWriter) Close() error {
+	w.closeOnce.Do(func() {
+		var errs []error
+		for _, c := range slices.Backward(w.closers) {
+			errs = append(errs, c.Close())
+		}
+		w.done()
+		w.closeErr = errors.Join(errs...)
+	})
+	return w.closeErr
+}
+
+// recordingFile is a completed recording in a Sink's directory.
+type recordingFile struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+// prune deletes the recordings that exceed the configured retention limits.
+func (s *Sink) prune() {
+	if s.opts.MaxAge <= 0 && s.opts.MaxTotalSize <= 0 {
+		return
+	}
+	files, err := s.list()
+	if err != nil {
+		s.opts.Logf("localsink: listing recordings: %v", err)
+		return
+	}
+	// Oldest first.
+	slices.SortFunc(files, func(a, b recordingFile) int {
+		return cmp.Or(a.modTime.Compare(b.modTime), cmp.Compare(a.name, b.name))
+	})
+	var total int64
+	for _, f := range files {
+		total += f.size
+	}
+	now := s.opts.Now()
+	for _, f := range files {
+		expired := s.opts.MaxAge > 0 && now.Sub(f.modTime) > s.opts.MaxAge
+		overSize := s.opts.MaxTotalSize > 0 && total > s.opts.MaxTotalSize
+		if !expired && !overSize {
+			break
+		}
+		if err := os.Remove(filepath.Join(s.opts.Dir, f.name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			s.opts.Logf("localsink: deleting old recording: %v", err)
+			continue
+		}
+		total -= f.size
+	}
+}
+
+// list returns the completed recordings in s's directory.
+func (s *Sink) list() ([]recordingFile, error) {
+	des, err := os.ReadDir(s.opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var files []recordingFile
+	for _, de := range des {
+		name := de.Name()
+		if !de.Type().IsRegular() || !strings.HasPrefix(name, s.opts.Prefix+"-") || !isRecordingName(name) || s.active.Contains(name) {
+			continue
+		}
+		fi, err := de.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, recordingFile{name: name, size: fi.Size(), modTime: fi.ModTime()})
+	}
+	return files, nil
+}
+
+// isRecordingName reports whether name looks like the name of
+// a recording written by a Sink.
+func isRecordingName(name string) bool {
+	name = strings.TrimSuffix(name, encryptedSuffix)
+	name = strings.TrimSuffix(name, compressedSuffix)
+	return strings.HasSuffix(name, castSuffix)
+}
+
+// Open returns the asciinema cast of the recording read from r,
+// undoing any compression and encryption based on the recording's
+// file name. The priv key is required for encrypted recordings.
+func Open(r io.Reader, name string, priv *PrivateKey) (io.Reader, error) {
+	if !isRecordingName(name) {
+		return nil, fmt.Errorf("localsink: %q is not a recording", name)
+	}
+	if rest, ok := strings.CutSuffix(name, encryptedSuffix); ok {
+		if priv == nil {
+			return nil, fmt.Errorf("localsink: %q is encrypted and no private key was given", name)
+		}
+		dr, err := newDecryptReader(r, *priv)
+		if err != nil {
+			return nil, err
+		}
+		r, name = dr, rest
+	}
+	if strings.HasSuffix(name, compressedSuffix) {
+		r = newDecompressReader(r)
+	}
+	return r, nil
+}
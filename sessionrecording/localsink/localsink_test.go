@@ -0,0 +1,180 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package localsink
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRoundTrip(t *testing.T) {
+	priv := NewPrivateKey()
+	pub := priv.Public()
+	cast := `{"version":2,"width":80,"height":24}` + "\n" + strings.Repeat(`[0.5,"o","hello world\r\n"]`+"\n", 10000)
+
+	for _, compress := range []bool{false, true} {
+		for _, encrypt := range []bool{false, true} {
+			t.Run(fmt.Sprintf("compress=%v/encrypt=%v", compress, encrypt), func(t *testing.T) {
+				opts := Options{Dir: t.TempDir(), Compress: compress}
+				if encrypt {
+					opts.Recipient = &pub
+				}
+				s, err := New(opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				w, err := s.NewRecording(time.Now())
+				if err != nil {
+					t.Fatal(err)
+				}
+				// Write in small pieces, like a session does.
+				for line := range strings.Lines(cast) {
+					if _, err := io.WriteString(w, line); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if err := w.Close(); err != nil {
+					t.Fatal(err)
+				}
+
+				files, err := s.list()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(files) != 1 {
+					t.Fatalf("got %d recordings; want 1", len(files))
+				}
+				name := files[0].name
+				if got, want := strings.HasSuffix(name, ".zst") || strings.HasSuffix(name, ".zst.enc"), compress; got != want {
+					t.Errorf("name %q compressed = %v; want %v", name, got, want)
+				}
+				raw, err := os.ReadFile(filepath.Join(opts.Dir, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := bytes.Contains(raw, []byte("hello world")); got == encrypt {
+					t.Errorf("plaintext visible in file = %v", got)
+				}
+				if compress && len(raw) > len(cast)/10 {
+					t.Errorf("compressed recording is %d bytes; want at most %d", len(raw), len(cast)/10)
+				}
+
+				r, err := Open(bytes.NewReader(raw), name, &priv)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := io.ReadAll(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != cast {
+					t.Errorf("round trip mismatch: got %d bytes; want %d", len(got), len(cast))
+				}
+
+				if encrypt {
+					other := NewPrivateKey()
+					r, err := Open(bytes.NewReader(raw), name, &other)
+					if err == nil {
+						_, err = io.ReadAll(r)
+					}
+					if err == nil {
+						t.Error("decrypting with wrong key succeeded")
+					}
+					r, err = Open(bytes.NewReader(raw[:len(raw)-10]), name, &priv)
+					if err == nil {
+						_, err = io.ReadAll(r)
+					}
+					if !errors.Is(err, io.ErrUnexpectedEOF) {
+						t.Errorf("truncated recording: got %v; want ErrUnexpectedEOF", err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestKeyStrings(t *testing.T) {
+	priv := NewPrivateKey()
+	priv2, err := ParsePrivateKey(priv.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if priv2 != priv {
+		t.Error("private key round trip mismatch")
+	}
+	pub, err := ParsePublicKey(priv.Public().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pub != priv.Public() {
+		t.Error("public key round trip mismatch")
+	}
+	if _, err := ParsePublicKey(priv.String()); err == nil {
+		t.Error("parsed private key as public key")
+	}
+	if _, err := ParsePublicKey("recpub:abcd"); err == nil {
+		t.Error("parsed short key")
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Unix(1700000000, 0)
+	s, err := New(Options{
+		Dir:          dir,
+		MaxAge:       24 * time.Hour,
+		MaxTotalSize: 250,
+		Now:          func() time.Time { return now },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeOld := func(name string, size int, age time.Duration) {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, bytes.Repeat([]byte("x"), size), 0600); err != nil {
+			t.Fatal(err)
+		}
+		mtime := now.Add(-age)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeOld("ssh-session-1-a.cast", 100, 48*time.Hour)    // expired
+	writeOld("ssh-session-2-b.cast.zst", 100, 3*time.Hour) // deleted for size
+	writeOld("ssh-session-3-c.cast", 100, 2*time.Hour)
+	writeOld("ssh-session-4-d.cast.enc", 100, 1*time.Hour)
+	writeOld("unrelated.txt", 1000, 100*time.Hour)
+
+	// An active recording is never deleted.
+	w, err := s.NewRecording(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, strings.Repeat("y", 1000))
+	s.prune()
+
+	var names []string
+	des, _ := os.ReadDir(dir)
+	for _, de := range des {
+		names = append(names, de.Name())
+	}
+	want := []string{
+		filepath.Base(w.(*recordingWriter).closers[0].(*os.File).Name()),
+		"ssh-session-3-c.cast",
+		"ssh-session-4-d.cast.enc",
+		"unrelated.txt",
+	}
+	if !slices.Equal(names, want) {
+		t.Errorf("after prune: %q; want %q", names, want)
+	}
+	w.Close()
+}
@@ -0,0 +1,21 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package sessionrecording
+
+import (
+	"io"
+	"time"
+)
+
+// Sink is a destination for session recordings other than a remote
+// recorder, such as a local directory.
+//
+// See tailscale.com/sessionrecording/localsink for an implementation.
+type Sink interface {
+	// NewRecording starts a new recording of a session that started at
+	// the given time. The caller writes the asciinema cast (a CastHeader
+	// line followed by event lines) to the returned writer and closes it
+	// when the session ends.
+	NewRecording(start time.Time) (io.WriteCloser, error)
+}
@@ -33,10 +33,12 @@ import (
 	gossh "golang.org/x/crypto/ssh"
 	"tailscale.com/envknob"
 	"tailscale.com/feature"
+	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnlocal"
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/net/tsdial"
 	"tailscale.com/sessionrecording"
+	"tailscale.com/sessionrecording/localsink"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tempfork/gliderlabs/ssh"
 	"tailscale.com/types/key"
@@ -88,6 +90,10 @@ type server struct {
 	logf           logger.Logf
 	tailscaledPath string
 
+	// localSink, if non-nil, is where sessions are recorded when the SSH
+	// policy names no recorders or none can be reached.
+	localSink sessionrecording.Sink
+
 	timeNow func() time.Time // or nil for time.Now
 
 	sessionWaitGroup sync.WaitGroup
@@ -121,6 +127,12 @@ func init() {
 				return lb.ControlNow(time.Now())
 			},
 		}
+		if conf := lb.Sys().InitialConfig; conf != nil && conf.Parsed.SSHRecording != nil {
+			srv.localSink, err = newLocalSink(logf, conf.Parsed.SSHRecording)
+			if err != nil {
+				return nil, fmt.Errorf("SSH session recording: %w", err)
+			}
+		}
 
 		return srv, nil
 	})
@@ -1099,7 +1111,44 @@ func (ss *sshSession) recorders() ([]netip.AddrPort, *tailcfg.SSHRecorderFailure
 
 func (ss *sshSession) shouldRecord() bool {
 	recs, _ := ss.recorders()
-	return len(recs) > 0 || recordSSHToLocalDisk()
+	return len(recs) > 0 || ss.localRecordingSink() != nil
+}
+
+// localRecordingSink returns the sink to record the session to if there
+// are no recorders configured or reachable, or nil if there is none.
+func (ss *sshSession) localRecordingSink() sessionrecording.Sink {
+	if sink := ss.conn.srv.localSink; sink != nil {
+		return sink
+	}
+	if recordSSHToLocalDisk() {
+		return sinkFunc(ss.openFileForRecording)
+	}
+	return nil
+}
+
+// sinkFunc is a func that implements [sessionrecording.Sink].
+type sinkFunc func(start time.Time) (io.WriteCloser, error)
+
+func (f sinkFunc) NewRecording(start time.Time) (io.WriteCloser, error) { return f(start) }
+
+// newLocalSink returns the sink for recording sessions to local storage
+// as configured in conf.
+func newLocalSink(logf logger.Logf, conf *ipn.SSHRecordingConfig) (sessionrecording.Sink, error) {
+	opts := localsink.Options{
+		Dir:          conf.Dir,
+		MaxAge:       conf.MaxAge.Duration,
+		MaxTotalSize: conf.MaxTotalSize,
+		Compress:     conf.Compress,
+		Logf:         logf,
+	}
+	if conf.Recipient != "" {
+		pub, err := localsink.ParsePublicKey(conf.Recipient)
+		if err != nil {
+			return nil, err
+		}
+		opts.Recipient = &pub
+	}
+	return localsink.New(opts)
 }
 
 type sshConnInfo struct {
@@ -1271,13 +1320,9 @@ func (ss *sshSession) startNewRecording() (_ *recording, err error) {
 	}
 
 	recorders, onFailure := ss.recorders()
-	var localRecording bool
-	if len(recorders) == 0 {
-		if recordSSHToLocalDisk() {
-			localRecording = true
-		} else {
-			return nil, errors.New("no recorders configured")
-		}
+	localSink := ss.localRecordingSink()
+	if len(recorders) == 0 && localSink == nil {
+		return nil, errors.New("no recorders configured")
 	}
 
 	var w ssh.Window
@@ -1301,34 +1346,52 @@ func (ss *sshSession) startNewRecording() (_ *recording, err error) {
 	// ss.ctx is closed when the session closes, but we don't want to break the upload at that time.
 	// Instead we want to wait for the session to close the writer when it finishes.
 	ctx := context.Background()
-	if localRecording {
-		rec.out, err = ss.openFileForRecording(now)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		var errChan <-chan error
-		var attempts []*tailcfg.SSHRecordingAttempt
+	var errChan <-chan error
+	var attempts []*tailcfg.SSHRecordingAttempt
+	if len(recorders) > 0 {
 		rec.out, attempts, errChan, err = sessionrecording.ConnectToRecorder(ctx, recorders, ss.conn.srv.lb.Dialer().UserDial)
-		if err != nil {
-			if onFailure != nil && onFailure.NotifyURL != "" && len(attempts) > 0 {
-				eventType := tailcfg.SSHSessionRecordingFailed
-				if onFailure.RejectSessionWithMessage != "" {
-					eventType = tailcfg.SSHSessionRecordingRejected
-				}
-				ss.notifyControl(ctx, nodeKey, eventType, attempts, onFailure.NotifyURL)
+	}
+	if err != nil {
+		// The policy's failure action applies even when there's a
+		// local sink to fall back to.
+		if onFailure != nil && onFailure.NotifyURL != "" && len(attempts) > 0 {
+			eventType := tailcfg.SSHSessionRecordingFailed
+			if onFailure.RejectSessionWithMessage != "" {
+				eventType = tailcfg.SSHSessionRecordingRejected
 			}
-
+			ss.notifyControl(ctx, nodeKey, eventType, attempts, onFailure.NotifyURL)
+		}
+		if onFailure != nil && onFailure.RejectSessionWithMessage != "" {
+			ss.logf("recording: error starting recording (rejecting session): %v", err)
+			return nil, userVisibleError{
+				error: err,
+				msg:   onFailure.RejectSessionWithMessage,
+			}
+		}
+		if localSink == nil {
+			ss.logf("recording: error starting recording (failing open): %v", err)
+			return nil, nil
+		}
+		ss.logf("recording: error starting recording (recording locally instead): %v", err)
+	}
+	if rec.out == nil {
+		out, err := localSink.NewRecording(now)
+		if err != nil {
 			if onFailure != nil && onFailure.RejectSessionWithMessage != "" {
-				ss.logf("recording: error starting recording (rejecting session): %v", err)
+				ss.logf("recording: error starting local recording (rejecting session): %v", err)
 				return nil, userVisibleError{
 					error: err,
 					msg:   onFailure.RejectSessionWithMessage,
 				}
 			}
-			ss.logf("recording: error starting recording (failing open): %v", err)
+			ss.logf("recording: error starting local recording (failing open): %v", err)
 			return nil, nil
 		}
+		lw := &localRecordingWriter{WriteCloser: out}
+		lw.onFailure = func(err error) { ss.recordingFailed(ctx, nodeKey, onFailure, nil, err) }
+		rec.out = lw
+	}
+	if errChan != nil {
 		go func() {
 			err := <-errChan
 			if err == nil {
@@ -1341,26 +1404,7 @@ func (ss *sshSession) startNewRecording() (_ *recording, err error) {
 					err = errors.New("recording upload ended before the SSH session")
 				}
 			}
-			if onFailure != nil && onFailure.NotifyURL != "" && len(attempts) > 0 {
-				lastAttempt := attempts[len(attempts)-1]
-				lastAttempt.FailureMessage = err.Error()
-
-				eventType := tailcfg.SSHSessionRecordingFailed
-				if onFailure.TerminateSessionWithMessage != "" {
-					eventType = tailcfg.SSHSessionRecordingTerminated
-				}
-
-				ss.notifyControl(ctx, nodeKey, eventType, attempts, onFailure.NotifyURL)
-			}
-			if onFailure != nil && onFailure.TerminateSessionWithMessage != "" {
-				ss.logf("recording: error uploading recording (closing session): %v", err)
-				ss.cancelCtx(userVisibleError{
-					error: err,
-					msg:   onFailure.TerminateSessionWithMessage,
-				})
-				return
-			}
-			ss.logf("recording: error uploading recording (failing open): %v", err)
+			ss.recordingFailed(ctx, nodeKey, onFailure, attempts, err)
 		}()
 	}
 
@@ -1410,6 +1454,31 @@ func (ss *sshSession) startNewRecording() (_ *recording, err error) {
 	return rec, nil
 }
 
+// recordingFailed handles a recording that failed after it started,
+// according to the policy's onFailure action.
+func (ss *sshSession) recordingFailed(ctx context.Context, nodeKey key.NodePublic, onFailure *tailcfg.SSHRecorderFailureAction, attempts []*tailcfg.SSHRecordingAttempt, err error) {
+	if onFailure != nil && onFailure.NotifyURL != "" && len(attempts) > 0 {
+		lastAttempt := attempts[len(attempts)-1]
+		lastAttempt.FailureMessage = err.Error()
+
+		eventType := tailcfg.SSHSessionRecordingFailed
+		if onFailure.TerminateSessionWithMessage != "" {
+			eventType = tailcfg.SSHSessionRecordingTerminated
+		}
+
+		ss.notifyControl(ctx, nodeKey, eventType, attempts, onFailure.NotifyURL)
+	}
+	if onFailure != nil && onFailure.TerminateSessionWithMessage != "" {
+		ss.logf("recording: error uploading recording (closing session): %v", err)
+		ss.cancelCtx(userVisibleError{
+			error: err,
+			msg:   onFailure.TerminateSessionWithMessage,
+		})
+		return
+	}
+	ss.logf("recording: error uploading recording (failing open): %v", err)
+}
+
 // notifyControl sends a SSHEventNotifyRequest to control over noise.
 // A SSHEventNotifyRequest is sent when an action or state reached during
 // an SSH session is a defined EventType.
@@ -1449,6 +1518,23 @@ func (ss *sshSession) notifyControl(ctx context.Context, nodeKey key.NodePublic,
 	}
 }
 
+// localRecordingWriter is the writer for a recording to a local sink.
+// Unlike uploads to a recorder, local writes fail synchronously, so it
+// reports the first write error to onFailure.
+type localRecordingWriter struct {
+	io.WriteCloser
+	onFailure func(error)
+	failed    atomic.Bool
+}
+
+func (w *localRecordingWriter) Write(p []byte) (int, error) {
+	n, err := w.WriteCloser.Write(p)
+	if err != nil && !w.failed.Swap(true) {
+		w.onFailure(err)
+	}
+	return n, err
+}
+
 // recording is the state for an SSH session recording.
 type recording struct {
 	ss    *sshSession
@@ -22,6 +22,7 @@ import (
 	"os"
 	"os/exec"
 	"os/user"
+	"path/filepath"
 	"reflect"
 	"runtime"
 	"slices"
@@ -36,11 +37,13 @@ import (
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/h2c"
 	"tailscale.com/cmd/testwrapper/flakytest"
+	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnlocal"
 	"tailscale.com/ipn/store/mem"
 	"tailscale.com/net/memnet"
 	"tailscale.com/net/tsdial"
 	"tailscale.com/sessionrecording"
+	"tailscale.com/sessionrecording/localsink"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tempfork/gliderlabs/ssh"
 	testssh "tailscale.com/tempfork/sshtest/ssh"
@@ -771,6 +774,153 @@ func TestSSHRecordingNonInteractive(t *testing.T) {
 	}
 }
 
+// TestSSHRecordingLocalSink tests that sessions are recorded to the local
+// sink when the policy names no recorders or none can be reached, unless
+// the policy rejects sessions that can't be recorded.
+func TestSSHRecordingLocalSink(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skipf("skipping on %q; only runs on linux and darwin", runtime.GOOS)
+	}
+	badRecorder, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	badRecorderAddr := netip.MustParseAddrPort(badRecorder.Addr().String())
+	badRecorder.Close()
+
+	failOpen := &tailcfg.SSHRecorderFailureAction{
+		TerminateSessionWithMessage: "session terminated",
+	}
+	reject := &tailcfg.SSHRecorderFailureAction{
+		RejectSessionWithMessage:    "session rejected",
+		TerminateSessionWithMessage: "session terminated",
+	}
+	tests := []struct {
+		name         string
+		recorders    []netip.AddrPort
+		onFailure    *tailcfg.SSHRecorderFailureAction
+		wantRejected bool
+	}{
+		{"no-recorders", nil, reject, false},
+		{"unreachable-recorder", []netip.AddrPort{badRecorderAddr}, failOpen, false},
+		{"unreachable-recorder-reject", []netip.AddrPort{badRecorderAddr}, reject, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			priv := localsink.NewPrivateKey()
+			dir := t.TempDir()
+			sink, err := newLocalSink(t.Logf, &ipn.SSHRecordingConfig{
+				Dir:       dir,
+				Compress:  true,
+				Recipient: priv.Public().String(),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := &server{
+				logf:      tstest.WhileTestRunningLogger(t),
+				localSink: sink,
+				lb: &localState{
+					sshEnabled: true,
+					varRoot:    t.TempDir(),
+					matchingRule: newSSHRule(
+						&tailcfg.SSHAction{
+							Accept:             true,
+							Recorders:          tt.recorders,
+							OnRecordingFailure: tt.onFailure,
+						},
+					),
+				},
+			}
+			defer s.Shutdown()
+
+			src, dst := must.Get(netip.ParseAddrPort("100.100.100.101:2231")), must.Get(netip.ParseAddrPort("100.100.100.102:22"))
+			sc, dc := memnet.NewTCPConn(src, dst, 1024)
+
+			const sshUser = "alice"
+			cfg := &testssh.ClientConfig{
+				User:            sshUser,
+				HostKeyCallback: testssh.InsecureIgnoreHostKey(),
+			}
+
+			var wg sync.WaitGroup
+			wg.Go(func() {
+				c, chans, reqs, err := testssh.NewClientConn(sc, sc.RemoteAddr().String(), cfg)
+				if err != nil {
+					t.Errorf("client: %v", err)
+					return
+				}
+				client := testssh.NewClient(c, chans, reqs)
+				defer client.Close()
+				session, err := client.NewSession()
+				if err != nil {
+					t.Errorf("client: %v", err)
+					return
+				}
+				defer session.Close()
+				out, err := session.CombinedOutput("echo Ran echo!")
+				if tt.wantRejected {
+					if err == nil || string(out) != "session rejected\r\n" {
+						t.Errorf("client: got %q, %v; want session rejected", out, err)
+					}
+					return
+				}
+				if err != nil {
+					t.Errorf("client: %v", err)
+				}
+				if string(out) != "Ran echo!\n" {
+					t.Errorf("client: unexpected output: %q", out)
+				}
+			})
+			if err := s.HandleSSHConn(dc); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			wg.Wait()
+
+			if tt.wantRejected {
+				if des, err := os.ReadDir(dir); err != nil || len(des) != 0 {
+					t.Errorf("rejected session was recorded locally: %v, %v", des, err)
+				}
+				return
+			}
+
+			var recording []byte
+			if err := tstest.WaitFor(5*time.Second, func() error {
+				des, err := os.ReadDir(dir)
+				if err != nil {
+					return err
+				}
+				if len(des) != 1 {
+					return fmt.Errorf("got %d recordings; want 1", len(des))
+				}
+				f, err := os.Open(filepath.Join(dir, des[0].Name()))
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				r, err := localsink.Open(f, des[0].Name(), &priv)
+				if err != nil {
+					return err
+				}
+				recording, err = io.ReadAll(r)
+				return err
+			}); err != nil {
+				t.Fatal(err)
+			}
+			var ch sessionrecording.CastHeader
+			if err := json.NewDecoder(bytes.NewReader(recording)).Decode(&ch); err != nil {
+				t.Fatal(err)
+			}
+			if ch.SSHUser != sshUser || ch.Command != "echo Ran echo!" {
+				t.Errorf("CastHeader = %+v", ch)
+			}
+			if !bytes.Contains(recording, []byte("Ran echo!")) {
+				t.Errorf("recording does not contain session output: %q", recording)
+			}
+		})
+	}
+}
+
 func TestSSHAuthFlow(t *testing.T) {
 	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
 		t.Skipf("skipping on %q; only runs on linux and darwin", runtime.GOOS)
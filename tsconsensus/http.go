@@ -4,6 +4,7 @@
 package tsconsensus
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -12,8 +13,10 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"slices"
 	"time"
 
+	"github.com/hashicorp/raft"
 	"tailscale.com/util/httpm"
 )
 
@@ -65,9 +68,17 @@ func (rac *commandClient) join(host string, jr joinRequest) error {
 }
 
 func (rac *commandClient) executeCommand(host string, bs []byte) (CommandResult, error) {
+	return rac.postForCommandResult(host, "/executeCommand", bs)
+}
+
+func (rac *commandClient) executeQuery(host string, bs []byte) (CommandResult, error) {
+	return rac.postForCommandResult(host, "/executeQuery", bs)
+}
+
+func (rac *commandClient) postForCommandResult(host, path string, bs []byte) (CommandResult, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
-	url := rac.url(host, "/executeCommand")
+	url := rac.url(host, path)
 	req, err := http.NewRequestWithContext(ctx, httpm.POST, url, bytes.NewReader(bs))
 	if err != nil {
 		return CommandResult{}, err
@@ -91,6 +102,60 @@ func (rac *commandClient) executeCommand(host string, bs []byte) (CommandResult,
 	return cr, nil
 }
 
+func (rac *commandClient) restore(host string, backup io.Reader) error {
+	ctx, cancel := context.WithTimeout(context.Background(), restoreTimeout)
+	defer cancel()
+	url := rac.url(host, "/restore")
+	req, err := http.NewRequestWithContext(ctx, httpm.POST, url, backup)
+	if err != nil {
+		return err
+	}
+	resp, err := rac.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		respBs, err := readAllMaxBytes(resp.Body)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("remote responded %d: %s", resp.StatusCode, string(respBs))
+	}
+	return nil
+}
+
+func (rac *commandClient) changeMembership(host string, mr membershipRequest) (uint64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	rBs, err := json.Marshal(mr)
+	if err != nil {
+		return 0, err
+	}
+	url := rac.url(host, "/membership")
+	req, err := http.NewRequestWithContext(ctx, httpm.POST, url, bytes.NewReader(rBs))
+	if err != nil {
+		return 0, err
+	}
+	resp, err := rac.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	respBs, err := readAllMaxBytes(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != 200 {
+		return 0, fmt.Errorf("remote responded %d: %s", resp.StatusCode, string(respBs))
+	}
+	var res membershipResult
+	if err = json.Unmarshal(respBs, &res); err != nil {
+		return 0, err
+	}
+	return res.Index, nil
+}
+
 type authedHandler struct {
 	auth    *authorization
 	handler http.Handler
@@ -167,10 +232,123 @@ func (c *Consensus) handleExecuteCommandHTTP(w http.ResponseWriter, r *http.Requ
 	}
 }
 
+func (c *Consensus) handleExecuteQueryHTTP(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var qr queryRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes)).Decode(&qr); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Don't forward again, the node that sent this thought we were leader.
+	result, err := c.executeQueryLocally(qr.Command, qr.Lease)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Printf("error encoding execute query result: %v", err)
+		return
+	}
+}
+
+func (c *Consensus) handleMembershipHTTP(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var mr membershipRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes)).Decode(&mr); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Don't forward again, the node that sent this thought we were leader.
+	idx, err := c.changeMembershipLocally(mr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(membershipResult{Index: idx}); err != nil {
+		log.Printf("error encoding membership result: %v", err)
+		return
+	}
+}
+
+func (c *Consensus) handleSnapshotHTTP(w http.ResponseWriter, r *http.Request) {
+	meta, err := c.TakeSnapshot()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(meta); err != nil {
+		log.Printf("error encoding snapshot meta: %v", err)
+		return
+	}
+}
+
+func (c *Consensus) handleListSnapshotsHTTP(w http.ResponseWriter, r *http.Request) {
+	metas, err := c.ListSnapshots()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(metas); err != nil {
+		log.Printf("error encoding snapshot list: %v", err)
+		return
+	}
+}
+
+func (c *Consensus) handleGetSnapshotHTTP(w http.ResponseWriter, r *http.Request) {
+	c.serveBackup(w, r.PathValue("id"))
+}
+
+func (c *Consensus) handleRestoreHTTP(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	br := bufio.NewReader(r.Body)
+	meta, err := readBackupHeader(br)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Don't forward again, the node that sent this thought we were leader.
+	err = c.restoreLocally(meta, br)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// serveBackup responds with the backup of the snapshot with the given id from
+// this node's snapshot store.
+//
+// Only snapshots still in the store can be served. The store keeps just the
+// most recent snapshots (two on disk, or one in memory), and taking a snapshot
+// or restoring a backup creates a new one that may evict older ones, so a
+// backup that must be kept has to be fetched and stored elsewhere first.
+func (c *Consensus) serveBackup(w http.ResponseWriter, id string) {
+	metas, err := c.ListSnapshots()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !slices.ContainsFunc(metas, func(m *raft.SnapshotMeta) bool { return m.ID == id }) {
+		http.Error(w, "snapshot not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if err := c.WriteBackup(w, id); err != nil {
+		// Too late to change the status; the client sees a short body.
+		log.Printf("error writing backup of snapshot %s: %v", id, err)
+		return
+	}
+}
+
 func (c *Consensus) makeCommandMux() *http.ServeMux {
 	mux := http.NewServeMux()
 	mux.HandleFunc("POST /join", c.handleJoinHTTP)
 	mux.HandleFunc("POST /executeCommand", c.handleExecuteCommandHTTP)
+	mux.HandleFunc("POST /executeQuery", c.handleExecuteQueryHTTP)
+	mux.HandleFunc("POST /membership", c.handleMembershipHTTP)
+	mux.HandleFunc("POST /snapshot", c.handleSnapshotHTTP)
+	mux.HandleFunc("GET /snapshots", c.handleListSnapshotsHTTP)
+	mux.HandleFunc("GET /snapshots/{id}", c.handleGetSnapshotHTTP)
+	mux.HandleFunc("POST /restore", c.handleRestoreHTTP)
 	return mux
 }
 
@@ -0,0 +1,149 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tsconsensus
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// membershipTimeout bounds how long a membership change may wait to be
+// scheduled by raft.
+const membershipTimeout = 10 * time.Second
+
+// leaderWaitTimeout bounds how long a membership change waits for this node
+// to learn who the leader is, for example just after an election or a
+// leadership transfer, and leaderWaitInterval is how often it checks.
+const (
+	leaderWaitTimeout  = 5 * time.Second
+	leaderWaitInterval = 50 * time.Millisecond
+)
+
+// membership operations, see membershipRequest.
+const (
+	opRemoveServer       = "removeServer"
+	opAddNonvoter        = "addNonvoter"
+	opTransferLeadership = "transferLeadership"
+)
+
+// A membershipRequest asks the leader to change the cluster membership.
+// It is forwarded to the leader over the command mux.
+type membershipRequest struct {
+	Op   string
+	ID   string
+	Host string // for opAddNonvoter
+}
+
+type membershipResult struct {
+	Index uint64
+}
+
+// RemoveServer removes the server with the given ID from the cluster
+// configuration, for example because it has died and won't be coming back.
+// If this node is not the leader the request is forwarded to the leader.
+// It returns the index of the configuration change.
+func (c *Consensus) RemoveServer(id raft.ServerID) (uint64, error) {
+	return c.changeMembership(membershipRequest{Op: opRemoveServer, ID: string(id)})
+}
+
+// AddNonvoter adds the node with the given ID and tailscale IP to the cluster
+// as a nonvoter: it receives the log and applies it to its state machine but
+// doesn't take part in elections or count towards quorum. A voter can be made
+// a nonvoter by first removing it. If this node is not the leader the request
+// is forwarded to the leader. It returns the index of the configuration change.
+func (c *Consensus) AddNonvoter(id raft.ServerID, host netip.Addr) (uint64, error) {
+	return c.changeMembership(membershipRequest{Op: opAddNonvoter, ID: string(id), Host: host.String()})
+}
+
+// TransferLeadership asks the leader to step down in favor of the voter with
+// the given ID, or, if id is empty, in favor of the most up to date voter.
+func (c *Consensus) TransferLeadership(id raft.ServerID) error {
+	_, err := c.changeMembership(membershipRequest{Op: opTransferLeadership, ID: string(id)})
+	return err
+}
+
+func (c *Consensus) changeMembership(req membershipRequest) (uint64, error) {
+	deadline := time.Now().Add(leaderWaitTimeout)
+	for {
+		idx, err := c.changeMembershipLocally(req)
+		var leErr lookElsewhereError
+		if errors.As(err, &leErr) {
+			return c.commandClient.changeMembership(leErr.where, req)
+		}
+		if errors.Is(err, errLeaderUnknown) && time.Now().Before(deadline) {
+			time.Sleep(leaderWaitInterval)
+			continue
+		}
+		return idx, err
+	}
+}
+
+func (c *Consensus) changeMembershipLocally(req membershipRequest) (uint64, error) {
+	var fut raft.Future
+	var idxFut raft.IndexFuture
+	switch req.Op {
+	case opRemoveServer:
+		if req.ID == "" {
+			return 0, errors.New("server ID required")
+		}
+		idxFut = c.raft.RemoveServer(raft.ServerID(req.ID), 0, membershipTimeout)
+		fut = idxFut
+	case opAddNonvoter:
+		if req.ID == "" {
+			return 0, errors.New("server ID required")
+		}
+		host, err := netip.ParseAddr(req.Host)
+		if err != nil {
+			return 0, fmt.Errorf("bad host: %w", err)
+		}
+		idxFut = c.raft.AddNonvoter(raft.ServerID(req.ID), raft.ServerAddress(c.raftAddr(host)), 0, membershipTimeout)
+		fut = idxFut
+	case opTransferLeadership:
+		if req.ID == "" {
+			fut = c.raft.LeadershipTransfer()
+			break
+		}
+		addr, err := c.serverAddress(raft.ServerID(req.ID))
+		if err != nil {
+			return 0, err
+		}
+		fut = c.raft.LeadershipTransferToServer(raft.ServerID(req.ID), addr)
+	default:
+		return 0, fmt.Errorf("unknown membership operation %q", req.Op)
+	}
+	err := fut.Error()
+	if errors.Is(err, raft.ErrNotLeader) {
+		leader, err := c.getLeader()
+		if err != nil {
+			return 0, err
+		}
+		return 0, lookElsewhereError{where: leader}
+	}
+	if err != nil {
+		return 0, err
+	}
+	if idxFut != nil {
+		return idxFut.Index(), nil
+	}
+	return 0, nil
+}
+
+// serverAddress returns the raft address of the server with the given ID in
+// the current cluster configuration.
+func (c *Consensus) serverAddress(id raft.ServerID) (raft.ServerAddress, error) {
+	cfg, err := c.GetClusterConfiguration()
+	if err != nil {
+		return "", err
+	}
+	for _, s := range cfg.Servers {
+		if s.ID == id {
+			return s.Address, nil
+		}
+	}
+	return "", fmt.Errorf("server %q is not in the cluster configuration", id)
+}
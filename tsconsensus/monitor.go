@@ -10,10 +10,14 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/netip"
 	"slices"
 
+	"github.com/hashicorp/raft"
+	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
 	"tailscale.com/tsnet"
 	"tailscale.com/util/dnsname"
 )
@@ -27,6 +31,25 @@ type monitor struct {
 	ts  *tsnet.Server
 	con *Consensus
 	sg  statusGetter
+
+	whois    whoIsGetter            // for authorizing the admin endpoints
+	adminCap tailcfg.PeerCapability // required for the admin endpoints
+}
+
+type whoIsGetter interface {
+	whoIs(ctx context.Context, remoteAddr string) (*apitype.WhoIsResponse, error)
+}
+
+type tailscaleWhoIsGetter struct {
+	ts *tsnet.Server
+}
+
+func (wg tailscaleWhoIsGetter) whoIs(ctx context.Context, remoteAddr string) (*apitype.WhoIsResponse, error) {
+	lc, err := wg.ts.LocalClient()
+	if err != nil {
+		return nil, err
+	}
+	return lc.WhoIs(ctx, remoteAddr)
 }
 
 func (m *monitor) getStatus(ctx context.Context) (status, error) {
@@ -42,15 +65,16 @@ func serveMonitor(c *Consensus, ts *tsnet.Server, listenAddr string) (*http.Serv
 	if err != nil {
 		return nil, err
 	}
-	m := &monitor{con: c, ts: ts, sg: &tailscaleStatusGetter{
-		ts: ts,
-	}}
-	mux := http.NewServeMux()
-	mux.HandleFunc("GET /full", m.handleFullStatus)
-	mux.HandleFunc("GET /{$}", m.handleSummaryStatus)
-	mux.HandleFunc("GET /netmap", m.handleNetmap)
-	mux.HandleFunc("POST /dial", m.handleDial)
-	srv := &http.Server{Handler: mux}
+	m := &monitor{
+		con: c,
+		ts:  ts,
+		sg: &tailscaleStatusGetter{
+			ts: ts,
+		},
+		whois:    tailscaleWhoIsGetter{ts: ts},
+		adminCap: c.config.AdminCapability,
+	}
+	srv := &http.Server{Handler: m.makeMux()}
 	go func() {
 		err := srv.Serve(ln)
 		log.Printf("MonitorHTTP stopped serving with error: %v", err)
@@ -58,6 +82,98 @@ func serveMonitor(c *Consensus, ts *tsnet.Server, listenAddr string) (*http.Serv
 	return srv, nil
 }
 
+func (m *monitor) makeMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /full", m.handleFullStatus)
+	mux.HandleFunc("GET /{$}", m.handleSummaryStatus)
+	mux.HandleFunc("GET /netmap", m.handleNetmap)
+	mux.HandleFunc("POST /dial", m.handleDial)
+	if m.adminCap != "" {
+		mux.Handle("DELETE /servers/{id}", m.adminOnly(m.handleRemoveServer))
+		mux.Handle("POST /nonvoters", m.adminOnly(m.handleAddNonvoter))
+		mux.Handle("POST /leadership-transfer", m.adminOnly(m.handleTransferLeadership))
+	}
+	return mux
+}
+
+// adminOnly wraps h so that it's only served to tailnet identities that hold
+// the admin capability.
+func (m *monitor) adminOnly(h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		who, err := m.whois.whoIs(r.Context(), r.RemoteAddr)
+		if err != nil {
+			log.Printf("monitor: error WhoIs %s: %v", r.RemoteAddr, err)
+			http.Error(w, "", http.StatusForbidden)
+			return
+		}
+		if !who.CapMap.HasCapability(m.adminCap) {
+			http.Error(w, "peer not allowed", http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	})
+}
+
+func (m *monitor) handleRemoveServer(w http.ResponseWriter, r *http.Request) {
+	idx, err := m.con.RemoveServer(raft.ServerID(r.PathValue("id")))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(membershipResult{Index: idx}); err != nil {
+		log.Printf("monitor: error encoding remove server result: %v", err)
+		return
+	}
+}
+
+func (m *monitor) handleAddNonvoter(w http.ResponseWriter, r *http.Request) {
+	var params struct {
+		ID   string
+		Host netip.Addr
+	}
+	defer r.Body.Close()
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes)).Decode(&params); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if params.ID == "" || !params.Host.IsValid() {
+		http.Error(w, "Required: ID and Host", http.StatusBadRequest)
+		return
+	}
+	idx, err := m.con.AddNonvoter(raft.ServerID(params.ID), params.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(membershipResult{Index: idx}); err != nil {
+		log.Printf("monitor: error encoding add nonvoter result: %v", err)
+		return
+	}
+}
+
+func (m *monitor) handleTransferLeadership(w http.ResponseWriter, r *http.Request) {
+	var params struct {
+		ID string // optional
+	}
+	defer r.Body.Close()
+	bs, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(bs) > 0 {
+		if err := json.Unmarshal(bs, &params); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if err := m.con.TransferLeadership(raft.ServerID(params.ID)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
+
 func (m *monitor) handleFullStatus(w http.ResponseWriter, r *http.Request) {
 	s, err := m.getStatus(r.Context())
 	if err != nil {
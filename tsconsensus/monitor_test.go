@@ -0,0 +1,67 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tsconsensus
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+)
+
+type testWhoIsGetter map[string]tailcfg.PeerCapMap
+
+func (wg testWhoIsGetter) whoIs(ctx context.Context, remoteAddr string) (*apitype.WhoIsResponse, error) {
+	capMap, ok := wg[remoteAddr]
+	if !ok {
+		return nil, errors.New("no such peer")
+	}
+	return &apitype.WhoIsResponse{CapMap: capMap}, nil
+}
+
+const testAdminCap tailcfg.PeerCapability = "example.com/cap/consensus-admin"
+
+func TestMonitorAdminOnly(t *testing.T) {
+	m := &monitor{
+		whois: testWhoIsGetter{
+			"100.64.0.1:1234": {testAdminCap: nil},
+			"100.64.0.2:1234": {"example.com/cap/other": nil},
+		},
+		adminCap: testAdminCap,
+	}
+	h := m.adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	for _, tt := range []struct {
+		remoteAddr string
+		want       int
+	}{
+		{"100.64.0.1:1234", http.StatusOK},
+		{"100.64.0.2:1234", http.StatusForbidden},
+		{"100.64.0.3:1234", http.StatusForbidden},
+	} {
+		r := httptest.NewRequest("POST", "/leadership-transfer", nil)
+		r.RemoteAddr = tt.remoteAddr
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s: got status %d, want %d", tt.remoteAddr, w.Code, tt.want)
+		}
+	}
+}
+
+func TestMonitorAdminEndpointsNeedCapability(t *testing.T) {
+	m := &monitor{whois: testWhoIsGetter{"100.64.0.1:1234": {testAdminCap: nil}}}
+	r := httptest.NewRequest("DELETE", "/servers/100.64.0.9", nil)
+	r.RemoteAddr = "100.64.0.1:1234"
+	w := httptest.NewRecorder()
+	m.makeMux().ServeHTTP(w, r)
+	if w.Code == http.StatusOK {
+		t.Errorf("admin endpoint served without an AdminCapability configured")
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tsconsensus
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/hashicorp/raft"
+)
+
+// A Querier is a raft.FSM that can also answer read-only Commands from its
+// current state, without them being appended to the raft log. See
+// Consensus.ExecuteQuery.
+//
+// Query must not change the state, and may be called concurrently with
+// Apply and with other calls to Query.
+type Querier interface {
+	Query(Command) CommandResult
+}
+
+// QueryOpts are options for Consensus.ExecuteQuery. The zero value asks for a
+// linearizable query.
+type QueryOpts struct {
+	// Lease, if set, has the leader answer the query as long as it believes
+	// itself to be the leader, without first confirming that with a quorum of
+	// the cluster. This saves a round trip, but a leader that has been deposed
+	// without noticing yet may answer with stale state, for up to the raft
+	// LeaderLeaseTimeout.
+	Lease bool
+
+	// Stale, if set, has the query answered from this node's state machine even
+	// if this node is a follower, rather than being forwarded to the leader.
+	// The state may lag the leader's by an arbitrary amount.
+	Stale bool
+}
+
+// queryRequest is a query forwarded to the leader over the command mux.
+type queryRequest struct {
+	Command Command
+	Lease   bool
+}
+
+var errNoQuerier = errors.New("state machine does not implement Querier")
+
+// ExecuteQuery answers a read-only Command using the state machine's Query
+// method, which it must implement (see Querier). Unlike ExecuteCommand, the
+// query is not appended to the raft log.
+//
+// By default the query is linearizable: the leader confirms it's still the
+// leader with a quorum of the cluster and then answers from its state machine,
+// which reflects every command that completed before ExecuteQuery was called.
+// Queries made on followers are forwarded to the leader. See QueryOpts for
+// cheaper, weaker alternatives.
+func (c *Consensus) ExecuteQuery(cmd Command, opts QueryOpts) (CommandResult, error) {
+	if opts.Stale {
+		q, ok := c.fsm.(Querier)
+		if !ok {
+			return CommandResult{}, errNoQuerier
+		}
+		return q.Query(cmd), nil
+	}
+	result, err := c.executeQueryLocally(cmd, opts.Lease)
+	var leErr lookElsewhereError
+	if errors.As(err, &leErr) {
+		b, err := json.Marshal(queryRequest{Command: cmd, Lease: opts.Lease})
+		if err != nil {
+			return CommandResult{}, err
+		}
+		return c.commandClient.executeQuery(leErr.where, b)
+	}
+	return result, err
+}
+
+func (c *Consensus) executeQueryLocally(cmd Command, lease bool) (CommandResult, error) {
+	q, ok := c.fsm.(Querier)
+	if !ok {
+		return CommandResult{}, errNoQuerier
+	}
+	if err := c.readyForQuery(lease); err != nil {
+		if errors.Is(err, raft.ErrNotLeader) || errors.Is(err, raft.ErrLeadershipLost) {
+			leader, err := c.getLeader()
+			if err != nil {
+				return CommandResult{}, err
+			}
+			return CommandResult{}, lookElsewhereError{where: leader}
+		}
+		return CommandResult{}, err
+	}
+	return q.Query(cmd), nil
+}
+
+// readyForQuery returns nil if this node is the leader and its state machine
+// reflects every command that has completed, in which case the state machine
+// can be queried.
+//
+// A command completes once it has been applied to the leader's state machine,
+// so the current leader's state machine reflects all commands that completed
+// while it has been leader. A new leader may not yet have applied the commands
+// that completed under the previous leader; so the first query of each term
+// waits on a raft barrier, which costs one log entry per term, not per query.
+func (c *Consensus) readyForQuery(lease bool) error {
+	if c.raft.State() != raft.Leader {
+		return raft.ErrNotLeader
+	}
+	term := c.raft.CurrentTerm()
+	if c.queryReadyTerm.Load() != term {
+		if err := c.raft.Barrier(0).Error(); err != nil {
+			return err
+		}
+		c.queryReadyTerm.Store(term)
+	}
+	if lease {
+		return nil
+	}
+	return c.raft.VerifyLeader().Error()
+}
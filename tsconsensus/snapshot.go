@@ -0,0 +1,163 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tsconsensus
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// A backup is a snapshot in a form that can be stored outside of the cluster
+// and restored into any cluster (see Consensus.RestoreBackup). It is a single
+// line of JSON containing the raft.SnapshotMeta, followed by exactly meta.Size
+// bytes of state machine data, as written by the FSM's raft.FSMSnapshot.
+
+// restoreTimeout bounds how long a restore may wait to be scheduled by raft,
+// and how long a restore forwarded to the leader may take.
+const restoreTimeout = 5 * time.Minute
+
+// maxBackupHeaderBytes bounds the size of the JSON header of a backup.
+const maxBackupHeaderBytes = 1024 * 1024
+
+var errNotLeader = errors.New("restore: not the leader")
+
+// TakeSnapshot takes a snapshot of the state machine on this node, stores it in
+// this node's snapshot store and compacts the raft log up to the snapshot.
+// Each node snapshots independently, so the snapshot is only available from
+// the node that took it.
+func (c *Consensus) TakeSnapshot() (*raft.SnapshotMeta, error) {
+	fut := c.raft.Snapshot()
+	if err := fut.Error(); err != nil {
+		return nil, err
+	}
+	meta, rc, err := fut.Open()
+	if err != nil {
+		return nil, err
+	}
+	rc.Close()
+	return meta, nil
+}
+
+// ListSnapshots returns the snapshots in this node's snapshot store, newest
+// first.
+func (c *Consensus) ListSnapshots() ([]*raft.SnapshotMeta, error) {
+	return c.snapStore.List()
+}
+
+// WriteBackup writes the snapshot with the given ID from this node's snapshot
+// store to w, in a form that can be passed to RestoreBackup.
+func (c *Consensus) WriteBackup(w io.Writer, id string) error {
+	meta, rc, err := c.snapStore.Open(id)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if err := writeBackupHeader(w, meta); err != nil {
+		return err
+	}
+	n, err := io.Copy(w, rc)
+	if err != nil {
+		return err
+	}
+	if n != meta.Size {
+		return fmt.Errorf("snapshot %s: read %d bytes, want %d", id, n, meta.Size)
+	}
+	return nil
+}
+
+// RestoreBackup replaces the state of the cluster's state machines with the
+// backup read from r, as written by WriteBackup. The cluster's current
+// membership is kept. If this node is not the leader, the backup is forwarded
+// to the leader.
+//
+// RestoreBackup is meant for disaster recovery. To recover a cluster that has
+// lost quorum, stop every node, remove their state directories, start a single
+// node so that it bootstraps a new cluster as its leader, restore the backup
+// on it, and then start the other nodes so that they join and receive the
+// restored state from the leader.
+func (c *Consensus) RestoreBackup(r io.Reader) error {
+	br := bufio.NewReader(r)
+	meta, err := readBackupHeader(br)
+	if err != nil {
+		return err
+	}
+	// restoreLocally only returns errNotLeader itself if it hasn't read any
+	// of the state, so the whole backup can still be forwarded.
+	err = c.restoreLocally(meta, br)
+	if err != errNotLeader {
+		return err
+	}
+	leader, err := c.getLeader()
+	if err != nil {
+		return err
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		err := writeBackupHeader(pw, meta)
+		if err == nil {
+			_, err = io.Copy(pw, io.LimitReader(br, meta.Size))
+		}
+		pw.CloseWithError(err)
+	}()
+	defer pr.Close()
+	return c.commandClient.restore(leader, pr)
+}
+
+// restoreLocally restores the backup state read from r on this node, which
+// must be the leader. It returns errNotLeader, without reading from r, if this
+// node isn't the leader. If leadership is lost once the restore has started, r
+// has been partly read and a different error is returned.
+func (c *Consensus) restoreLocally(meta *raft.SnapshotMeta, r io.Reader) error {
+	if c.raft.State() != raft.Leader {
+		return errNotLeader
+	}
+	err := c.raft.Restore(meta, io.LimitReader(r, meta.Size), restoreTimeout)
+	if errors.Is(err, raft.ErrNotLeader) {
+		return fmt.Errorf("restore: lost leadership during restore, try again: %w", err)
+	}
+	return err
+}
+
+func writeBackupHeader(w io.Writer, meta *raft.SnapshotMeta) error {
+	bs, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(bs, '\n'))
+	return err
+}
+
+func readBackupHeader(r *bufio.Reader) (*raft.SnapshotMeta, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := r.ReadLine()
+		if err != nil {
+			return nil, fmt.Errorf("reading backup header: %w", err)
+		}
+		line = append(line, chunk...)
+		if len(line) > maxBackupHeaderBytes {
+			return nil, errors.New("reading backup header: too long")
+		}
+		if !isPrefix {
+			break
+		}
+	}
+	var meta raft.SnapshotMeta
+	if err := json.Unmarshal(line, &meta); err != nil {
+		return nil, fmt.Errorf("reading backup header: %w", err)
+	}
+	if meta.Version < raft.SnapshotVersionMin || meta.Version > raft.SnapshotVersionMax {
+		return nil, fmt.Errorf("reading backup header: unsupported snapshot version %d", meta.Version)
+	}
+	if meta.Size < 0 {
+		return nil, fmt.Errorf("reading backup header: invalid size %d", meta.Size)
+	}
+	return &meta, nil
+}
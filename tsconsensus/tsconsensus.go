@@ -11,7 +11,9 @@
 // tsconsensus provides:
 //   - cluster peer discovery based on tailscale tags
 //   - executing a command on the leader
+//   - linearizable read-only queries of the state machine, that don't go through the raft log
 //   - communication between cluster peers over tailscale using tsnet
+//   - taking snapshots of the state machine, and backing them up and restoring them
 //
 // Users implement a state machine that satisfies the raft.FSM interface, with the business logic they desire.
 // When changes to state are needed any node may
@@ -33,11 +35,13 @@ import (
 	"net/http"
 	"net/netip"
 	"path/filepath"
+	"sync/atomic"
 	"time"
 
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/raft"
 	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
 	"tailscale.com/tsnet"
 	"tailscale.com/types/views"
 )
@@ -73,6 +77,10 @@ type Config struct {
 	ConnTimeout       time.Duration
 	ServeDebugMonitor bool
 	StateDirPath      string
+	// AdminCapability is the peer capability that tailnet identities must hold
+	// to use the membership admin endpoints of the monitor HTTP server (see
+	// ServeDebugMonitor). If empty, the admin endpoints are not served.
+	AdminCapability tailcfg.PeerCapability
 }
 
 // DefaultConfig returns a Config populated with default values ready for use.
@@ -184,6 +192,7 @@ func Start(ctx context.Context, ts *tsnet.Server, fsm raft.FSM, bootstrapOpts Bo
 	}
 	shutdownCtx, shutdownCtxCancel := context.WithCancel(ctx)
 	c := Consensus{
+		fsm:               fsm,
 		commandClient:     &cc,
 		self:              self,
 		config:            cfg,
@@ -208,11 +217,12 @@ func Start(ctx context.Context, ts *tsnet.Server, fsm raft.FSM, bootstrapOpts Bo
 	// after startRaft it's possible some other raft node that has us in their configuration will get
 	// in contact, so by the time we do anything else we may already be a functioning member
 	// of a consensus
-	r, err := startRaft(shutdownCtx, ts, &fsm, c.self, auth, cfg)
+	r, snapStore, err := startRaft(shutdownCtx, ts, &fsm, c.self, auth, cfg)
 	if err != nil {
 		return nil, err
 	}
 	c.raft = r
+	c.snapStore = snapStore
 
 	// we may already be in a consensus (see comment above before startRaft) but we're going to
 	// try to bootstrap anyway in case this is a fresh start.
@@ -241,7 +251,7 @@ func Start(ctx context.Context, ts *tsnet.Server, fsm raft.FSM, bootstrapOpts Bo
 	return &c, nil
 }
 
-func startRaft(shutdownCtx context.Context, ts *tsnet.Server, fsm *raft.FSM, self selfRaftNode, auth *authorization, cfg Config) (*raft.Raft, error) {
+func startRaft(shutdownCtx context.Context, ts *tsnet.Server, fsm *raft.FSM, self selfRaftNode, auth *authorization, cfg Config) (*raft.Raft, raft.SnapshotStore, error) {
 	cfg.Raft.LocalID = raft.ServerID(self.id)
 
 	var logStore raft.LogStore
@@ -257,7 +267,7 @@ func startRaft(shutdownCtx context.Context, ts *tsnet.Server, fsm *raft.FSM, sel
 		var err error
 		stableStore, logStore, err = boltStore(filepath.Join(cfg.StateDirPath, "store"))
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		snaplogger := hclog.New(&hclog.LoggerOptions{
 			Name:   "raft-snap",
@@ -266,14 +276,14 @@ func startRaft(shutdownCtx context.Context, ts *tsnet.Server, fsm *raft.FSM, sel
 		})
 		snapStore, err = raft.NewFileSnapshotStoreWithLogger(filepath.Join(cfg.StateDirPath, "snapstore"), 2, snaplogger)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 	}
 
 	// opens the listener on the raft port, raft will close it when it thinks it's appropriate
 	ln, err := ts.Listen("tcp", raftAddr(self.hostAddr, cfg))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	transportLogger := hclog.New(&hclog.LoggerOptions{
@@ -292,7 +302,11 @@ func startRaft(shutdownCtx context.Context, ts *tsnet.Server, fsm *raft.FSM, sel
 		cfg.ConnTimeout,
 		transportLogger)
 
-	return raft.NewRaft(cfg.Raft, *fsm, logStore, stableStore, snapStore, transport)
+	r, err := raft.NewRaft(cfg.Raft, *fsm, logStore, stableStore, snapStore, transport)
+	if err != nil {
+		return nil, nil, err
+	}
+	return r, snapStore, nil
 }
 
 // A Consensus is the consensus algorithm for a tsnet.Server
@@ -300,12 +314,19 @@ func startRaft(shutdownCtx context.Context, ts *tsnet.Server, fsm *raft.FSM, sel
 // and command execution on the leader.
 type Consensus struct {
 	raft              *raft.Raft
+	fsm               raft.FSM
+	snapStore         raft.SnapshotStore
 	commandClient     *commandClient
 	self              selfRaftNode
 	config            Config
 	cmdHttpServer     *http.Server
 	monitorHttpServer *http.Server
 	shutdownCtxCancel context.CancelFunc
+
+	// queryReadyTerm is the raft term in which this node, as leader, last
+	// confirmed that its state machine is ready to answer queries.
+	// See readyForQuery.
+	queryReadyTerm atomic.Uint64
 }
 
 func (c *Consensus) bootstrapTryToJoinAnyTarget(targets views.Slice[*ipnstate.PeerStatus]) bool {
@@ -536,12 +557,8 @@ func (c *Consensus) GetClusterConfiguration() (raft.Configuration, error) {
 	return fut.Configuration(), nil
 }
 
-// DeleteClusterServer returns the result of the underlying raft instance's RemoveServer
+// DeleteClusterServer removes the server with the given ID from the cluster
+// configuration. It is equivalent to RemoveServer.
 func (c *Consensus) DeleteClusterServer(id raft.ServerID) (uint64, error) {
-	fut := c.raft.RemoveServer(id, 0, 1*time.Second)
-	err := fut.Error()
-	if err != nil {
-		return 0, err
-	}
-	return fut.Index(), nil
+	return c.RemoveServer(id)
 }
@@ -17,6 +17,7 @@ import (
 	"net/netip"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"testing"
@@ -104,14 +105,44 @@ func (f *fsm) eventsMatch(es []string) bool {
 	return cmp.Equal(es, f.applyEvents)
 }
 
+// Query answers with the events applied so far.
+func (f *fsm) Query(Command) CommandResult {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	result, err := json.Marshal(f.applyEvents)
+	return CommandResult{Result: result, Err: err}
+}
+
 func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
-	return nil, nil
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return fsmSnapshot(slices.Clone(f.applyEvents)), nil
 }
 
 func (f *fsm) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+	var events []string
+	if err := json.NewDecoder(rc).Decode(&events); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.applyEvents = events
 	return nil
 }
 
+type fsmSnapshot []string
+
+func (s fsmSnapshot) Persist(sink raft.SnapshotSink) error {
+	if err := json.NewEncoder(sink).Encode(s); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s fsmSnapshot) Release() {}
+
 func testConfig(t *testing.T) {
 	if cibuild.On() {
 		t.Skip("these integration tests don't always work well in CI and that's bad for CI; see https://github.com/tailscale/tailscale/issues/16340 and https://github.com/tailscale/tailscale/issues/18022")
@@ -281,6 +312,23 @@ func waitFor(t testing.TB, msg string, condition func() bool, waitBetweenTries t
 	}
 }
 
+// waitForLeaderAgreement waits for all of ps to know the same leader.
+func waitForLeaderAgreement(t testing.TB, ps []*participant) {
+	t.Helper()
+	waitFor(t, "nodes agree on leader", func() bool {
+		want, _ := ps[0].c.raft.LeaderWithID()
+		if want == "" {
+			return false
+		}
+		for _, p := range ps[1:] {
+			if got, _ := p.c.raft.LeaderWithID(); got != want {
+				return false
+			}
+		}
+		return true
+	}, 100*time.Millisecond)
+}
+
 type participant struct {
 	c   *Consensus
 	sm  *fsm
@@ -762,3 +810,202 @@ func TestFollowOnly(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestSnapshotBackupRestore(t *testing.T) {
+	testConfig(t)
+	ctx := context.Background()
+	clusterTag := "tag:whatever"
+	ps, _, _ := startNodesAndWaitForPeerStatus(t, ctx, clusterTag, 3)
+	cfg := warnLogConfig()
+	createConsensusCluster(t, ctx, clusterTag, ps, cfg)
+	for _, p := range ps {
+		defer p.c.Stop(ctx)
+	}
+	assertCommandsWorkOnAnyNode(t, ps)
+	want := []string{"0", "1", "2"}
+
+	// Snapshots are taken and stored by the node asked, leader or not.
+	follower := ps[1]
+	meta, err := follower.c.TakeSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	metas, err := follower.c.ListSnapshots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metas) != 1 || metas[0].ID != meta.ID {
+		t.Fatalf("ListSnapshots: got %v, want just %s", metas, meta.ID)
+	}
+	var backup bytes.Buffer
+	if err := follower.c.WriteBackup(&backup, meta.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// The backup is also available over the command mux, to cluster peers,
+	// while the snapshot is in the store. Restoring replaces it.
+	url := fmt.Sprintf("http://%s:%d/snapshots/%s", follower.c.self.hostAddr, cfg.CommandPort, meta.ID)
+	rsp, err := ps[0].ts.HTTPClient().Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rsp.Body.Close()
+	got, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsp.StatusCode != 200 || !bytes.Equal(got, backup.Bytes()) {
+		t.Fatalf("GET %s: status %d, body %q, want %q", url, rsp.StatusCode, got, backup.Bytes())
+	}
+
+	// Change the state, then restore the backup through a follower, which
+	// forwards it to the leader, which installs it on every node.
+	res, err := ps[2].c.ExecuteCommand(Command{Args: []byte(`"after backup"`)})
+	if err != nil || res.Err != nil {
+		t.Fatalf("ExecuteCommand: %v, %v", err, res.Err)
+	}
+	if err := follower.c.RestoreBackup(bytes.NewReader(backup.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	fxAllRestored := func() bool {
+		for _, p := range ps {
+			if !p.sm.eventsMatch(want) {
+				return false
+			}
+		}
+		return true
+	}
+	waitFor(t, "restored state makes it to all", fxAllRestored, time.Second)
+}
+
+func TestReadBackupHeader(t *testing.T) {
+	meta := &raft.SnapshotMeta{Version: raft.SnapshotVersionMax, ID: "1-2-3", Index: 2, Term: 1, Size: 4}
+	var buf bytes.Buffer
+	if err := writeBackupHeader(&buf, meta); err != nil {
+		t.Fatal(err)
+	}
+	buf.WriteString("data")
+	br := bufio.NewReader(&buf)
+	got, err := readBackupHeader(br)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != meta.ID || got.Index != meta.Index || got.Size != meta.Size {
+		t.Errorf("readBackupHeader = %+v, want %+v", got, meta)
+	}
+	if rest, _ := io.ReadAll(br); string(rest) != "data" {
+		t.Errorf("data after header = %q, want %q", rest, "data")
+	}
+
+	for _, bad := range []string{
+		"",
+		"not json\n",
+		`{"Version":99,"Size":1}` + "\n",
+		`{"Version":1,"Size":-1}` + "\n",
+	} {
+		if _, err := readBackupHeader(bufio.NewReader(strings.NewReader(bad))); err == nil {
+			t.Errorf("readBackupHeader(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestMembershipChanges(t *testing.T) {
+	testConfig(t)
+	ctx := context.Background()
+	clusterTag := "tag:whatever"
+	ps, _, _ := startNodesAndWaitForPeerStatus(t, ctx, clusterTag, 3)
+	cfg := warnLogConfig()
+	createConsensusCluster(t, ctx, clusterTag, ps, cfg)
+	for _, p := range ps {
+		defer p.c.Stop(ctx)
+	}
+
+	suffrages := func(c *Consensus) map[raft.ServerID]raft.ServerSuffrage {
+		conf, err := c.GetClusterConfiguration()
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := map[raft.ServerID]raft.ServerSuffrage{}
+		for _, s := range conf.Servers {
+			m[s.ID] = s.Suffrage
+		}
+		return m
+	}
+
+	// Leadership can be transferred to a chosen node, via a follower.
+	target := ps[2]
+	if err := ps[1].c.TransferLeadership(raft.ServerID(target.c.self.id)); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "target becomes leader", func() bool {
+		return target.c.raft.State() == raft.Leader
+	}, time.Second)
+	waitForLeaderAgreement(t, ps)
+
+	// Removing a server, via a follower, takes it out of the configuration.
+	removed := ps[1]
+	removedID := raft.ServerID(removed.c.self.id)
+	if _, err := ps[0].c.RemoveServer(removedID); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "server removed from configuration", func() bool {
+		_, ok := suffrages(ps[0].c)[removedID]
+		return !ok
+	}, time.Second)
+	waitForLeaderAgreement(t, []*participant{ps[0], ps[2]})
+
+	// It can come back as a nonvoter, and still gets the state machine updates.
+	if _, err := ps[0].c.AddNonvoter(removedID, removed.c.self.hostAddr); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "server added as nonvoter", func() bool {
+		return suffrages(ps[0].c)[removedID] == raft.Nonvoter
+	}, time.Second)
+	waitForLeaderAgreement(t, ps)
+	assertCommandsWorkOnAnyNode(t, []*participant{ps[0], ps[2]})
+	want := []string{"0", "1"}
+	waitFor(t, "nonvoter applies commands", func() bool {
+		return removed.sm.eventsMatch(want)
+	}, time.Second)
+}
+
+func TestExecuteQuery(t *testing.T) {
+	testConfig(t)
+	ctx := context.Background()
+	clusterTag := "tag:whatever"
+	ps, _, _ := startNodesAndWaitForPeerStatus(t, ctx, clusterTag, 3)
+	cfg := warnLogConfig()
+	createConsensusCluster(t, ctx, clusterTag, ps, cfg)
+	for _, p := range ps {
+		defer p.c.Stop(ctx)
+	}
+	assertCommandsWorkOnAnyNode(t, ps)
+	want := []string{"0", "1", "2"}
+	lastIndex := ps[0].c.raft.LastIndex()
+
+	for i, p := range ps {
+		for _, opts := range []QueryOpts{{}, {Lease: true}, {Stale: true}} {
+			res, err := p.c.ExecuteQuery(Command{Name: "events"}, opts)
+			if err != nil {
+				t.Fatalf("%d: ExecuteQuery(%+v): %v", i, opts, err)
+			}
+			if res.Err != nil {
+				t.Fatalf("%d: ExecuteQuery(%+v): result error: %v", i, opts, res.Err)
+			}
+			var got []string
+			if err := json.Unmarshal(res.Result, &got); err != nil {
+				t.Fatal(err)
+			}
+			// Stale queries on followers may not have caught up, but every
+			// node has seen all events by the end of assertCommandsWorkOnAnyNode.
+			if !cmp.Equal(got, want) {
+				t.Errorf("%d: ExecuteQuery(%+v) = %q, want %q", i, opts, got, want)
+			}
+		}
+	}
+
+	// Queries don't append to the log, except for one barrier per leader term.
+	if got := ps[0].c.raft.LastIndex(); got > lastIndex+1 {
+		t.Errorf("queries appended %d log entries, want at most 1", got-lastIndex)
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package filter
+
+import (
+	"net/netip"
+
+	"tailscale.com/net/packet"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/ipproto"
+	"tailscale.com/types/views"
+	"tailscale.com/wgengine/filter/filtertype"
+)
+
+// CheckResult is the result of Filter.Explain: the verdict that the filter
+// reaches for a packet, and why.
+type CheckResult struct {
+	// Response is the filter's verdict, as returned by Check.
+	Response Response
+
+	// Why is the reason for the verdict, as it would be logged.
+	Why string
+
+	// MatchIndex is the index, in the matches passed to New, of the Match
+	// that accepted the packet, or -1 if the verdict wasn't due to a Match.
+	// For matches from MatchesFromFilterRules, it's also the index of the
+	// corresponding tailcfg.FilterRule.
+	MatchIndex int
+
+	// Src is the source prefix of the Match that contained the packet's
+	// source IP. It's the zero value if the Match matched by SrcCap instead.
+	Src netip.Prefix
+
+	// SrcCap is the capability of the packet's source that the Match
+	// matched, if it didn't match by Src.
+	SrcCap tailcfg.NodeCapability
+
+	// Dst is the destination of the Match that contained the packet's
+	// destination IP and port.
+	Dst NetPortRange
+}
+
+// Explain is like Check, but returns an explanation of the verdict: which
+// Match, if any, accepted the packet, and which of its sources and
+// destinations matched. It's meant for debugging; it's much slower than
+// Check.
+func (f *Filter) Explain(srcIP, dstIP netip.Addr, dstPort uint16, proto ipproto.Proto) CheckResult {
+	res := CheckResult{MatchIndex: -1}
+	pkt := syntheticPacket(srcIP, dstIP, dstPort, proto)
+	if pkt == nil {
+		res.Response, res.Why = Drop, "address family mismatch"
+		return res
+	}
+	res.Response, res.Why = f.runIn(pkt, 0)
+	if res.Response != Accept {
+		return res
+	}
+
+	ms, idx := f.matches4, f.matchIdx4
+	if pkt.IPVersion == 6 {
+		ms, idx = f.matches6, f.matchIdx6
+	}
+	var i int
+	switch res.Why {
+	case "tcp ok", "ok":
+		i = ms.explainMatch(pkt, f.srcIPHasCap, &res)
+	case "icmp ok":
+		i = ms.explainMatchIPsOnly(pkt, f.srcIPHasCap, &res)
+	case "other-portless ok":
+		i = ms.explainMatchProtoAndIPsOnlyIfAllPorts(pkt, &res)
+	default:
+		// Accepted without a Match, for example by an IngressAllowHook.
+		return res
+	}
+	if i >= 0 {
+		res.MatchIndex = idx[i]
+	}
+	return res
+}
+
+// explainMatch is like match, but returns the index in ms of the first
+// Match that matches q, or -1, and fills in the matched Src, SrcCap and Dst
+// of res.
+func (ms matches) explainMatch(q *packet.Parsed, hasCap CapTestFunc, res *CheckResult) int {
+	for i := range ms {
+		m := &ms[i]
+		if !views.SliceContains(m.IPProto, q.IPProto) {
+			continue
+		}
+		if !explainSrcMatches(m, q.Src.Addr(), hasCap, res) {
+			continue
+		}
+		for _, dst := range m.Dsts {
+			if dst.Net.Contains(q.Dst.Addr()) && dst.Ports.Contains(q.Dst.Port()) {
+				res.Dst = dst
+				return i
+			}
+		}
+	}
+	res.Src, res.SrcCap = netip.Prefix{}, ""
+	return -1
+}
+
+// explainMatchIPsOnly is the explainMatch equivalent of matchIPsOnly.
+func (ms matches) explainMatchIPsOnly(q *packet.Parsed, hasCap CapTestFunc, res *CheckResult) int {
+	srcAddr := q.Src.Addr()
+	for i := range ms {
+		m := &ms[i]
+		if !explainSrcMatches(m, srcAddr, nil, res) {
+			continue
+		}
+		for _, dst := range m.Dsts {
+			if dst.Net.Contains(q.Dst.Addr()) {
+				res.Dst = dst
+				return i
+			}
+		}
+	}
+	// Like matchIPsOnly, a source capability allows ICMP regardless of
+	// the Match's destinations.
+	if hasCap != nil {
+		for i := range ms {
+			for _, c := range ms[i].SrcCaps {
+				if hasCap(srcAddr, c) {
+					res.Src, res.SrcCap = netip.Prefix{}, c
+					return i
+				}
+			}
+		}
+	}
+	res.Src, res.SrcCap = netip.Prefix{}, ""
+	return -1
+}
+
+// explainMatchProtoAndIPsOnlyIfAllPorts is the explainMatch equivalent of
+// matchProtoAndIPsOnlyIfAllPorts.
+func (ms matches) explainMatchProtoAndIPsOnlyIfAllPorts(q *packet.Parsed, res *CheckResult) int {
+	for i := range ms {
+		m := &ms[i]
+		if !views.SliceContains(m.IPProto, q.IPProto) {
+			continue
+		}
+		if !explainSrcMatches(m, q.Src.Addr(), nil, res) {
+			continue
+		}
+		for _, dst := range m.Dsts {
+			if dst.Ports == filtertype.AllPorts && dst.Net.Contains(q.Dst.Addr()) {
+				res.Dst = dst
+				return i
+			}
+		}
+	}
+	res.Src, res.SrcCap = netip.Prefix{}, ""
+	return -1
+}
+
+// explainSrcMatches is like srcMatches, but records in res the source
+// prefix or capability that matched.
+func explainSrcMatches(m *filtertype.Match, srcAddr netip.Addr, hasCap CapTestFunc, res *CheckResult) bool {
+	res.Src, res.SrcCap = netip.Prefix{}, ""
+	if m.SrcsContains(srcAddr) {
+		for _, p := range m.Srcs {
+			if p.Contains(srcAddr) {
+				res.Src = p
+				break
+			}
+		}
+		return true
+	}
+	if hasCap != nil {
+		for _, c := range m.SrcCaps {
+			if hasCap(srcAddr, c) {
+				res.SrcCap = c
+				return true
+			}
+		}
+	}
+	return false
+}
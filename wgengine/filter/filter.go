@@ -56,6 +56,12 @@ type Filter struct {
 	matches4 matches
 	matches6 matches
 
+	// matchIdx4 and matchIdx6 are, for each element of matches4 and
+	// matches6 respectively, the index of the Match passed to New that
+	// it was derived from. They're only used by Explain.
+	matchIdx4 []int
+	matchIdx6 []int
+
 	// cap4 and cap6 are the subsets of the matches that are about
 	// capability grants, partitioned by source IP address family.
 	cap4, cap6 matches
@@ -233,10 +239,14 @@ func New(matches []Match, capTest CapTestFunc, localNets, logIPs *netipx.IPSet,
 		}
 	}
 
+	matches4, matchIdx4 := matchesFamily(matches, netip.Addr.Is4)
+	matches6, matchIdx6 := matchesFamily(matches, netip.Addr.Is6)
 	f := &Filter{
 		logf:        logf,
-		matches4:    matchesFamily(matches, netip.Addr.Is4),
-		matches6:    matchesFamily(matches, netip.Addr.Is6),
+		matches4:    matches4,
+		matches6:    matches6,
+		matchIdx4:   matchIdx4,
+		matchIdx6:   matchIdx6,
 		cap4:        capMatchesFunc(matches, netip.Addr.Is4),
 		cap6:        capMatchesFunc(matches, netip.Addr.Is6),
 		local4:      ipset.FalseContainsIPFunc(),
@@ -263,10 +273,10 @@ func New(matches []Match, capTest CapTestFunc, localNets, logIPs *netipx.IPSet,
 }
 
 // matchesFamily returns the subset of ms for which keep(srcNet.IP)
-// and keep(dstNet.IP) are both true.
-func matchesFamily(ms matches, keep func(netip.Addr) bool) matches {
-	var ret matches
-	for _, m := range ms {
+// and keep(dstNet.IP) are both true, along with the index in ms of
+// each returned Match.
+func matchesFamily(ms matches, keep func(netip.Addr) bool) (ret matches, idx []int) {
+	for i, m := range ms {
 		var retm Match
 		retm.IPProto = m.IPProto
 		retm.SrcCaps = m.SrcCaps
@@ -284,9 +294,10 @@ func matchesFamily(ms matches, keep func(netip.Addr) bool) matches {
 		if (len(retm.Srcs) > 0 || len(retm.SrcCaps) > 0) && len(retm.Dsts) > 0 {
 			retm.SrcsContains = ipset.NewContainsIPFunc(views.SliceOf(retm.Srcs))
 			ret = append(ret, retm)
+			idx = append(idx, i)
 		}
 	}
-	return ret
+	return ret, idx
 }
 
 // capMatchesFunc returns a copy of the subset of ms for which keep(srcNet.IP)
@@ -375,13 +386,24 @@ var dummyPacket = []byte{
 // Check determines whether traffic from srcIP to dstIP:dstPort is allowed
 // using protocol proto.
 func (f *Filter) Check(srcIP, dstIP netip.Addr, dstPort uint16, proto ipproto.Proto) Response {
+	pkt := syntheticPacket(srcIP, dstIP, dstPort, proto)
+	if pkt == nil {
+		// Mismatched address families, no filters will
+		// match.
+		return Drop
+	}
+	return f.RunIn(pkt, 0)
+}
+
+// syntheticPacket returns a packet from srcIP to dstIP:dstPort using
+// protocol proto, for Check and Explain. If proto is TCP, the packet is
+// a SYN. It returns nil if srcIP and dstIP are of different families.
+func syntheticPacket(srcIP, dstIP netip.Addr, dstPort uint16, proto ipproto.Proto) *packet.Parsed {
 	pkt := &packet.Parsed{}
 	pkt.Decode(dummyPacket) // initialize private fields
 	switch {
 	case (srcIP.Is4() && dstIP.Is6()) || (srcIP.Is6() && srcIP.Is4()):
-		// Mismatched address families, no filters will
-		// match.
-		return Drop
+		return nil
 	case srcIP.Is4():
 		pkt.IPVersion = 4
 	case srcIP.Is6():
@@ -395,8 +417,7 @@ func (f *Filter) Check(srcIP, dstIP netip.Addr, dstPort uint16, proto ipproto.Pr
 	if proto == ipproto.TCP {
 		pkt.TCPFlags = packet.TCPSyn
 	}
-
-	return f.RunIn(pkt, 0)
+	return pkt
 }
 
 // CheckTCP determines whether TCP traffic from srcIP to dstIP:dstPort
@@ -441,14 +462,19 @@ func (f *Filter) ShieldsUp() bool { return f.shieldsUp }
 // RunIn determines whether this node is allowed to receive q from a
 // Tailscale peer.
 func (f *Filter) RunIn(q *packet.Parsed, rf RunFlags) Response {
+	r, _ := f.runIn(q, rf)
+	return r
+}
+
+// runIn is RunIn, but also returns the reason for the verdict, as logged.
+func (f *Filter) runIn(q *packet.Parsed, rf RunFlags) (Response, string) {
 	dir := in
-	r, _ := f.pre(q, rf, dir)
+	r, _, why := f.pre(q, rf, dir)
 	if r == Accept || r == Drop {
 		// already logged
-		return r
+		return r, why
 	}
 
-	var why string
 	switch q.IPVersion {
 	case 4:
 		r, why = f.runIn4(q)
@@ -462,20 +488,20 @@ func (f *Filter) RunIn(q *packet.Parsed, rf RunFlags) Response {
 		for _, pm := range f.IngressAllowHooks {
 			if match, why := pm(*q); match {
 				f.logRateLimit(rf, q, dir, Accept, why)
-				return Accept
+				return Accept, why
 			}
 		}
 		r = Drop
 	}
 	f.logRateLimit(rf, q, dir, r, why)
-	return r
+	return r, why
 }
 
 // RunOut determines whether this node is allowed to send q to a
 // Tailscale peer.
 func (f *Filter) RunOut(q *packet.Parsed, rf RunFlags) (Response, usermetric.DropReason) {
 	dir := out
-	r, reason := f.pre(q, rf, dir)
+	r, reason, _ := f.pre(q, rf, dir)
 	if r == Accept || r == Drop {
 		// already logged
 		return r, reason
@@ -668,39 +694,39 @@ func (f *Filter) isAllowedLinkLocal(q *packet.Parsed) bool {
 }
 
 // pre runs the direction-agnostic filter logic. dir is only used for
-// logging.
-func (f *Filter) pre(q *packet.Parsed, rf RunFlags, dir direction) (Response, usermetric.DropReason) {
+// logging. If it reaches a verdict, why is the reason for it.
+func (f *Filter) pre(q *packet.Parsed, rf RunFlags, dir direction) (_ Response, _ usermetric.DropReason, why string) {
 	if len(q.Buffer()) == 0 {
 		// wireguard keepalive packet, always permit.
-		return Accept, ""
+		return Accept, "", "keepalive"
 	}
 	if len(q.Buffer()) < 20 {
 		f.logRateLimit(rf, q, dir, Drop, "too short")
-		return Drop, usermetric.ReasonTooShort
+		return Drop, usermetric.ReasonTooShort, "too short"
 	}
 
 	if q.IPProto == ipproto.Unknown {
 		f.logRateLimit(rf, q, dir, Drop, "unknown proto")
-		return Drop, usermetric.ReasonUnknownProtocol
+		return Drop, usermetric.ReasonUnknownProtocol, "unknown proto"
 	}
 
 	if q.Dst.Addr().IsMulticast() {
 		f.logRateLimit(rf, q, dir, Drop, "multicast")
-		return Drop, usermetric.ReasonMulticast
+		return Drop, usermetric.ReasonMulticast, "multicast"
 	}
 	if q.Dst.Addr().IsLinkLocalUnicast() && !f.isAllowedLinkLocal(q) {
 		f.logRateLimit(rf, q, dir, Drop, "link-local-unicast")
-		return Drop, usermetric.ReasonLinkLocalUnicast
+		return Drop, usermetric.ReasonLinkLocalUnicast, "link-local-unicast"
 	}
 
 	if q.IPProto == ipproto.Fragment {
 		// Fragments after the first always need to be passed through.
 		// Very small fragments are considered Junk by Parsed.
 		f.logRateLimit(rf, q, dir, Accept, "fragment")
-		return Accept, ""
+		return Accept, "", "fragment"
 	}
 
-	return noVerdict, ""
+	return noVerdict, "", ""
 }
 
 // loggingAllowed reports whether p can appear in logs at all.
@@ -196,6 +196,62 @@ func TestFilter(t *testing.T) {
 	}
 }
 
+func TestExplain(t *testing.T) {
+	filt := newFilter(t.Logf)
+	filt.srcIPHasCap = func(ip netip.Addr, cap tailcfg.NodeCapability) bool {
+		return cap == "cap-hit-1234-ssh" && ip == netip.MustParseAddr("10.0.0.1")
+	}
+
+	tests := []struct {
+		name      string
+		proto     ipproto.Proto
+		src, dst  string
+		port      uint16
+		want      Response
+		wantWhy   string
+		wantIndex int
+		wantSrc   string // prefix, or capability
+		wantDst   string
+	}{
+		{"tcp", ipproto.TCP, "8.2.2.2", "1.2.3.4", 22, Accept, "tcp ok", 0, "8.2.2.2/32", "1.2.3.4/32:22"},
+		{"tcp-second-dst", ipproto.TCP, "8.1.1.1", "5.6.7.8", 24, Accept, "tcp ok", 0, "8.1.1.1/32", "5.6.7.8/32:23-24"},
+		{"sctp", ipproto.SCTP, "9.1.1.1", "1.2.3.4", 22, Accept, "ok", 1, "9.1.1.1/32", "1.2.3.4/32:22"},
+		{"udp-any-src", ipproto.UDP, "7.7.7.7", "1.2.3.4", 443, Accept, "ok", 5, "0.0.0.0/0", "0.0.0.0/0:443"},
+		{"cap", ipproto.TCP, "10.0.0.1", "1.2.3.4", 22, Accept, "tcp ok", 11, "cap-hit-1234-ssh", "1.2.3.4/32:22"},
+		{"icmp", ipproto.ICMPv4, "8.1.1.1", "1.2.3.4", 0, Accept, "icmp ok", 0, "8.1.1.1/32", "1.2.3.4/32:22"},
+		{"portless", testAllowedProto, "8.1.1.1", "1.2.3.4", 0, Accept, "other-portless ok", 9, "0.0.0.0/0", "0.0.0.0/0:*"},
+		{"v6", ipproto.TCP, "::2", "2001::2", 22, Accept, "tcp ok", 7, "::2/128", "2001::2/128:22"},
+		{"no-match", ipproto.TCP, "8.1.1.1", "1.2.3.4", 21, Drop, "no rules matched", -1, "", ""},
+		{"no-cap", ipproto.TCP, "10.0.0.2", "1.2.3.4", 22, Drop, "no rules matched", -1, "", ""},
+		{"not-local", ipproto.TCP, "8.1.1.1", "9.9.9.9", 22, Drop, "destination not allowed", -1, "", ""},
+		{"mixed-family", ipproto.TCP, "8.1.1.1", "2001::1", 22, Drop, "address family mismatch", -1, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := filt.Explain(netip.MustParseAddr(tt.src), netip.MustParseAddr(tt.dst), tt.port, tt.proto)
+			if got.Response != tt.want || got.Why != tt.wantWhy || got.MatchIndex != tt.wantIndex {
+				t.Fatalf("got (%v, %q, %d); want (%v, %q, %d)", got.Response, got.Why, got.MatchIndex, tt.want, tt.wantWhy, tt.wantIndex)
+			}
+			if tt.wantIndex < 0 {
+				return
+			}
+			gotSrc := string(got.SrcCap)
+			if got.Src.IsValid() {
+				gotSrc = got.Src.String()
+			}
+			if gotSrc != tt.wantSrc {
+				t.Errorf("matched src = %q; want %q", gotSrc, tt.wantSrc)
+			}
+			if got.Dst.String() != tt.wantDst {
+				t.Errorf("matched dst = %q; want %q", got.Dst, tt.wantDst)
+			}
+			if r := filt.Check(netip.MustParseAddr(tt.src), netip.MustParseAddr(tt.dst), tt.port, tt.proto); r != got.Response {
+				t.Errorf("Check = %v; Explain = %v", r, got.Response)
+			}
+		})
+	}
+}
+
 func TestUDPState(t *testing.T) {
 	acl := newFilter(t.Logf)
 	flags := LogDrops | LogAccepts
@@ -397,7 +453,7 @@ func TestPreFilter(t *testing.T) {
 	for _, testPacket := range packets {
 		p := &packet.Parsed{}
 		p.Decode(testPacket.b)
-		got, gotReason := f.pre(p, LogDrops|LogAccepts, in)
+		got, gotReason, _ := f.pre(p, LogDrops|LogAccepts, in)
 		if got != testPacket.want || gotReason != testPacket.wantReason {
 			t.Errorf("%q got=%v want=%v gotReason=%s wantReason=%s packet:\n%s", testPacket.desc, got, testPacket.want, gotReason, testPacket.wantReason, packet.Hexdump(testPacket.b))
 		}
@@ -1224,7 +1280,7 @@ func TestLinkLocalAllowHooks(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			filt := newFilter(t.Logf)
 			filt.LinkLocalAllowHooks = tt.hooks
-			got, reason := filt.pre(&tt.p, 0, tt.dir)
+			got, reason, _ := filt.pre(&tt.p, 0, tt.dir)
 			if got != tt.want {
 				t.Errorf("pre = %v (%s); want %v", got, reason, tt.want)
 			}
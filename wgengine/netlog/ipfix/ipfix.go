@@ -0,0 +1,415 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package ipfix exports network flow logs (see [netlogtype.Message]) to an
+// IPFIX collector (RFC 7011) over UDP.
+//
+// Each [netlogtype.ConnectionCounts] becomes up to two data records, one per
+// direction of the connection that saw traffic. Records use the standard
+// information elements for addresses, ports, protocol, direction, counts,
+// and time range, plus the Tailscale-specific enterprise elements
+// described by [EnterpriseElement].
+//
+// NetFlow v9 collectors that don't understand IPFIX are not supported,
+// since NetFlow v9 has no way to describe enterprise-specific fields.
+package ipfix
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+
+	"tailscale.com/syncs"
+	"tailscale.com/types/ipproto"
+	"tailscale.com/types/netlogtype"
+)
+
+// EnterpriseElement is the ID of a Tailscale-specific information element,
+// scoped to the enterprise number the [Exporter] is configured with.
+// They're only exported if an enterprise number is configured.
+type EnterpriseElement uint16
+
+const (
+	// ElementTrafficType is the kind of traffic as an unsigned8:
+	// see [TrafficType].
+	ElementTrafficType EnterpriseElement = 1
+	// ElementSrcNodeID is the stable node ID of the node that owns the
+	// source address, or empty if unknown.
+	ElementSrcNodeID EnterpriseElement = 2
+	// ElementSrcUser is the user that owns the node that owns the source
+	// address, or empty if unknown or the node is tagged.
+	ElementSrcUser EnterpriseElement = 3
+	// ElementDstNodeID is like ElementSrcNodeID, for the destination address.
+	ElementDstNodeID EnterpriseElement = 4
+	// ElementDstUser is like ElementSrcUser, for the destination address.
+	ElementDstUser EnterpriseElement = 5
+)
+
+// TrafficType is the value of the [ElementTrafficType] element.
+type TrafficType uint8
+
+const (
+	TrafficVirtual  TrafficType = 1 // [netlogtype.Message.VirtualTraffic]
+	TrafficSubnet   TrafficType = 2 // [netlogtype.Message.SubnetTraffic]
+	TrafficExit     TrafficType = 3 // [netlogtype.Message.ExitTraffic]
+	TrafficPhysical TrafficType = 4 // [netlogtype.Message.PhysicalTraffic]
+)
+
+// Standard information element IDs, from the IANA IPFIX registry.
+const (
+	ieOctetDeltaCount          = 1
+	iePacketDeltaCount         = 2
+	ieProtocolIdentifier       = 4
+	ieSourceTransportPort      = 7
+	ieSourceIPv4Address        = 8
+	ieDestinationTransportPort = 11
+	ieDestinationIPv4Address   = 12
+	ieSourceIPv6Address        = 27
+	ieDestinationIPv6Address   = 28
+	ieFlowDirection            = 61
+	ieFlowStartMilliseconds    = 152
+	ieFlowEndMilliseconds      = 153
+)
+
+const (
+	version = 10
+
+	setHeaderLen = 4
+
+	templateSetID = 2
+	templateIDv4  = 256
+	templateIDv6  = 257
+
+	// variableLength is the field length in a template
+	// for variable-length elements.
+	variableLength = 0xffff
+
+	// enterpriseBit is set in the element ID of enterprise-specific
+	// elements in a template.
+	enterpriseBit = 0x8000
+
+	directionIngress = 0
+	directionEgress  = 1
+)
+
+// maxMessageSize is the maximum size of an IPFIX message. It's small
+// enough to avoid IP fragmentation on typical paths.
+const maxMessageSize = 1400
+
+// templateInterval is how often templates are resent. Collectors that
+// start after the exporter can't decode data records until they've seen
+// the templates (RFC 7011, section 8.4).
+const templateInterval = 5 * time.Minute
+
+// field is a field specifier in a template.
+type field struct {
+	id         uint16
+	length     uint16
+	enterprise bool
+}
+
+// templateFields returns the fields of the template for the address family
+// with addresses of addrLen bytes, including the enterprise-specific fields
+// if withEnterprise. Data records are encoded in the same order by
+// [encoder.newRecord].
+func templateFields(addrLen uint16, withEnterprise bool) []field {
+	srcAddr, dstAddr := uint16(ieSourceIPv4Address), uint16(ieDestinationIPv4Address)
+	if addrLen == 16 {
+		srcAddr, dstAddr = ieSourceIPv6Address, ieDestinationIPv6Address
+	}
+	fields := []field{
+		{id: srcAddr, length: addrLen},
+		{id: dstAddr, length: addrLen},
+		{id: ieSourceTransportPort, length: 2},
+		{id: ieDestinationTransportPort, length: 2},
+		{id: ieProtocolIdentifier, length: 1},
+		{id: ieFlowDirection, length: 1},
+		{id: iePacketDeltaCount, length: 8},
+		{id: ieOctetDeltaCount, length: 8},
+		{id: ieFlowStartMilliseconds, length: 8},
+		{id: ieFlowEndMilliseconds, length: 8},
+	}
+	if !withEnterprise {
+		return fields
+	}
+	return append(fields, []field{
+		{id: uint16(ElementTrafficType), length: 1, enterprise: true},
+		{id: uint16(ElementSrcNodeID), length: variableLength, enterprise: true},
+		{id: uint16(ElementSrcUser), length: variableLength, enterprise: true},
+		{id: uint16(ElementDstNodeID), length: variableLength, enterprise: true},
+		{id: uint16(ElementDstUser), length: variableLength, enterprise: true},
+	}...)
+}
+
+// Config is the configuration of an [Exporter].
+type Config struct {
+	// Collector is the "host:port" of the UDP collector.
+	Collector string
+
+	// EnterpriseNumber is the IANA Private Enterprise Number that scopes
+	// the Tailscale-specific information elements. If zero, those
+	// elements are not exported.
+	EnterpriseNumber uint32
+
+	// ObservationDomainID identifies this exporter to the collector.
+	ObservationDomainID uint32
+}
+
+// Exporter sends network flow logs to an IPFIX collector.
+type Exporter struct {
+	conn net.Conn
+	now  func() time.Time // for tests
+
+	mu            syncs.Mutex
+	enc           encoder
+	lastTemplates time.Time // or zero if never sent
+}
+
+// NewExporter returns a new Exporter that sends to the collector in c.
+func NewExporter(c Config) (*Exporter, error) {
+	if c.Collector == "" {
+		return nil, errors.New("no collector address")
+	}
+	conn, err := net.Dial("udp", c.Collector)
+	if err != nil {
+		return nil, err
+	}
+	x := &Exporter{
+		conn: conn,
+		now:  time.Now,
+		enc: encoder{
+			enterprise: c.EnterpriseNumber,
+			domain:     c.ObservationDomainID,
+		},
+	}
+	return x, nil
+}
+
+// Export sends the traffic in msg to the collector.
+func (x *Exporter) Export(msg *netlogtype.Message) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	now := x.now()
+	withTemplates := x.lastTemplates.IsZero() || now.Sub(x.lastTemplates) >= templateInterval
+	pkts := x.enc.encode(msg, withTemplates, now)
+	if len(pkts) == 0 {
+		return nil
+	}
+	if withTemplates {
+		x.lastTemplates = now
+	}
+	for _, pkt := range pkts {
+		if _, err := x.conn.Write(pkt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the connection to the collector.
+func (x *Exporter) Close() error {
+	return x.conn.Close()
+}
+
+// encoder encodes network flow logs as IPFIX messages.
+type encoder struct {
+	enterprise uint32 // or zero to omit the enterprise-specific elements
+	domain     uint32
+	seq        uint32 // number of data records encoded so far
+}
+
+// record is a data record, and the template it's encoded with.
+type record struct {
+	templateID uint16
+	b          []byte
+}
+
+// encode returns the IPFIX messages for the traffic in msg, each no larger
+// than maxMessageSize. If withTemplates, the first message starts with the
+// templates. It returns nil if msg has no traffic.
+func (e *encoder) encode(msg *netlogtype.Message, withTemplates bool, now time.Time) [][]byte {
+	nodes := make(map[netip.Addr]*netlogtype.Node)
+	for i := range msg.DstNodes {
+		for _, addr := range msg.DstNodes[i].Addresses {
+			nodes[addr] = &msg.DstNodes[i]
+		}
+	}
+	for _, addr := range msg.SrcNode.Addresses {
+		nodes[addr] = &msg.SrcNode
+	}
+
+	var recs []record
+	for _, t := range []struct {
+		typ     TrafficType
+		traffic []netlogtype.ConnectionCounts
+	}{
+		{TrafficVirtual, msg.VirtualTraffic},
+		{TrafficSubnet, msg.SubnetTraffic},
+		{TrafficExit, msg.ExitTraffic},
+		{TrafficPhysical, msg.PhysicalTraffic},
+	} {
+		for _, cc := range t.traffic {
+			c := cc.Connection
+			src, dst := c.Src.Addr().Unmap(), c.Dst.Addr().Unmap()
+			if !src.IsValid() || !dst.IsValid() || src.Is4() != dst.Is4() {
+				continue
+			}
+			if cc.TxPackets > 0 || cc.TxBytes > 0 {
+				recs = append(recs, e.newRecord(msg, nodes, t.typ, c.Proto, c.Src, c.Dst, directionEgress, cc.TxPackets, cc.TxBytes))
+			}
+			if cc.RxPackets > 0 || cc.RxBytes > 0 {
+				recs = append(recs, e.newRecord(msg, nodes, t.typ, c.Proto, c.Dst, c.Src, directionIngress, cc.RxPackets, cc.RxBytes))
+			}
+		}
+	}
+	if len(recs) == 0 {
+		return nil
+	}
+
+	var (
+		pkts   [][]byte
+		pkt    []byte
+		nrecs  uint32 // in pkt
+		setOff = -1   // offset of the current data set in pkt, or -1 if none
+		setID  uint16
+	)
+	endSet := func() {
+		if setOff >= 0 {
+			binary.BigEndian.PutUint16(pkt[setOff+2:], uint16(len(pkt)-setOff))
+			setOff = -1
+		}
+	}
+	endMessage := func() {
+		endSet()
+		binary.BigEndian.PutUint16(pkt[2:], uint16(len(pkt)))
+		binary.BigEndian.PutUint32(pkt[8:], e.seq)
+		e.seq += nrecs
+		pkts = append(pkts, pkt)
+		pkt, nrecs = nil, 0
+	}
+	startMessage := func() {
+		pkt = binary.BigEndian.AppendUint16(pkt, version)
+		pkt = binary.BigEndian.AppendUint16(pkt, 0) // length, set by endMessage
+		pkt = binary.BigEndian.AppendUint32(pkt, uint32(now.Unix()))
+		pkt = binary.BigEndian.AppendUint32(pkt, 0) // sequence number, set by endMessage
+		pkt = binary.BigEndian.AppendUint32(pkt, e.domain)
+	}
+
+	startMessage()
+	if withTemplates {
+		pkt = e.appendTemplates(pkt)
+	}
+	for _, r := range recs {
+		n := len(r.b)
+		if setOff < 0 || setID != r.templateID {
+			n += setHeaderLen
+		}
+		if nrecs > 0 && len(pkt)+n > maxMessageSize {
+			endMessage()
+			startMessage()
+		}
+		if setOff < 0 || setID != r.templateID {
+			endSet()
+			setOff, setID = len(pkt), r.templateID
+			pkt = binary.BigEndian.AppendUint16(pkt, setID)
+			pkt = binary.BigEndian.AppendUint16(pkt, 0) // length, set by endSet
+		}
+		pkt = append(pkt, r.b...)
+		nrecs++
+	}
+	endMessage()
+	return pkts
+}
+
+// appendTemplates appends a template set with the IPv4 and IPv6 templates.
+func (e *encoder) appendTemplates(b []byte) []byte {
+	off := len(b)
+	b = binary.BigEndian.AppendUint16(b, templateSetID)
+	b = binary.BigEndian.AppendUint16(b, 0) // length, set below
+	for _, t := range []struct {
+		id      uint16
+		addrLen uint16
+	}{
+		{templateIDv4, 4},
+		{templateIDv6, 16},
+	} {
+		fields := templateFields(t.addrLen, e.enterprise != 0)
+		b = binary.BigEndian.AppendUint16(b, t.id)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(fields)))
+		for _, f := range fields {
+			if f.enterprise {
+				b = binary.BigEndian.AppendUint16(b, f.id|enterpriseBit)
+				b = binary.BigEndian.AppendUint16(b, f.length)
+				b = binary.BigEndian.AppendUint32(b, e.enterprise)
+			} else {
+				b = binary.BigEndian.AppendUint16(b, f.id)
+				b = binary.BigEndian.AppendUint16(b, f.length)
+			}
+		}
+	}
+	binary.BigEndian.PutUint16(b[off+2:], uint16(len(b)-off))
+	return b
+}
+
+// newRecord returns a data record for traffic from src to dst.
+func (e *encoder) newRecord(msg *netlogtype.Message, nodes map[netip.Addr]*netlogtype.Node, typ TrafficType, proto ipproto.Proto, src, dst netip.AddrPort, direction uint8, packets, bytes uint64) record {
+	srcAddr, dstAddr := src.Addr().Unmap(), dst.Addr().Unmap()
+	templateID := uint16(templateIDv4)
+	if srcAddr.Is6() {
+		templateID = templateIDv6
+	}
+	var b []byte
+	b = append(b, srcAddr.AsSlice()...)
+	b = append(b, dstAddr.AsSlice()...)
+	b = binary.BigEndian.AppendUint16(b, src.Port())
+	b = binary.BigEndian.AppendUint16(b, dst.Port())
+	b = append(b, uint8(proto), direction)
+	b = binary.BigEndian.AppendUint64(b, packets)
+	b = binary.BigEndian.AppendUint64(b, bytes)
+	b = binary.BigEndian.AppendUint64(b, uint64(msg.Start.UnixMilli()))
+	b = binary.BigEndian.AppendUint64(b, uint64(msg.End.UnixMilli()))
+	if e.enterprise == 0 {
+		return record{templateID, b}
+	}
+	b = append(b, uint8(typ))
+	var srcID, srcUser, dstID, dstUser string
+	if n := nodes[srcAddr]; n != nil {
+		srcID, srcUser = string(n.NodeID), n.User
+	}
+	if n := nodes[dstAddr]; n != nil {
+		dstID, dstUser = string(n.NodeID), n.User
+	}
+	for _, s := range []string{srcID, srcUser, dstID, dstUser} {
+		b = appendVarString(b, s)
+	}
+	return record{templateID, b}
+}
+
+// appendVarString appends s as a variable-length field
+// (RFC 7011, section 7), truncating it if it's too long.
+func appendVarString(b []byte, s string) []byte {
+	const maxLen = 0xff // enough for node IDs and login names
+	if len(s) >= maxLen {
+		s = s[:maxLen-1]
+	}
+	b = append(b, uint8(len(s)))
+	return append(b, s...)
+}
+
+// String implements [fmt.Stringer].
+func (t TrafficType) String() string {
+	switch t {
+	case TrafficVirtual:
+		return "virtual"
+	case TrafficSubnet:
+		return "subnet"
+	case TrafficExit:
+		return "exit"
+	case TrafficPhysical:
+		return "physical"
+	}
+	return fmt.Sprintf("TrafficType(%d)", uint8(t))
+}
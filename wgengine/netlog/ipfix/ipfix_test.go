@@ -0,0 +1,309 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipfix
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"tailscale.com/types/ipproto"
+	"tailscale.com/types/netlogtype"
+)
+
+// collector is a minimal IPFIX collector for tests.
+type collector struct {
+	t         *testing.T
+	pc        net.PacketConn
+	templates map[uint16][]field
+	seq       uint32 // expected sequence number of the next message
+}
+
+func newCollector(t *testing.T) *collector {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	return &collector{t: t, pc: pc, templates: make(map[uint16][]field)}
+}
+
+// collectedRecord is a decoded data record. Unsigned fields are stored as
+// uint64, and addresses and variable-length fields as strings.
+type collectedRecord map[string]any
+
+// read reads one message and returns its data records, and whether it
+// contained templates.
+func (c *collector) read() (recs []collectedRecord, hadTemplates bool) {
+	t := c.t
+	t.Helper()
+	buf := make([]byte, 65535)
+	c.pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := c.pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := buf[:n]
+	if n > maxMessageSize {
+		t.Errorf("message size = %d; want <= %d", n, maxMessageSize)
+	}
+	if v := binary.BigEndian.Uint16(b[0:]); v != version {
+		t.Fatalf("version = %d", v)
+	}
+	if l := int(binary.BigEndian.Uint16(b[2:])); l != n {
+		t.Fatalf("length = %d; read %d bytes", l, n)
+	}
+	if seq := binary.BigEndian.Uint32(b[8:]); seq != c.seq {
+		t.Errorf("sequence = %d; want %d", seq, c.seq)
+	}
+	if d := binary.BigEndian.Uint32(b[12:]); d != 7 {
+		t.Errorf("observation domain = %d; want 7", d)
+	}
+	b = b[16:]
+	for len(b) > 0 {
+		setID := binary.BigEndian.Uint16(b)
+		setLen := int(binary.BigEndian.Uint16(b[2:]))
+		set := b[setHeaderLen:setLen]
+		b = b[setLen:]
+		if setID == templateSetID {
+			hadTemplates = true
+			for len(set) > 0 {
+				id, count := binary.BigEndian.Uint16(set), int(binary.BigEndian.Uint16(set[2:]))
+				set = set[4:]
+				var fields []field
+				for range count {
+					f := field{id: binary.BigEndian.Uint16(set), length: binary.BigEndian.Uint16(set[2:])}
+					set = set[4:]
+					if f.id&enterpriseBit != 0 {
+						f.id &^= enterpriseBit
+						f.enterprise = true
+						if pen := binary.BigEndian.Uint32(set); pen != 12345 {
+							t.Errorf("enterprise number = %d; want 12345", pen)
+						}
+						set = set[4:]
+					}
+					fields = append(fields, f)
+				}
+				c.templates[id] = fields
+			}
+			continue
+		}
+		fields, ok := c.templates[setID]
+		if !ok {
+			t.Fatalf("data set with unknown template %d", setID)
+		}
+		for len(set) > 0 {
+			r := make(collectedRecord)
+			for _, f := range fields {
+				name := fmt.Sprint(f.id)
+				if f.enterprise {
+					name = "e" + name
+				}
+				switch {
+				case f.length == variableLength:
+					l := int(set[0])
+					r[name] = string(set[1 : 1+l])
+					set = set[1+l:]
+				case f.id == ieSourceIPv4Address || f.id == ieDestinationIPv4Address ||
+					f.id == ieSourceIPv6Address || f.id == ieDestinationIPv6Address:
+					addr, _ := netip.AddrFromSlice(set[:f.length])
+					r[name] = addr.String()
+					set = set[f.length:]
+				default:
+					var v uint64
+					for _, x := range set[:f.length] {
+						v = v<<8 | uint64(x)
+					}
+					r[name] = v
+					set = set[f.length:]
+				}
+			}
+			recs = append(recs, r)
+		}
+	}
+	c.seq += uint32(len(recs))
+	return recs, hadTemplates
+}
+
+func TestExporter(t *testing.T) {
+	c := newCollector(t)
+	x, err := NewExporter(Config{
+		Collector:           c.pc.LocalAddr().String(),
+		EnterpriseNumber:    12345,
+		ObservationDomainID: 7,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer x.Close()
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	x.now = func() time.Time { return now }
+
+	self := netip.MustParseAddr("100.64.0.1")
+	peer := netip.MustParseAddr("100.64.0.2")
+	self6 := netip.MustParseAddr("fd7a:115c:a1e0::1")
+	peer6 := netip.MustParseAddr("fd7a:115c:a1e0::2")
+	endpoint := netip.MustParseAddr("198.51.100.1")
+	msg := &netlogtype.Message{
+		NodeID: "nSELF",
+		Start:  now.Add(-5 * time.Second),
+		End:    now,
+		SrcNode: netlogtype.Node{
+			NodeID:    "nSELF",
+			Addresses: []netip.Addr{self, self6},
+			User:      "alice@example.com",
+		},
+		DstNodes: []netlogtype.Node{{
+			NodeID:    "nPEER",
+			Addresses: []netip.Addr{peer, peer6},
+			Tags:      []string{"tag:server"},
+		}},
+		VirtualTraffic: []netlogtype.ConnectionCounts{{
+			Connection: netlogtype.Connection{Proto: ipproto.TCP, Src: netip.AddrPortFrom(self, 40000), Dst: netip.AddrPortFrom(peer, 443)},
+			Counts:     netlogtype.Counts{TxPackets: 2, TxBytes: 200, RxPackets: 3, RxBytes: 3000},
+		}, {
+			Connection: netlogtype.Connection{Proto: ipproto.UDP, Src: netip.AddrPortFrom(self6, 5353), Dst: netip.AddrPortFrom(peer6, 53)},
+			Counts:     netlogtype.Counts{TxPackets: 1, TxBytes: 60},
+		}},
+		PhysicalTraffic: []netlogtype.ConnectionCounts{{
+			Connection: netlogtype.Connection{Src: netip.AddrPortFrom(peer, 0), Dst: netip.AddrPortFrom(endpoint, 41641)},
+			Counts:     netlogtype.Counts{RxPackets: 3, RxBytes: 3100},
+		}},
+	}
+	startMs, endMs := uint64(msg.Start.UnixMilli()), uint64(msg.End.UnixMilli())
+	rec := func(src, dst netip.Addr, sport, dport uint16, proto ipproto.Proto, dir uint8, pkts, bytes uint64, typ TrafficType, srcID, srcUser, dstID, dstUser string) collectedRecord {
+		srcIE, dstIE := ieSourceIPv4Address, ieDestinationIPv4Address
+		if src.Is6() {
+			srcIE, dstIE = ieSourceIPv6Address, ieDestinationIPv6Address
+		}
+		return collectedRecord{
+			fmt.Sprint(srcIE):                      src.String(),
+			fmt.Sprint(dstIE):                      dst.String(),
+			fmt.Sprint(ieSourceTransportPort):      uint64(sport),
+			fmt.Sprint(ieDestinationTransportPort): uint64(dport),
+			fmt.Sprint(ieProtocolIdentifier):       uint64(proto),
+			fmt.Sprint(ieFlowDirection):            uint64(dir),
+			fmt.Sprint(iePacketDeltaCount):         pkts,
+			fmt.Sprint(ieOctetDeltaCount):          bytes,
+			fmt.Sprint(ieFlowStartMilliseconds):    startMs,
+			fmt.Sprint(ieFlowEndMilliseconds):      endMs,
+			fmt.Sprint("e", ElementTrafficType):    uint64(typ),
+			fmt.Sprint("e", ElementSrcNodeID):      srcID,
+			fmt.Sprint("e", ElementSrcUser):        srcUser,
+			fmt.Sprint("e", ElementDstNodeID):      dstID,
+			fmt.Sprint("e", ElementDstUser):        dstUser,
+		}
+	}
+	want := []collectedRecord{
+		rec(self, peer, 40000, 443, ipproto.TCP, directionEgress, 2, 200, TrafficVirtual, "nSELF", "alice@example.com", "nPEER", ""),
+		rec(peer, self, 443, 40000, ipproto.TCP, directionIngress, 3, 3000, TrafficVirtual, "nPEER", "", "nSELF", "alice@example.com"),
+		rec(self6, peer6, 5353, 53, ipproto.UDP, directionEgress, 1, 60, TrafficVirtual, "nSELF", "alice@example.com", "nPEER", ""),
+		rec(endpoint, peer, 41641, 0, 0, directionIngress, 3, 3100, TrafficPhysical, "", "", "nPEER", ""),
+	}
+
+	if err := x.Export(msg); err != nil {
+		t.Fatal(err)
+	}
+	got, hadTemplates := c.read()
+	if !hadTemplates {
+		t.Error("first message has no templates")
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("records (-want +got):\n%s", diff)
+	}
+
+	// Templates aren't resent until templateInterval has passed.
+	if err := x.Export(msg); err != nil {
+		t.Fatal(err)
+	}
+	if _, hadTemplates := c.read(); hadTemplates {
+		t.Error("second message has templates")
+	}
+	now = now.Add(templateInterval)
+	if err := x.Export(msg); err != nil {
+		t.Fatal(err)
+	}
+	if _, hadTemplates := c.read(); !hadTemplates {
+		t.Error("templates not resent")
+	}
+
+	// A message with no traffic sends nothing.
+	if err := x.Export(&netlogtype.Message{NodeID: "nSELF"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Many records are split across messages.
+	msg.PhysicalTraffic = nil
+	msg.VirtualTraffic = nil
+	for i := range 100 {
+		msg.VirtualTraffic = append(msg.VirtualTraffic, netlogtype.ConnectionCounts{
+			Connection: netlogtype.Connection{Proto: ipproto.TCP, Src: netip.AddrPortFrom(self, uint16(10000+i)), Dst: netip.AddrPortFrom(peer, 22)},
+			Counts:     netlogtype.Counts{TxPackets: 1, TxBytes: 100},
+		})
+	}
+	if err := x.Export(msg); err != nil {
+		t.Fatal(err)
+	}
+	var total, messages int
+	for total < 100 {
+		recs, _ := c.read()
+		total += len(recs)
+		messages++
+	}
+	if total != 100 {
+		t.Errorf("got %d records; want 100", total)
+	}
+	if messages < 2 {
+		t.Errorf("got %d messages; want at least 2", messages)
+	}
+}
+
+func TestExporterWithoutEnterpriseNumber(t *testing.T) {
+	c := newCollector(t)
+	x, err := NewExporter(Config{
+		Collector:           c.pc.LocalAddr().String(),
+		ObservationDomainID: 7,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer x.Close()
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	x.now = func() time.Time { return now }
+
+	self := netip.MustParseAddr("100.64.0.1")
+	peer := netip.MustParseAddr("100.64.0.2")
+	msg := &netlogtype.Message{
+		Start:   now.Add(-5 * time.Second),
+		End:     now,
+		SrcNode: netlogtype.Node{NodeID: "nSELF", Addresses: []netip.Addr{self}},
+		VirtualTraffic: []netlogtype.ConnectionCounts{{
+			Connection: netlogtype.Connection{Proto: ipproto.TCP, Src: netip.AddrPortFrom(self, 40000), Dst: netip.AddrPortFrom(peer, 443)},
+			Counts:     netlogtype.Counts{TxPackets: 2, TxBytes: 200},
+		}},
+	}
+	if err := x.Export(msg); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := c.read()
+	want := []collectedRecord{{
+		fmt.Sprint(ieSourceIPv4Address):        self.String(),
+		fmt.Sprint(ieDestinationIPv4Address):   peer.String(),
+		fmt.Sprint(ieSourceTransportPort):      uint64(40000),
+		fmt.Sprint(ieDestinationTransportPort): uint64(443),
+		fmt.Sprint(ieProtocolIdentifier):       uint64(ipproto.TCP),
+		fmt.Sprint(ieFlowDirection):            uint64(directionEgress),
+		fmt.Sprint(iePacketDeltaCount):         uint64(2),
+		fmt.Sprint(ieOctetDeltaCount):          uint64(200),
+		fmt.Sprint(ieFlowStartMilliseconds):    uint64(msg.Start.UnixMilli()),
+		fmt.Sprint(ieFlowEndMilliseconds):      uint64(msg.End.UnixMilli()),
+	}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("records (-want +got):\n%s", diff)
+	}
+}
@@ -57,7 +57,7 @@ func (noopDevice) SetConnectionCounter(netlogfunc.ConnectionCounter) {}
 // unless the Tailnet administrator opts-into explicit logging.
 // The zero value is ready for use.
 type Logger struct {
-	mu   syncs.Mutex // protects all fields below
+	mu   syncs.Mutex // protects all fields below, except sink
 	logf logger.Logf
 
 	// shutdownLocked shuts down the logger.
@@ -78,6 +78,12 @@ type Logger struct {
 	// These are read-only once updated by ReconfigRoutes.
 	routeAddrs    set.Set[netip.Addr]
 	routePrefixes []netip.Prefix
+
+	// sink, if non-nil, is called with every recorded message.
+	// It's not protected by mu, since it's called by the recorder
+	// goroutine that shutdownLocked waits on.
+	// It is set by SetSink and persists across Startup and Shutdown.
+	sink syncs.AtomicValue[func(*netlogtype.Message)]
 }
 
 // Running reports whether the logger is running.
@@ -114,6 +120,9 @@ var testClient *http.Client
 // The IP protocol and source port are always zero.
 // The sock is used to populated the PhysicalTraffic field in [netlogtype.Message].
 //
+// If nodeLogID is the zero value, messages are not uploaded,
+// and are only passed to the function set by [Logger.SetSink].
+//
 // The netMon parameter is optional; if non-nil it's used to do faster interface lookups.
 func (nl *Logger) Startup(logf logger.Logf, nm *netmap.NetworkMap, nodeLogID, domainLogID logid.PrivateID, tun, sock Device, netMon *netmon.Monitor, health *health.Tracker, bus *eventbus.Bus, logExitFlowEnabledEnabled bool) error {
 	nl.mu.Lock()
@@ -128,25 +137,28 @@ func (nl *Logger) Startup(logf logger.Logf, nm *netmap.NetworkMap, nodeLogID, do
 	if logf == nil {
 		logf = log.Printf
 	}
-	httpc := &http.Client{Transport: logpolicy.NewLogtailTransport(logtail.DefaultHost, netMon, health, logf)}
-	if testClient != nil {
-		httpc = testClient
+	var logger *logtail.Logger
+	if !nodeLogID.IsZero() {
+		httpc := &http.Client{Transport: logpolicy.NewLogtailTransport(logtail.DefaultHost, netMon, health, logf)}
+		if testClient != nil {
+			httpc = testClient
+		}
+		logger = logtail.NewLogger(logtail.Config{
+			Collection:    "tailtraffic.log.tailscale.io",
+			PrivateID:     nodeLogID,
+			CopyPrivateID: domainLogID,
+			Bus:           bus,
+			Stderr:        io.Discard,
+			CompressLogs:  true,
+			HTTPC:         httpc,
+			// TODO(joetsai): Set Buffer? Use an in-memory buffer for now.
+
+			// Include process sequence numbers to identify missing samples.
+			IncludeProcID:       true,
+			IncludeProcSequence: true,
+		}, logf)
+		logger.SetSockstatsLabel(sockstats.LabelNetlogLogger)
 	}
-	logger := logtail.NewLogger(logtail.Config{
-		Collection:    "tailtraffic.log.tailscale.io",
-		PrivateID:     nodeLogID,
-		CopyPrivateID: domainLogID,
-		Bus:           bus,
-		Stderr:        io.Discard,
-		CompressLogs:  true,
-		HTTPC:         httpc,
-		// TODO(joetsai): Set Buffer? Use an in-memory buffer for now.
-
-		// Include process sequence numbers to identify missing samples.
-		IncludeProcID:       true,
-		IncludeProcSequence: true,
-	}, logf)
-	logger.SetSockstatsLabel(sockstats.LabelNetlogLogger)
 
 	// Register the connection tracker into the TUN device.
 	tun = cmp.Or[Device](tun, noopDevice{})
@@ -167,6 +179,12 @@ func (nl *Logger) Startup(logf logger.Logf, nm *netmap.NetworkMap, nodeLogID, do
 		defer close(recorderDone)
 		for rec := range recordsChan {
 			msg := rec.toMessage(false, !logExitFlowEnabledEnabled)
+			if sink := nl.sink.Load(); sink != nil {
+				sink(&msg)
+			}
+			if logger == nil {
+				continue
+			}
 			if b, err := jsonv2.Marshal(msg, jsontext.AllowInvalidUTF8(true)); err != nil {
 				if nl.logf != nil {
 					nl.logf("netlog: json.Marshal error: %v", err)
@@ -190,7 +208,10 @@ func (nl *Logger) Startup(logf logger.Logf, nm *netmap.NetworkMap, nodeLogID, do
 		recorderDone = nil
 
 		// Try to upload all pending records.
-		err := logger.Shutdown(ctx)
+		var err error
+		if logger != nil {
+			err = logger.Shutdown(ctx)
+		}
 
 		// Purge state.
 		nl.shutdownLocked = nil
@@ -205,6 +226,19 @@ func (nl *Logger) Startup(logf logger.Logf, nm *netmap.NetworkMap, nodeLogID, do
 	return nil
 }
 
+// SetSink sets a function to be called, from a single goroutine, with each
+// message that the logger records while running, whether or not it's
+// uploaded. The message must not be retained or modified after the function
+// returns. A nil fn removes the sink.
+func (nl *Logger) SetSink(fn func(*netlogtype.Message)) {
+	nl.sink.Store(fn)
+}
+
+// HasSink reports whether a sink has been set by [Logger.SetSink].
+func (nl *Logger) HasSink() bool {
+	return nl.sink.Load() != nil
+}
+
 var (
 	tailscaleServiceIPv4 = tsaddr.TailscaleServiceIP()
 	tailscaleServiceIPv6 = tsaddr.TailscaleServiceIPv6()
@@ -12,3 +12,4 @@ func (*Logger) Running() bool          { return false }
 func (*Logger) Shutdown(any) error     { return nil }
 func (*Logger) ReconfigNetworkMap(any) {}
 func (*Logger) ReconfigRoutes(any)     {}
+func (*Logger) HasSink() bool          { return false }
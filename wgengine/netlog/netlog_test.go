@@ -20,6 +20,7 @@ import (
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/bools"
 	"tailscale.com/types/ipproto"
+	"tailscale.com/types/logid"
 	"tailscale.com/types/netlogtype"
 	"tailscale.com/types/netmap"
 	"tailscale.com/wgengine/router"
@@ -220,6 +221,34 @@ func TestAutoFlushTimeout(t *testing.T) {
 	}
 }
 
+func TestLocalOnlySink(t *testing.T) {
+	var got []netlogtype.Message
+	var logger Logger
+	logger.SetSink(func(msg *netlogtype.Message) {
+		got = append(got, *msg)
+	})
+	nm := &netmap.NetworkMap{
+		SelfNode: (&tailcfg.Node{
+			StableID:  "n123456CNTL",
+			Addresses: []netip.Prefix{prefix("100.1.2.3")},
+		}).View(),
+	}
+	// With no log IDs, nothing is uploaded.
+	if err := logger.Startup(t.Logf, nm, logid.PrivateID{}, logid.PrivateID{}, nil, nil, nil, nil, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	logger.updateVirtConn(ipproto.TCP, addrPort("100.1.2.3:80"), addrPort("100.1.2.4:1812"), 1, 100, false)
+	if err := logger.Shutdown(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("sink got %d messages, want 1", len(got))
+	}
+	if got[0].NodeID != "n123456CNTL" || len(got[0].ExitTraffic) != 1 {
+		t.Errorf("sink got %+v", got[0])
+	}
+}
+
 func BenchmarkUpdateSameConn(b *testing.B) {
 	var logger Logger
 	b.ReportAllocs()
@@ -0,0 +1,52 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !ts_omit_netlog && !ts_omit_logtail
+
+package wgengine
+
+import (
+	"context"
+
+	"tailscale.com/types/netlogtype"
+	"tailscale.com/wgengine/router"
+)
+
+// NetLogSinkSetter is implemented by engines that can send network flow
+// log messages to a local sink.
+type NetLogSinkSetter interface {
+	// SetNetLogSink sets a function to be called with each network flow
+	// log message. While a sink is set, network flows are recorded even if
+	// network flow logging isn't enabled for the tailnet, in which case
+	// they're not uploaded. The sink is removed by calling this function
+	// with nil.
+	SetNetLogSink(func(*netlogtype.Message))
+}
+
+var _ NetLogSinkSetter = (*userspaceEngine)(nil)
+
+// SetNetLogSink implements [NetLogSinkSetter].
+func (e *userspaceEngine) SetNetLogSink(fn func(*netlogtype.Message)) {
+	e.wgLock.Lock()
+	defer e.wgLock.Unlock()
+	e.networkLogger.SetSink(fn)
+
+	// Start or stop the network logger if it was or is now only running
+	// for the sink. Otherwise, leave it to Reconfig.
+	routerCfg := e.lastRouter
+	upload := netLogUpload(&e.lastCfgFull)
+	switch {
+	case fn != nil && !e.networkLogger.Running() && routerCfg != nil && !routerCfg.Equal(&router.Config{}):
+		e.mu.Lock()
+		nm := e.netMap
+		e.mu.Unlock()
+		e.startNetLogLocked(&e.lastCfgFull, routerCfg, nm)
+	case fn == nil && !upload && e.networkLogger.Running():
+		e.logf("wgengine: shutting down local network logger")
+		ctx, cancel := context.WithTimeout(context.Background(), networkLoggerUploadTimeout)
+		defer cancel()
+		if err := e.networkLogger.Shutdown(ctx); err != nil {
+			e.logf("wgengine: error shutting down network logger: %v", err)
+		}
+	}
+}
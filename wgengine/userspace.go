@@ -46,6 +46,7 @@ import (
 	"tailscale.com/types/ipproto"
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
+	"tailscale.com/types/logid"
 	"tailscale.com/types/netmap"
 	"tailscale.com/types/views"
 	"tailscale.com/util/backoff"
@@ -1098,9 +1099,11 @@ func (e *userspaceEngine) Reconfig(cfg *wgcfg.Config, routerCfg *router.Config,
 	oldLogIDs := e.lastCfgFull.NetworkLogging
 	netLogIDsNowValid := !newLogIDs.NodeID.IsZero() && !newLogIDs.DomainID.IsZero()
 	netLogIDsWasValid := !oldLogIDs.NodeID.IsZero() && !oldLogIDs.DomainID.IsZero()
-	netLogIDsChanged := netLogIDsNowValid && netLogIDsWasValid && newLogIDs != oldLogIDs
-	netLogRunning := netLogIDsNowValid && !routerCfg.Equal(&router.Config{})
-	if !buildfeatures.HasNetLog || envknob.NoLogsNoSupport() {
+	// A logger running only for a local sink (see SetNetLogSink) must also
+	// be restarted when uploading starts or stops.
+	netLogIDsChanged := newLogIDs != oldLogIDs && (netLogIDsNowValid && netLogIDsWasValid || e.networkLogger.HasSink())
+	netLogRunning := (netLogUpload(cfg) || e.networkLogger.HasSink()) && !routerCfg.Equal(&router.Config{})
+	if !buildfeatures.HasNetLog {
 		netLogRunning = false
 	}
 
@@ -1193,14 +1196,7 @@ func (e *userspaceEngine) Reconfig(cfg *wgcfg.Config, routerCfg *router.Config,
 	// Startup the network logger.
 	// Do this before configuring the router so that we capture initial packets.
 	if buildfeatures.HasNetLog && netLogRunning && !e.networkLogger.Running() {
-		nid := cfg.NetworkLogging.NodeID
-		tid := cfg.NetworkLogging.DomainID
-		logExitFlowEnabled := cfg.NetworkLogging.LogExitFlowEnabled
-		e.logf("wgengine: Reconfig: starting up network logger (node:%s tailnet:%s)", nid.Public(), tid.Public())
-		if err := e.networkLogger.Startup(e.logf, nm, nid, tid, e.tundev, e.magicConn, e.netMon, e.health, e.eventBus, logExitFlowEnabled); err != nil {
-			e.logf("wgengine: Reconfig: error starting up network logger: %v", err)
-		}
-		e.networkLogger.ReconfigRoutes(routerCfg)
+		e.startNetLogLocked(cfg, routerCfg, nm)
 	}
 
 	if routerChanged {
@@ -1276,6 +1272,32 @@ func (e *userspaceEngine) Reconfig(cfg *wgcfg.Config, routerCfg *router.Config,
 	return nil
 }
 
+// netLogUpload reports whether cfg enables uploading network flow logs.
+func netLogUpload(cfg *wgcfg.Config) bool {
+	ids := cfg.NetworkLogging
+	return !ids.NodeID.IsZero() && !ids.DomainID.IsZero() && !envknob.NoLogsNoSupport()
+}
+
+// startNetLogLocked starts the network logger, uploading to the IDs in
+// cfg if it enables uploading.
+//
+// e.wgLock must be held.
+func (e *userspaceEngine) startNetLogLocked(cfg *wgcfg.Config, routerCfg *router.Config, nm *netmap.NetworkMap) {
+	var nid, tid logid.PrivateID
+	if netLogUpload(cfg) {
+		nid = cfg.NetworkLogging.NodeID
+		tid = cfg.NetworkLogging.DomainID
+		e.logf("wgengine: Reconfig: starting up network logger (node:%s tailnet:%s)", nid.Public(), tid.Public())
+	} else {
+		e.logf("wgengine: Reconfig: starting up network logger (local only)")
+	}
+	logExitFlowEnabled := cfg.NetworkLogging.LogExitFlowEnabled
+	if err := e.networkLogger.Startup(e.logf, nm, nid, tid, e.tundev, e.magicConn, e.netMon, e.health, e.eventBus, logExitFlowEnabled); err != nil {
+		e.logf("wgengine: Reconfig: error starting up network logger: %v", err)
+	}
+	e.networkLogger.ReconfigRoutes(routerCfg)
+}
+
 func (e *userspaceEngine) GetFilter() *filter.Filter {
 	return e.tundev.GetFilter()
 }
@@ -0,0 +1,15 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !js && !ts_omit_debug && !ts_omit_netlog && !ts_omit_logtail
+
+package wgengine
+
+import "tailscale.com/types/netlogtype"
+
+// SetNetLogSink implements [NetLogSinkSetter] if the wrapped engine does.
+func (e *watchdogEngine) SetNetLogSink(fn func(*netlogtype.Message)) {
+	if s, ok := e.wrap.(NetLogSinkSetter); ok {
+		s.SetNetLogSink(fn)
+	}
+}